@@ -0,0 +1,485 @@
+package adblock
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// CompactFileName 编译产物在 cache_dir 中的文件名
+const CompactFileName = "rules.compact"
+
+// 编译文件格式（小端）：
+//
+//	magic[8] | 条目数 u32 | 指纹长度 u32 | 回退规则长度 u32 | 指纹 | 回退规则文本 | 偏移表 (n+1)×u32 | 条目
+//
+// 条目为反转后的域名（"com.example.ads"）加一个标志字节，按字节序排序
+var compactMagic = [8]byte{'S', 'D', 'S', 'C', 'M', 'P', 'T', 1}
+
+const compactHeaderSize = 20
+
+// 条目标志
+const (
+	compactBlockExact  byte = 1 << iota // 纯域名与 hosts 规则：只匹配域名自身
+	compactBlockSuffix                  // ||example.com^：匹配自身及子域名
+	compactAllowSuffix                  // @@||example.com^
+)
+
+// compiledEngine 能把规则编译为磁盘文件、并在规则来源未变化时直接加载该文件的引擎
+type compiledEngine interface {
+	FilterEngine
+	// LoadCompiled 加载指纹一致的编译文件，文件不存在、损坏或指纹不一致时返回 false
+	LoadCompiled(fingerprint string) bool
+	// Compile 编译规则写入磁盘并加载，fingerprint 标识规则来源的状态
+	Compile(rules []string, fingerprint string) error
+}
+
+// unsupportedRuleCounter 能报告已加载但永远不会生效的规则数的引擎
+// 目前只有按客户端名称匹配的 $client 规则（请求不携带客户端名称）
+type unsupportedRuleCounter interface {
+	UnsupportedRules() int
+}
+
+// CompactEngine 面向百万级规则列表的紧凑引擎
+// 纯域名、拦截型 hosts 与 ||domain^、@@||domain^ 规则编译为按反转域名排序的只读表，
+// 写入 cache_dir 后以 mmap 加载，查询时按标签逐级二分查找；
+// 正则、通配符与带修饰符的规则数量通常很少，交给 urlfilter 引擎处理
+type CompactEngine struct {
+	dir      string
+	mu       sync.RWMutex
+	table    *compactTable
+	fallback *URLFilterEngine
+}
+
+// NewCompactEngine creates a compact engine that keeps its compiled file in dir.
+func NewCompactEngine(dir string) *CompactEngine {
+	return &CompactEngine{dir: dir}
+}
+
+// LoadRules 在内存中构建规则表，不写入磁盘（用于定时规则集等小规模规则）
+func (e *CompactEngine) LoadRules(rules []string) error {
+	data, err := buildCompactTable(rules, "")
+	if err != nil {
+		return err
+	}
+	return e.swap(data, nil)
+}
+
+// Compile 构建规则表写入临时文件，映射后再原子地替换为正式文件
+// 映射的是刚写入的文件本身，并发编译时不会加载到其他实例的结果
+func (e *CompactEngine) Compile(rules []string, fingerprint string) error {
+	data, err := buildCompactTable(rules, fingerprint)
+	if err != nil {
+		return err
+	}
+	if e.dir == "" {
+		return e.swap(data, nil)
+	}
+
+	f, err := os.CreateTemp(e.dir, CompactFileName+".*.tmp")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	defer os.Remove(tmp) // rename 成功后为空操作
+	defer f.Close()
+
+	if _, err := f.Write(data); err != nil {
+		return err
+	}
+	data = nil // 释放构建缓冲区，之后只保留映射
+	mapped, unmap, err := mapFile(f)
+	if err != nil {
+		return err
+	}
+	f.Close() // 映射不依赖文件句柄；部分平台不能重命名仍被打开的文件
+	if err := os.Rename(tmp, filepath.Join(e.dir, CompactFileName)); err != nil {
+		unmap()
+		return err
+	}
+	return e.swap(mapped, unmap)
+}
+
+// LoadCompiled implements compiledEngine.
+func (e *CompactEngine) LoadCompiled(fingerprint string) bool {
+	if e.dir == "" || fingerprint == "" {
+		return false
+	}
+	f, err := os.Open(filepath.Join(e.dir, CompactFileName))
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	mapped, unmap, err := mapFile(f)
+	if err != nil {
+		return false
+	}
+	t, err := parseCompactTable(mapped)
+	if err != nil || t.fingerprint != fingerprint {
+		unmap()
+		return false
+	}
+	return e.swap(mapped, unmap) == nil
+}
+
+// swap 解析新表与回退规则后替换当前状态，并释放旧的映射
+func (e *CompactEngine) swap(data []byte, unmap func() error) error {
+	t, err := parseCompactTable(data)
+	if err != nil {
+		if unmap != nil {
+			unmap()
+		}
+		return err
+	}
+	t.unmap = unmap
+
+	var fallback *URLFilterEngine
+	if len(t.fallbackRules) > 0 {
+		fallback, _ = NewURLFilterEngine()
+		if err := fallback.LoadRules(t.fallbackRules); err != nil {
+			t.close()
+			return err
+		}
+	}
+
+	e.mu.Lock()
+	old := e.table
+	e.table, e.fallback = t, fallback
+	e.mu.Unlock()
+	old.close()
+	return nil
+}
+
+// CheckHost implements the FilterEngine interface.
+func (e *CompactEngine) CheckHost(domain string) (MatchResult, string) {
+	res := e.Check(&Request{Host: domain})
+	return res.Match, res.Rule
+}
+
+// Check implements the FilterEngine interface.
+// 回退规则中的放行、改写与 $important 拦截优先，其次是编译表中的放行与拦截，最后是回退规则的普通拦截
+func (e *CompactEngine) Check(req *Request) Result {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	var fb Result
+	if e.fallback != nil {
+		fb = e.fallback.Check(req)
+		if fb.Rewrite != nil || fb.Match == MatchAllowed || (fb.Match == MatchBlocked && hasModifier(fb.Rule, "important")) {
+			return fb
+		}
+	}
+	result := Result{Cacheable: e.fallback == nil || !e.fallback.contextual}
+
+	if match, rule := e.table.match(req.Host); match != MatchNeutral {
+		result.Match, result.Rule = match, rule
+		return result
+	}
+	if fb.Match == MatchBlocked {
+		result.Match, result.Rule = fb.Match, fb.Rule
+	}
+	return result
+}
+
+// Count implements the FilterEngine interface.
+func (e *CompactEngine) Count() int {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	n := 0
+	if e.table != nil {
+		n = e.table.n
+	}
+	if e.fallback != nil {
+		n += e.fallback.Count()
+	}
+	return n
+}
+
+// UnsupportedRules 实现 unsupportedRuleCounter 接口，上下文相关规则都在回退引擎中
+func (e *CompactEngine) UnsupportedRules() int {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	if e.fallback == nil {
+		return 0
+	}
+	return e.fallback.UnsupportedRules()
+}
+
+// Close 释放映射；之后的查询均为中性结果
+func (e *CompactEngine) Close() error {
+	e.mu.Lock()
+	old := e.table
+	e.table, e.fallback = nil, nil
+	e.mu.Unlock()
+	return old.close()
+}
+
+// compactTable 编译表的只读视图，切片均指向 data（映射内存或内存副本）
+type compactTable struct {
+	n             int
+	fingerprint   string
+	fallbackRules []string
+	offsets       []byte
+	entries       []byte
+	unmap         func() error
+}
+
+func parseCompactTable(data []byte) (*compactTable, error) {
+	if len(data) < compactHeaderSize || !bytes.Equal(data[:8], compactMagic[:]) {
+		return nil, fmt.Errorf("invalid compact rule file")
+	}
+	n := int(binary.LittleEndian.Uint32(data[8:]))
+	fpLen := int(binary.LittleEndian.Uint32(data[12:]))
+	fbLen := int(binary.LittleEndian.Uint32(data[16:]))
+
+	pos := compactHeaderSize
+	if fpLen+fbLen+(n+1)*4 > len(data)-pos {
+		return nil, fmt.Errorf("truncated compact rule file")
+	}
+	t := &compactTable{n: n, fingerprint: string(data[pos : pos+fpLen])}
+	pos += fpLen
+	if fbLen > 0 {
+		t.fallbackRules = strings.Split(string(data[pos:pos+fbLen]), "\n")
+	}
+	pos += fbLen
+	t.offsets = data[pos : pos+(n+1)*4]
+	t.entries = data[pos+(n+1)*4:]
+	if int(binary.LittleEndian.Uint32(t.offsets[n*4:])) != len(t.entries) {
+		return nil, fmt.Errorf("truncated compact rule file")
+	}
+	// 逐个校验偏移：从 0 开始严格递增（每个条目至少含标志字节）且不越界，
+	// 损坏的文件在加载时被拒绝并重新编译，而不是在查询路径上越界
+	prev := uint32(0)
+	if binary.LittleEndian.Uint32(t.offsets) != 0 {
+		return nil, fmt.Errorf("corrupted compact rule file: first offset is not 0")
+	}
+	for i := 1; i <= n; i++ {
+		off := binary.LittleEndian.Uint32(t.offsets[i*4:])
+		if off <= prev || int(off) > len(t.entries) {
+			return nil, fmt.Errorf("corrupted compact rule file: invalid offset for entry %d", i-1)
+		}
+		prev = off
+	}
+	return t, nil
+}
+
+func (t *compactTable) close() error {
+	if t == nil || t.unmap == nil {
+		return nil
+	}
+	return t.unmap()
+}
+
+// entry 返回第 i 个条目的键与标志
+func (t *compactTable) entry(i int) ([]byte, byte) {
+	start := binary.LittleEndian.Uint32(t.offsets[i*4:])
+	end := binary.LittleEndian.Uint32(t.offsets[(i+1)*4:])
+	return t.entries[start : end-1], t.entries[end-1]
+}
+
+func (t *compactTable) lookup(key []byte) byte {
+	lo, hi := 0, t.n
+	for lo < hi {
+		mid := int(uint(lo+hi) >> 1)
+		k, flags := t.entry(mid)
+		switch c := bytes.Compare(k, key); {
+		case c == 0:
+			return flags
+		case c < 0:
+			lo = mid + 1
+		default:
+			hi = mid
+		}
+	}
+	return 0
+}
+
+// match 从顶级域开始逐级查找域名自身及其各级父域，放行规则优先于拦截规则
+func (t *compactTable) match(domain string) (MatchResult, string) {
+	if t == nil || t.n == 0 {
+		return MatchNeutral, ""
+	}
+	key := []byte(reverseLabels(strings.ToLower(strings.TrimSuffix(domain, "."))))
+
+	var blocked string
+	for i := 0; i <= len(key); i++ {
+		if i < len(key) && key[i] != '.' {
+			continue
+		}
+		flags := t.lookup(key[:i])
+		if flags == 0 {
+			continue
+		}
+		name := reverseLabels(string(key[:i]))
+		if flags&compactAllowSuffix != 0 {
+			return MatchAllowed, "@@||" + name + "^"
+		}
+		if blocked != "" {
+			continue
+		}
+		if flags&compactBlockSuffix != 0 {
+			blocked = "||" + name + "^"
+		} else if flags&compactBlockExact != 0 && i == len(key) {
+			blocked = name
+		}
+	}
+	if blocked != "" {
+		return MatchBlocked, blocked
+	}
+	return MatchNeutral, ""
+}
+
+// reverseLabels 颠倒域名标签顺序："ads.example.com" -> "com.example.ads"
+func reverseLabels(domain string) string {
+	labels := strings.Split(domain, ".")
+	slices.Reverse(labels)
+	return strings.Join(labels, ".")
+}
+
+// compactBuilder 把全部键连续存放在一个缓冲区中，避免为数百万条规则分别分配字符串
+type compactBuilder struct {
+	buf      []byte
+	entries  []compactBuildEntry
+	fallback []string
+}
+
+type compactBuildEntry struct {
+	off   uint32
+	len   uint16
+	flags byte
+}
+
+func (b *compactBuilder) key(e compactBuildEntry) []byte {
+	return b.buf[e.off : e.off+uint32(e.len)]
+}
+
+func (b *compactBuilder) add(domain string, flags byte) {
+	domain = strings.ToLower(domain)
+	off := len(b.buf)
+	labels := strings.Split(domain, ".")
+	for i := len(labels) - 1; i >= 0; i-- {
+		b.buf = append(b.buf, labels[i]...)
+		if i > 0 {
+			b.buf = append(b.buf, '.')
+		}
+	}
+	b.entries = append(b.entries, compactBuildEntry{off: uint32(off), len: uint16(len(domain)), flags: flags})
+}
+
+// addRule 解析一条规则，能编译进表的加入表中，其余留给回退引擎
+func (b *compactBuilder) addRule(rule string) {
+	rule = strings.TrimSpace(rule)
+	if rule == "" || strings.HasPrefix(rule, "!") || strings.HasPrefix(rule, "#") {
+		return
+	}
+
+	switch {
+	case strings.HasPrefix(rule, "@@||") && strings.HasSuffix(rule, "^"):
+		if d := rule[4 : len(rule)-1]; isCompactDomain(d) {
+			b.add(d, compactAllowSuffix)
+			return
+		}
+	case strings.HasPrefix(rule, "||") && strings.HasSuffix(rule, "^"):
+		if d := rule[2 : len(rule)-1]; isCompactDomain(d) {
+			b.add(d, compactBlockSuffix)
+			return
+		}
+	case strings.ContainsAny(rule, " \t"):
+		fields := strings.Fields(rule)
+		if !isBlockingHostsIP(fields[0]) {
+			break // 指向真实地址的 hosts 规则是改写，由回退引擎生成应答
+		}
+		for _, d := range fields[1:] {
+			if strings.HasPrefix(d, "#") {
+				break
+			}
+			// 单标签名称（localhost、broadcasthost 等）是 hosts 文件的固定条目，不作为拦截规则
+			if isCompactDomain(d) && strings.Contains(d, ".") {
+				b.add(d, compactBlockExact)
+			}
+		}
+		return
+	default:
+		if isCompactDomain(rule) {
+			b.add(rule, compactBlockExact)
+			return
+		}
+	}
+	b.fallback = append(b.fallback, rule)
+}
+
+func isBlockingHostsIP(ip string) bool {
+	switch ip {
+	case "0.0.0.0", "127.0.0.1", "::", "::1":
+		return true
+	}
+	return false
+}
+
+// isCompactDomain 判断是否为可直接编译的域名（不含通配符、修饰符等特殊字符）
+func isCompactDomain(d string) bool {
+	if d == "" || len(d) > 253 || d[0] == '.' || d[len(d)-1] == '.' || strings.Contains(d, "..") {
+		return false
+	}
+	for i := 0; i < len(d); i++ {
+		c := d[i]
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '.') {
+			return false
+		}
+	}
+	return true
+}
+
+// buildCompactTable 解析规则并序列化为编译文件格式，同一域名的多条规则合并标志
+func buildCompactTable(rules []string, fingerprint string) ([]byte, error) {
+	b := &compactBuilder{}
+	for _, rule := range rules {
+		b.addRule(rule)
+	}
+
+	slices.SortFunc(b.entries, func(x, y compactBuildEntry) int {
+		return bytes.Compare(b.key(x), b.key(y))
+	})
+	merged := b.entries[:0]
+	for _, e := range b.entries {
+		if n := len(merged); n > 0 && bytes.Equal(b.key(merged[n-1]), b.key(e)) {
+			merged[n-1].flags |= e.flags
+			continue
+		}
+		merged = append(merged, e)
+	}
+	fallback := strings.Join(b.fallback, "\n")
+	size := compactHeaderSize + len(fingerprint) + len(fallback) + (len(merged)+1)*4
+	for _, e := range merged {
+		size += int(e.len) + 1
+	}
+	if uint64(size) > math.MaxUint32 {
+		return nil, fmt.Errorf("compact rule file too large: %d bytes", size)
+	}
+
+	data := make([]byte, 0, size)
+	data = append(data, compactMagic[:]...)
+	data = binary.LittleEndian.AppendUint32(data, uint32(len(merged)))
+	data = binary.LittleEndian.AppendUint32(data, uint32(len(fingerprint)))
+	data = binary.LittleEndian.AppendUint32(data, uint32(len(fallback)))
+	data = append(data, fingerprint...)
+	data = append(data, fallback...)
+	var off uint32
+	for _, e := range merged {
+		data = binary.LittleEndian.AppendUint32(data, off)
+		off += uint32(e.len) + 1
+	}
+	data = binary.LittleEndian.AppendUint32(data, off)
+	for _, e := range merged {
+		data = append(data, b.key(e)...)
+		data = append(data, e.flags)
+	}
+	return data, nil
+}
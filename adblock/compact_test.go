@@ -0,0 +1,192 @@
+package adblock
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"smartdnssort/config"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+var testCompactRules = []string{
+	"! comment",
+	"||ads.example.com^",
+	"@@||ok.ads.example.com^",
+	"tracker.example.net",
+	"0.0.0.0 hosts.example.org other.example.org",
+	"127.0.0.1 localhost",
+	"192.0.2.1 mapped.example.org",
+	"/^banner[0-9]+\\.example\\.io$/",
+	"||video.example.com^$dnstype=AAAA",
+	"||Upper.Example.COM^",
+	"||ads.example.com^",
+}
+
+func TestCompactEngineMatch(t *testing.T) {
+	e := NewCompactEngine("")
+	if err := e.LoadRules(testCompactRules); err != nil {
+		t.Fatalf("LoadRules: %v", err)
+	}
+	defer e.Close()
+
+	tests := []struct {
+		host  string
+		match MatchResult
+		rule  string
+	}{
+		{"ads.example.com", MatchBlocked, "||ads.example.com^"},
+		{"x.y.ads.example.com.", MatchBlocked, "||ads.example.com^"},
+		{"ok.ads.example.com", MatchAllowed, "@@||ok.ads.example.com^"},
+		{"a.ok.ads.example.com", MatchAllowed, "@@||ok.ads.example.com^"},
+		{"notads.example.com", MatchNeutral, ""},
+		{"tracker.example.net", MatchBlocked, "tracker.example.net"},
+		{"sub.tracker.example.net", MatchNeutral, ""}, // 纯域名只匹配自身
+		{"other.example.org", MatchBlocked, "other.example.org"},
+		{"localhost", MatchNeutral, ""},
+		{"banner12.example.io", MatchBlocked, "/^banner[0-9]+\\.example\\.io$/"},
+		{"UPPER.example.com", MatchBlocked, "||upper.example.com^"},
+	}
+	for _, tt := range tests {
+		match, rule := e.CheckHost(tt.host)
+		if match != tt.match || rule != tt.rule {
+			t.Errorf("CheckHost(%q) = %v %q, want %v %q", tt.host, match, rule, tt.match, tt.rule)
+		}
+	}
+
+	// 回退规则保留 urlfilter 的修饰符与 hosts 改写语义
+	if res := e.Check(&Request{Host: "video.example.com", QType: dns.TypeAAAA}); res.Match != MatchBlocked || res.Cacheable {
+		t.Errorf("$dnstype rule should block AAAA and be non-cacheable, got %+v", res)
+	}
+	if res := e.Check(&Request{Host: "video.example.com", QType: dns.TypeA}); res.Match != MatchNeutral {
+		t.Errorf("$dnstype rule should not block A, got %+v", res)
+	}
+	if res := e.Check(&Request{Host: "mapped.example.org", QType: dns.TypeA}); res.Rewrite == nil || len(res.Rewrite.Answer) != 1 {
+		t.Errorf("hosts rule with a real address should rewrite, got %+v", res)
+	}
+
+	// 6 个域名条目（重复规则合并，localhost 被忽略）+ 3 条回退规则
+	if got := e.Count(); got != 6+3 {
+		t.Errorf("Count() = %d, want 9", got)
+	}
+}
+
+func TestCompactEngineCompiledFile(t *testing.T) {
+	dir := t.TempDir()
+	e := NewCompactEngine(dir)
+	if err := e.Compile(testCompactRules, "fp-1"); err != nil {
+		t.Fatalf("Compile: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, CompactFileName)); err != nil {
+		t.Fatalf("compiled file should be written: %v", err)
+	}
+
+	loaded := NewCompactEngine(dir)
+	if loaded.LoadCompiled("fp-2") {
+		t.Fatal("fingerprint mismatch must not load the compiled file")
+	}
+	if !loaded.LoadCompiled("fp-1") {
+		t.Fatal("matching fingerprint should load the compiled file")
+	}
+	if match, _ := loaded.CheckHost("x.ads.example.com"); match != MatchBlocked {
+		t.Error("loaded table should match the compiled rules")
+	}
+	if got, want := loaded.Count(), e.Count(); got != want {
+		t.Errorf("loaded Count() = %d, want %d", got, want)
+	}
+
+	// 重新编译替换文件，已加载的实例仍使用原来的映射
+	if err := e.Compile([]string{"||new.example^"}, "fp-3"); err != nil {
+		t.Fatalf("recompile: %v", err)
+	}
+	if match, _ := loaded.CheckHost("ads.example.com"); match != MatchBlocked {
+		t.Error("existing mapping should survive recompilation")
+	}
+	if match, _ := e.CheckHost("ads.example.com"); match != MatchNeutral {
+		t.Error("recompiled engine should use the new rules")
+	}
+
+	loaded.Close()
+	e.Close()
+	if match, _ := e.CheckHost("new.example"); match != MatchNeutral {
+		t.Error("closed engine should be neutral")
+	}
+}
+
+func TestCompactEngineManagerReusesCompiledFile(t *testing.T) {
+	dir := t.TempDir()
+	rulesFile := filepath.Join(dir, "rules.txt")
+	var content []byte
+	for i := 0; i < 200; i++ {
+		content = fmt.Appendf(content, "||ad%d.example.com^\n", i)
+	}
+	if err := os.WriteFile(rulesFile, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &config.AdBlockConfig{Enable: true, Engine: "compact", CacheDir: dir, RuleURLs: []string{rulesFile}}
+	m, err := NewManager(cfg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.LoadRulesFromCache(); err != nil {
+		t.Fatalf("LoadRulesFromCache: %v", err)
+	}
+	if match, _ := m.CheckHost("x.ad7.example.com"); match != MatchBlocked {
+		t.Fatal("compact engine should block listed domains")
+	}
+	info, err := os.Stat(filepath.Join(dir, CompactFileName))
+	if err != nil {
+		t.Fatalf("compiled file should exist: %v", err)
+	}
+
+	// 规则源未变化时直接复用编译文件
+	m2, _ := NewManager(cfg, nil)
+	if err := m2.LoadRulesFromCache(); err != nil {
+		t.Fatalf("second LoadRulesFromCache: %v", err)
+	}
+	if info2, _ := os.Stat(filepath.Join(dir, CompactFileName)); !info2.ModTime().Equal(info.ModTime()) {
+		t.Error("unchanged sources should reuse the compiled file")
+	}
+	if match, _ := m2.CheckHost("ad199.example.com"); match != MatchBlocked {
+		t.Error("reused compiled file should block listed domains")
+	}
+}
+
+func TestCompactEngineRejectsCorruptedFile(t *testing.T) {
+	dir := t.TempDir()
+	e := NewCompactEngine(dir)
+	if err := e.Compile(testCompactRules, "fp-1"); err != nil {
+		t.Fatalf("Compile: %v", err)
+	}
+	e.Close()
+	path := filepath.Join(dir, CompactFileName)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parseCompactTable(data); err != nil {
+		t.Fatalf("compiled file should parse: %v", err)
+	}
+
+	// 破坏中间条目的偏移：末尾偏移仍然正确，但必须在加载时被拒绝
+	fpLen := int(binary.LittleEndian.Uint32(data[12:]))
+	fbLen := int(binary.LittleEndian.Uint32(data[16:]))
+	offsets := compactHeaderSize + fpLen + fbLen
+	for _, bad := range []uint32{0xFFFFFF, 0} {
+		corrupted := bytes.Clone(data)
+		binary.LittleEndian.PutUint32(corrupted[offsets+4:], bad)
+		if _, err := parseCompactTable(corrupted); err == nil {
+			t.Errorf("offset %#x should be rejected", bad)
+		}
+		if err := os.WriteFile(path, corrupted, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if NewCompactEngine(dir).LoadCompiled("fp-1") {
+			t.Errorf("corrupted file with offset %#x should not be loaded", bad)
+		}
+	}
+}
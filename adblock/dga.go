@@ -0,0 +1,482 @@
+package adblock
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"slices"
+	"smartdnssort/config"
+	"smartdnssort/logger"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// DGA 检测模式
+const (
+	DGAModeBlock = "block" // 拦截超过阈值的域名
+	DGAModeAlert = "alert" // 只记录与告警，照常解析
+	DGAModeLearn = "learn" // 用成功解析的域名训练 n-gram 模型，只记录不告警
+)
+
+const (
+	// DGAModelFileName 学习模式累积的 bigram 计数文件
+	DGAModelFileName = "dga_model.json"
+
+	dgaDefaultThreshold   = 0.65
+	dgaDefaultMinLength   = 8
+	dgaDefaultBurstWindow = 60
+	dgaDefaultBurstCount  = 20
+
+	dgaMaxLength    = 24   // 标签长度达到该值时长度分量记满
+	dgaMaxClients   = 4096 // 跟踪 NXDOMAIN 突发的客户端上限
+	dgaMaxFlagged   = 500  // 保留的可疑域名上限
+	dgaBurstBuckets = 6
+	dgaSaveInterval = 10 * time.Minute // 学习模型的保存间隔
+
+	// bigram 字母表：a-z、0-9、'-' 与标签边界
+	dgaAlphabet = 38
+	dgaBoundary = dgaAlphabet - 1
+)
+
+//go:embed dga_corpus.txt
+var dgaCorpus string
+
+// dgaBaseModel 由内置语料构建的基准 bigram 计数，首次使用时构建
+var dgaBaseModel = sync.OnceValue(func() *bigramCounts {
+	c := &bigramCounts{}
+	for _, line := range strings.Split(dgaCorpus, "\n") {
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		for _, word := range strings.Fields(line) {
+			c.add(word)
+		}
+	}
+	return c
+})
+
+// bigramCounts 相邻字符对的出现次数，标签首尾与边界符号相连
+type bigramCounts struct {
+	Names  int64                            `json:"names"`
+	Counts [dgaAlphabet][dgaAlphabet]uint32 `json:"counts"`
+}
+
+func dgaSymbol(c byte) int {
+	switch {
+	case c >= 'a' && c <= 'z':
+		return int(c - 'a')
+	case c >= '0' && c <= '9':
+		return 26 + int(c-'0')
+	case c == '-':
+		return 36
+	}
+	return -1
+}
+
+func (b *bigramCounts) add(label string) {
+	prev := dgaBoundary
+	for i := 0; i < len(label); i++ {
+		sym := dgaSymbol(label[i])
+		if sym < 0 {
+			return
+		}
+		if b.Counts[prev][sym] < math.MaxUint32 {
+			b.Counts[prev][sym]++
+		}
+		prev = sym
+	}
+	b.Counts[prev][dgaBoundary]++
+	b.Names++
+}
+
+// DGAScore 单个域名的评分与各分量（0-1，越大越可疑）
+type DGAScore struct {
+	Domain     string  `json:"domain"`
+	Label      string  `json:"label"`       // 参与评分的可注册标签
+	Score      float64 `json:"score"`       // 综合评分
+	Entropy    float64 `json:"entropy"`     // 字符熵
+	NGram      float64 `json:"ngram"`       // bigram 不可能度
+	DigitRatio float64 `json:"digit_ratio"` // 数字占比
+	Length     float64 `json:"length"`      // 标签长度
+	Burst      float64 `json:"burst"`       // 客户端近期 NXDOMAIN 突发率
+}
+
+// DGAFlag 超过阈值的域名记录
+type DGAFlag struct {
+	DGAScore
+	Client    string    `json:"client"`
+	Action    string    `json:"action"` // blocked、alerted 或 learning
+	Count     int64     `json:"count"`
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+}
+
+// nxBurst 按时间分桶统计客户端的 NXDOMAIN 应答数
+type nxBurst struct {
+	slots  [dgaBurstBuckets]int64 // 桶对应的时间片编号
+	counts [dgaBurstBuckets]int
+}
+
+func (b *nxBurst) add(slot int64) {
+	i := slot % dgaBurstBuckets
+	if b.slots[i] != slot {
+		b.slots[i] = slot
+		b.counts[i] = 0
+	}
+	b.counts[i]++
+}
+
+func (b *nxBurst) total(slot int64) int {
+	n := 0
+	for i := range b.slots {
+		if b.slots[i] > slot-dgaBurstBuckets && b.slots[i] <= slot {
+			n += b.counts[i]
+		}
+	}
+	return n
+}
+
+// DGADetector 以字符熵、bigram 似然、数字占比、长度与客户端 NXDOMAIN 突发率给域名评分，
+// 识别尚未收录进规则列表的算法生成域名
+type DGADetector struct {
+	cfg       config.DGAConfig
+	modelPath string
+	now       func() time.Time
+
+	mu      sync.Mutex
+	learned *bigramCounts
+	dirty   bool
+	clients map[netip.Addr]*nxBurst
+	flagged map[string]*DGAFlag
+}
+
+// NewDGADetector 创建检测器，dir 非空时从中加载并保存学习到的 bigram 计数
+func NewDGADetector(cfg config.DGAConfig, dir string) *DGADetector {
+	if cfg.Mode == "" {
+		cfg.Mode = DGAModeAlert
+	}
+	if cfg.Threshold <= 0 {
+		cfg.Threshold = dgaDefaultThreshold
+	}
+	if cfg.MinLength <= 0 {
+		cfg.MinLength = dgaDefaultMinLength
+	}
+	if cfg.BurstWindowSeconds <= 0 {
+		cfg.BurstWindowSeconds = dgaDefaultBurstWindow
+	}
+	if cfg.BurstThreshold <= 0 {
+		cfg.BurstThreshold = dgaDefaultBurstCount
+	}
+	d := &DGADetector{
+		cfg:     cfg,
+		now:     time.Now,
+		learned: &bigramCounts{},
+		clients: make(map[netip.Addr]*nxBurst),
+		flagged: make(map[string]*DGAFlag),
+	}
+	if dir != "" {
+		d.modelPath = filepath.Join(dir, DGAModelFileName)
+		if data, err := os.ReadFile(d.modelPath); err == nil {
+			if err := json.Unmarshal(data, d.learned); err != nil {
+				logger.Warnf("[AdBlock] Ignoring invalid DGA model %s: %v", d.modelPath, err)
+				d.learned = &bigramCounts{}
+			}
+		}
+	}
+	return d
+}
+
+// ValidateDGA 检查 DGA 检测配置
+func ValidateDGA(cfg *config.DGAConfig) error {
+	switch cfg.Mode {
+	case "", DGAModeBlock, DGAModeAlert, DGAModeLearn:
+	default:
+		return fmt.Errorf("invalid dga mode %q (must be one of: block, alert, learn)", cfg.Mode)
+	}
+	if cfg.Threshold < 0 || cfg.Threshold > 1 {
+		return fmt.Errorf("dga threshold must be between 0 and 1")
+	}
+	return nil
+}
+
+// registrableLabel 返回域名中可注册的那一级标签（公共后缀左边一级），
+// 常见的二级后缀（如 com.cn、co.uk）按一个后缀处理
+func registrableLabel(domain string) string {
+	labels := strings.Split(domain, ".")
+	if len(labels) < 2 {
+		return ""
+	}
+	i := len(labels) - 2
+	if i > 0 {
+		switch labels[i] {
+		case "co", "com", "net", "org", "gov", "edu", "ac":
+			i--
+		}
+	}
+	return labels[i]
+}
+
+// allowed 判断域名是否在白名单后缀内或属于不参与检测的本地/反向解析域
+func (d *DGADetector) allowed(domain string) bool {
+	for _, suffix := range []string{"arpa", "local", "lan", "home.arpa", "internal"} {
+		if domain == suffix || strings.HasSuffix(domain, "."+suffix) {
+			return true
+		}
+	}
+	for _, suffix := range d.cfg.Allowlist {
+		suffix = strings.ToLower(strings.Trim(suffix, ". "))
+		if suffix != "" && (domain == suffix || strings.HasSuffix(domain, "."+suffix)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Score 计算域名评分；标签过短、国际化域名或白名单内的域名不评分，返回 false
+func (d *DGADetector) Score(domain string, client netip.Addr) (DGAScore, bool) {
+	domain = strings.ToLower(strings.TrimSuffix(domain, "."))
+	label := registrableLabel(domain)
+	if len(label) < d.cfg.MinLength || strings.HasPrefix(label, "xn--") || d.allowed(domain) {
+		return DGAScore{}, false
+	}
+
+	s := DGAScore{Domain: domain, Label: label}
+	s.Entropy = labelEntropy(label)
+	s.DigitRatio = digitScore(label)
+	s.Length = min(1, float64(len(label)-d.cfg.MinLength)/float64(max(dgaMaxLength-d.cfg.MinLength, 1)))
+
+	d.mu.Lock()
+	s.NGram = d.ngramScore(label)
+	if b, ok := d.clients[client]; ok && client.IsValid() {
+		s.Burst = min(1, float64(b.total(d.slot()))/float64(d.cfg.BurstThreshold))
+	}
+	d.mu.Unlock()
+
+	// 字符特征加权得到词法分，NXDOMAIN 突发再把剩余部分按比例拉高
+	lexical := 0.5*s.NGram + 0.25*s.Entropy + 0.15*s.DigitRatio + 0.1*s.Length
+	s.Score = 1 - (1-lexical)*(1-0.5*s.Burst)
+	return s, true
+}
+
+// labelEntropy 字符香农熵，2.5 bit 以下记 0，4 bit 以上记满
+func labelEntropy(label string) float64 {
+	var counts [256]int
+	for i := 0; i < len(label); i++ {
+		counts[label[i]]++
+	}
+	h, n := 0.0, float64(len(label))
+	for _, c := range counts {
+		if c > 0 {
+			p := float64(c) / n
+			h -= p * math.Log2(p)
+		}
+	}
+	return min(1, max(0, (h-2.5)/1.5))
+}
+
+// digitScore 字母与数字混排时的数字占比，一半为数字时记满；纯数字或纯字母记 0
+func digitScore(label string) float64 {
+	digits, letters := 0, 0
+	for i := 0; i < len(label); i++ {
+		switch c := label[i]; {
+		case c >= '0' && c <= '9':
+			digits++
+		case c >= 'a' && c <= 'z':
+			letters++
+		}
+	}
+	if digits == 0 || letters == 0 {
+		return 0
+	}
+	return min(1, 2*float64(digits)/float64(len(label)))
+}
+
+// ngramScore 按基准语料与学习计数计算每个字符对的平均 -log2 概率（加一平滑），
+// 常见单词与站点名约 3.5-4.5 bit，随机字符串约 6 bit 以上，映射到 0-1；须持有 d.mu
+func (d *DGADetector) ngramScore(label string) float64 {
+	base := dgaBaseModel()
+	sum, n := 0.0, 0
+	prev := dgaBoundary
+	for i := 0; i <= len(label); i++ {
+		sym := dgaBoundary
+		if i < len(label) {
+			if sym = dgaSymbol(label[i]); sym < 0 {
+				return 0
+			}
+		}
+		var row, pair float64
+		for j := 0; j < dgaAlphabet; j++ {
+			row += float64(base.Counts[prev][j]) + float64(d.learned.Counts[prev][j])
+		}
+		pair = float64(base.Counts[prev][sym]) + float64(d.learned.Counts[prev][sym])
+		sum -= math.Log2((pair + 1) / (row + dgaAlphabet))
+		n++
+		prev = sym
+	}
+	return min(1, max(0, (sum/float64(n)-4.5)/1.5))
+}
+
+func (d *DGADetector) slot() int64 {
+	width := max(int64(d.cfg.BurstWindowSeconds)/dgaBurstBuckets, 1)
+	return d.now().Unix() / width
+}
+
+// Check 为未被规则命中的查询评分；超过阈值时记录为可疑域名，拦截模式下返回拦截结果
+func (d *DGADetector) Check(req *Request) Result {
+	s, ok := d.Score(req.Host, req.ClientIP)
+	if !ok || s.Score < d.cfg.Threshold {
+		return Result{}
+	}
+
+	action := "alerted"
+	switch d.cfg.Mode {
+	case DGAModeBlock:
+		action = "blocked"
+	case DGAModeLearn:
+		action = "learning"
+	}
+	d.flag(s, req.ClientIP, action)
+
+	rule := fmt.Sprintf("dga:%.2f", s.Score)
+	switch d.cfg.Mode {
+	case DGAModeBlock:
+		logger.Debugf("[AdBlock] DGA blocked: %s (score %.2f)", s.Domain, s.Score)
+		// 突发分量随客户端变化，结果不按域名缓存
+		return Result{Match: MatchBlocked, Rule: rule}
+	case DGAModeAlert:
+		logger.Warnf("[AdBlock] Suspicious domain %s from %s (score %.2f)", s.Domain, req.ClientIP, s.Score)
+	}
+	return Result{}
+}
+
+func (d *DGADetector) flag(s DGAScore, client netip.Addr, action string) {
+	now := d.now()
+	clientStr := ""
+	if client.IsValid() {
+		clientStr = client.String()
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if f, ok := d.flagged[s.Domain]; ok {
+		f.DGAScore = s
+		f.Client = clientStr
+		f.Action = action
+		f.Count++
+		f.LastSeen = now
+		return
+	}
+	if len(d.flagged) >= dgaMaxFlagged {
+		// 丢弃最久未出现的记录
+		var oldest string
+		for domain, f := range d.flagged {
+			if oldest == "" || f.LastSeen.Before(d.flagged[oldest].LastSeen) {
+				oldest = domain
+			}
+		}
+		delete(d.flagged, oldest)
+	}
+	d.flagged[s.Domain] = &DGAFlag{DGAScore: s, Client: clientStr, Action: action, Count: 1, FirstSeen: now, LastSeen: now}
+}
+
+// RecordResponse 记录查询的最终应答：NXDOMAIN 计入客户端的突发率，
+// 学习模式下有应答记录的域名用于训练 bigram 模型
+func (d *DGADetector) RecordResponse(domain string, client netip.Addr, rcode int, answered bool) {
+	switch {
+	case rcode == dns.RcodeNameError && client.IsValid():
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		b, ok := d.clients[client]
+		if !ok {
+			if len(d.clients) >= dgaMaxClients {
+				d.pruneClientsLocked()
+				if len(d.clients) >= dgaMaxClients {
+					return
+				}
+			}
+			b = &nxBurst{}
+			d.clients[client] = b
+		}
+		b.add(d.slot())
+
+	case rcode == dns.RcodeSuccess && answered && d.cfg.Mode == DGAModeLearn:
+		label := registrableLabel(strings.ToLower(strings.TrimSuffix(domain, ".")))
+		if len(label) < 2 || strings.HasPrefix(label, "xn--") {
+			return
+		}
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		d.learned.add(label)
+		d.dirty = true
+	}
+}
+
+// pruneClientsLocked 删除窗口内没有 NXDOMAIN 的客户端；须持有 d.mu
+func (d *DGADetector) pruneClientsLocked() {
+	slot := d.slot()
+	for addr, b := range d.clients {
+		if b.total(slot) == 0 {
+			delete(d.clients, addr)
+		}
+	}
+}
+
+// Flagged 返回可疑域名，最近出现的在前
+func (d *DGADetector) Flagged() []DGAFlag {
+	d.mu.Lock()
+	result := make([]DGAFlag, 0, len(d.flagged))
+	for _, f := range d.flagged {
+		result = append(result, *f)
+	}
+	d.mu.Unlock()
+	slices.SortFunc(result, func(a, b DGAFlag) int { return b.LastSeen.Compare(a.LastSeen) })
+	return result
+}
+
+// ClearFlagged 清空可疑域名记录
+func (d *DGADetector) ClearFlagged() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	clear(d.flagged)
+}
+
+// Mode 返回生效的检测模式
+func (d *DGADetector) Mode() string { return d.cfg.Mode }
+
+// Threshold 返回生效的评分阈值
+func (d *DGADetector) Threshold() float64 { return d.cfg.Threshold }
+
+// LearnedNames 返回学习模式累积的域名数
+func (d *DGADetector) LearnedNames() int64 {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.learned.Names
+}
+
+// SaveModel 把学习到的 bigram 计数写入缓存目录，没有新数据时跳过
+func (d *DGADetector) SaveModel() error {
+	d.mu.Lock()
+	if !d.dirty || d.modelPath == "" {
+		d.mu.Unlock()
+		return nil
+	}
+	data, err := json.Marshal(d.learned)
+	d.dirty = false
+	d.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	tmp := d.modelPath + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, d.modelPath)
+}
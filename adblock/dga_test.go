@@ -0,0 +1,142 @@
+package adblock
+
+import (
+	"net/netip"
+	"smartdnssort/config"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestDGAScoreSeparatesRandomLabels(t *testing.T) {
+	d := NewDGADetector(config.DGAConfig{Enable: true}, "")
+
+	benign := []string{
+		"www.google.com", "mail.facebook.com", "weather.microsoft.com", "api.wikipedia.org",
+		"cdn.cloudflare.net", "news.bbc.co.uk", "shopping.taobao.com", "music.netease.com",
+		"developer.mozilla.org", "githubusercontent.com", "accounts.youtube.com",
+	}
+	for _, domain := range benign {
+		if s, ok := d.Score(domain, netip.Addr{}); ok && s.Score >= d.Threshold() {
+			t.Errorf("%s scored %.2f, should stay below the threshold", domain, s.Score)
+		}
+	}
+
+	random := []string{"xj4k2qp9vz7m.com", "qzkwhvbxtrpfjd.net", "a8f3k1z9q7w2e5.org", "kqzxjvwpfbmtlh.info"}
+	for _, domain := range random {
+		s, ok := d.Score(domain, netip.Addr{})
+		if !ok || s.Score < d.Threshold() {
+			t.Errorf("%s scored %.2f, should reach the threshold", domain, s.Score)
+		}
+	}
+
+	for _, domain := range []string{"short.com", "xn--fiqs8sxn4kzp2a.com", "qzkwhvbxtrpfjd.lan"} {
+		if _, ok := d.Score(domain, netip.Addr{}); ok {
+			t.Errorf("%s should not be scored", domain)
+		}
+	}
+}
+
+func TestDGANXDomainBurstRaisesScore(t *testing.T) {
+	d := NewDGADetector(config.DGAConfig{Enable: true, BurstThreshold: 10}, "")
+	now := time.Unix(1700000000, 0)
+	d.now = func() time.Time { return now }
+	client := netip.MustParseAddr("192.168.1.20")
+
+	before, _ := d.Score("vexorimpal.com", client)
+	for range 10 {
+		d.RecordResponse("missing.example", client, dns.RcodeNameError, false)
+	}
+	after, _ := d.Score("vexorimpal.com", client)
+	if after.Burst != 1 || after.Score <= before.Score {
+		t.Errorf("burst should raise the score: before %.2f, after %.2f (burst %.2f)", before.Score, after.Score, after.Burst)
+	}
+	if other, _ := d.Score("vexorimpal.com", netip.MustParseAddr("192.168.1.21")); other.Burst != 0 {
+		t.Error("burst should be tracked per client")
+	}
+
+	now = now.Add(2 * time.Minute)
+	if expired, _ := d.Score("vexorimpal.com", client); expired.Burst != 0 {
+		t.Errorf("burst should expire with the window, got %.2f", expired.Burst)
+	}
+}
+
+func TestDGAModes(t *testing.T) {
+	req := &Request{Host: "qzkwhvbxtrpfjd.net", ClientIP: netip.MustParseAddr("10.0.0.5")}
+
+	block := NewDGADetector(config.DGAConfig{Enable: true, Mode: DGAModeBlock}, "")
+	if res := block.Check(req); res.Match != MatchBlocked || res.Cacheable {
+		t.Errorf("block mode should block without caching, got %+v", res)
+	}
+	if res := block.Check(&Request{Host: "www.google.com"}); res.Match != MatchNeutral {
+		t.Errorf("benign names should not be blocked, got %+v", res)
+	}
+	flagged := block.Flagged()
+	if len(flagged) != 1 || flagged[0].Action != "blocked" || flagged[0].Client != "10.0.0.5" || flagged[0].Count != 1 {
+		t.Fatalf("unexpected flagged domains %+v", flagged)
+	}
+
+	for mode, action := range map[string]string{DGAModeAlert: "alerted", DGAModeLearn: "learning"} {
+		d := NewDGADetector(config.DGAConfig{Enable: true, Mode: mode}, "")
+		d.Check(req)
+		if res := d.Check(req); res.Match != MatchNeutral {
+			t.Errorf("%s mode should not block, got %+v", mode, res)
+		}
+		if f := d.Flagged(); len(f) != 1 || f[0].Action != action || f[0].Count != 2 {
+			t.Errorf("%s mode: unexpected flagged domains %+v", mode, f)
+		}
+		d.ClearFlagged()
+		if len(d.Flagged()) != 0 {
+			t.Error("ClearFlagged should drop all flagged domains")
+		}
+	}
+
+	allow := NewDGADetector(config.DGAConfig{Enable: true, Mode: DGAModeBlock, Allowlist: []string{"trusted.net", "qzkwhvbxtrpfjd.net"}}, "")
+	if res := allow.Check(&Request{Host: "cdn.qzkwhvbxtrpfjd.net"}); res.Match != MatchNeutral {
+		t.Error("allowlisted domains and their subdomains should not be scored")
+	}
+}
+
+func TestDGALearnAndPersistModel(t *testing.T) {
+	dir := t.TempDir()
+	d := NewDGADetector(config.DGAConfig{Enable: true, Mode: DGAModeLearn}, dir)
+	before, _ := d.Score("zhqxvkwj.cn", netip.Addr{})
+
+	for range 200 {
+		d.RecordResponse("zhqxvkwj.cn", netip.Addr{}, dns.RcodeSuccess, true)
+	}
+	d.RecordResponse("nodata.example", netip.Addr{}, dns.RcodeSuccess, false) // 无应答记录不参与学习
+	if d.LearnedNames() != 200 {
+		t.Fatalf("LearnedNames = %d, want 200", d.LearnedNames())
+	}
+	after, _ := d.Score("zhqxvkwj.cn", netip.Addr{})
+	if after.NGram >= before.NGram {
+		t.Errorf("learning should make the name more likely: %.2f -> %.2f", before.NGram, after.NGram)
+	}
+	if err := d.SaveModel(); err != nil {
+		t.Fatal(err)
+	}
+
+	reloaded := NewDGADetector(config.DGAConfig{Enable: true, Mode: DGAModeAlert}, dir)
+	if reloaded.LearnedNames() != 200 {
+		t.Errorf("reloaded model should keep learned names, got %d", reloaded.LearnedNames())
+	}
+	reloaded.RecordResponse("vexorimpal.com", netip.Addr{}, dns.RcodeSuccess, true)
+	if reloaded.LearnedNames() != 200 {
+		t.Error("only learn mode should train the model")
+	}
+}
+
+func TestValidateDGA(t *testing.T) {
+	for _, cfg := range []config.DGAConfig{{}, {Mode: DGAModeBlock, Threshold: 0.8}} {
+		if err := ValidateDGA(&cfg); err != nil {
+			t.Errorf("%+v should be valid: %v", cfg, err)
+		}
+	}
+	for _, cfg := range []config.DGAConfig{{Mode: "drop"}, {Threshold: 1.5}, {Threshold: -0.1}} {
+		if err := ValidateDGA(&cfg); err == nil {
+			t.Errorf("%+v should be rejected", cfg)
+		}
+	}
+}
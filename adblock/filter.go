@@ -1,5 +1,11 @@
 package adblock
 
+import (
+	"net/netip"
+
+	"github.com/miekg/dns"
+)
+
 // MatchResult is the result of a host check.
 type MatchResult int
 
@@ -9,9 +15,43 @@ const (
 	MatchAllowed
 )
 
+// Request 一次过滤检查的请求上下文，用于匹配 $dnstype、$client 等修饰符
+type Request struct {
+	Host       string
+	QType      uint16
+	ClientIP   netip.Addr // 零值表示未知，不参与 $client 匹配
+	ClientName string     // 空表示未知；DNS 请求路径不提供客户端名称，按名称的 $client 规则计入 UnsupportedRules
+	TCP        bool       // 请求经由 TCP 到达
+}
+
+// Rewrite $dnsrewrite、hosts 或 RPZ 规则给出的改写结果（已应用例外规则）
+//   - CNAME 非空：以指向该名称的 CNAME 应答
+//   - RCode 非 NOERROR：以该应答码应答
+//   - Drop：不应答；Truncate：返回设置了 TC 位的空应答，迫使客户端改用 TCP
+//   - 否则以 Answer 应答，Answer 为空表示 NODATA（规则只改写了其他记录类型）
+type Rewrite struct {
+	RCode    int
+	CNAME    string   // FQDN
+	Answer   []dns.RR // owner 为请求域名、TTL 为 0，由调用方设置
+	Drop     bool
+	Truncate bool
+	Rules    []string // 生效的规则原文
+}
+
+// Result is the result of a request check.
+type Result struct {
+	Match   MatchResult
+	Rule    string
+	Rewrite *Rewrite // 非 nil 时按改写结果应答，优先于 Match
+	// Cacheable 为 true 表示结果与请求上下文（查询类型、客户端）无关，可以按域名缓存
+	Cacheable bool
+}
+
 // FilterEngine is the interface for adblock filter engines.
 type FilterEngine interface {
 	CheckHost(domain string) (result MatchResult, rule string)
+	// Check 按完整的请求上下文匹配，并返回改写结果
+	Check(req *Request) Result
 	LoadRules(rules []string) error
 	Count() int
 	Close() error
@@ -0,0 +1,152 @@
+package adblock
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"slices"
+	"smartdnssort/logger"
+	"strings"
+	"time"
+)
+
+// IPBlocklist 按应答地址拦截的 CIDR 列表，按最长前缀匹配
+type IPBlocklist struct {
+	prefixes map[netip.Prefix]struct{}
+	bits     []int // 出现过的前缀长度（IPv4 映射到 IPv6 空间），从长到短
+}
+
+// NewIPBlocklist 由 CIDR 规则行构建列表
+// 每行取第一个字段（兼容 "1.2.3.0/24 ; SBL123" 这类带注释的格式），单个地址视为 /32 或 /128，无法解析的行被忽略
+func NewIPBlocklist(lines []string) *IPBlocklist {
+	l := &IPBlocklist{prefixes: make(map[netip.Prefix]struct{})}
+	for _, line := range lines {
+		p, ok := parseCIDRRule(line)
+		if !ok {
+			continue
+		}
+		p = mappedPrefix(p.Masked())
+		if _, exists := l.prefixes[p]; exists {
+			continue
+		}
+		l.prefixes[p] = struct{}{}
+		if !slices.Contains(l.bits, p.Bits()) {
+			l.bits = append(l.bits, p.Bits())
+		}
+	}
+	slices.Sort(l.bits)
+	slices.Reverse(l.bits)
+	return l
+}
+
+func parseCIDRRule(line string) (netip.Prefix, bool) {
+	fields := strings.FieldsFunc(line, func(r rune) bool { return r == ' ' || r == '\t' || r == ';' || r == ',' })
+	if len(fields) == 0 {
+		return netip.Prefix{}, false
+	}
+	if p, err := netip.ParsePrefix(fields[0]); err == nil {
+		return p, true
+	}
+	if ip, err := netip.ParseAddr(fields[0]); err == nil {
+		ip = ip.Unmap()
+		return netip.PrefixFrom(ip, ip.BitLen()), true
+	}
+	return netip.Prefix{}, false
+}
+
+// Match 返回第一个落在列表中的地址所命中的网段（IPv4 以原始形式返回）
+func (l *IPBlocklist) Match(ips []netip.Addr) (netip.Prefix, bool) {
+	if l == nil || len(l.bits) == 0 {
+		return netip.Prefix{}, false
+	}
+	for _, ip := range ips {
+		ip16 := netip.AddrFrom16(ip.As16())
+		for _, bits := range l.bits {
+			p, err := ip16.Prefix(bits)
+			if err != nil {
+				continue
+			}
+			if _, ok := l.prefixes[p]; !ok {
+				continue
+			}
+			if ip16.Is4In6() && bits >= 96 {
+				p = netip.PrefixFrom(ip16.Unmap(), bits-96).Masked()
+			}
+			return p, true
+		}
+	}
+	return netip.Prefix{}, false
+}
+
+// Count returns the number of distinct prefixes in the list.
+func (l *IPBlocklist) Count() int {
+	if l == nil {
+		return 0
+	}
+	return len(l.prefixes)
+}
+
+// ipBlocklistSource 返回 IP 列表来源对应的下载状态，远程列表缓存在 cache_dir 下；调用方需持有 ipMu
+func (m *AdBlockManager) ipBlocklistSource(url string) *SourceInfo {
+	if src, ok := m.ipSources[url]; ok {
+		return src
+	}
+	h := sha256.Sum256([]byte(url))
+	src := &SourceInfo{
+		URL:       url,
+		Status:    "active",
+		CacheFile: "ipset_" + hex.EncodeToString(h[:16]) + ".txt",
+		Enabled:   true,
+	}
+	m.ipSources[url] = src
+	return src
+}
+
+// UpdateIPBlocklists 重新加载所有 IP 列表
+// 远程列表的缓存未过期且非强制时直接读取缓存，下载失败时继续使用已有缓存
+func (m *AdBlockManager) UpdateIPBlocklists(force bool) int {
+	m.ipMu.Lock()
+	defer m.ipMu.Unlock()
+
+	var lines []string
+	for _, url := range m.cfg.IPBlocklists {
+		src := m.ipBlocklistSource(url)
+		path := GetLocalFilePath(url)
+		if !IsLocalFile(url) {
+			path = filepath.Join(m.loader.cacheDir, src.CacheFile)
+			info, err := os.Stat(path)
+			stale := err != nil || time.Since(info.ModTime()) >= time.Duration(m.cfg.UpdateIntervalHours)*time.Hour
+			if force || stale {
+				if _, _, err := m.loader.UpdateFromSource(context.Background(), src); err != nil {
+					logger.Warnf("[AdBlock] Failed to update IP blocklist %s: %v", url, err)
+				}
+			}
+		}
+
+		rules, err := ReadValidRules(path)
+		if err != nil {
+			logger.Warnf("[AdBlock] Failed to read IP blocklist %s: %v", url, err)
+			continue
+		}
+		lines = append(lines, rules...)
+	}
+
+	list := NewIPBlocklist(lines)
+	m.mu.Lock()
+	m.ipBlocklist = list
+	m.mu.Unlock()
+	return list.Count()
+}
+
+// CheckResponseIPs 按 IP 列表检查应答地址，返回命中的网段
+func (m *AdBlockManager) CheckResponseIPs(ips []netip.Addr) (netip.Prefix, bool) {
+	if !m.enabled.Load() {
+		return netip.Prefix{}, false
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.ipBlocklist.Match(ips)
+}
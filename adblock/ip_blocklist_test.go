@@ -0,0 +1,76 @@
+package adblock
+
+import (
+	"net/netip"
+	"os"
+	"path/filepath"
+	"smartdnssort/config"
+	"testing"
+)
+
+func TestIPBlocklistMatch(t *testing.T) {
+	l := NewIPBlocklist([]string{
+		"192.0.2.0/24 ; SBL000001",
+		"192.0.2.128/25",
+		"198.51.100.7",
+		"2001:db8::/32",
+		"not-a-cidr",
+	})
+	if l.Count() != 4 {
+		t.Fatalf("Count() = %d, want 4", l.Count())
+	}
+
+	tests := []struct {
+		ip   string
+		want string
+	}{
+		{"192.0.2.200", "192.0.2.128/25"}, // 最长前缀
+		{"192.0.2.1", "192.0.2.0/24"},
+		{"198.51.100.7", "198.51.100.7/32"},
+		{"2001:db8:1::1", "2001:db8::/32"},
+		{"203.0.113.1", ""},
+	}
+	for _, tt := range tests {
+		p, ok := l.Match([]netip.Addr{netip.MustParseAddr(tt.ip)})
+		if tt.want == "" {
+			if ok {
+				t.Errorf("%s: unexpected match %s", tt.ip, p)
+			}
+			continue
+		}
+		if !ok || p.String() != tt.want {
+			t.Errorf("%s: got %s (%v), want %s", tt.ip, p, ok, tt.want)
+		}
+	}
+
+	// 任一地址命中即拦截
+	ips := []netip.Addr{netip.MustParseAddr("203.0.113.1"), netip.MustParseAddr("198.51.100.7")}
+	if _, ok := l.Match(ips); !ok {
+		t.Error("a single listed address should match the answer")
+	}
+}
+
+func TestManagerUpdateIPBlocklists(t *testing.T) {
+	dir := t.TempDir()
+	listFile := filepath.Join(dir, "bad_ranges.txt")
+	if err := os.WriteFile(listFile, []byte("# bad ranges\n192.0.2.0/24\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &config.AdBlockConfig{Enable: true, Engine: "simple", CacheDir: dir, IPBlocklists: []string{listFile}}
+	m, err := NewManager(cfg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := m.UpdateIPBlocklists(false); n != 1 {
+		t.Fatalf("UpdateIPBlocklists() = %d, want 1", n)
+	}
+	if p, ok := m.CheckResponseIPs([]netip.Addr{netip.MustParseAddr("192.0.2.9")}); !ok || p.String() != "192.0.2.0/24" {
+		t.Errorf("expected 192.0.2.0/24, got %s (%v)", p, ok)
+	}
+
+	m.SetEnabled(false)
+	if _, ok := m.CheckResponseIPs([]netip.Addr{netip.MustParseAddr("192.0.2.9")}); ok {
+		t.Error("disabled adblock should not block response IPs")
+	}
+}
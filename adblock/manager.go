@@ -2,13 +2,17 @@ package adblock
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"net/netip"
 	"os"
 	"path/filepath"
 	"smartdnssort/config"
 	"smartdnssort/connectivity"
 	"smartdnssort/logger"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -21,6 +25,37 @@ type AdBlockManager struct {
 	mu             sync.RWMutex
 	lastUpdate     time.Time
 	networkChecker connectivity.NetworkHealthChecker
+
+	// RPZ 区域独立于规则源同步，先于规则引擎检查
+	rpz       *RPZEngine
+	rpzMu     sync.Mutex
+	rpzCtx    context.Context
+	rpzCancel context.CancelFunc
+
+	// 按应答地址拦截的 CIDR 列表，随规则更新一同刷新
+	ipBlocklist *IPBlocklist
+	ipSources   map[string]*SourceInfo
+	ipMu        sync.Mutex
+
+	services *ServiceCatalog
+	schedule *ScheduleEngine
+
+	// 按规则与规则源的命中统计，规则源索引在每次加载规则后于后台重建
+	ruleStats *RuleStats
+
+	// DGA 启发式检测（未启用时为 nil），对未被规则命中的域名评分
+	dga *DGADetector
+
+	// 过滤结果可能变化时（如 RPZ 区域更新）调用，清空按域名缓存的拦截/白名单结果
+	onRulesChanged func()
+
+	// 生效的过滤开关，由 SetEnabled/Pause/Resume 维护，不读取可能被外部修改的 cfg.Enable
+	enabled atomic.Bool
+
+	// 暂停状态：暂停期间 SetEnabled 只记录恢复后的状态
+	pausedUntil   time.Time
+	pauseTimer    *time.Timer
+	resumeEnabled bool
 }
 
 func NewManager(cfg *config.AdBlockConfig, networkChecker connectivity.NetworkHealthChecker) (*AdBlockManager, error) {
@@ -37,20 +72,38 @@ func NewManager(cfg *config.AdBlockConfig, networkChecker connectivity.NetworkHe
 	loader := NewRuleLoader(cfg)
 	stats := NewStats()
 
-	return &AdBlockManager{
+	m := &AdBlockManager{
 		cfg:            cfg,
 		engine:         engine,
 		sourcesMgr:     sourcesMgr,
 		loader:         loader,
 		stats:          stats,
 		networkChecker: networkChecker,
-	}, nil
+		rpz:            NewRPZEngine(),
+		ipSources:      make(map[string]*SourceInfo),
+		services:       NewServiceCatalog(),
+		ruleStats:      NewRuleStats(),
+	}
+	m.enabled.Store(cfg.Enable)
+	if cfg.DGA.Enable {
+		m.dga = NewDGADetector(cfg.DGA, cfg.CacheDir)
+	}
+	m.loadCachedServiceCatalog()
+	m.rebuildSchedules()
+	return m, nil
 }
 
 func (m *AdBlockManager) Start(ctx context.Context) {
+	m.rpzMu.Lock()
+	m.rpzCtx = ctx
+	m.rpzMu.Unlock()
+	m.SetRPZZones(m.cfg.RPZ)
 
 	// Initial rule load
 	go func() {
+		if len(m.cfg.IPBlocklists) > 0 {
+			logger.Debugf("[AdBlock] Loaded %d IP blocklist prefixes", m.UpdateIPBlocklists(false))
+		}
 		logger.Debugf("[AdBlock] Loading existing rules from cache...")
 		if err := m.LoadRulesFromCache(); err != nil {
 			logger.Warnf("[AdBlock] Failed to load rules from cache: %v", err)
@@ -70,6 +123,21 @@ func (m *AdBlockManager) Start(ctx context.Context) {
 		}
 	}()
 
+	// 定期保存 DGA 学习模式累积的模型
+	go func() {
+		ticker := time.NewTicker(dgaSaveInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				m.saveDGAModel()
+			case <-ctx.Done():
+				m.saveDGAModel()
+				return
+			}
+		}
+	}()
+
 	// Ticker for periodic updates
 	if m.cfg.UpdateIntervalHours > 0 {
 		ticker := time.NewTicker(time.Duration(m.cfg.UpdateIntervalHours) * time.Hour)
@@ -99,6 +167,12 @@ func (m *AdBlockManager) UpdateRules(force bool) (UpdateResult, error) {
 		return UpdateResult{}, fmt.Errorf("network unhealthy, rules update skipped")
 	}
 
+	if err := m.updateServiceCatalog(context.Background()); err != nil {
+		logger.Warnf("[AdBlock] Failed to update service catalog: %v", err)
+	} else if m.cfg.ServicesURL != "" {
+		m.rebuildSchedules()
+	}
+
 	// Phase 1: Prepare - Download and parse rules WITHOUT holding the lock
 	sources := m.sourcesMgr.GetAllSources()
 
@@ -168,28 +242,19 @@ func (m *AdBlockManager) UpdateRules(force bool) (UpdateResult, error) {
 	// Phase 2: Load - Parse all rules into a new engine instance WITHOUT holding the lock
 	// Get fresh source list after updates
 	updatedSources := m.sourcesMgr.GetAllSources()
-	allRules, err := m.loader.LoadAllRules(updatedSources)
+	newEngine, allRules, err := m.loadEngine(updatedSources)
 	if err != nil {
 		return UpdateResult{}, err
 	}
 
-	// Create a new engine instance with the updated rules
-	newEngine, err := CreateEngine(m.cfg)
-	if err != nil {
-		return UpdateResult{}, err
-	}
+	// Phase 3: Swap - Replace the engine with minimal lock holding time
+	m.swapEngine(newEngine, time.Now())
 
-	if err := newEngine.LoadRules(allRules); err != nil {
-		return UpdateResult{}, err
+	if len(m.cfg.IPBlocklists) > 0 {
+		logger.Debugf("[AdBlock] Reloaded %d IP blocklist prefixes", m.UpdateIPBlocklists(force))
 	}
 
-	// Phase 3: Swap - Replace the engine with minimal lock holding time
-	m.mu.Lock()
-	m.engine = newEngine
-	m.lastUpdate = time.Now()
-	m.mu.Unlock()
-
-	totalRules = m.engine.Count()
+	totalRules = newEngine.Count()
 	if totalRules == 0 {
 		totalRules = len(allRules)
 	}
@@ -244,29 +309,26 @@ func (m *AdBlockManager) LoadRulesFromCache() error {
 	}
 
 	// Phase 2: Load rules from files (without holding lock)
-	allRules, err := m.loader.LoadAllRules(sources)
+	engine, allRules, err := m.loadEngine(sources)
 	if err != nil {
 		return err
 	}
+	ruleCount := len(allRules)
+	if allRules == nil {
+		ruleCount = engine.Count() // 直接加载了编译文件
+	}
 
 	const minCacheRuleCount = 100
 	// Only load rules if we have a reasonable number
 	// If cache has very few rules, it's likely incomplete or corrupted
 	// Trigger a fresh download to get complete rules
-	if len(allRules) < minCacheRuleCount {
-		logger.Warnf("[AdBlock] Cache has too few rules (%d), likely incomplete. Will trigger fresh download.", len(allRules))
-		return fmt.Errorf("cache has insufficient rules: %d", len(allRules))
+	if ruleCount < minCacheRuleCount {
+		engine.Close()
+		logger.Warnf("[AdBlock] Cache has too few rules (%d), likely incomplete. Will trigger fresh download.", ruleCount)
+		return fmt.Errorf("cache has insufficient rules: %d", ruleCount)
 	}
 
-	logger.Debugf("[AdBlock] Loaded %d rules from cache", len(allRules))
-
-	// Phase 3: Load rules into engine and update state (with minimal lock time)
-	m.mu.Lock()
-	defer m.mu.Unlock()
-
-	if err := m.engine.LoadRules(allRules); err != nil {
-		return err
-	}
+	logger.Debugf("[AdBlock] Loaded %d rules from cache", ruleCount)
 
 	// Update m.lastUpdate with the latest LastUpdate time from sources
 	// This ensures the correct last update time is shown even when loading from cache
@@ -276,30 +338,321 @@ func (m *AdBlockManager) LoadRulesFromCache() error {
 			latestUpdate = source.LastUpdate
 		}
 	}
-	m.lastUpdate = latestUpdate
 
+	// Phase 3: Swap engine and update state (with minimal lock time)
+	m.swapEngine(engine, latestUpdate)
 	return nil
 }
 
+// loadEngine 创建新的过滤引擎并载入规则源与已启用服务的规则
+// 编译型引擎在规则来源未变化时直接加载上次的编译文件，此时不读取规则，返回的 rules 为 nil
+func (m *AdBlockManager) loadEngine(sources []*SourceInfo) (FilterEngine, []string, error) {
+	engine, err := CreateEngine(m.cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	compiled, isCompiled := engine.(compiledEngine)
+	var fingerprint string
+	if isCompiled {
+		fingerprint = m.rulesFingerprint(sources)
+		if compiled.LoadCompiled(fingerprint) {
+			logger.Debugf("[AdBlock] Rule sources unchanged, loaded compiled rules (%d)", engine.Count())
+			return engine, nil, nil
+		}
+	}
+
+	rules, err := m.loader.LoadAllRules(sources)
+	if err != nil {
+		return nil, nil, err
+	}
+	rules = append(rules, m.serviceRules()...)
+
+	if isCompiled {
+		err = compiled.Compile(rules, fingerprint)
+	} else {
+		err = engine.LoadRules(rules)
+	}
+	if err != nil {
+		engine.Close()
+		return nil, nil, err
+	}
+	return engine, rules, nil
+}
+
+// rulesFingerprint 由已启用规则源的文件状态（路径、大小、修改时间）与服务规则计算指纹
+func (m *AdBlockManager) rulesFingerprint(sources []*SourceInfo) string {
+	h := sha256.New()
+	for _, source := range sources {
+		if !source.Enabled {
+			continue
+		}
+		path, _ := m.loader.sourcePath(source)
+		fmt.Fprintf(h, "%s\x00%s\x00", source.URL, path)
+		if info, err := os.Stat(path); err == nil {
+			fmt.Fprintf(h, "%d\x00%d\x00", info.Size(), info.ModTime().UnixNano())
+		}
+	}
+	for _, rule := range m.serviceRules() {
+		fmt.Fprintf(h, "%s\n", rule)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// swapEngine 替换过滤引擎并关闭旧引擎
+// 查询在持有读锁期间使用引擎，写锁释放后旧引擎不再被引用，可以安全关闭（释放映射等资源）
+func (m *AdBlockManager) swapEngine(engine FilterEngine, lastUpdate time.Time) {
+	m.mu.Lock()
+	old := m.engine
+	m.engine = engine
+	m.lastUpdate = lastUpdate
+	m.mu.Unlock()
+	if old != nil && old != engine {
+		old.Close()
+	}
+	go m.indexRuleSources()
+}
+
+// SetRPZZones 按新的区域配置重启所有 RPZ 同步任务，列表顺序即优先级
+func (m *AdBlockManager) SetRPZZones(zones []config.RPZZoneConfig) {
+	m.rpzMu.Lock()
+	defer m.rpzMu.Unlock()
+
+	if m.rpzCancel != nil {
+		m.rpzCancel()
+		m.rpzCancel = nil
+	}
+	// 取消的任务可能仍在完成进行中的传输，新版本号使其写入被丢弃
+	gen := m.rpz.Reset()
+	m.invalidateCaches()
+	if m.rpzCtx == nil || len(zones) == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(m.rpzCtx)
+	m.rpzCancel = cancel
+	for i, zone := range zones {
+		go newRPZSource(zone, i, gen, m.rpz, m.invalidateCaches).run(ctx)
+	}
+}
+
+// SetCacheInvalidator 设置过滤结果变化时的回调，应在 Start 之前调用
+func (m *AdBlockManager) SetCacheInvalidator(fn func()) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.onRulesChanged = fn
+}
+
+func (m *AdBlockManager) invalidateCaches() {
+	m.mu.RLock()
+	fn := m.onRulesChanged
+	m.mu.RUnlock()
+	if fn != nil {
+		fn()
+	}
+}
+
 func (m *AdBlockManager) CheckHost(domain string) (MatchResult, string) {
-	if !m.cfg.Enable {
+	if !m.enabled.Load() {
 		return MatchNeutral, ""
 	}
+	if result, rule := m.rpz.CheckHost(domain); result != MatchNeutral {
+		return result, rule
+	}
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 	return m.engine.CheckHost(domain)
 }
 
+// Check 按请求上下文（查询类型、客户端）匹配规则，返回拦截/放行或改写结果
+func (m *AdBlockManager) Check(req *Request) Result {
+	if !m.enabled.Load() {
+		return Result{Cacheable: true}
+	}
+	if res := m.rpz.Check(req); res.Match != MatchNeutral || res.Rewrite != nil {
+		return res
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.engine.Check(req)
+}
+
+// HasResponsePolicy 返回是否需要在解析后检查应答地址（存在 RPZ-IP 触发器）
+func (m *AdBlockManager) HasResponsePolicy() bool {
+	return m.enabled.Load() && m.rpz.HasIPTriggers()
+}
+
+// CheckResponse 按应答中的地址检查 RPZ-IP 触发器
+func (m *AdBlockManager) CheckResponse(req *Request, ips []netip.Addr) Result {
+	if !m.enabled.Load() {
+		return Result{}
+	}
+	return m.rpz.CheckResponse(req, ips)
+}
+
+// Enabled 返回过滤当前是否生效，暂停期间为 false
+func (m *AdBlockManager) Enabled() bool {
+	return m.enabled.Load()
+}
+
 // SetEnabled dynamically enables or disables AdBlock filtering
+// 暂停期间只记录状态，暂停结束时生效
 func (m *AdBlockManager) SetEnabled(enabled bool) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	m.cfg.Enable = enabled
+	if m.pauseTimer != nil {
+		m.resumeEnabled = enabled
+		return
+	}
+	m.enabled.Store(enabled)
+}
+
+// Pause 暂停过滤 d 时长，到期后自动恢复暂停前的状态；重复调用会重新计时
+// onTransition 在自动恢复时调用，用于让依赖过滤结果的缓存失效
+func (m *AdBlockManager) Pause(d time.Duration, onTransition func()) time.Time {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.pauseTimer != nil {
+		m.pauseTimer.Stop()
+	} else {
+		m.resumeEnabled = m.enabled.Load()
+	}
+	m.enabled.Store(false)
+	m.pausedUntil = time.Now().Add(d)
+
+	var timer *time.Timer
+	timer = time.AfterFunc(d, func() {
+		m.mu.Lock()
+		if m.pauseTimer != timer {
+			m.mu.Unlock()
+			return // 已被 Resume 或新的 Pause 取代
+		}
+		m.resumeLocked()
+		m.mu.Unlock()
+		logger.Info("[AdBlock] Pause expired, filtering restored")
+		if onTransition != nil {
+			onTransition()
+		}
+	})
+	m.pauseTimer = timer
+	return m.pausedUntil
+}
+
+// Resume 立即结束暂停，未暂停时返回 false
+func (m *AdBlockManager) Resume() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.pauseTimer == nil {
+		return false
+	}
+	m.pauseTimer.Stop()
+	m.resumeLocked()
+	return true
+}
+
+func (m *AdBlockManager) resumeLocked() {
+	m.enabled.Store(m.resumeEnabled)
+	m.pauseTimer = nil
+	m.pausedUntil = time.Time{}
+}
+
+// PausedUntil 返回暂停的结束时间，未暂停时为零值
+func (m *AdBlockManager) PausedUntil() time.Time {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.pausedUntil
+}
+
+// rebuildSchedules 按当前配置与服务目录重新编译定时规则集，配置无效时停用定时规则
+func (m *AdBlockManager) rebuildSchedules() {
+	schedule, err := NewScheduleEngine(m.cfg, m.services)
+	if err != nil {
+		logger.Errorf("[AdBlock] Invalid schedules, scheduled filtering disabled: %v", err)
+	}
+	m.mu.Lock()
+	m.schedule = schedule
+	m.mu.Unlock()
+}
+
+// SetSchedules 替换时区与定时规则集
+func (m *AdBlockManager) SetSchedules(timezone string, schedules []config.ScheduleConfig) {
+	m.mu.Lock()
+	m.cfg.Timezone = timezone
+	m.cfg.Schedules = schedules
+	m.mu.Unlock()
+	m.rebuildSchedules()
+}
+
+// CheckSchedule 检查当前时间窗口内生效的定时规则集
+func (m *AdBlockManager) CheckSchedule(req *Request) Result {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if !m.enabled.Load() {
+		return Result{}
+	}
+	return m.schedule.Check(req, time.Now())
+}
+
+// SetIPBlocklists 替换 IP 列表来源并在后台重新加载
+func (m *AdBlockManager) SetIPBlocklists(urls []string) {
+	m.ipMu.Lock()
+	m.cfg.IPBlocklists = urls
+	m.ipMu.Unlock()
+	go func() {
+		logger.Debugf("[AdBlock] Reloaded %d IP blocklist prefixes", m.UpdateIPBlocklists(false))
+	}()
+}
+
+// SetDGA 替换 DGA 检测配置，旧检测器学习到的模型先写入磁盘
+func (m *AdBlockManager) SetDGA(cfg config.DGAConfig) {
+	m.saveDGAModel()
+	var dga *DGADetector
+	if cfg.Enable {
+		dga = NewDGADetector(cfg, m.cfg.CacheDir)
+	}
+	m.mu.Lock()
+	m.cfg.DGA = cfg
+	m.dga = dga
+	m.mu.Unlock()
+}
+
+// DGA 返回 DGA 检测器，未启用时返回 nil
+func (m *AdBlockManager) DGA() *DGADetector {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.dga
+}
+
+// CheckDGA 对未被规则命中的查询做 DGA 评分，只有拦截模式会返回拦截结果
+func (m *AdBlockManager) CheckDGA(req *Request) Result {
+	m.mu.RLock()
+	dga, enabled := m.dga, m.enabled.Load()
+	m.mu.RUnlock()
+	if dga == nil || !enabled {
+		return Result{}
+	}
+	return dga.Check(req)
+}
+
+func (m *AdBlockManager) saveDGAModel() {
+	if dga := m.DGA(); dga != nil {
+		if err := dga.SaveModel(); err != nil {
+			logger.Warnf("[AdBlock] Failed to save DGA model: %v", err)
+		}
+	}
 }
 
 func (m *AdBlockManager) RecordBlock(domain, rule string) {
 	m.stats.RecordBlock(domain, rule)
+	m.ruleStats.Record(domain, rule)
+}
+
+// ClearRuleStats 清空规则命中统计
+func (m *AdBlockManager) ClearRuleStats() {
+	m.ruleStats.Reset()
 }
 
 func (m *AdBlockManager) GetStats() AdBlockStats {
@@ -333,7 +686,11 @@ func (m *AdBlockManager) GetStats() AdBlockStats {
 		}
 	}
 
-	return m.stats.GetStats(m.cfg.Enable, m.cfg.Engine, totalRules, len(sources), failedSources, m.lastUpdate)
+	stats := m.stats.GetStats(m.enabled.Load(), m.cfg.Engine, totalRules, len(sources), failedSources, m.lastUpdate)
+	if counter, ok := m.engine.(unsupportedRuleCounter); ok {
+		stats.UnsupportedRules = counter.UnsupportedRules()
+	}
+	return stats
 }
 
 func (m *AdBlockManager) GetSources() []SourceStatus {
@@ -357,27 +714,24 @@ func (m *AdBlockManager) SetSourceEnabled(url string, enabled bool) error {
 		return err
 	}
 
-	// 2. Load rules and create engine WITHOUT holding manager lock
-	sources := m.sourcesMgr.GetAllSources()
-	allRules, err := m.loader.LoadAllRules(sources)
-	if err != nil {
-		return err
-	}
+	return m.rebuildEngine()
+}
 
-	// Create a new engine with updated rules
-	newEngine, err := CreateEngine(m.cfg)
+// rebuildEngine 从已缓存的规则源与已启用的服务重新编译过滤引擎
+func (m *AdBlockManager) rebuildEngine() error {
+	// 1. Load rules and create engine WITHOUT holding manager lock
+	newEngine, _, err := m.loadEngine(m.sourcesMgr.GetAllSources())
 	if err != nil {
 		return err
 	}
 
-	if err := newEngine.LoadRules(allRules); err != nil {
-		return err
-	}
-
-	// 3. Swap the engine with minimal lock holding time
+	// 2. Swap the engine with minimal lock holding time
 	m.mu.Lock()
-	defer m.mu.Unlock()
+	old := m.engine
 	m.engine = newEngine
+	m.mu.Unlock()
+	old.Close()
+	go m.indexRuleSources()
 	return nil
 }
 
@@ -0,0 +1,24 @@
+//go:build unix
+
+package adblock
+
+import (
+	"os"
+	"syscall"
+)
+
+// mapFile 以只读方式映射整个文件，返回的 unmap 释放映射；关闭文件不影响已建立的映射
+func mapFile(f *os.File) ([]byte, func() error, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return nil, nil, err
+	}
+	if info.Size() == 0 {
+		return nil, func() error { return nil }, nil
+	}
+	data, err := syscall.Mmap(int(f.Fd()), 0, int(info.Size()), syscall.PROT_READ, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, nil, err
+	}
+	return data, func() error { return syscall.Munmap(data) }, nil
+}
@@ -0,0 +1,126 @@
+package adblock
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"slices"
+	"strings"
+
+	"github.com/AdguardTeam/urlfilter/rules"
+	"github.com/miekg/dns"
+)
+
+// buildDNSRewrite 将已应用例外规则的 $dnsrewrite 规则转换为改写结果，没有规则时返回 nil
+// 优先级与 AdGuard Home 相同：CNAME 覆盖一切，其次是非 NOERROR 的应答码，最后按查询类型收集记录
+func buildDNSRewrite(nrules []*rules.NetworkRule, host string, qtype uint16) *Rewrite {
+	if len(nrules) == 0 {
+		return nil
+	}
+
+	rw := &Rewrite{RCode: dns.RcodeSuccess}
+	for _, nr := range nrules {
+		dr := nr.DNSRewrite
+		if dr.NewCNAME != "" {
+			return &Rewrite{RCode: dns.RcodeSuccess, CNAME: dns.Fqdn(dr.NewCNAME), Rules: []string{nr.Text()}}
+		}
+
+		rw.Rules = append(rw.Rules, nr.Text())
+		if dr.RCode != dns.RcodeSuccess {
+			rw.RCode = dr.RCode
+			rw.Answer = nil
+			return rw
+		}
+		if dr.RRType != qtype {
+			continue
+		}
+		if rr := rewriteRR(host, dr.RRType, dr.Value); rr != nil {
+			rw.Answer = append(rw.Answer, rr)
+		}
+	}
+	return rw
+}
+
+// applyHostRules 处理 hosts 格式规则：全部指向 0.0.0.0/:: 时视为拦截，否则改写为规则中的地址
+func applyHostRules(result Result, v4, v6 []*rules.HostRule, host string, qtype uint16) Result {
+	all := slices.Concat(v4, v6)
+	if len(all) == 0 {
+		return result
+	}
+	result.Rule = all[0].Text()
+	if !slices.ContainsFunc(all, func(hr *rules.HostRule) bool { return !hr.IP.IsUnspecified() }) {
+		result.Match = MatchBlocked
+		return result
+	}
+
+	rw := &Rewrite{RCode: dns.RcodeSuccess}
+	var family []*rules.HostRule
+	switch qtype {
+	case dns.TypeA:
+		family = v4
+	case dns.TypeAAAA:
+		family = v6
+	}
+	for _, hr := range family {
+		rw.Rules = append(rw.Rules, hr.Text())
+		if rr := rewriteRR(host, qtype, hr.IP); rr != nil {
+			rw.Answer = append(rw.Answer, rr)
+		}
+	}
+	if len(rw.Rules) == 0 {
+		rw.Rules = []string{result.Rule}
+	}
+	result.Rewrite = rw
+	return result
+}
+
+// rewriteRR 按 urlfilter 的 RRValue 约定构造记录，不支持的类型返回 nil
+func rewriteRR(host string, rrtype uint16, value rules.RRValue) dns.RR {
+	hdr := dns.RR_Header{Name: dns.Fqdn(host), Rrtype: rrtype, Class: dns.ClassINET}
+	switch v := value.(type) {
+	case netip.Addr:
+		switch {
+		case rrtype == dns.TypeA && v.Is4():
+			return &dns.A{Hdr: hdr, A: net.IP(v.AsSlice())}
+		case rrtype == dns.TypeAAAA && v.Is6():
+			return &dns.AAAA{Hdr: hdr, AAAA: net.IP(v.AsSlice())}
+		}
+	case string:
+		switch rrtype {
+		case dns.TypeTXT:
+			return &dns.TXT{Hdr: hdr, Txt: []string{v}}
+		case dns.TypePTR:
+			return &dns.PTR{Hdr: hdr, Ptr: dns.Fqdn(v)}
+		}
+	case *rules.DNSMX:
+		return &dns.MX{Hdr: hdr, Preference: v.Preference, Mx: dns.Fqdn(v.Exchange)}
+	case *rules.DNSSRV:
+		return &dns.SRV{Hdr: hdr, Priority: v.Priority, Weight: v.Weight, Port: v.Port, Target: dns.Fqdn(v.Target)}
+	case *rules.DNSSVCB:
+		return svcbRewriteRR(hdr, v)
+	}
+	return nil
+}
+
+// svcbRewriteRR 通过表示格式构造 HTTPS/SVCB 记录，由 miekg/dns 负责各参数的解析和校验
+func svcbRewriteRR(hdr dns.RR_Header, v *rules.DNSSVCB) dns.RR {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s 0 IN %s %d %s", hdr.Name, dns.TypeToString[hdr.Rrtype], v.Priority, dns.Fqdn(v.Target))
+	keys := make([]string, 0, len(v.Params))
+	for k := range v.Params {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	for _, k := range keys {
+		if val := v.Params[k]; val != "" {
+			fmt.Fprintf(&b, " %s=%q", k, val)
+		} else {
+			b.WriteString(" " + k)
+		}
+	}
+	rr, err := dns.NewRR(b.String())
+	if err != nil {
+		return nil
+	}
+	return rr
+}
@@ -0,0 +1,407 @@
+package adblock
+
+import (
+	"fmt"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/miekg/dns"
+)
+
+// rpzAction RPZ 策略动作，由触发器所在 owner 的记录决定
+type rpzAction int
+
+const (
+	rpzNXDomain  rpzAction = iota // CNAME .
+	rpzNoData                     // CNAME *.
+	rpzPassthru                   // CNAME rpz-passthru.
+	rpzDrop                       // CNAME rpz-drop.
+	rpzTCPOnly                    // CNAME rpz-tcp-only.
+	rpzLocalData                  // 其他记录：以这些记录应答
+)
+
+// rpzRule 一个触发器（QNAME 或 RPZ-IP）对应的策略
+type rpzRule struct {
+	action rpzAction
+	data   []dns.RR // 本地数据，应答时 owner 替换为查询名
+	text   string   // 用于日志和统计的规则描述
+}
+
+// rpzZone 一个已加载的策略区域
+type rpzZone struct {
+	name      string              // 区域名（不带末尾点）
+	exact     map[string]*rpzRule // QNAME 精确触发器
+	wildcard  map[string]*rpzRule // *.suffix 触发器，键为 suffix
+	ipRules   map[netip.Prefix]*rpzRule
+	ipBits    []int // ipRules 中出现过的前缀长度（按 IPv6 映射后的位数），从长到短
+	count     int
+	unhandled int // 不支持的触发器（rpz-client-ip、rpz-nsdname、rpz-nsip）
+}
+
+// RPZEngine 基于响应策略区域（RPZ）的过滤引擎
+// 多个区域按优先级排列，第一个命中的区域决定结果；同一区域内精确 QNAME 优先于通配符，RPZ-IP 取最长前缀
+type RPZEngine struct {
+	mu    sync.RWMutex
+	zones []*rpzZone
+	gen   uint64 // 区域配置的版本，Reset 时递增，旧版本同步任务的写入被丢弃
+}
+
+// NewRPZEngine creates an empty RPZ engine.
+func NewRPZEngine() *RPZEngine {
+	return &RPZEngine{}
+}
+
+// newRPZZone 由区域内的全部记录构建策略区域
+func newRPZZone(name string, rrs []dns.RR) *rpzZone {
+	z := &rpzZone{
+		name:     strings.ToLower(strings.TrimSuffix(name, ".")),
+		exact:    make(map[string]*rpzRule),
+		wildcard: make(map[string]*rpzRule),
+		ipRules:  make(map[netip.Prefix]*rpzRule),
+	}
+	origin := "." + z.name + "."
+
+	for _, rr := range rrs {
+		h := rr.Header()
+		if h.Rrtype == dns.TypeSOA || h.Rrtype == dns.TypeNS {
+			continue
+		}
+		owner := strings.ToLower(h.Name)
+		if !strings.HasSuffix(owner, origin) {
+			continue
+		}
+		rel := strings.TrimSuffix(owner, origin)
+
+		switch {
+		case strings.HasSuffix(rel, ".rpz-ip"):
+			prefix, err := parseRPZIP(strings.TrimSuffix(rel, ".rpz-ip"))
+			if err != nil {
+				z.unhandled++
+				continue
+			}
+			z.ipRules[prefix] = z.addRecord(z.ipRules[prefix], rr)
+		case strings.HasSuffix(rel, ".rpz-client-ip"), strings.HasSuffix(rel, ".rpz-nsdname"), strings.HasSuffix(rel, ".rpz-nsip"):
+			z.unhandled++
+		case rel == "*":
+			z.wildcard[""] = z.addRecord(z.wildcard[""], rr)
+		case strings.HasPrefix(rel, "*."):
+			z.wildcard[rel[2:]] = z.addRecord(z.wildcard[rel[2:]], rr)
+		default:
+			z.exact[rel] = z.addRecord(z.exact[rel], rr)
+		}
+	}
+	return z
+}
+
+// addRecord 将 owner 下的一条记录并入策略：特殊 CNAME 决定动作，其余记录作为本地数据
+func (z *rpzZone) addRecord(rule *rpzRule, rr dns.RR) *rpzRule {
+	if rule == nil {
+		rule = &rpzRule{action: rpzLocalData}
+		z.count++
+	}
+	if cname, ok := rr.(*dns.CNAME); ok {
+		action := rpzLocalData
+		switch strings.ToLower(cname.Target) {
+		case ".":
+			action = rpzNXDomain
+		case "*.":
+			action = rpzNoData
+		case "rpz-passthru.":
+			action = rpzPassthru
+		case "rpz-drop.":
+			action = rpzDrop
+		case "rpz-tcp-only.":
+			action = rpzTCPOnly
+		}
+		if action != rpzLocalData {
+			rule.action, rule.data = action, nil
+			rule.text = fmt.Sprintf("rpz:%s %s CNAME %s", z.name, rr.Header().Name, cname.Target)
+			return rule
+		}
+	}
+	if rule.action == rpzLocalData {
+		rule.data = append(rule.data, rr)
+		if rule.text == "" {
+			rule.text = fmt.Sprintf("rpz:%s %s local-data", z.name, rr.Header().Name)
+		}
+	}
+	return rule
+}
+
+// parseRPZIP 解析 RPZ-IP 触发器的 owner：前缀长度后接倒序的地址，IPv6 中 zz 表示 ::
+//
+//	24.0.2.0.192        -> 192.0.2.0/24
+//	64.zz.db8.2001      -> 2001:db8::/64
+func parseRPZIP(s string) (netip.Prefix, error) {
+	labels := strings.Split(s, ".")
+	if len(labels) < 2 {
+		return netip.Prefix{}, fmt.Errorf("invalid rpz-ip trigger %q", s)
+	}
+	bits, err := strconv.Atoi(labels[0])
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("invalid rpz-ip prefix length %q", labels[0])
+	}
+	addr := labels[1:]
+	slices.Reverse(addr)
+
+	var text string
+	if len(addr) == 4 && !slices.Contains(addr, "zz") {
+		text = strings.Join(addr, ".")
+	} else if i := slices.Index(addr, "zz"); i >= 0 {
+		text = strings.Join(addr[:i], ":") + "::" + strings.Join(addr[i+1:], ":")
+	} else {
+		text = strings.Join(addr, ":")
+	}
+	ip, err := netip.ParseAddr(text)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("invalid rpz-ip address %q", s)
+	}
+	return ip.Prefix(bits)
+}
+
+// finalize 在加载完成后整理 RPZ-IP 前缀长度，IPv4 统一映射到 IPv6 空间比较
+func (z *rpzZone) finalize() *rpzZone {
+	seen := make(map[int]bool)
+	ipRules := make(map[netip.Prefix]*rpzRule, len(z.ipRules))
+	for p, r := range z.ipRules {
+		p = mappedPrefix(p)
+		ipRules[p] = r
+		if !seen[p.Bits()] {
+			seen[p.Bits()] = true
+			z.ipBits = append(z.ipBits, p.Bits())
+		}
+	}
+	z.ipRules = ipRules
+	slices.Sort(z.ipBits)
+	slices.Reverse(z.ipBits)
+	return z
+}
+
+func mappedPrefix(p netip.Prefix) netip.Prefix {
+	if p.Addr().Is4() {
+		return netip.PrefixFrom(netip.AddrFrom16(p.Addr().As16()), p.Bits()+96)
+	}
+	return p
+}
+
+// matchQName 返回区域内对域名生效的 QNAME 触发器
+func (z *rpzZone) matchQName(domain string) *rpzRule {
+	if r := z.exact[domain]; r != nil {
+		return r
+	}
+	for rest := domain; ; {
+		idx := strings.IndexByte(rest, '.')
+		if idx < 0 {
+			return z.wildcard[""]
+		}
+		rest = rest[idx+1:]
+		if r := z.wildcard[rest]; r != nil {
+			return r
+		}
+	}
+}
+
+// matchIP 返回最长前缀命中的 RPZ-IP 触发器
+func (z *rpzZone) matchIP(ip netip.Addr) *rpzRule {
+	if len(z.ipBits) == 0 {
+		return nil
+	}
+	ip16 := netip.AddrFrom16(ip.As16())
+	for _, bits := range z.ipBits {
+		p, err := ip16.Prefix(bits)
+		if err != nil {
+			continue
+		}
+		if r := z.ipRules[p]; r != nil {
+			return r
+		}
+	}
+	return nil
+}
+
+// SetZone 替换（或按优先级追加）一个区域的内容，priority 越小优先级越高
+// gen 与当前配置版本不一致时（同步任务属于已被替换的配置）丢弃并返回 false
+func (e *RPZEngine) SetZone(gen uint64, priority int, name string, rrs []dns.RR) bool {
+	z := newRPZZone(name, rrs).finalize()
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if gen != e.gen {
+		return false
+	}
+	for len(e.zones) <= priority {
+		e.zones = append(e.zones, nil)
+	}
+	e.zones[priority] = z
+	return true
+}
+
+// Reset 清空所有区域并返回新的配置版本，区域配置变化时使用：
+// 同一优先级上的新区域首次同步失败时，不能继续沿用旧区域的策略
+func (e *RPZEngine) Reset() uint64 {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.zones = nil
+	e.gen++
+	return e.gen
+}
+
+// HasIPTriggers 返回是否有区域包含 RPZ-IP 触发器，没有时无需检查应答
+func (e *RPZEngine) HasIPTriggers() bool {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return slices.ContainsFunc(e.zones, func(z *rpzZone) bool { return z != nil && len(z.ipBits) > 0 })
+}
+
+// CheckHost implements the FilterEngine interface.
+func (e *RPZEngine) CheckHost(domain string) (MatchResult, string) {
+	res := e.Check(&Request{Host: domain})
+	if res.Rewrite != nil {
+		return MatchBlocked, res.Rule
+	}
+	return res.Match, res.Rule
+}
+
+// Check implements the FilterEngine interface: 按优先级检查 QNAME 触发器
+func (e *RPZEngine) Check(req *Request) Result {
+	domain := strings.ToLower(strings.TrimSuffix(req.Host, "."))
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	for _, z := range e.zones {
+		if z == nil {
+			continue
+		}
+		if r := z.matchQName(domain); r != nil {
+			return r.result(req)
+		}
+	}
+	return Result{Cacheable: true}
+}
+
+// CheckResponse 按优先级检查应答中的地址（RPZ-IP 触发器）
+// 高优先级区域中的 QNAME PASSTHRU 会终止检查，其余 QNAME 触发器在解析前已经处理
+func (e *RPZEngine) CheckResponse(req *Request, ips []netip.Addr) Result {
+	domain := strings.ToLower(strings.TrimSuffix(req.Host, "."))
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	for _, z := range e.zones {
+		if z == nil {
+			continue
+		}
+		if r := z.matchQName(domain); r != nil && r.action == rpzPassthru {
+			return Result{Match: MatchAllowed, Rule: r.text}
+		}
+		for _, ip := range ips {
+			if r := z.matchIP(ip); r != nil {
+				return r.result(req)
+			}
+		}
+	}
+	return Result{}
+}
+
+// result 将策略动作转换为过滤结果
+func (r *rpzRule) result(req *Request) Result {
+	res := Result{Rule: r.text, Cacheable: true}
+	switch r.action {
+	case rpzPassthru:
+		res.Match = MatchAllowed
+	case rpzNXDomain:
+		res.Match = MatchBlocked
+		res.Rewrite = &Rewrite{RCode: dns.RcodeNameError, Rules: []string{r.text}}
+	case rpzNoData:
+		res.Match = MatchBlocked
+		res.Rewrite = &Rewrite{RCode: dns.RcodeSuccess, Rules: []string{r.text}}
+	case rpzDrop:
+		res.Match = MatchBlocked
+		res.Rewrite = &Rewrite{Drop: true, Rules: []string{r.text}}
+	case rpzTCPOnly:
+		// 结果取决于传输协议，不能按域名缓存
+		res.Cacheable = false
+		if req.TCP {
+			res.Match = MatchAllowed
+		} else {
+			res.Rewrite = &Rewrite{Truncate: true, Rules: []string{r.text}}
+		}
+	case rpzLocalData:
+		res.Rewrite = r.localData(req)
+	}
+	return res
+}
+
+// localData 按查询类型选取本地数据；CNAME 优先，目标以 *. 开头时拼接查询名（RFC 草案中的 wildcard 本地数据）
+func (r *rpzRule) localData(req *Request) *Rewrite {
+	rw := &Rewrite{RCode: dns.RcodeSuccess, Rules: []string{r.text}}
+	for _, rr := range r.data {
+		if cname, ok := rr.(*dns.CNAME); ok {
+			target := cname.Target
+			if rest, ok := strings.CutPrefix(target, "*."); ok {
+				target = dns.Fqdn(strings.TrimSuffix(req.Host, ".")) + rest
+			}
+			rw.CNAME = target
+			rw.Answer = nil
+			return rw
+		}
+		if rr.Header().Rrtype == req.QType || req.QType == dns.TypeANY {
+			rw.Answer = append(rw.Answer, rr)
+		}
+	}
+	return rw
+}
+
+// LoadRules implements the FilterEngine interface.
+// 规则文本按区域文件格式解析为单个区域，区域名取自 SOA 记录，替换所有已加载的区域
+func (e *RPZEngine) LoadRules(rules []string) error {
+	name, rrs, err := parseRPZZoneText(strings.Join(rules, "\n"), "")
+	if err != nil {
+		return err
+	}
+	z := newRPZZone(name, rrs).finalize()
+	e.mu.Lock()
+	e.zones = []*rpzZone{z}
+	e.mu.Unlock()
+	return nil
+}
+
+// parseRPZZoneText 解析区域文件文本，origin 为空时使用第一条 SOA 的 owner 作为区域名
+func parseRPZZoneText(text, origin string) (string, []dns.RR, error) {
+	zp := dns.NewZoneParser(strings.NewReader(text), dns.Fqdn(origin), "")
+	zp.SetIncludeAllowed(false)
+	var rrs []dns.RR
+	for rr, ok := zp.Next(); ok; rr, ok = zp.Next() {
+		if origin == "" && rr.Header().Rrtype == dns.TypeSOA {
+			origin = rr.Header().Name
+		}
+		rrs = append(rrs, rr)
+	}
+	if err := zp.Err(); err != nil {
+		return "", nil, fmt.Errorf("invalid rpz zone: %w", err)
+	}
+	if origin == "" || origin == "." {
+		return "", nil, fmt.Errorf("invalid rpz zone: missing SOA record")
+	}
+	return origin, rrs, nil
+}
+
+// Count implements the FilterEngine interface.
+func (e *RPZEngine) Count() int {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	n := 0
+	for _, z := range e.zones {
+		if z != nil {
+			n += z.count
+		}
+	}
+	return n
+}
+
+// Close implements the FilterEngine interface.
+func (e *RPZEngine) Close() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.zones = nil
+	return nil
+}
@@ -0,0 +1,223 @@
+package adblock
+
+import (
+	"net"
+	"net/netip"
+	"smartdnssort/config"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+const testRPZZone = `
+$ORIGIN rpz.example.
+$TTL 300
+@                          SOA  ns.rpz.example. admin.rpz.example. 1 3600 600 86400 60
+@                          NS   ns.rpz.example.
+nx.example                 CNAME .
+nodata.example             CNAME *.
+*.wild.example             CNAME .
+ok.wild.example            CNAME rpz-passthru.
+drop.example               CNAME rpz-drop.
+tcp.example                CNAME rpz-tcp-only.
+local.example              A    192.0.2.80
+local.example              AAAA 2001:db8::80
+alias.example              CNAME *.walled.example.
+24.0.2.0.192.rpz-ip        CNAME .
+32.5.2.0.192.rpz-ip        CNAME rpz-passthru.
+48.zz.db8.2001.rpz-ip      CNAME *.
+32.1.1.168.192.rpz-client-ip CNAME .
+`
+
+func newTestRPZEngine(t *testing.T) *RPZEngine {
+	t.Helper()
+	e := NewRPZEngine()
+	if err := e.LoadRules(strings.Split(testRPZZone, "\n")); err != nil {
+		t.Fatalf("LoadRules: %v", err)
+	}
+	return e
+}
+
+func TestRPZQNameActions(t *testing.T) {
+	e := newTestRPZEngine(t)
+
+	tests := []struct {
+		host  string
+		qtype uint16
+		tcp   bool
+		match MatchResult
+		check func(*Rewrite) bool
+	}{
+		{"nx.example", dns.TypeA, false, MatchBlocked, func(rw *Rewrite) bool { return rw.RCode == dns.RcodeNameError }},
+		{"nodata.example", dns.TypeA, false, MatchBlocked, func(rw *Rewrite) bool { return rw.RCode == dns.RcodeSuccess && len(rw.Answer) == 0 }},
+		{"a.b.wild.example", dns.TypeA, false, MatchBlocked, func(rw *Rewrite) bool { return rw.RCode == dns.RcodeNameError }},
+		{"ok.wild.example", dns.TypeA, false, MatchAllowed, nil},
+		{"drop.example", dns.TypeA, false, MatchBlocked, func(rw *Rewrite) bool { return rw.Drop }},
+		{"tcp.example", dns.TypeA, false, MatchNeutral, func(rw *Rewrite) bool { return rw.Truncate }},
+		{"tcp.example", dns.TypeA, true, MatchAllowed, nil},
+		{"Local.Example.", dns.TypeAAAA, false, MatchNeutral, func(rw *Rewrite) bool {
+			return len(rw.Answer) == 1 && rw.Answer[0].(*dns.AAAA).AAAA.String() == "2001:db8::80"
+		}},
+		{"local.example", dns.TypeMX, false, MatchNeutral, func(rw *Rewrite) bool { return len(rw.Answer) == 0 && rw.CNAME == "" }},
+		{"alias.example", dns.TypeA, false, MatchNeutral, func(rw *Rewrite) bool { return rw.CNAME == "alias.example.walled.example." }},
+		{"wild.example", dns.TypeA, false, MatchNeutral, nil},
+		{"other.example", dns.TypeA, false, MatchNeutral, nil},
+	}
+	for _, tt := range tests {
+		res := e.Check(&Request{Host: tt.host, QType: tt.qtype, TCP: tt.tcp})
+		if res.Match != tt.match {
+			t.Errorf("%s: match = %v, want %v", tt.host, res.Match, tt.match)
+		}
+		if (tt.check == nil) != (res.Rewrite == nil) || (tt.check != nil && !tt.check(res.Rewrite)) {
+			t.Errorf("%s: unexpected rewrite %+v", tt.host, res.Rewrite)
+		}
+	}
+
+	if res := e.Check(&Request{Host: "tcp.example", QType: dns.TypeA}); res.Cacheable {
+		t.Error("tcp-only results depend on the transport and must not be cacheable")
+	}
+	// 8 个 QNAME 触发器 + 3 个 RPZ-IP 触发器，rpz-client-ip 不支持
+	if got := e.Count(); got != 11 {
+		t.Errorf("Count() = %d, want 11", got)
+	}
+}
+
+func TestRPZResponseIP(t *testing.T) {
+	e := newTestRPZEngine(t)
+	if !e.HasIPTriggers() {
+		t.Fatal("zone with rpz-ip owners should report IP triggers")
+	}
+	req := &Request{Host: "site.example", QType: dns.TypeA}
+
+	res := e.CheckResponse(req, []netip.Addr{netip.MustParseAddr("198.51.100.1"), netip.MustParseAddr("192.0.2.9")})
+	if res.Rewrite == nil || res.Rewrite.RCode != dns.RcodeNameError {
+		t.Errorf("192.0.2.0/24 should trigger NXDOMAIN, got %+v", res)
+	}
+	// 最长前缀优先：192.0.2.5/32 为 PASSTHRU
+	if res := e.CheckResponse(req, []netip.Addr{netip.MustParseAddr("192.0.2.5")}); res.Match != MatchAllowed || res.Rewrite != nil {
+		t.Errorf("longest prefix passthru should win, got %+v", res)
+	}
+	res = e.CheckResponse(&Request{Host: "site.example", QType: dns.TypeAAAA}, []netip.Addr{netip.MustParseAddr("2001:db8:0:1::1")})
+	if res.Rewrite == nil || res.Rewrite.RCode != dns.RcodeSuccess || len(res.Rewrite.Answer) != 0 {
+		t.Errorf("2001:db8::/48 should trigger NODATA, got %+v", res)
+	}
+	if res := e.CheckResponse(req, []netip.Addr{netip.MustParseAddr("203.0.113.1")}); res.Match != MatchNeutral || res.Rewrite != nil {
+		t.Errorf("unlisted address should be neutral, got %+v", res)
+	}
+	// QNAME PASSTHRU 豁免应答地址检查
+	if res := e.CheckResponse(&Request{Host: "ok.wild.example"}, []netip.Addr{netip.MustParseAddr("192.0.2.9")}); res.Rewrite != nil {
+		t.Errorf("qname passthru should exempt the response, got %+v", res)
+	}
+}
+
+func TestRPZZonePriority(t *testing.T) {
+	parse := func(text string) (string, []dns.RR) {
+		name, rrs, err := parseRPZZoneText(text, "")
+		if err != nil {
+			t.Fatalf("parse: %v", err)
+		}
+		return name, rrs
+	}
+	e := NewRPZEngine()
+	name, rrs := parse("$ORIGIN allow.rpz.\n@ 60 SOA ns. admin. 1 60 60 60 60\nsafe.example 60 CNAME rpz-passthru.\n")
+	e.SetZone(0, 0, name, rrs)
+	name, rrs = parse("$ORIGIN block.rpz.\n@ 60 SOA ns. admin. 1 60 60 60 60\n*.example 60 CNAME .\n")
+	e.SetZone(0, 1, name, rrs)
+
+	if res := e.Check(&Request{Host: "safe.example", QType: dns.TypeA}); res.Match != MatchAllowed {
+		t.Errorf("higher priority passthru should win, got %+v", res)
+	}
+	if res := e.Check(&Request{Host: "bad.example", QType: dns.TypeA}); res.Match != MatchBlocked {
+		t.Errorf("lower priority zone should still apply, got %+v", res)
+	}
+
+	gen := e.Reset()
+	if res := e.Check(&Request{Host: "bad.example", QType: dns.TypeA}); res.Match != MatchNeutral {
+		t.Errorf("reset should remove every zone, got %+v", res)
+	}
+	if e.SetZone(gen-1, 0, name, rrs) {
+		t.Error("zones from a replaced configuration should be rejected")
+	}
+	if !e.SetZone(gen, 0, name, rrs) || e.Check(&Request{Host: "bad.example", QType: dns.TypeA}).Match != MatchBlocked {
+		t.Error("zones from the current configuration should apply")
+	}
+}
+
+// rpzTestPrimary 一个只支持 AXFR/IXFR 的最小主服务器，IXFR 从 serial 1 增量到 serial 2
+type rpzTestPrimary struct {
+	serial uint32
+}
+
+func (p *rpzTestPrimary) ServeDNS(w dns.ResponseWriter, r *dns.Msg) {
+	rr := func(s string) dns.RR {
+		v, err := dns.NewRR(s)
+		if err != nil {
+			panic(err)
+		}
+		return v
+	}
+	soa := func(serial uint32) dns.RR {
+		return &dns.SOA{Hdr: dns.RR_Header{Name: "rpz.test.", Rrtype: dns.TypeSOA, Class: dns.ClassINET, Ttl: 60},
+			Ns: "ns.rpz.test.", Mbox: "admin.rpz.test.", Serial: serial, Refresh: 60, Retry: 60, Expire: 60, Minttl: 60}
+	}
+
+	var rrs []dns.RR
+	if r.Question[0].Qtype == dns.TypeIXFR && p.serial == 2 {
+		rrs = []dns.RR{soa(2),
+			soa(1), rr("old.example.rpz.test. 60 CNAME ."),
+			soa(2), rr("new.example.rpz.test. 60 CNAME ."),
+			soa(2)}
+	} else {
+		rrs = []dns.RR{soa(p.serial), rr("old.example.rpz.test. 60 CNAME ."), soa(p.serial)}
+	}
+
+	ch := make(chan *dns.Envelope, 1)
+	tr := new(dns.Transfer)
+	go func() {
+		ch <- &dns.Envelope{RR: rrs}
+		close(ch)
+	}()
+	_ = tr.Out(w, r, ch)
+	w.Hijack()
+}
+
+func TestRPZSourceTransfer(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("cannot listen: %v", err)
+	}
+	primary := &rpzTestPrimary{serial: 1}
+	srv := &dns.Server{Listener: ln, Handler: primary}
+	go srv.ActivateAndServe()
+	defer srv.Shutdown()
+
+	e := NewRPZEngine()
+	changes := 0
+	src := newRPZSource(config.RPZZoneConfig{Name: "rpz.test", Primary: ln.Addr().String()}, 0, 0, e, func() { changes++ })
+	if err := src.sync(); err != nil {
+		t.Fatalf("AXFR sync: %v", err)
+	}
+	if res := e.Check(&Request{Host: "old.example"}); res.Match != MatchBlocked {
+		t.Fatalf("AXFR zone should block old.example, got %+v", res)
+	}
+
+	primary.serial = 2
+	if err := src.sync(); err != nil {
+		t.Fatalf("IXFR sync: %v", err)
+	}
+	if src.serial != 2 {
+		t.Errorf("serial = %d, want 2", src.serial)
+	}
+	if res := e.Check(&Request{Host: "old.example"}); res.Match != MatchNeutral {
+		t.Errorf("IXFR should delete old.example, got %+v", res)
+	}
+	if res := e.Check(&Request{Host: "new.example"}); res.Match != MatchBlocked {
+		t.Errorf("IXFR should add new.example, got %+v", res)
+	}
+
+	// 每次替换区域都要清空拦截/白名单缓存
+	if changes != 2 {
+		t.Errorf("cache invalidations = %d, want 2", changes)
+	}
+}
@@ -0,0 +1,265 @@
+package adblock
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"smartdnssort/config"
+	"smartdnssort/logger"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	rpzFileRefresh = 5 * time.Minute
+	rpzMinRefresh  = time.Minute
+	rpzMaxRefresh  = 24 * time.Hour
+	rpzRetryDelay  = time.Minute
+	rpzXfrTimeout  = 30 * time.Second
+	rpzDefaultTSIG = dns.HmacSHA256
+)
+
+// ValidateRPZZones 校验 RPZ 区域配置，供配置校验使用
+func ValidateRPZZones(zones []config.RPZZoneConfig) error {
+	for i, z := range zones {
+		if strings.Trim(strings.TrimSpace(z.Name), ".") == "" {
+			return fmt.Errorf("rpz zone %d: name is required", i)
+		}
+		if (z.File == "") == (z.Primary == "") {
+			return fmt.Errorf("rpz zone %s: exactly one of file or primary is required", z.Name)
+		}
+		if (z.TSIGKeyName == "") != (z.TSIGSecret == "") {
+			return fmt.Errorf("rpz zone %s: tsig_key_name and tsig_secret must be set together", z.Name)
+		}
+		if z.RefreshSeconds < 0 {
+			return fmt.Errorf("rpz zone %s: refresh_seconds cannot be negative", z.Name)
+		}
+	}
+	return nil
+}
+
+// rpzSource 一个 RPZ 区域的同步状态：本地文件按修改时间重新加载，主服务器优先 IXFR，失败时回退 AXFR
+type rpzSource struct {
+	cfg      config.RPZZoneConfig
+	priority int
+	gen      uint64 // 创建时的区域配置版本
+	engine   *RPZEngine
+	onChange func() // 区域内容替换后调用，用于清空拦截/白名单缓存
+
+	serial  uint32
+	refresh time.Duration     // SOA 中的 refresh，配置了 refresh_seconds 时不使用
+	records map[string]dns.RR // 当前区域内容，用于应用 IXFR 增量
+	modTime time.Time
+}
+
+func newRPZSource(cfg config.RPZZoneConfig, priority int, gen uint64, engine *RPZEngine, onChange func()) *rpzSource {
+	return &rpzSource{cfg: cfg, priority: priority, gen: gen, engine: engine, onChange: onChange}
+}
+
+// run 加载区域并按刷新间隔持续同步，直到 ctx 取消
+func (src *rpzSource) run(ctx context.Context) {
+	for {
+		delay := src.interval()
+		if err := src.sync(); err != nil {
+			logger.Warnf("[RPZ] Failed to sync zone %s: %v", src.cfg.Name, err)
+			delay = min(delay, rpzRetryDelay)
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		}
+	}
+}
+
+func (src *rpzSource) interval() time.Duration {
+	switch {
+	case src.cfg.RefreshSeconds > 0:
+		return time.Duration(src.cfg.RefreshSeconds) * time.Second
+	case src.cfg.File != "":
+		return rpzFileRefresh
+	case src.refresh > 0:
+		return min(max(src.refresh, rpzMinRefresh), rpzMaxRefresh)
+	default:
+		return rpzMinRefresh
+	}
+}
+
+// sync 拉取区域的最新内容，有变化时替换引擎中对应优先级的区域
+func (src *rpzSource) sync() error {
+	var changed bool
+	var err error
+	if src.cfg.File != "" {
+		changed, err = src.loadFile()
+	} else {
+		changed, err = src.transfer()
+	}
+	if err != nil || !changed {
+		return err
+	}
+
+	rrs := make([]dns.RR, 0, len(src.records))
+	for _, rr := range src.records {
+		rrs = append(rrs, rr)
+	}
+	if !src.engine.SetZone(src.gen, src.priority, src.cfg.Name, rrs) {
+		return nil // 配置已更换，本任务即将退出
+	}
+	if src.onChange != nil {
+		src.onChange()
+	}
+	logger.Infof("[RPZ] Zone %s loaded: serial %d, %d records", src.cfg.Name, src.serial, len(rrs))
+	return nil
+}
+
+func (src *rpzSource) loadFile() (bool, error) {
+	info, err := os.Stat(src.cfg.File)
+	if err != nil {
+		return false, err
+	}
+	if src.records != nil && info.ModTime().Equal(src.modTime) {
+		return false, nil
+	}
+	data, err := os.ReadFile(src.cfg.File)
+	if err != nil {
+		return false, err
+	}
+	_, rrs, err := parseRPZZoneText(string(data), src.cfg.Name)
+	if err != nil {
+		return false, err
+	}
+	src.modTime = info.ModTime()
+	src.replace(rrs)
+	return true, nil
+}
+
+// transfer 已有区域内容时先尝试 IXFR，主服务器不支持或增量无法应用时回退到 AXFR
+func (src *rpzSource) transfer() (bool, error) {
+	if src.records != nil {
+		changed, err := src.xfr(true)
+		if err == nil {
+			return changed, nil
+		}
+		logger.Debugf("[RPZ] IXFR for %s failed, falling back to AXFR: %v", src.cfg.Name, err)
+	}
+	return src.xfr(false)
+}
+
+func (src *rpzSource) xfr(incremental bool) (bool, error) {
+	zone := dns.Fqdn(strings.ToLower(src.cfg.Name))
+	m := new(dns.Msg)
+	if incremental {
+		m.SetIxfr(zone, src.serial, ".", ".")
+	} else {
+		m.SetAxfr(zone)
+	}
+
+	t := &dns.Transfer{DialTimeout: rpzXfrTimeout, ReadTimeout: rpzXfrTimeout}
+	if src.cfg.TSIGKeyName != "" {
+		keyName := dns.CanonicalName(src.cfg.TSIGKeyName)
+		alg := rpzDefaultTSIG
+		if src.cfg.TSIGAlgorithm != "" {
+			alg = dns.Fqdn(strings.ToLower(src.cfg.TSIGAlgorithm))
+		}
+		m.SetTsig(keyName, alg, 300, time.Now().Unix())
+		t.TsigSecret = map[string]string{keyName: src.cfg.TSIGSecret}
+	}
+
+	env, err := t.In(m, src.cfg.Primary)
+	if err != nil {
+		return false, err
+	}
+	var rrs []dns.RR
+	for e := range env {
+		if e.Error != nil {
+			return false, e.Error
+		}
+		rrs = append(rrs, e.RR...)
+	}
+	if len(rrs) == 0 {
+		return false, fmt.Errorf("empty transfer response")
+	}
+	soa, ok := rrs[0].(*dns.SOA)
+	if !ok {
+		return false, fmt.Errorf("transfer response does not start with SOA")
+	}
+
+	switch {
+	case len(rrs) == 1:
+		// IXFR：区域未变化时只返回当前 SOA
+		if soa.Serial != src.serial {
+			return false, fmt.Errorf("unexpected single SOA with serial %d (have %d)", soa.Serial, src.serial)
+		}
+		src.refresh = time.Duration(soa.Refresh) * time.Second
+		return false, nil
+	case !incremental || !isSOA(rrs[1]):
+		// AXFR，或主服务器以完整区域回应 IXFR
+		src.replace(rrs[:len(rrs)-1])
+	default:
+		if err := src.applyIXFR(rrs); err != nil {
+			return false, err
+		}
+	}
+	src.refresh = time.Duration(soa.Refresh) * time.Second
+	return true, nil
+}
+
+// applyIXFR 应用增量传送（RFC 1995）：新 SOA 之后依次是 旧SOA 删除记录... 新SOA 添加记录...，以新 SOA 结束
+func (src *rpzSource) applyIXFR(rrs []dns.RR) error {
+	newSOA := rrs[0].(*dns.SOA)
+	if last, ok := rrs[len(rrs)-1].(*dns.SOA); !ok || last.Serial != newSOA.Serial {
+		return fmt.Errorf("incomplete IXFR response")
+	}
+	if old := rrs[1].(*dns.SOA); old.Serial != src.serial {
+		return fmt.Errorf("IXFR starts at serial %d, have %d", old.Serial, src.serial)
+	}
+
+	// 每遇到一个 SOA 在“删除”与“添加”之间切换，第一个（旧 SOA）开始删除段
+	adding := true
+	for _, rr := range rrs[1 : len(rrs)-1] {
+		if isSOA(rr) {
+			adding = !adding
+			continue
+		}
+		if adding {
+			src.records[rrKey(rr)] = rr
+		} else {
+			delete(src.records, rrKey(rr))
+		}
+	}
+	src.records[rrKey(newSOA)] = newSOA
+	src.serial = newSOA.Serial
+	return nil
+}
+
+// replace 用完整的区域内容替换当前状态
+func (src *rpzSource) replace(rrs []dns.RR) {
+	src.records = make(map[string]dns.RR, len(rrs))
+	for _, rr := range rrs {
+		if soa, ok := rr.(*dns.SOA); ok {
+			src.serial = soa.Serial
+		}
+		src.records[rrKey(rr)] = rr
+	}
+}
+
+func isSOA(rr dns.RR) bool {
+	return rr.Header().Rrtype == dns.TypeSOA
+}
+
+// rrKey 以不含 TTL 的表示格式标识一条记录，IXFR 删除时按此匹配
+func rrKey(rr dns.RR) string {
+	if isSOA(rr) {
+		return "SOA"
+	}
+	cp := dns.Copy(rr)
+	cp.Header().Ttl = 0
+	cp.Header().Name = strings.ToLower(cp.Header().Name)
+	return cp.String()
+}
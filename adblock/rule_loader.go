@@ -209,6 +209,20 @@ func countLinesFromFile(path string) (int, error) {
 	return CountValidRules(path)
 }
 
+// sourcePath 返回规则源的本地文件：优先使用缓存文件，本地规则源回退到其自身路径
+// 远程规则源尚未下载时返回 false
+func (rl *RuleLoader) sourcePath(source *SourceInfo) (string, bool) {
+	cachePath := filepath.Join(rl.cacheDir, source.CacheFile)
+	if _, err := os.Stat(cachePath); os.IsNotExist(err) {
+		// if a local file, the path is the URL
+		if IsLocalFile(source.URL) {
+			return GetLocalFilePath(source.URL), true
+		}
+		return cachePath, false
+	}
+	return cachePath, true
+}
+
 // LoadAllRules reads all rules from a list of cache files.
 // Custom rules (local files) are loaded first to ensure higher priority.
 func (rl *RuleLoader) LoadAllRules(sources []*SourceInfo) ([]string, error) {
@@ -222,18 +236,12 @@ func (rl *RuleLoader) LoadAllRules(sources []*SourceInfo) ([]string, error) {
 			continue
 		}
 
-		// Check if cache file exists
-		cachePath := filepath.Join(rl.cacheDir, source.CacheFile)
-		if _, err := os.Stat(cachePath); os.IsNotExist(err) {
-			// if a local file, the path is the URL
-			if IsLocalFile(source.URL) {
-				cachePath = GetLocalFilePath(source.URL)
-			} else {
-				// Cache file doesn't exist and it's not a local file
-				// This is a critical error - the source should have been downloaded first
-				loadErrors = append(loadErrors, fmt.Sprintf("cache file missing for source %s: %s", source.URL, cachePath))
-				continue
-			}
+		cachePath, ok := rl.sourcePath(source)
+		if !ok {
+			// Cache file doesn't exist and it's not a local file
+			// This is a critical error - the source should have been downloaded first
+			loadErrors = append(loadErrors, fmt.Sprintf("cache file missing for source %s: %s", source.URL, cachePath))
+			continue
 		}
 
 		rules, err := ReadValidRules(cachePath)
@@ -0,0 +1,377 @@
+package adblock
+
+import (
+	"bufio"
+	"container/heap"
+	"hash/fnv"
+	"net/netip"
+	"os"
+	"slices"
+	"smartdnssort/logger"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// RuleStatsDays 规则命中统计保留的天数（按天分桶）
+	RuleStatsDays = 30
+	// ruleStatsMaxPerDay 每个日桶最多记录的不同规则数，超出后新规则的命中被忽略
+	ruleStatsMaxPerDay = 50000
+)
+
+// RuleStats 按规则记录拦截命中，并按规则源统计命中分布、从未命中的规则比例与列表重叠
+// 规则源只保存规则键的 64 位哈希（已排序），百万级列表也只占用数 MB
+type RuleStats struct {
+	mu      sync.RWMutex
+	buckets [RuleStatsDays]ruleHitBucket
+	since   time.Time
+
+	indexMu sync.Mutex // 串行化重建索引
+	sources map[string][]uint64
+}
+
+type ruleHitBucket struct {
+	day  int64 // 本地日期对应的天数，用于判断桶是否过期
+	hits map[uint64]*ruleHit
+}
+
+type ruleHit struct {
+	rule  string
+	count int64
+}
+
+// RuleHitCount 单条规则的命中次数
+type RuleHitCount struct {
+	Rule string `json:"rule"`
+	Hits int64  `json:"hits"`
+}
+
+// SourceOverlap 与另一个规则源共有的规则
+type SourceOverlap struct {
+	URL         string  `json:"url"`
+	SharedRules int     `json:"shared_rules"`
+	Ratio       float64 `json:"ratio"` // 共有规则占本规则源规则数的比例
+}
+
+// SourceRuleStats 单个规则源在统计窗口内的命中情况
+type SourceRuleStats struct {
+	URL           string          `json:"url"`
+	Days          int             `json:"days"`
+	TrackedSince  time.Time       `json:"tracked_since"` // 统计开始时间，早于窗口起点时窗口内的数据才完整
+	TotalRules    int             `json:"total_rules"`
+	Hits          int64           `json:"hits"`
+	HitRules      int             `json:"hit_rules"`
+	NeverHitRatio float64         `json:"never_hit_ratio"`
+	TopRules      []RuleHitCount  `json:"top_rules"`
+	Overlap       []SourceOverlap `json:"overlap"`
+}
+
+// NewRuleStats creates an empty rule hit tracker.
+func NewRuleStats() *RuleStats {
+	return &RuleStats{since: time.Now(), sources: make(map[string][]uint64)}
+}
+
+func dayNumber(t time.Time) int64 {
+	y, m, d := t.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC).Unix() / 86400
+}
+
+// ruleKeys 把规则归一化为用于比对的键：小写、去掉 simple 引擎的类别前缀；
+// hosts 规则按每个域名生成一个键，与纯域名规则等价
+func ruleKeys(rule string) []string {
+	rule = strings.ToLower(strings.TrimSpace(rule))
+	for _, prefix := range []string{"blacklist: ", "hosts: ", "adblock: ", "regex: "} {
+		if after, ok := strings.CutPrefix(rule, prefix); ok {
+			rule = after
+			break
+		}
+	}
+	if rule == "" || strings.HasPrefix(rule, "!") || strings.HasPrefix(rule, "#") {
+		return nil
+	}
+	if !strings.HasPrefix(rule, "/") && strings.ContainsAny(rule, " \t") {
+		fields := strings.Fields(rule)
+		if _, err := netip.ParseAddr(fields[0]); err == nil {
+			var keys []string
+			for _, d := range fields[1:] {
+				if strings.HasPrefix(d, "#") {
+					break
+				}
+				keys = append(keys, d)
+			}
+			return keys
+		}
+	}
+	return []string{rule}
+}
+
+func ruleHash(key string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	return h.Sum64()
+}
+
+// Record 记录一次命中；hosts 规则优先记为与被拦截域名一致的那个键
+func (rs *RuleStats) Record(domain, rule string) {
+	keys := ruleKeys(rule)
+	if len(keys) == 0 {
+		return
+	}
+	key := keys[0]
+	domain = strings.ToLower(strings.TrimSuffix(domain, "."))
+	if len(keys) > 1 && slices.Contains(keys, domain) {
+		key = domain
+	}
+	h := ruleHash(key)
+
+	now := time.Now()
+	day := dayNumber(now)
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	b := &rs.buckets[day%RuleStatsDays]
+	if b.day != day || b.hits == nil {
+		b.day = day
+		b.hits = make(map[uint64]*ruleHit)
+	}
+	if hit, ok := b.hits[h]; ok {
+		hit.count++
+		return
+	}
+	if len(b.hits) < ruleStatsMaxPerDay {
+		b.hits[h] = &ruleHit{rule: key, count: 1}
+	}
+}
+
+// SetSource 替换规则源的规则键哈希（须已排序去重），hashes 为 nil 时移除该规则源
+func (rs *RuleStats) SetSource(url string, hashes []uint64) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	if hashes == nil {
+		delete(rs.sources, url)
+		return
+	}
+	rs.sources[url] = hashes
+}
+
+// Reset 清空命中记录，规则源索引保留
+func (rs *RuleStats) Reset() {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	rs.buckets = [RuleStatsDays]ruleHitBucket{}
+	rs.since = time.Now()
+}
+
+// windowHits 汇总最近 days 天（含今天）的命中
+func (rs *RuleStats) windowHits(days int) map[uint64]*ruleHit {
+	today := dayNumber(time.Now())
+	agg := make(map[uint64]*ruleHit)
+	for i := range rs.buckets {
+		b := &rs.buckets[i]
+		if b.hits == nil || b.day <= today-int64(days) || b.day > today {
+			continue
+		}
+		for h, hit := range b.hits {
+			if a, ok := agg[h]; ok {
+				a.count += hit.count
+			} else {
+				agg[h] = &ruleHit{rule: hit.rule, count: hit.count}
+			}
+		}
+	}
+	return agg
+}
+
+// sourceContains 判断规则源是否包含该规则；simple 引擎按查询域名报告 ||domain^，
+// 精确键不存在时依次尝试其父域，返回规则源中实际匹配的键
+func sourceContains(hashes []uint64, rule string) (string, bool) {
+	if _, ok := slices.BinarySearch(hashes, ruleHash(rule)); ok {
+		return rule, true
+	}
+	domain, ok := strings.CutPrefix(rule, "||")
+	if !ok || !strings.HasSuffix(domain, "^") {
+		return "", false
+	}
+	domain = strings.TrimSuffix(domain, "^")
+	for {
+		_, parent, found := strings.Cut(domain, ".")
+		if !found || !strings.Contains(parent, ".") {
+			return "", false
+		}
+		key := "||" + parent + "^"
+		if _, ok := slices.BinarySearch(hashes, ruleHash(key)); ok {
+			return key, true
+		}
+		domain = parent
+	}
+}
+
+// SourceStats 统计规则源在最近 days 天内的命中，返回命中最多的 top 条规则；规则源未建立索引时返回 false
+func (rs *RuleStats) SourceStats(url string, days, top int) (SourceRuleStats, bool) {
+	days = min(max(days, 1), RuleStatsDays)
+
+	rs.mu.RLock()
+	hashes, ok := rs.sources[url]
+	if !ok {
+		rs.mu.RUnlock()
+		return SourceRuleStats{}, false
+	}
+	hits := rs.windowHits(days)
+	others := make(map[string][]uint64, len(rs.sources))
+	for u, h := range rs.sources {
+		if u != url {
+			others[u] = h
+		}
+	}
+	since := rs.since
+	rs.mu.RUnlock()
+
+	st := SourceRuleStats{URL: url, Days: days, TrackedSince: since, TotalRules: len(hashes)}
+
+	// 同一条源规则可能由多个报告键（不同子域名）命中，按源规则合并
+	perRule := make(map[string]int64)
+	for _, hit := range hits {
+		if key, ok := sourceContains(hashes, hit.rule); ok {
+			perRule[key] += hit.count
+			st.Hits += hit.count
+		}
+	}
+	st.HitRules = len(perRule)
+	if st.TotalRules > 0 {
+		st.NeverHitRatio = 1 - float64(st.HitRules)/float64(st.TotalRules)
+	}
+	st.TopRules = topRuleHits(perRule, top)
+
+	for u, h := range others {
+		shared := countShared(hashes, h)
+		if shared == 0 {
+			continue
+		}
+		st.Overlap = append(st.Overlap, SourceOverlap{URL: u, SharedRules: shared, Ratio: float64(shared) / float64(max(len(hashes), 1))})
+	}
+	slices.SortFunc(st.Overlap, func(a, b SourceOverlap) int { return b.SharedRules - a.SharedRules })
+	return st, true
+}
+
+// countShared 对两个已排序的哈希列表做归并计数
+func countShared(a, b []uint64) int {
+	n, i, j := 0, 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			n++
+			i++
+			j++
+		case a[i] < b[j]:
+			i++
+		default:
+			j++
+		}
+	}
+	return n
+}
+
+type ruleHitHeap []RuleHitCount
+
+func (h ruleHitHeap) Len() int { return len(h) }
+func (h ruleHitHeap) Less(i, j int) bool {
+	if h[i].Hits != h[j].Hits {
+		return h[i].Hits < h[j].Hits
+	}
+	return h[i].Rule > h[j].Rule
+}
+func (h ruleHitHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *ruleHitHeap) Push(x interface{}) { *h = append(*h, x.(RuleHitCount)) }
+func (h *ruleHitHeap) Pop() interface{} {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
+
+// topRuleHits 用最小堆选出命中最多的 k 条规则，按命中次数降序返回
+func topRuleHits(perRule map[string]int64, k int) []RuleHitCount {
+	if k <= 0 {
+		return []RuleHitCount{}
+	}
+	h := &ruleHitHeap{}
+	for rule, count := range perRule {
+		item := RuleHitCount{Rule: rule, Hits: count}
+		if h.Len() < k {
+			heap.Push(h, item)
+		} else if (*h)[0].Hits < count || ((*h)[0].Hits == count && rule < (*h)[0].Rule) {
+			heap.Pop(h)
+			heap.Push(h, item)
+		}
+	}
+	result := make([]RuleHitCount, h.Len())
+	for i := h.Len() - 1; i >= 0; i-- {
+		result[i] = heap.Pop(h).(RuleHitCount)
+	}
+	return result
+}
+
+// hashRuleFile 逐行读取规则文件并计算规则键哈希，返回排序去重后的结果
+func hashRuleFile(path string) ([]uint64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	hashes := []uint64{}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		for _, key := range ruleKeys(scanner.Text()) {
+			hashes = append(hashes, ruleHash(key))
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	slices.Sort(hashes)
+	return slices.Compact(hashes), nil
+}
+
+// indexRuleSources 为已启用的规则源重建规则键索引，已禁用或移除的规则源从统计中去掉
+// 只读取规则文件、不解析规则，在规则加载后于后台执行
+func (m *AdBlockManager) indexRuleSources() {
+	m.ruleStats.indexMu.Lock()
+	defer m.ruleStats.indexMu.Unlock()
+
+	active := make(map[string]bool)
+	for _, source := range m.sourcesMgr.GetAllSources() {
+		if !source.Enabled {
+			continue
+		}
+		path, ok := m.loader.sourcePath(source)
+		if !ok {
+			continue
+		}
+		hashes, err := hashRuleFile(path)
+		if err != nil {
+			logger.Warnf("[AdBlock] Failed to index rules of %s: %v", source.URL, err)
+			continue
+		}
+		m.ruleStats.SetSource(source.URL, hashes)
+		active[source.URL] = true
+	}
+
+	m.ruleStats.mu.RLock()
+	var stale []string
+	for url := range m.ruleStats.sources {
+		if !active[url] {
+			stale = append(stale, url)
+		}
+	}
+	m.ruleStats.mu.RUnlock()
+	for _, url := range stale {
+		m.ruleStats.SetSource(url, nil)
+	}
+}
+
+// GetSourceStats 返回规则源最近 days 天的命中统计；规则源不存在、未启用或尚未建立索引时返回 false
+func (m *AdBlockManager) GetSourceStats(url string, days, top int) (SourceRuleStats, bool) {
+	return m.ruleStats.SourceStats(url, days, top)
+}
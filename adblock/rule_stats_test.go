@@ -0,0 +1,138 @@
+package adblock
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"smartdnssort/config"
+	"testing"
+)
+
+func TestRuleKeys(t *testing.T) {
+	tests := []struct {
+		rule string
+		want []string
+	}{
+		{"||Ads.Example.com^", []string{"||ads.example.com^"}},
+		{"blacklist: tracker.example.net", []string{"tracker.example.net"}},
+		{"0.0.0.0 a.example.org b.example.org # comment", []string{"a.example.org", "b.example.org"}},
+		{"/^ad[0-9]+\\.example\\.io$/", []string{"/^ad[0-9]+\\.example\\.io$/"}},
+		{"! comment", nil},
+		{"   ", nil},
+	}
+	for _, tt := range tests {
+		if got := ruleKeys(tt.rule); !slices.Equal(got, tt.want) {
+			t.Errorf("ruleKeys(%q) = %q, want %q", tt.rule, got, tt.want)
+		}
+	}
+}
+
+func hashRules(rules ...string) []uint64 {
+	var hashes []uint64
+	for _, r := range rules {
+		for _, key := range ruleKeys(r) {
+			hashes = append(hashes, ruleHash(key))
+		}
+	}
+	slices.Sort(hashes)
+	return slices.Compact(hashes)
+}
+
+func TestRuleStatsSourceStats(t *testing.T) {
+	rs := NewRuleStats()
+	rs.SetSource("list-a", hashRules("||ads.example.com^", "||track.example.com^", "0.0.0.0 pixel.example.net", "||unused.example.com^"))
+	rs.SetSource("list-b", hashRules("||ads.example.com^", "pixel.example.net", "||other.example.org^"))
+
+	for i := 0; i < 3; i++ {
+		rs.Record("ads.example.com", "||ads.example.com^")
+	}
+	// simple 引擎以查询域名报告规则，应归到源中的父域规则
+	rs.Record("x.track.example.com", "adblock: ||x.track.example.com^")
+	rs.Record("pixel.example.net", "hosts: 0.0.0.0 pixel.example.net")
+	rs.Record("nowhere.example", "||nowhere.example^")
+
+	if _, ok := rs.SourceStats("missing", 7, 10); ok {
+		t.Fatal("unknown source should not be reported")
+	}
+	st, ok := rs.SourceStats("list-a", 7, 2)
+	if !ok {
+		t.Fatal("indexed source should be reported")
+	}
+	if st.TotalRules != 4 || st.HitRules != 3 || st.Hits != 5 {
+		t.Errorf("got total=%d hitRules=%d hits=%d, want 4/3/5", st.TotalRules, st.HitRules, st.Hits)
+	}
+	if st.NeverHitRatio != 0.25 {
+		t.Errorf("NeverHitRatio = %v, want 0.25", st.NeverHitRatio)
+	}
+	want := []RuleHitCount{{"||ads.example.com^", 3}, {"pixel.example.net", 1}}
+	if !slices.Equal(st.TopRules, want) {
+		t.Errorf("TopRules = %v, want %v", st.TopRules, want)
+	}
+	if len(st.Overlap) != 1 || st.Overlap[0].URL != "list-b" || st.Overlap[0].SharedRules != 2 || st.Overlap[0].Ratio != 0.5 {
+		t.Errorf("Overlap = %+v, want list-b sharing 2 rules", st.Overlap)
+	}
+
+	rs.Reset()
+	if st, _ := rs.SourceStats("list-a", 7, 2); st.Hits != 0 || st.NeverHitRatio != 1 {
+		t.Errorf("reset should clear hits, got %+v", st)
+	}
+	rs.SetSource("list-b", nil)
+	if _, ok := rs.SourceStats("list-b", 7, 2); ok {
+		t.Error("removed source should not be reported")
+	}
+}
+
+func TestManagerSourceStats(t *testing.T) {
+	dir := t.TempDir()
+	fileA := filepath.Join(dir, "a.txt")
+	fileB := filepath.Join(dir, "b.txt")
+	if err := os.WriteFile(fileA, []byte("||ads.example.com^\n||track.example.com^\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(fileB, []byte("0.0.0.0 ads.example.com\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// 缓存规则过少会被视为不完整，补充一个填充规则源
+	fileC := filepath.Join(dir, "c.txt")
+	var filler []byte
+	for i := 0; i < 200; i++ {
+		filler = fmt.Appendf(filler, "||filler%d.example.org^\n", i)
+	}
+	if err := os.WriteFile(fileC, filler, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &config.AdBlockConfig{Enable: true, Engine: "simple", CacheDir: dir, RuleURLs: []string{fileA, fileB, fileC}}
+	m, err := NewManager(cfg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.LoadRulesFromCache(); err != nil {
+		t.Fatalf("LoadRulesFromCache: %v", err)
+	}
+	m.indexRuleSources() // 加载后会在后台索引，这里同步执行以便断言
+
+	match, rule := m.CheckHost("sub.ads.example.com")
+	if match != MatchBlocked {
+		t.Fatal("listed domain should be blocked")
+	}
+	m.RecordBlock("sub.ads.example.com", rule)
+
+	st, ok := m.GetSourceStats(fileA, 1, 10)
+	if !ok {
+		t.Fatal("local source should be indexed")
+	}
+	if st.TotalRules != 2 || st.Hits != 1 || len(st.TopRules) != 1 || st.TopRules[0].Rule != "||ads.example.com^" {
+		t.Errorf("unexpected stats %+v", st)
+	}
+	if len(st.Overlap) != 0 {
+		t.Errorf("||ads.example.com^ and a hosts entry are different rules, got overlap %+v", st.Overlap)
+	}
+
+	m.ClearRuleStats()
+	if st, _ := m.GetSourceStats(fileA, 1, 10); st.Hits != 0 {
+		t.Errorf("ClearRuleStats should drop hits, got %d", st.Hits)
+	}
+}
@@ -0,0 +1,169 @@
+package adblock
+
+import (
+	"fmt"
+	"smartdnssort/config"
+	"strings"
+	"time"
+	_ "time/tzdata" // 保证没有系统时区数据库的平台也能解析 timezone
+)
+
+var weekdayNames = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// scheduleWindow 编译后的每周时间窗口，时间以当天零点起的分钟数表示
+type scheduleWindow struct {
+	days       [7]bool // 按窗口开始的那一天计
+	start, end int
+}
+
+// active 判断 t 是否落在窗口内；跨午夜的窗口在次日 end 之前仍属于前一天开始的窗口
+func (w scheduleWindow) active(t time.Time) bool {
+	minute := t.Hour()*60 + t.Minute()
+	today, yesterday := t.Weekday(), (t.Weekday()+6)%7
+	if w.start < w.end {
+		return w.days[today] && minute >= w.start && minute < w.end
+	}
+	return (w.days[today] && minute >= w.start) || (w.days[yesterday] && minute < w.end)
+}
+
+// schedule 一个定时规则集
+type schedule struct {
+	name    string
+	engine  FilterEngine
+	windows []scheduleWindow
+}
+
+// ScheduleEngine 按时间窗口启用的规则集，窗口在配置的时区中计算
+type ScheduleEngine struct {
+	loc       *time.Location
+	schedules []*schedule
+}
+
+// NewScheduleEngine 编译定时规则集，规则集引用的服务从目录中展开
+func NewScheduleEngine(cfg *config.AdBlockConfig, catalog *ServiceCatalog) (*ScheduleEngine, error) {
+	loc, err := scheduleLocation(cfg.Timezone)
+	if err != nil {
+		return nil, err
+	}
+	e := &ScheduleEngine{loc: loc}
+	for _, sc := range cfg.Schedules {
+		windows, err := parseScheduleWindows(sc)
+		if err != nil {
+			return nil, err
+		}
+		engine, err := CreateEngine(cfg)
+		if err != nil {
+			return nil, err
+		}
+		rules := append(catalog.Rules(sc.Services), sc.Rules...)
+		if err := engine.LoadRules(rules); err != nil {
+			return nil, fmt.Errorf("schedule %s: %w", sc.Name, err)
+		}
+		e.schedules = append(e.schedules, &schedule{name: sc.Name, engine: engine, windows: windows})
+	}
+	return e, nil
+}
+
+// ValidateSchedules 校验时区与定时规则集配置，供配置校验使用
+func ValidateSchedules(cfg *config.AdBlockConfig) error {
+	if _, err := scheduleLocation(cfg.Timezone); err != nil {
+		return err
+	}
+	for _, sc := range cfg.Schedules {
+		if _, err := parseScheduleWindows(sc); err != nil {
+			return err
+		}
+		if len(sc.Services) == 0 && len(sc.Rules) == 0 {
+			return fmt.Errorf("schedule %s: services or rules are required", sc.Name)
+		}
+	}
+	return nil
+}
+
+func scheduleLocation(name string) (*time.Location, error) {
+	if name == "" {
+		return time.Local, nil
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, fmt.Errorf("invalid timezone %q: %w", name, err)
+	}
+	return loc, nil
+}
+
+func parseScheduleWindows(sc config.ScheduleConfig) ([]scheduleWindow, error) {
+	if sc.Name == "" {
+		return nil, fmt.Errorf("schedule name is required")
+	}
+	if len(sc.Windows) == 0 {
+		return nil, fmt.Errorf("schedule %s: at least one window is required", sc.Name)
+	}
+	windows := make([]scheduleWindow, 0, len(sc.Windows))
+	for _, wc := range sc.Windows {
+		var w scheduleWindow
+		var err error
+		if w.start, err = parseClock(wc.Start); err != nil {
+			return nil, fmt.Errorf("schedule %s: %w", sc.Name, err)
+		}
+		if w.end, err = parseClock(wc.End); err != nil {
+			return nil, fmt.Errorf("schedule %s: %w", sc.Name, err)
+		}
+		if len(wc.Days) == 0 {
+			w.days = [7]bool{true, true, true, true, true, true, true}
+		}
+		for _, d := range wc.Days {
+			day := strings.ToLower(strings.TrimSpace(d))
+			if len(day) > 3 {
+				day = day[:3] // 同时接受 monday 这样的全称
+			}
+			wd, ok := weekdayNames[day]
+			if !ok {
+				return nil, fmt.Errorf("schedule %s: invalid day %q", sc.Name, d)
+			}
+			w.days[wd] = true
+		}
+		windows = append(windows, w)
+	}
+	return windows, nil
+}
+
+// parseClock 解析 HH:MM，24:00 表示当天结束
+func parseClock(s string) (int, error) {
+	var h, m int
+	if _, err := fmt.Sscanf(s, "%d:%d", &h, &m); err != nil || h < 0 || m < 0 || m > 59 || h > 24 || (h == 24 && m != 0) {
+		return 0, fmt.Errorf("invalid time %q (expected HH:MM)", s)
+	}
+	return h*60 + m, nil
+}
+
+// Check 返回在 now 时刻生效的规则集对请求的拦截结果，结果随时间变化，不可按域名缓存
+func (e *ScheduleEngine) Check(req *Request, now time.Time) Result {
+	if e == nil {
+		return Result{}
+	}
+	now = now.In(e.loc)
+	for _, s := range e.schedules {
+		if !s.activeAt(now) {
+			continue
+		}
+		res := s.engine.Check(req)
+		if res.Match == MatchBlocked || res.Rewrite != nil {
+			res.Rule = "schedule " + s.name + ": " + res.Rule
+			res.Cacheable = false
+			return res
+		}
+	}
+	return Result{}
+}
+
+func (s *schedule) activeAt(t time.Time) bool {
+	for _, w := range s.windows {
+		if w.active(t) {
+			return true
+		}
+	}
+	return false
+}
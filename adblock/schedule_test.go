@@ -0,0 +1,124 @@
+package adblock
+
+import (
+	"smartdnssort/config"
+	"testing"
+	"time"
+)
+
+func TestScheduleWindows(t *testing.T) {
+	cfg := &config.AdBlockConfig{
+		Engine:   "simple",
+		Timezone: "Asia/Shanghai",
+		Schedules: []config.ScheduleConfig{{
+			Name:     "bedtime",
+			Services: []string{"tiktok"},
+			Rules:    []string{"||weibo.com^"},
+			Windows: []config.ScheduleWindowConfig{
+				{Days: []string{"mon", "tue", "wed", "thu", "fri"}, Start: "22:00", End: "07:00"},
+			},
+		}},
+	}
+	e, err := NewScheduleEngine(cfg, NewServiceCatalog())
+	if err != nil {
+		t.Fatal(err)
+	}
+	loc, _ := time.LoadLocation("Asia/Shanghai")
+	at := func(day, clock string) time.Time {
+		ts, err := time.ParseInLocation("2006-01-02 15:04", day+" "+clock, loc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ts
+	}
+
+	// 2024-01-01 是星期一
+	tests := []struct {
+		when    time.Time
+		blocked bool
+	}{
+		{at("2024-01-01", "21:59"), false},
+		{at("2024-01-01", "22:00"), true},
+		{at("2024-01-02", "06:59"), true}, // 周一开始的窗口延续到周二早上
+		{at("2024-01-02", "07:00"), false},
+		{at("2024-01-06", "06:00"), true},  // 周五晚上开始的窗口延续到周六早上
+		{at("2024-01-06", "23:00"), false}, // 周六晚上不在窗口内
+		{at("2024-01-01", "06:00"), false}, // 周日晚上没有窗口
+	}
+	for _, tt := range tests {
+		res := e.Check(&Request{Host: "www.tiktok.com"}, tt.when)
+		if (res.Match == MatchBlocked) != tt.blocked {
+			t.Errorf("%s: blocked = %v, want %v", tt.when.Format("Mon 15:04"), res.Match == MatchBlocked, tt.blocked)
+		}
+		if res.Cacheable {
+			t.Error("schedule results must not be cacheable")
+		}
+	}
+	if res := e.Check(&Request{Host: "weibo.com"}, at("2024-01-01", "23:00").UTC()); res.Match != MatchBlocked {
+		t.Error("explicit rules should be active and windows evaluated in the configured timezone")
+	}
+}
+
+func TestValidateSchedules(t *testing.T) {
+	valid := config.ScheduleConfig{Name: "s", Rules: []string{"||a.example^"}, Windows: []config.ScheduleWindowConfig{{Start: "09:00", End: "17:30"}}}
+	if err := ValidateSchedules(&config.AdBlockConfig{Schedules: []config.ScheduleConfig{valid}}); err != nil {
+		t.Errorf("valid schedule rejected: %v", err)
+	}
+
+	tests := []config.AdBlockConfig{
+		{Timezone: "Nowhere/Invalid"},
+		{Schedules: []config.ScheduleConfig{{Name: "s", Rules: valid.Rules}}},
+		{Schedules: []config.ScheduleConfig{{Name: "s", Rules: valid.Rules, Windows: []config.ScheduleWindowConfig{{Start: "25:00", End: "07:00"}}}}},
+		{Schedules: []config.ScheduleConfig{{Name: "s", Rules: valid.Rules, Windows: []config.ScheduleWindowConfig{{Days: []string{"someday"}, Start: "22:00", End: "07:00"}}}}},
+		{Schedules: []config.ScheduleConfig{{Name: "s", Windows: valid.Windows}}},
+	}
+	for i, cfg := range tests {
+		if err := ValidateSchedules(&cfg); err == nil {
+			t.Errorf("case %d: expected validation error", i)
+		}
+	}
+}
+
+func TestManagerPause(t *testing.T) {
+	m, err := NewManager(&config.AdBlockConfig{Enable: true, Engine: "simple", CacheDir: t.TempDir()}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	restored := make(chan struct{})
+	until := m.Pause(50*time.Millisecond, func() { close(restored) })
+	if until.IsZero() || m.PausedUntil() != until {
+		t.Fatal("pause end time should be reported")
+	}
+	if m.Check(&Request{Host: "a.example"}).Match != MatchNeutral || m.Enabled() {
+		t.Fatal("filtering should be disabled while paused")
+	}
+
+	// 暂停期间的 SetEnabled 在恢复时生效，而不是立即解除暂停
+	m.SetEnabled(true)
+	if m.Enabled() {
+		t.Fatal("SetEnabled must not end the pause")
+	}
+	// 服务器侧写入的配置开关不影响生效状态
+	m.cfg.Enable = true
+	if m.Enabled() || m.Check(&Request{Host: "a.example"}).Match != MatchNeutral {
+		t.Fatal("the configured flag must not end the pause")
+	}
+
+	select {
+	case <-restored:
+	case <-time.After(2 * time.Second):
+		t.Fatal("pause did not expire")
+	}
+	if !m.Enabled() || !m.PausedUntil().IsZero() {
+		t.Error("filtering should be restored after the pause")
+	}
+
+	m.Pause(time.Hour, nil)
+	if !m.Resume() || !m.Enabled() {
+		t.Error("Resume should restore filtering immediately")
+	}
+	if m.Resume() {
+		t.Error("Resume without a pause should report false")
+	}
+}
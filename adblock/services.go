@@ -0,0 +1,211 @@
+package adblock
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"smartdnssort/logger"
+	"sync"
+)
+
+//go:embed services.json
+var builtinServices []byte
+
+const serviceCatalogFile = "services.json"
+
+// Service 一个可一键拦截的服务：具名的一组域名规则
+type Service struct {
+	ID    string   `json:"id"`
+	Name  string   `json:"name"`
+	Group string   `json:"group"` // 分类，如 social、video、gaming，用于按类别批量开关
+	Rules []string `json:"rules"`
+}
+
+type serviceCatalogData struct {
+	Version  int       `json:"version"`
+	Services []Service `json:"services"`
+}
+
+// ServiceCatalog 服务目录，内置一份默认目录，可从远程地址更新
+type ServiceCatalog struct {
+	mu       sync.RWMutex
+	version  int
+	services []Service
+	byID     map[string]*Service
+}
+
+// NewServiceCatalog creates a catalog initialized with the built-in service definitions.
+func NewServiceCatalog() *ServiceCatalog {
+	c := &ServiceCatalog{}
+	if err := c.Load(builtinServices); err != nil {
+		panic(fmt.Sprintf("invalid built-in service catalog: %v", err))
+	}
+	return c
+}
+
+// Load 解析并替换目录内容，格式错误时保留原目录
+func (c *ServiceCatalog) Load(data []byte) error {
+	var catalog serviceCatalogData
+	if err := json.Unmarshal(data, &catalog); err != nil {
+		return fmt.Errorf("invalid service catalog: %w", err)
+	}
+	byID := make(map[string]*Service, len(catalog.Services))
+	for i := range catalog.Services {
+		svc := &catalog.Services[i]
+		if svc.ID == "" || len(svc.Rules) == 0 {
+			return fmt.Errorf("invalid service catalog: service %d has no id or rules", i)
+		}
+		if _, dup := byID[svc.ID]; dup {
+			return fmt.Errorf("invalid service catalog: duplicate service id %q", svc.ID)
+		}
+		byID[svc.ID] = svc
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.version = catalog.Version
+	c.services = catalog.Services
+	c.byID = byID
+	return nil
+}
+
+// Version returns the catalog version.
+func (c *ServiceCatalog) Version() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.version
+}
+
+// Services returns all service definitions in catalog order.
+func (c *ServiceCatalog) Services() []Service {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return slices.Clone(c.services)
+}
+
+// Has 返回目录中是否存在该服务
+func (c *ServiceCatalog) Has(id string) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.byID[id] != nil
+}
+
+// GroupIDs 返回分类下的所有服务 ID
+func (c *ServiceCatalog) GroupIDs(group string) []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	var ids []string
+	for _, svc := range c.services {
+		if svc.Group == group {
+			ids = append(ids, svc.ID)
+		}
+	}
+	return ids
+}
+
+// Rules 汇总已启用服务的规则，目录中不存在的服务被忽略
+func (c *ServiceCatalog) Rules(ids []string) []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	var rules []string
+	for _, id := range ids {
+		if svc := c.byID[id]; svc != nil {
+			rules = append(rules, svc.Rules...)
+		}
+	}
+	return rules
+}
+
+// loadCachedServiceCatalog 加载上次下载的目录，不存在或无效时沿用内置目录
+func (m *AdBlockManager) loadCachedServiceCatalog() {
+	data, err := os.ReadFile(filepath.Join(m.cfg.CacheDir, serviceCatalogFile))
+	if err != nil {
+		return
+	}
+	if err := m.services.Load(data); err != nil {
+		logger.Warnf("[AdBlock] Ignoring cached service catalog: %v", err)
+	}
+}
+
+// updateServiceCatalog 从 services_url 下载最新目录，校验通过后替换并写入缓存
+func (m *AdBlockManager) updateServiceCatalog(ctx context.Context) error {
+	if m.cfg.ServicesURL == "" {
+		return nil
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, m.cfg.ServicesURL, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := m.loader.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("bad status: %s", resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxFileSizeLimit))
+	if err != nil {
+		return err
+	}
+	if err := m.services.Load(data); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(m.cfg.CacheDir, serviceCatalogFile), data, 0644)
+}
+
+// serviceRules 返回已启用服务对应的规则，与规则源一同编译进过滤引擎
+func (m *AdBlockManager) serviceRules() []string {
+	m.mu.RLock()
+	ids := m.cfg.BlockedServices
+	m.mu.RUnlock()
+	return m.services.Rules(ids)
+}
+
+// ServiceStatus 服务目录条目及其启用状态
+type ServiceStatus struct {
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	Group     string `json:"group"`
+	RuleCount int    `json:"rule_count"`
+	Blocked   bool   `json:"blocked"`
+}
+
+// GetServices 返回服务目录及各服务的启用状态
+func (m *AdBlockManager) GetServices() []ServiceStatus {
+	m.mu.RLock()
+	enabled := m.cfg.BlockedServices
+	m.mu.RUnlock()
+
+	services := m.services.Services()
+	statuses := make([]ServiceStatus, 0, len(services))
+	for _, svc := range services {
+		statuses = append(statuses, ServiceStatus{
+			ID:        svc.ID,
+			Name:      svc.Name,
+			Group:     svc.Group,
+			RuleCount: len(svc.Rules),
+			Blocked:   slices.Contains(enabled, svc.ID),
+		})
+	}
+	return statuses
+}
+
+// Catalog returns the service catalog.
+func (m *AdBlockManager) Catalog() *ServiceCatalog {
+	return m.services
+}
+
+// SetBlockedServices 替换已启用的服务并重新编译过滤引擎
+func (m *AdBlockManager) SetBlockedServices(ids []string) error {
+	m.mu.Lock()
+	m.cfg.BlockedServices = ids
+	m.mu.Unlock()
+	return m.rebuildEngine()
+}
@@ -0,0 +1,64 @@
+package adblock
+
+import (
+	"os"
+	"path/filepath"
+	"smartdnssort/config"
+	"testing"
+)
+
+func TestServiceCatalog(t *testing.T) {
+	c := NewServiceCatalog()
+	if !c.Has("tiktok") || c.Has("no-such-service") {
+		t.Fatal("built-in catalog should contain tiktok only")
+	}
+	gaming := c.GroupIDs("gaming")
+	if len(gaming) == 0 {
+		t.Fatal("built-in catalog should have a gaming group")
+	}
+	if rules := c.Rules([]string{"tiktok", "unknown"}); len(rules) == 0 {
+		t.Error("rules of known services should be returned, unknown ones ignored")
+	}
+
+	if err := c.Load([]byte(`{"version":2,"services":[{"id":"a","rules":["||a.example^"]},{"id":"a","rules":["||b.example^"]}]}`)); err == nil {
+		t.Error("duplicate service ids should be rejected")
+	}
+	if c.Version() != 1 || !c.Has("tiktok") {
+		t.Error("a rejected catalog must not replace the current one")
+	}
+}
+
+func TestManagerBlockedServices(t *testing.T) {
+	dir := t.TempDir()
+	rulesFile := filepath.Join(dir, "custom.txt")
+	if err := os.WriteFile(rulesFile, []byte("||ads.example^\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := &config.AdBlockConfig{Enable: true, Engine: "urlfilter", CacheDir: dir, RuleURLs: []string{rulesFile}}
+	m, err := NewManager(cfg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.SetBlockedServices([]string{"tiktok"}); err != nil {
+		t.Fatal(err)
+	}
+	if res, _ := m.CheckHost("www.tiktok.com"); res != MatchBlocked {
+		t.Error("enabled service should be blocked")
+	}
+	if res, _ := m.CheckHost("ads.example"); res != MatchBlocked {
+		t.Error("rule sources should stay active alongside services")
+	}
+	for _, svc := range m.GetServices() {
+		if svc.Blocked != (svc.ID == "tiktok") {
+			t.Errorf("service %s blocked = %v", svc.ID, svc.Blocked)
+		}
+	}
+
+	if err := m.SetBlockedServices(nil); err != nil {
+		t.Fatal(err)
+	}
+	if res, _ := m.CheckHost("www.tiktok.com"); res != MatchNeutral {
+		t.Error("disabled service should no longer be blocked")
+	}
+}
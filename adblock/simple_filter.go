@@ -59,6 +59,13 @@ func (f *SimpleFilter) CheckHost(domain string) (MatchResult, string) {
 	return MatchNeutral, ""
 }
 
+// Check implements the FilterEngine interface.
+// 简单引擎不支持请求修饰符，结果只取决于域名
+func (f *SimpleFilter) Check(req *Request) Result {
+	match, rule := f.CheckHost(req.Host)
+	return Result{Match: match, Rule: rule, Cacheable: true}
+}
+
 // LoadRules implements the FilterEngine interface.
 // It parses rules and adds them to the appropriate matcher.
 func (f *SimpleFilter) LoadRules(rules []string) error {
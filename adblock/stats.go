@@ -16,6 +16,8 @@ type AdBlockStats struct {
 	LastUpdate    string   `json:"last_update"`
 	SourcesCount  int      `json:"sources_count"`
 	FailedSources []string `json:"failed_sources"`
+	// 已加载但不会生效的规则数（按客户端名称匹配的 $client 规则）
+	UnsupportedRules int `json:"unsupported_rules"`
 }
 
 // Stats manages adblock statistics.
@@ -1,6 +1,9 @@
 package adblock
 
 import (
+	"net/netip"
+	"slices"
+	"smartdnssort/logger"
 	"strings"
 
 	"github.com/AdguardTeam/urlfilter"
@@ -10,6 +13,10 @@ import (
 type URLFilterEngine struct {
 	engine    *urlfilter.DNSEngine
 	ruleCount int
+	// contextual 规则中含有 $dnstype/$client/$ctag 修饰符，同一域名的结果随请求而变
+	contextual bool
+	// clientNameRules 按客户端名称匹配的 $client 规则数，请求不携带客户端名称，这些规则不会生效
+	clientNameRules int
 }
 
 func NewURLFilterEngine() (*URLFilterEngine, error) {
@@ -37,6 +44,16 @@ func (e *URLFilterEngine) LoadRules(rules []string) error {
 
 	e.engine = urlfilter.NewDNSEngine(storage)
 	e.ruleCount = len(rules)
+	e.contextual = slices.ContainsFunc(rules, hasContextModifier)
+	e.clientNameRules = 0
+	for _, rule := range rules {
+		if hasClientNameModifier(rule) {
+			e.clientNameRules++
+		}
+	}
+	if e.clientNameRules > 0 {
+		logger.Warnf("[AdBlock] %d rule(s) match $client by name, which is not supported (client names are unknown); only IP/CIDR clients take effect", e.clientNameRules)
+	}
 
 	// 你原来的精确计数逻辑，一字未动
 	actualCount := 0
@@ -71,6 +88,94 @@ func (e *URLFilterEngine) CheckHost(domain string) (MatchResult, string) {
 	return MatchNeutral, ""
 }
 
+// Check 按请求上下文匹配，$dnsrewrite 优先于普通拦截/放行规则（与 AdGuard Home 一致）
+// $important、$badfilter 由引擎在选取基础规则时处理
+func (e *URLFilterEngine) Check(req *Request) Result {
+	if e.engine == nil {
+		return Result{Cacheable: true}
+	}
+
+	res, matched := e.engine.MatchRequest(&urlfilter.DNSRequest{
+		Hostname:   req.Host,
+		ClientIP:   req.ClientIP,
+		ClientName: req.ClientName,
+		DNSType:    req.QType,
+	})
+	result := Result{Cacheable: !e.contextual}
+
+	// MatchRequest 在只命中 $dnsrewrite 时返回 false，需要单独检查
+	if rw := buildDNSRewrite(res.DNSRewrites(), req.Host, req.QType); rw != nil {
+		result.Rewrite = rw
+		result.Rule = rw.Rules[0]
+		return result
+	}
+	if !matched {
+		return result
+	}
+
+	if nr := res.NetworkRule; nr != nil {
+		result.Rule = nr.Text()
+		if nr.Whitelist {
+			result.Match = MatchAllowed
+		} else {
+			result.Match = MatchBlocked
+		}
+		return result
+	}
+
+	return applyHostRules(result, res.HostRulesV4, res.HostRulesV6, req.Host, req.QType)
+}
+
+// hasContextModifier 判断规则是否带有依赖请求上下文的修饰符
+func hasContextModifier(rule string) bool {
+	return hasModifier(rule, "dnstype", "client", "ctag")
+}
+
+// hasModifier 判断规则是否带有任一指定名称的修饰符（忽略取反前缀 ~ 与参数）
+func hasModifier(rule string, names ...string) bool {
+	idx := strings.LastIndexByte(rule, '$')
+	if idx < 0 {
+		return false
+	}
+	for _, opt := range strings.Split(rule[idx+1:], ",") {
+		name, _, _ := strings.Cut(strings.TrimSpace(opt), "=")
+		if slices.Contains(names, strings.TrimPrefix(name, "~")) {
+			return true
+		}
+	}
+	return false
+}
+
+// hasClientNameModifier 判断规则的 $client 修饰符中是否含有客户端名称（而不是 IP/CIDR）
+func hasClientNameModifier(rule string) bool {
+	idx := strings.LastIndexByte(rule, '$')
+	if idx < 0 {
+		return false
+	}
+	for _, opt := range strings.Split(rule[idx+1:], ",") {
+		name, value, ok := strings.Cut(strings.TrimSpace(opt), "=")
+		if !ok || name != "client" {
+			continue
+		}
+		for _, client := range strings.Split(value, "|") {
+			client = strings.Trim(strings.TrimPrefix(strings.TrimSpace(client), "~"), `'"`)
+			if _, err := netip.ParseAddr(client); err == nil {
+				continue
+			}
+			if _, err := netip.ParsePrefix(client); err == nil {
+				continue
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// UnsupportedRules 实现 unsupportedRuleCounter 接口
+func (e *URLFilterEngine) UnsupportedRules() int {
+	return e.clientNameRules
+}
+
 func (e *URLFilterEngine) Count() int {
 	if e.engine == nil {
 		return 0
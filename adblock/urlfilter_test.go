@@ -0,0 +1,148 @@
+package adblock
+
+import (
+	"net/netip"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func newTestURLFilterEngine(t *testing.T, rules ...string) *URLFilterEngine {
+	t.Helper()
+	e, _ := NewURLFilterEngine()
+	if err := e.LoadRules(rules); err != nil {
+		t.Fatalf("LoadRules: %v", err)
+	}
+	return e
+}
+
+func TestURLFilterDNSRewrite(t *testing.T) {
+	e := newTestURLFilterEngine(t,
+		"||a.example^$dnsrewrite=192.0.2.1",
+		"||a.example^$dnsrewrite=NOERROR;AAAA;2001:db8::1",
+		"||a.example^$dnsrewrite=NOERROR;TXT;hello",
+		"||cname.example^$dnsrewrite=target.example.net",
+		"||refused.example^$dnsrewrite=REFUSED",
+		"||svc.example^$dnsrewrite=NOERROR;HTTPS;1 . alpn=h2 ipv4hint=192.0.2.7",
+		"||exc.example^$dnsrewrite=192.0.2.1",
+		"||exc.example^$dnsrewrite=192.0.2.2",
+		"@@||exc.example^$dnsrewrite=192.0.2.1",
+	)
+
+	tests := []struct {
+		host   string
+		qtype  uint16
+		rcode  int
+		cname  string
+		answer []string
+	}{
+		{"a.example", dns.TypeA, dns.RcodeSuccess, "", []string{"192.0.2.1"}},
+		{"sub.a.example", dns.TypeAAAA, dns.RcodeSuccess, "", []string{"2001:db8::1"}},
+		{"a.example", dns.TypeTXT, dns.RcodeSuccess, "", []string{"\"hello\""}},
+		{"a.example", dns.TypeMX, dns.RcodeSuccess, "", nil}, // NODATA
+		{"cname.example", dns.TypeA, dns.RcodeSuccess, "target.example.net.", nil},
+		{"refused.example", dns.TypeA, dns.RcodeRefused, "", nil},
+		{"svc.example", dns.TypeHTTPS, dns.RcodeSuccess, "", []string{"1 . alpn=\"h2\" ipv4hint=\"192.0.2.7\""}},
+		{"exc.example", dns.TypeA, dns.RcodeSuccess, "", []string{"192.0.2.2"}},
+	}
+	for _, tt := range tests {
+		res := e.Check(&Request{Host: tt.host, QType: tt.qtype})
+		rw := res.Rewrite
+		if rw == nil {
+			t.Errorf("%s/%s: expected rewrite, got %+v", tt.host, dns.TypeToString[tt.qtype], res)
+			continue
+		}
+		if rw.RCode != tt.rcode || rw.CNAME != tt.cname || len(rw.Answer) != len(tt.answer) {
+			t.Errorf("%s/%s: got rcode=%d cname=%q answer=%v", tt.host, dns.TypeToString[tt.qtype], rw.RCode, rw.CNAME, rw.Answer)
+			continue
+		}
+		for i, rr := range rw.Answer {
+			hdr := rr.Header().String()
+			if got := rr.String()[len(hdr):]; got != tt.answer[i] {
+				t.Errorf("%s/%s: answer[%d] = %q, want %q", tt.host, dns.TypeToString[tt.qtype], i, got, tt.answer[i])
+			}
+		}
+	}
+
+	if res := e.Check(&Request{Host: "other.example", QType: dns.TypeA}); res.Rewrite != nil || res.Match != MatchNeutral {
+		t.Errorf("unrelated host should be neutral, got %+v", res)
+	}
+}
+
+func TestURLFilterRequestModifiers(t *testing.T) {
+	e := newTestURLFilterEngine(t,
+		"||typed.example^$dnstype=AAAA",
+		"||kids.example^$client=192.168.1.10",
+		"||important.example^$important",
+		"@@||important.example^",
+		"||bad.example^",
+		"||bad.example^$badfilter",
+	)
+
+	if res := e.Check(&Request{Host: "typed.example", QType: dns.TypeAAAA}); res.Match != MatchBlocked {
+		t.Errorf("AAAA should be blocked by $dnstype, got %+v", res)
+	}
+	if res := e.Check(&Request{Host: "typed.example", QType: dns.TypeA}); res.Match != MatchNeutral {
+		t.Errorf("A should not match $dnstype=AAAA, got %+v", res)
+	}
+	if res := e.Check(&Request{Host: "typed.example", QType: dns.TypeA}); res.Cacheable {
+		t.Error("results must not be cacheable when context modifiers are present")
+	}
+
+	kid := netip.MustParseAddr("192.168.1.10")
+	if res := e.Check(&Request{Host: "kids.example", QType: dns.TypeA, ClientIP: kid}); res.Match != MatchBlocked {
+		t.Errorf("client 192.168.1.10 should be blocked, got %+v", res)
+	}
+	if res := e.Check(&Request{Host: "kids.example", QType: dns.TypeA, ClientIP: netip.MustParseAddr("192.168.1.11")}); res.Match != MatchNeutral {
+		t.Errorf("other clients should not be blocked, got %+v", res)
+	}
+
+	if res := e.Check(&Request{Host: "important.example", QType: dns.TypeA}); res.Match != MatchBlocked {
+		t.Errorf("$important should win over the allowlist rule, got %+v", res)
+	}
+	if res := e.Check(&Request{Host: "bad.example", QType: dns.TypeA}); res.Match != MatchNeutral {
+		t.Errorf("$badfilter should disable the blocking rule, got %+v", res)
+	}
+}
+
+func TestURLFilterCountsClientNameRules(t *testing.T) {
+	e := newTestURLFilterEngine(t,
+		"||kids.example^$client=192.168.1.10|~10.0.0.0/8",
+		"||tv.example^$client='Living Room TV'",
+		"||laptop.example^$dnstype=A,client=192.168.1.20|~'Frank laptop'",
+		"||plain.example^",
+	)
+	if n := e.UnsupportedRules(); n != 2 {
+		t.Errorf("UnsupportedRules = %d, want 2 name-based $client rules", n)
+	}
+
+	compact := NewCompactEngine(t.TempDir())
+	if err := compact.Compile([]string{"||ads.example^", "||tv.example^$client='Living Room TV'"}, "fp"); err != nil {
+		t.Fatal(err)
+	}
+	defer compact.Close()
+	if n := compact.UnsupportedRules(); n != 1 {
+		t.Errorf("compact engine UnsupportedRules = %d, want 1", n)
+	}
+}
+
+func TestURLFilterHostRules(t *testing.T) {
+	e := newTestURLFilterEngine(t,
+		"0.0.0.0 ads.example",
+		"192.0.2.50 nas.example",
+	)
+
+	res := e.Check(&Request{Host: "ads.example", QType: dns.TypeA})
+	if res.Match != MatchBlocked || !res.Cacheable {
+		t.Errorf("0.0.0.0 host rule should block, got %+v", res)
+	}
+
+	res = e.Check(&Request{Host: "nas.example", QType: dns.TypeA})
+	if res.Rewrite == nil || len(res.Rewrite.Answer) != 1 || res.Rewrite.Answer[0].(*dns.A).A.String() != "192.0.2.50" {
+		t.Fatalf("host rule should rewrite A, got %+v", res)
+	}
+	res = e.Check(&Request{Host: "nas.example", QType: dns.TypeAAAA})
+	if res.Rewrite == nil || len(res.Rewrite.Answer) != 0 {
+		t.Errorf("AAAA for an IPv4-only host rule should be NODATA, got %+v", res)
+	}
+}
@@ -62,6 +62,8 @@ func CreateEngine(cfg *config.AdBlockConfig) (FilterEngine, error) {
 		return NewURLFilterEngine()
 	case "simple":
 		return NewSimpleFilter(), nil
+	case "compact":
+		return NewCompactEngine(cfg.CacheDir), nil
 	default:
 		return nil, fmt.Errorf("unknown adblock engine: %s", cfg.Engine)
 	}
@@ -210,6 +210,11 @@ func TestCreateEngine(t *testing.T) {
 			engineType:  "urlfilter",
 			expectError: false,
 		},
+		{
+			name:        "Compact engine",
+			engineType:  "compact",
+			expectError: false,
+		},
 		{
 			name:        "Unknown engine type",
 			engineType:  "unknown",
@@ -0,0 +1,63 @@
+package blockpage
+
+import (
+	"html/template"
+	"net/http"
+)
+
+type pageData struct {
+	Domain    string
+	Rule      string
+	Submitted bool
+	Action    string
+}
+
+var pageTemplate = template.Must(template.New("blockpage").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Blocked: {{.Domain}}</title>
+<style>
+body{font-family:system-ui,-apple-system,"Segoe UI",sans-serif;background:#f5f6f8;color:#1f2328;margin:0}
+main{max-width:560px;margin:12vh auto;background:#fff;border-radius:12px;padding:32px;box-shadow:0 2px 12px rgba(0,0,0,.08)}
+h1{font-size:1.4em;margin:0 0 12px}
+code{background:#f0f1f3;border-radius:4px;padding:2px 6px;word-break:break-all}
+p{line-height:1.6}
+textarea{width:100%;box-sizing:border-box;min-height:64px;margin:8px 0;font:inherit}
+button{background:#2f6feb;color:#fff;border:0;border-radius:6px;padding:8px 16px;font:inherit;cursor:pointer}
+.muted{color:#656d76;font-size:.9em}
+</style>
+</head>
+<body>
+<main>
+<h1>This site is blocked</h1>
+<p>The network's DNS filter blocked <code>{{.Domain}}</code>. The network itself is working.</p>
+{{if .Rule}}<p>Matched rule: <code>{{.Rule}}</code></p>{{end}}
+{{if .Submitted}}
+<p><strong>Your unblock request has been sent to the administrator.</strong></p>
+{{else}}
+<form method="post" action="{{.Action}}">
+<input type="hidden" name="domain" value="{{.Domain}}">
+<label class="muted" for="reason">Why do you need this site? (optional)</label>
+<textarea id="reason" name="reason" maxlength="500"></textarea>
+<button type="submit">Request unblock</button>
+</form>
+{{end}}
+<p class="muted">Blocked by SmartDNSSort</p>
+</main>
+</body>
+</html>
+`))
+
+// renderPage 以 403 返回拦截页；禁止缓存，解除拦截后刷新即可访问
+func renderPage(w http.ResponseWriter, r *http.Request, data pageData) {
+	data.Action = UnblockPath
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusForbidden)
+	if r.Method == http.MethodHead {
+		return
+	}
+	pageTemplate.Execute(w, data)
+}
@@ -0,0 +1,291 @@
+package blockpage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"smartdnssort/logger"
+)
+
+const (
+	// UnblockPath 拦截页"申请解除拦截"表单的提交路径
+	UnblockPath = "/.smartdnssort/unblock-request"
+
+	defaultRecentTTL   = 30 * time.Minute
+	defaultMaxRecent   = 10000
+	maxUnblockRequests = 200
+	maxReasonLength    = 500
+	shutdownTimeout    = 5 * time.Second
+)
+
+// Options 拦截页服务器配置
+type Options struct {
+	HTTPAddr   string        // HTTP 监听地址，为空时不监听
+	HTTPSAddr  string        // HTTPS 监听地址，为空时不监听
+	CACertFile string        // 本地 CA 证书，与 CAKeyFile 同时配置时 HTTPS 显示拦截页，否则重置连接
+	CAKeyFile  string        // 本地 CA 私钥
+	RecentTTL  time.Duration // 拦截记录的保留时间，须覆盖客户端缓存拦截应答的时长
+	MaxRecent  int           // 最多保留的拦截记录数
+}
+
+// UnblockRequest 用户在拦截页提交的解除拦截申请
+type UnblockRequest struct {
+	Domain string    `json:"domain"`
+	Rule   string    `json:"rule"`
+	Client string    `json:"client"`
+	Reason string    `json:"reason,omitempty"`
+	Time   time.Time `json:"time"`
+}
+
+type recentBlock struct {
+	rule    string
+	expires time.Time
+}
+
+// Server 为被拦截域名提供拦截页：A/AAAA 拦截应答指向本机后，浏览器的 HTTP 请求按 Host 查找最近的拦截记录，
+// 显示被拦截的域名、命中规则和解除申请按钮；HTTPS 在配置本地 CA 时按 SNI 签发证书，否则立即重置连接
+type Server struct {
+	opts   Options
+	issuer *certIssuer
+	now    func() time.Time
+
+	mu       sync.Mutex
+	recent   map[string]recentBlock
+	requests []UnblockRequest
+
+	httpServer *http.Server
+	httpsLn    net.Listener
+	wg         sync.WaitGroup
+}
+
+// New 创建拦截页服务器，CA 证书或私钥无效时返回错误
+func New(opts Options) (*Server, error) {
+	if opts.RecentTTL <= 0 {
+		opts.RecentTTL = defaultRecentTTL
+	}
+	if opts.MaxRecent <= 0 {
+		opts.MaxRecent = defaultMaxRecent
+	}
+	s := &Server{
+		opts:   opts,
+		now:    time.Now,
+		recent: make(map[string]recentBlock),
+	}
+	if (opts.CACertFile == "") != (opts.CAKeyFile == "") {
+		return nil, errors.New("ca_cert and ca_key must be configured together")
+	}
+	if opts.CACertFile != "" {
+		issuer, err := loadCertIssuer(opts.CACertFile, opts.CAKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		s.issuer = issuer
+	}
+	s.httpServer = &http.Server{
+		Handler:           s,
+		ReadHeaderTimeout: 10 * time.Second,
+		IdleTimeout:       60 * time.Second,
+	}
+	return s, nil
+}
+
+// Start 启动 HTTP/HTTPS 监听，任一监听失败时关闭已启动的监听并返回错误
+func (s *Server) Start() error {
+	var httpLn, httpsLn net.Listener
+	var err error
+	if s.opts.HTTPAddr != "" {
+		if httpLn, err = net.Listen("tcp", s.opts.HTTPAddr); err != nil {
+			return fmt.Errorf("failed to listen on %s: %w", s.opts.HTTPAddr, err)
+		}
+	}
+	if s.opts.HTTPSAddr != "" {
+		if httpsLn, err = net.Listen("tcp", s.opts.HTTPSAddr); err != nil {
+			if httpLn != nil {
+				httpLn.Close()
+			}
+			return fmt.Errorf("failed to listen on %s: %w", s.opts.HTTPSAddr, err)
+		}
+	}
+
+	if httpLn != nil {
+		s.serve(httpLn)
+		logger.Infof("[BlockPage] HTTP server started on %s", httpLn.Addr())
+	}
+	if httpsLn != nil {
+		s.httpsLn = httpsLn
+		if s.issuer != nil {
+			s.serve(tlsListener(httpsLn, s.issuer))
+			logger.Infof("[BlockPage] HTTPS server started on %s", httpsLn.Addr())
+		} else {
+			s.wg.Add(1)
+			go s.resetLoop(httpsLn)
+			logger.Infof("[BlockPage] No local CA configured, HTTPS connections on %s will be reset", httpsLn.Addr())
+		}
+	}
+	return nil
+}
+
+func (s *Server) serve(ln net.Listener) {
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		if err := s.httpServer.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Errorf("[BlockPage] Server error on %s: %v", ln.Addr(), err)
+		}
+	}()
+}
+
+// resetLoop 接受 HTTPS 连接后立即以 RST 关闭：没有可信证书时握手必然失败，
+// 立即重置让浏览器快速报错，而不是等待超时
+func (s *Server) resetLoop(ln net.Listener) {
+	defer s.wg.Done()
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				logger.Errorf("[BlockPage] Accept error: %v", err)
+			}
+			return
+		}
+		if tc, ok := conn.(*net.TCPConn); ok {
+			tc.SetLinger(0)
+		}
+		conn.Close()
+	}
+}
+
+// Stop 关闭所有监听并等待后台 goroutine 退出
+func (s *Server) Stop() {
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := s.httpServer.Shutdown(ctx); err != nil {
+		s.httpServer.Close()
+	}
+	if s.httpsLn != nil {
+		s.httpsLn.Close() // 重置模式下的监听不归 httpServer 管理
+	}
+	s.wg.Wait()
+}
+
+// Remember 记录一次拦截，供浏览器随后访问该域名时显示命中规则
+func (s *Server) Remember(domain, rule string) {
+	domain = normalizeHost(domain)
+	if domain == "" {
+		return
+	}
+	now := s.now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.recent[domain]; !ok && len(s.recent) >= s.opts.MaxRecent {
+		s.pruneLocked(now)
+		if len(s.recent) >= s.opts.MaxRecent {
+			return
+		}
+	}
+	s.recent[domain] = recentBlock{rule: rule, expires: now.Add(s.opts.RecentTTL)}
+}
+
+// pruneLocked 删除过期的拦截记录
+func (s *Server) pruneLocked(now time.Time) {
+	for domain, b := range s.recent {
+		if now.After(b.expires) {
+			delete(s.recent, domain)
+		}
+	}
+}
+
+// Lookup 返回域名最近一次被拦截时命中的规则
+func (s *Server) Lookup(domain string) (string, bool) {
+	domain = normalizeHost(domain)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, ok := s.recent[domain]
+	if !ok || s.now().After(b.expires) {
+		return "", false
+	}
+	return b.rule, true
+}
+
+// UnblockRequests 返回解除拦截申请，最新的在前
+func (s *Server) UnblockRequests() []UnblockRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	result := slices.Clone(s.requests)
+	slices.Reverse(result)
+	return result
+}
+
+// ClearUnblockRequests 清空解除拦截申请
+func (s *Server) ClearUnblockRequests() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests = nil
+}
+
+// addUnblockRequest 记录申请；同一客户端对同一域名的重复申请只更新原记录，超出上限时丢弃最早的申请
+func (s *Server) addUnblockRequest(req UnblockRequest) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests = slices.DeleteFunc(s.requests, func(old UnblockRequest) bool {
+		return old.Domain == req.Domain && old.Client == req.Client
+	})
+	if len(s.requests) >= maxUnblockRequests {
+		s.requests = slices.Delete(s.requests, 0, len(s.requests)-maxUnblockRequests+1)
+	}
+	s.requests = append(s.requests, req)
+}
+
+// ServeHTTP 对任意路径返回拦截页，只有 UnblockPath 的 POST 请求用于提交解除申请
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	domain := normalizeHost(r.Host)
+	if r.URL.Path == UnblockPath && r.Method == http.MethodPost {
+		s.handleUnblockRequest(w, r, domain)
+		return
+	}
+	rule, _ := s.Lookup(domain)
+	renderPage(w, r, pageData{Domain: domain, Rule: rule})
+}
+
+func (s *Server) handleUnblockRequest(w http.ResponseWriter, r *http.Request, domain string) {
+	r.Body = http.MaxBytesReader(w, r.Body, 4096)
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+	if d := normalizeHost(r.PostForm.Get("domain")); d != "" {
+		domain = d
+	}
+	if domain == "" || len(domain) > 253 {
+		http.Error(w, "invalid domain", http.StatusBadRequest)
+		return
+	}
+	reason := strings.TrimSpace(r.PostForm.Get("reason"))
+	if len(reason) > maxReasonLength {
+		reason = reason[:maxReasonLength]
+	}
+	client := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(client); err == nil {
+		client = host
+	}
+	rule, _ := s.Lookup(domain)
+	s.addUnblockRequest(UnblockRequest{Domain: domain, Rule: rule, Client: client, Reason: reason, Time: s.now()})
+	logger.Infof("[BlockPage] Unblock requested for %s by %s", domain, client)
+
+	renderPage(w, r, pageData{Domain: domain, Rule: rule, Submitted: true})
+}
+
+// normalizeHost 去掉端口与末尾的点并转为小写
+func normalizeHost(host string) string {
+	host = strings.TrimSpace(host)
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.ToLower(strings.TrimSuffix(host, "."))
+}
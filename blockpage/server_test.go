@@ -0,0 +1,205 @@
+package blockpage
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRememberAndLookup(t *testing.T) {
+	s, err := New(Options{RecentTTL: time.Minute, MaxRecent: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	s.now = func() time.Time { return now }
+
+	s.Remember("Ads.Example.com.", "||ads.example.com^")
+	if rule, ok := s.Lookup("ads.example.com:80"); !ok || rule != "||ads.example.com^" {
+		t.Errorf("Lookup = %q %v, want the recorded rule", rule, ok)
+	}
+
+	s.Remember("b.example", "b")
+	s.Remember("c.example", "c") // 已满且没有过期记录，丢弃
+	if _, ok := s.Lookup("c.example"); ok {
+		t.Error("records beyond MaxRecent should be dropped")
+	}
+
+	now = now.Add(2 * time.Minute)
+	if _, ok := s.Lookup("ads.example.com"); ok {
+		t.Error("expired record should not be returned")
+	}
+	s.Remember("c.example", "c") // 过期记录被清理后可以写入
+	if _, ok := s.Lookup("c.example"); !ok {
+		t.Error("expired records should be pruned to make room")
+	}
+}
+
+func TestServeHTTP(t *testing.T) {
+	s, err := New(Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Remember("ads.example.com", "||ads.example.com^")
+
+	req := httptest.NewRequest(http.MethodGet, "http://ads.example.com/banner.js", nil)
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+	body := rec.Body.String()
+	if rec.Code != http.StatusForbidden || !strings.Contains(body, "ads.example.com") || !strings.Contains(body, "||ads.example.com^") {
+		t.Errorf("page should name the domain and rule, got %d %s", rec.Code, body)
+	}
+	if !strings.Contains(body, UnblockPath) {
+		t.Error("page should contain the unblock form")
+	}
+
+	form := url.Values{"domain": {"ads.example.com"}, "reason": {"needed for work"}}
+	req = httptest.NewRequest(http.MethodPost, "http://ads.example.com"+UnblockPath, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.RemoteAddr = "192.168.1.20:51000"
+	rec = httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+	if !strings.Contains(rec.Body.String(), "has been sent") {
+		t.Errorf("submitting should confirm the request, got %s", rec.Body.String())
+	}
+
+	// 同一客户端重复申请只保留一条
+	req = httptest.NewRequest(http.MethodPost, "http://ads.example.com"+UnblockPath, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.RemoteAddr = "192.168.1.20:51001"
+	s.ServeHTTP(httptest.NewRecorder(), req)
+
+	reqs := s.UnblockRequests()
+	if len(reqs) != 1 {
+		t.Fatalf("expected 1 unblock request, got %d", len(reqs))
+	}
+	if r := reqs[0]; r.Domain != "ads.example.com" || r.Rule != "||ads.example.com^" || r.Client != "192.168.1.20" || r.Reason != "needed for work" {
+		t.Errorf("unexpected request %+v", r)
+	}
+	s.ClearUnblockRequests()
+	if len(s.UnblockRequests()) != 0 {
+		t.Error("ClearUnblockRequests should drop all requests")
+	}
+}
+
+func TestHTTPSResetWithoutCA(t *testing.T) {
+	s, err := New(Options{HTTPSAddr: "127.0.0.1:0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Stop()
+
+	// 重置可能在建立连接时就已到达
+	conn, err := net.Dial("tcp", s.httpsLn.Addr().String())
+	if err != nil {
+		if !strings.Contains(err.Error(), "reset") {
+			t.Fatal(err)
+		}
+		return
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+	if _, err := io.ReadAll(conn); err != nil && !strings.Contains(err.Error(), "reset") {
+		t.Errorf("connection should be closed promptly, got %v", err)
+	}
+}
+
+// writeTestCA 生成自签名 CA 并写入 dir，返回证书池
+func writeTestCA(t *testing.T, dir string) (string, string, *x509.CertPool) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile, keyFile := filepath.Join(dir, "ca.pem"), filepath.Join(dir, "ca.key")
+	os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644)
+	os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600)
+
+	ca, _ := x509.ParseCertificate(der)
+	pool := x509.NewCertPool()
+	pool.AddCert(ca)
+	return certFile, keyFile, pool
+}
+
+func TestHTTPSWithLocalCA(t *testing.T) {
+	certFile, keyFile, pool := writeTestCA(t, t.TempDir())
+	if _, err := New(Options{CACertFile: certFile}); err == nil {
+		t.Error("CA certificate without key should be rejected")
+	}
+
+	s, err := New(Options{HTTPSAddr: "127.0.0.1:0", CACertFile: certFile, CAKeyFile: keyFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Remember("tracker.example.net", "tracker.example.net")
+
+	// 通过证书签发器直接验证链与域名
+	cert, err := s.issuer.getCertificate(&tls.ClientHelloInfo{ServerName: "tracker.example.net"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cert.Leaf.Verify(x509.VerifyOptions{DNSName: "tracker.example.net", Roots: pool}); err != nil {
+		t.Errorf("issued certificate should verify against the CA: %v", err)
+	}
+	if again, _ := s.issuer.getCertificate(&tls.ClientHelloInfo{ServerName: "tracker.example.net"}); again != cert {
+		t.Error("certificates should be cached per server name")
+	}
+
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Stop()
+
+	client := &http.Client{Transport: &http.Transport{
+		TLSClientConfig: &tls.Config{RootCAs: pool},
+		DialContext: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, network, s.httpsLn.Addr().String())
+		},
+	}}
+	defer client.CloseIdleConnections()
+	resp, err := client.Get("https://tracker.example.net/")
+	if err != nil {
+		t.Fatalf("HTTPS request should succeed with the local CA trusted: %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusForbidden || !strings.Contains(string(body), "tracker.example.net") {
+		t.Errorf("HTTPS should serve the block page, got %d %s", resp.StatusCode, body)
+	}
+}
@@ -0,0 +1,124 @@
+package blockpage
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	leafValidity  = 7 * 24 * time.Hour
+	leafRenewal   = time.Hour // 剩余有效期不足时重新签发
+	maxLeafCached = 1000
+)
+
+// certIssuer 用本地 CA 按 SNI 即时签发叶子证书，所有叶子证书共用一把密钥
+type certIssuer struct {
+	ca      *x509.Certificate
+	caKey   crypto.Signer
+	leafKey *ecdsa.PrivateKey
+
+	mu    sync.Mutex
+	certs map[string]*tls.Certificate
+}
+
+func loadCertIssuer(certFile, keyFile string) (*certIssuer, error) {
+	pair, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load CA: %w", err)
+	}
+	ca, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse CA certificate: %w", err)
+	}
+	if !ca.IsCA {
+		return nil, errors.New("ca_cert is not a CA certificate")
+	}
+	signer, ok := pair.PrivateKey.(crypto.Signer)
+	if !ok {
+		return nil, errors.New("unsupported CA private key")
+	}
+	leafKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return &certIssuer{ca: ca, caKey: signer, leafKey: leafKey, certs: make(map[string]*tls.Certificate)}, nil
+}
+
+// getCertificate 返回 SNI 对应的证书；未携带 SNI 的连接无法确定域名，直接握手失败
+func (ci *certIssuer) getCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	name := normalizeHost(hello.ServerName)
+	if name == "" {
+		return nil, errors.New("missing server name")
+	}
+	now := time.Now()
+
+	ci.mu.Lock()
+	defer ci.mu.Unlock()
+	if cert, ok := ci.certs[name]; ok && now.Add(leafRenewal).Before(cert.Leaf.NotAfter) {
+		return cert, nil
+	}
+	cert, err := ci.issue(name, now)
+	if err != nil {
+		return nil, err
+	}
+	if len(ci.certs) >= maxLeafCached {
+		clear(ci.certs)
+	}
+	ci.certs[name] = cert
+	return cert, nil
+}
+
+func (ci *certIssuer) issue(name string, now time.Time) (*tls.Certificate, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(leafValidity),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	if tmpl.NotAfter.After(ci.ca.NotAfter) {
+		tmpl.NotAfter = ci.ca.NotAfter
+	}
+	if ip := net.ParseIP(name); ip != nil {
+		tmpl.IPAddresses = []net.IP{ip}
+	} else {
+		tmpl.DNSNames = []string{name}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ci.ca, &ci.leafKey.PublicKey, ci.caKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to issue certificate for %s: %w", name, err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Certificate{
+		Certificate: [][]byte{der, ci.ca.Raw},
+		PrivateKey:  ci.leafKey,
+		Leaf:        leaf,
+	}, nil
+}
+
+func tlsListener(ln net.Listener, ci *certIssuer) net.Listener {
+	return tls.NewListener(ln, &tls.Config{
+		GetCertificate: ci.getCertificate,
+		MinVersion:     tls.VersionTLS12,
+		NextProtos:     []string{"http/1.1"},
+	})
+}
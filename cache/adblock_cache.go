@@ -88,6 +88,15 @@ func (c *Cache) SetAllowed(domain string, entry *AllowedCacheEntry) {
 	c.allowedCache[domain] = entry
 }
 
+// ClearAdBlockCaches 清空拦截与白名单缓存，过滤规则变化后使新规则立即生效
+func (c *Cache) ClearAdBlockCaches() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	clear(c.blockedCache)
+	clear(c.allowedCache)
+}
+
 // cleanAdBlockCaches 清理过期的 AdBlock 缓存
 // ⚠️ 调用此方法前必须持有 c.mu 锁！
 // 此方法由 CleanExpired 在持有锁的情况下调用，不要在其他地方直接调用
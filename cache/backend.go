@@ -0,0 +1,432 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"hash/fnv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"smartdnssort/logger"
+
+	"github.com/miekg/dns"
+)
+
+// Backend 外部缓存后端（L2），位于原始缓存和排序缓存的内存分片之下
+// 值为已序列化的条目，过期由后端按 ttl 自行处理
+type Backend interface {
+	// Get 读取键值，键不存在时返回 ok=false 且 err=nil
+	Get(ctx context.Context, key string) (value []byte, ok bool, err error)
+	// Set 写入键值并设置过期时间
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	// Delete 删除键
+	Delete(ctx context.Context, keys ...string) error
+	// Clear 删除本实例使用的全部键
+	Clear(ctx context.Context) error
+	Close() error
+}
+
+const (
+	backendRawPrefix    = "raw:"
+	backendSortedPrefix = "sorted:"
+	backendQueueSize    = 4096 // 每个写入 worker 的队列长度
+)
+
+// BackendStats 外部缓存后端统计
+type BackendStats struct {
+	Enabled bool  `json:"enabled"`
+	Hits    int64 `json:"hits"`    // L1 未命中、L2 命中
+	Misses  int64 `json:"misses"`  // L1、L2 均未命中
+	Writes  int64 `json:"writes"`  // 成功的写入/删除
+	Errors  int64 `json:"errors"`  // 读写错误（含超时）
+	Dropped int64 `json:"dropped"` // 写入队列满而丢弃的写入
+}
+
+// backendOp 异步写入操作，value 为 nil 表示删除；done 非空时在操作完成后通知等待方
+type backendOp struct {
+	key   string
+	value []byte
+	ttl   time.Duration
+	done  *sync.WaitGroup
+}
+
+// tieredBackend 包装 Backend，负责序列化、超时和异步写入
+// 同一个键的写入总是落在同一个 worker 上，保证顺序
+type tieredBackend struct {
+	backend      Backend
+	timeout      time.Duration
+	staleSeconds int64
+
+	// 队列从不关闭：closeMu 保证 close 之后不再有投递，worker 收到 stop 后写完队列中剩余的操作再退出
+	queues  []chan backendOp
+	stop    chan struct{}
+	closeMu sync.RWMutex
+	closed  bool
+	wg      sync.WaitGroup
+
+	hits    atomic.Int64
+	misses  atomic.Int64
+	writes  atomic.Int64
+	errors  atomic.Int64
+	dropped atomic.Int64
+}
+
+// SetBackend 在原始缓存和排序缓存之下挂载外部后端，内存分片作为 L1
+// workers 为异步写入并发数；staleSeconds 为条目过期后在后端继续保留的时间
+// 只应在启动阶段调用一次
+func (c *Cache) SetBackend(b Backend, workers int, timeout time.Duration, staleSeconds int) {
+	if b == nil {
+		return
+	}
+	workers = max(workers, 1)
+	if timeout <= 0 {
+		timeout = 100 * time.Millisecond
+	}
+	tb := &tieredBackend{
+		backend:      b,
+		timeout:      timeout,
+		staleSeconds: int64(staleSeconds),
+		queues:       make([]chan backendOp, workers),
+		stop:         make(chan struct{}),
+	}
+	for i := range tb.queues {
+		tb.queues[i] = make(chan backendOp, backendQueueSize)
+		tb.wg.Add(1)
+		go tb.writeWorker(tb.queues[i])
+	}
+	c.backend = tb
+}
+
+// GetBackendStats 获取外部后端统计，未启用时 Enabled=false
+func (c *Cache) GetBackendStats() BackendStats {
+	tb := c.backend
+	if tb == nil {
+		return BackendStats{}
+	}
+	return BackendStats{
+		Enabled: true,
+		Hits:    tb.hits.Load(),
+		Misses:  tb.misses.Load(),
+		Writes:  tb.writes.Load(),
+		Errors:  tb.errors.Load(),
+		Dropped: tb.dropped.Load(),
+	}
+}
+
+func (tb *tieredBackend) writeWorker(queue chan backendOp) {
+	defer tb.wg.Done()
+	for {
+		select {
+		case op := <-queue:
+			tb.apply(op)
+		case <-tb.stop:
+			for {
+				select {
+				case op := <-queue:
+					tb.apply(op)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+func (tb *tieredBackend) apply(op backendOp) {
+	if op.done != nil {
+		defer op.done.Done()
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), tb.timeout)
+	var err error
+	if op.value == nil {
+		err = tb.backend.Delete(ctx, op.key)
+	} else {
+		err = tb.backend.Set(ctx, op.key, op.value, op.ttl)
+	}
+	cancel()
+	if err != nil {
+		tb.errors.Add(1)
+		logger.Debugf("[CacheBackend] Write %s failed: %v", op.key, err)
+		return
+	}
+	tb.writes.Add(1)
+}
+
+func (tb *tieredBackend) queueFor(key string) chan backendOp {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return tb.queues[h.Sum32()%uint32(len(tb.queues))]
+}
+
+// enqueue 非阻塞投递写入，队列满或已关闭时丢弃（后端只是共享层，丢失写入不影响正确性）
+func (tb *tieredBackend) enqueue(op backendOp) {
+	tb.closeMu.RLock()
+	defer tb.closeMu.RUnlock()
+	if tb.closed {
+		tb.dropped.Add(1)
+		return
+	}
+	select {
+	case tb.queueFor(op.key) <- op:
+	default:
+		tb.dropped.Add(1)
+	}
+}
+
+// deleteSync 经由各键所属的 worker 删除并等待完成：删除排在该键之前已投递的写入之后，
+// 返回时后端中不再有这些键的旧值
+func (tb *tieredBackend) deleteSync(keys []string) {
+	tb.closeMu.RLock()
+	defer tb.closeMu.RUnlock()
+	if tb.closed {
+		return
+	}
+	var wg sync.WaitGroup
+	for _, key := range keys {
+		wg.Add(1)
+		tb.queueFor(key) <- backendOp{key: key, done: &wg}
+	}
+	wg.Wait()
+}
+
+func (tb *tieredBackend) get(key string) ([]byte, bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), tb.timeout)
+	defer cancel()
+	value, ok, err := tb.backend.Get(ctx, key)
+	if err != nil {
+		tb.errors.Add(1)
+		logger.Debugf("[CacheBackend] Read %s failed: %v", key, err)
+		return nil, false
+	}
+	if !ok {
+		tb.misses.Add(1)
+		return nil, false
+	}
+	tb.hits.Add(1)
+	return value, true
+}
+
+// expiryTTL 计算后端过期时间：剩余有效期加上陈旧保留期，已无保留价值时返回 0
+func (tb *tieredBackend) expiryTTL(expiresAt time.Time) time.Duration {
+	ttl := time.Until(expiresAt) + time.Duration(tb.staleSeconds)*time.Second
+	if ttl < time.Second {
+		return 0
+	}
+	return ttl
+}
+
+// close 停止写入 worker（等待队列写完）并关闭后端；之后的写入直接丢弃
+func (tb *tieredBackend) close() error {
+	tb.closeMu.Lock()
+	if tb.closed {
+		tb.closeMu.Unlock()
+		return nil
+	}
+	tb.closed = true
+	close(tb.stop)
+	tb.closeMu.Unlock()
+	tb.wg.Wait()
+	return tb.backend.Close()
+}
+
+// backendRawEntry 原始缓存条目的后端存储格式，记录以文本形式保存
+// 完整应答（含 Authority/Additional 段）以线路格式保存在 Msg 中
+type backendRawEntry struct {
+	Msg         []byte   `json:"m,omitempty"`
+	Records     []string `json:"r,omitempty"`
+	IPs         []string `json:"ips,omitempty"` // 仅在没有 Records 时保存（SetRaw 写入的条目）
+	CNAMEs      []string `json:"c,omitempty"`
+	UpstreamTTL uint32   `json:"ttl"`
+	AD          bool     `json:"ad,omitempty"`
+	Acquired    int64    `json:"at"` // UnixNano
+	Source      string   `json:"src,omitempty"`
+}
+
+// backendSortedEntry 排序结果的后端存储格式
+type backendSortedEntry struct {
+	IPs       []string `json:"ips"`
+	RTTs      []int    `json:"rtts"`
+	Timestamp int64    `json:"at"` // UnixNano
+	TTL       int      `json:"ttl"`
+}
+
+// storeRawToBackend 异步写入原始缓存条目
+func (c *Cache) storeRawToBackend(key string, entry *RawCacheEntry) {
+	tb := c.backend
+	if tb == nil {
+		return
+	}
+	ttl := tb.expiryTTL(entry.AcquisitionTime.Add(time.Duration(entry.EffectiveTTL) * time.Second))
+	if ttl == 0 {
+		return
+	}
+	stored := backendRawEntry{
+		CNAMEs:      entry.CNAMEs,
+		UpstreamTTL: entry.UpstreamTTL,
+		AD:          entry.AuthenticatedData,
+		Acquired:    entry.AcquisitionTime.UnixNano(),
+		Source:      entry.Source,
+	}
+	if len(entry.Records) > 0 {
+		stored.Records = make([]string, 0, len(entry.Records))
+		for _, rr := range entry.Records {
+			stored.Records = append(stored.Records, rr.String())
+		}
+	} else {
+		stored.IPs = entry.IPs
+	}
+	if entry.Msg != nil {
+		if packed, err := entry.Msg.Pack(); err == nil {
+			stored.Msg = packed
+		}
+	}
+	data, err := json.Marshal(stored)
+	if err != nil {
+		return
+	}
+	tb.enqueue(backendOp{key: backendRawPrefix + key, value: data, ttl: ttl})
+}
+
+// loadRawFromBackend L1 未命中时从后端读取原始缓存，命中后回填 L1
+// EffectiveTTL 按本地的 min/max TTL 策略重新计算
+func (c *Cache) loadRawFromBackend(key string) (*RawCacheEntry, bool) {
+	tb := c.backend
+	if tb == nil {
+		return nil, false
+	}
+	data, ok := tb.get(backendRawPrefix + key)
+	if !ok {
+		return nil, false
+	}
+	var stored backendRawEntry
+	if err := json.Unmarshal(data, &stored); err != nil {
+		tb.errors.Add(1)
+		return nil, false
+	}
+
+	records := make([]dns.RR, 0, len(stored.Records))
+	for _, s := range stored.Records {
+		rr, err := dns.NewRR(s)
+		if err != nil || rr == nil {
+			tb.errors.Add(1)
+			return nil, false
+		}
+		records = append(records, rr)
+	}
+	ips := stored.IPs
+	if len(records) > 0 {
+		ips = extractIPsFromRecords(records)
+	} else {
+		records = nil
+	}
+
+	acquired := time.Unix(0, stored.Acquired)
+	domain, _ := parseCacheKey(key)
+	effTTL := c.calculateEffectiveTTL(domain, stored.UpstreamTTL)
+
+	var msg *dns.Msg
+	if len(stored.Msg) > 0 {
+		msg = new(dns.Msg)
+		if err := msg.Unpack(stored.Msg); err != nil {
+			tb.errors.Add(1)
+			return nil, false
+		}
+		ageReplicaMsg(msg, effTTL)
+	}
+
+	entry := &RawCacheEntry{
+		Msg:               msg,
+		Records:           records,
+		IPs:               ips,
+		CNAMEs:            stored.CNAMEs,
+		UpstreamTTL:       stored.UpstreamTTL,
+		EffectiveTTL:      effTTL,
+		AcquisitionTime:   acquired,
+		AuthenticatedData: stored.AD,
+		QueryVersion:      stored.Acquired,
+		Source:            stored.Source,
+	}
+	c.rawCache.Set(key, entry)
+	c.addToExpiredHeap(key, acquired.Unix()+int64(effTTL), entry.QueryVersion)
+	return entry, true
+}
+
+// storeSortedToBackend 异步写入排序结果
+func (c *Cache) storeSortedToBackend(key string, entry *SortedCacheEntry) {
+	tb := c.backend
+	if tb == nil || !entry.IsValid || len(entry.IPs) == 0 {
+		return
+	}
+	ttl := tb.expiryTTL(entry.Timestamp.Add(time.Duration(entry.TTL) * time.Second))
+	if ttl == 0 {
+		return
+	}
+	data, err := json.Marshal(backendSortedEntry{
+		IPs:       entry.IPs,
+		RTTs:      entry.RTTs,
+		Timestamp: entry.Timestamp.UnixNano(),
+		TTL:       entry.TTL,
+	})
+	if err != nil {
+		return
+	}
+	tb.enqueue(backendOp{key: backendSortedPrefix + key, value: data, ttl: ttl})
+}
+
+// loadSortedFromBackend L1 未命中时从后端读取排序结果，命中后回填 L1
+func (c *Cache) loadSortedFromBackend(domain string, qtype uint16) (*SortedCacheEntry, bool) {
+	tb := c.backend
+	if tb == nil {
+		return nil, false
+	}
+	data, ok := tb.get(backendSortedPrefix + cacheKey(domain, qtype))
+	if !ok {
+		return nil, false
+	}
+	var stored backendSortedEntry
+	if err := json.Unmarshal(data, &stored); err != nil || len(stored.IPs) == 0 {
+		tb.errors.Add(1)
+		return nil, false
+	}
+	entry := &SortedCacheEntry{
+		IPs:          stored.IPs,
+		RTTs:         stored.RTTs,
+		Timestamp:    time.Unix(0, stored.Timestamp),
+		TTL:          stored.TTL,
+		IsValid:      true,
+		QueryVersion: stored.Timestamp,
+	}
+	c.setSorted(domain, qtype, entry)
+	return entry, true
+}
+
+// deleteFromBackend 同步删除原始缓存和排序结果，须在删除 L1 之前调用，
+// 避免其他查询在 L1 删除后从后端读回旧值
+func (c *Cache) deleteFromBackend(keys []string) {
+	tb := c.backend
+	if tb == nil || len(keys) == 0 {
+		return
+	}
+	backendKeys := make([]string, 0, 2*len(keys))
+	for _, key := range keys {
+		backendKeys = append(backendKeys, backendRawPrefix+key, backendSortedPrefix+key)
+	}
+	tb.deleteSync(backendKeys)
+}
+
+// clearBackend 清空后端中本实例前缀下的全部键
+func (c *Cache) clearBackend() {
+	tb := c.backend
+	if tb == nil {
+		return
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		if err := tb.backend.Clear(ctx); err != nil {
+			tb.errors.Add(1)
+			logger.Warnf("[CacheBackend] Clear failed: %v", err)
+		}
+	}()
+}
@@ -0,0 +1,306 @@
+package cache
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+)
+
+// RedisOptions Redis 后端配置
+type RedisOptions struct {
+	Addr        string
+	Password    string
+	DB          int
+	KeyPrefix   string        // 所有键的前缀，Clear 只删除该前缀下的键
+	PoolSize    int           // 最大连接数
+	DialTimeout time.Duration // 建立连接超时
+}
+
+// RedisBackend 使用 RESP 协议的外部缓存后端，兼容 Redis/KeyDB/Valkey 等
+// 只使用 GET/SET PX/DEL/SCAN 这几个基础命令，过期由服务端处理
+type RedisBackend struct {
+	opts  RedisOptions
+	conns chan *redisConn // 空闲连接
+	slots chan struct{}   // 连接数上限
+}
+
+// redisError 服务端返回的错误应答（-ERR ...），连接本身仍可复用
+type redisError string
+
+func (e redisError) Error() string { return "redis: " + string(e) }
+
+// NewRedisBackend 创建 Redis 后端，并用 PING 检查服务是否可用
+func NewRedisBackend(opts RedisOptions) (*RedisBackend, error) {
+	if opts.Addr == "" {
+		return nil, errors.New("redis address is empty")
+	}
+	if opts.PoolSize <= 0 {
+		opts.PoolSize = 16
+	}
+	if opts.DialTimeout <= 0 {
+		opts.DialTimeout = 2 * time.Second
+	}
+	b := &RedisBackend{
+		opts:  opts,
+		conns: make(chan *redisConn, opts.PoolSize),
+		slots: make(chan struct{}, opts.PoolSize),
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), opts.DialTimeout)
+	defer cancel()
+	reply, err := b.do(ctx, "PING")
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to redis %s: %w", opts.Addr, err)
+	}
+	if s, _ := reply.(string); s != "PONG" {
+		return nil, fmt.Errorf("unexpected PING reply from %s: %v", opts.Addr, reply)
+	}
+	return b, nil
+}
+
+// Get 实现 Backend
+func (b *RedisBackend) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	reply, err := b.do(ctx, "GET", b.opts.KeyPrefix+key)
+	if err != nil {
+		return nil, false, err
+	}
+	if reply == nil {
+		return nil, false, nil
+	}
+	value, ok := reply.([]byte)
+	if !ok {
+		return nil, false, fmt.Errorf("unexpected GET reply type %T", reply)
+	}
+	return value, true, nil
+}
+
+// Set 实现 Backend，使用 SET ... PX 由服务端负责过期
+func (b *RedisBackend) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	ms := max(ttl.Milliseconds(), 1)
+	_, err := b.do(ctx, "SET", b.opts.KeyPrefix+key, value, "PX", strconv.FormatInt(ms, 10))
+	return err
+}
+
+// Delete 实现 Backend
+func (b *RedisBackend) Delete(ctx context.Context, keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	args := make([]any, 0, len(keys)+1)
+	args = append(args, "DEL")
+	for _, key := range keys {
+		args = append(args, b.opts.KeyPrefix+key)
+	}
+	_, err := b.do(ctx, args...)
+	return err
+}
+
+// Clear 实现 Backend，通过 SCAN 遍历前缀下的键并分批删除，不使用 FLUSHDB 以免影响其他数据
+func (b *RedisBackend) Clear(ctx context.Context) error {
+	cursor := "0"
+	for {
+		reply, err := b.do(ctx, "SCAN", cursor, "MATCH", b.opts.KeyPrefix+"*", "COUNT", "500")
+		if err != nil {
+			return err
+		}
+		parts, ok := reply.([]any)
+		if !ok || len(parts) != 2 {
+			return fmt.Errorf("unexpected SCAN reply: %v", reply)
+		}
+		next, _ := parts[0].([]byte)
+		keys, _ := parts[1].([]any)
+		if len(keys) > 0 {
+			args := make([]any, 0, len(keys)+1)
+			args = append(args, "DEL")
+			args = append(args, keys...)
+			if _, err := b.do(ctx, args...); err != nil {
+				return err
+			}
+		}
+		cursor = string(next)
+		if cursor == "0" || cursor == "" {
+			return nil
+		}
+	}
+}
+
+// Close 关闭所有空闲连接，之后的请求会重新建立连接
+func (b *RedisBackend) Close() error {
+	for {
+		select {
+		case conn := <-b.conns:
+			conn.Close()
+			<-b.slots
+		default:
+			return nil
+		}
+	}
+}
+
+// do 从连接池取连接执行一条命令
+// 网络错误时丢弃连接；服务端错误应答不影响连接复用
+func (b *RedisBackend) do(ctx context.Context, args ...any) (any, error) {
+	conn, err := b.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	reply, err := conn.do(ctx, args...)
+	var replyErr redisError
+	if err != nil && !errors.As(err, &replyErr) {
+		conn.Close()
+		<-b.slots
+		return nil, err
+	}
+	b.conns <- conn
+	return reply, err
+}
+
+func (b *RedisBackend) acquire(ctx context.Context) (*redisConn, error) {
+	select {
+	case conn := <-b.conns:
+		return conn, nil
+	default:
+	}
+	select {
+	case conn := <-b.conns:
+		return conn, nil
+	case b.slots <- struct{}{}:
+		conn, err := b.dial(ctx)
+		if err != nil {
+			<-b.slots
+			return nil, err
+		}
+		return conn, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// dial 建立新连接并完成 AUTH/SELECT
+func (b *RedisBackend) dial(ctx context.Context) (*redisConn, error) {
+	dialer := net.Dialer{Timeout: b.opts.DialTimeout}
+	nc, err := dialer.DialContext(ctx, "tcp", b.opts.Addr)
+	if err != nil {
+		return nil, err
+	}
+	conn := &redisConn{Conn: nc, r: bufio.NewReader(nc), w: bufio.NewWriter(nc)}
+	if b.opts.Password != "" {
+		if _, err := conn.do(ctx, "AUTH", b.opts.Password); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("AUTH failed: %w", err)
+		}
+	}
+	if b.opts.DB != 0 {
+		if _, err := conn.do(ctx, "SELECT", strconv.Itoa(b.opts.DB)); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("SELECT %d failed: %w", b.opts.DB, err)
+		}
+	}
+	return conn, nil
+}
+
+// redisConn 单个 RESP 连接
+type redisConn struct {
+	net.Conn
+	r *bufio.Reader
+	w *bufio.Writer
+}
+
+// do 发送命令并读取应答，超时取自 ctx
+func (c *redisConn) do(ctx context.Context, args ...any) (any, error) {
+	if deadline, ok := ctx.Deadline(); ok {
+		c.SetDeadline(deadline)
+	} else {
+		c.SetDeadline(time.Time{})
+	}
+	if err := writeRESPCommand(c.w, args); err != nil {
+		return nil, err
+	}
+	if err := c.w.Flush(); err != nil {
+		return nil, err
+	}
+	return readRESPReply(c.r)
+}
+
+// writeRESPCommand 以 RESP 数组形式编码命令，参数支持 string 和 []byte
+func writeRESPCommand(w *bufio.Writer, args []any) error {
+	w.WriteString("*" + strconv.Itoa(len(args)) + "\r\n")
+	for _, arg := range args {
+		var data []byte
+		switch v := arg.(type) {
+		case string:
+			data = []byte(v)
+		case []byte:
+			data = v
+		default:
+			return fmt.Errorf("unsupported RESP argument type %T", arg)
+		}
+		w.WriteString("$" + strconv.Itoa(len(data)) + "\r\n")
+		w.Write(data)
+		w.WriteString("\r\n")
+	}
+	return nil
+}
+
+// readRESPReply 读取一个应答
+// 简单字符串返回 string，整数返回 int64，批量字符串返回 []byte，数组返回 []any，空值返回 nil
+func readRESPReply(r *bufio.Reader) (any, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, fmt.Errorf("malformed RESP line %q", line)
+	}
+	prefix, body := line[0], line[1:len(line)-2]
+	switch prefix {
+	case '+':
+		return body, nil
+	case '-':
+		return nil, redisError(body)
+	case ':':
+		return strconv.ParseInt(body, 10, 64)
+	case '$':
+		n, err := strconv.Atoi(body)
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		return buf[:n], nil
+	case '*':
+		n, err := strconv.Atoi(body)
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		items := make([]any, n)
+		for i := range items {
+			item, err := readRESPReply(r)
+			var replyErr redisError
+			if errors.As(err, &replyErr) {
+				// 数组中的错误项作为值返回，保证整个应答被读完
+				items[i] = replyErr
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			items[i] = item
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("unknown RESP type %q", prefix)
+}
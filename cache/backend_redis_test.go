@@ -0,0 +1,335 @@
+package cache
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeRedis 测试用的 RESP 服务，支持 PING/AUTH/SELECT/GET/SET PX/DEL/SCAN 和按 PX 过期
+type fakeRedis struct {
+	ln       net.Listener
+	password string
+
+	mu      sync.Mutex
+	data    map[string][]byte
+	expires map[string]time.Time
+	conns   []net.Conn
+}
+
+func newFakeRedis(t *testing.T, password string) *fakeRedis {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	f := &fakeRedis{ln: ln, password: password, data: map[string][]byte{}, expires: map[string]time.Time{}}
+	go f.serve()
+	t.Cleanup(func() { ln.Close() })
+	return f
+}
+
+func (f *fakeRedis) addr() string { return f.ln.Addr().String() }
+
+func (f *fakeRedis) serve() {
+	for {
+		conn, err := f.ln.Accept()
+		if err != nil {
+			return
+		}
+		f.mu.Lock()
+		f.conns = append(f.conns, conn)
+		f.mu.Unlock()
+		go f.handle(conn)
+	}
+}
+
+func (f *fakeRedis) handle(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	authed := f.password == ""
+	for {
+		reply, err := readRESPReply(r)
+		if err != nil {
+			return
+		}
+		items, _ := reply.([]any)
+		args := make([]string, len(items))
+		for i, item := range items {
+			b, _ := item.([]byte)
+			args[i] = string(b)
+		}
+		if len(args) == 0 {
+			return
+		}
+		cmd := strings.ToUpper(args[0])
+		if !authed && cmd != "AUTH" {
+			conn.Write([]byte("-NOAUTH Authentication required.\r\n"))
+			continue
+		}
+		switch cmd {
+		case "AUTH":
+			if args[1] != f.password {
+				conn.Write([]byte("-WRONGPASS invalid password\r\n"))
+				continue
+			}
+			authed = true
+			conn.Write([]byte("+OK\r\n"))
+		case "PING":
+			conn.Write([]byte("+PONG\r\n"))
+		case "SELECT":
+			conn.Write([]byte("+OK\r\n"))
+		case "GET":
+			value, ok := f.get(args[1])
+			if !ok {
+				conn.Write([]byte("$-1\r\n"))
+				continue
+			}
+			conn.Write([]byte("$" + strconv.Itoa(len(value)) + "\r\n" + string(value) + "\r\n"))
+		case "SET":
+			f.mu.Lock()
+			f.data[args[1]] = []byte(args[2])
+			delete(f.expires, args[1])
+			if len(args) == 5 && strings.ToUpper(args[3]) == "PX" {
+				ms, _ := strconv.Atoi(args[4])
+				f.expires[args[1]] = time.Now().Add(time.Duration(ms) * time.Millisecond)
+			}
+			f.mu.Unlock()
+			conn.Write([]byte("+OK\r\n"))
+		case "DEL":
+			n := 0
+			f.mu.Lock()
+			for _, key := range args[1:] {
+				if _, ok := f.data[key]; ok {
+					delete(f.data, key)
+					delete(f.expires, key)
+					n++
+				}
+			}
+			f.mu.Unlock()
+			conn.Write([]byte(":" + strconv.Itoa(n) + "\r\n"))
+		case "SCAN":
+			// 一次返回全部匹配的键
+			prefix := strings.TrimSuffix(args[3], "*")
+			var keys []string
+			f.mu.Lock()
+			for key := range f.data {
+				if strings.HasPrefix(key, prefix) {
+					keys = append(keys, key)
+				}
+			}
+			f.mu.Unlock()
+			var sb strings.Builder
+			sb.WriteString("*2\r\n$1\r\n0\r\n*" + strconv.Itoa(len(keys)) + "\r\n")
+			for _, key := range keys {
+				sb.WriteString("$" + strconv.Itoa(len(key)) + "\r\n" + key + "\r\n")
+			}
+			conn.Write([]byte(sb.String()))
+		default:
+			conn.Write([]byte("-ERR unknown command '" + cmd + "'\r\n"))
+		}
+	}
+}
+
+// shutdown 关闭监听和所有已建立的连接，模拟后端宕机
+func (f *fakeRedis) shutdown() {
+	f.ln.Close()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, conn := range f.conns {
+		conn.Close()
+	}
+}
+
+func (f *fakeRedis) get(key string) ([]byte, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if exp, ok := f.expires[key]; ok && time.Now().After(exp) {
+		delete(f.data, key)
+		delete(f.expires, key)
+	}
+	value, ok := f.data[key]
+	return value, ok
+}
+
+func (f *fakeRedis) ttl(key string) time.Duration {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return time.Until(f.expires[key])
+}
+
+func (f *fakeRedis) set(key, value string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.data[key] = []byte(value)
+}
+
+func TestRedisBackendCommands(t *testing.T) {
+	srv := newFakeRedis(t, "secret")
+	ctx := context.Background()
+
+	_, err := NewRedisBackend(RedisOptions{Addr: srv.addr(), Password: "wrong"})
+	assert.Error(t, err, "wrong password should fail")
+
+	b, err := NewRedisBackend(RedisOptions{Addr: srv.addr(), Password: "secret", KeyPrefix: "t:", PoolSize: 2})
+	require.NoError(t, err)
+	defer b.Close()
+
+	require.NoError(t, b.Set(ctx, "k1", []byte("v1\r\nwith crlf"), time.Minute))
+	value, ok, err := b.Get(ctx, "k1")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "v1\r\nwith crlf", string(value))
+
+	_, ok, err = b.Get(ctx, "missing")
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	// 服务端过期
+	require.NoError(t, b.Set(ctx, "short", []byte("x"), 30*time.Millisecond))
+	time.Sleep(60 * time.Millisecond)
+	_, ok, _ = b.Get(ctx, "short")
+	assert.False(t, ok)
+
+	require.NoError(t, b.Delete(ctx, "k1"))
+	_, ok, _ = b.Get(ctx, "k1")
+	assert.False(t, ok)
+
+	// Clear 只删除本前缀下的键
+	srv.set("other:keep", "1")
+	require.NoError(t, b.Set(ctx, "a", []byte("1"), time.Minute))
+	require.NoError(t, b.Set(ctx, "b", []byte("2"), time.Minute))
+	require.NoError(t, b.Clear(ctx))
+	_, ok, _ = b.Get(ctx, "a")
+	assert.False(t, ok)
+	_, ok = srv.get("other:keep")
+	assert.True(t, ok)
+}
+
+func newBackendTestCache(t *testing.T, addr string) *Cache {
+	t.Helper()
+	b, err := NewRedisBackend(RedisOptions{Addr: addr, KeyPrefix: "sdns:"})
+	require.NoError(t, err)
+	c := NewCache(getDefaultCacheConfig())
+	c.SetBackend(b, 2, time.Second, 60)
+	return c
+}
+
+func waitBackendWrites(t *testing.T, c *Cache, n int64) {
+	t.Helper()
+	deadline := time.Now().Add(3 * time.Second)
+	for c.GetBackendStats().Writes < n {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %d backend writes, got %+v", n, c.GetBackendStats())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestBackendSharedBetweenInstances(t *testing.T) {
+	srv := newFakeRedis(t, "")
+	writer := newBackendTestCache(t, srv.addr())
+	reader := newBackendTestCache(t, srv.addr())
+
+	writer.SetRawRecordsWithSource("www.example.com", dns.TypeA, newTestARecords("www.example.com", "1.1.1.1", "2.2.2.2"), []string{"cdn.example.net."}, 300, true, "8.8.8.8:53")
+	writer.SetSorted("www.example.com", dns.TypeA, &SortedCacheEntry{
+		IPs: []string{"2.2.2.2", "1.1.1.1"}, RTTs: []int{5, 40}, Timestamp: time.Now(), TTL: 300, IsValid: true,
+	})
+	waitBackendWrites(t, writer, 2)
+
+	// 服务端过期时间 = 剩余有效期 + 陈旧保留期
+	ttl := srv.ttl("sdns:raw:" + cacheKey("www.example.com", dns.TypeA))
+	assert.InDelta(t, 360, ttl.Seconds(), 2)
+
+	raw, ok := reader.GetRaw("www.example.com", dns.TypeA)
+	require.True(t, ok, "reader should fall through to the shared backend")
+	assert.Equal(t, []string{"1.1.1.1", "2.2.2.2"}, raw.IPs)
+	assert.Len(t, raw.Records, 2)
+	assert.Equal(t, []string{"cdn.example.net."}, raw.CNAMEs)
+	assert.True(t, raw.AuthenticatedData)
+	assert.Equal(t, "8.8.8.8:53", raw.Source)
+
+	sorted, ok := reader.GetSorted("www.example.com", dns.TypeA)
+	require.True(t, ok)
+	assert.Equal(t, []string{"2.2.2.2", "1.1.1.1"}, sorted.IPs)
+
+	// 回填 L1 后不再访问后端
+	_, ok = reader.GetRaw("www.example.com", dns.TypeA)
+	assert.True(t, ok)
+	assert.Equal(t, int64(2), reader.GetBackendStats().Hits)
+
+	// 未命中
+	_, ok = reader.GetRaw("missing.example.com", dns.TypeA)
+	assert.False(t, ok)
+	assert.Equal(t, int64(1), reader.GetBackendStats().Misses)
+}
+
+func TestBackendKeepsFullMessage(t *testing.T) {
+	srv := newFakeRedis(t, "")
+	writer := newBackendTestCache(t, srv.addr())
+	reader := newBackendTestCache(t, srv.addr())
+
+	msg := new(dns.Msg)
+	msg.SetQuestion("example.com.", dns.TypeMX)
+	msg.Answer = []dns.RR{mustRR(t, "example.com. 3600 IN MX 10 mail.example.com.")}
+	msg.Ns = []dns.RR{mustRR(t, "example.com. 7200 IN NS ns1.example.com.")}
+	msg.Extra = []dns.RR{mustRR(t, "mail.example.com. 300 IN A 192.0.2.25")}
+	writer.SetRawMessage("example.com", dns.TypeMX, msg, msg.Answer, nil, 600, false, "")
+	waitBackendWrites(t, writer, 1)
+
+	raw, ok := reader.GetRaw("example.com", dns.TypeMX)
+	require.True(t, ok)
+	require.NotNil(t, raw.Msg, "authority and additional sections should survive the backend")
+	assert.Len(t, raw.Msg.Ns, 1)
+	assert.Len(t, raw.Msg.Extra, 1)
+	// 记录 TTL 不超过本地缓存寿命
+	assert.LessOrEqual(t, raw.Msg.Ns[0].Header().Ttl, raw.EffectiveTTL)
+}
+
+func TestBackendPurgeDeletesSharedEntries(t *testing.T) {
+	srv := newFakeRedis(t, "")
+	c := newBackendTestCache(t, srv.addr())
+
+	c.SetRawRecordsWithSource("www.example.com", dns.TypeA, newTestARecords("www.example.com", "1.1.1.1"), nil, 300, false, "")
+	waitBackendWrites(t, c, 1)
+
+	// Purge 返回时后端删除已完成
+	c.Purge(EntryFilter{Name: "www.example.com"})
+	assert.Equal(t, int64(3), c.GetBackendStats().Writes) // raw 和 sorted 两个删除
+
+	other := newBackendTestCache(t, srv.addr())
+	_, ok := other.GetRaw("www.example.com", dns.TypeA)
+	assert.False(t, ok, "purged entry should be gone from the shared backend")
+}
+
+func TestBackendWritesAfterClose(t *testing.T) {
+	srv := newFakeRedis(t, "")
+	c := newBackendTestCache(t, srv.addr())
+	require.NoError(t, c.Close())
+
+	// 关闭后仍在运行的查询/刷新协程的写入被丢弃，不能 panic
+	c.storeRawToBackend(cacheKey("www.example.com", dns.TypeA), &RawCacheEntry{IPs: []string{"1.1.1.1"}, EffectiveTTL: 300, AcquisitionTime: time.Now()})
+	c.deleteFromBackend([]string{cacheKey("www.example.com", dns.TypeA)})
+	assert.Equal(t, int64(1), c.GetBackendStats().Dropped)
+}
+
+func TestBackendUnavailableFallsBack(t *testing.T) {
+	srv := newFakeRedis(t, "")
+	c := newBackendTestCache(t, srv.addr())
+	srv.shutdown()
+
+	// 后端不可用时只影响命中率，不影响本地缓存
+	c.SetRawRecordsWithSource("www.example.com", dns.TypeA, newTestARecords("www.example.com", "1.1.1.1"), nil, 300, false, "")
+	_, ok := c.GetRaw("www.example.com", dns.TypeA)
+	assert.True(t, ok)
+	_, ok = c.GetRaw("missing.example.com", dns.TypeA)
+	assert.False(t, ok)
+	assert.Greater(t, c.GetBackendStats().Errors, int64(0))
+}
@@ -35,6 +35,10 @@ type Cache struct {
 	blockedCache map[string]*BlockedCacheEntry // 拦截缓存
 	allowedCache map[string]*AllowedCacheEntry // 白名单缓存
 	msgCache     *LRUCache                     // DNSSEC 消息缓存（存储完整的 DNS 响应）
+	nsec         *nsecCache                    // 积极否定缓存（NSEC/NSEC3 证明）
+
+	// 按字节的内存预算：raw/sorted/error/msg 共享 max_memory_mb，msgCache 在预算内另受 msg_cache_size_mb 约束
+	memBudget *memoryBudget // 未设置 max_memory_mb 时为 nil
 
 	// 统计和其他字段
 	prefetcher      PrefetchChecker        // Prefetcher 实例，用于热点域名保护
@@ -61,6 +65,18 @@ type Cache struct {
 	// 持久化状态追踪
 	lastSavedDirty uint64
 
+	// 预写日志，未调用 OpenPersistence 时为 nil
+	wal atomic.Pointer[walWriter]
+
+	// 按域名的 TTL 策略规则，无锁读取，由 SetTTLRules 整体替换
+	ttlRules atomic.Pointer[[]ttlRule]
+
+	// 节点间复制回调（ReplicationHook），无锁读取
+	replicationHook atomic.Value
+
+	// 外部缓存后端（L2），未启用时为 nil，仅在启动阶段由 SetBackend 设置
+	backend *tieredBackend
+
 	// 监控指标
 	heapChannelFullCount int64 // channel 满的次数（原子操作）
 
@@ -76,8 +92,8 @@ func NewCache(cfg *config.CacheConfig) *Cache {
 	// 计算 msgCache 的最大条目数
 	msgCacheEntries := 0
 	if cfg.MsgCacheSizeMB > 0 {
-		// 假设平均 DNS 消息 ~2KB，计算最大条目数
-		msgCacheEntries = (cfg.MsgCacheSizeMB * 1024 * 1024) / 2048
+		// 条目数只是兜底上限，按每条消息 ~512 字节计算，实际容量由字节记账控制
+		msgCacheEntries = (cfg.MsgCacheSizeMB * 1024 * 1024) / 512
 		msgCacheEntries = max(msgCacheEntries, 10) // 最小 10 条
 	}
 
@@ -91,12 +107,20 @@ func NewCache(cfg *config.CacheConfig) *Cache {
 		blockedCache:    make(map[string]*BlockedCacheEntry),
 		allowedCache:    make(map[string]*AllowedCacheEntry),
 		msgCache:        NewLRUCache(msgCacheEntries),
+		nsec:            newNSECCache(cfg.NSECCacheMaxEntries),
 		recentlyBlocked: NewRecentlyBlockedTracker(),
 		expiredHeap:     make(expireHeap, 0),
 		addHeapChan:     make(chan expireEntry, 10000), // 增加缓冲至 10000，消除突发流量下的阻塞点
 		stopHeapChan:    make(chan struct{}),
 	}
 
+	// 按实际字节记账：条目数上限仍然保留，字节预算先触发时按 LRU 驱逐
+	c.memBudget = newMemoryBudget(int64(cfg.MaxMemoryMB) * 1024 * 1024)
+	c.rawCache.enableAccounting(c.memBudget.account(0))
+	c.sortedCache.enableAccounting(c.memBudget.account(0))
+	c.errorCache.enableAccounting(c.memBudget.account(0))
+	c.msgCache.enableAccounting(c.memBudget.account(int64(cfg.MsgCacheSizeMB) * 1024 * 1024))
+
 	// 设置 sortedCache 的驱逐回调，用于更新 IP 池引用计数
 	c.sortedCache.SetOnEvict(func(key string, value any) {
 		if c.ipPoolUpdater != nil {
@@ -168,6 +192,9 @@ func (c *Cache) Clear() {
 	c.blockedCache = make(map[string]*BlockedCacheEntry)
 	c.allowedCache = make(map[string]*AllowedCacheEntry)
 	c.msgCache.Clear()
+	c.nsec.clear()
+	c.clearBackend()
+	c.logWALClear()
 
 	// 清空过期堆和统计
 	c.expiredHeap = make(expireHeap, 0)
@@ -200,6 +227,9 @@ func (c *Cache) Close() error {
 	if c.msgCache != nil {
 		c.msgCache.Close()
 	}
+	if c.backend != nil {
+		return c.backend.close()
+	}
 
 	return nil
 }
@@ -52,7 +52,7 @@ func (c *Cache) CleanExpired() {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	usage := c.getMemoryUsagePercentLocked()
+	usage := c.GetMemoryUsagePercent()
 	now := timeNow().Unix()
 
 	// 压力阈值：优先使用用户配置，若未设置则默认 0.9
@@ -192,6 +192,7 @@ func (c *Cache) CleanExpired() {
 
 		if shouldDelete {
 			c.rawCache.Delete(entry.key)
+			c.logWALDelete(entry.key)
 			atomic.AddInt64(&c.evictions, 1) // 记录驱逐
 			heap.Pop(&c.expiredHeap)
 			c.actualExpiredCount-- // 增量更新：删除时递减
@@ -259,6 +260,8 @@ func (c *Cache) cleanAuxiliaryCaches(ancientLimit int64) {
 	c.cleanExpiredSortedCache(ancientLimit)
 	// 清理过期的错误缓存
 	c.cleanExpiredErrorCache()
+	// 清理过期的否定证明
+	c.cleanExpiredNSEC()
 	// 清理完成的排序任务
 	c.cleanCompletedSortingStates()
 
@@ -21,14 +21,14 @@ func (c *Cache) GetError(domain string, qtype uint16) (*ErrorCacheEntry, bool) {
 	return entry, true
 }
 
-// SetError 设置错误缓存
+// SetError 设置错误缓存，TTL 受按域名规则的 max_ttl_seconds 限制
 // 注意：errorCache 内部已实现线程安全，无需全局锁
 func (c *Cache) SetError(domain string, qtype uint16, rcode int, ttl int) {
 	key := cacheKey(domain, qtype)
 	entry := &ErrorCacheEntry{
 		Rcode:    rcode,
 		CachedAt: timeNow(),
-		TTL:      ttl,
+		TTL:      c.ClampErrorTTL(domain, ttl),
 	}
 	c.errorCache.Set(key, entry)
 }
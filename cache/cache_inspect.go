@@ -0,0 +1,359 @@
+package cache
+
+import (
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// EntryFilter 缓存检视和定向清除的匹配条件，各条件之间为"与"关系
+type EntryFilter struct {
+	Name   string // 精确域名，或 *.example.com（匹配所有子域名，不含 example.com 本身）
+	Search string // 域名子串，仅用于检视
+	Qtype  uint16 // 查询类型，0 表示所有类型
+}
+
+// normalizeDomain 统一为小写并去掉末尾的点，与缓存键保持一致
+func normalizeDomain(domain string) string {
+	return strings.ToLower(strings.TrimRight(strings.TrimSpace(domain), "."))
+}
+
+// matchDomain 判断域名是否满足 Name/Search 条件
+func (f EntryFilter) matchDomain(domain string) bool {
+	domain = normalizeDomain(domain)
+	if name := normalizeDomain(f.Name); name != "" {
+		if suffix, ok := strings.CutPrefix(name, "*."); ok {
+			if !strings.HasSuffix(domain, "."+suffix) {
+				return false
+			}
+		} else if domain != name {
+			return false
+		}
+	}
+	if f.Search != "" && !strings.Contains(domain, strings.ToLower(f.Search)) {
+		return false
+	}
+	return true
+}
+
+// match 判断缓存键是否满足过滤条件
+func (f EntryFilter) match(domain string, qtype uint16) bool {
+	if f.Qtype != 0 && qtype != f.Qtype {
+		return false
+	}
+	return f.matchDomain(domain)
+}
+
+// CacheEntryInfo 单个缓存条目在各缓存层中的状态，用于 /api/cache/entries
+// 拦截/白名单缓存按域名存储，不区分查询类型，对应条目的 Qtype 为空
+type CacheEntryInfo struct {
+	Domain string   `json:"domain"`
+	Qtype  string   `json:"qtype,omitempty"`
+	Layers []string `json:"layers"` // raw, sorted, sorting, error, dnssec, blocked, allowed
+
+	// 原始缓存
+	State             string   `json:"state,omitempty"` // fresh, stale, expired
+	RemainingTTL      int32    `json:"remaining_ttl"`
+	UpstreamTTL       uint32   `json:"upstream_ttl,omitempty"`
+	EffectiveTTL      uint32   `json:"effective_ttl,omitempty"`
+	AgeSeconds        int64    `json:"age_seconds,omitempty"`
+	IPs               []string `json:"ips,omitempty"`
+	CNAMEs            []string `json:"cnames,omitempty"`
+	Records           int      `json:"records,omitempty"`
+	Source            string   `json:"source,omitempty"`
+	AuthenticatedData bool     `json:"authenticated_data,omitempty"`
+
+	// 排序缓存
+	SortState string   `json:"sort_state,omitempty"` // sorted, sort_expired, sorting
+	SortedIPs []string `json:"sorted_ips,omitempty"`
+	RTTs      []int    `json:"rtts,omitempty"`
+
+	// 错误缓存
+	ErrorRcode string `json:"error_rcode,omitempty"`
+
+	// 拦截/白名单缓存
+	BlockType string `json:"block_type,omitempty"`
+	BlockRule string `json:"block_rule,omitempty"`
+}
+
+// ListEntries 按条件列出缓存条目，结果按域名和查询类型排序
+// limit <= 0 表示不限制；第二个返回值为满足条件的条目总数
+// 读取使用 GetNoUpdate，不影响 LRU 顺序
+func (c *Cache) ListEntries(filter EntryFilter, limit int) ([]CacheEntryInfo, int) {
+	entries := make(map[string]*CacheEntryInfo)
+	get := func(key string) *CacheEntryInfo {
+		if e, ok := entries[key]; ok {
+			return e
+		}
+		domain, qtype := parseCacheKey(key)
+		if domain == "" || !filter.match(domain, qtype) {
+			return nil
+		}
+		e := &CacheEntryInfo{Domain: domain, Qtype: dns.TypeToString[qtype]}
+		if e.Qtype == "" {
+			e.Qtype = dns.Type(qtype).String()
+		}
+		entries[key] = e
+		return e
+	}
+
+	now := timeNow()
+
+	for _, key := range c.rawCache.GetAllKeys() {
+		value, ok := c.rawCache.GetNoUpdate(key)
+		raw, isRaw := value.(*RawCacheEntry)
+		if !ok || !isRaw {
+			continue
+		}
+		e := get(key)
+		if e == nil {
+			continue
+		}
+		e.Layers = append(e.Layers, "raw")
+		switch raw.GetState(AncientLimitLowPressure) {
+		case FRESH:
+			e.State = "fresh"
+		case STALE:
+			e.State = "stale"
+		default:
+			e.State = "expired"
+		}
+		e.RemainingTTL = raw.GetRemainingTTL()
+		e.UpstreamTTL = raw.UpstreamTTL
+		e.EffectiveTTL = raw.EffectiveTTL
+		e.AgeSeconds = int64(now.Sub(raw.AcquisitionTime) / time.Second)
+		e.IPs = raw.IPs
+		e.CNAMEs = raw.CNAMEs
+		e.Records = len(raw.Records)
+		e.Source = raw.Source
+		e.AuthenticatedData = raw.AuthenticatedData
+	}
+
+	for _, key := range c.sortedCache.GetAllKeys() {
+		value, ok := c.sortedCache.GetNoUpdate(key)
+		sorted, isSorted := value.(*SortedCacheEntry)
+		if !ok || !isSorted {
+			continue
+		}
+		e := get(key)
+		if e == nil {
+			continue
+		}
+		e.Layers = append(e.Layers, "sorted")
+		e.SortState = "sorted"
+		if sorted.IsExpired() {
+			e.SortState = "sort_expired"
+		}
+		e.SortedIPs = sorted.IPs
+		e.RTTs = sorted.RTTs
+	}
+
+	for _, key := range c.errorCache.GetAllKeys() {
+		value, ok := c.errorCache.GetNoUpdate(key)
+		errEntry, isErr := value.(*ErrorCacheEntry)
+		if !ok || !isErr || errEntry.IsExpired() {
+			continue
+		}
+		if e := get(key); e != nil {
+			e.Layers = append(e.Layers, "error")
+			e.ErrorRcode = dns.RcodeToString[errEntry.Rcode]
+		}
+	}
+
+	for _, key := range c.msgCache.GetAllKeys() {
+		value, ok := c.msgCache.GetNoUpdate(key)
+		msgEntry, isMsg := value.(*DNSSECCacheEntry)
+		if !ok || !isMsg || msgEntry.IsExpired() {
+			continue
+		}
+		if e := get(key); e != nil {
+			e.Layers = append(e.Layers, "dnssec")
+		}
+	}
+
+	c.mu.RLock()
+	for key, state := range c.sortingState {
+		if !state.InProgress {
+			continue
+		}
+		if e := get(key); e != nil {
+			e.Layers = append(e.Layers, "sorting")
+			e.SortState = "sorting"
+		}
+	}
+
+	// 拦截/白名单缓存不区分查询类型，按类型过滤时不列出
+	var domainEntries []*CacheEntryInfo
+	if filter.Qtype == 0 {
+		for domain, b := range c.blockedCache {
+			if b.IsExpired() || !filter.matchDomain(domain) {
+				continue
+			}
+			domainEntries = append(domainEntries, &CacheEntryInfo{
+				Domain:       normalizeDomain(domain),
+				Layers:       []string{"blocked"},
+				RemainingTTL: int32(b.ExpiredAt.Sub(now) / time.Second),
+				BlockType:    b.BlockType,
+				BlockRule:    b.Rule,
+			})
+		}
+		for domain, a := range c.allowedCache {
+			if a.IsExpired() || !filter.matchDomain(domain) {
+				continue
+			}
+			domainEntries = append(domainEntries, &CacheEntryInfo{
+				Domain:       normalizeDomain(domain),
+				Layers:       []string{"allowed"},
+				RemainingTTL: int32(a.ExpiredAt.Sub(now) / time.Second),
+			})
+		}
+	}
+	c.mu.RUnlock()
+
+	result := make([]CacheEntryInfo, 0, len(entries)+len(domainEntries))
+	for _, e := range entries {
+		result = append(result, *e)
+	}
+	for _, e := range domainEntries {
+		result = append(result, *e)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Domain != result[j].Domain {
+			return result[i].Domain < result[j].Domain
+		}
+		return result[i].Qtype < result[j].Qtype
+	})
+
+	total := len(result)
+	if limit > 0 && len(result) > limit {
+		result = result[:limit]
+	}
+	return result, total
+}
+
+// PurgeResult 定向清除在各缓存层删除的条目数
+type PurgeResult struct {
+	Raw     int `json:"raw"`
+	Sorted  int `json:"sorted"`
+	Sorting int `json:"sorting"`
+	Error   int `json:"error"`
+	DNSSEC  int `json:"dnssec"`
+	Blocked int `json:"blocked"`
+	Allowed int `json:"allowed"`
+}
+
+// Total 返回删除的条目总数
+func (r PurgeResult) Total() int {
+	return r.Raw + r.Sorted + r.Sorting + r.Error + r.DNSSEC + r.Blocked + r.Allowed
+}
+
+// Purge 按条件从所有缓存层删除条目，返回各层删除数量
+// 与 Clear 不同，只影响匹配的域名/类型，其余缓存保持不变
+// 拦截/白名单缓存不区分查询类型，仅在未指定 Qtype 时清除
+func (c *Cache) Purge(filter EntryFilter) PurgeResult {
+	var result PurgeResult
+
+	var rawKeys, sortedKeys []string
+	for _, key := range c.rawCache.GetAllKeys() {
+		if domain, qtype := parseCacheKey(key); filter.match(domain, qtype) {
+			rawKeys = append(rawKeys, key)
+		}
+	}
+	for _, key := range c.sortedCache.GetAllKeys() {
+		if domain, qtype := parseCacheKey(key); filter.match(domain, qtype) {
+			sortedKeys = append(sortedKeys, key)
+		}
+	}
+
+	// 先删除外部后端，再删除 L1：顺序反过来时，L1 删除后的查询会从后端读回旧值
+	// 外部后端中可能存在本地 L1 没有的条目，精确域名+类型时一并删除
+	backendKeys := slices.Concat(rawKeys, sortedKeys)
+	if name := normalizeDomain(filter.Name); name != "" && filter.Qtype != 0 && !strings.HasPrefix(name, "*.") {
+		backendKeys = append(backendKeys, cacheKey(name, filter.Qtype))
+	}
+	slices.Sort(backendKeys)
+	c.deleteFromBackend(slices.Compact(backendKeys))
+
+	for _, key := range rawKeys {
+		c.rawCache.Delete(key)
+		c.logWALDelete(key)
+		result.Raw++
+	}
+
+	type released struct {
+		domain string
+		ips    []string
+	}
+	var releasedIPs []released
+	for _, key := range sortedKeys {
+		domain, _ := parseCacheKey(key)
+		if value, ok := c.sortedCache.GetNoUpdate(key); ok {
+			if entry, ok := value.(*SortedCacheEntry); ok {
+				releasedIPs = append(releasedIPs, released{domain, entry.IPs})
+			}
+		}
+		c.sortedCache.Delete(key)
+		result.Sorted++
+	}
+
+	for _, key := range c.errorCache.GetAllKeys() {
+		if domain, qtype := parseCacheKey(key); filter.match(domain, qtype) {
+			c.errorCache.Delete(key)
+			result.Error++
+		}
+	}
+
+	for _, key := range c.msgCache.GetAllKeys() {
+		if domain, qtype := parseCacheKey(key); filter.match(domain, qtype) {
+			c.msgCache.Delete(key)
+			result.DNSSEC++
+		}
+	}
+
+	c.mu.Lock()
+	for key, state := range c.sortingState {
+		domain, qtype := parseCacheKey(key)
+		if !filter.match(domain, qtype) {
+			continue
+		}
+		// 与 CancelSort 一致：唤醒等待者，并允许重新排序
+		state.InProgress = false
+		if state.Done != nil {
+			select {
+			case <-state.Done:
+			default:
+				close(state.Done)
+			}
+		}
+		delete(c.sortingState, key)
+		result.Sorting++
+	}
+	if filter.Qtype == 0 {
+		for domain := range c.blockedCache {
+			if filter.matchDomain(domain) {
+				delete(c.blockedCache, domain)
+				result.Blocked++
+			}
+		}
+		for domain := range c.allowedCache {
+			if filter.matchDomain(domain) {
+				delete(c.allowedCache, domain)
+				result.Allowed++
+			}
+		}
+	}
+	updater := c.ipPoolUpdater
+	c.mu.Unlock()
+
+	// 与 LRU 驱逐一致，释放 IP 池中该域名的引用
+	if updater != nil {
+		for _, r := range releasedIPs {
+			updater.UpdateDomainIPs(r.ips, nil, r.domain)
+		}
+	}
+
+	return result
+}
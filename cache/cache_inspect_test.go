@@ -0,0 +1,141 @@
+package cache
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestARecords(domain string, ips ...string) []dns.RR {
+	var records []dns.RR
+	for _, ip := range ips {
+		rr, _ := dns.NewRR(fmt.Sprintf("%s 300 IN A %s", dns.Fqdn(domain), ip))
+		records = append(records, rr)
+	}
+	return records
+}
+
+// populateInspectCache 在各缓存层写入 example.com 及其子域名的数据
+func populateInspectCache(c *Cache) {
+	c.SetRawRecordsWithSource("example.com", dns.TypeA, newTestARecords("example.com", "1.1.1.1"), nil, 300, false, "8.8.8.8:53")
+	c.SetRawRecordsWithSource("www.example.com", dns.TypeA, newTestARecords("www.example.com", "2.2.2.2", "3.3.3.3"), nil, 300, false, "1.1.1.1:53")
+	c.SetRawRecordsWithSource("www.example.com", dns.TypeAAAA, nil, nil, 300, false, "1.1.1.1:53")
+	c.SetRawRecordsWithSource("cdn.example.com", dns.TypeA, newTestARecords("cdn.example.com", "4.4.4.4"), nil, 300, false, "")
+	c.SetRawRecordsWithSource("other.org", dns.TypeA, newTestARecords("other.org", "5.5.5.5"), nil, 300, false, "")
+
+	c.SetSorted("www.example.com", dns.TypeA, &SortedCacheEntry{
+		IPs:       []string{"3.3.3.3", "2.2.2.2"},
+		RTTs:      []int{10, 40},
+		Timestamp: time.Now(),
+		TTL:       300,
+		IsValid:   true,
+	})
+	c.SetError("bad.example.com", dns.TypeA, dns.RcodeServerFailure, 30)
+	c.SetDNSSECMsg("www.example.com", dns.TypeA, createTestDNSMsg("www.example.com", dns.TypeA, "2.2.2.2", 300, true, false, false))
+	c.SetBlocked("ads.example.com", &BlockedCacheEntry{BlockType: "nxdomain", Rule: "||ads.example.com^", ExpiredAt: time.Now().Add(time.Minute)})
+	c.SetAllowed("other.org", &AllowedCacheEntry{ExpiredAt: time.Now().Add(time.Minute)})
+}
+
+func TestListEntriesMergesLayers(t *testing.T) {
+	c := NewCache(getDefaultCacheConfig())
+	populateInspectCache(c)
+
+	entries, total := c.ListEntries(EntryFilter{Name: "www.example.com", Qtype: dns.TypeA}, 0)
+	assert.Equal(t, 1, total)
+	if assert.Len(t, entries, 1) {
+		e := entries[0]
+		assert.Equal(t, "A", e.Qtype)
+		assert.Equal(t, []string{"raw", "sorted", "dnssec"}, e.Layers)
+		assert.Equal(t, "fresh", e.State)
+		assert.Equal(t, "1.1.1.1:53", e.Source)
+		assert.Equal(t, "sorted", e.SortState)
+		assert.Equal(t, []string{"3.3.3.3", "2.2.2.2"}, e.SortedIPs)
+		assert.Equal(t, []int{10, 40}, e.RTTs)
+		assert.Greater(t, e.RemainingTTL, int32(0))
+	}
+
+	// 通配符只匹配子域名，结果按域名排序，包含错误缓存和拦截缓存
+	entries, total = c.ListEntries(EntryFilter{Name: "*.example.com"}, 0)
+	assert.Equal(t, 5, total)
+	var domains []string
+	for _, e := range entries {
+		domains = append(domains, e.Domain+"/"+e.Qtype)
+	}
+	assert.Equal(t, []string{"ads.example.com/", "bad.example.com/A", "cdn.example.com/A", "www.example.com/A", "www.example.com/AAAA"}, domains)
+	assert.Equal(t, "SERVFAIL", entries[1].ErrorRcode)
+	assert.Equal(t, "nxdomain", entries[0].BlockType)
+
+	// 子串检索与截断
+	entries, total = c.ListEntries(EntryFilter{Search: "EXAMPLE"}, 2)
+	assert.Equal(t, 6, total)
+	assert.Len(t, entries, 2)
+}
+
+func TestPurgeByExactName(t *testing.T) {
+	c := NewCache(getDefaultCacheConfig())
+	populateInspectCache(c)
+
+	result := c.Purge(EntryFilter{Name: "WWW.example.com."})
+	assert.Equal(t, PurgeResult{Raw: 2, Sorted: 1, DNSSEC: 1}, result)
+	assert.Equal(t, 4, result.Total())
+
+	_, ok := c.GetRaw("www.example.com", dns.TypeA)
+	assert.False(t, ok)
+	_, ok = c.GetSorted("www.example.com", dns.TypeA)
+	assert.False(t, ok)
+	_, ok = c.GetDNSSECMsg("www.example.com", dns.TypeA)
+	assert.False(t, ok)
+
+	// 其他域名不受影响
+	_, ok = c.GetRaw("example.com", dns.TypeA)
+	assert.True(t, ok)
+	_, ok = c.GetRaw("cdn.example.com", dns.TypeA)
+	assert.True(t, ok)
+}
+
+func TestPurgeByWildcardAndQtype(t *testing.T) {
+	c := NewCache(getDefaultCacheConfig())
+	populateInspectCache(c)
+
+	// 指定类型时不清除与类型无关的拦截缓存
+	result := c.Purge(EntryFilter{Name: "*.example.com", Qtype: dns.TypeA})
+	assert.Equal(t, PurgeResult{Raw: 2, Sorted: 1, Error: 1, DNSSEC: 1}, result)
+	_, ok := c.GetRaw("www.example.com", dns.TypeAAAA)
+	assert.True(t, ok, "AAAA entry should survive an A-only purge")
+	_, ok = c.GetRaw("example.com", dns.TypeA)
+	assert.True(t, ok, "wildcard should not match the apex")
+	_, ok = c.GetBlocked("ads.example.com")
+	assert.True(t, ok)
+
+	result = c.Purge(EntryFilter{Name: "*.example.com"})
+	assert.Equal(t, PurgeResult{Raw: 1, Blocked: 1}, result)
+	_, ok = c.GetBlocked("ads.example.com")
+	assert.False(t, ok)
+	assert.True(t, c.GetAllowed("other.org"))
+}
+
+func TestPurgeWakesInProgressSort(t *testing.T) {
+	c := NewCache(getDefaultCacheConfig())
+
+	state, isNew := c.GetOrStartSort("www.example.com", dns.TypeA)
+	assert.True(t, isNew)
+
+	entries, _ := c.ListEntries(EntryFilter{Name: "www.example.com"}, 0)
+	if assert.Len(t, entries, 1) {
+		assert.Equal(t, "sorting", entries[0].SortState)
+	}
+
+	result := c.Purge(EntryFilter{Name: "www.example.com"})
+	assert.Equal(t, 1, result.Sorting)
+	select {
+	case <-state.Done:
+	default:
+		t.Fatal("purge should close the Done channel of an in-progress sort")
+	}
+
+	_, isNew = c.GetOrStartSort("www.example.com", dns.TypeA)
+	assert.True(t, isNew, "a new sort should be allowed after purge")
+}
@@ -0,0 +1,610 @@
+package cache
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// 积极否定缓存（RFC 8198）：缓存上游已验证的 NSEC/NSEC3 否定证明，
+// 对证明范围内的任意名称在本地合成 NXDOMAIN/NODATA，无需再询问上游
+// 错误缓存只能按 (域名, 类型) 精确命中，随机子域名洪泛每次都会打到上游，这里按区间命中
+
+const (
+	defaultNSECMaxEntries = 10000
+	// NSEC3 迭代次数上限，超过时不使用（RFC 9276 建议视为不安全）
+	nsec3MaxIterations = 100
+)
+
+// NSECStats 积极否定缓存统计
+type NSECStats struct {
+	Zones             int   `json:"zones"`
+	Records           int   `json:"records"`
+	SynthesizedNX     int64 `json:"synthesized_nxdomain"`
+	SynthesizedNoData int64 `json:"synthesized_nodata"`
+}
+
+// SynthesizedNegative 由缓存证明合成的否定应答
+type SynthesizedNegative struct {
+	Rcode int      // dns.RcodeNameError 或 dns.RcodeSuccess（NODATA）
+	TTL   uint32   // 剩余 TTL，取所用证明和 SOA 中的最小值
+	Ns    []dns.RR // Authority 段：SOA、使用到的 NSEC/NSEC3 及其 RRSIG，TTL 已按剩余时间调整
+}
+
+// nsecProof 一条 NSEC 或 NSEC3 记录及其签名
+type nsecProof struct {
+	rr      dns.RR
+	sigs    []dns.RR
+	owner   string // NSEC：规范化的所有者名；NSEC3：所有者哈希（小写 base32hex）
+	next    string // NSEC：下一个名称；NSEC3：下一个哈希
+	types   []uint16
+	expires time.Time
+}
+
+func (p *nsecProof) hasType(t uint16) bool {
+	for _, bt := range p.types {
+		if bt == t {
+			return true
+		}
+	}
+	return false
+}
+
+// isDelegation 判断证明所有者是否为子区委派点（有 NS 无 SOA）或 DNAME，
+// 这类记录不能用于否定其下方的名称
+func (p *nsecProof) isDelegation() bool {
+	return (p.hasType(dns.TypeNS) && !p.hasType(dns.TypeSOA)) || p.hasType(dns.TypeDNAME)
+}
+
+// nsecZone 单个签名区的否定证明
+type nsecZone struct {
+	name  string // 区顶点，小写 FQDN
+	soa   *nsecProof
+	nsec  []*nsecProof // 按规范顺序排序
+	nsec3 []*nsecProof // 按所有者哈希排序
+
+	// NSEC3 参数，同一区内所有 NSEC3 记录相同，参数变化（重新签名）时丢弃旧记录
+	hashAlg    uint8
+	iterations uint16
+	salt       string
+}
+
+// nsecCache 按区存储否定证明
+type nsecCache struct {
+	mu         sync.RWMutex
+	zones      map[string]*nsecZone
+	count      int
+	maxEntries int
+
+	synthesizedNX     atomic.Int64
+	synthesizedNoData atomic.Int64
+}
+
+func newNSECCache(maxEntries int) *nsecCache {
+	if maxEntries <= 0 {
+		maxEntries = defaultNSECMaxEntries
+	}
+	return &nsecCache{zones: make(map[string]*nsecZone), maxEntries: maxEntries}
+}
+
+// AddNSECProofs 从上游应答中提取否定证明并缓存，返回缓存的记录数
+// 只接受已验证（AD 位）且 Authority 段带有 SOA 的应答，签名者必须是该 SOA 所在的区
+func (c *Cache) AddNSECProofs(msg *dns.Msg) int {
+	if msg == nil || !msg.AuthenticatedData {
+		return 0
+	}
+	if msg.Rcode != dns.RcodeNameError && msg.Rcode != dns.RcodeSuccess {
+		return 0
+	}
+
+	var soa *dns.SOA
+	for _, rr := range msg.Ns {
+		if s, ok := rr.(*dns.SOA); ok {
+			soa = s
+			break
+		}
+	}
+	if soa == nil {
+		return 0
+	}
+	zone := strings.ToLower(dns.Fqdn(soa.Hdr.Name))
+
+	// 按所有者和覆盖类型收集签名
+	sigs := make(map[string][]dns.RR)
+	for _, rr := range msg.Ns {
+		if sig, ok := rr.(*dns.RRSIG); ok && strings.EqualFold(dns.Fqdn(sig.SignerName), zone) {
+			key := strings.ToLower(sig.Hdr.Name) + "|" + dns.TypeToString[sig.TypeCovered]
+			sigs[key] = append(sigs[key], dns.Copy(sig))
+		}
+	}
+	sigsFor := func(rr dns.RR) []dns.RR {
+		return sigs[strings.ToLower(rr.Header().Name)+"|"+dns.TypeToString[rr.Header().Rrtype]]
+	}
+
+	// 否定应答的 TTL 不超过 SOA 的 TTL 和 MINIMUM（RFC 2308）
+	maxTTL := min(soa.Hdr.Ttl, soa.Minttl)
+	now := timeNow()
+	proofFor := func(rr dns.RR) *nsecProof {
+		ttl := min(rr.Header().Ttl, maxTTL)
+		if ttl == 0 {
+			return nil
+		}
+		return &nsecProof{rr: dns.Copy(rr), sigs: sigsFor(rr), expires: now.Add(time.Duration(ttl) * time.Second)}
+	}
+
+	soaProof := proofFor(soa)
+	if soaProof == nil || len(soaProof.sigs) == 0 {
+		return 0
+	}
+
+	var proofs []*nsecProof
+	for _, rr := range msg.Ns {
+		switch v := rr.(type) {
+		case *dns.NSEC:
+			if !dns.IsSubDomain(zone, strings.ToLower(v.Hdr.Name)) {
+				continue
+			}
+			p := proofFor(v)
+			if p == nil || len(p.sigs) == 0 {
+				continue
+			}
+			p.owner = strings.ToLower(dns.Fqdn(v.Hdr.Name))
+			p.next = strings.ToLower(dns.Fqdn(v.NextDomain))
+			p.types = v.TypeBitMap
+			proofs = append(proofs, p)
+		case *dns.NSEC3:
+			// opt-out 的 NSEC3 不能证明名称不存在；迭代次数过高的不使用
+			if v.Flags&1 == 1 || v.Iterations > nsec3MaxIterations || v.Hash != dns.SHA1 {
+				continue
+			}
+			labels := dns.SplitDomainName(v.Hdr.Name)
+			if len(labels) < 2 || !strings.EqualFold(dns.Fqdn(strings.Join(labels[1:], ".")), zone) {
+				continue
+			}
+			p := proofFor(v)
+			if p == nil || len(p.sigs) == 0 {
+				continue
+			}
+			p.owner = strings.ToLower(labels[0])
+			p.next = strings.ToLower(v.NextDomain)
+			p.types = v.TypeBitMap
+			proofs = append(proofs, p)
+		}
+	}
+	if len(proofs) == 0 {
+		return 0
+	}
+	return c.nsec.add(zone, soaProof, proofs)
+}
+
+func (nc *nsecCache) add(zoneName string, soa *nsecProof, proofs []*nsecProof) int {
+	nc.mu.Lock()
+	defer nc.mu.Unlock()
+
+	z, ok := nc.zones[zoneName]
+	if !ok {
+		z = &nsecZone{name: zoneName}
+		nc.zones[zoneName] = z
+	}
+	z.soa = soa
+
+	added := 0
+	for _, p := range proofs {
+		if n3, ok := p.rr.(*dns.NSEC3); ok {
+			if len(z.nsec3) > 0 && (z.hashAlg != n3.Hash || z.iterations != n3.Iterations || !strings.EqualFold(z.salt, n3.Salt)) {
+				nc.count -= len(z.nsec3)
+				z.nsec3 = nil
+			}
+			z.hashAlg, z.iterations, z.salt = n3.Hash, n3.Iterations, n3.Salt
+			var inserted bool
+			z.nsec3, inserted = upsertProof(z.nsec3, p, strings.Compare)
+			if inserted {
+				nc.count++
+			}
+		} else {
+			var inserted bool
+			z.nsec, inserted = upsertProof(z.nsec, p, canonicalCompare)
+			if inserted {
+				nc.count++
+			}
+		}
+		added++
+	}
+
+	if nc.count > nc.maxEntries {
+		nc.evictLocked(timeNow())
+	}
+	return added
+}
+
+// upsertProof 按 owner 有序插入，所有者相同时替换，返回是否新增
+func upsertProof(list []*nsecProof, p *nsecProof, cmp func(a, b string) int) ([]*nsecProof, bool) {
+	i := sort.Search(len(list), func(i int) bool { return cmp(list[i].owner, p.owner) >= 0 })
+	if i < len(list) && list[i].owner == p.owner {
+		list[i] = p
+		return list, false
+	}
+	list = append(list, nil)
+	copy(list[i+1:], list[i:])
+	list[i] = p
+	return list, true
+}
+
+// evictLocked 先清理过期记录，仍超出上限时按过期时间从早到晚淘汰
+func (nc *nsecCache) evictLocked(now time.Time) {
+	nc.removeExpiredLocked(now)
+	if nc.count <= nc.maxEntries {
+		return
+	}
+
+	type ref struct {
+		zone    *nsecZone
+		owner   string
+		nsec3   bool
+		expires time.Time
+	}
+	refs := make([]ref, 0, nc.count)
+	for _, z := range nc.zones {
+		for _, p := range z.nsec {
+			refs = append(refs, ref{z, p.owner, false, p.expires})
+		}
+		for _, p := range z.nsec3 {
+			refs = append(refs, ref{z, p.owner, true, p.expires})
+		}
+	}
+	sort.Slice(refs, func(i, j int) bool { return refs[i].expires.Before(refs[j].expires) })
+
+	// 多淘汰 10%，避免每次插入都触发
+	target := nc.maxEntries - nc.maxEntries/10
+	for _, r := range refs[:nc.count-target] {
+		if r.nsec3 {
+			r.zone.nsec3 = removeProof(r.zone.nsec3, r.owner)
+		} else {
+			r.zone.nsec = removeProof(r.zone.nsec, r.owner)
+		}
+	}
+	nc.recountLocked()
+}
+
+func removeProof(list []*nsecProof, owner string) []*nsecProof {
+	for i, p := range list {
+		if p.owner == owner {
+			return append(list[:i], list[i+1:]...)
+		}
+	}
+	return list
+}
+
+func (nc *nsecCache) removeExpiredLocked(now time.Time) {
+	for _, z := range nc.zones {
+		z.nsec = filterLiveProofs(z.nsec, now)
+		z.nsec3 = filterLiveProofs(z.nsec3, now)
+	}
+	nc.recountLocked()
+}
+
+func filterLiveProofs(list []*nsecProof, now time.Time) []*nsecProof {
+	live := list[:0]
+	for _, p := range list {
+		if now.Before(p.expires) {
+			live = append(live, p)
+		}
+	}
+	for i := len(live); i < len(list); i++ {
+		list[i] = nil
+	}
+	return live
+}
+
+// recountLocked 重新统计条目数并删除空区
+func (nc *nsecCache) recountLocked() {
+	nc.count = 0
+	for name, z := range nc.zones {
+		if len(z.nsec) == 0 && len(z.nsec3) == 0 {
+			delete(nc.zones, name)
+			continue
+		}
+		nc.count += len(z.nsec) + len(z.nsec3)
+	}
+}
+
+// cleanExpiredNSEC 清理过期的否定证明
+func (c *Cache) cleanExpiredNSEC() {
+	c.nsec.mu.Lock()
+	defer c.nsec.mu.Unlock()
+	c.nsec.removeExpiredLocked(timeNow())
+}
+
+func (nc *nsecCache) clear() {
+	nc.mu.Lock()
+	defer nc.mu.Unlock()
+	nc.zones = make(map[string]*nsecZone)
+	nc.count = 0
+}
+
+// GetNSECStats 获取积极否定缓存统计
+func (c *Cache) GetNSECStats() NSECStats {
+	c.nsec.mu.RLock()
+	defer c.nsec.mu.RUnlock()
+	return NSECStats{
+		Zones:             len(c.nsec.zones),
+		Records:           c.nsec.count,
+		SynthesizedNX:     c.nsec.synthesizedNX.Load(),
+		SynthesizedNoData: c.nsec.synthesizedNoData.Load(),
+	}
+}
+
+// SynthesizeNegative 使用缓存的否定证明为 domain/qtype 合成否定应答
+// 只在证明完整时返回 ok：
+//   - NODATA：存在与名称匹配的证明，且类型位图中没有 qtype 和 CNAME
+//   - NXDOMAIN：名称被覆盖（不存在），且最近祖先下的通配符同样被覆盖
+func (c *Cache) SynthesizeNegative(domain string, qtype uint16) (*SynthesizedNegative, bool) {
+	qname := strings.ToLower(dns.Fqdn(domain))
+	now := timeNow()
+
+	c.nsec.mu.RLock()
+	z := c.nsec.findZoneLocked(qname)
+	if z == nil || z.soa == nil || !now.Before(z.soa.expires) {
+		c.nsec.mu.RUnlock()
+		return nil, false
+	}
+	var rcode int
+	var used []*nsecProof
+	var ok bool
+	if len(z.nsec) > 0 {
+		rcode, used, ok = z.proveNSEC(qname, qtype, now)
+	}
+	if !ok && len(z.nsec3) > 0 {
+		rcode, used, ok = z.proveNSEC3(qname, qtype, now)
+	}
+	soa := z.soa
+	c.nsec.mu.RUnlock()
+	if !ok {
+		return nil, false
+	}
+
+	if rcode == dns.RcodeNameError {
+		c.nsec.synthesizedNX.Add(1)
+	} else {
+		c.nsec.synthesizedNoData.Add(1)
+	}
+	return buildSynthesized(rcode, soa, used, now), true
+}
+
+// findZoneLocked 找到包含 qname 的最深的已缓存区
+func (nc *nsecCache) findZoneLocked(qname string) *nsecZone {
+	name := qname
+	for {
+		if z, ok := nc.zones[name]; ok {
+			return z
+		}
+		off, end := dns.NextLabel(name, 0)
+		if end {
+			return nil
+		}
+		name = name[off:]
+	}
+}
+
+// proveNSEC 使用 NSEC 记录证明
+func (z *nsecZone) proveNSEC(qname string, qtype uint16, now time.Time) (int, []*nsecProof, bool) {
+	p := z.nsecPredecessor(qname, now)
+	if p == nil {
+		return 0, nil, false
+	}
+	if p.owner == qname {
+		// 名称存在：只能证明 NODATA
+		if qtype == dns.TypeDS && p.hasType(dns.TypeSOA) {
+			// 区顶点的 DS 由父区证明，子区的记录不能用
+			return 0, nil, false
+		}
+		if p.hasType(qtype) || p.hasType(dns.TypeCNAME) || (p.isDelegation() && qtype != dns.TypeDS) {
+			return 0, nil, false
+		}
+		return dns.RcodeSuccess, []*nsecProof{p}, true
+	}
+	if !z.nsecCovers(p, qname) {
+		return 0, nil, false
+	}
+
+	// 最近祖先：qname 与证明两端名称的最长公共祖先
+	ce := deeperName(commonAncestor(qname, p.owner), commonAncestor(qname, p.next))
+	if !dns.IsSubDomain(z.name, ce) {
+		return 0, nil, false
+	}
+	wildcard := "*." + ce
+	wp := z.nsecPredecessor(wildcard, now)
+	if wp == nil || wp.owner == wildcard || !z.nsecCovers(wp, wildcard) {
+		return 0, nil, false
+	}
+	used := []*nsecProof{p}
+	if wp != p {
+		used = append(used, wp)
+	}
+	return dns.RcodeNameError, used, true
+}
+
+// nsecPredecessor 找到规范顺序上不大于 name 的最后一条未过期 NSEC，没有时取最后一条（区尾回绕）
+func (z *nsecZone) nsecPredecessor(name string, now time.Time) *nsecProof {
+	i := sort.Search(len(z.nsec), func(i int) bool { return canonicalCompare(z.nsec[i].owner, name) > 0 })
+	var p *nsecProof
+	if i > 0 {
+		p = z.nsec[i-1]
+	} else {
+		p = z.nsec[len(z.nsec)-1]
+	}
+	if !now.Before(p.expires) {
+		return nil
+	}
+	return p
+}
+
+// nsecCovers 判断 NSEC 是否覆盖（证明不存在）name
+func (z *nsecZone) nsecCovers(p *nsecProof, name string) bool {
+	// 委派点或 DNAME 之下的名称不由本区证明
+	if dns.IsSubDomain(p.owner, name) && p.isDelegation() {
+		return false
+	}
+	// next 在 name 之下说明 name 是空非终端，存在但无数据
+	if dns.IsSubDomain(name, p.next) {
+		return false
+	}
+	afterOwner := canonicalCompare(p.owner, name) < 0
+	beforeNext := canonicalCompare(name, p.next) < 0
+	if canonicalCompare(p.owner, p.next) < 0 {
+		return afterOwner && beforeNext
+	}
+	// 最后一条记录回绕到区顶点
+	return afterOwner || beforeNext
+}
+
+// proveNSEC3 使用 NSEC3 记录证明
+func (z *nsecZone) proveNSEC3(qname string, qtype uint16, now time.Time) (int, []*nsecProof, bool) {
+	hash := func(name string) string {
+		return strings.ToLower(dns.HashName(name, z.hashAlg, z.iterations, z.salt))
+	}
+
+	if p := z.nsec3Match(hash(qname), now); p != nil {
+		if qtype == dns.TypeDS && p.hasType(dns.TypeSOA) {
+			return 0, nil, false
+		}
+		if p.hasType(qtype) || p.hasType(dns.TypeCNAME) || (p.isDelegation() && qtype != dns.TypeDS) {
+			return 0, nil, false
+		}
+		return dns.RcodeSuccess, []*nsecProof{p}, true
+	}
+
+	// 最近祖先证明：自下而上找到第一个存在的祖先 ce，
+	// 其下一级（next closer）和通配符 *.ce 都必须被覆盖
+	nextCloser := qname
+	for ce := parentName(qname); ce != ""; nextCloser, ce = ce, parentName(ce) {
+		if !dns.IsSubDomain(z.name, ce) {
+			return 0, nil, false
+		}
+		cep := z.nsec3Match(hash(ce), now)
+		if cep == nil {
+			continue
+		}
+		if cep.isDelegation() {
+			return 0, nil, false
+		}
+		ncp := z.nsec3Cover(hash(nextCloser), now)
+		if ncp == nil {
+			return 0, nil, false
+		}
+		wp := z.nsec3Cover(hash("*."+ce), now)
+		if wp == nil {
+			return 0, nil, false
+		}
+		used := []*nsecProof{cep, ncp}
+		if wp != ncp && wp != cep {
+			used = append(used, wp)
+		}
+		return dns.RcodeNameError, used, true
+	}
+	return 0, nil, false
+}
+
+func (z *nsecZone) nsec3Match(h string, now time.Time) *nsecProof {
+	i := sort.Search(len(z.nsec3), func(i int) bool { return z.nsec3[i].owner >= h })
+	if i < len(z.nsec3) && z.nsec3[i].owner == h && now.Before(z.nsec3[i].expires) {
+		return z.nsec3[i]
+	}
+	return nil
+}
+
+func (z *nsecZone) nsec3Cover(h string, now time.Time) *nsecProof {
+	i := sort.Search(len(z.nsec3), func(i int) bool { return z.nsec3[i].owner > h })
+	var p *nsecProof
+	if i > 0 {
+		p = z.nsec3[i-1]
+	} else {
+		p = z.nsec3[len(z.nsec3)-1]
+	}
+	if !now.Before(p.expires) || p.owner == h {
+		return nil
+	}
+	if p.owner < p.next {
+		if p.owner < h && h < p.next {
+			return p
+		}
+		return nil
+	}
+	if h > p.owner || h < p.next {
+		return p
+	}
+	return nil
+}
+
+// buildSynthesized 组装 Authority 段，所有记录的 TTL 调整为剩余时间
+func buildSynthesized(rcode int, soa *nsecProof, used []*nsecProof, now time.Time) *SynthesizedNegative {
+	expires := soa.expires
+	for _, p := range used {
+		if p.expires.Before(expires) {
+			expires = p.expires
+		}
+	}
+	ttl := uint32(max(1, int(expires.Sub(now).Seconds())))
+
+	ns := make([]dns.RR, 0, 2+len(used)*2)
+	appendWithTTL := func(rrs ...dns.RR) {
+		for _, rr := range rrs {
+			cp := dns.Copy(rr)
+			cp.Header().Ttl = ttl
+			ns = append(ns, cp)
+		}
+	}
+	appendWithTTL(soa.rr)
+	appendWithTTL(soa.sigs...)
+	for _, p := range used {
+		appendWithTTL(p.rr)
+		appendWithTTL(p.sigs...)
+	}
+	return &SynthesizedNegative{Rcode: rcode, TTL: ttl, Ns: ns}
+}
+
+// canonicalCompare 按 DNSSEC 规范顺序（RFC 4034 6.1）比较两个小写 FQDN：
+// 从最右侧的标签开始逐个按字节比较
+func canonicalCompare(a, b string) int {
+	la := dns.SplitDomainName(a)
+	lb := dns.SplitDomainName(b)
+	for i, j := len(la)-1, len(lb)-1; i >= 0 && j >= 0; i, j = i-1, j-1 {
+		if c := strings.Compare(la[i], lb[j]); c != 0 {
+			return c
+		}
+	}
+	return len(la) - len(lb)
+}
+
+// commonAncestor 返回两个 FQDN 的最长公共祖先
+func commonAncestor(a, b string) string {
+	n := dns.CompareDomainName(a, b)
+	labels := dns.SplitDomainName(a)
+	if n == 0 {
+		return "."
+	}
+	return dns.Fqdn(strings.Join(labels[len(labels)-n:], "."))
+}
+
+func deeperName(a, b string) string {
+	if dns.CountLabel(a) >= dns.CountLabel(b) {
+		return a
+	}
+	return b
+}
+
+// parentName 返回上一级名称，根返回空字符串
+func parentName(name string) string {
+	if name == "." {
+		return ""
+	}
+	off, end := dns.NextLabel(name, 0)
+	if end {
+		return "."
+	}
+	return name[off:]
+}
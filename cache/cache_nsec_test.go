@@ -0,0 +1,190 @@
+package cache
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// signedNegative 构造一个带 SOA、NSEC/NSEC3 及其 RRSIG 的已验证否定应答
+func signedNegative(t *testing.T, zone string, rcode int, minTTL uint32, proofs ...string) *dns.Msg {
+	t.Helper()
+	msg := new(dns.Msg)
+	msg.Rcode = rcode
+	msg.AuthenticatedData = true
+
+	soa := mustRR(t, fmt.Sprintf("%s 3600 IN SOA ns.%s hostmaster.%s 1 7200 3600 1209600 %d", zone, zone, zone, minTTL))
+	msg.Ns = append(msg.Ns, soa, testRRSIG(t, soa, zone))
+	for _, s := range proofs {
+		rr := mustRR(t, s)
+		msg.Ns = append(msg.Ns, rr, testRRSIG(t, rr, zone))
+	}
+	return msg
+}
+
+func testRRSIG(t *testing.T, rr dns.RR, signer string) dns.RR {
+	t.Helper()
+	h := rr.Header()
+	return mustRR(t, fmt.Sprintf("%s %d IN RRSIG %s 13 %d %d 20300101000000 20200101000000 12345 %s AAAA",
+		h.Name, h.Ttl, dns.TypeToString[h.Rrtype], dns.CountLabel(h.Name), h.Ttl, signer))
+}
+
+func TestNSECSynthesizesNXDOMAIN(t *testing.T) {
+	c := NewCache(getDefaultCacheConfig())
+	msg := signedNegative(t, "example.com.", dns.RcodeNameError, 300,
+		"example.com. 3600 IN NSEC a.example.com. A NS SOA RRSIG NSEC",
+		"c.example.com. 3600 IN NSEC m.example.com. A RRSIG NSEC",
+	)
+	require.Equal(t, 2, c.AddNSECProofs(msg))
+
+	// d 落在 c..m 之间，通配符 *.example.com 落在 example.com..a 之间
+	neg, ok := c.SynthesizeNegative("d.example.com", dns.TypeA)
+	require.True(t, ok)
+	assert.Equal(t, dns.RcodeNameError, neg.Rcode)
+	assert.LessOrEqual(t, neg.TTL, uint32(300), "TTL is capped by the SOA minimum")
+	var types []string
+	for _, rr := range neg.Ns {
+		types = append(types, dns.TypeToString[rr.Header().Rrtype])
+	}
+	assert.Equal(t, []string{"SOA", "RRSIG", "NSEC", "RRSIG", "NSEC", "RRSIG"}, types)
+
+	// 随机子域名同样命中
+	_, ok = c.SynthesizeNegative("f8k2j1.example.com", dns.TypeAAAA)
+	assert.True(t, ok)
+	_, ok = c.SynthesizeNegative("deep.label.g.example.com", dns.TypeA)
+	assert.True(t, ok)
+
+	// 没有被证明覆盖的名称不能合成
+	_, ok = c.SynthesizeNegative("b.example.com", dns.TypeA)
+	assert.False(t, ok, "b is between a and c, whose NSEC is not cached")
+	_, ok = c.SynthesizeNegative("x.example.com", dns.TypeA)
+	assert.False(t, ok)
+	_, ok = c.SynthesizeNegative("other.org", dns.TypeA)
+	assert.False(t, ok)
+
+	assert.Equal(t, int64(3), c.GetNSECStats().SynthesizedNX)
+}
+
+func TestNSECSynthesizesNODATA(t *testing.T) {
+	c := NewCache(getDefaultCacheConfig())
+	c.AddNSECProofs(signedNegative(t, "example.com.", dns.RcodeSuccess, 300,
+		"c.example.com. 3600 IN NSEC m.example.com. A RRSIG NSEC",
+		"alias.example.com. 3600 IN NSEC c.example.com. CNAME RRSIG NSEC",
+	))
+
+	neg, ok := c.SynthesizeNegative("c.example.com", dns.TypeAAAA)
+	require.True(t, ok)
+	assert.Equal(t, dns.RcodeSuccess, neg.Rcode)
+
+	_, ok = c.SynthesizeNegative("c.example.com", dns.TypeA)
+	assert.False(t, ok, "type exists in the bitmap")
+	_, ok = c.SynthesizeNegative("alias.example.com", dns.TypeAAAA)
+	assert.False(t, ok, "a CNAME owner must be resolved, not denied")
+	assert.Equal(t, int64(1), c.GetNSECStats().SynthesizedNoData)
+}
+
+func TestNSECRespectsEmptyNonTerminalsAndDelegations(t *testing.T) {
+	c := NewCache(getDefaultCacheConfig())
+	c.AddNSECProofs(signedNegative(t, "example.com.", dns.RcodeNameError, 300,
+		"example.com. 3600 IN NSEC a.example.com. A NS SOA RRSIG NSEC",
+		"c.example.com. 3600 IN NSEC www.d.example.com. A RRSIG NSEC",
+		"deleg.example.com. 3600 IN NSEC m.example.com. NS RRSIG NSEC",
+	))
+
+	// d.example.com 是空非终端（www.d.example.com 存在）
+	_, ok := c.SynthesizeNegative("d.example.com", dns.TypeA)
+	assert.False(t, ok)
+
+	// 委派点之下的名称由子区负责
+	_, ok = c.SynthesizeNegative("host.deleg.example.com", dns.TypeA)
+	assert.False(t, ok)
+	_, ok = c.SynthesizeNegative("deleg.example.com", dns.TypeA)
+	assert.False(t, ok)
+
+	// 父区可以证明委派点没有 DS
+	neg, ok := c.SynthesizeNegative("deleg.example.com", dns.TypeDS)
+	require.True(t, ok)
+	assert.Equal(t, dns.RcodeSuccess, neg.Rcode)
+}
+
+func TestNSEC3SynthesizesNegative(t *testing.T) {
+	const zone = "example.org."
+	apexHash := strings.ToLower(dns.HashName(zone, dns.SHA1, 1, "AABB"))
+	// 只有顶点的区：唯一的 NSEC3 记录首尾相接，覆盖所有其他哈希
+	proof := fmt.Sprintf("%s.%s 3600 IN NSEC3 1 0 1 AABB %s A NS SOA RRSIG DNSKEY NSEC3PARAM", apexHash, zone, strings.ToUpper(apexHash))
+
+	c := NewCache(getDefaultCacheConfig())
+	require.Equal(t, 1, c.AddNSECProofs(signedNegative(t, zone, dns.RcodeNameError, 300, proof)))
+
+	neg, ok := c.SynthesizeNegative("random123.example.org", dns.TypeA)
+	require.True(t, ok)
+	assert.Equal(t, dns.RcodeNameError, neg.Rcode)
+
+	neg, ok = c.SynthesizeNegative("example.org", dns.TypeAAAA)
+	require.True(t, ok)
+	assert.Equal(t, dns.RcodeSuccess, neg.Rcode)
+
+	_, ok = c.SynthesizeNegative("example.org", dns.TypeA)
+	assert.False(t, ok)
+
+	// opt-out 的记录不使用
+	optOut := NewCache(getDefaultCacheConfig())
+	optOutProof := strings.Replace(proof, "NSEC3 1 0 1", "NSEC3 1 1 1", 1)
+	assert.Equal(t, 0, optOut.AddNSECProofs(signedNegative(t, zone, dns.RcodeNameError, 300, optOutProof)))
+}
+
+func TestNSECRequiresValidatedSignedAnswers(t *testing.T) {
+	c := NewCache(getDefaultCacheConfig())
+	proof := "c.example.com. 3600 IN NSEC m.example.com. A RRSIG NSEC"
+
+	unvalidated := signedNegative(t, "example.com.", dns.RcodeNameError, 300, proof)
+	unvalidated.AuthenticatedData = false
+	assert.Equal(t, 0, c.AddNSECProofs(unvalidated))
+
+	// 去掉 NSEC 的签名
+	unsigned := signedNegative(t, "example.com.", dns.RcodeNameError, 300, proof)
+	unsigned.Ns = unsigned.Ns[:3]
+	assert.Equal(t, 0, c.AddNSECProofs(unsigned))
+
+	// 签名者不是 SOA 所在的区
+	foreign := signedNegative(t, "example.com.", dns.RcodeNameError, 300)
+	rr := mustRR(t, proof)
+	foreign.Ns = append(foreign.Ns, rr, testRRSIG(t, rr, "evil.example."))
+	assert.Equal(t, 0, c.AddNSECProofs(foreign))
+
+	assert.Equal(t, 0, c.GetNSECStats().Records)
+}
+
+func TestNSECExpiresAndEvicts(t *testing.T) {
+	c := NewCache(getDefaultCacheConfig())
+	c.AddNSECProofs(signedNegative(t, "example.com.", dns.RcodeNameError, 1,
+		"example.com. 3600 IN NSEC a.example.com. A NS SOA RRSIG NSEC",
+		"c.example.com. 3600 IN NSEC m.example.com. A RRSIG NSEC",
+	))
+	_, ok := c.SynthesizeNegative("d.example.com", dns.TypeA)
+	require.True(t, ok)
+
+	time.Sleep(1100 * time.Millisecond)
+	_, ok = c.SynthesizeNegative("d.example.com", dns.TypeA)
+	assert.False(t, ok, "proofs expire after the negative TTL")
+	c.cleanExpiredNSEC()
+	stats := c.GetNSECStats()
+	assert.Equal(t, 0, stats.Records)
+	assert.Equal(t, 0, stats.Zones)
+
+	// 超出上限时淘汰
+	c.nsec.maxEntries = 10
+	for i := range 20 {
+		c.AddNSECProofs(signedNegative(t, "example.com.", dns.RcodeNameError, 300,
+			fmt.Sprintf("h%02d.example.com. 3600 IN NSEC h%02da.example.com. A RRSIG NSEC", i, i)))
+	}
+	assert.LessOrEqual(t, c.GetNSECStats().Records, 10)
+
+	c.Clear()
+	assert.Equal(t, 0, c.GetNSECStats().Zones)
+}
@@ -3,6 +3,7 @@ package cache
 import (
 	"encoding/gob"
 	"errors"
+	"fmt"
 	"hash/crc32"
 	"io"
 	"math/rand"
@@ -30,16 +31,23 @@ type cacheFileFooter struct {
 
 const (
 	cacheFileMagic   = 0x53444E53 // "SDNS"
-	cacheFileVersion = 1
+	// 版本 2：文件头、条目、结束标记和文件尾使用同一个 gob 流
+	// 版本 1 为每部分分别创建编码器，类型信息冲突导致无法读回
+	cacheFileVersion = 2
 )
 
 // SaveToDisk 将缓存保存到磁盘
 // 采用流式持久化策略：按分片锁定并直接写入文件，内存占用从 O(N) 降为 O(分片大小)
-// 文件格式：[Header][Entry1][Entry2]...[Footer(含校验和)]
+// 文件格式：[Header][Entry1][Entry2]...[空条目(结束标记)][Footer(含校验和)]
 func (c *Cache) SaveToDisk(filename string) error {
+	return c.writeSnapshot(filename, false)
+}
+
+// writeSnapshot 写入全量快照，force 为 false 时无变更则跳过
+func (c *Cache) writeSnapshot(filename string, force bool) error {
 	// 1. 脏数据检查
 	currentDirty := c.rawCache.GetDirtyCount()
-	if atomic.LoadUint64(&c.lastSavedDirty) == currentDirty {
+	if !force && atomic.LoadUint64(&c.lastSavedDirty) == currentDirty {
 		// 无变更，跳过保存
 		return nil
 	}
@@ -52,22 +60,22 @@ func (c *Cache) SaveToDisk(filename string) error {
 	}
 
 	// 3. 写入文件头
+	// 4. 流式写入：整个文件使用同一个 gob 流式编码器
+	encoder := gob.NewEncoder(f)
 	header := cacheFileHeader{
 		Magic:   cacheFileMagic,
 		Version: cacheFileVersion,
 	}
-	if err := gob.NewEncoder(f).Encode(header); err != nil {
+	if err := encoder.Encode(header); err != nil {
 		f.Close()
 		os.Remove(tempFile)
 		return err
 	}
 
-	// 4. 流式写入：使用 gob 流式编码器直接写入文件
-	encoder := gob.NewEncoder(f)
-
 	// 统计实际写入的条目数和校验和计算
 	var entryCount uint64
 	checksum := crc32.NewIEEE()
+	var encodeErr error
 
 	// 5. 流式遍历所有分片，直接编码写入
 	// 每次只锁定一个分片，处理完立即释放，内存占用可控
@@ -83,27 +91,10 @@ func (c *Cache) SaveToDisk(filename string) error {
 			return true // 继续遍历
 		}
 
-		// 准备 CNAME 数据
-		entryCNAMEs := entry.CNAMEs
-		var legacyCNAME string
-		if len(entryCNAMEs) > 0 {
-			legacyCNAME = entryCNAMEs[0]
-		}
-
-		// 构建持久化条目
-		persistentEntry := PersistentCacheEntry{
-			Domain:          domain,
-			QType:           qtype,
-			IPs:             entry.IPs,
-			CNAME:           legacyCNAME,
-			CNAMEs:          entryCNAMEs,
-			AcquisitionTime: entry.AcquisitionTime.Unix(),
-			EffectiveTTL:    entry.EffectiveTTL,
-			GracePeriod:     entry.gracePeriod,
-		}
-
 		// 直接编码写入单条记录
+		persistentEntry := toPersistentEntry(domain, qtype, entry)
 		if err := encoder.Encode(persistentEntry); err != nil {
+			encodeErr = err
 			return false // 遇到错误，停止遍历
 		}
 
@@ -117,24 +108,34 @@ func (c *Cache) SaveToDisk(filename string) error {
 		return true // 继续遍历
 	})
 
-	// 检查是否有错误发生（通过条目数判断）
-	if entryCount == 0 && c.rawCache.Len() > 0 {
+	if encodeErr != nil {
 		f.Close()
 		os.Remove(tempFile)
-		// 如果有数据但没写入，说明编码出错
+		return encodeErr
 	}
 
-	// 6. 写入文件尾（校验和）
+	// 6. 写入结束标记和文件尾（校验和）
 	footer := cacheFileFooter{
 		Checksum: checksum.Sum32(),
 		Count:    entryCount,
 	}
-	if err := gob.NewEncoder(f).Encode(footer); err != nil {
+	if err := encoder.Encode(PersistentCacheEntry{}); err != nil {
+		f.Close()
+		os.Remove(tempFile)
+		return err
+	}
+	if err := encoder.Encode(footer); err != nil {
 		f.Close()
 		os.Remove(tempFile)
 		return err
 	}
 
+	// 确保快照落盘后再替换，之后才能安全地丢弃旧的 WAL
+	if err := f.Sync(); err != nil {
+		f.Close()
+		os.Remove(tempFile)
+		return err
+	}
 	f.Close()
 
 	// 7. 原子替换
@@ -147,17 +148,130 @@ func (c *Cache) SaveToDisk(filename string) error {
 	return nil
 }
 
-// LoadFromDisk 从磁盘加载缓存
+// toPersistentEntry 将原始缓存条目转换为持久化格式（快照和 WAL 共用）
+func toPersistentEntry(domain string, qtype uint16, entry *RawCacheEntry) PersistentCacheEntry {
+	// 准备 CNAME 数据
+	var legacyCNAME string
+	if len(entry.CNAMEs) > 0 {
+		legacyCNAME = entry.CNAMEs[0]
+	}
+
+	persistentEntry := PersistentCacheEntry{
+		Domain:            domain,
+		QType:             qtype,
+		IPs:               entry.IPs,
+		CNAME:             legacyCNAME,
+		CNAMEs:            entry.CNAMEs,
+		AcquisitionTime:   entry.AcquisitionTime.Unix(),
+		EffectiveTTL:      entry.EffectiveTTL,
+		GracePeriod:       entry.gracePeriod,
+		AuthenticatedData: entry.AuthenticatedData,
+	}
+	// 只有 A/AAAA 记录时可由 IPs 还原，无需重复保存
+	if hasNonIPRecords(entry.Records) {
+		persistentEntry.Records = make([]string, 0, len(entry.Records))
+		for _, rr := range entry.Records {
+			persistentEntry.Records = append(persistentEntry.Records, rr.String())
+		}
+	}
+	// 完整应答以线路格式保存，文本形式无法还原 SVCB 等记录的全部参数
+	if entry.Msg != nil {
+		if packed, err := entry.Msg.Pack(); err == nil {
+			persistentEntry.Msg = packed
+		}
+	}
+	return persistentEntry
+}
+
+func hasNonIPRecords(records []dns.RR) bool {
+	for _, rr := range records {
+		switch rr.(type) {
+		case *dns.A, *dns.AAAA:
+		default:
+			return true
+		}
+	}
+	return false
+}
+
+// restoreRawEntry 将持久化条目还原为原始缓存条目
 // 实现平滑恢复算法：继承剩余 TTL 或分配抖动延迟，避免集体失效洪峰
+func restoreRawEntry(entry PersistentCacheEntry, now int64) (string, *RawCacheEntry) {
+	cnames := entry.CNAMEs
+	if len(cnames) == 0 && entry.CNAME != "" {
+		cnames = []string{entry.CNAME}
+	}
+
+	// 平滑恢复算法：计算剩余寿命并动态分配 TTL
+	var loadTTL uint32
+	if entry.AcquisitionTime > 0 && entry.EffectiveTTL > 0 {
+		// 有完整的持久化数据，执行平滑恢复
+		elapsed := now - entry.AcquisitionTime
+		remainingTTL := int64(entry.EffectiveTTL) - elapsed
+
+		if remainingTTL > 30 {
+			// 场景 A：数据依然很新鲜
+			// 策略：直接继承剩余寿命，保证准确性
+			loadTTL = uint32(remainingTTL)
+		} else {
+			// 场景 B：数据已过期或即将过期
+			// 策略：分配 30s 基础 TTL + 抖动延迟（15~45s）
+			// 核心用意：防止在重启后的第 30.001 秒发生二次集体失效洪峰
+			loadTTL = uint32(15 + rand.Intn(31)) // 15~45s 随机分布
+		}
+	} else {
+		// 旧版本数据或数据不完整，使用默认 30s + 抖动
+		loadTTL = uint32(15 + rand.Intn(31))
+	}
+
+	var records []dns.RR
+	for _, s := range entry.Records {
+		if rr, err := dns.NewRR(s); err == nil && rr != nil {
+			records = append(records, rr)
+		}
+	}
+
+	var msg *dns.Msg
+	if len(entry.Msg) > 0 {
+		msg = new(dns.Msg)
+		if err := msg.Unpack(entry.Msg); err != nil {
+			msg = nil
+		} else {
+			// 消息中的 TTL 是获取时的原值，按已经过的时间扣减，且不超过恢复后的缓存寿命
+			ageMsgTTL(msg, now-entry.AcquisitionTime, loadTTL)
+		}
+	}
+
+	return cacheKey(entry.Domain, entry.QType), &RawCacheEntry{
+		Msg:               msg,
+		Records:           records,
+		IPs:               entry.IPs,
+		CNAMEs:            cnames,
+		UpstreamTTL:       loadTTL,
+		EffectiveTTL:      loadTTL,
+		AcquisitionTime:   time.Now(), // 以加载时间为新起点
+		AuthenticatedData: entry.AuthenticatedData,
+		gracePeriod:       entry.GracePeriod, // 恢复软过期容忍期
+	}
+}
+
+// LoadFromDisk 从磁盘加载缓存
 // 支持流式读取：逐条解码，内存占用 O(1)
 // 支持校验和验证：检测文件损坏
 func (c *Cache) LoadFromDisk(filename string) error {
+	_, err := c.loadSnapshot(filename, time.Time{})
+	return err
+}
+
+// loadSnapshot 加载快照，deadline 非零时超时即停止（已读取的条目仍会加载，但跳过校验和验证）
+// 返回加载的条目数
+func (c *Cache) loadSnapshot(filename string, deadline time.Time) (int, error) {
 	f, err := os.Open(filename)
 	if err != nil {
 		if os.IsNotExist(err) {
-			return nil
+			return 0, nil
 		}
-		return err
+		return 0, err
 	}
 	defer f.Close()
 
@@ -169,42 +283,45 @@ func (c *Cache) LoadFromDisk(filename string) error {
 		// 旧版本文件没有文件头，尝试作为旧格式加载
 		// 重新打开文件，从头开始读取
 		f.Seek(0, 0)
-		return c.loadFromDiskLegacy(filename, f)
+		return c.loadFromDiskLegacy(f, deadline)
 	}
 
 	// 验证文件头
 	if header.Magic != cacheFileMagic {
 		// 不是新格式文件，尝试作为旧格式加载
 		f.Seek(0, 0)
-		return c.loadFromDiskLegacy(filename, f)
+		return c.loadFromDiskLegacy(f, deadline)
 	}
 
-	if header.Version > cacheFileVersion {
-		// 文件版本过新，无法读取
-		return errors.New("cache file version is too new")
+	if header.Version != cacheFileVersion {
+		// 文件版本过新，或是无法读回的版本 1 文件
+		return 0, fmt.Errorf("unsupported cache file version %d", header.Version)
 	}
 
-	now := time.Now().Unix()
 	checksum := crc32.NewIEEE()
 	var entryCount uint64
+	truncated := false
 
 	// 2. 流式读取条目
 	// 先收集所有条目，最后读取 footer 进行校验
 	var entries []PersistentCacheEntry
 	for {
+		if !deadline.IsZero() && entryCount%256 == 0 && time.Now().After(deadline) {
+			truncated = true
+			break
+		}
+
 		var entry PersistentCacheEntry
 		err := decoder.Decode(&entry)
 		if err != nil {
 			if errors.Is(err, io.EOF) {
 				break
 			}
-			return err
+			return 0, err
 		}
 
-		// 检查是否是 footer（通过判断是否有合理的域名）
+		// 空条目为结束标记，之后是 footer
 		if entry.Domain == "" && len(entry.IPs) == 0 {
-			// 可能是 footer，尝试解码为 footer
-			// 由于我们已经解码为 entry，需要重新处理
 			break
 		}
 
@@ -218,117 +335,58 @@ func (c *Cache) LoadFromDisk(filename string) error {
 		entryCount++
 	}
 
-	// 3. 读取文件尾（校验和）
-	// 由于 gob 流式解码的限制，我们需要在解码条目后继续解码 footer
-	// 重新创建 decoder 从当前位置读取
-	var footer cacheFileFooter
-	// 尝试解码 footer，如果失败则忽略（兼容性）
-	footerDecoder := gob.NewDecoder(f)
-	footerErr := footerDecoder.Decode(&footer)
-	if footerErr == nil && footer.Count > 0 {
-		// 验证校验和
-		if footer.Checksum != checksum.Sum32() {
-			return ErrChecksumMismatch
+	// 3. 读取文件尾（校验和），与条目处于同一个 gob 流
+	if !truncated {
+		var footer cacheFileFooter
+		// 尝试解码 footer，如果失败则忽略（兼容性）
+		footerErr := decoder.Decode(&footer)
+		if footerErr == nil && footer.Count > 0 {
+			// 验证校验和
+			if footer.Checksum != checksum.Sum32() {
+				return 0, ErrChecksumMismatch
+			}
 		}
 	}
 
 	// 4. 处理所有条目
+	now := time.Now().Unix()
 	for _, entry := range entries {
-		key := cacheKey(entry.Domain, entry.QType)
-
-		cnames := entry.CNAMEs
-		if len(cnames) == 0 && entry.CNAME != "" {
-			cnames = []string{entry.CNAME}
-		}
-
-		// 平滑恢复算法：计算剩余寿命并动态分配 TTL
-		var loadTTL uint32
-		if entry.AcquisitionTime > 0 && entry.EffectiveTTL > 0 {
-			// 有完整的持久化数据，执行平滑恢复
-			elapsed := now - entry.AcquisitionTime
-			remainingTTL := int64(entry.EffectiveTTL) - elapsed
-
-			if remainingTTL > 30 {
-				// 场景 A：数据依然很新鲜
-				// 策略：直接继承剩余寿命，保证准确性
-				loadTTL = uint32(remainingTTL)
-			} else {
-				// 场景 B：数据已过期或即将过期
-				// 策略：分配 30s 基础 TTL + 抖动延迟（15~45s）
-				// 核心用意：防止在重启后的第 30.001 秒发生二次集体失效洪峰
-				loadTTL = uint32(15 + rand.Intn(31)) // 15~45s 随机分布
-			}
-		} else {
-			// 旧版本数据或数据不完整，使用默认 30s + 抖动
-			loadTTL = uint32(15 + rand.Intn(31))
-		}
-
-		cacheEntry := &RawCacheEntry{
-			IPs:             entry.IPs,
-			CNAMEs:          cnames,
-			UpstreamTTL:     loadTTL,
-			EffectiveTTL:    loadTTL,
-			AcquisitionTime: time.Now(),        // 以加载时间为新起点
-			gracePeriod:     entry.GracePeriod, // 恢复软过期容忍期
-		}
+		key, cacheEntry := restoreRawEntry(entry, now)
 		c.rawCache.Set(key, cacheEntry)
 	}
 
 	// 加载完成后更新 dirty 计数，避免立即保存
 	atomic.StoreUint64(&c.lastSavedDirty, c.rawCache.GetDirtyCount())
-	return nil
+	return len(entries), nil
 }
 
 // loadFromDiskLegacy 以旧格式加载缓存文件（无校验和）
-func (c *Cache) loadFromDiskLegacy(filename string, f *os.File) error {
+func (c *Cache) loadFromDiskLegacy(f *os.File, deadline time.Time) (int, error) {
 	decoder := gob.NewDecoder(f)
 	now := time.Now().Unix()
+	loaded := 0
 
 	for {
+		if !deadline.IsZero() && loaded%256 == 0 && time.Now().After(deadline) {
+			break
+		}
+
 		var entry PersistentCacheEntry
 		err := decoder.Decode(&entry)
 		if err != nil {
 			if errors.Is(err, io.EOF) {
 				break
 			}
-			return err
+			return loaded, err
 		}
 
-		key := cacheKey(entry.Domain, entry.QType)
-
-		cnames := entry.CNAMEs
-		if len(cnames) == 0 && entry.CNAME != "" {
-			cnames = []string{entry.CNAME}
-		}
-
-		// 平滑恢复算法
-		var loadTTL uint32
-		if entry.AcquisitionTime > 0 && entry.EffectiveTTL > 0 {
-			elapsed := now - entry.AcquisitionTime
-			remainingTTL := int64(entry.EffectiveTTL) - elapsed
-
-			if remainingTTL > 30 {
-				loadTTL = uint32(remainingTTL)
-			} else {
-				loadTTL = uint32(15 + rand.Intn(31))
-			}
-		} else {
-			loadTTL = uint32(15 + rand.Intn(31))
-		}
-
-		cacheEntry := &RawCacheEntry{
-			IPs:             entry.IPs,
-			CNAMEs:          cnames,
-			UpstreamTTL:     loadTTL,
-			EffectiveTTL:    loadTTL,
-			AcquisitionTime: time.Now(),
-			gracePeriod:     entry.GracePeriod,
-		}
+		key, cacheEntry := restoreRawEntry(entry, now)
 		c.rawCache.Set(key, cacheEntry)
+		loaded++
 	}
 
 	atomic.StoreUint64(&c.lastSavedDirty, c.rawCache.GetDirtyCount())
-	return nil
+	return loaded, nil
 }
 
 // GetMsg 获取 DNSSEC 完整消息缓存
@@ -397,3 +455,20 @@ func extractMinTTLFromMsg(msg *dns.Msg) uint32 {
 
 	return minTTL
 }
+
+// ageMsgTTL 将消息各段记录的 TTL 扣减 elapsed 秒并限制在 [1, ceiling] 内（OPT 除外）
+func ageMsgTTL(msg *dns.Msg, elapsed int64, ceiling uint32) {
+	for _, section := range [][]dns.RR{msg.Answer, msg.Ns, msg.Extra} {
+		for _, rr := range section {
+			h := rr.Header()
+			if h.Rrtype == dns.TypeOPT {
+				continue
+			}
+			ttl := max(int64(h.Ttl)-elapsed, 1)
+			if ceiling > 0 {
+				ttl = min(ttl, int64(ceiling))
+			}
+			h.Ttl = uint32(ttl)
+		}
+	}
+}
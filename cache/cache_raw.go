@@ -33,7 +33,8 @@ func (c *Cache) GetRaw(domain string, qtype uint16) (*RawCacheEntry, bool) {
 	key := cacheKey(domain, qtype)
 	value, exists := c.rawCache.Get(key)
 	if !exists {
-		return nil, false
+		// L1 未命中时回源到外部后端（未启用时直接返回）
+		return c.loadRawFromBackend(key)
 	}
 
 	entry, ok := value.(*RawCacheEntry)
@@ -54,7 +55,7 @@ func (c *Cache) SetRawWithVersion(domain string, qtype uint16, ips []string, cna
 // 注意：rawCache 内部已实现线程安全，无需全局锁
 func (c *Cache) SetRawWithDNSSEC(domain string, qtype uint16, ips []string, cnames []string, upstreamTTL uint32, authData bool) {
 	key := cacheKey(domain, qtype)
-	effTTL := c.calculateEffectiveTTL(upstreamTTL)
+	effTTL := c.calculateEffectiveTTL(domain, upstreamTTL)
 	queryVersion := timeNow().UnixNano() // 使用当前时间作为版本号
 	entry := &RawCacheEntry{
 		Records:           nil, // 向后兼容，暂时保持为 nil
@@ -67,6 +68,7 @@ func (c *Cache) SetRawWithDNSSEC(domain string, qtype uint16, ips []string, cnam
 		QueryVersion:      queryVersion,
 	}
 	c.rawCache.Set(key, entry)
+	c.logWALInsert(domain, qtype, entry)
 
 	// 将过期数据添加到堆中（异步化，无全局锁）
 	// 使用 EffectiveTTL 确保即使上游 TTL 很短，数据也在本地生存足够长时间
@@ -77,7 +79,7 @@ func (c *Cache) SetRawWithDNSSEC(domain string, qtype uint16, ips []string, cnam
 // SetRawWithDNSSECAndVersion 设置带 DNSSEC 标记和版本号的原始缓存
 func (c *Cache) SetRawWithDNSSECAndVersion(domain string, qtype uint16, ips []string, cnames []string, upstreamTTL uint32, authData bool, queryVersion int64) {
 	key := cacheKey(domain, qtype)
-	effTTL := c.calculateEffectiveTTL(upstreamTTL)
+	effTTL := c.calculateEffectiveTTL(domain, upstreamTTL)
 	entry := &RawCacheEntry{
 		Records:           nil, // 向后兼容，暂时保持为 nil
 		IPs:               ips,
@@ -89,6 +91,7 @@ func (c *Cache) SetRawWithDNSSECAndVersion(domain string, qtype uint16, ips []st
 		QueryVersion:      queryVersion,
 	}
 	c.rawCache.Set(key, entry)
+	c.logWALInsert(domain, qtype, entry)
 
 	// 将过期数据添加到堆中（异步化，无全局锁）
 	expiryTime := timeNow().Unix() + int64(effTTL)
@@ -110,37 +113,38 @@ func (c *Cache) SetRawRecordsWithVersion(domain string, qtype uint16, records []
 // 同时进行IP级别去重，确保IPs列表中没有重复
 // 注意：rawCache 内部已实现线程安全，无需全局锁
 func (c *Cache) SetRawRecordsWithDNSSEC(domain string, qtype uint16, records []dns.RR, cnames []string, upstreamTTL uint32, authData bool) {
-	// 使用公共函数提取 IP（去重）
-	ips := extractIPsFromRecords(records)
+	c.setRawRecords(domain, qtype, records, cnames, upstreamTTL, authData, timeNow().UnixNano(), "", nil)
+}
 
-	key := cacheKey(domain, qtype)
-	effTTL := c.calculateEffectiveTTL(upstreamTTL)
-	queryVersion := timeNow().UnixNano() // 使用当前时间作为版本号
-	entry := &RawCacheEntry{
-		Records:           records,
-		IPs:               ips, // 从 records 派生，已去重
-		CNAMEs:            cnames,
-		UpstreamTTL:       upstreamTTL,
-		EffectiveTTL:      effTTL,
-		AcquisitionTime:   timeNow(),
-		AuthenticatedData: authData,
-		QueryVersion:      queryVersion,
-	}
-	c.rawCache.Set(key, entry)
+// SetRawRecordsWithSource 设置通用记录原始缓存，并记录应答来源的上游服务器
+// 来源仅用于缓存检视（/api/cache/entries），不影响缓存行为
+func (c *Cache) SetRawRecordsWithSource(domain string, qtype uint16, records []dns.RR, cnames []string, upstreamTTL uint32, authData bool, source string) {
+	c.setRawRecords(domain, qtype, records, cnames, upstreamTTL, authData, timeNow().UnixNano(), source, nil)
+}
 
-	// 将过期数据添加到堆中（异步化，无全局锁）
-	// 使用 EffectiveTTL 确保即使上游 TTL 很短，数据也在本地生存足够长时间
-	expiryTime := timeNow().Unix() + int64(effTTL)
-	c.addToExpiredHeap(key, expiryTime, queryVersion)
+// SetRawMessage 缓存通用记录类型的上游完整应答，Records/CNAMEs 仍按原样保存供检视、预取和旧路径使用
+// 消息以副本保存，去掉报文 ID
+func (c *Cache) SetRawMessage(domain string, qtype uint16, msg *dns.Msg, records []dns.RR, cnames []string, upstreamTTL uint32, authData bool, source string) {
+	var stored *dns.Msg
+	if msg != nil {
+		stored = msg.Copy()
+		stored.Id = 0
+	}
+	c.setRawRecords(domain, qtype, records, cnames, upstreamTTL, authData, timeNow().UnixNano(), source, stored)
 }
 
 // SetRawRecordsWithDNSSECAndVersion 设置带 DNSSEC 标记和版本号的通用记录原始缓存
 func (c *Cache) SetRawRecordsWithDNSSECAndVersion(domain string, qtype uint16, records []dns.RR, cnames []string, upstreamTTL uint32, authData bool, queryVersion int64) {
+	c.setRawRecords(domain, qtype, records, cnames, upstreamTTL, authData, queryVersion, "", nil)
+}
+
+// setRawRecords 通用记录原始缓存的统一写入入口
+func (c *Cache) setRawRecords(domain string, qtype uint16, records []dns.RR, cnames []string, upstreamTTL uint32, authData bool, queryVersion int64, source string, msg *dns.Msg) {
 	// 使用公共函数提取 IP（去重）
 	ips := extractIPsFromRecords(records)
 
 	key := cacheKey(domain, qtype)
-	effTTL := c.calculateEffectiveTTL(upstreamTTL)
+	effTTL := c.calculateEffectiveTTL(domain, upstreamTTL)
 	entry := &RawCacheEntry{
 		Records:           records,
 		IPs:               ips, // 从 records 派生，已去重
@@ -150,12 +154,21 @@ func (c *Cache) SetRawRecordsWithDNSSECAndVersion(domain string, qtype uint16, r
 		AcquisitionTime:   timeNow(),
 		AuthenticatedData: authData,
 		QueryVersion:      queryVersion,
+		Source:            source,
+		Msg:               msg,
 	}
 	c.rawCache.Set(key, entry)
+	c.logWALInsert(domain, qtype, entry)
 
 	// 将过期数据添加到堆中（异步化，无全局锁）
+	// 使用 EffectiveTTL 确保即使上游 TTL 很短，数据也在本地生存足够长时间
 	expiryTime := timeNow().Unix() + int64(effTTL)
 	c.addToExpiredHeap(key, expiryTime, queryVersion)
+	c.storeRawToBackend(key, entry)
+
+	if hook := c.getReplicationHook(); hook != nil {
+		hook.OnRawSet(domain, qtype, entry)
+	}
 }
 
 // GetRawCacheSnapshot 获取 rawCache 中所有值的快照（用于采样计算）
@@ -175,17 +188,7 @@ func (c *Cache) getRawCacheKeysSnapshot() []string {
 	return c.rawCache.GetAllKeys()
 }
 
-// calculateEffectiveTTL 计算应用了本地策略后的有效 TTL
-func (c *Cache) calculateEffectiveTTL(upstreamTTL uint32) uint32 {
-	effTTL := upstreamTTL
-
-	if c.config.MinTTLSeconds > 0 && effTTL < uint32(c.config.MinTTLSeconds) {
-		effTTL = uint32(c.config.MinTTLSeconds)
-	}
-
-	if c.config.MaxTTLSeconds > 0 && effTTL > uint32(c.config.MaxTTLSeconds) {
-		effTTL = uint32(c.config.MaxTTLSeconds)
-	}
-
-	return effTTL
+// calculateEffectiveTTL 计算应用了本地策略（全局 min/max 与按域名规则）后的有效 TTL
+func (c *Cache) calculateEffectiveTTL(domain string, upstreamTTL uint32) uint32 {
+	return c.TTLPolicyFor(domain).Clamp(upstreamTTL)
 }
@@ -0,0 +1,132 @@
+package cache
+
+import (
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// ReplicationHook 在本地写入原始缓存和排序缓存时被调用，用于节点间缓存复制
+// 来自对等节点的写入（ApplyReplicated*）不会触发回调，避免在节点之间循环转发
+// 回调在写入路径上同步执行，实现方必须是非阻塞的
+type ReplicationHook interface {
+	OnRawSet(domain string, qtype uint16, entry *RawCacheEntry)
+	OnSortedSet(domain string, qtype uint16, entry *SortedCacheEntry)
+}
+
+// replicationHookHolder 包装接口值，使 atomic.Value 始终存储同一具体类型
+type replicationHookHolder struct {
+	hook ReplicationHook
+}
+
+// SetReplicationHook 设置复制回调，传入 nil 表示关闭复制
+func (c *Cache) SetReplicationHook(h ReplicationHook) {
+	c.replicationHook.Store(replicationHookHolder{hook: h})
+}
+
+// getReplicationHook 无锁读取复制回调，写入热路径上使用
+func (c *Cache) getReplicationHook() ReplicationHook {
+	if holder, ok := c.replicationHook.Load().(replicationHookHolder); ok {
+		return holder.hook
+	}
+	return nil
+}
+
+// ApplyReplicatedRaw 写入来自对等节点的原始缓存，按获取时间"后写者胜"
+// 本地已有相同或更新的条目、或条目已超过陈旧保留上限时忽略，返回是否写入
+// EffectiveTTL 按本地的 min/max TTL 策略重新计算；msg 为对端缓存的完整应答，可为 nil
+func (c *Cache) ApplyReplicatedRaw(domain string, qtype uint16, msg *dns.Msg, records []dns.RR, cnames []string, upstreamTTL uint32, authData bool, acquired time.Time, source string) bool {
+	key := cacheKey(domain, qtype)
+	if value, ok := c.rawCache.GetNoUpdate(key); ok {
+		if existing, ok := value.(*RawCacheEntry); ok && !existing.AcquisitionTime.Before(acquired) {
+			return false
+		}
+	}
+
+	effTTL := c.calculateEffectiveTTL(domain, upstreamTTL)
+	expiryTime := acquired.Unix() + int64(effTTL)
+	if timeNow().Unix() > expiryTime+AncientLimitLowPressure {
+		return false
+	}
+
+	if msg != nil {
+		msg.Id = 0
+		ageReplicaMsg(msg, effTTL)
+	}
+
+	queryVersion := acquired.UnixNano()
+	entry := &RawCacheEntry{
+		Msg:               msg,
+		Records:           records,
+		IPs:               extractIPsFromRecords(records),
+		CNAMEs:            cnames,
+		UpstreamTTL:       upstreamTTL,
+		EffectiveTTL:      effTTL,
+		AcquisitionTime:   acquired,
+		AuthenticatedData: authData,
+		QueryVersion:      queryVersion,
+		Source:            source,
+	}
+	c.rawCache.Set(key, entry)
+	c.addToExpiredHeap(key, expiryTime, queryVersion)
+	c.logWALInsert(domain, qtype, entry)
+	return true
+}
+
+// ageReplicaMsg 调整来自后端或对等节点的完整应答的 TTL
+// 条目保留原获取时间，命中时再按已过时间扣减，这里只按本地 TTL 策略限制上限
+func ageReplicaMsg(msg *dns.Msg, effTTL uint32) {
+	ageMsgTTL(msg, 0, effTTL)
+}
+
+// ApplyReplicatedSorted 写入来自对等节点的排序结果，按排序完成时间"后写者胜"
+// 已过期的排序结果直接忽略，返回是否写入
+func (c *Cache) ApplyReplicatedSorted(domain string, qtype uint16, entry *SortedCacheEntry) bool {
+	if entry == nil || entry.IsExpired() {
+		return false
+	}
+	key := cacheKey(domain, qtype)
+	if value, ok := c.sortedCache.GetNoUpdate(key); ok {
+		if existing, ok := value.(*SortedCacheEntry); ok && existing.IsValid && !existing.Timestamp.Before(entry.Timestamp) {
+			return false
+		}
+	}
+	c.setSorted(domain, qtype, entry)
+	return true
+}
+
+// RangeRaw 遍历原始缓存（用于全量快照），fn 返回 false 时停止
+func (c *Cache) RangeRaw(fn func(domain string, qtype uint16, entry *RawCacheEntry) bool) {
+	c.rawCache.StreamForEach(func(key string, value any) bool {
+		entry, ok := value.(*RawCacheEntry)
+		if !ok {
+			return true
+		}
+		domain, qtype := parseCacheKey(key)
+		if domain == "" {
+			return true
+		}
+		return fn(domain, qtype, entry)
+	})
+}
+
+// RangeSorted 遍历未过期的排序缓存（用于全量快照），fn 返回 false 时停止
+func (c *Cache) RangeSorted(fn func(domain string, qtype uint16, entry *SortedCacheEntry) bool) {
+	for _, key := range c.sortedCache.GetAllKeys() {
+		value, ok := c.sortedCache.GetNoUpdate(key)
+		if !ok {
+			continue
+		}
+		entry, ok := value.(*SortedCacheEntry)
+		if !ok || entry.IsExpired() {
+			continue
+		}
+		domain, qtype := parseCacheKey(key)
+		if domain == "" {
+			continue
+		}
+		if !fn(domain, qtype, entry) {
+			return
+		}
+	}
+}
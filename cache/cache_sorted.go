@@ -13,7 +13,11 @@ func (c *Cache) GetSortedWithStale(domain string, qtype uint16, includeStale boo
 	key := cacheKey(domain, qtype)
 	value, exists := c.sortedCache.Get(key)
 	if !exists {
-		return nil, false
+		// L1 未命中时回源到外部后端（未启用时直接返回）
+		value, exists = c.loadSortedFromBackend(domain, qtype)
+		if !exists {
+			return nil, false
+		}
 	}
 
 	entry, ok := value.(*SortedCacheEntry)
@@ -37,6 +41,18 @@ func (c *Cache) GetSortedWithStale(domain string, qtype uint16, includeStale boo
 // SetSorted 设置排序后的缓存
 // 注意：sortedCache 已改用分片锁 (ShardedLRUCache)，无需全局锁，降低锁竞争
 func (c *Cache) SetSorted(domain string, qtype uint16, entry *SortedCacheEntry) {
+	c.setSorted(domain, qtype, entry)
+	if entry != nil {
+		c.storeSortedToBackend(cacheKey(domain, qtype), entry)
+	}
+
+	if hook := c.getReplicationHook(); hook != nil && entry != nil {
+		hook.OnSortedSet(domain, qtype, entry)
+	}
+}
+
+// setSorted 写入排序缓存并维护 IP 池引用计数，不触发复制回调
+func (c *Cache) setSorted(domain string, qtype uint16, entry *SortedCacheEntry) {
 	key := cacheKey(domain, qtype)
 
 	// 获取旧的 IP 列表，用于更新 IP 池引用计数
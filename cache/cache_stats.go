@@ -11,25 +11,80 @@ func (c *Cache) GetCurrentEntries() int {
 	return c.rawCache.Len()
 }
 
-// GetEvictions 获取驱逐计数
+// GetEvictions 获取驱逐计数（含字节预算触发的驱逐）
 func (c *Cache) GetEvictions() int64 {
-	return atomic.LoadInt64(&c.evictions)
+	n := atomic.LoadInt64(&c.evictions)
+	for _, l := range c.memoryLayers() {
+		n += l.acct.evictions.Load()
+	}
+	return n
 }
 
 // GetMemoryUsagePercent 获取当前内存使用百分比
+// 取字节预算占用率与条目数占用率中较高的一个，两个上限都会触发 LRU 驱逐
+// 条目数与字节计数分别由分片锁和原子变量维护，不需要持有 c.mu，可在查询路径上调用
 func (c *Cache) GetMemoryUsagePercent() float64 {
-	if c.maxEntries == 0 {
-		return 0
+	var usage float64
+	if c.maxEntries > 0 {
+		usage = float64(c.rawCache.Len()) / float64(c.maxEntries)
 	}
-	return float64(c.GetCurrentEntries()) / float64(c.maxEntries)
+	if c.memBudget != nil {
+		usage = max(usage, float64(c.memBudget.Used())/float64(c.memBudget.limit))
+	}
+	return usage
 }
 
-// getMemoryUsagePercentLocked 获取当前内存使用百分比（已持有锁）
-func (c *Cache) getMemoryUsagePercentLocked() float64 {
-	if c.maxEntries == 0 {
-		return 0
+// MemoryLayerStats 单个缓存层的实际内存占用
+type MemoryLayerStats struct {
+	Entries         int   `json:"entries"`
+	Bytes           int64 `json:"bytes"`
+	LimitBytes      int64 `json:"limit_bytes"`      // 该层在共享预算内的独立字节上限，0 表示只受共享预算约束
+	BudgetEvictions int64 `json:"budget_evictions"` // 因字节上限驱逐的条目数
+}
+
+// MemoryStats 按层统计的缓存内存占用
+type MemoryStats struct {
+	BudgetBytes int64                       `json:"budget_bytes"` // raw/sorted/error/msg 共享的字节预算，0 表示不限制
+	UsedBytes   int64                       `json:"used_bytes"`   // 计入共享预算的字节数（未设置预算时为 0）
+	TotalBytes  int64                       `json:"total_bytes"`  // 所有层的字节数
+	Layers      map[string]MemoryLayerStats `json:"layers"`
+}
+
+type memoryLayer struct {
+	name    string
+	acct    *layerAccount
+	entries func() int
+}
+
+func (c *Cache) memoryLayers() []memoryLayer {
+	return []memoryLayer{
+		{"raw", c.rawCache.acct, c.rawCache.Len},
+		{"sorted", c.sortedCache.acct, c.sortedCache.Len},
+		{"error", c.errorCache.acct, c.errorCache.Len},
+		{"msg", c.msgCache.acct, c.msgCache.Len},
+	}
+}
+
+// GetMemoryStats 返回各缓存层按条目内容增量记账的字节数
+func (c *Cache) GetMemoryStats() MemoryStats {
+	stats := MemoryStats{Layers: make(map[string]MemoryLayerStats, 4)}
+	if c.memBudget != nil {
+		stats.BudgetBytes = c.memBudget.limit
+	}
+	for _, l := range c.memoryLayers() {
+		ls := MemoryLayerStats{
+			Entries:         l.entries(),
+			Bytes:           l.acct.Bytes(),
+			LimitBytes:      l.acct.limit,
+			BudgetEvictions: l.acct.evictions.Load(),
+		}
+		if l.acct.budget != nil {
+			stats.UsedBytes += ls.Bytes
+		}
+		stats.TotalBytes += ls.Bytes
+		stats.Layers[l.name] = ls
 	}
-	return float64(c.rawCache.Len()) / float64(c.maxEntries)
+	return stats
 }
 
 // GetExpiredHeapEntries 统计过期堆中的条目数（内部监控指标）
@@ -0,0 +1,492 @@
+package cache
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"hash/crc32"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"smartdnssort/logger"
+)
+
+// 预写日志（WAL）：快照之间的原始缓存变更以追加方式写入 <快照>.wal
+// 启动时依次回放 快照 → .wal.old → .wal，崩溃后最多丢失最近一个同步周期的变更
+// 帧格式：[4 字节长度][4 字节 CRC32][JSON 载荷]，尾部不完整或校验失败的帧视为撕裂写入并截断
+
+// PersistenceFile 缓存快照的默认文件名，周期保存和退出保存共用
+const PersistenceFile = "dns_cache.bin"
+
+const (
+	walOpInsert uint8 = 1
+	walOpDelete uint8 = 2
+	walOpClear  uint8 = 3
+
+	walFrameHeaderSize = 8
+	walMaxRecordSize   = 4 << 20 // 单条记录上限，超过视为损坏
+	walQueueSize       = 8192
+)
+
+// walRecord 单条日志记录
+type walRecord struct {
+	Op    uint8                 `json:"op"`
+	Key   string                `json:"key,omitempty"`
+	Entry *PersistentCacheEntry `json:"entry,omitempty"`
+}
+
+// PersistenceOptions 增量持久化配置
+type PersistenceOptions struct {
+	Path         string        // 快照文件路径，日志为 Path+".wal"
+	SyncInterval time.Duration // 日志刷盘间隔
+	CompactSize  int64         // 日志达到该大小（字节）时触发后台压缩
+	LoadTimeout  time.Duration // 启动加载时间上限，0 表示不限制
+}
+
+// PersistenceStats 增量持久化统计
+type PersistenceStats struct {
+	Enabled         bool      `json:"enabled"`
+	WALBytes        int64     `json:"wal_bytes"`
+	Appended        int64     `json:"appended"`
+	Dropped         int64     `json:"dropped"` // 日志队列满而丢弃的记录
+	Compactions     int64     `json:"compactions"`
+	LastCompaction  time.Time `json:"last_compaction"`
+	LoadedEntries   int       `json:"loaded_entries"`   // 启动时从快照加载的条目
+	ReplayedRecords int       `json:"replayed_records"` // 启动时回放的日志记录
+	LoadDurationMs  int64     `json:"load_duration_ms"`
+	LoadTruncated   bool      `json:"load_truncated"` // 启动加载是否因超时提前结束
+}
+
+// walWriter 日志写入器，单个 goroutine 顺序写入，保证记录顺序与内存操作一致
+type walWriter struct {
+	opts    PersistenceOptions
+	walPath string
+	oldPath string
+
+	mu   sync.Mutex // 保护 file/buf/size
+	file *os.File
+	buf  *bufio.Writer
+	size int64
+
+	compactMu  sync.Mutex // 串行化压缩
+	compacting atomic.Bool
+
+	queue  chan walRecord
+	stopCh chan struct{}
+	wg     sync.WaitGroup
+
+	appended       atomic.Int64
+	dropped        atomic.Int64
+	compactions    atomic.Int64
+	lastCompaction atomic.Int64 // UnixNano
+
+	loadedEntries   int
+	replayedRecords int
+	loadDuration    time.Duration
+	loadTruncated   bool
+}
+
+// OpenPersistence 加载快照并回放日志，然后开始记录后续变更
+// 加载超过 LoadTimeout 时停止读取，已读取的部分仍然生效
+func (c *Cache) OpenPersistence(opts PersistenceOptions) error {
+	if opts.Path == "" {
+		return errors.New("persistence path is empty")
+	}
+	if opts.SyncInterval <= 0 {
+		opts.SyncInterval = time.Second
+	}
+	w := &walWriter{
+		opts:    opts,
+		walPath: opts.Path + ".wal",
+		oldPath: opts.Path + ".wal.old",
+		queue:   make(chan walRecord, walQueueSize),
+		stopCh:  make(chan struct{}),
+	}
+
+	start := time.Now()
+	var deadline time.Time
+	if opts.LoadTimeout > 0 {
+		deadline = start.Add(opts.LoadTimeout)
+	}
+
+	loaded, err := c.loadSnapshot(opts.Path, deadline)
+	if err != nil {
+		// 快照损坏不影响日志回放，只是少了基线数据
+		logger.Warnf("[Persistence] Failed to load snapshot %s: %v", opts.Path, err)
+	}
+	w.loadedEntries = loaded
+
+	replayed, truncated, _ := c.replayWAL(w.oldPath, deadline)
+	w.replayedRecords += replayed
+	w.loadTruncated = truncated
+
+	validSize := int64(-1)
+	if !w.loadTruncated {
+		replayed, truncated, validSize = c.replayWAL(w.walPath, deadline)
+		w.replayedRecords += replayed
+		w.loadTruncated = truncated
+	}
+	if !deadline.IsZero() && time.Now().After(deadline) {
+		w.loadTruncated = true
+	}
+	w.loadDuration = time.Since(start)
+
+	f, err := os.OpenFile(w.walPath, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+	// 截掉撕裂的尾部，之后的追加从最后一条完整记录开始
+	if validSize >= 0 {
+		if info, err := f.Stat(); err == nil && info.Size() > validSize {
+			logger.Warnf("[Persistence] Truncating torn WAL tail: %d -> %d bytes", info.Size(), validSize)
+			if err := f.Truncate(validSize); err != nil {
+				f.Close()
+				return err
+			}
+		}
+	}
+	size, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	w.file = f
+	w.buf = bufio.NewWriter(f)
+	w.size = size
+
+	atomic.StoreUint64(&c.lastSavedDirty, c.rawCache.GetDirtyCount())
+	logger.Infof("[Persistence] Loaded %d snapshot entries and %d WAL records in %v (truncated=%v)",
+		w.loadedEntries, w.replayedRecords, w.loadDuration, w.loadTruncated)
+
+	w.wg.Add(1)
+	go w.run(c)
+	c.wal.Store(w)
+	return nil
+}
+
+// replayWAL 回放日志文件，返回回放的记录数、是否因超时提前结束，以及最后一条完整记录之后的偏移
+// 文件不存在时偏移为 -1
+func (c *Cache) replayWAL(path string, deadline time.Time) (int, bool, int64) {
+	f, err := os.Open(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logger.Warnf("[Persistence] Failed to open WAL %s: %v", path, err)
+		}
+		return 0, false, -1
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	now := time.Now().Unix()
+	var offset int64
+	replayed := 0
+	for {
+		if !deadline.IsZero() && replayed%256 == 0 && time.Now().After(deadline) {
+			return replayed, true, -1
+		}
+		rec, n, err := readWALFrame(r)
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				logger.Warnf("[Persistence] WAL %s damaged at offset %d: %v", path, offset, err)
+			}
+			return replayed, false, offset
+		}
+		offset += n
+		c.applyWALRecord(rec, now)
+		replayed++
+	}
+}
+
+func (c *Cache) applyWALRecord(rec walRecord, now int64) {
+	switch rec.Op {
+	case walOpInsert:
+		if rec.Entry == nil {
+			return
+		}
+		key, entry := restoreRawEntry(*rec.Entry, now)
+		c.rawCache.Set(key, entry)
+	case walOpDelete:
+		c.rawCache.Delete(rec.Key)
+	case walOpClear:
+		c.rawCache.Clear()
+	}
+}
+
+// readWALFrame 读取一帧，返回记录和帧长度；不完整的帧返回 io.ErrUnexpectedEOF
+func readWALFrame(r io.Reader) (walRecord, int64, error) {
+	var rec walRecord
+	var header [walFrameHeaderSize]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return rec, 0, err
+	}
+	length := binary.BigEndian.Uint32(header[:4])
+	if length == 0 || length > walMaxRecordSize {
+		return rec, 0, errors.New("invalid record length")
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return rec, 0, err
+	}
+	if crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(header[4:]) {
+		return rec, 0, errors.New("record checksum mismatch")
+	}
+	if err := json.Unmarshal(payload, &rec); err != nil {
+		return rec, 0, err
+	}
+	return rec, int64(walFrameHeaderSize + length), nil
+}
+
+// appendWALFrame 编码一条记录并写入 w，返回写入的字节数
+func appendWALFrame(w io.Writer, rec walRecord) (int64, error) {
+	payload, err := json.Marshal(rec)
+	if err != nil {
+		return 0, err
+	}
+	var header [walFrameHeaderSize]byte
+	binary.BigEndian.PutUint32(header[:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(header[4:], crc32.ChecksumIEEE(payload))
+	if _, err := w.Write(header[:]); err != nil {
+		return 0, err
+	}
+	if _, err := w.Write(payload); err != nil {
+		return 0, err
+	}
+	return int64(walFrameHeaderSize + len(payload)), nil
+}
+
+// run 写入循环：顺序写入队列中的记录，按 SyncInterval 刷盘并检查是否需要压缩
+func (w *walWriter) run(c *Cache) {
+	defer w.wg.Done()
+	ticker := time.NewTicker(w.opts.SyncInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case rec := <-w.queue:
+			w.write(rec)
+		case <-ticker.C:
+			w.sync()
+			if w.opts.CompactSize > 0 && w.currentSize() >= w.opts.CompactSize && w.compacting.CompareAndSwap(false, true) {
+				w.wg.Add(1)
+				go func() {
+					defer w.wg.Done()
+					defer w.compacting.Store(false)
+					if err := c.compactWith(w); err != nil {
+						logger.Warnf("[Persistence] Background compaction failed: %v", err)
+					}
+				}()
+			}
+		case <-w.stopCh:
+			for {
+				select {
+				case rec := <-w.queue:
+					w.write(rec)
+				default:
+					w.sync()
+					return
+				}
+			}
+		}
+	}
+}
+
+func (w *walWriter) write(rec walRecord) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	n, err := appendWALFrame(w.buf, rec)
+	if err != nil {
+		logger.Debugf("[Persistence] WAL append failed: %v", err)
+		return
+	}
+	w.size += n
+	w.appended.Add(1)
+}
+
+func (w *walWriter) sync() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := w.buf.Flush(); err != nil {
+		logger.Warnf("[Persistence] WAL flush failed: %v", err)
+		return
+	}
+	w.file.Sync()
+}
+
+func (w *walWriter) currentSize() int64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.size
+}
+
+// enqueue 非阻塞投递，队列满时丢弃（下一次压缩写入的快照会补上）
+func (w *walWriter) enqueue(rec walRecord) {
+	select {
+	case w.queue <- rec:
+	default:
+		w.dropped.Add(1)
+	}
+}
+
+// rotate 将当前日志并入 .wal.old 并重新打开空日志
+// 上一次压缩失败遗留的 .wal.old 会保留，新内容追加在其后
+func (w *walWriter) rotate() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if err := w.buf.Flush(); err != nil {
+		return err
+	}
+	if err := w.file.Sync(); err != nil {
+		return err
+	}
+
+	if _, err := os.Stat(w.oldPath); err == nil {
+		old, err := os.OpenFile(w.oldPath, os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return err
+		}
+		if _, err := w.file.Seek(0, io.SeekStart); err != nil {
+			old.Close()
+			return err
+		}
+		if _, err := io.Copy(old, w.file); err != nil {
+			old.Close()
+			return err
+		}
+		if err := old.Sync(); err != nil {
+			old.Close()
+			return err
+		}
+		old.Close()
+		if err := w.file.Truncate(0); err != nil {
+			return err
+		}
+		if _, err := w.file.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		w.buf.Reset(w.file)
+		w.size = 0
+		return nil
+	}
+
+	w.file.Close()
+	if err := os.Rename(w.walPath, w.oldPath); err != nil {
+		// 重命名失败时继续使用原日志
+		if f, openErr := os.OpenFile(w.walPath, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644); openErr == nil {
+			w.file = f
+			w.buf.Reset(f)
+		}
+		return err
+	}
+	f, err := os.OpenFile(w.walPath, os.O_CREATE|os.O_RDWR|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	w.file = f
+	w.buf.Reset(f)
+	w.size = 0
+	return nil
+}
+
+// Compact 写入全量快照并丢弃已包含在快照中的日志
+// 未启用增量持久化时什么也不做
+func (c *Cache) Compact() error {
+	w := c.wal.Load()
+	if w == nil {
+		return nil
+	}
+	return c.compactWith(w)
+}
+
+// compactWith 压缩流程：轮转日志 → 写快照 → 删除 .wal.old
+// 轮转之后的变更写入新日志，回放时叠加在快照之上
+func (c *Cache) compactWith(w *walWriter) error {
+	w.compactMu.Lock()
+	defer w.compactMu.Unlock()
+
+	if err := w.rotate(); err != nil {
+		return err
+	}
+	if err := c.writeSnapshot(w.opts.Path, true); err != nil {
+		return err
+	}
+	if err := os.Remove(w.oldPath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	w.compactions.Add(1)
+	w.lastCompaction.Store(time.Now().UnixNano())
+	return nil
+}
+
+// ClosePersistence 停止记录日志并写入最终快照，之后日志文件被删除
+func (c *Cache) ClosePersistence() error {
+	w := c.wal.Swap(nil)
+	if w == nil {
+		return nil
+	}
+	close(w.stopCh)
+	w.wg.Wait()
+
+	err := c.compactWith(w)
+	w.mu.Lock()
+	w.file.Close()
+	w.mu.Unlock()
+	if err == nil {
+		os.Remove(w.walPath)
+	}
+	return err
+}
+
+// GetPersistenceStats 获取增量持久化统计，未启用时 Enabled=false
+func (c *Cache) GetPersistenceStats() PersistenceStats {
+	w := c.wal.Load()
+	if w == nil {
+		return PersistenceStats{}
+	}
+	stats := PersistenceStats{
+		Enabled:         true,
+		WALBytes:        w.currentSize(),
+		Appended:        w.appended.Load(),
+		Dropped:         w.dropped.Load(),
+		Compactions:     w.compactions.Load(),
+		LoadedEntries:   w.loadedEntries,
+		ReplayedRecords: w.replayedRecords,
+		LoadDurationMs:  w.loadDuration.Milliseconds(),
+		LoadTruncated:   w.loadTruncated,
+	}
+	if ts := w.lastCompaction.Load(); ts > 0 {
+		stats.LastCompaction = time.Unix(0, ts)
+	}
+	return stats
+}
+
+// logWALInsert 记录原始缓存写入
+func (c *Cache) logWALInsert(domain string, qtype uint16, entry *RawCacheEntry) {
+	w := c.wal.Load()
+	if w == nil {
+		return
+	}
+	pe := toPersistentEntry(domain, qtype, entry)
+	w.enqueue(walRecord{Op: walOpInsert, Entry: &pe})
+}
+
+// logWALDelete 记录原始缓存删除
+func (c *Cache) logWALDelete(key string) {
+	if w := c.wal.Load(); w != nil {
+		w.enqueue(walRecord{Op: walOpDelete, Key: key})
+	}
+}
+
+// logWALClear 记录清空操作
+func (c *Cache) logWALClear() {
+	if w := c.wal.Load(); w != nil {
+		w.enqueue(walRecord{Op: walOpClear})
+	}
+}
@@ -0,0 +1,216 @@
+package cache
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// openTestPersistence 在临时目录中打开增量持久化，测试结束时丢弃写入器
+func openTestPersistence(t *testing.T, path string, loadTimeout time.Duration) *Cache {
+	t.Helper()
+	c := NewCache(getDefaultCacheConfig())
+	require.NoError(t, c.OpenPersistence(PersistenceOptions{
+		Path:         path,
+		SyncInterval: 10 * time.Millisecond,
+		CompactSize:  1 << 30,
+		LoadTimeout:  loadTimeout,
+	}))
+	t.Cleanup(func() { simulateCrash(c) })
+	return c
+}
+
+// simulateCrash 停止写入器但不写快照，相当于进程在日志刷盘后被杀掉
+func simulateCrash(c *Cache) {
+	w := c.wal.Swap(nil)
+	if w == nil {
+		return
+	}
+	close(w.stopCh)
+	w.wg.Wait()
+	w.file.Close()
+}
+
+func setTestRaw(c *Cache, domain string, ips ...string) {
+	c.SetRawRecordsWithSource(domain, dns.TypeA, newTestARecords(domain, ips...), nil, 300, false, "")
+}
+
+func TestWALReplayAfterCrash(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dns_cache.bin")
+	c := openTestPersistence(t, path, 0)
+
+	setTestRaw(c, "a.example.com", "1.1.1.1")
+	setTestRaw(c, "b.example.com", "2.2.2.2")
+	c.SetRawRecordsWithSource("mx.example.com", dns.TypeMX, []dns.RR{mustRR(t, "mx.example.com. 300 IN MX 10 mail.example.com.")}, nil, 300, true, "")
+	c.Purge(EntryFilter{Name: "b.example.com"})
+	simulateCrash(c)
+
+	_, err := os.Stat(path)
+	assert.True(t, os.IsNotExist(err), "no snapshot should have been written")
+
+	restored := openTestPersistence(t, path, 0)
+	raw, ok := restored.GetRaw("a.example.com", dns.TypeA)
+	require.True(t, ok)
+	assert.Equal(t, []string{"1.1.1.1"}, raw.IPs)
+	_, ok = restored.GetRaw("b.example.com", dns.TypeA)
+	assert.False(t, ok, "deleted entry must not come back")
+
+	mx, ok := restored.GetRaw("mx.example.com", dns.TypeMX)
+	require.True(t, ok)
+	require.Len(t, mx.Records, 1)
+	assert.Equal(t, "mail.example.com.", mx.Records[0].(*dns.MX).Mx)
+	assert.True(t, mx.AuthenticatedData)
+
+	stats := restored.GetPersistenceStats()
+	assert.Equal(t, 4, stats.ReplayedRecords)
+	assert.False(t, stats.LoadTruncated)
+}
+
+func TestWALTruncatesTornTail(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dns_cache.bin")
+	c := openTestPersistence(t, path, 0)
+	setTestRaw(c, "a.example.com", "1.1.1.1")
+	simulateCrash(c)
+
+	info, err := os.Stat(path + ".wal")
+	require.NoError(t, err)
+	validSize := info.Size()
+
+	// 追加一个只写了一半的帧
+	f, err := os.OpenFile(path+".wal", os.O_WRONLY|os.O_APPEND, 0644)
+	require.NoError(t, err)
+	_, err = f.Write([]byte{0, 0, 1, 0, 0xde, 0xad, 0xbe, 0xef, '{', '"'})
+	require.NoError(t, err)
+	f.Close()
+
+	c = openTestPersistence(t, path, 0)
+	_, ok := c.GetRaw("a.example.com", dns.TypeA)
+	assert.True(t, ok)
+	info, err = os.Stat(path + ".wal")
+	require.NoError(t, err)
+	assert.Equal(t, validSize, info.Size(), "torn tail should be truncated")
+
+	// 截断后追加的记录可以正常回放
+	setTestRaw(c, "b.example.com", "2.2.2.2")
+	simulateCrash(c)
+	c = openTestPersistence(t, path, 0)
+	_, ok = c.GetRaw("a.example.com", dns.TypeA)
+	assert.True(t, ok)
+	_, ok = c.GetRaw("b.example.com", dns.TypeA)
+	assert.True(t, ok)
+	assert.Equal(t, 2, c.GetPersistenceStats().ReplayedRecords)
+}
+
+func TestCompactWritesSnapshotAndResetsWAL(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dns_cache.bin")
+	c := openTestPersistence(t, path, 0)
+	setTestRaw(c, "a.example.com", "1.1.1.1")
+	require.Eventually(t, func() bool { return c.GetPersistenceStats().Appended == 1 }, time.Second, 5*time.Millisecond)
+
+	require.NoError(t, c.Compact())
+	stats := c.GetPersistenceStats()
+	assert.Equal(t, int64(0), stats.WALBytes)
+	assert.Equal(t, int64(1), stats.Compactions)
+	_, err := os.Stat(path + ".wal.old")
+	assert.True(t, os.IsNotExist(err))
+
+	// 压缩后的变更叠加在快照之上
+	setTestRaw(c, "b.example.com", "2.2.2.2")
+	simulateCrash(c)
+
+	c = openTestPersistence(t, path, 0)
+	_, ok := c.GetRaw("a.example.com", dns.TypeA)
+	assert.True(t, ok)
+	_, ok = c.GetRaw("b.example.com", dns.TypeA)
+	assert.True(t, ok)
+	stats = c.GetPersistenceStats()
+	assert.Equal(t, 1, stats.LoadedEntries)
+	assert.Equal(t, 1, stats.ReplayedRecords)
+}
+
+func TestWALClearRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dns_cache.bin")
+	c := openTestPersistence(t, path, 0)
+	setTestRaw(c, "a.example.com", "1.1.1.1")
+	require.NoError(t, c.Compact())
+	c.Clear()
+	setTestRaw(c, "b.example.com", "2.2.2.2")
+	simulateCrash(c)
+
+	c = openTestPersistence(t, path, 0)
+	_, ok := c.GetRaw("a.example.com", dns.TypeA)
+	assert.False(t, ok, "clear must also drop entries loaded from the snapshot")
+	_, ok = c.GetRaw("b.example.com", dns.TypeA)
+	assert.True(t, ok)
+}
+
+func TestClosePersistenceLeavesOnlySnapshot(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dns_cache.bin")
+	c := openTestPersistence(t, path, 0)
+	setTestRaw(c, "a.example.com", "1.1.1.1")
+	require.NoError(t, c.ClosePersistence())
+	assert.False(t, c.GetPersistenceStats().Enabled)
+
+	_, err := os.Stat(path + ".wal")
+	assert.True(t, os.IsNotExist(err))
+
+	c = openTestPersistence(t, path, 0)
+	_, ok := c.GetRaw("a.example.com", dns.TypeA)
+	assert.True(t, ok)
+	assert.Equal(t, 1, c.GetPersistenceStats().LoadedEntries)
+}
+
+func TestPersistenceLoadTimeout(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dns_cache.bin")
+	c := openTestPersistence(t, path, 0)
+	setTestRaw(c, "a.example.com", "1.1.1.1")
+	simulateCrash(c)
+
+	c = openTestPersistence(t, path, time.Nanosecond)
+	stats := c.GetPersistenceStats()
+	assert.True(t, stats.LoadTruncated)
+	assert.Equal(t, 0, stats.ReplayedRecords)
+}
+
+func mustRR(t *testing.T, s string) dns.RR {
+	t.Helper()
+	rr, err := dns.NewRR(s)
+	require.NoError(t, err)
+	return rr
+}
+
+func TestRawMessageSurvivesPersistence(t *testing.T) {
+	c := NewCache(getDefaultCacheConfig())
+
+	msg := new(dns.Msg)
+	msg.SetQuestion("svc.example.com.", dns.TypeHTTPS)
+	msg.Response = true
+	msg.Answer = []dns.RR{mustRR(t, `svc.example.com. 300 IN HTTPS 1 . alpn="h3,h2" ipv4hint="192.0.2.1,192.0.2.2" ipv6hint="2001:db8::1"`)}
+	msg.Extra = []dns.RR{mustRR(t, "svc.example.com. 300 IN A 192.0.2.1")}
+	c.SetRawMessage("svc.example.com", dns.TypeHTTPS, msg, msg.Answer, nil, 300, false, "")
+
+	raw, ok := c.GetRaw("svc.example.com", dns.TypeHTTPS)
+	require.True(t, ok)
+	require.NotNil(t, raw.Msg)
+	assert.Zero(t, raw.Msg.Id)
+
+	pe := toPersistentEntry("svc.example.com", dns.TypeHTTPS, raw)
+	require.NotEmpty(t, pe.Msg)
+
+	pe.AcquisitionTime -= 100 // 落盘后 100 秒重启
+	_, restored := restoreRawEntry(pe, time.Now().Unix())
+	require.NotNil(t, restored.Msg)
+	require.Len(t, restored.Msg.Answer, 1)
+	require.Len(t, restored.Msg.Extra, 1)
+
+	https, ok := restored.Msg.Answer[0].(*dns.HTTPS)
+	require.True(t, ok)
+	assert.Equal(t, msg.Answer[0].(*dns.HTTPS).Value, https.Value, "SVCB parameters including address hints must round-trip")
+	assert.InDelta(t, 200, https.Hdr.Ttl, 2)
+	assert.InDelta(t, 200, restored.Msg.Extra[0].Header().Ttl, 2)
+}
@@ -28,6 +28,12 @@ type RawCacheEntry struct {
 	AcquisitionTime   time.Time // 从上游获取的时间
 	AuthenticatedData bool      // DNSSEC 验证标记 (AD flag)
 	QueryVersion      int64     // 查询版本号，用于防止旧的后台补全覆盖新的缓存
+	Source            string    // 应答来源的上游服务器（后台合并等场景为空）
+
+	// Msg 通用记录类型（非 A/AAAA）的上游完整应答，保留 Authority/Additional 段
+	// （MX/SRV/NS 的 glue、HTTPS/SVCB 的地址提示等），为 nil 时由 Records 重建应答
+	// 写入后只读，使用方需要 Copy 后再修改
+	Msg *dns.Msg
 
 	// 第二阶段改造：Stale-While-Revalidate 支持
 	gracePeriod uint32 // 软过期容忍期（秒），用于 Stale-While-Revalidate
@@ -156,4 +162,11 @@ type PersistentCacheEntry struct {
 	AcquisitionTime int64  `json:"acquisition_time"`       // 原始获取时间 (Unix 时间戳)
 	EffectiveTTL    uint32 `json:"effective_ttl"`          // 当时生效的 TTL 策略值（秒）
 	GracePeriod     uint32 `json:"grace_period,omitempty"` // 软过期容忍期（秒），用于 Stale-While-Revalidate
+
+	// 非 A/AAAA 记录（MX、TXT、HTTPS 等）的文本形式，旧版本文件中为空
+	Records           []string `json:"records,omitempty"`
+	AuthenticatedData bool     `json:"ad,omitempty"`
+
+	// 通用记录类型的上游完整应答（线路格式），旧版本文件中为空
+	Msg []byte `json:"msg,omitempty"`
 }
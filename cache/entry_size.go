@@ -0,0 +1,85 @@
+package cache
+
+import (
+	"unsafe"
+
+	"github.com/miekg/dns"
+)
+
+// 内存占用计算中使用的运行时开销（64 位平台）
+const (
+	stringHeaderSize = int64(unsafe.Sizeof(""))
+	// mapSlotOverhead map 中一个槽位的开销：key 字符串头 + 元素指针 + tophash，按装载因子放大
+	mapSlotOverhead = (stringHeaderSize + 8 + 1) * 8 / 6
+	// rrOverhead 单条 dns.RR 在内存中相对于线路格式多出的开销：RR_Header 结构体及分配器对齐
+	rrOverhead = int64(unsafe.Sizeof(dns.RR_Header{})) + 16
+)
+
+// entrySize 计算一个缓存条目（含 key、链表节点和 map 槽位）实际占用的字节数
+// 按条目内容逐项累加，IP 数量、CNAME 链长度和记录数越多，结果越大
+func entrySize(key string, value any) int64 {
+	size := int64(len(key)) + mapSlotOverhead + int64(unsafe.Sizeof(CacheNode{}))
+	switch e := value.(type) {
+	case *RawCacheEntry:
+		size += rawEntrySize(e)
+	case *SortedCacheEntry:
+		size += sortedEntrySize(e)
+	case *ErrorCacheEntry:
+		size += int64(unsafe.Sizeof(*e))
+	case *DNSSECCacheEntry:
+		size += int64(unsafe.Sizeof(*e)) + msgSize(e.Message)
+	case string:
+		size += int64(len(e))
+	}
+	return size
+}
+
+func rawEntrySize(e *RawCacheEntry) int64 {
+	if e == nil {
+		return 0
+	}
+	return int64(unsafe.Sizeof(*e)) + stringsSize(e.IPs) + stringsSize(e.CNAMEs) +
+		int64(len(e.Source)) + rrsSize(e.Records) + msgSize(e.Msg)
+}
+
+func sortedEntrySize(e *SortedCacheEntry) int64 {
+	if e == nil {
+		return 0
+	}
+	return int64(unsafe.Sizeof(*e)) + stringsSize(e.IPs) + int64(cap(e.RTTs))*int64(unsafe.Sizeof(int(0)))
+}
+
+// msgSize 计算完整 DNS 消息的占用：消息结构体 + 各段记录
+func msgSize(m *dns.Msg) int64 {
+	if m == nil {
+		return 0
+	}
+	size := int64(unsafe.Sizeof(*m))
+	for _, q := range m.Question {
+		size += int64(len(q.Name))
+	}
+	size += int64(cap(m.Question)) * int64(unsafe.Sizeof(dns.Question{}))
+	return size + rrsSize(m.Answer) + rrsSize(m.Ns) + rrsSize(m.Extra)
+}
+
+// stringsSize 计算字符串切片的底层数组与字符串内容占用
+func stringsSize(ss []string) int64 {
+	size := int64(cap(ss)) * stringHeaderSize
+	for _, s := range ss {
+		size += int64(len(s))
+	}
+	return size
+}
+
+// rrsSize 以线路格式长度加固定结构开销计算记录切片的占用
+// 线路格式长度包含 owner name 和 RDATA，能反映长 CNAME 链、TXT 等大记录的真实体积
+func rrsSize(rrs []dns.RR) int64 {
+	size := int64(cap(rrs)) * 16 // 接口值
+	for _, rr := range rrs {
+		if rr == nil {
+			continue
+		}
+		size += int64(dns.Len(rr)) + rrOverhead
+	}
+	return size
+}
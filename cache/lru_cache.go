@@ -23,12 +23,17 @@ type LRUCache struct {
 
 	// 驱逐回调
 	onEvict func(key string, value any) // 当条目被驱逐时调用
+
+	// 字节记账，acct 为 nil 表示不记账
+	acct  *layerAccount
+	bytes int64
 }
 
 // lruNode 链表中的节点
 type lruNode struct {
 	key   string
 	value any
+	size  int64
 }
 
 // NewLRUCache 创建一个容量限制的 LRU 缓存
@@ -124,12 +129,22 @@ func (lru *LRUCache) GetNoUpdate(key string) (any, bool) {
 // Set 添加或更新一个值
 // 新条目添加到链表头部，如果超过容量则删除尾部元素（最久未使用）
 func (lru *LRUCache) Set(key string, value any) {
+	// 释放锁后再检查字节上限
+	defer lru.acct.reclaim()
+
 	lru.mu.Lock()
 	defer lru.mu.Unlock()
 
+	var size int64
+	if lru.acct != nil {
+		size = entrySize(key, value)
+	}
+
 	// 如果 key 已存在，更新值并移到头部
 	if elem, exists := lru.cache[key]; exists {
-		elem.Value.(*lruNode).value = value
+		node := elem.Value.(*lruNode)
+		node.value = value
+		lru.resize(node, size)
 		lru.list.MoveToFront(elem)
 		return
 	}
@@ -138,6 +153,7 @@ func (lru *LRUCache) Set(key string, value any) {
 	node := &lruNode{key: key, value: value}
 	elem := lru.list.PushFront(node)
 	lru.cache[key] = elem
+	lru.resize(node, size)
 
 	// 如果超过容量，删除尾部元素（最久未使用）
 	if lru.capacity > 0 && lru.list.Len() > lru.capacity {
@@ -145,6 +161,31 @@ func (lru *LRUCache) Set(key string, value any) {
 	}
 }
 
+// resize 更新节点的记账大小（在持有写锁的情况下调用）
+func (lru *LRUCache) resize(node *lruNode, size int64) {
+	delta := size - node.size
+	node.size = size
+	lru.bytes += delta
+	lru.acct.add(delta)
+}
+
+// evictLRU 驱逐最久未使用的条目，供字节预算回收使用
+func (lru *LRUCache) evictLRU() bool {
+	lru.mu.Lock()
+	defer lru.mu.Unlock()
+	if lru.list.Len() == 0 {
+		return false
+	}
+	lru.evictOne()
+	return true
+}
+
+// enableAccounting 开启字节记账，必须在缓存投入使用前调用
+func (lru *LRUCache) enableAccounting(acct *layerAccount) {
+	acct.evict = lru.evictLRU
+	lru.acct = acct
+}
+
 // evictOne 删除链表尾部的元素（最久未使用）
 func (lru *LRUCache) evictOne() {
 	elem := lru.list.Back()
@@ -153,6 +194,8 @@ func (lru *LRUCache) evictOne() {
 		key := elem.Value.(*lruNode).key
 		value := elem.Value.(*lruNode).value
 		delete(lru.cache, key)
+		lru.bytes -= elem.Value.(*lruNode).size
+		lru.acct.add(-elem.Value.(*lruNode).size)
 
 		// 调用驱逐回调
 		if lru.onEvict != nil {
@@ -176,15 +219,31 @@ func (lru *LRUCache) Delete(key string) {
 	if elem, exists := lru.cache[key]; exists {
 		lru.list.Remove(elem)
 		delete(lru.cache, key)
+		lru.bytes -= elem.Value.(*lruNode).size
+		lru.acct.add(-elem.Value.(*lruNode).size)
 	}
 }
 
+// GetAllKeys 获取所有键快照（按最近使用顺序）
+func (lru *LRUCache) GetAllKeys() []string {
+	lru.mu.RLock()
+	defer lru.mu.RUnlock()
+
+	keys := make([]string, 0, lru.list.Len())
+	for elem := lru.list.Front(); elem != nil; elem = elem.Next() {
+		keys = append(keys, elem.Value.(*lruNode).key)
+	}
+	return keys
+}
+
 // Clear 清空缓存
 func (lru *LRUCache) Clear() {
 	lru.mu.Lock()
 	defer lru.mu.Unlock()
 	lru.cache = make(map[string]*list.Element)
 	lru.list = list.New()
+	lru.acct.add(-lru.bytes)
+	lru.bytes = 0
 }
 
 // Close 关闭缓存，停止异步处理 goroutine
@@ -226,8 +285,10 @@ func (lru *LRUCache) CleanExpired(isExpired func(value any) bool) int {
 	count := 0
 	for _, elem := range elemsToRemove {
 		lru.list.Remove(elem)
-		key := elem.Value.(*lruNode).key
-		delete(lru.cache, key)
+		node := elem.Value.(*lruNode)
+		delete(lru.cache, node.key)
+		lru.bytes -= node.size
+		lru.acct.add(-node.size)
 		count++
 	}
 	return count
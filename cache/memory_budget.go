@@ -0,0 +1,111 @@
+package cache
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// layerAccount 单个缓存层（raw/sorted/error/msg）的字节记账
+// 容器在持有分片锁时增量更新，读取无锁
+type layerAccount struct {
+	evict     func() bool   // 驱逐该层最久未使用的一个条目，层为空时返回 false
+	limit     int64         // 该层在共享预算内的独立字节上限，0 表示只受共享预算约束
+	budget    *memoryBudget // 共享预算，nil 表示不计入
+	bytes     atomic.Int64
+	evictions atomic.Int64 // 因字节上限触发的驱逐数
+}
+
+func (a *layerAccount) add(delta int64) {
+	if a == nil || delta == 0 {
+		return
+	}
+	a.bytes.Add(delta)
+	a.budget.add(delta)
+}
+
+// Bytes 返回该层当前占用的字节数
+func (a *layerAccount) Bytes() int64 {
+	if a == nil {
+		return 0
+	}
+	return a.bytes.Load()
+}
+
+// reclaim 在写入后调用（不得持有任何分片锁），先满足本层上限，再满足共享预算
+func (a *layerAccount) reclaim() {
+	if a == nil {
+		return
+	}
+	if a.limit > 0 {
+		for a.bytes.Load() > a.limit && a.evict() {
+			a.evictions.Add(1)
+		}
+	}
+	a.budget.reclaim()
+}
+
+// memoryBudget 多个缓存层共享的硬性字节预算
+// 超出后从占用最大的层开始按 LRU 驱逐，直到回到预算以内
+type memoryBudget struct {
+	limit  int64
+	used   atomic.Int64
+	layers []*layerAccount
+	mu     sync.Mutex // 串行化驱逐，避免并发写入同时超额驱逐
+}
+
+func newMemoryBudget(limit int64) *memoryBudget {
+	if limit <= 0 {
+		return nil
+	}
+	return &memoryBudget{limit: limit}
+}
+
+// account 创建一个计入本预算的层，limit 为该层在预算内的独立上限（0 表示只受共享预算约束）
+// b 为 nil（未设置预算）时只受 limit 约束
+func (b *memoryBudget) account(limit int64) *layerAccount {
+	a := &layerAccount{budget: b, limit: limit}
+	if b != nil {
+		b.layers = append(b.layers, a)
+	}
+	return a
+}
+
+func (b *memoryBudget) add(delta int64) {
+	if b != nil {
+		b.used.Add(delta)
+	}
+}
+
+// Used 返回预算内各层占用的字节总数
+func (b *memoryBudget) Used() int64 {
+	if b == nil {
+		return 0
+	}
+	return b.used.Load()
+}
+
+func (b *memoryBudget) reclaim() {
+	if b == nil || b.used.Load() <= b.limit {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	exhausted := make(map[*layerAccount]bool, len(b.layers))
+	for b.used.Load() > b.limit {
+		var victim *layerAccount
+		for _, l := range b.layers {
+			if !exhausted[l] && (victim == nil || l.Bytes() > victim.Bytes()) {
+				victim = l
+			}
+		}
+		if victim == nil {
+			return
+		}
+		if !victim.evict() {
+			exhausted[victim] = true
+			continue
+		}
+		victim.evictions.Add(1)
+	}
+}
@@ -0,0 +1,117 @@
+package cache
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestCDNRecords 构造一个 CDN 风格的应答：长 CNAME 链加大量 A 记录
+func newTestCDNRecords(domain string, ipCount int) ([]dns.RR, []string) {
+	cnames := []string{
+		"edge." + domain + ".cdn-provider-global.example.net.",
+		"geo-balanced.region-a.cdn-provider-global.example.net.",
+	}
+	ips := make([]string, ipCount)
+	for i := range ips {
+		ips[i] = fmt.Sprintf("10.%d.%d.%d", i/65536%256, i/256%256, i%256)
+	}
+	return newTestARecords(cnames[len(cnames)-1], ips...), cnames
+}
+
+func TestMemoryAccountingFollowsEntryContent(t *testing.T) {
+	c := NewCache(getDefaultCacheConfig())
+
+	setTestRaw(c, "small.example.com", "1.1.1.1")
+	small := c.GetMemoryStats().Layers["raw"].Bytes
+	require.Greater(t, small, int64(0))
+
+	records, cnames := newTestCDNRecords("big.example.com", 40)
+	c.SetRawRecords("big.example.com", dns.TypeA, records, cnames, 300)
+	withBig := c.GetMemoryStats().Layers["raw"].Bytes
+	assert.Greater(t, withBig-small, 20*small, "40 IPs and a CNAME chain should weigh far more than a single-IP entry")
+
+	// 替换同一个 key 只记账差值
+	setTestRaw(c, "big.example.com", "2.2.2.2")
+	assert.InDelta(t, 2*small, c.GetMemoryStats().Layers["raw"].Bytes, float64(small)/2)
+
+	c.SetSorted("small.example.com", dns.TypeA, &SortedCacheEntry{IPs: []string{"1.1.1.1"}, RTTs: []int{10}, Timestamp: time.Now(), TTL: 300, IsValid: true})
+	c.SetError("bad.example.com", dns.TypeA, dns.RcodeServerFailure, 30)
+	msg := new(dns.Msg)
+	msg.SetQuestion("small.example.com.", dns.TypeA)
+	msg.Answer = newTestARecords("small.example.com", "1.1.1.1")
+	c.SetDNSSECMsg("small.example.com", dns.TypeA, msg)
+
+	stats := c.GetMemoryStats()
+	for layer, entries := range map[string]int{"raw": 2, "sorted": 1, "error": 1, "msg": 1} {
+		assert.Equal(t, entries, stats.Layers[layer].Entries, layer)
+		assert.Greater(t, stats.Layers[layer].Bytes, int64(0), layer)
+	}
+	assert.Equal(t, stats.Layers["raw"].Bytes+stats.Layers["sorted"].Bytes+stats.Layers["error"].Bytes+stats.Layers["msg"].Bytes, stats.UsedBytes)
+	assert.Equal(t, stats.UsedBytes, stats.TotalBytes)
+
+	c.Purge(EntryFilter{Name: "small.example.com"})
+	assert.Less(t, c.GetMemoryStats().Layers["raw"].Bytes, 2*small)
+
+	c.Clear()
+	stats = c.GetMemoryStats()
+	assert.Zero(t, stats.TotalBytes)
+	assert.Zero(t, stats.UsedBytes)
+}
+
+func TestMemoryBudgetIsHardLimit(t *testing.T) {
+	cfg := getDefaultCacheConfig()
+	cfg.MaxMemoryMB = 1
+	c := NewCache(cfg)
+
+	const domains = 600
+	for i := 0; i < domains; i++ {
+		domain := fmt.Sprintf("host%d.example.com", i)
+		records, cnames := newTestCDNRecords(domain, 32)
+		c.SetRawRecords(domain, dns.TypeA, records, cnames, 300)
+		c.SetSorted(domain, dns.TypeA, &SortedCacheEntry{IPs: []string{"10.0.0.1", "10.0.0.2"}, RTTs: []int{5, 6}, Timestamp: time.Now(), TTL: 300, IsValid: true})
+		msg := new(dns.Msg)
+		msg.SetQuestion(dns.Fqdn(domain), dns.TypeA)
+		msg.Answer = records
+		c.SetDNSSECMsg(domain, dns.TypeA, msg)
+
+		stats := c.GetMemoryStats()
+		require.LessOrEqual(t, stats.UsedBytes, stats.BudgetBytes, "budget exceeded after %d writes", i+1)
+	}
+
+	stats := c.GetMemoryStats()
+	assert.Less(t, stats.Layers["raw"].Entries, domains)
+	assert.Greater(t, stats.Layers["raw"].BudgetEvictions, int64(0))
+	assert.Greater(t, stats.Layers["msg"].Bytes, int64(0), "msg cache is counted in the shared budget")
+	assert.Greater(t, c.GetEvictions(), int64(0))
+	assert.LessOrEqual(t, c.GetMemoryUsagePercent(), 1.0)
+
+	// LRU：最近写入的域名保留，最早的被淘汰
+	_, ok := c.GetRaw(fmt.Sprintf("host%d.example.com", domains-1), dns.TypeA)
+	assert.True(t, ok)
+	_, ok = c.GetRaw("host0.example.com", dns.TypeA)
+	assert.False(t, ok)
+}
+
+func TestLayerLimitEvictsOnlyThatLayer(t *testing.T) {
+	lru := NewLRUCache(1000)
+	acct := newMemoryBudget(0).account(2048)
+	lru.enableAccounting(acct)
+
+	for i := 0; i < 100; i++ {
+		lru.Set(fmt.Sprintf("key-%03d", i), "0123456789abcdef0123456789abcdef")
+	}
+	assert.LessOrEqual(t, acct.Bytes(), int64(2048))
+	assert.Greater(t, acct.evictions.Load(), int64(0))
+	_, ok := lru.GetNoUpdate("key-099")
+	assert.True(t, ok)
+	_, ok = lru.GetNoUpdate("key-000")
+	assert.False(t, ok)
+
+	lru.CleanExpired(func(any) bool { return true })
+	assert.Zero(t, acct.Bytes())
+}
@@ -10,8 +10,9 @@ import (
 // 每个分片有独立的锁，不同的 key 可以并发访问不同的分片
 type ShardedCache struct {
 	shards []*CacheShard
-	mask   uint32 // 用于快速计算分片索引
-	dirty  uint64 // 变更计数器，用于持久化决策
+	mask   uint32        // 用于快速计算分片索引
+	dirty  uint64        // 变更计数器，用于持久化决策
+	acct   *layerAccount // 字节记账，nil 表示不记账
 }
 
 // CacheShard 单个缓存分片
@@ -20,6 +21,7 @@ type CacheShard struct {
 	capacity int
 	cache    map[string]*CacheNode
 	list     *CacheList
+	bytes    int64 // 本分片条目占用的字节数
 
 	// 异步访问记录机制（每个分片独立）
 	accessChan chan string
@@ -31,6 +33,7 @@ type CacheShard struct {
 type CacheNode struct {
 	key   string
 	value any
+	size  int64 // 记账时计算的条目字节数
 	prev  *CacheNode
 	next  *CacheNode
 }
@@ -136,13 +139,22 @@ func (sc *ShardedCache) markDirty() {
 
 // Set 设置值
 func (sc *ShardedCache) Set(key string, value any) {
+	// 释放分片锁后再检查字节上限，驱逐可能涉及其他分片和其他缓存层
+	defer sc.acct.reclaim()
+
 	shard := sc.getShard(key)
 	shard.mu.Lock()
 	defer shard.mu.Unlock()
 
+	var size int64
+	if sc.acct != nil {
+		size = entrySize(key, value)
+	}
+
 	// 如果 key 已存在，更新值并移到头部
 	if node, exists := shard.cache[key]; exists {
 		node.value = value
+		shard.resize(sc.acct, node, size)
 		shard.list.moveToFront(node)
 		return
 	}
@@ -151,22 +163,65 @@ func (sc *ShardedCache) Set(key string, value any) {
 	node := &CacheNode{key: key, value: value}
 	shard.list.pushFront(node)
 	shard.cache[key] = node
+	shard.resize(sc.acct, node, size)
 
 	// 如果超过容量，删除尾部元素
 	if shard.capacity > 0 && shard.list.len > shard.capacity {
-		shard.evictOne()
+		sc.acct.add(-shard.evictOne())
 	}
 	sc.markDirty()
 }
 
-// evictOne 删除链表尾部的元素（在持有写锁的情况下调用）
-func (shard *CacheShard) evictOne() {
+// resize 更新节点的记账大小（在持有写锁的情况下调用）
+func (shard *CacheShard) resize(acct *layerAccount, node *CacheNode, size int64) {
+	delta := size - node.size
+	node.size = size
+	shard.bytes += delta
+	acct.add(delta)
+}
+
+// evictOne 删除链表尾部的元素（在持有写锁的情况下调用），返回释放的字节数
+func (shard *CacheShard) evictOne() int64 {
 	if shard.list.tail == nil {
-		return
+		return 0
 	}
 	node := shard.list.tail
 	shard.list.remove(node)
 	delete(shard.cache, node.key)
+	shard.bytes -= node.size
+	return node.size
+}
+
+// evictLRU 从占用字节最多的分片驱逐最久未使用的条目，供字节预算回收使用
+func (sc *ShardedCache) evictLRU() bool {
+	var victim *CacheShard
+	var most int64
+	for _, shard := range sc.shards {
+		shard.mu.RLock()
+		b, n := shard.bytes, shard.list.len
+		shard.mu.RUnlock()
+		if n > 0 && (victim == nil || b > most) {
+			victim, most = shard, b
+		}
+	}
+	if victim == nil {
+		return false
+	}
+
+	victim.mu.Lock()
+	evicted := victim.list.tail != nil
+	sc.acct.add(-victim.evictOne())
+	victim.mu.Unlock()
+	if evicted {
+		sc.markDirty()
+	}
+	return evicted
+}
+
+// enableAccounting 开启字节记账，必须在缓存投入使用前调用
+func (sc *ShardedCache) enableAccounting(acct *layerAccount) {
+	acct.evict = sc.evictLRU
+	sc.acct = acct
 }
 
 // Delete 删除一个键
@@ -178,6 +233,8 @@ func (sc *ShardedCache) Delete(key string) {
 	if node, exists := shard.cache[key]; exists {
 		shard.list.remove(node)
 		delete(shard.cache, key)
+		shard.bytes -= node.size
+		sc.acct.add(-node.size)
 		sc.markDirty()
 	}
 }
@@ -199,6 +256,8 @@ func (sc *ShardedCache) Clear() {
 		shard.mu.Lock()
 		shard.cache = make(map[string]*CacheNode)
 		shard.list = &CacheList{}
+		sc.acct.add(-shard.bytes)
+		shard.bytes = 0
 		shard.mu.Unlock()
 	}
 	sc.markDirty()
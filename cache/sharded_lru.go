@@ -14,6 +14,7 @@ type ShardedLRUCache struct {
 	mask    uint32
 	onEvict func(key string, value any)
 	dirty   uint64
+	acct    *layerAccount // 字节记账，nil 表示不记账
 }
 
 // ShardedLRUShard 单个分片
@@ -22,6 +23,7 @@ type ShardedLRUShard struct {
 	capacity int
 	cache    map[string]*list.Element
 	list     *list.List
+	bytes    int64 // 本分片条目占用的字节数
 
 	// 异步访问记录
 	accessChan chan string
@@ -33,6 +35,7 @@ type ShardedLRUShard struct {
 type shardedLRUNode struct {
 	key   string
 	value any
+	size  int64
 }
 
 // NewShardedLRUCache 创建分片 LRU 缓存
@@ -114,12 +117,21 @@ func (slru *ShardedLRUCache) GetNoUpdate(key string) (any, bool) {
 
 // Set 设置值
 func (slru *ShardedLRUCache) Set(key string, value any) {
+	defer slru.acct.reclaim()
+
 	shard := slru.getShard(key)
 	shard.mu.Lock()
 	defer shard.mu.Unlock()
 
+	var size int64
+	if slru.acct != nil {
+		size = entrySize(key, value)
+	}
+
 	if elem, exists := shard.cache[key]; exists {
-		elem.Value.(*shardedLRUNode).value = value
+		node := elem.Value.(*shardedLRUNode)
+		node.value = value
+		shard.resize(slru.acct, node, size)
 		shard.list.MoveToFront(elem)
 		return
 	}
@@ -127,13 +139,46 @@ func (slru *ShardedLRUCache) Set(key string, value any) {
 	node := &shardedLRUNode{key: key, value: value}
 	elem := shard.list.PushFront(node)
 	shard.cache[key] = elem
+	shard.resize(slru.acct, node, size)
 
 	if shard.capacity > 0 && shard.list.Len() > shard.capacity {
-		shard.evictOne(slru.onEvict)
+		slru.acct.add(-shard.evictOne(slru.onEvict))
 	}
 	atomic.AddUint64(&slru.dirty, 1)
 }
 
+// evictLRU 从占用字节最多的分片驱逐最久未使用的条目，供字节预算回收使用
+func (slru *ShardedLRUCache) evictLRU() bool {
+	var victim *ShardedLRUShard
+	var most int64
+	for _, shard := range slru.shards {
+		shard.mu.RLock()
+		b, n := shard.bytes, shard.list.Len()
+		shard.mu.RUnlock()
+		if n > 0 && (victim == nil || b > most) {
+			victim, most = shard, b
+		}
+	}
+	if victim == nil {
+		return false
+	}
+
+	victim.mu.Lock()
+	evicted := victim.list.Len() > 0
+	slru.acct.add(-victim.evictOne(slru.onEvict))
+	victim.mu.Unlock()
+	if evicted {
+		atomic.AddUint64(&slru.dirty, 1)
+	}
+	return evicted
+}
+
+// enableAccounting 开启字节记账，必须在缓存投入使用前调用
+func (slru *ShardedLRUCache) enableAccounting(acct *layerAccount) {
+	acct.evict = slru.evictLRU
+	slru.acct = acct
+}
+
 // Delete 删除键
 func (slru *ShardedLRUCache) Delete(key string) {
 	shard := slru.getShard(key)
@@ -143,6 +188,9 @@ func (slru *ShardedLRUCache) Delete(key string) {
 	if elem, exists := shard.cache[key]; exists {
 		shard.list.Remove(elem)
 		delete(shard.cache, key)
+		size := elem.Value.(*shardedLRUNode).size
+		shard.bytes -= size
+		slru.acct.add(-size)
 		atomic.AddUint64(&slru.dirty, 1)
 	}
 }
@@ -164,6 +212,8 @@ func (slru *ShardedLRUCache) Clear() {
 		shard.mu.Lock()
 		shard.cache = make(map[string]*list.Element)
 		shard.list = list.New()
+		slru.acct.add(-shard.bytes)
+		shard.bytes = 0
 		shard.mu.Unlock()
 	}
 	atomic.AddUint64(&slru.dirty, 1)
@@ -205,8 +255,10 @@ func (slru *ShardedLRUCache) CleanExpired(isExpired func(value any) bool) int {
 		count := 0
 		for _, elem := range elemsToRemove {
 			shard.list.Remove(elem)
-			key := elem.Value.(*shardedLRUNode).key
-			delete(shard.cache, key)
+			node := elem.Value.(*shardedLRUNode)
+			delete(shard.cache, node.key)
+			shard.bytes -= node.size
+			slru.acct.add(-node.size)
 			count++
 		}
 		totalCount += count
@@ -243,20 +295,29 @@ func (slru *ShardedLRUCache) GetAllKeys() []string {
 
 // ShardedLRUShard 方法
 
-// evictOne 驱逐尾部元素（最久未使用）
-func (shard *ShardedLRUShard) evictOne(onEvict func(key string, value any)) {
+// evictOne 驱逐尾部元素（最久未使用），返回释放的字节数
+func (shard *ShardedLRUShard) evictOne(onEvict func(key string, value any)) int64 {
 	elem := shard.list.Back()
 	if elem == nil {
-		return
+		return 0
 	}
 	shard.list.Remove(elem)
-	key := elem.Value.(*shardedLRUNode).key
-	value := elem.Value.(*shardedLRUNode).value
-	delete(shard.cache, key)
+	node := elem.Value.(*shardedLRUNode)
+	delete(shard.cache, node.key)
+	shard.bytes -= node.size
 
 	if onEvict != nil {
-		onEvict(key, value)
+		onEvict(node.key, node.value)
 	}
+	return node.size
+}
+
+// resize 更新节点的记账大小（在持有写锁的情况下调用）
+func (shard *ShardedLRUShard) resize(acct *layerAccount, node *shardedLRUNode, size int64) {
+	delta := size - node.size
+	node.size = size
+	shard.bytes += delta
+	acct.add(delta)
 }
 
 // processAccessRecords 异步处理访问记录
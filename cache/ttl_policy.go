@@ -0,0 +1,176 @@
+package cache
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"smartdnssort/config"
+)
+
+// TTLPolicy 某个域名最终生效的缓存策略：全局配置叠加第一条命中的按域名规则
+type TTLPolicy struct {
+	MinTTL        uint32 // 0 表示不限制
+	MaxTTL        uint32 // 0 表示不限制
+	UserReturnTTL int
+	KeepExpired   bool
+	// KeepExpiredFixed 为 true 表示规则显式指定了 keep_expired，
+	// 此时不再因内存压力低而自动放宽为允许返回过期数据
+	KeepExpiredFixed bool
+	Prefetch         bool
+	Rule             string // 命中规则的 domain 或 regex，未命中为空
+}
+
+// AllowStale 返回是否允许以过期数据应答（Stale-While-Revalidate）
+func (p TTLPolicy) AllowStale(lowPressure bool) bool {
+	if p.KeepExpiredFixed {
+		return p.KeepExpired
+	}
+	return p.KeepExpired || lowPressure
+}
+
+// Clamp 将 TTL 限制在 [MinTTL, MaxTTL] 范围内，两者冲突时 MaxTTL 优先
+func (p TTLPolicy) Clamp(ttl uint32) uint32 {
+	if p.MinTTL > 0 && ttl < p.MinTTL {
+		ttl = p.MinTTL
+	}
+	if p.MaxTTL > 0 && ttl > p.MaxTTL {
+		ttl = p.MaxTTL
+	}
+	return ttl
+}
+
+// ttlRule 编译后的按域名 TTL 规则
+type ttlRule struct {
+	suffix string         // 规范化后的域名后缀
+	re     *regexp.Regexp // 与 suffix 二选一
+	cfg    config.TTLRuleConfig
+}
+
+func (r *ttlRule) match(domain string) bool {
+	if r.re != nil {
+		return r.re.MatchString(domain)
+	}
+	return domain == r.suffix || strings.HasSuffix(domain, "."+r.suffix)
+}
+
+func (r *ttlRule) name() string {
+	if r.re != nil {
+		return r.cfg.Regex
+	}
+	return r.cfg.Domain
+}
+
+// compileTTLRules 校验并编译规则列表
+func compileTTLRules(rules []config.TTLRuleConfig) ([]ttlRule, error) {
+	compiled := make([]ttlRule, 0, len(rules))
+	for i, rc := range rules {
+		suffix := normalizePolicyDomain(rc.Domain)
+		switch {
+		case suffix == "" && rc.Regex == "":
+			return nil, fmt.Errorf("ttl rule %d: domain or regex is required", i)
+		case suffix != "" && rc.Regex != "":
+			return nil, fmt.Errorf("ttl rule %d: domain and regex are mutually exclusive", i)
+		}
+		if rc.MinTTLSeconds < 0 || rc.MaxTTLSeconds < 0 || rc.UserReturnTTL < 0 {
+			return nil, fmt.Errorf("ttl rule %d: ttl values cannot be negative", i)
+		}
+		if rc.MinTTLSeconds > 0 && rc.MaxTTLSeconds > 0 && rc.MinTTLSeconds > rc.MaxTTLSeconds {
+			return nil, fmt.Errorf("ttl rule %d: min_ttl_seconds (%d) cannot be greater than max_ttl_seconds (%d)", i, rc.MinTTLSeconds, rc.MaxTTLSeconds)
+		}
+		rule := ttlRule{suffix: suffix, cfg: rc}
+		if rc.Regex != "" {
+			re, err := regexp.Compile(rc.Regex)
+			if err != nil {
+				return nil, fmt.Errorf("ttl rule %d: invalid regex %q: %v", i, rc.Regex, err)
+			}
+			rule.re = re
+		}
+		compiled = append(compiled, rule)
+	}
+	return compiled, nil
+}
+
+// ValidateTTLRules 校验按域名 TTL 规则，供配置校验使用
+func ValidateTTLRules(rules []config.TTLRuleConfig) error {
+	_, err := compileTTLRules(rules)
+	return err
+}
+
+// SetTTLRules 替换按域名 TTL 规则，规则无效时保留原有规则并返回错误
+// 已在缓存中的条目保持写入时的有效 TTL，新规则从下一次写入开始生效
+func (c *Cache) SetTTLRules(rules []config.TTLRuleConfig) error {
+	compiled, err := compileTTLRules(rules)
+	if err != nil {
+		return err
+	}
+	c.ttlRules.Store(&compiled)
+	return nil
+}
+
+// TTLPolicyFor 返回域名生效的缓存策略，按规则书写顺序匹配，第一条命中的规则生效
+func (c *Cache) TTLPolicyFor(domain string) TTLPolicy {
+	policy := TTLPolicy{
+		MinTTL:        uint32(max(c.config.MinTTLSeconds, 0)),
+		MaxTTL:        uint32(max(c.config.MaxTTLSeconds, 0)),
+		UserReturnTTL: c.config.UserReturnTTL,
+		KeepExpired:   c.config.KeepExpiredEntries,
+		Prefetch:      true,
+	}
+	rule := c.matchTTLRule(domain)
+	if rule == nil {
+		return policy
+	}
+
+	rc := rule.cfg
+	policy.Rule = rule.name()
+	if rc.MinTTLSeconds > 0 {
+		policy.MinTTL = uint32(rc.MinTTLSeconds)
+		// 规则只放宽了下限时，同时抬高继承来的全局上限，否则下限不会生效
+		if rc.MaxTTLSeconds == 0 && policy.MaxTTL > 0 && policy.MaxTTL < policy.MinTTL {
+			policy.MaxTTL = policy.MinTTL
+		}
+	}
+	if rc.MaxTTLSeconds > 0 {
+		policy.MaxTTL = uint32(rc.MaxTTLSeconds)
+	}
+	if rc.UserReturnTTL > 0 {
+		policy.UserReturnTTL = rc.UserReturnTTL
+	}
+	if rc.KeepExpired != nil {
+		policy.KeepExpired = *rc.KeepExpired
+		policy.KeepExpiredFixed = true
+	}
+	if rc.Prefetch != nil {
+		policy.Prefetch = *rc.Prefetch
+	}
+	return policy
+}
+
+// ClampErrorTTL 按命中规则的 max_ttl_seconds 限制错误/否定缓存的 TTL
+// 不应用下限：长期缓存的静态域名出错时不应把错误也缓存一整天
+func (c *Cache) ClampErrorTTL(domain string, ttl int) int {
+	if rule := c.matchTTLRule(domain); rule != nil && rule.cfg.MaxTTLSeconds > 0 {
+		return min(ttl, rule.cfg.MaxTTLSeconds)
+	}
+	return ttl
+}
+
+// matchTTLRule 返回第一条命中的规则，未配置规则或未命中时返回 nil
+func (c *Cache) matchTTLRule(domain string) *ttlRule {
+	rules := c.ttlRules.Load()
+	if rules == nil || len(*rules) == 0 {
+		return nil
+	}
+	domain = normalizePolicyDomain(domain)
+	for i := range *rules {
+		if (*rules)[i].match(domain) {
+			return &(*rules)[i]
+		}
+	}
+	return nil
+}
+
+func normalizePolicyDomain(domain string) string {
+	return strings.TrimSuffix(strings.ToLower(strings.TrimSpace(domain)), ".")
+}
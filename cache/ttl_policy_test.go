@@ -0,0 +1,98 @@
+package cache
+
+import (
+	"testing"
+
+	"smartdnssort/config"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func boolPtr(b bool) *bool { return &b }
+
+func newTTLRuleCache(t *testing.T, rules ...config.TTLRuleConfig) *Cache {
+	t.Helper()
+	cfg := getDefaultCacheConfig()
+	cfg.MinTTLSeconds = 3600
+	cfg.MaxTTLSeconds = 7200
+	cfg.KeepExpiredEntries = true
+	c := NewCache(cfg)
+	require.NoError(t, c.SetTTLRules(rules))
+	return c
+}
+
+func TestTTLPolicyMatching(t *testing.T) {
+	c := newTTLRuleCache(t,
+		config.TTLRuleConfig{Domain: "dyn.example.net.", MaxTTLSeconds: 30, UserReturnTTL: 30, KeepExpired: boolPtr(false), Prefetch: boolPtr(false)},
+		config.TTLRuleConfig{Regex: `^[a-z0-9-]+\.corp\.internal$`, MinTTLSeconds: 86400},
+		config.TTLRuleConfig{Domain: "example.net", UserReturnTTL: 60},
+	)
+
+	// 第一条命中的规则生效，后缀匹配自身和子域名
+	p := c.TTLPolicyFor("Home.Dyn.Example.NET.")
+	assert.Equal(t, "dyn.example.net.", p.Rule)
+	assert.Equal(t, uint32(30), p.Clamp(600), "max wins over the inherited global min")
+	assert.Equal(t, 30, p.UserReturnTTL)
+	assert.False(t, p.AllowStale(true), "explicit keep_expired is not relaxed under low pressure")
+	assert.False(t, p.Prefetch)
+
+	// 只设置了下限的规则会抬高继承来的全局上限
+	p = c.TTLPolicyFor("wiki.corp.internal")
+	assert.Equal(t, uint32(86400), p.Clamp(60))
+	assert.Equal(t, uint32(86400), p.Clamp(90000))
+	assert.Equal(t, 600, p.UserReturnTTL)
+
+	p = c.TTLPolicyFor("www.example.net")
+	assert.Equal(t, "example.net", p.Rule)
+	assert.Equal(t, uint32(3600), p.Clamp(60))
+
+	// 未命中时使用全局配置
+	p = c.TTLPolicyFor("notexample.net")
+	assert.Empty(t, p.Rule)
+	assert.Equal(t, uint32(7200), p.Clamp(90000))
+	assert.True(t, p.Prefetch)
+	assert.True(t, p.AllowStale(false))
+}
+
+func TestTTLRulesApplyToCacheWrites(t *testing.T) {
+	c := newTTLRuleCache(t, config.TTLRuleConfig{Domain: "dyn.example.net", MaxTTLSeconds: 30})
+
+	c.SetRawRecordsWithDNSSEC("home.dyn.example.net", dns.TypeA, newTestARecords("home.dyn.example.net", "192.0.2.1"), nil, 300, false)
+	raw, ok := c.GetRaw("home.dyn.example.net", dns.TypeA)
+	require.True(t, ok)
+	assert.Equal(t, uint32(30), raw.EffectiveTTL)
+	assert.Equal(t, uint32(300), raw.UpstreamTTL)
+
+	c.SetRawRecordsWithDNSSEC("www.example.com", dns.TypeA, newTestARecords("www.example.com", "192.0.2.2"), nil, 300, false)
+	raw, ok = c.GetRaw("www.example.com", dns.TypeA)
+	require.True(t, ok)
+	assert.Equal(t, uint32(3600), raw.EffectiveTTL)
+
+	// 错误缓存只受规则上限约束
+	c.SetError("gone.dyn.example.net", dns.TypeA, dns.RcodeNameError, 300)
+	entry, ok := c.GetError("gone.dyn.example.net", dns.TypeA)
+	require.True(t, ok)
+	assert.Equal(t, 30, entry.TTL)
+	assert.Equal(t, 300, c.ClampErrorTTL("gone.example.com", 300))
+}
+
+func TestSetTTLRulesRejectsInvalidRules(t *testing.T) {
+	c := newTTLRuleCache(t, config.TTLRuleConfig{Domain: "dyn.example.net", MaxTTLSeconds: 30})
+
+	for _, rules := range [][]config.TTLRuleConfig{
+		{{MaxTTLSeconds: 30}},
+		{{Domain: "a.com", Regex: "a"}},
+		{{Regex: "("}},
+		{{Domain: "a.com", MinTTLSeconds: 60, MaxTTLSeconds: 30}},
+		{{Domain: "a.com", UserReturnTTL: -1}},
+	} {
+		assert.Error(t, ValidateTTLRules(rules))
+		assert.Error(t, c.SetTTLRules(rules))
+	}
+	assert.Equal(t, "dyn.example.net", c.TTLPolicyFor("dyn.example.net").Rule, "invalid rules keep the previous set")
+
+	require.NoError(t, c.SetTTLRules(nil))
+	assert.Empty(t, c.TTLPolicyFor("dyn.example.net").Rule)
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"smartdnssort/config"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// apiResponse 与 webapi.APIResponse 对应，Data 延迟解析
+type apiResponse struct {
+	Success bool            `json:"success"`
+	Message string          `json:"message"`
+	Data    json.RawMessage `json:"data"`
+}
+
+// cacheEntry 仅解析命令行展示需要的字段
+type cacheEntry struct {
+	Domain       string   `json:"domain"`
+	Qtype        string   `json:"qtype"`
+	Layers       []string `json:"layers"`
+	State        string   `json:"state"`
+	RemainingTTL int32    `json:"remaining_ttl"`
+	IPs          []string `json:"ips"`
+	Source       string   `json:"source"`
+	SortState    string   `json:"sort_state"`
+	SortedIPs    []string `json:"sorted_ips"`
+	RTTs         []int    `json:"rtts"`
+	ErrorRcode   string   `json:"error_rcode"`
+	BlockType    string   `json:"block_type"`
+}
+
+// runCacheCommand 处理 cache 子命令，通过运行中实例的 Web API 检视或清除缓存
+// 返回进程退出码
+func runCacheCommand(args []string, configPath string) int {
+	if len(args) == 0 {
+		printCacheHelp()
+		return 1
+	}
+
+	switch args[0] {
+	case "list":
+		return runCacheList(args[1:], configPath)
+	case "purge":
+		return runCachePurge(args[1:], configPath)
+	case "help", "-h":
+		printCacheHelp()
+		return 0
+	default:
+		fmt.Fprintf(os.Stderr, "错误：未知的 cache 子命令 '%s'，支持的命令：list, purge\n", args[0])
+		return 1
+	}
+}
+
+func runCacheList(args []string, configPath string) int {
+	fs := flag.NewFlagSet("cache list", flag.ContinueOnError)
+	api := fs.String("api", "", "Web API 地址（默认根据配置文件的 webui.listen_port 推断）")
+	search := fs.String("q", "", "按域名子串检索")
+	qtype := fs.String("type", "", "查询类型（A、AAAA、HTTPS...）")
+	limit := fs.Int("limit", 100, "最多显示的条目数")
+	asJSON := fs.Bool("json", false, "输出原始 JSON")
+	if err := fs.Parse(args); err != nil {
+		return 1
+	}
+
+	params := url.Values{}
+	if fs.NArg() > 0 {
+		params.Set("domain", fs.Arg(0))
+	}
+	if *search != "" {
+		params.Set("q", *search)
+	}
+	if *qtype != "" {
+		params.Set("type", *qtype)
+	}
+	params.Set("limit", strconv.Itoa(*limit))
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	base := cacheAPIBase(*api, configPath)
+	resp, err := doAPIRequest(client, http.MethodGet, base+"/api/cache/entries?"+params.Encode(), nil, "")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "错误：%v\n", err)
+		return 1
+	}
+
+	if *asJSON {
+		os.Stdout.Write(resp.Data)
+		fmt.Println()
+		return 0
+	}
+
+	var result struct {
+		Total   int          `json:"total"`
+		Entries []cacheEntry `json:"entries"`
+	}
+	if err := json.Unmarshal(resp.Data, &result); err != nil {
+		fmt.Fprintf(os.Stderr, "错误：无法解析响应：%v\n", err)
+		return 1
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "DOMAIN\tTYPE\tLAYERS\tSTATE\tTTL\tSORT\tSOURCE\tANSWER")
+	for _, e := range result.Entries {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%d\t%s\t%s\t%s\n",
+			e.Domain, dashIfEmpty(e.Qtype), strings.Join(e.Layers, ","), dashIfEmpty(e.State),
+			e.RemainingTTL, dashIfEmpty(e.SortState), dashIfEmpty(e.Source), describeCacheEntry(e))
+	}
+	tw.Flush()
+	fmt.Printf("\n显示 %d / %d 条\n", len(result.Entries), result.Total)
+	return 0
+}
+
+func runCachePurge(args []string, configPath string) int {
+	fs := flag.NewFlagSet("cache purge", flag.ContinueOnError)
+	api := fs.String("api", "", "Web API 地址（默认根据配置文件的 webui.listen_port 推断）")
+	qtype := fs.String("type", "", "仅清除指定查询类型")
+	if err := fs.Parse(args); err != nil {
+		return 1
+	}
+
+	domain := fs.Arg(0)
+	if domain == "" && *qtype == "" {
+		fmt.Fprintln(os.Stderr, "错误：需要指定域名（example.com 或 *.example.com）或 -type")
+		return 1
+	}
+
+	body, _ := json.Marshal(map[string]string{"domain": domain, "type": *qtype})
+	client := &http.Client{Timeout: 10 * time.Second}
+	base := cacheAPIBase(*api, configPath)
+
+	// 写操作需要 CSRF 令牌
+	tokenResp, err := doAPIRequest(client, http.MethodGet, base+"/api/csrf-token", nil, "")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "错误：获取 CSRF 令牌失败：%v\n", err)
+		return 1
+	}
+	var token struct {
+		CSRFToken string `json:"csrf_token"`
+	}
+	if err := json.Unmarshal(tokenResp.Data, &token); err != nil {
+		fmt.Fprintf(os.Stderr, "错误：无法解析 CSRF 令牌：%v\n", err)
+		return 1
+	}
+
+	resp, err := doAPIRequest(client, http.MethodPost, base+"/api/cache/purge", body, token.CSRFToken)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "错误：%v\n", err)
+		return 1
+	}
+	fmt.Println(resp.Message)
+	var result struct {
+		Layers map[string]int `json:"layers"`
+	}
+	if json.Unmarshal(resp.Data, &result) == nil {
+		for _, layer := range []string{"raw", "sorted", "sorting", "error", "dnssec", "blocked", "allowed"} {
+			if n := result.Layers[layer]; n > 0 {
+				fmt.Printf("  %-8s %d\n", layer, n)
+			}
+		}
+	}
+	return 0
+}
+
+// cacheAPIBase 确定 Web API 地址：命令行参数优先，其次读取配置文件中的端口
+// 配置文件不存在时不会创建默认配置，直接使用默认端口
+func cacheAPIBase(api, configPath string) string {
+	if api != "" {
+		if !strings.Contains(api, "://") {
+			api = "http://" + api
+		}
+		return strings.TrimRight(api, "/")
+	}
+	port := 8080
+	if _, err := os.Stat(configPath); err == nil {
+		if cfg, err := config.LoadConfig(configPath); err == nil && cfg.WebUI.ListenPort > 0 {
+			port = cfg.WebUI.ListenPort
+		}
+	}
+	return fmt.Sprintf("http://127.0.0.1:%d", port)
+}
+
+// doAPIRequest 发送请求并解析统一的 APIResponse，success=false 时返回错误
+func doAPIRequest(client *http.Client, method, target string, body []byte, csrfToken string) (*apiResponse, error) {
+	req, err := http.NewRequest(method, target, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if csrfToken != "" {
+		req.Header.Set("X-CSRF-Token", csrfToken)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("无法连接 Web API（服务是否已启动并开启 webui？）：%w", err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 32<<20))
+	if err != nil {
+		return nil, err
+	}
+	var apiResp apiResponse
+	if err := json.Unmarshal(data, &apiResp); err != nil {
+		return nil, fmt.Errorf("unexpected response (HTTP %d): %s", resp.StatusCode, strings.TrimSpace(string(data)))
+	}
+	if !apiResp.Success {
+		return nil, fmt.Errorf("%s (HTTP %d)", apiResp.Message, resp.StatusCode)
+	}
+	return &apiResp, nil
+}
+
+// describeCacheEntry 生成条目的简要应答描述
+func describeCacheEntry(e cacheEntry) string {
+	switch {
+	case e.BlockType != "":
+		return "blocked:" + e.BlockType
+	case e.ErrorRcode != "":
+		return "error:" + e.ErrorRcode
+	case len(e.SortedIPs) > 0:
+		parts := make([]string, len(e.SortedIPs))
+		for i, ip := range e.SortedIPs {
+			if i < len(e.RTTs) {
+				parts[i] = fmt.Sprintf("%s(%dms)", ip, e.RTTs[i])
+			} else {
+				parts[i] = ip
+			}
+		}
+		return strings.Join(parts, " ")
+	case len(e.IPs) > 0:
+		return strings.Join(e.IPs, " ")
+	}
+	return "-"
+}
+
+func dashIfEmpty(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+func printCacheHelp() {
+	fmt.Print(`缓存检视与定向清除（需要服务已运行并开启 Web 管理界面）
+
+使用方法：
+  SmartDNSSort [-c 配置文件] cache list  [-q 子串] [-type 类型] [-limit N] [-json] [域名|*.后缀]
+  SmartDNSSort [-c 配置文件] cache purge [-type 类型] [域名|*.后缀]
+
+通用选项：
+  -api <地址>     Web API 地址，例如 127.0.0.1:8080（默认读取配置文件端口）
+
+示例：
+  # 查看某个 CDN 下的所有缓存
+  SmartDNSSort cache list '*.cdn.example.com'
+
+  # 清除单个域名的所有类型缓存
+  SmartDNSSort cache purge www.example.com
+
+  # 仅清除子域名的 AAAA 缓存
+  SmartDNSSort cache purge -type AAAA '*.example.com'
+`)
+}
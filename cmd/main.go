@@ -98,6 +98,11 @@ func main() {
 		effectiveConfigPath = filepath.Join(effectiveWorkDir, effectiveConfigPath)
 	}
 
+	// cache 子命令：通过运行中实例的 Web API 检视或清除缓存
+	if args := flag.Args(); len(args) > 0 && args[0] == "cache" {
+		os.Exit(runCacheCommand(args[1:], effectiveConfigPath))
+	}
+
 	// 正常的 DNS 服务器启动流程
 	// 加载配置（先加载配置以获取日志级别设置）
 	cfg, err := config.LoadConfig(effectiveConfigPath)
@@ -226,6 +231,9 @@ func printHelp() {
   -v              详细输出
   -h              显示此帮助信息
 
+子命令：
+  cache list|purge  检视或定向清除运行中实例的缓存（cache help 查看详情）
+
 示例：
   # 启动 DNS 服务器
   SmartDNSSort -c /etc/SmartDNSSort/config.yaml
@@ -241,6 +249,9 @@ func printHelp() {
 
   # 卸载服务
   sudo SmartDNSSort -s uninstall
+
+  # 清除某个 CDN 的缓存
+  SmartDNSSort cache purge '*.cdn.example.com'
 `)
 }
 
@@ -11,6 +11,33 @@ dns:
   enable_tcp: true
   # 是否启用 IPv6 支持，默认 true
   enable_ipv6: true
+  # HTTPS/SVCB (type 65/64) 记录处理
+  # 浏览器会直接使用 HTTPS 记录中的 ipv4hint/ipv6hint 建立连接，从而绕过排序后的 A/AAAA 应答
+  svcb:
+    # 用排序缓存中同一目标名的 IP 顺序替换地址提示；目标名尚未完成排序时保留上游提示
+    rewrite_hints: true
+    # 按域名的策略（匹配域名自身及其子域名），第一条命中的规则生效
+    # strip_hints: 移除地址提示；strip_ech: 移除 ECH 配置
+    # 带 DNSSEC 签名的记录被修改后，对应的 RRSIG 一并移除
+    rules: []
+    #  - domain: "example.com"
+    #    strip_hints: true
+    #  - domain: "corp.example.net"
+    #    strip_ech: true
+  # 安全搜索：将搜索引擎域名以 CNAME 指向其安全搜索端点（如 forcesafesearch.google.com），
+  # 端点地址经由正常的缓存、上游与排序流程解析
+  safe_search:
+    enable: false
+    google: true
+    bing: true
+    duckduckgo: true
+    youtube: true
+    # YouTube 受限模式：strict（严格）或 moderate（适中）
+    youtube_mode: "strict"
+    # 生效的客户端 IP 或 CIDR，留空对所有客户端生效
+    clients: []
+    #  - "192.168.1.50"
+    #  - "192.168.2.0/24"
 
 # 上游 DNS 服务器配置
 upstream:
@@ -101,6 +128,66 @@ ping:
   max_test_ips: 0
   # 缓存 IP 的 RTT (延迟) 结果的时间（秒）
   rtt_cache_ttl_seconds: 300
+  # 离线 GeoIP/ASN 排序（需自行下载 MaxMind GeoLite2 MMDB 数据库）
+  # 作为 RTT 的次级排序键；断网或 Ping 关闭等无 RTT 数据时作为主排序键
+  geoip:
+    enabled: false
+    # 国家数据库路径，如 ./GeoLite2-Country.mmdb
+    country_db: ""
+    # ASN 数据库路径，如 ./GeoLite2-ASN.mmdb（可与 country_db 为同一个合并库）
+    asn_db: ""
+    # 偏好 ASN 列表，按优先级排列（如自家运营商：4134 电信、4837 联通、9808 移动）
+    preferred_asns: []
+    # 偏好国家/地区代码列表，按优先级排列，优先级低于 preferred_asns
+    preferred_countries: []
+    # RTT 分桶宽度（毫秒），同一桶内的 IP 视为延迟相同，按 GeoIP 偏好排序。0 表示仅在 RTT 完全相同时生效
+    rtt_bucket_ms: 10
+  # 全局探测预算：PingAndSort、IP 巡检和 TCP 回退共享，用户触发的排序优先于后台刷新
+  probe_budget:
+    # 每秒最大发包数，0 表示不限制
+    packets_per_second: 0
+    # 每小时最大探测字节数，0 表示不限制（后台巡检最多使用 90%，剩余留给用户排序）
+    bytes_per_hour: 0
+    # 预算不足时排队的最长等待时间（毫秒），超时则推迟本次探测
+    max_wait_ms: 2000
+  # 手动 IP 排序规则文件，每行：域名 动作 IP/CIDR...（可通过 Web 界面 /api/sort-rules 管理）
+  # 动作：pin 固定在最前、prefer 优先、demote 降级到末尾、exclude 排除；*.example.com 匹配所有子域名
+  sort_rules_file: "./sort_rules.txt"
+  # 外部探测器：把测速交给 HTTP 批量端点或本地脚本，结果同样写入 RTT 缓存和 IP 池
+  # 协议：请求 {"ips": [...], "domain": "...", "count": 3, "timeout_ms": 1000}
+  #       响应 {"results": [{"ip": "1.1.1.1", "rtt": 12, "loss": 0, "method": "icmp"}]}，rtt < 0 表示不可达
+  # 外部探测失败或未返回的 IP 会回退到本地探测
+  prober:
+    enabled: false
+    # 类型：http（POST 到 url）或 exec（JSON 经标准输入/输出交换）
+    type: "http"
+    url: ""
+    # 可选，以 Authorization: Bearer 发送
+    auth_token: ""
+    command: ""
+    args: []
+    # 单批次超时（毫秒）
+    timeout_ms: 5000
+    # 单批次最大 IP 数，0 表示一次发送全部
+    batch_size: 0
+  # 带宽探测：对软件镜像、视频 CDN 等大文件域名按下载吞吐量排序，而非仅看延迟
+  # 对每个候选 IP 携带正确的 Host/SNI 下载前 max_kb KB，结果缓存在 IP 池中
+  bandwidth:
+    enabled: false
+    # 需要按吞吐量排序的域名，path 为测速路径（建议指向一个较大的静态文件），scheme 默认 https
+    domains: []
+    #  - domain: "*.mirrors.example.com"
+    #    path: "/ubuntu/ls-lR.gz"
+    # 单次测速最多下载的数据量（KB）
+    max_kb: 512
+    # 单次测速超时（毫秒）
+    timeout_ms: 10000
+    # 同时进行的测速数
+    concurrency: 2
+    # 每小时最多下载的字节数，0 表示不限制
+    bytes_per_hour: 0
+    # 测速结果有效期（秒）
+    cache_ttl_seconds: 21600
 
 
 
@@ -132,6 +219,10 @@ webui:
 # 广告拦截配置
 adblock:
   enable: true
+  # 过滤引擎：urlfilter（支持 AdGuard 语法，含 $dnsrewrite、$dnstype、$client、$important、$badfilter）| simple | compact
+  # compact 面向 OISD、HaGeZi 等百万级列表：纯域名、hosts 与 ||domain^ 规则编译为 cache_dir/rules.compact 并以 mmap 加载，
+  # 规则源未变化时启动直接复用编译文件；正则、通配符与带修饰符的规则仍按 urlfilter 语法处理
+  # $dnsrewrite 改写的应答使用 blocked_ttl 作为 TTL
   engine: urlfilter
   rule_urls:
     - https://adguardteam.github.io/HostlistsRegistry/assets/filter_1.txt
@@ -142,6 +233,58 @@ adblock:
   max_cache_size_mb: 10
   block_mode: zero_ip
   blocked_ttl: 3600
+  # 响应策略区域 (RPZ)，按书写顺序决定优先级，先于上面的规则列表生效
+  # 支持 QNAME 与 RPZ-IP（应答地址）触发器，动作：NXDOMAIN、NODATA、PASSTHRU、DROP、TCP-only 及本地数据
+  rpz: []
+  #  - name: "rpz.security.example"
+  #    primary: "10.0.0.53:53"          # 通过 IXFR/AXFR 从主服务器同步
+  #    tsig_key_name: "rpz-key"
+  #    tsig_secret: "base64-secret"
+  #  - name: "local.rpz"
+  #    file: "./adblock_cache/local.rpz"  # 本地区域文件，修改后自动重新加载
+  # 应答地址 CIDR 拦截列表：上游解析结果中任一地址落在列表网段内时按 block_mode 拦截
+  # 支持本地文件与 http(s) 地址，远程列表随规则一起按 update_interval_hours 更新
+  ip_blocklists: []
+  #  - "https://www.spamhaus.org/drop/drop.txt"
+  #  - "./adblock_cache/bad_ranges.txt"
+  # 一键拦截的服务（如 tiktok、steam），完整列表见 /api/adblock/services，也可按分类（social、video、gaming 等）开关
+  blocked_services: []
+  # 服务目录更新地址（JSON），为空时使用内置目录；随规则一起更新
+  services_url: ""
+  # 定时过滤：规则集只在每周的时间窗口内生效（end 不晚于 start 时跨越午夜），时区为空时使用本机时区
+  # 临时暂停全部过滤请使用 /api/adblock/pause，到期自动恢复
+  timezone: ""
+  schedules: []
+  #  - name: "bedtime-social"
+  #    services: ["tiktok", "instagram"]
+  #    rules: ["||weibo.com^"]
+  #    windows:
+  #      - days: ["mon", "tue", "wed", "thu", "fri"]
+  #        start: "22:00"
+  #        end: "07:00"
+  # 拦截页：启用后 A/AAAA 拦截应答返回下面的本机地址，浏览器访问被拦截域名时显示域名、命中规则和"申请解除拦截"按钮
+  # 其他查询类型仍按 block_mode 应答。解除申请可在 /api/adblock/unblock-requests 查看
+  # HTTPS 仅在配置本地 CA（ca_cert/ca_key）时显示拦截页，否则立即重置连接；客户端须信任该 CA
+  block_page:
+    enable: false
+    ipv4: ""                   # 如 192.168.1.2
+    ipv6: ""
+    http_listen: ":80"
+    https_listen: ":443"
+    ca_cert: ""
+    ca_key: ""
+  # 算法生成域名（DGA）启发式检测：对未被规则命中的域名，按可注册标签的字符熵、bigram 似然、数字占比、长度
+  # 以及该客户端近期的 NXDOMAIN 突发率评分（0-1），达到 threshold 的域名记录在 /api/adblock/dga
+  # mode: block 拦截；alert 只告警照常解析；learn 用成功解析的域名训练本地模型（保存在 cache_dir），只记录不告警
+  # 误报的域名可加入 allowlist，或用 @@ 白名单规则放行
+  dga:
+    enable: false
+    mode: alert
+    threshold: 0.65
+    min_length: 8              # 可注册标签短于此长度时不评分
+    burst_window_seconds: 60
+    burst_threshold: 20        # 窗口内 NXDOMAIN 数达到此值时突发分量记满
+    allowlist: []
 
 # 系统资源配置
 system:
@@ -195,9 +338,24 @@ cache:
   negative_ttl_seconds: 300
   # 错误响应缓存（SERVFAIL/REFUSED等）的 TTL（秒），默认值 30
   error_cache_ttl_seconds: 30
+  # 按域名的 TTL 策略，按书写顺序匹配，第一条命中的规则生效；未填写的字段沿用上面的全局值
+  # - domain：域名后缀，匹配自身及所有子域名；regex：正则表达式，两者二选一
+  # - min_ttl_seconds / max_ttl_seconds：本地缓存时长的上下限，冲突时以 max 为准；max 同时限制错误与否定缓存
+  # - user_return_ttl：返回给客户端的 TTL
+  # - keep_expired：是否允许以过期数据应答，设置后不再受内存压力自动放宽的影响
+  # - prefetch：是否参与预取
+  ttl_rules: []
+  #  - domain: "dyn.example.net"
+  #    max_ttl_seconds: 30
+  #    user_return_ttl: 30
+  #    keep_expired: false
+  #    prefetch: false
+  #  - regex: '^[a-z0-9-]+\.corp\.internal$'
+  #    min_ttl_seconds: 86400
 
   # 内存缓存管理 (高级)
-  # 最大内存使用量 (MB)。超过此限制将触发LRU淘汰。0表示不限制。
+  # 最大内存使用量 (MB)。原始、排序、错误三层缓存按条目实际内容（IP 数量、CNAME 链、记录）记账，
+  # 合计超过此限制时按 LRU 淘汰，保证不超出。0表示不限制。
   max_memory_mb: 32
   # 是否保留已过期的缓存条目。当内存充足时，可设为 true 以加速后续查询。
   # 内存敏感环境建议关闭（设置为 false）
@@ -210,8 +368,81 @@ cache:
   # 在LRU淘汰期间，是否保护预取列表中的域名不被清除。
   protect_prefetch_domains: true
   # 缓存持久化落盘间隔（分钟），默认 60 分钟
+  # 每次落盘写入完整快照 (dns_cache.bin)，两次快照之间的变更追加到预写日志 (dns_cache.bin.wal)
   save_to_disk_interval_minutes: 60
+  # 预写日志刷盘间隔（毫秒），进程崩溃或断电时最多丢失这段时间内的变更
+  wal_sync_interval_ms: 1000
+  # 预写日志超过该大小 (MB) 时在后台提前生成快照并清空日志
+  wal_compact_mb: 16
+  # 启动时加载快照和回放日志的时间上限（秒），超时后以已加载的部分启动
+  load_timeout_seconds: 5
   # DNSSEC 消息缓存容量 (MB)，用于存储完整的 DNS 响应消息（包含 RRSIG 等）
-  # 独立于主缓存，默认为主缓存的 1/10（即 32MB 主缓存对应 3.2MB 消息缓存）
+  # 计入 max_memory_mb 共享预算，并在预算内不超过该上限；按消息实际大小记账，超出后按 LRU 淘汰；默认为主缓存的 1/10（即 32MB 主缓存对应 3.2MB 消息缓存）
   msg_cache_size_mb: 3
+  # 积极否定缓存 (RFC 8198)，需要同时启用 upstream.dnssec 且上游为验证型解析器（返回 AD 位）
+  # 缓存上游返回的 NSEC/NSEC3 否定证明，证明范围内的任意名称直接在本地返回 NXDOMAIN/NODATA，
+  # 大量随机子域名查询（恶意软件、DGA 等）不再逐个打到上游
+  aggressive_nsec: true
+  # 否定证明记录的最大缓存条数
+  nsec_cache_max_entries: 10000
+
+  # 外部缓存后端 (L2)，修改后需重启生效
+  # 多个实例指向同一个 Redis 即可共享缓存层，本地内存缓存作为 L1 挡在前面：
+  # 写入时同时异步写入后端，本地未命中时再读取后端
+  backend:
+    # memory: 仅使用本地内存（默认）；redis: 使用 Redis 协议兼容的服务（Redis/KeyDB/Valkey 等）
+    type: memory
+    # Redis 地址
+    addr: "127.0.0.1:6379"
+    # AUTH 密码，为空表示不认证
+    password: ""
+    # 数据库编号
+    db: 0
+    # 键前缀
+    key_prefix: "smartdnssort:"
+    # 连接池大小，默认 16
+    pool_size: 16
+    # 单次读写超时（毫秒），默认 100；读取超时按未命中处理，不影响查询
+    timeout_ms: 100
+    # 条目过期后在后端继续保留的秒数（用于陈旧应答），由后端按过期时间自动删除，默认 3600
+    stale_seconds: 3600
+
+# 多实例缓存复制配置
+# 启用后，本节点的原始缓存写入、排序结果和 IP 测速数据会推送到 peers 中的节点，
+# 对等节点之间最终一致，冲突时以获取时间较新的数据为准；
+# 每次与对等节点建立连接时都会先发送一份全量快照
+replication:
+  # 是否启用缓存复制，默认 false
+  enabled: false
+  # 本节点标识，为空时使用主机名，各节点必须不同
+  node_id: ""
+  # 接收对等节点数据的 TCP 监听地址，默认 :8053
+  listen_addr: ":8053"
+  # 对等节点地址列表，例如 ["10.0.0.2:8053", "10.0.0.3:8053"]
+  peers: []
+  # 共享密钥，所有节点必须一致；启用复制时必填
+  shared_key: ""
+  # 每个对等节点的发送队列长度，默认 10000
+  queue_size: 10000
+  # 单个数据帧最多携带的更新数，默认 256
+  batch_size: 256
+  # 批量发送间隔（毫秒），默认 200
+  flush_interval_ms: 200
+
+# 启动缓存预热配置
+# 服务重启后按热门程度重新解析并排序常用域名，避免重启后的首次查询都要等待上游和测速；
+# 预热来源为上次运行保存的热门域名历史（预取评分和热门域名统计）以及可选的域名列表文件
+warmup:
+  # 是否启用启动预热，默认 false
+  enabled: false
+  # 热门域名历史文件，随缓存定期保存并在关闭时写入
+  history_file: "warmup_history.json"
+  # 域名列表文件，每行一个域名，# 开头为注释；为空则不使用
+  domain_list_file: ""
+  # 单次预热的最大域名数，默认 500
+  max_domains: 500
+  # 每秒最多发起的预热解析数，默认 20
+  rate_per_second: 20
+  # 并发预热的工作协程数，默认 4
+  concurrency: 4
 `
@@ -33,6 +33,12 @@ func setDefaultValues(cfg *Config, rawData []byte) {
 	if cfg.DNS.ListenPort == 0 {
 		cfg.DNS.ListenPort = 53
 	}
+	// 旧配置文件没有 dns.svcb 段时默认开启地址提示改写
+	if !cfg.DNS.SVCB.RewriteHints {
+		if !isFieldExplicitlySet(rawData, "dns", "svcb") {
+			cfg.DNS.SVCB.RewriteHints = true
+		}
+	}
 
 	// Upstream 配置默认值
 	setUpstreamDefaults(&cfg.Upstream)
@@ -67,6 +73,12 @@ func setDefaultValues(cfg *Config, rawData []byte) {
 
 	// IPMonitor 配置默认值
 	setIPMonitorDefaults(cfg, rawData)
+
+	// Replication 配置默认值
+	setReplicationDefaults(&cfg.Replication)
+
+	// Warmup 配置默认值
+	setWarmupDefaults(&cfg.Warmup)
 }
 
 // setUpstreamDefaults 设置上游配置的默认值
@@ -148,6 +160,28 @@ func setPingDefaults(cfg *Config, rawData []byte) {
 		cfg.Ping.Concurrency = 16
 	}
 	// MaxTestIPs: 0 means unlimited, so we don't need to set a default if it's 0.
+	// 探测预算：速率与字节数 0 表示不限制，只为排队等待时间设置默认值
+	if cfg.Ping.ProbeBudget.MaxWaitMs == 0 {
+		cfg.Ping.ProbeBudget.MaxWaitMs = 2000
+	}
+	if cfg.Ping.SortRulesFile == "" {
+		cfg.Ping.SortRulesFile = "./sort_rules.txt"
+	}
+	if cfg.Ping.Bandwidth.MaxKB == 0 {
+		cfg.Ping.Bandwidth.MaxKB = 512
+	}
+	if cfg.Ping.Bandwidth.TimeoutMs == 0 {
+		cfg.Ping.Bandwidth.TimeoutMs = 10000
+	}
+	if cfg.Ping.Bandwidth.Concurrency == 0 {
+		cfg.Ping.Bandwidth.Concurrency = 2
+	}
+	if cfg.Ping.Bandwidth.CacheTTLSeconds == 0 {
+		cfg.Ping.Bandwidth.CacheTTLSeconds = 21600
+	}
+	if cfg.Ping.Prober.TimeoutMs == 0 {
+		cfg.Ping.Prober.TimeoutMs = 5000
+	}
 	if cfg.Ping.RttCacheTtlSeconds == 0 {
 		cfg.Ping.RttCacheTtlSeconds = 600 // 与 DefaultConfigContent 保持一致
 	}
@@ -211,6 +245,19 @@ func setCacheDefaults(cfg *Config) {
 	if cfg.Cache.SaveToDiskIntervalMinutes == 0 {
 		cfg.Cache.SaveToDiskIntervalMinutes = 60
 	}
+	if cfg.Cache.NSECCacheMaxEntries == 0 {
+		cfg.Cache.NSECCacheMaxEntries = 10000
+	}
+	if cfg.Cache.WALSyncIntervalMs == 0 {
+		cfg.Cache.WALSyncIntervalMs = 1000
+	}
+	if cfg.Cache.WALCompactMB == 0 {
+		cfg.Cache.WALCompactMB = 16
+	}
+	if cfg.Cache.LoadTimeoutSeconds == 0 {
+		cfg.Cache.LoadTimeoutSeconds = 5
+	}
+	setCacheBackendDefaults(&cfg.Cache.Backend)
 }
 
 // setAdBlockDefaults 设置广告拦截配置的默认值
@@ -242,6 +289,18 @@ func setAdBlockDefaults(cfg *Config) {
 	if cfg.AdBlock.BlockedTTL == 0 {
 		cfg.AdBlock.BlockedTTL = 3600
 	}
+	if cfg.AdBlock.BlockPage.HTTPListen == "" {
+		cfg.AdBlock.BlockPage.HTTPListen = ":80"
+	}
+	if cfg.AdBlock.BlockPage.HTTPSListen == "" {
+		cfg.AdBlock.BlockPage.HTTPSListen = ":443"
+	}
+	if cfg.AdBlock.DGA.Mode == "" {
+		cfg.AdBlock.DGA.Mode = "alert"
+	}
+	if cfg.AdBlock.DGA.Threshold == 0 {
+		cfg.AdBlock.DGA.Threshold = 0.65
+	}
 }
 
 // setSystemDefaults 设置系统配置的默认值
@@ -362,3 +421,54 @@ func setIPMonitorDefaults(cfg *Config, rawData []byte) {
 		}
 	}
 }
+
+// setCacheBackendDefaults 设置外部缓存后端的默认值
+func setCacheBackendDefaults(cfg *CacheBackendConfig) {
+	if cfg.Type == "" {
+		cfg.Type = "memory"
+	}
+	if cfg.KeyPrefix == "" {
+		cfg.KeyPrefix = "smartdnssort:"
+	}
+	if cfg.PoolSize == 0 {
+		cfg.PoolSize = 16
+	}
+	if cfg.TimeoutMs == 0 {
+		cfg.TimeoutMs = 100
+	}
+	if cfg.StaleSeconds == 0 {
+		cfg.StaleSeconds = 3600
+	}
+}
+
+// setReplicationDefaults 设置缓存复制配置的默认值
+func setReplicationDefaults(cfg *ReplicationConfig) {
+	if cfg.ListenAddr == "" {
+		cfg.ListenAddr = ":8053"
+	}
+	if cfg.QueueSize == 0 {
+		cfg.QueueSize = 10000
+	}
+	if cfg.BatchSize == 0 {
+		cfg.BatchSize = 256
+	}
+	if cfg.FlushIntervalMs == 0 {
+		cfg.FlushIntervalMs = 200
+	}
+}
+
+// setWarmupDefaults 设置缓存预热配置的默认值
+func setWarmupDefaults(cfg *WarmupConfig) {
+	if cfg.HistoryFile == "" {
+		cfg.HistoryFile = "warmup_history.json"
+	}
+	if cfg.MaxDomains == 0 {
+		cfg.MaxDomains = 500
+	}
+	if cfg.RatePerSecond == 0 {
+		cfg.RatePerSecond = 20
+	}
+	if cfg.Concurrency == 0 {
+		cfg.Concurrency = 4
+	}
+}
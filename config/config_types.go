@@ -2,16 +2,18 @@ package config
 
 // Config 主配置结构
 type Config struct {
-	DNS       DNSConfig      `yaml:"dns" json:"dns"`
-	Upstream  UpstreamConfig `yaml:"upstream" json:"upstream"`
-	Ping      PingConfig     `yaml:"ping" json:"ping"`
-	Cache     CacheConfig    `yaml:"cache" json:"cache"`
-	Prefetch  PrefetchConfig `yaml:"prefetch" json:"prefetch"`
-	WebUI     WebUIConfig    `yaml:"webui" json:"webui"`
-	AdBlock   AdBlockConfig  `yaml:"adblock" json:"adblock"`
-	System    SystemConfig   `yaml:"system" json:"system"`
-	Stats     StatsConfig    `yaml:"stats" json:"stats"`
-	IPMonitor IPPoolConfig   `yaml:"ip_monitor" json:"ip_monitor"`
+	DNS         DNSConfig         `yaml:"dns" json:"dns"`
+	Upstream    UpstreamConfig    `yaml:"upstream" json:"upstream"`
+	Ping        PingConfig        `yaml:"ping" json:"ping"`
+	Cache       CacheConfig       `yaml:"cache" json:"cache"`
+	Prefetch    PrefetchConfig    `yaml:"prefetch" json:"prefetch"`
+	WebUI       WebUIConfig       `yaml:"webui" json:"webui"`
+	AdBlock     AdBlockConfig     `yaml:"adblock" json:"adblock"`
+	System      SystemConfig      `yaml:"system" json:"system"`
+	Stats       StatsConfig       `yaml:"stats" json:"stats"`
+	IPMonitor   IPPoolConfig      `yaml:"ip_monitor" json:"ip_monitor"`
+	Replication ReplicationConfig `yaml:"replication" json:"replication"`
+	Warmup      WarmupConfig      `yaml:"warmup" json:"warmup"`
 }
 
 // DNSConfig DNS 服务器配置
@@ -19,6 +21,38 @@ type DNSConfig struct {
 	ListenPort int  `yaml:"listen_port,omitempty" json:"listen_port"`
 	EnableTCP  bool `yaml:"enable_tcp" json:"enable_tcp"`
 	EnableIPv6 bool `yaml:"enable_ipv6" json:"enable_ipv6"`
+
+	// HTTPS/SVCB 记录中地址提示与 ECH 的处理策略
+	SVCB SVCBConfig `yaml:"svcb" json:"svcb"`
+
+	// 强制搜索引擎安全搜索/受限模式
+	SafeSearch SafeSearchConfig `yaml:"safe_search" json:"safe_search"`
+}
+
+// SafeSearchConfig 安全搜索配置：搜索引擎域名以 CNAME 指向其安全搜索端点
+type SafeSearchConfig struct {
+	Enable      bool     `yaml:"enable" json:"enable"`
+	Google      bool     `yaml:"google" json:"google"`
+	Bing        bool     `yaml:"bing" json:"bing"`
+	DuckDuckGo  bool     `yaml:"duckduckgo" json:"duckduckgo"`
+	YouTube     bool     `yaml:"youtube" json:"youtube"`
+	YouTubeMode string   `yaml:"youtube_mode,omitempty" json:"youtube_mode"` // strict（默认）或 moderate
+	Clients     []string `yaml:"clients,omitempty" json:"clients"`           // 生效的客户端 IP/CIDR，为空时对所有客户端生效
+}
+
+// SVCBConfig HTTPS/SVCB 应答改写配置
+type SVCBConfig struct {
+	// 用排序缓存中同一目标名的 A/AAAA 顺序改写 ipv4hint/ipv6hint，使直接使用提示连接的客户端同样命中最优 IP
+	RewriteHints bool `yaml:"rewrite_hints" json:"rewrite_hints"`
+	// 按域名的策略，按书写顺序匹配，第一条命中的规则生效
+	Rules []SVCBRuleConfig `yaml:"rules,omitempty" json:"rules"`
+}
+
+// SVCBRuleConfig 单条按域名生效的 HTTPS/SVCB 策略
+type SVCBRuleConfig struct {
+	Domain     string `yaml:"domain" json:"domain"`                     // 域名后缀，匹配自身及所有子域名
+	StripHints bool   `yaml:"strip_hints,omitempty" json:"strip_hints"` // 移除 ipv4hint/ipv6hint，迫使客户端走 A/AAAA 查询
+	StripECH   bool   `yaml:"strip_ech,omitempty" json:"strip_ech"`     // 移除 ech 配置，客户端将回退到明文 SNI
 }
 
 // UpstreamConfig 上游 DNS 服务器配置
@@ -89,6 +123,70 @@ type PingConfig struct {
 	MaxTestIPs         int    `yaml:"max_test_ips,omitempty" json:"max_test_ips"`
 	RttCacheTtlSeconds int    `yaml:"rtt_cache_ttl_seconds,omitempty" json:"rtt_cache_ttl_seconds"`
 	EnableHttpFallback bool   `yaml:"enable_http_fallback,omitempty" json:"enable_http_fallback"`
+
+	// 离线 GeoIP/ASN 排序（可选）
+	GeoIP GeoIPConfig `yaml:"geoip,omitempty" json:"geoip"`
+
+	// 全局探测预算（PingAndSort、IP 巡检、TCP 回退共享）
+	ProbeBudget ProbeBudgetConfig `yaml:"probe_budget,omitempty" json:"probe_budget"`
+
+	// 手动 IP 排序规则文件（按域名固定/偏好/降级/排除 IP 或网段）
+	SortRulesFile string `yaml:"sort_rules_file,omitempty" json:"sort_rules_file"`
+
+	// 外部探测器（可选），替代本地 ICMP/TCP 探测
+	Prober ProberConfig `yaml:"prober,omitempty" json:"prober"`
+
+	// 带宽探测（可选），按下载吞吐量为大文件域名排序
+	Bandwidth BandwidthConfig `yaml:"bandwidth,omitempty" json:"bandwidth"`
+}
+
+// BandwidthConfig 吞吐量排序配置，仅对 Domains 中列出的域名生效
+type BandwidthConfig struct {
+	Enabled         bool                    `yaml:"enabled" json:"enabled"`
+	Domains         []BandwidthDomainConfig `yaml:"domains,omitempty" json:"domains"`
+	MaxKB           int                     `yaml:"max_kb,omitempty" json:"max_kb"`                       // 单次测速最多下载 KB
+	TimeoutMs       int                     `yaml:"timeout_ms,omitempty" json:"timeout_ms"`               // 单次测速超时
+	Concurrency     int                     `yaml:"concurrency,omitempty" json:"concurrency"`             // 同时进行的测速数
+	BytesPerHour    int64                   `yaml:"bytes_per_hour,omitempty" json:"bytes_per_hour"`       // 每小时下载字节上限，0 表示不限制
+	CacheTTLSeconds int                     `yaml:"cache_ttl_seconds,omitempty" json:"cache_ttl_seconds"` // 测速结果有效期
+}
+
+// BandwidthDomainConfig 单个域名的测速目标
+type BandwidthDomainConfig struct {
+	Domain string `yaml:"domain" json:"domain"`                   // 支持 *.example.com
+	Path   string `yaml:"path,omitempty" json:"path"`             // 测速路径，默认 "/"
+	Scheme string `yaml:"scheme,omitempty" json:"scheme"`         // https（默认）或 http
+}
+
+// ProberConfig 外部探测器配置
+// http: 向批量端点 POST {"ips": [...]}；exec: 通过标准输入/输出交换同样的 JSON
+type ProberConfig struct {
+	Enabled   bool     `yaml:"enabled" json:"enabled"`
+	Type      string   `yaml:"type,omitempty" json:"type"`             // http 或 exec
+	URL       string   `yaml:"url,omitempty" json:"url"`               // http 批量探测端点
+	AuthToken string   `yaml:"auth_token,omitempty" json:"auth_token"` // 可选，作为 Bearer Token 发送
+	Command   string   `yaml:"command,omitempty" json:"command"`       // exec 可执行文件
+	Args      []string `yaml:"args,omitempty" json:"args"`             // exec 命令参数
+	TimeoutMs int      `yaml:"timeout_ms,omitempty" json:"timeout_ms"` // 单批次超时
+	BatchSize int      `yaml:"batch_size,omitempty" json:"batch_size"` // 单批次最大 IP 数，0 表示不拆分
+}
+
+// ProbeBudgetConfig 全局探测预算配置，0 表示不限制
+type ProbeBudgetConfig struct {
+	PacketsPerSecond int   `yaml:"packets_per_second,omitempty" json:"packets_per_second"` // 每秒最大发包数
+	BytesPerHour     int64 `yaml:"bytes_per_hour,omitempty" json:"bytes_per_hour"`         // 每小时最大探测字节数
+	MaxWaitMs        int   `yaml:"max_wait_ms,omitempty" json:"max_wait_ms"`               // 排队最长等待时间（毫秒），超时即推迟探测
+}
+
+// GeoIPConfig 基于本地 MaxMind MMDB 数据库的离线排序配置
+// 作为 RTT 排序的次级键；没有任何 RTT 数据时作为主排序键
+type GeoIPConfig struct {
+	Enabled            bool     `yaml:"enabled" json:"enabled"`
+	CountryDB          string   `yaml:"country_db,omitempty" json:"country_db"`                   // 如 GeoLite2-Country.mmdb
+	ASNDB              string   `yaml:"asn_db,omitempty" json:"asn_db"`                           // 如 GeoLite2-ASN.mmdb
+	PreferredASNs      []uint32 `yaml:"preferred_asns,omitempty" json:"preferred_asns"`           // 按优先级排列，如自家运营商 ASN
+	PreferredCountries []string `yaml:"preferred_countries,omitempty" json:"preferred_countries"` // ISO 国家代码，按优先级排列
+	RTTBucketMs        int      `yaml:"rtt_bucket_ms,omitempty" json:"rtt_bucket_ms"`             // RTT 分桶宽度，同桶内按 GeoIP 偏好排序
 }
 
 // CacheConfig DNS 缓存配置
@@ -100,6 +198,9 @@ type CacheConfig struct {
 	NegativeTTLSeconds int `yaml:"negative_ttl_seconds,omitempty" json:"negative_ttl_seconds"`       // 否定缓存(NXDOMAIN/NODATA)的TTL
 	ErrorCacheTTL      int `yaml:"error_cache_ttl_seconds,omitempty" json:"error_cache_ttl_seconds"` // 错误响应缓存的TTL
 
+	// 按域名的 TTL 策略，按书写顺序匹配，第一条命中的规则生效
+	TTLRules []TTLRuleConfig `yaml:"ttl_rules,omitempty" json:"ttl_rules"`
+
 	// 内存缓存管理 (高级)
 	MaxMemoryMB               int     `yaml:"max_memory_mb,omitempty" json:"max_memory_mb"`
 	KeepExpiredEntries        bool    `yaml:"keep_expired_entries" json:"keep_expired_entries"`
@@ -108,10 +209,45 @@ type CacheConfig struct {
 	ProtectPrefetchDomains    bool    `yaml:"protect_prefetch_domains" json:"protect_prefetch_domains"`
 	SaveToDiskIntervalMinutes int     `yaml:"save_to_disk_interval_minutes" json:"save_to_disk_interval_minutes"`
 
-	// DNSSEC 消息缓存容量
+	// 增量持久化：快照之间的变更写入预写日志 (WAL)
+	WALSyncIntervalMs  int `yaml:"wal_sync_interval_ms,omitempty" json:"wal_sync_interval_ms"`   // 日志刷盘间隔，崩溃时最多丢失这段时间内的变更
+	WALCompactMB       int `yaml:"wal_compact_mb,omitempty" json:"wal_compact_mb"`               // 日志超过该大小时提前压缩为快照
+	LoadTimeoutSeconds int `yaml:"load_timeout_seconds,omitempty" json:"load_timeout_seconds"`   // 启动加载时间上限，超时后以已加载的部分启动
+
+	// DNSSEC 消息缓存容量，计入 max_memory_mb 共享预算
 	MsgCacheSizeMB int `yaml:"msg_cache_size_mb,omitempty" json:"msg_cache_size_mb"`
 	// DNSSEC 消息缓存 TTL（秒），用于限制 RRSIG 等记录的缓存时间
 	DNSSECMsgCacheTTLSeconds int `yaml:"dnssec_msg_cache_ttl_seconds,omitempty" json:"dnssec_msg_cache_ttl_seconds"`
+
+	// 积极否定缓存（RFC 8198）：需要启用 upstream.dnssec，用已验证的 NSEC/NSEC3 证明在本地合成 NXDOMAIN/NODATA
+	AggressiveNSEC      bool `yaml:"aggressive_nsec" json:"aggressive_nsec"`
+	NSECCacheMaxEntries int  `yaml:"nsec_cache_max_entries,omitempty" json:"nsec_cache_max_entries"`
+
+	// 外部缓存后端（L2），内存分片缓存作为 L1
+	Backend CacheBackendConfig `yaml:"backend" json:"backend"`
+}
+
+// TTLRuleConfig 单条按域名生效的缓存策略，未设置的字段沿用全局配置
+type TTLRuleConfig struct {
+	Domain        string `yaml:"domain,omitempty" json:"domain"`                   // 域名后缀，匹配自身及所有子域名
+	Regex         string `yaml:"regex,omitempty" json:"regex"`                     // 正则表达式，与 domain 二选一
+	MinTTLSeconds int    `yaml:"min_ttl_seconds,omitempty" json:"min_ttl_seconds"`
+	MaxTTLSeconds int    `yaml:"max_ttl_seconds,omitempty" json:"max_ttl_seconds"` // 同时限制错误与否定缓存的 TTL
+	UserReturnTTL int    `yaml:"user_return_ttl,omitempty" json:"user_return_ttl"`
+	KeepExpired   *bool  `yaml:"keep_expired,omitempty" json:"keep_expired"` // 是否允许以过期数据应答
+	Prefetch      *bool  `yaml:"prefetch,omitempty" json:"prefetch"`         // 是否参与预取
+}
+
+// CacheBackendConfig 外部缓存后端配置，多个实例可共享同一缓存层
+type CacheBackendConfig struct {
+	Type         string `yaml:"type,omitempty" json:"type"`                   // memory（默认，不使用外部后端）| redis
+	Addr         string `yaml:"addr,omitempty" json:"addr"`                   // Redis 地址 host:port
+	Password     string `yaml:"password,omitempty" json:"password"`           // AUTH 密码，可为空
+	DB           int    `yaml:"db,omitempty" json:"db"`                       // SELECT 的数据库编号
+	KeyPrefix    string `yaml:"key_prefix,omitempty" json:"key_prefix"`       // 键前缀，不同集群共用一个 Redis 时用于隔离
+	PoolSize     int    `yaml:"pool_size,omitempty" json:"pool_size"`         // 连接池大小，同时也是异步写入的并发数
+	TimeoutMs    int    `yaml:"timeout_ms,omitempty" json:"timeout_ms"`       // 单次读写超时（毫秒），读超时视为未命中
+	StaleSeconds int    `yaml:"stale_seconds,omitempty" json:"stale_seconds"` // 条目过期后在后端继续保留的时间，用于陈旧应答
 }
 
 // PrefetchConfig 预取配置
@@ -152,6 +288,73 @@ type AdBlockConfig struct {
 	FailedSources       []string `yaml:"failed_sources,omitempty" json:"failed_sources"`
 	MaxConcurrentDownloads int   `yaml:"max_concurrent_downloads,omitempty" json:"max_concurrent_downloads"`
 	DownloadTimeoutSeconds int   `yaml:"download_timeout_seconds,omitempty" json:"download_timeout_seconds"`
+
+	// 响应策略区域（RPZ），按书写顺序决定优先级，先于规则列表生效
+	RPZ []RPZZoneConfig `yaml:"rpz,omitempty" json:"rpz"`
+	// 按应答地址拦截的 CIDR 列表（本地文件或 http(s) 地址），每行一个网段或地址
+	IPBlocklists []string `yaml:"ip_blocklists,omitempty" json:"ip_blocklists"`
+	// 一键拦截的服务 ID（见 /api/adblock/services），其规则与规则源一同编译进过滤引擎
+	BlockedServices []string `yaml:"blocked_services,omitempty" json:"blocked_services"`
+	// 服务目录的更新地址，为空时使用内置目录
+	ServicesURL string `yaml:"services_url,omitempty" json:"services_url"`
+
+	// 定时过滤：时间窗口按 Timezone 计算，为空时使用本地时区
+	Timezone  string           `yaml:"timezone,omitempty" json:"timezone"`
+	Schedules []ScheduleConfig `yaml:"schedules,omitempty" json:"schedules"`
+
+	// 拦截页：A/AAAA 拦截应答指向本机，浏览器访问被拦截域名时显示拦截原因
+	BlockPage BlockPageConfig `yaml:"block_page" json:"block_page"`
+
+	// 算法生成域名（DGA）启发式检测，对未被规则命中的域名评分
+	DGA DGAConfig `yaml:"dga" json:"dga"`
+}
+
+// DGAConfig 算法生成域名启发式检测
+type DGAConfig struct {
+	Enable             bool     `yaml:"enable" json:"enable"`
+	Mode               string   `yaml:"mode,omitempty" json:"mode"`                                 // block、alert 或 learn，默认 alert
+	Threshold          float64  `yaml:"threshold,omitempty" json:"threshold"`                       // 0-1，评分达到阈值的域名被标记，默认 0.65
+	MinLength          int      `yaml:"min_length,omitempty" json:"min_length"`                     // 可注册标签短于此长度时不评分，默认 8
+	BurstWindowSeconds int      `yaml:"burst_window_seconds,omitempty" json:"burst_window_seconds"` // NXDOMAIN 突发统计窗口，默认 60 秒
+	BurstThreshold     int      `yaml:"burst_threshold,omitempty" json:"burst_threshold"`           // 窗口内 NXDOMAIN 数达到此值时突发分量记满，默认 20
+	Allowlist          []string `yaml:"allowlist,omitempty" json:"allowlist"`                       // 不参与检测的域名后缀
+}
+
+// BlockPageConfig 内置拦截页 HTTP(S) 服务器
+type BlockPageConfig struct {
+	Enable      bool   `yaml:"enable" json:"enable"`
+	IPv4        string `yaml:"ipv4,omitempty" json:"ipv4"`                 // A 拦截应答返回的本机地址，为空时返回 0.0.0.0
+	IPv6        string `yaml:"ipv6,omitempty" json:"ipv6"`                 // AAAA 拦截应答返回的本机地址，为空时返回空应答
+	HTTPListen  string `yaml:"http_listen,omitempty" json:"http_listen"`   // 默认 :80
+	HTTPSListen string `yaml:"https_listen,omitempty" json:"https_listen"` // 默认 :443
+	CACert      string `yaml:"ca_cert,omitempty" json:"ca_cert"`           // 本地 CA 证书（PEM），与 ca_key 同时配置时为 HTTPS 连接签发证书
+	CAKey       string `yaml:"ca_key,omitempty" json:"ca_key"`             // 本地 CA 私钥（PEM）
+}
+
+// ScheduleConfig 一组只在指定时间窗口内生效的拦截规则
+type ScheduleConfig struct {
+	Name     string                 `yaml:"name" json:"name"`
+	Services []string               `yaml:"services,omitempty" json:"services"` // 服务目录中的服务 ID
+	Rules    []string               `yaml:"rules,omitempty" json:"rules"`       // 与规则源相同格式的规则
+	Windows  []ScheduleWindowConfig `yaml:"windows" json:"windows"`
+}
+
+// ScheduleWindowConfig 每周重复的时间窗口，end 不晚于 start 时窗口跨越午夜，延续到次日
+type ScheduleWindowConfig struct {
+	Days  []string `yaml:"days,omitempty" json:"days"` // mon..sun，为空表示每天
+	Start string   `yaml:"start" json:"start"`         // HH:MM
+	End   string   `yaml:"end" json:"end"`             // HH:MM
+}
+
+// RPZZoneConfig 单个 RPZ 区域的来源：本地区域文件或从主服务器区域传送
+type RPZZoneConfig struct {
+	Name           string `yaml:"name" json:"name"`                                 // 区域名，如 rpz.example.com
+	File           string `yaml:"file,omitempty" json:"file"`                       // 本地区域文件，与 primary 二选一
+	Primary        string `yaml:"primary,omitempty" json:"primary"`                 // 主服务器 host:port，通过 IXFR/AXFR 同步
+	TSIGKeyName    string `yaml:"tsig_key_name,omitempty" json:"tsig_key_name"`     // 区域传送使用的 TSIG 密钥名，可为空
+	TSIGSecret     string `yaml:"tsig_secret,omitempty" json:"tsig_secret"`         // Base64 编码的 TSIG 密钥
+	TSIGAlgorithm  string `yaml:"tsig_algorithm,omitempty" json:"tsig_algorithm"`   // 默认 hmac-sha256
+	RefreshSeconds int    `yaml:"refresh_seconds,omitempty" json:"refresh_seconds"` // 检查更新的间隔，0 表示使用 SOA 中的 refresh（文件区域为 300 秒）
 }
 
 // SystemConfig 系统资源配置
@@ -206,3 +409,39 @@ type IPPoolConfig struct {
 	// IP 池清理间隔（秒），默认 3600 秒（1 小时）
 	CleanupInterval int `yaml:"cleanup_interval,omitempty" json:"cleanup_interval"`
 }
+
+// ReplicationConfig 多实例缓存复制配置
+type ReplicationConfig struct {
+	// 是否启用节点间缓存复制
+	Enabled bool `yaml:"enabled" json:"enabled"`
+	// 本节点标识，为空时使用主机名
+	NodeID string `yaml:"node_id,omitempty" json:"node_id"`
+	// 接收对等节点数据的监听地址，为空则不接收
+	ListenAddr string `yaml:"listen_addr,omitempty" json:"listen_addr"`
+	// 对等节点地址列表（host:port），本节点主动推送数据给这些节点
+	Peers []string `yaml:"peers,omitempty" json:"peers"`
+	// 共享密钥，所有节点必须一致，用于双向认证和数据帧校验
+	SharedKey string `yaml:"shared_key,omitempty" json:"shared_key"`
+	// 每个对等节点的发送队列长度，队列满时丢弃新的更新
+	QueueSize int `yaml:"queue_size,omitempty" json:"queue_size"`
+	// 单个数据帧最多携带的更新数
+	BatchSize int `yaml:"batch_size,omitempty" json:"batch_size"`
+	// 批量发送间隔（毫秒）
+	FlushIntervalMs int `yaml:"flush_interval_ms,omitempty" json:"flush_interval_ms"`
+}
+
+// WarmupConfig 启动时缓存预热配置
+type WarmupConfig struct {
+	// 是否在启动后预热缓存
+	Enabled bool `yaml:"enabled" json:"enabled"`
+	// 热门域名历史文件，定期及关闭时由预取评分和热门域名统计写入
+	HistoryFile string `yaml:"history_file,omitempty" json:"history_file"`
+	// 用户提供的域名列表文件（每行一个域名，# 开头为注释），为空则不使用
+	DomainListFile string `yaml:"domain_list_file,omitempty" json:"domain_list_file"`
+	// 单次预热的最大域名数
+	MaxDomains int `yaml:"max_domains,omitempty" json:"max_domains"`
+	// 每秒最多发起的预热解析数
+	RatePerSecond int `yaml:"rate_per_second,omitempty" json:"rate_per_second"`
+	// 并发预热的工作协程数
+	Concurrency int `yaml:"concurrency,omitempty" json:"concurrency"`
+}
@@ -0,0 +1,186 @@
+package dnsserver
+
+import (
+	"context"
+	"fmt"
+	"smartdnssort/config"
+	"smartdnssort/stats"
+	"smartdnssort/upstream"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func mustTestRR(t *testing.T, s string) dns.RR {
+	t.Helper()
+	rr, err := dns.NewRR(s)
+	if err != nil {
+		t.Fatalf("NewRR(%q): %v", s, err)
+	}
+	return rr
+}
+
+// Test_GenericQuery_FullMessageCache 验证通用类型的 Authority/Additional 段在缓存命中后原样返回
+func Test_GenericQuery_FullMessageCache(t *testing.T) {
+	cfg := &config.Config{
+		Cache:    config.CacheConfig{FastResponseTTL: 15, ErrorCacheTTL: 60},
+		Upstream: config.UpstreamConfig{TimeoutMs: 1000},
+		Stats: config.StatsConfig{
+			HotDomainsWindowHours:   1,
+			HotDomainsBucketMinutes: 10,
+			HotDomainsShardCount:    4,
+			HotDomainsMaxPerBucket:  100,
+		},
+	}
+	s := stats.NewStats(&cfg.Stats)
+	server := NewServer(cfg, s)
+
+	upstreamCalls := 0
+	mockUpstream := &MockUpstream{
+		ExchangeFunc: func(ctx context.Context, msg *dns.Msg) (*dns.Msg, error) {
+			upstreamCalls++
+			resp := new(dns.Msg)
+			resp.SetReply(msg)
+			resp.Answer = []dns.RR{
+				mustTestRR(t, "example.com. 3600 IN MX 10 mail.example.com."),
+				mustTestRR(t, "example.com. 3600 IN RRSIG MX 13 2 3600 20300101000000 20200101000000 12345 example.com. AAAA"),
+			}
+			resp.Ns = []dns.RR{mustTestRR(t, "example.com. 7200 IN NS ns1.example.com.")}
+			resp.Extra = []dns.RR{
+				mustTestRR(t, "mail.example.com. 300 IN A 192.0.2.25"),
+				mustTestRR(t, "mail.example.com. 300 IN AAAA 2001:db8::25"),
+			}
+			resp.SetEdns0(1232, false)
+			return resp, nil
+		},
+	}
+	mgr := upstream.NewManager(&cfg.Upstream, []upstream.Upstream{mockUpstream}, s, nil)
+
+	req := new(dns.Msg)
+	req.SetQuestion("Example.COM.", dns.TypeMX)
+	w := &capturingResponseWriter{}
+	server.handleGenericCacheMiss(w, req, "example.com", dns.TypeMX, context.Background(), mgr, cfg, s, nil)
+	if w.LastMsg == nil {
+		t.Fatal("no response written on cache miss")
+	}
+	if len(w.LastMsg.Ns) != 1 || len(w.LastMsg.Extra) != 2 {
+		t.Fatalf("miss response lost sections: ns=%v extra=%v", w.LastMsg.Ns, w.LastMsg.Extra)
+	}
+
+	raw, ok := server.cache.GetRaw("example.com", dns.TypeMX)
+	if !ok || raw.Msg == nil {
+		t.Fatal("full message was not cached")
+	}
+
+	// 再次查询命中缓存：新的 ID、带 EDNS 但不带 DO
+	hit := new(dns.Msg)
+	hit.SetQuestion("example.com.", dns.TypeMX)
+	hit.SetEdns0(4096, false)
+	w = &capturingResponseWriter{}
+	if !server.handleRawCacheHitGeneric(w, hit, "example.com", dns.TypeMX, cfg, s) {
+		t.Fatal("expected generic cache hit")
+	}
+	resp := w.LastMsg
+	if upstreamCalls != 1 {
+		t.Errorf("expected 1 upstream call, got %d", upstreamCalls)
+	}
+	if resp.Id != hit.Id || resp.Question[0].Name != "example.com." {
+		t.Errorf("response not bound to the new request: id=%d question=%v", resp.Id, resp.Question)
+	}
+	if len(resp.Answer) != 1 || resp.Answer[0].Header().Rrtype != dns.TypeMX {
+		t.Errorf("RRSIG should be stripped for non-DO client, answer=%v", resp.Answer)
+	}
+	if len(resp.Ns) != 1 || len(resp.Extra) != 3 { // NS + A/AAAA glue + 我方 OPT
+		t.Fatalf("cached response lost sections: ns=%v extra=%v", resp.Ns, resp.Extra)
+	}
+	if opt := resp.IsEdns0(); opt == nil || opt.UDPSize() != 4096 {
+		t.Errorf("expected OPT echoing the client's EDNS, got %v", opt)
+	}
+	for _, rr := range resp.Extra {
+		if rr.Header().Rrtype != dns.TypeOPT && rr.Header().Ttl > 300 {
+			t.Errorf("glue TTL not preserved per record: %v", rr)
+		}
+	}
+	if ttl := resp.Ns[0].Header().Ttl; ttl <= 300 || ttl > 7200 {
+		t.Errorf("authority TTL should decrement independently, got %d", ttl)
+	}
+}
+
+func Test_BuildCachedMsgResponse_TTL(t *testing.T) {
+	server := &Server{}
+	cached := new(dns.Msg)
+	cached.SetQuestion("example.com.", dns.TypeTXT)
+	cached.Response = true
+	cached.Answer = []dns.RR{mustTestRR(t, "example.com. 100 IN TXT \"v=spf1 -all\"")}
+	cached.Extra = []dns.RR{mustTestRR(t, "extra.example.com. 30 IN A 192.0.2.1")}
+
+	req := new(dns.Msg)
+	req.SetQuestion("example.com.", dns.TypeTXT)
+
+	resp := server.buildCachedMsgResponse(req, cached, 40*time.Second, 0, false)
+	if got := resp.Answer[0].Header().Ttl; got != 60 {
+		t.Errorf("answer TTL = %d, want 60", got)
+	}
+	if got := resp.Extra[0].Header().Ttl; got != 1 {
+		t.Errorf("expired additional TTL = %d, want 1", got)
+	}
+	if resp.IsEdns0() != nil {
+		t.Error("no OPT expected for a non-EDNS client")
+	}
+
+	// 软过期：所有记录使用调用方给出的 TTL 上限
+	resp = server.buildCachedMsgResponse(req, cached, 40*time.Second, 15, false)
+	if resp.Answer[0].Header().Ttl != 15 || resp.Extra[0].Header().Ttl != 15 {
+		t.Errorf("TTLs should be capped at ceiling: %v %v", resp.Answer[0], resp.Extra[0])
+	}
+	if cached.Answer[0].Header().Ttl != 100 {
+		t.Error("cached message must not be modified")
+	}
+}
+
+// Test_GenericQuery_PositiveCacheBeforeAggressiveNSEC 验证正向缓存命中优先于积极否定缓存合成
+func Test_GenericQuery_PositiveCacheBeforeAggressiveNSEC(t *testing.T) {
+	server, cfg := newTestServer(t)
+	cfg.Upstream.Dnssec = true
+	cfg.Cache.AggressiveNSEC = true
+	s := stats.NewStats(&cfg.Stats)
+
+	signed := func(s string) []dns.RR {
+		rr := mustTestRR(t, s)
+		h := rr.Header()
+		return []dns.RR{rr, mustTestRR(t, fmt.Sprintf("%s %d IN RRSIG %s 13 %d %d 20300101000000 20200101000000 12345 example.com. AAAA",
+			h.Name, h.Ttl, dns.TypeToString[h.Rrtype], dns.CountLabel(h.Name), h.Ttl))}
+	}
+	neg := new(dns.Msg)
+	neg.Rcode = dns.RcodeNameError
+	neg.AuthenticatedData = true
+	for _, s := range []string{
+		"example.com. 3600 IN SOA ns.example.com. hostmaster.example.com. 1 7200 3600 1209600 300",
+		"example.com. 3600 IN NSEC a.example.com. A NS SOA RRSIG NSEC",
+		"c.example.com. 3600 IN NSEC m.example.com. A RRSIG NSEC",
+	} {
+		neg.Ns = append(neg.Ns, signed(s)...)
+	}
+	if server.cache.AddNSECProofs(neg) != 2 {
+		t.Fatal("NSEC proofs were not cached")
+	}
+
+	server.cache.SetRawRecords("d.example.com", dns.TypeTXT,
+		[]dns.RR{mustTestRR(t, `d.example.com. 300 IN TXT "cached"`)}, nil, 300)
+
+	query := func(name string) *dns.Msg {
+		req := new(dns.Msg)
+		req.SetQuestion(name+".", dns.TypeTXT)
+		w := &capturingResponseWriter{}
+		server.handleGenericQuery(w, req, name, dns.TypeTXT, context.Background(), nil, cfg, s, nil)
+		return w.LastMsg
+	}
+
+	if resp := query("d.example.com"); resp == nil || resp.Rcode != dns.RcodeSuccess || len(resp.Answer) != 1 {
+		t.Fatalf("cached positive answer should win over NSEC synthesis: %v", resp)
+	}
+	if resp := query("e.example.com"); resp == nil || resp.Rcode != dns.RcodeNameError {
+		t.Fatalf("uncached name covered by NSEC should be synthesized as NXDOMAIN: %v", resp)
+	}
+}
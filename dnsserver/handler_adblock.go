@@ -1,6 +1,7 @@
 package dnsserver
 
 import (
+	"net/netip"
 	"smartdnssort/adblock"
 	"smartdnssort/cache"
 	"smartdnssort/config"
@@ -11,13 +12,39 @@ import (
 	"github.com/miekg/dns"
 )
 
+// scheduleBlockedTTL 定时规则拦截应答的最大 TTL（秒）
+const scheduleBlockedTTL = 60
+
 // handleAdBlockCheck 执行 AdBlock 过滤检查
 // 返回 true 表示请求已处理
 func (s *Server) handleAdBlockCheck(w dns.ResponseWriter, r *dns.Msg, domain string, cfg *config.Config, adblockMgr *adblock.AdBlockManager) bool {
-	if adblockMgr == nil || !cfg.AdBlock.Enable {
+	if adblockMgr == nil || !adblockMgr.Enabled() {
 		return false
 	}
 
+	req := &adblock.Request{
+		Host:     domain,
+		QType:    r.Question[0].Qtype,
+		ClientIP: clientAddr(w),
+		TCP:      isTCP(w),
+	}
+
+	// 0. 定时规则集：结果随时间窗口变化，不写入拦截缓存，也不受白名单缓存影响
+	if res := adblockMgr.CheckSchedule(req); res.Match == adblock.MatchBlocked || res.Rewrite != nil {
+		logger.Debugf("[AdBlock] Blocked by schedule: %s (rule: %s)", domain, res.Rule)
+		adblockMgr.RecordBlock(domain, res.Rule)
+		s.stats.RecordBlockedDomain(domain)
+		s.cache.GetRecentlyBlocked().Add(domain)
+		// 客户端缓存的拦截应答不应延续到窗口结束之后
+		ttl := min(cfg.AdBlock.BlockedTTL, scheduleBlockedTTL)
+		if res.Rewrite != nil {
+			s.sendRewriteResponse(w, r, res.Rewrite, ttl)
+		} else {
+			s.sendBlockedResponse(w, r, domain, res.Rule, ttl, cfg)
+		}
+		return true
+	}
+
 	// 1. 检查拦截缓存 (快速路径)
 	if entry, hit := s.cache.GetBlocked(domain); hit {
 		logger.Debugf("[AdBlock] Cache Hit (Blocked): %s (rule: %s)", domain, entry.Rule)
@@ -26,7 +53,7 @@ func (s *Server) handleAdBlockCheck(w dns.ResponseWriter, r *dns.Msg, domain str
 		s.cache.GetRecentlyBlocked().Add(domain)
 
 		// 根据配置返回拦截响应
-		s.sendAdBlockResponse(w, r, cfg.AdBlock.BlockMode, cfg.AdBlock.BlockedTTL, cfg.AdBlock.BlockedResponseIP)
+		s.sendBlockedResponse(w, r, domain, entry.Rule, cfg.AdBlock.BlockedTTL, cfg)
 		return true
 	}
 
@@ -36,8 +63,19 @@ func (s *Server) handleAdBlockCheck(w dns.ResponseWriter, r *dns.Msg, domain str
 		return false // 继续执行后续 DNS 逻辑
 	}
 
-	// 3. 执行完整的规则匹配
-	matchResult, rule := adblockMgr.CheckHost(domain)
+	// 3. 执行完整的规则匹配（带查询类型与客户端上下文）
+	res := adblockMgr.Check(req)
+	if res.Rewrite != nil {
+		logger.Debugf("[AdBlock] Rewritten: %s (rules: %v)", domain, res.Rewrite.Rules)
+		if res.Match == adblock.MatchBlocked || res.Rewrite.RCode != dns.RcodeSuccess {
+			adblockMgr.RecordBlock(domain, res.Rule)
+			s.stats.RecordBlockedDomain(domain)
+		}
+		s.sendRewriteResponse(w, r, res.Rewrite, cfg.AdBlock.BlockedTTL)
+		return true
+	}
+
+	matchResult, rule := res.Match, res.Rule
 	if matchResult == adblock.MatchBlocked {
 		logger.Debugf("[AdBlock] Blocked: %s (rule: %s)", domain, rule)
 
@@ -45,27 +83,42 @@ func (s *Server) handleAdBlockCheck(w dns.ResponseWriter, r *dns.Msg, domain str
 		adblockMgr.RecordBlock(domain, rule)
 		s.stats.RecordBlockedDomain(domain)
 
-		// 写入拦截缓存
-		s.cache.SetBlocked(domain, &cache.BlockedCacheEntry{
-			BlockType: cfg.AdBlock.BlockMode,
-			Rule:      rule,
-			ExpiredAt: time.Now().Add(time.Duration(cfg.AdBlock.BlockedTTL) * time.Second),
-		})
+		// 写入拦截缓存（结果随查询类型或客户端变化时不按域名缓存）
+		if res.Cacheable {
+			s.cache.SetBlocked(domain, &cache.BlockedCacheEntry{
+				BlockType: cfg.AdBlock.BlockMode,
+				Rule:      rule,
+				ExpiredAt: time.Now().Add(time.Duration(cfg.AdBlock.BlockedTTL) * time.Second),
+			})
+		}
 
 		// 记录到最近被拦截的域名列表
 		s.cache.GetRecentlyBlocked().Add(domain)
 
 		// 根据配置返回拦截响应
-		s.sendAdBlockResponse(w, r, cfg.AdBlock.BlockMode, cfg.AdBlock.BlockedTTL, cfg.AdBlock.BlockedResponseIP)
+		s.sendBlockedResponse(w, r, domain, rule, cfg.AdBlock.BlockedTTL, cfg)
 		return true
 	}
 
+	// 4. DGA 启发式检测：只对没有被规则明确允许的域名评分，结果随客户端变化，不写入拦截缓存
+	if matchResult == adblock.MatchNeutral {
+		if dgaRes := adblockMgr.CheckDGA(req); dgaRes.Match == adblock.MatchBlocked {
+			adblockMgr.RecordBlock(domain, dgaRes.Rule)
+			s.stats.RecordBlockedDomain(domain)
+			s.cache.GetRecentlyBlocked().Add(domain)
+			s.sendBlockedResponse(w, r, domain, dgaRes.Rule, cfg.AdBlock.BlockedTTL, cfg)
+			return true
+		}
+	}
+
 	// 写入白名单缓存
-	isExplicit := (matchResult == adblock.MatchAllowed)
-	s.cache.SetAllowed(domain, &cache.AllowedCacheEntry{
-		ExpiredAt:  time.Now().Add(600 * time.Second),
-		IsExplicit: isExplicit,
-	})
+	if res.Cacheable {
+		isExplicit := (matchResult == adblock.MatchAllowed)
+		s.cache.SetAllowed(domain, &cache.AllowedCacheEntry{
+			ExpiredAt:  time.Now().Add(600 * time.Second),
+			IsExplicit: isExplicit,
+		})
+	}
 
 	return false // 未被拦截，继续处理
 }
@@ -82,10 +135,110 @@ func (s *Server) sendAdBlockResponse(w dns.ResponseWriter, r *dns.Msg, blockMode
 	}
 }
 
+// sendRewriteResponse 按 $dnsrewrite/hosts/RPZ 规则的改写结果合成应答
+// CNAME 改写与自定义回复规则一致，只返回 CNAME 记录，由客户端继续解析目标
+func (s *Server) sendRewriteResponse(w dns.ResponseWriter, r *dns.Msg, rw *adblock.Rewrite, ttl int) {
+	if rw.Drop {
+		return
+	}
+	msg := s.msgPool.Get()
+	msg.SetReply(r)
+	msg.RecursionAvailable = true
+	msg.Compress = false
+
+	name := r.Question[0].Name
+	switch {
+	case rw.Truncate:
+		msg.Truncated = true
+	case rw.CNAME != "":
+		msg.Answer = append(msg.Answer, &dns.CNAME{
+			Hdr:    dns.RR_Header{Name: name, Rrtype: dns.TypeCNAME, Class: dns.ClassINET, Ttl: uint32(ttl)},
+			Target: rw.CNAME,
+		})
+	case rw.RCode != dns.RcodeSuccess:
+		msg.SetRcode(r, rw.RCode)
+		if rw.RCode == dns.RcodeNameError {
+			msg.Ns = append(msg.Ns, s.buildSOARecord(strings.TrimRight(name, "."), uint32(ttl)))
+		}
+	default:
+		for _, rr := range rw.Answer {
+			rr = dns.Copy(rr)
+			rr.Header().Name = name
+			rr.Header().Ttl = uint32(ttl)
+			msg.Answer = append(msg.Answer, rr)
+		}
+		if len(msg.Answer) == 0 { // NODATA
+			msg.Ns = append(msg.Ns, s.buildSOARecord(strings.TrimRight(name, "."), uint32(ttl)))
+		}
+	}
+
+	w.WriteMsg(msg)
+	s.msgPool.Put(msg)
+}
+
+// clientAddr 返回请求来源 IP，无法获取时返回零值
+func clientAddr(w dns.ResponseWriter) netip.Addr {
+	addr := w.RemoteAddr()
+	if addr == nil {
+		return netip.Addr{}
+	}
+	ap, err := netip.ParseAddrPort(addr.String())
+	if err != nil {
+		return netip.Addr{}
+	}
+	return ap.Addr().Unmap()
+}
+
+// handleResponseIPCheck 按 IP 列表检查上游解析得到的最终地址，须在写入缓存前调用
+// 返回 true 表示请求被拦截
+func (s *Server) handleResponseIPCheck(w dns.ResponseWriter, r *dns.Msg, domain string, ips []string, cfg *config.Config, adblockMgr *adblock.AdBlockManager) bool {
+	rule, blocked := s.checkResponseIPs(domain, ips, cfg, adblockMgr)
+	if !blocked {
+		return false
+	}
+	adblockMgr.RecordBlock(domain, rule)
+	s.stats.RecordBlockedDomain(domain)
+	s.cache.GetRecentlyBlocked().Add(domain)
+
+	s.sendBlockedResponse(w, r, domain, rule, cfg.AdBlock.BlockedTTL, cfg)
+	return true
+}
+
+// checkResponseIPs 按 IP 列表检查解析得到的地址，命中时写入拦截缓存并返回命中的网段
+// 之后的查询在拦截缓存过期前直接返回拦截应答
+func (s *Server) checkResponseIPs(domain string, ips []string, cfg *config.Config, adblockMgr *adblock.AdBlockManager) (string, bool) {
+	if adblockMgr == nil || !adblockMgr.Enabled() || len(ips) == 0 {
+		return "", false
+	}
+	if s.cache.GetExplicitAllowed(domain) {
+		return "", false
+	}
+
+	addrs := make([]netip.Addr, 0, len(ips))
+	for _, ip := range ips {
+		if addr, err := netip.ParseAddr(ip); err == nil {
+			addrs = append(addrs, addr.Unmap())
+		}
+	}
+	prefix, ok := adblockMgr.CheckResponseIPs(addrs)
+	if !ok {
+		return "", false
+	}
+
+	rule := prefix.String()
+	logger.Debugf("[AdBlock] Response IP Blocked: %s resolved into %s", domain, rule)
+	s.cache.SetBlocked(domain, &cache.BlockedCacheEntry{
+		BlockType: cfg.AdBlock.BlockMode,
+		Rule:      rule,
+		ExpiredAt: time.Now().Add(time.Duration(cfg.AdBlock.BlockedTTL) * time.Second),
+	})
+	return rule, true
+}
+
 // handleCNAMEChainValidation 对 CNAME 链进行 AdBlock 检查
 // 返回 true 表示请求被拦截
 func (s *Server) handleCNAMEChainValidation(w dns.ResponseWriter, r *dns.Msg, domain string, cnames []string, cfg *config.Config, adblockMgr *adblock.AdBlockManager) bool {
-	if adblockMgr == nil || !cfg.AdBlock.Enable || len(cnames) == 0 {
+	if adblockMgr == nil || !adblockMgr.Enabled() || len(cnames) == 0 {
 		return false
 	}
 
@@ -115,7 +268,7 @@ func (s *Server) handleCNAMEChainValidation(w dns.ResponseWriter, r *dns.Msg, do
 			s.cache.GetRecentlyBlocked().Add(domain)
 
 			// 返回拦截响应
-			s.sendAdBlockResponse(w, r, cfg.AdBlock.BlockMode, cfg.AdBlock.BlockedTTL, cfg.AdBlock.BlockedResponseIP)
+			s.sendBlockedResponse(w, r, domain, rule, cfg.AdBlock.BlockedTTL, cfg)
 			return true
 		case adblock.MatchAllowed:
 			// 如果 CNAME 链中的某个域名被明确允许，我们可以选择停止检查或者仅针对此 CNAME 允许
@@ -0,0 +1,267 @@
+package dnsserver
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"smartdnssort/adblock"
+	"smartdnssort/config"
+	"smartdnssort/stats"
+	"smartdnssort/upstream"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func Test_SendRewriteResponse(t *testing.T) {
+	server, _ := newTestServer(t)
+	req := new(dns.Msg)
+	req.SetQuestion("Nas.Example.", dns.TypeA)
+
+	w := &capturingResponseWriter{}
+	server.sendRewriteResponse(w, req, &adblock.Rewrite{
+		Answer: []dns.RR{mustTestRR(t, "nas.example. 0 IN A 192.0.2.50")},
+	}, 10)
+	resp := w.LastMsg
+	if resp.Rcode != dns.RcodeSuccess || len(resp.Answer) != 1 {
+		t.Fatalf("unexpected rewrite response: %v", resp)
+	}
+	if h := resp.Answer[0].Header(); h.Name != "Nas.Example." || h.Ttl != 10 {
+		t.Errorf("answer should use the question name and blocked TTL: %v", resp.Answer[0])
+	}
+
+	w = &capturingResponseWriter{}
+	server.sendRewriteResponse(w, req, &adblock.Rewrite{CNAME: "target.example.net."}, 10)
+	if cname, ok := w.LastMsg.Answer[0].(*dns.CNAME); !ok || cname.Target != "target.example.net." {
+		t.Errorf("expected CNAME answer, got %v", w.LastMsg.Answer)
+	}
+
+	w = &capturingResponseWriter{}
+	server.sendRewriteResponse(w, req, &adblock.Rewrite{RCode: dns.RcodeRefused}, 10)
+	if w.LastMsg.Rcode != dns.RcodeRefused || len(w.LastMsg.Answer) != 0 {
+		t.Errorf("expected REFUSED, got %v", w.LastMsg)
+	}
+
+	w = &capturingResponseWriter{}
+	server.sendRewriteResponse(w, req, &adblock.Rewrite{}, 10)
+	if w.LastMsg.Rcode != dns.RcodeSuccess || len(w.LastMsg.Answer) != 0 || len(w.LastMsg.Ns) != 1 {
+		t.Errorf("expected NODATA with SOA, got %v", w.LastMsg)
+	}
+
+	w = &capturingResponseWriter{}
+	server.sendRewriteResponse(w, req, &adblock.Rewrite{Truncate: true}, 10)
+	if !w.LastMsg.Truncated || len(w.LastMsg.Answer) != 0 {
+		t.Errorf("expected empty truncated response, got %v", w.LastMsg)
+	}
+
+	w = &capturingResponseWriter{}
+	server.sendRewriteResponse(w, req, &adblock.Rewrite{Drop: true}, 10)
+	if w.LastMsg != nil {
+		t.Errorf("dropped query should not be answered, got %v", w.LastMsg)
+	}
+}
+
+func Test_AnswerIPs(t *testing.T) {
+	msg := new(dns.Msg)
+	msg.Answer = []dns.RR{
+		mustTestRR(t, "a.example. 60 IN CNAME b.example."),
+		mustTestRR(t, "b.example. 60 IN A 192.0.2.1"),
+		mustTestRR(t, "b.example. 60 IN AAAA ::ffff:192.0.2.2"),
+	}
+	ips := answerIPs(msg)
+	if len(ips) != 2 || ips[0].String() != "192.0.2.1" || ips[1].String() != "192.0.2.2" {
+		t.Errorf("unexpected answer IPs: %v", ips)
+	}
+
+	msg.Rcode = dns.RcodeNameError
+	if ips := answerIPs(msg); len(ips) != 0 {
+		t.Errorf("error responses should not be checked, got %v", ips)
+	}
+}
+
+// Test_ResponseIPBlocklist 验证 A 查询与通用查询的最终地址命中 CIDR 列表时按 block_mode 拦截且不写入缓存
+func Test_ResponseIPBlocklist(t *testing.T) {
+	server, cfg := newTestServer(t)
+	s := stats.NewStats(&cfg.Stats)
+
+	dir := t.TempDir()
+	listFile := filepath.Join(dir, "bad_ranges.txt")
+	if err := os.WriteFile(listFile, []byte("192.0.2.0/24\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg.AdBlock = config.AdBlockConfig{Enable: true, Engine: "simple", CacheDir: dir, BlockMode: "nxdomain", BlockedTTL: 60, IPBlocklists: []string{listFile}}
+	adblockMgr, err := adblock.NewManager(&cfg.AdBlock, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	adblockMgr.UpdateIPBlocklists(false)
+
+	mockUpstream := &MockUpstream{
+		ExchangeFunc: func(ctx context.Context, msg *dns.Msg) (*dns.Msg, error) {
+			resp := new(dns.Msg)
+			resp.SetReply(msg)
+			switch msg.Question[0].Qtype {
+			case dns.TypeA:
+				resp.Answer = []dns.RR{mustTestRR(t, "fresh.example. 300 IN A 192.0.2.10")}
+			case dns.TypeHTTPS:
+				resp.Answer = []dns.RR{mustTestRR(t, `fresh.example. 300 IN HTTPS 1 . ipv4hint="192.0.2.11"`)}
+			}
+			return resp, nil
+		},
+	}
+	mgr := upstream.NewManager(&cfg.Upstream, []upstream.Upstream{mockUpstream}, s, nil)
+
+	req := new(dns.Msg)
+	req.SetQuestion("fresh.example.", dns.TypeA)
+	w := &capturingResponseWriter{}
+	server.handleCacheMiss(w, req, "fresh.example", req.Question[0], context.Background(), mgr, cfg, s, adblockMgr)
+	if w.LastMsg == nil || w.LastMsg.Rcode != dns.RcodeNameError {
+		t.Fatalf("expected blocked NXDOMAIN response, got %v", w.LastMsg)
+	}
+	if _, ok := server.cache.GetRaw("fresh.example", dns.TypeA); ok {
+		t.Error("blocked answer must not be cached")
+	}
+	if entry, ok := server.cache.GetBlocked("fresh.example"); !ok || entry.Rule != "192.0.2.0/24" {
+		t.Errorf("matched CIDR should be recorded as the blocking rule, got %+v", entry)
+	}
+
+	req = new(dns.Msg)
+	req.SetQuestion("fresh.example.", dns.TypeHTTPS)
+	w = &capturingResponseWriter{}
+	server.handleGenericCacheMiss(w, req, "fresh.example", dns.TypeHTTPS, context.Background(), mgr, cfg, s, adblockMgr)
+	if w.LastMsg == nil || w.LastMsg.Rcode != dns.RcodeNameError {
+		t.Fatalf("HTTPS hint in a blocked range should be blocked, got %v", w.LastMsg)
+	}
+	if _, ok := server.cache.GetRaw("fresh.example", dns.TypeHTTPS); ok {
+		t.Error("blocked HTTPS answer must not be cached")
+	}
+}
+
+// Test_AdBlockSchedule 验证定时规则在 handleAdBlockCheck 中生效，且拦截应答使用较短的 TTL、不写入拦截缓存
+func Test_AdBlockSchedule(t *testing.T) {
+	server, cfg := newTestServer(t)
+	cfg.AdBlock = config.AdBlockConfig{
+		Enable: true, Engine: "simple", CacheDir: t.TempDir(), BlockMode: "nxdomain", BlockedTTL: 3600,
+		Schedules: []config.ScheduleConfig{{
+			Name:    "always",
+			Rules:   []string{"||games.example^"},
+			Windows: []config.ScheduleWindowConfig{{Start: "00:00", End: "00:00"}},
+		}},
+	}
+	adblockMgr, err := adblock.NewManager(&cfg.AdBlock, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := new(dns.Msg)
+	req.SetQuestion("play.games.example.", dns.TypeA)
+	w := &capturingResponseWriter{}
+	if !server.handleAdBlockCheck(w, req, "play.games.example", cfg, adblockMgr) {
+		t.Fatal("scheduled rule should block the query")
+	}
+	if w.LastMsg.Rcode != dns.RcodeNameError || len(w.LastMsg.Ns) != 1 || w.LastMsg.Ns[0].Header().Ttl > scheduleBlockedTTL {
+		t.Errorf("expected NXDOMAIN with a short TTL, got %v", w.LastMsg)
+	}
+	if _, hit := server.cache.GetBlocked("play.games.example"); hit {
+		t.Error("scheduled blocks must not be written to the blocked cache")
+	}
+
+	adblockMgr.Pause(time.Hour, nil)
+	defer adblockMgr.Resume()
+	if server.handleAdBlockCheck(&capturingResponseWriter{}, req, "play.games.example", cfg, adblockMgr) {
+		t.Error("schedules should not apply while filtering is paused")
+	}
+
+	// 暂停期间切换开关（如 /api/adblock/toggle）只在暂停结束后生效
+	server.adblockManager = adblockMgr
+	server.SetAdBlockEnabled(true)
+	if server.handleAdBlockCheck(&capturingResponseWriter{}, req, "play.games.example", cfg, adblockMgr) {
+		t.Error("toggling during a pause must not resume filtering")
+	}
+}
+
+// Test_AdBlockDGA 验证拦截模式下 DGA 检测拦截未被规则命中的可疑域名，且应答观察者把 NXDOMAIN 反馈给检测器
+func Test_AdBlockDGA(t *testing.T) {
+	server, cfg := newTestServer(t)
+	cfg.AdBlock = config.AdBlockConfig{
+		Enable: true, Engine: "simple", CacheDir: t.TempDir(), BlockMode: "nxdomain", BlockedTTL: 60,
+		DGA: config.DGAConfig{Enable: true, Mode: adblock.DGAModeBlock},
+	}
+	adblockMgr, err := adblock.NewManager(&cfg.AdBlock, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := new(dns.Msg)
+	req.SetQuestion("qzkwhvbxtrpfjd.net.", dns.TypeA)
+	w := &capturingResponseWriter{}
+	if !server.handleAdBlockCheck(w, req, "qzkwhvbxtrpfjd.net", cfg, adblockMgr) {
+		t.Fatal("suspicious domain should be blocked")
+	}
+	if w.LastMsg.Rcode != dns.RcodeNameError {
+		t.Errorf("expected NXDOMAIN, got %v", w.LastMsg)
+	}
+	if _, hit := server.cache.GetBlocked("qzkwhvbxtrpfjd.net"); hit {
+		t.Error("DGA blocks must not be written to the blocked cache")
+	}
+	if flagged := adblockMgr.DGA().Flagged(); len(flagged) != 1 || flagged[0].Action != "blocked" {
+		t.Errorf("blocked domain should be flagged, got %+v", flagged)
+	}
+
+	req.SetQuestion("www.google.com.", dns.TypeA)
+	if server.handleAdBlockCheck(&capturingResponseWriter{}, req, "www.google.com", cfg, adblockMgr) {
+		t.Error("benign domain should not be blocked")
+	}
+
+	learnCfg := *cfg
+	learnCfg.AdBlock.DGA.Mode = adblock.DGAModeLearn
+	adblockMgr.SetDGA(learnCfg.AdBlock.DGA)
+	ow := server.wrapDGAObserver(&capturingResponseWriter{}, "www.google.com", &learnCfg, adblockMgr)
+	resp := new(dns.Msg)
+	resp.SetReply(req)
+	resp.Answer = []dns.RR{mustTestRR(t, "www.google.com. 300 IN A 192.0.2.1")}
+	ow.WriteMsg(resp)
+	if adblockMgr.DGA().LearnedNames() != 1 {
+		t.Error("answered queries should train the model in learn mode")
+	}
+}
+
+// Test_RefreshResponseIPBlocklist 验证后台刷新（预取、预热）得到的地址命中 CIDR 列表时丢弃原有缓存并写入拦截缓存
+func Test_RefreshResponseIPBlocklist(t *testing.T) {
+	server, cfg := newTestServer(t)
+
+	dir := t.TempDir()
+	listFile := filepath.Join(dir, "bad_ranges.txt")
+	if err := os.WriteFile(listFile, []byte("192.0.2.0/24\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg.AdBlock = config.AdBlockConfig{Enable: true, Engine: "simple", CacheDir: dir, BlockMode: "nxdomain", BlockedTTL: 60, IPBlocklists: []string{listFile}}
+	adblockMgr, err := adblock.NewManager(&cfg.AdBlock, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	adblockMgr.UpdateIPBlocklists(false)
+
+	mockUpstream := &MockUpstream{
+		ExchangeFunc: func(ctx context.Context, msg *dns.Msg) (*dns.Msg, error) {
+			resp := new(dns.Msg)
+			resp.SetReply(msg)
+			resp.Answer = []dns.RR{mustTestRR(t, "moved.example. 300 IN A 192.0.2.10")}
+			return resp, nil
+		},
+	}
+	server.upstream = upstream.NewManager(&cfg.Upstream, []upstream.Upstream{mockUpstream}, server.stats, nil)
+	server.adblockManager = adblockMgr
+	server.networkChecker = nil
+
+	server.cache.SetRawRecordsWithSource("moved.example", dns.TypeA, []dns.RR{mustTestRR(t, "moved.example. 300 IN A 198.51.100.1")}, nil, 300, false, "")
+	server.refreshCacheAsync(RefreshTask{Domain: "moved.example", Qtype: dns.TypeA})
+
+	if _, ok := server.cache.GetRaw("moved.example", dns.TypeA); ok {
+		t.Error("cached answer should be dropped once the domain resolves into a blocked range")
+	}
+	if entry, ok := server.cache.GetBlocked("moved.example"); !ok || entry.Rule != "192.0.2.0/24" {
+		t.Errorf("refresh should record the blocked range, got %+v", entry)
+	}
+}
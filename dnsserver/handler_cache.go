@@ -45,6 +45,45 @@ func (s *Server) handleErrorCacheHit(w dns.ResponseWriter, r *dns.Msg, domain st
 	return false
 }
 
+// handleAggressiveNSEC 使用缓存的 NSEC/NSEC3 证明合成否定应答（RFC 8198）
+// 不带 DO 位的客户端只返回 SOA；带 DO 位时附带所用的证明和签名
+// 返回 true 表示请求已处理
+func (s *Server) handleAggressiveNSEC(w dns.ResponseWriter, r *dns.Msg, domain string, qtype uint16, cfg *config.Config, stats *stats.Stats) bool {
+	if !cfg.Upstream.Dnssec || !cfg.Cache.AggressiveNSEC {
+		return false
+	}
+	neg, ok := s.cache.SynthesizeNegative(domain, qtype)
+	if !ok {
+		return false
+	}
+	stats.IncCacheHits()
+	stats.IncSynthesizedNegative(neg.Rcode == dns.RcodeNameError)
+	logger.Debugf("[handleQuery] 积极否定缓存命中: %s (type=%s, rcode=%s, TTL=%d)",
+		domain, dns.TypeToString[qtype], dns.RcodeToString[neg.Rcode], neg.TTL)
+
+	do := r.IsEdns0() != nil && r.IsEdns0().Do()
+	msg := s.msgPool.Get()
+	msg.SetReply(r)
+	msg.RecursionAvailable = true
+	msg.Compress = false
+	msg.SetRcode(r, neg.Rcode)
+	// 证明来自上游已验证的应答
+	msg.AuthenticatedData = do || r.AuthenticatedData
+	for _, rr := range neg.Ns {
+		switch rr.Header().Rrtype {
+		case dns.TypeNSEC, dns.TypeNSEC3, dns.TypeRRSIG:
+			if !do {
+				continue
+			}
+		}
+		msg.Ns = append(msg.Ns, rr)
+	}
+
+	w.WriteMsg(msg)
+	s.msgPool.Put(msg)
+	return true
+}
+
 // handleSortedCacheHit 处理排序完成后的缓存命中
 // 返回 true 表示请求已处理
 func (s *Server) handleSortedCacheHit(w dns.ResponseWriter, r *dns.Msg, domain string, qtype uint16, cfg *config.Config, stats *stats.Stats) bool {
@@ -53,8 +92,15 @@ func (s *Server) handleSortedCacheHit(w dns.ResponseWriter, r *dns.Msg, domain s
 		return false
 	}
 
-	s.cache.RecordAccess(domain, qtype)                   // 记录访问
-	s.prefetcher.RecordAccess(domain, uint32(sorted.TTL)) // Prefetcher Math Model Update
+	policy := s.cache.TTLPolicyFor(domain)
+	// 按域名规则禁止以过期数据应答时，上游记录过期后不再使用排序缓存，交由后续流程回源
+	if !policy.AllowStale(true) {
+		if raw, ok := s.cache.GetRaw(domain, qtype); !ok || raw.IsExpired() {
+			return false
+		}
+	}
+	s.cache.RecordAccess(domain, qtype)                        // 记录访问
+	s.recordPrefetchAccess(domain, uint32(sorted.TTL), policy) // Prefetcher Math Model Update
 	stats.IncCacheHits()
 	stats.RecordDomainQuery(domain) // ✅ 统计有效域名查询
 
@@ -88,7 +134,7 @@ func (s *Server) handleSortedCacheHit(w dns.ResponseWriter, r *dns.Msg, domain s
 		userTTL = uint32(cfg.Cache.FastResponseTTL)
 	} else {
 		// 走您现有的复杂 TTL 计算逻辑，保持兼容性
-		userTTL = s.calculateUserTTL(sorted.TTL, elapsed, cfg, rttStale)
+		userTTL = s.calculateUserTTL(sorted.TTL, elapsed, cfg, policy.UserReturnTTL, rttStale)
 	}
 
 	// 5. 异步刷新策略：精准决策
@@ -123,6 +169,9 @@ func (s *Server) handleSortedCacheHit(w dns.ResponseWriter, r *dns.Msg, domain s
 		}
 	}
 
+	// 手动排序规则在所有自动排序之后生效
+	ipsToReturn = s.applySortRules(domain, ipsToReturn)
+
 	// 7. 构造响应
 	msg := s.msgPool.Get()
 	msg.SetReply(r)
@@ -145,16 +194,25 @@ func (s *Server) handleSortedCacheHit(w dns.ResponseWriter, r *dns.Msg, domain s
 	return true
 }
 
+// recordPrefetchAccess 向预取模型记录一次访问，按域名规则禁止预取的域名不进入评分表
+func (s *Server) recordPrefetchAccess(domain string, ttl uint32, policy cache.TTLPolicy) {
+	if !policy.Prefetch {
+		return
+	}
+	s.prefetcher.RecordAccess(domain, ttl)
+}
+
 // calculateUserTTL 抽取通用的用户 TTL 计算逻辑
-func (s *Server) calculateUserTTL(originalTTL int, elapsed time.Duration, cfg *config.Config, isStale bool) uint32 {
+// userReturnTTL 为该域名生效的 user_return_ttl（按域名规则可覆盖全局值）
+func (s *Server) calculateUserTTL(originalTTL int, elapsed time.Duration, cfg *config.Config, userReturnTTL int, isStale bool) uint32 {
 	elapsedSec := int(elapsed.Seconds())
 	remaining := max(0, originalTTL-elapsedSec)
 
 	var userTTL uint32
 	if remaining > 0 {
-		if cfg.Cache.UserReturnTTL > 0 {
-			cycleOffset := elapsedSec % cfg.Cache.UserReturnTTL
-			cappedTTL := cfg.Cache.UserReturnTTL - cycleOffset
+		if userReturnTTL > 0 {
+			cycleOffset := elapsedSec % userReturnTTL
+			cappedTTL := userReturnTTL - cycleOffset
 			userTTL = uint32(min(remaining, cappedTTL))
 		} else {
 			userTTL = uint32(remaining)
@@ -180,16 +238,18 @@ func (s *Server) handleRawCacheHit(w dns.ResponseWriter, r *dns.Msg, domain stri
 		return false
 	}
 
-	s.cache.RecordAccess(domain, qtype)                // 记录访问
-	s.prefetcher.RecordAccess(domain, raw.UpstreamTTL) // Prefetcher Math Model Update
+	policy := s.cache.TTLPolicyFor(domain)
+	s.cache.RecordAccess(domain, qtype)                     // 记录访问
+	s.recordPrefetchAccess(domain, raw.UpstreamTTL, policy) // Prefetcher Math Model Update
 	stats.IncCacheHits()
 	stats.RecordDomainQuery(domain) // ✅ 统计有效域名查询
 
 	// 第二阶段改造：三段式过期判定
 	// 优雅期：只要数据还在缓存中（未被清理），就允许通过 Stale-While-Revalidate 返回
 	// 自动优化：如果内存压力极低（<50%），即使配置关闭了 KeepExpiredEntries，也自动允许使用陈旧数据以加速响应
+	// 按域名规则显式设置了 keep_expired 时以规则为准
 	gracePeriod := uint32(cache.AncientLimitLowPressure)
-	useKeepExpired := cfg.Cache.KeepExpiredEntries || s.cache.GetMemoryUsagePercent() < 0.5
+	useKeepExpired := policy.AllowStale(s.cache.GetMemoryUsagePercent() < 0.5)
 	cacheState := raw.GetStateWithConfig(useKeepExpired, gracePeriod)
 
 	elapsed := time.Since(raw.AcquisitionTime)
@@ -199,7 +259,7 @@ func (s *Server) handleRawCacheHit(w dns.ResponseWriter, r *dns.Msg, domain stri
 	switch cacheState {
 	case cache.FRESH:
 		// Fresh 状态：直接返回，TTL 使用 UserReturnTTL（受 EffectiveTTL 余额限制）
-		userTTL = s.calculateUserTTL(int(raw.EffectiveTTL), elapsed, cfg, false)
+		userTTL = s.calculateUserTTL(int(raw.EffectiveTTL), elapsed, cfg, policy.UserReturnTTL, false)
 		logger.Debugf("[handleQuery] 原始缓存命中 (Fresh): %s (type=%s) -> %v, CNAMEs=%v, TTL=%d",
 			domain, dns.TypeToString[qtype], raw.IPs, raw.CNAMEs, userTTL)
 		// Fresh 状态下，只触发轻量的测速刷新，不查询上游
@@ -267,6 +327,7 @@ func (s *Server) handleRawCacheHit(w dns.ResponseWriter, r *dns.Msg, domain stri
 		ipsToReturn = s.prefetcher.GetFallbackRank(rankDomain, raw.IPs)
 		logger.Debugf("[handleQuery] 使用兜底排序: %s (type=%s) -> %v", domain, dns.TypeToString[qtype], ipsToReturn)
 	}
+	ipsToReturn = s.applySortRules(domain, ipsToReturn)
 
 	msg := s.msgPool.Get()
 	msg.RecursionAvailable = true
@@ -306,14 +367,20 @@ func (s *Server) handleRawCacheHitGeneric(w dns.ResponseWriter, r *dns.Msg, doma
 		return false
 	}
 
+	// 客户端需要 DNSSEC 记录，而缓存的完整应答不是以 DO 方式获取的：按未命中处理，重新向上游查询
+	if raw.Msg != nil && cfg.Upstream.Dnssec && r.IsEdns0() != nil && r.IsEdns0().Do() && !msgHasDO(raw.Msg) {
+		return false
+	}
+
+	policy := s.cache.TTLPolicyFor(domain)
 	s.cache.RecordAccess(domain, qtype)
-	s.prefetcher.RecordAccess(domain, raw.UpstreamTTL)
+	s.recordPrefetchAccess(domain, raw.UpstreamTTL, policy)
 	stats.IncCacheHits()
 	stats.RecordDomainQuery(domain)
 
 	// 审计修复：应用三段式过期判定逻辑
 	gracePeriod := uint32(cache.AncientLimitLowPressure)
-	useKeepExpired := cfg.Cache.KeepExpiredEntries || s.cache.GetMemoryUsagePercent() < 0.5
+	useKeepExpired := policy.AllowStale(s.cache.GetMemoryUsagePercent() < 0.5)
 	cacheState := raw.GetStateWithConfig(useKeepExpired, gracePeriod)
 
 	elapsed := time.Since(raw.AcquisitionTime)
@@ -322,7 +389,7 @@ func (s *Server) handleRawCacheHitGeneric(w dns.ResponseWriter, r *dns.Msg, doma
 	switch cacheState {
 	case cache.FRESH:
 		// Fresh 状态：直接返回
-		userTTL = s.calculateUserTTL(int(raw.EffectiveTTL), elapsed, cfg, false)
+		userTTL = s.calculateUserTTL(int(raw.EffectiveTTL), elapsed, cfg, policy.UserReturnTTL, false)
 		logger.Debugf("[handleRawCacheHitGeneric] 通用记录缓存命中 (Fresh): %s (type=%s) -> %d 条记录, CNAMEs=%v, TTL=%d",
 			domain, dns.TypeToString[qtype], len(raw.Records), raw.CNAMEs, userTTL)
 
@@ -344,14 +411,24 @@ func (s *Server) handleRawCacheHitGeneric(w dns.ResponseWriter, r *dns.Msg, doma
 		return false // 让上层去上游查询
 	}
 
+	authData := raw.AuthenticatedData && cfg.Upstream.Dnssec
+
+	// 有完整应答时原样回放三段记录，各记录 TTL 不超过 userTTL
+	if raw.Msg != nil {
+		resp := s.buildCachedMsgResponse(r, raw.Msg, elapsed, userTTL, authData)
+		s.rewriteSVCBAnswer(resp, domain, &cfg.DNS.SVCB)
+		w.WriteMsg(resp)
+		return true
+	}
+
 	// 构建通用响应
 	msg := s.msgPool.Get()
 	msg.SetReply(r)
 	msg.RecursionAvailable = true
 	msg.Compress = false
-	authData := raw.AuthenticatedData && cfg.Upstream.Dnssec
 
 	s.buildGenericResponse(msg, raw.CNAMEs, raw.Records, qtype, userTTL, authData)
+	s.rewriteSVCBAnswer(msg, domain, &cfg.DNS.SVCB)
 	w.WriteMsg(msg)
 	s.msgPool.Put(msg)
 
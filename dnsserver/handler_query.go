@@ -63,7 +63,7 @@ func (s *Server) handleCacheMiss(w dns.ResponseWriter, r *dns.Msg, domain string
 			msg.SetRcode(r, dns.RcodeNameError)
 
 			// 添加 SOA 记录到 Authority section（符合 RFC 2308）
-			soa := s.buildSOARecord(domain, uint32(currentCfg.Cache.ErrorCacheTTL))
+			soa := s.buildSOARecord(domain, uint32(s.cache.ClampErrorTTL(domain, currentCfg.Cache.ErrorCacheTTL)))
 			msg.Ns = append(msg.Ns, soa)
 
 			w.WriteMsg(msg)
@@ -73,7 +73,7 @@ func (s *Server) handleCacheMiss(w dns.ResponseWriter, r *dns.Msg, domain string
 			msg.Answer = nil
 
 			// 添加 SOA 记录到 Authority section（SERVFAIL 也应该有 SOA）
-			soa := s.buildSOARecord(domain, uint32(currentCfg.Cache.ErrorCacheTTL))
+			soa := s.buildSOARecord(domain, uint32(s.cache.ClampErrorTTL(domain, currentCfg.Cache.ErrorCacheTTL)))
 			msg.Ns = append(msg.Ns, soa)
 
 			w.WriteMsg(msg)
@@ -84,6 +84,11 @@ func (s *Server) handleCacheMiss(w dns.ResponseWriter, r *dns.Msg, domain string
 
 	// --- 统一处理入口 ---
 
+	// 积极否定缓存：收集已验证应答中的 NSEC/NSEC3 证明
+	if currentCfg.Upstream.Dnssec && currentCfg.Cache.AggressiveNSEC {
+		s.cache.AddNSECProofs(result.DnsMsg)
+	}
+
 	// 核心修复：检查上游返回的消息状态码
 	// 如果上游返回 NXDOMAIN，我们应该尊重并缓存 NXDOMAIN，而不是视为空结果 (NODATA)
 	if result.DnsMsg != nil && result.DnsMsg.Rcode == dns.RcodeNameError {
@@ -97,7 +102,7 @@ func (s *Server) handleCacheMiss(w dns.ResponseWriter, r *dns.Msg, domain string
 		msg.SetRcode(r, dns.RcodeNameError)
 
 		// 添加 SOA 记录到 Authority section（符合 RFC 2308）
-		soa := s.buildSOARecord(domain, uint32(currentCfg.Cache.ErrorCacheTTL))
+		soa := s.buildSOARecord(domain, uint32(s.cache.ClampErrorTTL(domain, currentCfg.Cache.ErrorCacheTTL)))
 		msg.Ns = append(msg.Ns, soa)
 
 		w.WriteMsg(msg)
@@ -124,7 +129,7 @@ func (s *Server) handleCacheMiss(w dns.ResponseWriter, r *dns.Msg, domain string
 			msg.SetRcode(r, dns.RcodeServerFailure)
 
 			// 添加 SOA 记录到 Authority section
-			soa := s.buildSOARecord(domain, uint32(currentCfg.Cache.ErrorCacheTTL))
+			soa := s.buildSOARecord(domain, uint32(s.cache.ClampErrorTTL(domain, currentCfg.Cache.ErrorCacheTTL)))
 			msg.Ns = append(msg.Ns, soa)
 
 			w.WriteMsg(msg)
@@ -148,6 +153,11 @@ func (s *Server) handleCacheMiss(w dns.ResponseWriter, r *dns.Msg, domain string
 		return // 请求被拦截
 	}
 
+	// [AdBlock] 对最终的 IP 列表进行 CIDR 检查，命中时不写入缓存
+	if s.handleResponseIPCheck(w, r, domain, finalIPs, currentCfg, adblockMgr) {
+		return // 请求被拦截
+	}
+
 	// 如果最终没有IP也没有CNAME，那就是 NODATA（域名存在但无此类型记录）
 	if len(finalIPs) == 0 && len(fullCNAMEs) == 0 {
 		logger.Debugf("[handleQuery] 上游查询返回空结果 (NODATA): %s", domain)
@@ -163,7 +173,7 @@ func (s *Server) handleCacheMiss(w dns.ResponseWriter, r *dns.Msg, domain string
 		msg.Answer = nil
 
 		// 添加 SOA 记录到 Authority section（符合 RFC 2308）
-		soa := s.buildSOARecord(domain, uint32(currentCfg.Cache.NegativeTTLSeconds))
+		soa := s.buildSOARecord(domain, uint32(s.cache.ClampErrorTTL(domain, currentCfg.Cache.NegativeTTLSeconds)))
 		msg.Ns = append(msg.Ns, soa)
 
 		w.WriteMsg(msg)
@@ -183,7 +193,7 @@ func (s *Server) handleCacheMiss(w dns.ResponseWriter, r *dns.Msg, domain string
 	if result.Records != nil {
 		finalRecords = result.Records
 	}
-	s.cache.SetRawRecordsWithDNSSEC(domain, qtype, finalRecords, fullCNAMEs, finalTTL, result.AuthenticatedData)
+	s.cache.SetRawRecordsWithSource(domain, qtype, finalRecords, fullCNAMEs, finalTTL, result.AuthenticatedData, result.Server)
 	if len(finalIPs) > 0 {
 		// 通知 Prefetcher 更新 IP 哈希
 		s.prefetcher.UpdateSimHash(domain, finalIPs)
@@ -204,6 +214,7 @@ func (s *Server) handleCacheMiss(w dns.ResponseWriter, r *dns.Msg, domain string
 		rankDomain = strings.TrimRight(fullCNAMEs[len(fullCNAMEs)-1], ".")
 	}
 	fallbackIPs := s.prefetcher.GetFallbackRank(rankDomain, finalIPs)
+	fallbackIPs = s.applySortRules(domain, fallbackIPs)
 	fastTTL := uint32(currentCfg.Cache.FastResponseTTL)
 
 	msg := s.msgPool.Get()
@@ -316,12 +327,23 @@ func (s *Server) handleQuery(w dns.ResponseWriter, r *dns.Msg) {
 		return // 请求已被本地规则处理
 	}
 
+	// 解析得到的应答在写出前按应答地址策略（RPZ-IP）检查
+	w = s.wrapResponsePolicy(w, r, domain, currentCfg, adblockMgr)
+	w = s.wrapDGAObserver(w, domain, currentCfg, adblockMgr)
+
+	// 安全搜索：搜索引擎域名改写为其安全搜索端点，端点地址继续走下面的缓存/上游/排序流程
+	var handled bool
+	if w, r, domain, handled = s.handleSafeSearch(w, r, domain, currentCfg); handled {
+		return
+	}
+	question = r.Question[0]
+
 	// 仅处理 A 和 AAAA 查询（暂时保留限制，后续会移除）
 	if qtype != dns.TypeA && qtype != dns.TypeAAAA {
 		// 对于非 A/AAAA 查询，尝试通用处理
 		ctx, cancel := context.WithTimeout(context.Background(), DefaultUpstreamTimeout)
 		defer cancel()
-		if s.handleGenericQuery(w, r, domain, qtype, ctx, currentUpstream, currentCfg, currentStats) {
+		if s.handleGenericQuery(w, r, domain, qtype, ctx, currentUpstream, currentCfg, currentStats, adblockMgr) {
 			return
 		}
 		// 如果通用处理失败，返回 NotImplemented
@@ -374,6 +396,11 @@ func (s *Server) handleQuery(w dns.ResponseWriter, r *dns.Msg) {
 		return
 	}
 
+	// 积极否定缓存（RFC 8198）只用于替代上游查询，放在正向缓存命中之后
+	if s.handleAggressiveNSEC(w, r, domain, qtype, currentCfg, currentStats) {
+		return
+	}
+
 	// ========== 第 5 阶段: 缓存未命中，执行首次查询 ==========
 	ctx, cancel := context.WithTimeout(context.Background(), DefaultUpstreamTimeout)
 	defer cancel()
@@ -382,7 +409,7 @@ func (s *Server) handleQuery(w dns.ResponseWriter, r *dns.Msg) {
 }
 
 // handleGenericQuery 处理非 A/AAAA 类型的通用查询
-func (s *Server) handleGenericQuery(w dns.ResponseWriter, r *dns.Msg, domain string, qtype uint16, ctx context.Context, currentUpstream *upstream.Manager, currentCfg *config.Config, currentStats *stats.Stats) bool {
+func (s *Server) handleGenericQuery(w dns.ResponseWriter, r *dns.Msg, domain string, qtype uint16, ctx context.Context, currentUpstream *upstream.Manager, currentCfg *config.Config, currentStats *stats.Stats, adblockMgr *adblock.AdBlockManager) bool {
 	logger.Debugf("[handleGenericQuery] 处理通用查询: %s (type=%s)", domain, dns.TypeToString[qtype])
 
 	// 检查错误缓存
@@ -395,13 +422,18 @@ func (s *Server) handleGenericQuery(w dns.ResponseWriter, r *dns.Msg, domain str
 		return true
 	}
 
+	// 检查积极否定缓存（仅在正向缓存未命中时替代上游查询）
+	if s.handleAggressiveNSEC(w, r, domain, qtype, currentCfg, currentStats) {
+		return true
+	}
+
 	// 缓存未命中，执行通用查询
-	s.handleGenericCacheMiss(w, r, domain, qtype, ctx, currentUpstream, currentCfg, currentStats)
+	s.handleGenericCacheMiss(w, r, domain, qtype, ctx, currentUpstream, currentCfg, currentStats, adblockMgr)
 	return true
 }
 
 // handleGenericCacheMiss 处理通用查询的缓存未命中情况
-func (s *Server) handleGenericCacheMiss(w dns.ResponseWriter, r *dns.Msg, domain string, qtype uint16, ctx context.Context, currentUpstream *upstream.Manager, currentCfg *config.Config, currentStats *stats.Stats) {
+func (s *Server) handleGenericCacheMiss(w dns.ResponseWriter, r *dns.Msg, domain string, qtype uint16, ctx context.Context, currentUpstream *upstream.Manager, currentCfg *config.Config, currentStats *stats.Stats, adblockMgr *adblock.AdBlockManager) {
 	currentStats.IncCacheMisses()
 
 	logger.Debugf("[handleGenericCacheMiss] 通用查询缓存未命中: %s (type=%s)", domain, dns.TypeToString[qtype])
@@ -430,14 +462,14 @@ func (s *Server) handleGenericCacheMiss(w dns.ResponseWriter, r *dns.Msg, domain
 			logger.Debugf("[handleGenericCacheMiss] NXDOMAIN 错误，缓存并返回: %s", domain)
 			msg.SetRcode(r, dns.RcodeNameError)
 			// 添加 SOA 记录到 Authority section（符合 RFC 2308）
-			soa := s.buildSOARecord(domain, uint32(currentCfg.Cache.ErrorCacheTTL))
+			soa := s.buildSOARecord(domain, uint32(s.cache.ClampErrorTTL(domain, currentCfg.Cache.ErrorCacheTTL)))
 			msg.Ns = append(msg.Ns, soa)
 		} else {
 			logger.Debugf("[handleGenericCacheMiss] SERVFAIL/超时错误，返回 SERVFAIL 响应: %s, Rcode=%d", domain, originalRcode)
 			msg.SetRcode(r, dns.RcodeServerFailure)
 
 			// 添加 SOA 记录到 Authority section
-			soa := s.buildSOARecord(domain, uint32(currentCfg.Cache.ErrorCacheTTL))
+			soa := s.buildSOARecord(domain, uint32(s.cache.ClampErrorTTL(domain, currentCfg.Cache.ErrorCacheTTL)))
 			msg.Ns = append(msg.Ns, soa)
 		}
 		w.WriteMsg(msg)
@@ -445,6 +477,10 @@ func (s *Server) handleGenericCacheMiss(w dns.ResponseWriter, r *dns.Msg, domain
 		return
 	}
 
+	if currentCfg.Upstream.Dnssec && currentCfg.Cache.AggressiveNSEC {
+		s.cache.AddNSECProofs(result.DnsMsg)
+	}
+
 	// 核心修复：检查上游返回的消息状态码（通用查询逻辑）
 	if result.DnsMsg != nil && result.DnsMsg.Rcode == dns.RcodeNameError {
 		logger.Debugf("[handleGenericCacheMiss] 上游查询返回 NXDOMAIN: %s", domain)
@@ -456,7 +492,7 @@ func (s *Server) handleGenericCacheMiss(w dns.ResponseWriter, r *dns.Msg, domain
 		msg.Compress = false
 		msg.SetRcode(r, dns.RcodeNameError)
 
-		soa := s.buildSOARecord(domain, uint32(currentCfg.Cache.ErrorCacheTTL))
+		soa := s.buildSOARecord(domain, uint32(s.cache.ClampErrorTTL(domain, currentCfg.Cache.ErrorCacheTTL)))
 		msg.Ns = append(msg.Ns, soa)
 
 		w.WriteMsg(msg)
@@ -464,12 +500,17 @@ func (s *Server) handleGenericCacheMiss(w dns.ResponseWriter, r *dns.Msg, domain
 		return
 	}
 
+	// [AdBlock] 记录中的地址（A/AAAA 及 HTTPS/SVCB 地址提示）命中 IP 列表时拦截，不写入缓存
+	if s.handleResponseIPCheck(w, r, domain, recordIPs(result.Records), currentCfg, adblockMgr) {
+		return
+	}
+
 	// 缓存通用记录
 	currentStats.RecordDomainQuery(domain)
 	logger.Debugf("[handleGenericCacheMiss] 通用查询结果: %s (type=%s) 获得 %d 条记录, CNAMEs=%v (TTL=%d秒)",
 		domain, dns.TypeToString[qtype], len(result.Records), result.CNAMEs, result.TTL)
 
-	s.cache.SetRawRecordsWithDNSSEC(domain, qtype, result.Records, result.CNAMEs, result.TTL, result.AuthenticatedData)
+	cached := s.cacheGenericResult(domain, qtype, result)
 
 	// 通知 Prefetcher 更新 IP 哈希（仅对 A/AAAA 记录）
 	if qtype == dns.TypeA || qtype == dns.TypeAAAA {
@@ -479,14 +520,36 @@ func (s *Server) handleGenericCacheMiss(w dns.ResponseWriter, r *dns.Msg, domain
 		}
 	}
 
+	authData := result.AuthenticatedData && currentCfg.Upstream.Dnssec
+	if cached != nil {
+		resp := s.buildCachedMsgResponse(r, cached, 0, 0, authData)
+		// HTTPS/SVCB 的地址提示只在响应副本上改写，缓存保持上游原貌，排序结果更新后下次命中即可生效
+		s.rewriteSVCBAnswer(resp, domain, &currentCfg.DNS.SVCB)
+		w.WriteMsg(resp)
+		return
+	}
+
 	// 构建通用响应
 	msg := s.msgPool.Get()
 	msg.SetReply(r)
 	msg.RecursionAvailable = true
 	msg.Compress = false
-	authData := result.AuthenticatedData && currentCfg.Upstream.Dnssec
 
 	s.buildGenericResponse(msg, result.CNAMEs, result.Records, qtype, result.TTL, authData)
+	s.rewriteSVCBAnswer(msg, domain, &currentCfg.DNS.SVCB)
 	w.WriteMsg(msg)
 	s.msgPool.Put(msg)
 }
+
+// cacheGenericResult 缓存通用记录类型的上游结果，有完整应答时连同去重后的消息一起保存
+// 返回缓存的消息，上游未返回消息时为 nil
+func (s *Server) cacheGenericResult(domain string, qtype uint16, result *upstream.QueryResultWithTTL) *dns.Msg {
+	if result.DnsMsg == nil {
+		s.cache.SetRawRecordsWithSource(domain, qtype, result.Records, result.CNAMEs, result.TTL, result.AuthenticatedData, result.Server)
+		return nil
+	}
+	msg := result.DnsMsg.Copy()
+	s.deduplicateDNSMsg(msg)
+	s.cache.SetRawMessage(domain, qtype, msg, result.Records, result.CNAMEs, result.TTL, result.AuthenticatedData, result.Server)
+	return msg
+}
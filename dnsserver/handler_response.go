@@ -324,3 +324,66 @@ func (s *Server) deduplicateRecords(records []dns.RR) []dns.RR {
 
 	return uniqueRecords
 }
+
+// buildCachedMsgResponse 用缓存的上游完整应答构造回复，保留 Answer/Authority/Additional 三段
+//   - 各记录 TTL 按经过时间独立递减；ceiling > 0 时限制在 ceiling 以内，已递减到 0 的记录使用 ceiling
+//     （本地 min_ttl 延长了缓存寿命、或处于软过期时由调用方决定返回的 TTL）
+//   - 上游的 OPT 记录不转发，客户端带 EDNS 时按其 DO 位重新附加
+//   - 客户端未设置 DO 时去除 RRSIG/NSEC/NSEC3（RFC 4035 3.2.1），除非查询的正是这些类型
+func (s *Server) buildCachedMsgResponse(r *dns.Msg, cached *dns.Msg, elapsed time.Duration, ceiling uint32, authData bool) *dns.Msg {
+	resp := cached.Copy()
+	resp.Id = r.Id
+	resp.Response = true
+	resp.Opcode = r.Opcode
+	resp.RecursionDesired = r.RecursionDesired
+	resp.RecursionAvailable = true
+	resp.CheckingDisabled = r.CheckingDisabled
+	resp.AuthenticatedData = authData
+	resp.Compress = false
+	// 回显客户端的问题段，保留其大小写（0x20 编码）
+	resp.Question = append([]dns.Question(nil), r.Question...)
+
+	qtype := r.Question[0].Qtype
+	opt := r.IsEdns0()
+	do := opt != nil && opt.Do()
+	elapsedSec := int64(elapsed.Seconds())
+	rewrite := func(rrs []dns.RR) []dns.RR {
+		out := rrs[:0]
+		for _, rr := range rrs {
+			h := rr.Header()
+			if h.Rrtype == dns.TypeOPT || (!do && h.Rrtype != qtype && isDNSSECRecordType(h.Rrtype)) {
+				continue
+			}
+			ttl := int64(h.Ttl) - elapsedSec
+			if ceiling > 0 && (ttl <= 0 || ttl > int64(ceiling)) {
+				ttl = int64(ceiling)
+			}
+			h.Ttl = uint32(max(ttl, 1))
+			out = append(out, rr)
+		}
+		return out
+	}
+	resp.Answer = rewrite(resp.Answer)
+	resp.Ns = rewrite(resp.Ns)
+	resp.Extra = rewrite(resp.Extra)
+
+	if opt != nil {
+		resp.SetEdns0(max(opt.UDPSize(), dns.MinMsgSize), do)
+	}
+	return resp
+}
+
+// isDNSSECRecordType 判断是否为只应返回给 DO 客户端的 DNSSEC 记录类型
+func isDNSSECRecordType(rrtype uint16) bool {
+	switch rrtype {
+	case dns.TypeRRSIG, dns.TypeNSEC, dns.TypeNSEC3:
+		return true
+	}
+	return false
+}
+
+// msgHasDO 判断缓存的上游应答是否以 DO 方式获取（带有 DNSSEC 记录）
+func msgHasDO(m *dns.Msg) bool {
+	opt := m.IsEdns0()
+	return opt != nil && opt.Do()
+}
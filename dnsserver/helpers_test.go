@@ -0,0 +1,23 @@
+package dnsserver
+
+import (
+	"smartdnssort/config"
+	"smartdnssort/stats"
+	"testing"
+)
+
+// newTestServer 构造测试共用的最小服务器，返回的配置与服务器共享，可在测试中按需修改
+func newTestServer(t *testing.T) (*Server, *config.Config) {
+	t.Helper()
+	cfg := &config.Config{
+		Cache:    config.CacheConfig{FastResponseTTL: 15, ErrorCacheTTL: 60},
+		Upstream: config.UpstreamConfig{TimeoutMs: 1000},
+		Stats: config.StatsConfig{
+			HotDomainsWindowHours:   1,
+			HotDomainsBucketMinutes: 10,
+			HotDomainsShardCount:    4,
+			HotDomainsMaxPerBucket:  100,
+		},
+	}
+	return NewServer(cfg, stats.NewStats(&cfg.Stats)), cfg
+}
@@ -2,6 +2,9 @@ package dnsserver
 
 import (
 	"context"
+	"smartdnssort/adblock"
+	"smartdnssort/cache"
+	"smartdnssort/config"
 	"smartdnssort/logger"
 	"time"
 
@@ -35,6 +38,11 @@ func (s *Server) refreshCacheAsync(task RefreshTask) {
 		req.SetEdns0(4096, true)
 	}
 
+	s.mu.RLock()
+	cfg := s.cfg
+	adblockMgr := s.adblockManager
+	s.mu.RUnlock()
+
 	// Step 1: Initial query to upstream
 	result, err := s.upstream.Query(ctx, req, dnssec)
 	if err != nil {
@@ -42,6 +50,19 @@ func (s *Server) refreshCacheAsync(task RefreshTask) {
 		return
 	}
 
+	// 通用记录类型不做 CNAME 递归和测速排序，直接缓存上游完整应答
+	if qtype != dns.TypeA && qtype != dns.TypeAAAA {
+		if result.DnsMsg != nil && result.DnsMsg.Rcode != dns.RcodeSuccess {
+			logger.Debugf("[refreshCacheAsync] 通用记录刷新返回 %s，保留原缓存: %s", dns.RcodeToString[result.DnsMsg.Rcode], domain)
+			return
+		}
+		if s.dropBlockedRefresh(domain, qtype, recordIPs(result.Records), cfg, adblockMgr) {
+			return
+		}
+		s.cacheGenericResult(domain, qtype, result)
+		return
+	}
+
 	var finalIPs []string
 	var fullCNAMEs []string
 	var finalTTL uint32
@@ -78,6 +99,10 @@ func (s *Server) refreshCacheAsync(task RefreshTask) {
 		return
 	}
 
+	if s.dropBlockedRefresh(domain, qtype, finalIPs, cfg, adblockMgr) {
+		return
+	}
+
 	logger.Debugf("[refreshCacheAsync] 刷新成功: %s -> %v -> %v (TTL: %d)", domain, fullCNAMEs, finalIPs, finalTTL)
 
 	// 只为原始查询域名创建缓存，不为CNAME链中的其他域名创建缓存
@@ -87,13 +112,25 @@ func (s *Server) refreshCacheAsync(task RefreshTask) {
 	// 获取完整的 DNS 记录（包括 TXT、MX、SRV 等）
 	// 这是修复 Bug 的关键：使用 SetRawRecords 而不是 SetRaw
 	// SetRaw 会将 Records 字段设置为 nil，导致非 IP 记录在刷新后丢失
-	s.cache.SetRawRecords(domain, qtype, recordsToCache, fullCNAMEs, finalTTL)
+	s.cache.SetRawRecordsWithSource(domain, qtype, recordsToCache, fullCNAMEs, finalTTL, false, result.Server)
 
 	// 通知 Prefetcher 更新 IP 哈希
 	s.prefetcher.UpdateSimHash(domain, finalIPs)
 	go s.sortIPsAsync(domain, qtype, finalIPs, finalTTL, time.Now())
 }
 
+// dropBlockedRefresh 刷新（含预取、预热）得到的地址命中 IP 列表时不写入缓存，并删除该域名原有的原始缓存与排序结果，
+// 与首次解析一样由拦截缓存应答之后的查询
+func (s *Server) dropBlockedRefresh(domain string, qtype uint16, ips []string, cfg *config.Config, adblockMgr *adblock.AdBlockManager) bool {
+	rule, blocked := s.checkResponseIPs(domain, ips, cfg, adblockMgr)
+	if !blocked {
+		return false
+	}
+	s.cache.Purge(cache.EntryFilter{Name: domain, Qtype: qtype})
+	logger.Debugf("[refreshCacheAsync] 刷新结果命中 IP 列表 %s，丢弃缓存: %s (type=%s)", rule, domain, dns.TypeToString[qtype])
+	return true
+}
+
 // RefreshDomain is the public method to trigger a cache refresh for a domain.
 // It satisfies the prefetch.Refresher interface.
 func (s *Server) RefreshDomain(domain string, qtype uint16) {
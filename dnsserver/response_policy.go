@@ -0,0 +1,112 @@
+package dnsserver
+
+import (
+	"net"
+	"net/netip"
+	"smartdnssort/adblock"
+	"smartdnssort/config"
+	"smartdnssort/logger"
+
+	"github.com/miekg/dns"
+)
+
+// responsePolicyWriter 在应答写出前按应答中的地址检查响应策略（RPZ-IP 触发器）
+// 缓存命中与上游解析的应答都经由它写出，命中策略时以改写结果替换原应答
+type responsePolicyWriter struct {
+	dns.ResponseWriter
+	s          *Server
+	r          *dns.Msg
+	domain     string
+	cfg        *config.Config
+	adblockMgr *adblock.AdBlockManager
+}
+
+// wrapResponsePolicy 仅在存在应答地址策略时包装 ResponseWriter，否则原样返回
+func (s *Server) wrapResponsePolicy(w dns.ResponseWriter, r *dns.Msg, domain string, cfg *config.Config, adblockMgr *adblock.AdBlockManager) dns.ResponseWriter {
+	if adblockMgr == nil || !adblockMgr.Enabled() || !adblockMgr.HasResponsePolicy() {
+		return w
+	}
+	return &responsePolicyWriter{ResponseWriter: w, s: s, r: r, domain: domain, cfg: cfg, adblockMgr: adblockMgr}
+}
+
+func (pw *responsePolicyWriter) WriteMsg(msg *dns.Msg) error {
+	ips := answerIPs(msg)
+	if len(ips) == 0 {
+		return pw.ResponseWriter.WriteMsg(msg)
+	}
+
+	res := pw.adblockMgr.CheckResponse(&adblock.Request{
+		Host:     pw.domain,
+		QType:    pw.r.Question[0].Qtype,
+		ClientIP: clientAddr(pw.ResponseWriter),
+		TCP:      isTCP(pw.ResponseWriter),
+	}, ips)
+	if res.Rewrite == nil {
+		return pw.ResponseWriter.WriteMsg(msg)
+	}
+
+	logger.Debugf("[AdBlock] Response rewritten: %s (rule: %s)", pw.domain, res.Rule)
+	if res.Match == adblock.MatchBlocked {
+		pw.adblockMgr.RecordBlock(pw.domain, res.Rule)
+		pw.s.stats.RecordBlockedDomain(pw.domain)
+		pw.s.cache.GetRecentlyBlocked().Add(pw.domain)
+	}
+	pw.s.sendRewriteResponse(pw.ResponseWriter, pw.r, res.Rewrite, pw.cfg.AdBlock.BlockedTTL)
+	return nil
+}
+
+// dgaObserverWriter 把最终应答反馈给 DGA 检测器：NXDOMAIN（包括错误缓存命中）计入客户端的突发率，
+// 学习模式下有应答记录的域名用于训练模型
+type dgaObserverWriter struct {
+	dns.ResponseWriter
+	dga    *adblock.DGADetector
+	domain string
+}
+
+// wrapDGAObserver 仅在启用 DGA 检测时包装 ResponseWriter，否则原样返回
+func (s *Server) wrapDGAObserver(w dns.ResponseWriter, domain string, cfg *config.Config, adblockMgr *adblock.AdBlockManager) dns.ResponseWriter {
+	if adblockMgr == nil || !adblockMgr.Enabled() {
+		return w
+	}
+	dga := adblockMgr.DGA()
+	if dga == nil {
+		return w
+	}
+	return &dgaObserverWriter{ResponseWriter: w, dga: dga, domain: domain}
+}
+
+func (ow *dgaObserverWriter) WriteMsg(msg *dns.Msg) error {
+	if msg != nil {
+		ow.dga.RecordResponse(ow.domain, clientAddr(ow.ResponseWriter), msg.Rcode, len(msg.Answer) > 0)
+	}
+	return ow.ResponseWriter.WriteMsg(msg)
+}
+
+// answerIPs 提取应答中的 A/AAAA 地址
+func answerIPs(msg *dns.Msg) []netip.Addr {
+	if msg == nil || msg.Rcode != dns.RcodeSuccess {
+		return nil
+	}
+	var ips []netip.Addr
+	for _, rr := range msg.Answer {
+		var ip net.IP
+		switch v := rr.(type) {
+		case *dns.A:
+			ip = v.A
+		case *dns.AAAA:
+			ip = v.AAAA
+		default:
+			continue
+		}
+		if addr, ok := netip.AddrFromSlice(ip); ok {
+			ips = append(ips, addr.Unmap())
+		}
+	}
+	return ips
+}
+
+// isTCP 返回请求是否经由 TCP 到达
+func isTCP(w dns.ResponseWriter) bool {
+	_, ok := w.RemoteAddr().(*net.TCPAddr)
+	return ok
+}
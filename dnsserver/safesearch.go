@@ -0,0 +1,138 @@
+package dnsserver
+
+import (
+	"net/netip"
+	"strings"
+
+	"smartdnssort/adblock"
+	"smartdnssort/config"
+	"smartdnssort/logger"
+
+	"github.com/miekg/dns"
+)
+
+// safeSearchTTL 安全搜索改写中 CNAME 记录的 TTL（秒）
+const safeSearchTTL = 300
+
+// 各搜索引擎的安全搜索端点
+const (
+	safeSearchGoogle          = "forcesafesearch.google.com"
+	safeSearchBing            = "strict.bing.com"
+	safeSearchDuckDuckGo      = "safe.duckduckgo.com"
+	safeSearchYouTubeStrict   = "restrict.youtube.com"
+	safeSearchYouTubeModerate = "restrictmoderate.youtube.com"
+)
+
+var (
+	safeSearchBingHosts       = map[string]bool{"bing.com": true, "www.bing.com": true}
+	safeSearchDuckDuckGoHosts = map[string]bool{"duckduckgo.com": true, "www.duckduckgo.com": true, "start.duckduckgo.com": true}
+	safeSearchYouTubeHosts    = map[string]bool{
+		"www.youtube.com":          true,
+		"m.youtube.com":            true,
+		"youtubei.googleapis.com":  true,
+		"youtube.googleapis.com":   true,
+		"www.youtube-nocookie.com": true,
+	}
+)
+
+// safeSearchTarget 返回域名对应的安全搜索端点；未启用对应引擎或不是搜索引擎域名时返回空字符串
+func safeSearchTarget(cfg *config.SafeSearchConfig, domain string) string {
+	if !cfg.Enable {
+		return ""
+	}
+	domain = strings.ToLower(strings.TrimRight(domain, "."))
+	switch {
+	case cfg.Google && isGoogleSearchHost(domain):
+		return safeSearchGoogle
+	case cfg.Bing && safeSearchBingHosts[domain]:
+		return safeSearchBing
+	case cfg.DuckDuckGo && safeSearchDuckDuckGoHosts[domain]:
+		return safeSearchDuckDuckGo
+	case cfg.YouTube && safeSearchYouTubeHosts[domain]:
+		if cfg.YouTubeMode == "moderate" {
+			return safeSearchYouTubeModerate
+		}
+		return safeSearchYouTubeStrict
+	}
+	return ""
+}
+
+// isGoogleSearchHost 匹配 google.<tld> 与 www.google.<tld>，tld 为 com、两字母国家域名或 co.xx/com.xx
+func isGoogleSearchHost(domain string) bool {
+	rest, ok := strings.CutPrefix(strings.TrimPrefix(domain, "www."), "google.")
+	if !ok {
+		return false
+	}
+	if rest == "com" {
+		return true
+	}
+	if sld, cc, found := strings.Cut(rest, "."); found {
+		if sld != "co" && sld != "com" {
+			return false
+		}
+		rest = cc
+	}
+	return len(rest) == 2 && rest[0] >= 'a' && rest[0] <= 'z' && rest[1] >= 'a' && rest[1] <= 'z'
+}
+
+// safeSearchAppliesTo 判断安全搜索是否对该客户端生效，未配置客户端列表时对所有客户端生效
+func safeSearchAppliesTo(clients []string, ip netip.Addr) bool {
+	if len(clients) == 0 {
+		return true
+	}
+	if !ip.IsValid() {
+		return false
+	}
+	for _, c := range clients {
+		c = strings.TrimSpace(c)
+		if p, err := netip.ParsePrefix(c); err == nil {
+			if p.Contains(ip) {
+				return true
+			}
+		} else if addr, err := netip.ParseAddr(c); err == nil && addr.Unmap() == ip {
+			return true
+		}
+	}
+	return false
+}
+
+// handleSafeSearch 将搜索引擎域名改写为其安全搜索端点
+// 非 A/AAAA 查询直接以 CNAME 应答，由客户端继续解析目标；
+// A/AAAA 查询改为查询端点本身并返回改写后的请求与 ResponseWriter，后续缓存/上游/排序流程照常进行，
+// 写出应答时附加 CNAME 并还原原始问题。handled 为 true 表示请求已处理
+func (s *Server) handleSafeSearch(w dns.ResponseWriter, r *dns.Msg, domain string, cfg *config.Config) (dns.ResponseWriter, *dns.Msg, string, bool) {
+	target := safeSearchTarget(&cfg.DNS.SafeSearch, domain)
+	if target == "" || !safeSearchAppliesTo(cfg.DNS.SafeSearch.Clients, clientAddr(w)) {
+		return w, r, domain, false
+	}
+
+	logger.Debugf("[SafeSearch] %s -> %s", domain, target)
+	s.stats.IncSafeSearch()
+
+	question := r.Question[0]
+	if question.Qtype != dns.TypeA && question.Qtype != dns.TypeAAAA {
+		s.sendRewriteResponse(w, r, &adblock.Rewrite{CNAME: dns.Fqdn(target)}, safeSearchTTL)
+		return w, r, domain, true
+	}
+
+	req := r.Copy()
+	req.Question[0].Name = dns.Fqdn(target)
+	return &safeSearchWriter{ResponseWriter: w, question: question, target: dns.Fqdn(target)}, req, target, false
+}
+
+// safeSearchWriter 在安全搜索端点的应答前附加原始域名的 CNAME，并把问题还原为客户端的原始问题
+type safeSearchWriter struct {
+	dns.ResponseWriter
+	question dns.Question
+	target   string
+}
+
+func (sw *safeSearchWriter) WriteMsg(msg *dns.Msg) error {
+	out := *msg // 浅拷贝，不修改调用方（可能来自对象池或缓存）的消息
+	out.Question = []dns.Question{sw.question}
+	out.Answer = append([]dns.RR{&dns.CNAME{
+		Hdr:    dns.RR_Header{Name: sw.question.Name, Rrtype: dns.TypeCNAME, Class: dns.ClassINET, Ttl: safeSearchTTL},
+		Target: sw.target,
+	}}, msg.Answer...)
+	return sw.ResponseWriter.WriteMsg(&out)
+}
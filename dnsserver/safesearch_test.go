@@ -0,0 +1,101 @@
+package dnsserver
+
+import (
+	"net/netip"
+	"smartdnssort/config"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestSafeSearchTarget(t *testing.T) {
+	cfg := &config.SafeSearchConfig{Enable: true, Google: true, Bing: true, DuckDuckGo: true, YouTube: true}
+
+	tests := []struct {
+		domain string
+		want   string
+	}{
+		{"www.google.com", "forcesafesearch.google.com"},
+		{"google.de", "forcesafesearch.google.com"},
+		{"WWW.Google.co.uk.", "forcesafesearch.google.com"},
+		{"www.google.com.hk", "forcesafesearch.google.com"},
+		{"mail.google.com", ""},
+		{"google.org", ""},
+		{"forcesafesearch.google.com", ""},
+		{"www.bing.com", "strict.bing.com"},
+		{"start.duckduckgo.com", "safe.duckduckgo.com"},
+		{"m.youtube.com", "restrict.youtube.com"},
+		{"youtubei.googleapis.com", "restrict.youtube.com"},
+		{"music.youtube.com", ""},
+		{"example.com", ""},
+	}
+	for _, tt := range tests {
+		if got := safeSearchTarget(cfg, tt.domain); got != tt.want {
+			t.Errorf("safeSearchTarget(%q) = %q, want %q", tt.domain, got, tt.want)
+		}
+	}
+
+	cfg.YouTubeMode = "moderate"
+	cfg.Bing = false
+	if got := safeSearchTarget(cfg, "www.youtube.com"); got != "restrictmoderate.youtube.com" {
+		t.Errorf("moderate mode should use restrictmoderate.youtube.com, got %q", got)
+	}
+	if got := safeSearchTarget(cfg, "www.bing.com"); got != "" {
+		t.Errorf("disabled engine should not be rewritten, got %q", got)
+	}
+	cfg.Enable = false
+	if got := safeSearchTarget(cfg, "www.google.com"); got != "" {
+		t.Errorf("disabled safe search should not rewrite, got %q", got)
+	}
+}
+
+func TestSafeSearchAppliesTo(t *testing.T) {
+	clients := []string{"192.168.1.50", "10.0.0.0/8"}
+	if !safeSearchAppliesTo(nil, netip.Addr{}) {
+		t.Error("empty client list should apply to everyone")
+	}
+	if !safeSearchAppliesTo(clients, netip.MustParseAddr("192.168.1.50")) || !safeSearchAppliesTo(clients, netip.MustParseAddr("10.1.2.3")) {
+		t.Error("listed clients should be covered")
+	}
+	if safeSearchAppliesTo(clients, netip.MustParseAddr("192.168.1.51")) || safeSearchAppliesTo(clients, netip.Addr{}) {
+		t.Error("unlisted or unknown clients should not be covered")
+	}
+}
+
+// TestHandleQuery_SafeSearch 验证 A 查询经端点的缓存应答返回，并附加 CNAME、还原原始问题
+func TestHandleQuery_SafeSearch(t *testing.T) {
+	server, cfg := newTestServer(t)
+	cfg.DNS.SafeSearch = config.SafeSearchConfig{Enable: true, Google: true}
+	server.cache.SetRaw("forcesafesearch.google.com", dns.TypeA, []string{"216.239.38.120"}, nil, 300)
+
+	req := new(dns.Msg)
+	req.SetQuestion("www.google.com.", dns.TypeA)
+	w := &capturingResponseWriter{}
+	server.handleQuery(w, req)
+
+	msg := w.LastMsg
+	if msg == nil || len(msg.Answer) != 2 {
+		t.Fatalf("expected CNAME + A answer, got %v", msg)
+	}
+	if msg.Question[0].Name != "www.google.com." {
+		t.Errorf("question should be restored, got %s", msg.Question[0].Name)
+	}
+	cname, ok := msg.Answer[0].(*dns.CNAME)
+	if !ok || cname.Hdr.Name != "www.google.com." || cname.Target != "forcesafesearch.google.com." {
+		t.Errorf("first answer should be the safe-search CNAME, got %v", msg.Answer[0])
+	}
+	if a, ok := msg.Answer[1].(*dns.A); !ok || a.A.String() != "216.239.38.120" {
+		t.Errorf("second answer should be the endpoint address, got %v", msg.Answer[1])
+	}
+
+	req = new(dns.Msg)
+	req.SetQuestion("www.google.com.", dns.TypeHTTPS)
+	server.handleQuery(w, req)
+	if msg := w.LastMsg; len(msg.Answer) != 1 || msg.Answer[0].Header().Rrtype != dns.TypeCNAME {
+		t.Errorf("non-address queries should get only the CNAME, got %v", msg)
+	}
+
+	if got := server.stats.GetStats()["safe_search_queries"].(int64); got != 2 {
+		t.Errorf("safe_search_queries = %d, want 2", got)
+	}
+}
@@ -2,9 +2,11 @@ package dnsserver
 
 import (
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"smartdnssort/adblock"
+	"smartdnssort/blockpage"
 	"smartdnssort/cache"
 	"smartdnssort/config"
 	"smartdnssort/connectivity"
@@ -12,6 +14,7 @@ import (
 	"smartdnssort/ping"
 	"smartdnssort/prefetch"
 	"smartdnssort/recursor"
+	"smartdnssort/replication"
 	"smartdnssort/stats"
 	"smartdnssort/upstream"
 
@@ -42,8 +45,16 @@ type Server struct {
 	tcpServer          *dns.Server                       // Used in: server_lifecycle.go
 	adblockManager     *adblock.AdBlockManager           // 广告拦截管理器
 	customRespManager  *CustomResponseManager            // 自定义回复管理器
+	sortRuleManager    *SortRuleManager                  // 手动 IP 排序规则（固定/偏好/降级/排除）
 	recursorMgr        *recursor.Manager                 // 嵌入式递归解析器管理器
 	ipMonitor          *ping.IPMonitor                   // IP 主动巡检调度器
+	geoRanker          *ping.GeoIPRanker                 // 离线 GeoIP/ASN 排序器（未启用时为 nil）
+	probeBudget        *ping.ProbeBudget                 // 全局探测预算，跨 Pinger 重建保持不变
+	bandwidthProber    *ping.BandwidthProber             // 大文件域名吞吐量探测器（未启用时为 nil）
+	replicator         *replication.Replicator           // 多实例缓存复制（未启用时为 nil）
+	replicationPool    atomic.Pointer[ping.IPPool]       // 当前 Pinger 的 IP 池，供复制器无锁读取
+	blockPage          atomic.Pointer[blockpage.Server]  // 拦截页服务器（未启用时为 nil），拦截应答路径无锁读取
+	warmup             *warmupRunner                     // 启动预热配置与进度
 	stopCh             chan struct{}                     // 用于优雅关闭后台 goroutine
 	sortSemaphore      chan struct{}                     // 限制并发排序任务数量（最多 50 个）
 	networkChecker     connectivity.NetworkHealthChecker // 网络健康检查器（用于静默隔离）
@@ -54,6 +65,13 @@ func (s *Server) GetCustomResponseManager() *CustomResponseManager {
 	return s.customRespManager
 }
 
+// GetSortRuleManager returns the manual IP sort rule manager
+func (s *Server) GetSortRuleManager() *SortRuleManager {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.sortRuleManager
+}
+
 // GetStats 获取统计信息
 func (s *Server) GetStats() map[string]interface{} {
 	s.mu.RLock()
@@ -90,6 +108,9 @@ func (s *Server) ClearStats() {
 	if s.upstream != nil {
 		s.upstream.ClearStats()
 	}
+	if s.adblockManager != nil {
+		s.adblockManager.ClearRuleStats()
+	}
 }
 
 // RecordRecentQuery adds a domain to the recent queries list.
@@ -174,14 +195,42 @@ func (s *Server) GetAdBlockManager() *adblock.AdBlockManager {
 }
 
 // SetAdBlockEnabled dynamically enables or disables AdBlock filtering
+// cfg.AdBlock.Enable 只记录配置值，生效状态由 AdBlockManager 维护：暂停期间切换只在暂停结束后生效
 func (s *Server) SetAdBlockEnabled(enabled bool) {
 	s.mu.Lock()
-	defer s.mu.Unlock()
-
 	s.cfg.AdBlock.Enable = enabled
+	mgr := s.adblockManager
+	s.mu.Unlock()
+
+	if mgr != nil {
+		mgr.SetEnabled(enabled)
+	}
 	logger.Debugf("[AdBlock] Filtering status changed to: %v", enabled)
 }
 
+// PauseAdBlock 暂停过滤 d 时长，到期自动恢复；暂停与恢复时都会清空拦截/白名单缓存
+func (s *Server) PauseAdBlock(d time.Duration) (time.Time, bool) {
+	mgr := s.GetAdBlockManager()
+	if mgr == nil {
+		return time.Time{}, false
+	}
+	until := mgr.Pause(d, s.cache.ClearAdBlockCaches)
+	s.cache.ClearAdBlockCaches()
+	logger.Infof("[AdBlock] Filtering paused until %s", until.Format(time.RFC3339))
+	return until, true
+}
+
+// ResumeAdBlock 立即结束暂停
+func (s *Server) ResumeAdBlock() bool {
+	mgr := s.GetAdBlockManager()
+	if mgr == nil || !mgr.Resume() {
+		return false
+	}
+	s.cache.ClearAdBlockCaches()
+	logger.Info("[AdBlock] Pause cancelled, filtering restored")
+	return true
+}
+
 // GetRecursorManager returns the recursor manager instance
 func (s *Server) GetRecursorManager() *recursor.Manager {
 	s.mu.RLock()
@@ -202,3 +251,22 @@ func (s *Server) GetIPMonitor() *ping.IPMonitor {
 	defer s.mu.RUnlock()
 	return s.ipMonitor
 }
+
+// GetGeoIPRanker returns the offline GeoIP ranker (nil if disabled)
+func (s *Server) GetGeoIPRanker() *ping.GeoIPRanker {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.geoRanker
+}
+
+// GetBandwidthProber returns the throughput prober (nil if disabled)
+func (s *Server) GetBandwidthProber() *ping.BandwidthProber {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.bandwidthProber
+}
+
+// GetProbeBudget returns the global probe budget shared by all probers
+func (s *Server) GetProbeBudget() *ping.ProbeBudget {
+	return s.probeBudget
+}
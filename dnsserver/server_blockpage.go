@@ -0,0 +1,65 @@
+package dnsserver
+
+import (
+	"time"
+
+	"smartdnssort/blockpage"
+	"smartdnssort/config"
+	"smartdnssort/logger"
+
+	"github.com/miekg/dns"
+)
+
+// newBlockPage 根据配置创建拦截页服务器，未启用或配置无效时返回 nil
+func newBlockPage(cfg *config.AdBlockConfig) *blockpage.Server {
+	if !cfg.BlockPage.Enable {
+		return nil
+	}
+	bp, err := blockpage.New(blockpage.Options{
+		HTTPAddr:   cfg.BlockPage.HTTPListen,
+		HTTPSAddr:  cfg.BlockPage.HTTPSListen,
+		CACertFile: cfg.BlockPage.CACert,
+		CAKeyFile:  cfg.BlockPage.CAKey,
+		// 客户端最长按 blocked_ttl 缓存拦截应答，拦截记录至少保留同样长的时间
+		RecentTTL: time.Duration(cfg.BlockedTTL) * time.Second,
+	})
+	if err != nil {
+		logger.Errorf("[BlockPage] Disabled: %v", err)
+		return nil
+	}
+	return bp
+}
+
+// startBlockPage 启动拦截页服务器，启动失败时不再把拦截应答指向本机
+func (s *Server) startBlockPage(bp *blockpage.Server) {
+	if bp != nil {
+		if err := bp.Start(); err != nil {
+			logger.Errorf("[BlockPage] Failed to start: %v", err)
+			bp = nil
+		}
+	}
+	s.blockPage.Store(bp)
+}
+
+// GetBlockPage returns the block page server (nil if disabled)
+func (s *Server) GetBlockPage() *blockpage.Server {
+	return s.blockPage.Load()
+}
+
+// sendBlockedResponse 发送拦截应答；拦截页启用时 A/AAAA 查询指向拦截页地址并记录命中规则，
+// 其他查询类型按 block_mode 应答
+func (s *Server) sendBlockedResponse(w dns.ResponseWriter, r *dns.Msg, domain, rule string, ttl int, cfg *config.Config) {
+	if bp := s.blockPage.Load(); bp != nil {
+		switch r.Question[0].Qtype {
+		case dns.TypeA, dns.TypeAAAA:
+			bp.Remember(domain, rule)
+			ip := cfg.AdBlock.BlockPage.IPv4
+			if r.Question[0].Qtype == dns.TypeAAAA {
+				ip = cfg.AdBlock.BlockPage.IPv6
+			}
+			buildZeroIPResponse(w, r, ip, ttl, s.msgPool)
+			return
+		}
+	}
+	s.sendAdBlockResponse(w, r, cfg.AdBlock.BlockMode, ttl, cfg.AdBlock.BlockedResponseIP)
+}
@@ -0,0 +1,49 @@
+package dnsserver
+
+import (
+	"smartdnssort/blockpage"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestSendBlockedResponse_BlockPage(t *testing.T) {
+	server, cfg := newTestServer(t)
+	cfg.AdBlock.BlockMode = "nxdomain"
+	cfg.AdBlock.BlockPage.IPv4 = "192.168.1.2"
+
+	req := new(dns.Msg)
+	req.SetQuestion("ads.example.com.", dns.TypeA)
+	w := &capturingResponseWriter{}
+	server.sendBlockedResponse(w, req, "ads.example.com", "||ads.example.com^", 60, cfg)
+	if w.LastMsg.Rcode != dns.RcodeNameError {
+		t.Errorf("without a block page the block mode should apply, got %v", w.LastMsg)
+	}
+
+	bp, err := blockpage.New(blockpage.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.blockPage.Store(bp)
+
+	server.sendBlockedResponse(w, req, "ads.example.com", "||ads.example.com^", 60, cfg)
+	if a, ok := w.LastMsg.Answer[0].(*dns.A); !ok || a.A.String() != "192.168.1.2" {
+		t.Errorf("A answer should point at the block page, got %v", w.LastMsg)
+	}
+	if rule, ok := bp.Lookup("ads.example.com"); !ok || rule != "||ads.example.com^" {
+		t.Errorf("blocked domain should be remembered for the block page, got %q %v", rule, ok)
+	}
+
+	// 未配置 IPv6 地址时 AAAA 返回空应答
+	req.SetQuestion("ads.example.com.", dns.TypeAAAA)
+	server.sendBlockedResponse(w, req, "ads.example.com", "||ads.example.com^", 60, cfg)
+	if w.LastMsg.Rcode != dns.RcodeSuccess || len(w.LastMsg.Answer) != 0 {
+		t.Errorf("AAAA without an IPv6 address should get NODATA, got %v", w.LastMsg)
+	}
+
+	req.SetQuestion("ads.example.com.", dns.TypeMX)
+	server.sendBlockedResponse(w, req, "ads.example.com", "||ads.example.com^", 60, cfg)
+	if w.LastMsg.Rcode != dns.RcodeNameError {
+		t.Errorf("other query types should follow the block mode, got %v", w.LastMsg)
+	}
+}
@@ -12,6 +12,7 @@ import (
 	"smartdnssort/ping"
 	"smartdnssort/prefetch"
 	"smartdnssort/recursor"
+	"smartdnssort/replication"
 	"smartdnssort/upstream"
 	"smartdnssort/upstream/bootstrap"
 )
@@ -85,6 +86,38 @@ func (s *Server) ApplyConfig(newCfg *config.Config) error {
 	if !reflect.DeepEqual(s.cfg.Ping, newCfg.Ping) {
 		logger.Debug("Reloading Pinger due to configuration changes.")
 		newPinger = ping.NewPinger(newCfg.Ping.Count, newCfg.Ping.TimeoutMs, newCfg.Ping.Concurrency, newCfg.Ping.MaxTestIPs, newCfg.Ping.RttCacheTtlSeconds, newCfg.Ping.EnableHttpFallback, "adblock_cache/ip_failure_weights.json")
+		// 预算实例跨 Pinger 重建保留，已用量和排队状态不丢失
+		newPinger.SetProbeBudget(s.probeBudget)
+		newPinger.SetProber(newProber(&newCfg.Ping.Prober), newCfg.Ping.Prober.BatchSize)
+	}
+
+	if !reflect.DeepEqual(s.cfg.Ping.ProbeBudget, newCfg.Ping.ProbeBudget) {
+		budgetCfg := newCfg.Ping.ProbeBudget
+		logger.Debugf("Updating probe budget: %d pkt/s, %d bytes/h", budgetCfg.PacketsPerSecond, budgetCfg.BytesPerHour)
+		s.probeBudget.SetLimits(budgetCfg.PacketsPerSecond, budgetCfg.BytesPerHour, time.Duration(budgetCfg.MaxWaitMs)*time.Millisecond)
+	}
+
+	var newGeoRanker *ping.GeoIPRanker
+	geoChanged := !reflect.DeepEqual(s.cfg.Ping.GeoIP, newCfg.Ping.GeoIP)
+	if geoChanged {
+		logger.Debug("Reloading GeoIP ranker due to configuration changes.")
+		newGeoRanker = newGeoIPRanker(&newCfg.Ping.GeoIP)
+	}
+
+	var newBwProber *ping.BandwidthProber
+	bandwidthChanged := !reflect.DeepEqual(s.cfg.Ping.Bandwidth, newCfg.Ping.Bandwidth)
+	if bandwidthChanged {
+		logger.Debug("Reloading bandwidth prober due to configuration changes.")
+		newBwProber = newBandwidthProber(&newCfg.Ping.Bandwidth)
+	}
+
+	var newSortRuleMgr *SortRuleManager
+	if s.cfg.Ping.SortRulesFile != newCfg.Ping.SortRulesFile {
+		logger.Debugf("Reloading sort rules from %s.", newCfg.Ping.SortRulesFile)
+		newSortRuleMgr = NewSortRuleManager(newCfg.Ping.SortRulesFile)
+		if err := newSortRuleMgr.Load(); err != nil {
+			logger.Errorf("[SortRules] Failed to load sort rules: %v", err)
+		}
 	}
 
 	var newSortQueue *cache.SortQueue
@@ -109,6 +142,28 @@ func (s *Server) ApplyConfig(newCfg *config.Config) error {
 		newPrefetcher = prefetch.NewPrefetcher(&newCfg.Prefetch, s.stats, s.cache, s)
 	}
 
+	// 复制器需要先停止旧实例才能释放监听端口；Stop 会等待后台连接退出，因此在锁外进行
+	var newReplicator *replication.Replicator
+	replicationChanged := !reflect.DeepEqual(s.cfg.Replication, newCfg.Replication)
+	if replicationChanged {
+		logger.Debug("Replication configuration changed, restarting replicator...")
+		s.cache.SetReplicationHook(nil)
+		if old := s.GetReplicator(); old != nil {
+			old.Stop()
+		}
+		newReplicator = s.newReplicator(&newCfg.Replication)
+		s.startReplicator(newReplicator)
+	}
+
+	// 拦截页服务器同样须先释放监听端口；拦截记录可重新积累，解除申请随旧实例丢弃
+	if !reflect.DeepEqual(s.cfg.AdBlock.BlockPage, newCfg.AdBlock.BlockPage) {
+		logger.Debug("Block page configuration changed, restarting block page server...")
+		if old := s.blockPage.Swap(nil); old != nil {
+			old.Stop()
+		}
+		s.startBlockPage(newBlockPage(&newCfg.AdBlock))
+	}
+
 	// Now, acquire the lock and swap the components.
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -160,6 +215,23 @@ func (s *Server) ApplyConfig(newCfg *config.Config) error {
 			s.pinger.Stop()
 		}
 		s.pinger = newPinger
+		s.replicationPool.Store(newPinger.GetIPPool())
+	}
+
+	if replicationChanged {
+		s.replicator = newReplicator
+	}
+
+	if geoChanged {
+		s.geoRanker = newGeoRanker
+	}
+
+	if bandwidthChanged {
+		s.bandwidthProber = newBwProber
+	}
+
+	if newSortRuleMgr != nil {
+		s.sortRuleManager = newSortRuleMgr
 	}
 
 	if newSortQueue != nil {
@@ -180,11 +252,36 @@ func (s *Server) ApplyConfig(newCfg *config.Config) error {
 		s.prefetcher.Start()
 	}
 
+	// 按域名的 TTL 策略可直接替换，新规则从下一次缓存写入开始生效
+	if !reflect.DeepEqual(s.cfg.Cache.TTLRules, newCfg.Cache.TTLRules) {
+		if err := s.cache.SetTTLRules(newCfg.Cache.TTLRules); err != nil {
+			logger.Errorf("[Cache] Invalid ttl_rules, keeping previous rules: %v", err)
+		}
+	}
+
 	// Handle AdBlock configuration changes
 	if !reflect.DeepEqual(s.cfg.AdBlock, newCfg.AdBlock) {
 		logger.Debug("AdBlock configuration changed, updating manager...")
 		if s.adblockManager != nil {
 			s.adblockManager.SetEnabled(newCfg.AdBlock.Enable)
+			if !reflect.DeepEqual(s.cfg.AdBlock.RPZ, newCfg.AdBlock.RPZ) {
+				s.adblockManager.SetRPZZones(newCfg.AdBlock.RPZ)
+			}
+			if !reflect.DeepEqual(s.cfg.AdBlock.IPBlocklists, newCfg.AdBlock.IPBlocklists) {
+				s.adblockManager.SetIPBlocklists(newCfg.AdBlock.IPBlocklists)
+			}
+			if !reflect.DeepEqual(s.cfg.AdBlock.BlockedServices, newCfg.AdBlock.BlockedServices) {
+				if err := s.adblockManager.SetBlockedServices(newCfg.AdBlock.BlockedServices); err != nil {
+					logger.Errorf("[AdBlock] Failed to apply blocked services: %v", err)
+				}
+				s.cache.ClearAdBlockCaches()
+			}
+			if s.cfg.AdBlock.Timezone != newCfg.AdBlock.Timezone || !reflect.DeepEqual(s.cfg.AdBlock.Schedules, newCfg.AdBlock.Schedules) {
+				s.adblockManager.SetSchedules(newCfg.AdBlock.Timezone, newCfg.AdBlock.Schedules)
+			}
+			if !reflect.DeepEqual(s.cfg.AdBlock.DGA, newCfg.AdBlock.DGA) {
+				s.adblockManager.SetDGA(newCfg.AdBlock.DGA)
+			}
 		}
 	}
 
@@ -3,6 +3,7 @@ package dnsserver
 import (
 	"context"
 	"fmt"
+	"time"
 
 	"smartdnssort/adblock"
 	"smartdnssort/cache"
@@ -78,6 +79,14 @@ func NewServer(cfg *config.Config, s *stats.Stats) *Server {
 	server.pinger.SetHealthChecker(checker)
 	logger.Debugf("[Server] Network health checker injected to Pinger for silent isolation.")
 
+	// 全局探测预算：PingAndSort、IPMonitor 与 TCP 回退共享同一个实例
+	budgetCfg := cfg.Ping.ProbeBudget
+	server.probeBudget = ping.NewProbeBudget(budgetCfg.PacketsPerSecond, budgetCfg.BytesPerHour, time.Duration(budgetCfg.MaxWaitMs)*time.Millisecond)
+	server.pinger.SetProbeBudget(server.probeBudget)
+
+	// 外部探测器（可选）：失败或未覆盖的 IP 回退到本地探测
+	server.pinger.SetProber(newProber(&cfg.Ping.Prober), cfg.Ping.Prober.BatchSize)
+
 	// 静默隔离改造：将全局网络健康检查器注入给 server 实例
 	// 这样 refresh queue 就可以在断网时跳过背景更新任务，避免无效的队列占用
 	server.networkChecker = checker
@@ -88,14 +97,27 @@ func NewServer(cfg *config.Config, s *stats.Stats) *Server {
 	s.SetNetworkChecker(checker)
 	logger.Debugf("[Server] Network health checker injected to Stats for silent isolation.")
 
-	// 尝试加载持久化缓存
+	// 加载持久化缓存（快照 + 预写日志），之后的变更持续写入日志
 	logger.Debugf("[Cache] Loading cache from disk...")
-	if err := server.cache.LoadFromDisk("dns_cache.bin"); err != nil {
-		logger.Errorf("[Cache] Failed to load cache: %v", err)
+	if err := server.cache.OpenPersistence(cache.PersistenceOptions{
+		Path:         cache.PersistenceFile,
+		SyncInterval: time.Duration(cfg.Cache.WALSyncIntervalMs) * time.Millisecond,
+		CompactSize:  int64(cfg.Cache.WALCompactMB) << 20,
+		LoadTimeout:  time.Duration(cfg.Cache.LoadTimeoutSeconds) * time.Second,
+	}); err != nil {
+		logger.Errorf("[Cache] Failed to open cache persistence: %v", err)
 	} else {
 		logger.Infof("[Cache] Loaded %d entries from disk.", server.cache.GetCurrentEntries())
 	}
 
+	// 按域名的 TTL 策略
+	if err := server.cache.SetTTLRules(cfg.Cache.TTLRules); err != nil {
+		logger.Errorf("[Cache] Invalid ttl_rules, using global TTL settings: %v", err)
+	}
+
+	// 外部缓存后端（可选），内存缓存作为 L1
+	attachCacheBackend(server.cache, &cfg.Cache.Backend)
+
 	// 初始化 AdBlock 管理器
 	logger.Debugf("[AdBlock] Initializing AdBlock Manager...")
 	adblockMgr, err := adblock.NewManager(&cfg.AdBlock, checker)
@@ -105,6 +127,7 @@ func NewServer(cfg *config.Config, s *stats.Stats) *Server {
 		cfg.AdBlock.Enable = false
 	} else {
 		server.adblockManager = adblockMgr
+		adblockMgr.SetCacheInvalidator(server.cache.ClearAdBlockCaches)
 		// Start the adblock manager (downloads rules, etc.)
 		go server.adblockManager.Start(context.Background())
 		if cfg.AdBlock.Enable {
@@ -124,6 +147,13 @@ func NewServer(cfg *config.Config, s *stats.Stats) *Server {
 	}
 	server.customRespManager = customRespMgr
 
+	// 手动 IP 排序规则：在测速排序之后、构造响应之前生效
+	sortRuleMgr := NewSortRuleManager(cfg.Ping.SortRulesFile)
+	if err := sortRuleMgr.Load(); err != nil {
+		logger.Errorf("[SortRules] Failed to load sort rules: %v", err)
+	}
+	server.sortRuleManager = sortRuleMgr
+
 	// 设置刷新队列的工作函数
 	refreshQueue.SetWorkFunc(server.refreshCacheAsync)
 
@@ -136,9 +166,22 @@ func NewServer(cfg *config.Config, s *stats.Stats) *Server {
 	server.prefetcher.SetNetworkChecker(checker)
 	logger.Debugf("[Server] Network health checker injected to Prefetcher for silent isolation.")
 
+	// 加载离线 GeoIP/ASN 排序器（可选）
+	server.geoRanker = newGeoIPRanker(&cfg.Ping.GeoIP)
+
+	// 大文件域名的吞吐量排序（可选）
+	server.bandwidthProber = newBandwidthProber(&cfg.Ping.Bandwidth)
+
 	// 设置 IP 池更新器，用于维护全局 IP 资源
 	server.cache.SetIPPoolUpdater(server.pinger.GetIPPool())
 
+	// 多实例缓存复制（可选），在 Start 中启动
+	server.replicationPool.Store(server.pinger.GetIPPool())
+	server.replicator = server.newReplicator(&cfg.Replication)
+
+	// 启动预热（可选），在监听端口就绪后执行
+	server.warmup = newWarmupRunner(cfg.Warmup)
+
 	// 初始化 IP 主动巡检调度器
 	logger.Debugf("[IPMonitor] Initializing IP Monitor...")
 	monitorConfig := ping.DefaultIPMonitorConfig()
@@ -193,3 +236,27 @@ func NewServer(cfg *config.Config, s *stats.Stats) *Server {
 
 	return server
 }
+
+// attachCacheBackend 按配置为缓存挂载外部后端，连接失败时仅使用本地内存
+func attachCacheBackend(c *cache.Cache, cfg *config.CacheBackendConfig) {
+	switch cfg.Type {
+	case "", "memory":
+		return
+	case "redis":
+		backend, err := cache.NewRedisBackend(cache.RedisOptions{
+			Addr:      cfg.Addr,
+			Password:  cfg.Password,
+			DB:        cfg.DB,
+			KeyPrefix: cfg.KeyPrefix,
+			PoolSize:  cfg.PoolSize,
+		})
+		if err != nil {
+			logger.Errorf("[CacheBackend] %v, falling back to memory only", err)
+			return
+		}
+		c.SetBackend(backend, cfg.PoolSize, time.Duration(cfg.TimeoutMs)*time.Millisecond, cfg.StaleSeconds)
+		logger.Infof("[CacheBackend] Using redis backend at %s (db %d, prefix %q)", cfg.Addr, cfg.DB, cfg.KeyPrefix)
+	default:
+		logger.Errorf("[CacheBackend] Unknown backend type %q, falling back to memory only", cfg.Type)
+	}
+}
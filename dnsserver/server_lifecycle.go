@@ -20,6 +20,8 @@ func (s *Server) Start() error {
 		Addr:    addr,
 		Net:     "udp",
 		Handler: dns.DefaultServeMux,
+		// 监听就绪后开始预热缓存
+		NotifyStartedFunc: s.startWarmup,
 	}
 
 	// 启动 TCP 服务器（如果启用）
@@ -50,6 +52,12 @@ func (s *Server) Start() error {
 	// Start the prefetcher
 	s.prefetcher.Start()
 
+	// 启动多实例缓存复制（如果启用）
+	s.startReplicator(s.GetReplicator())
+
+	// 启动拦截页服务器（如果启用）
+	s.startBlockPage(newBlockPage(&s.cfg.AdBlock))
+
 	// 启动嵌入式递归解析器（如果启用）
 	if s.recursorMgr != nil {
 		if err := s.recursorMgr.Start(); err != nil {
@@ -95,9 +103,27 @@ func (s *Server) Shutdown() {
 		}
 	}
 
-	// 保存缓存到磁盘
+	// 停止缓存复制，之后的缓存写入不再推送给对等节点
+	if r := s.GetReplicator(); r != nil {
+		s.cache.SetReplicationHook(nil)
+		r.Stop()
+		logger.Debug("[Replication] Replicator stopped.")
+	}
+
+	// 停止拦截页服务器，之后的拦截应答恢复按 block_mode 返回
+	if bp := s.blockPage.Swap(nil); bp != nil {
+		bp.Stop()
+		logger.Debug("[BlockPage] Block page server stopped.")
+	}
+
+	// 保存热门域名历史，须在预取器清空评分表之前
+	if err := s.saveWarmupHistory(); err != nil {
+		logger.Errorf("[Warmup] Failed to save history: %v", err)
+	}
+
+	// 保存缓存到磁盘：写入最终快照并关闭预写日志
 	logger.Debug("[Cache] Saving cache to disk...")
-	if err := s.cache.SaveToDisk("dns_cache.json"); err != nil {
+	if err := s.saveCache(true); err != nil {
 		logger.Errorf("[Cache] Failed to save cache: %v", err)
 	} else {
 		logger.Debug("[Cache] Cache saved successfully.")
@@ -0,0 +1,57 @@
+package dnsserver
+
+import (
+	"time"
+
+	"smartdnssort/config"
+	"smartdnssort/logger"
+	"smartdnssort/ping"
+	"smartdnssort/replication"
+)
+
+// newReplicator 根据配置创建缓存复制器，未启用或配置无效时返回 nil
+func (s *Server) newReplicator(cfg *config.ReplicationConfig) *replication.Replicator {
+	if !cfg.Enabled {
+		return nil
+	}
+	r, err := replication.New(replication.Options{
+		NodeID:        cfg.NodeID,
+		ListenAddr:    cfg.ListenAddr,
+		Peers:         cfg.Peers,
+		SharedKey:     cfg.SharedKey,
+		QueueSize:     cfg.QueueSize,
+		BatchSize:     cfg.BatchSize,
+		FlushInterval: time.Duration(cfg.FlushIntervalMs) * time.Millisecond,
+	}, s.cache, s.currentIPPool)
+	if err != nil {
+		logger.Errorf("[Replication] Disabled: %v", err)
+		return nil
+	}
+	return r
+}
+
+// currentIPPool 返回当前 Pinger 的 IP 池，Pinger 热重载后自动跟随
+// 复制器在缓存写入路径和后台 goroutine 中调用，这里不能获取 s.mu：
+// ApplyConfig 持有写锁时会等待排序队列等后台任务退出
+func (s *Server) currentIPPool() *ping.IPPool {
+	return s.replicationPool.Load()
+}
+
+// startReplicator 启动复制器并注册为缓存写入回调
+func (s *Server) startReplicator(r *replication.Replicator) {
+	if r == nil {
+		s.cache.SetReplicationHook(nil)
+		return
+	}
+	if err := r.Start(); err != nil {
+		logger.Errorf("[Replication] Failed to start: %v", err)
+	}
+	s.cache.SetReplicationHook(r)
+}
+
+// GetReplicator returns the cache replicator (nil if disabled)
+func (s *Server) GetReplicator() *replication.Replicator {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.replicator
+}
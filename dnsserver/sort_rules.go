@@ -0,0 +1,298 @@
+package dnsserver
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+)
+
+// SortRuleAction 手动排序规则的动作
+type SortRuleAction string
+
+const (
+	SortRulePin     SortRuleAction = "pin"     // 固定在最前，按规则中目标的书写顺序排列
+	SortRulePrefer  SortRuleAction = "prefer"  // 排在未命中规则的 IP 之前，保留测速顺序
+	SortRuleDemote  SortRuleAction = "demote"  // 排到末尾，保留测速顺序
+	SortRuleExclude SortRuleAction = "exclude" // 从响应中移除（全部被排除时保留原结果）
+)
+
+// SortRule 单条手动排序规则
+//
+//	example.com    pin     1.2.3.4
+//	*.example.com  prefer  10.0.0.0/8 2001:db8::/32
+//	cdn.test.org   exclude 5.6.7.8
+type SortRule struct {
+	Pattern string // 域名，*.example.com 匹配所有子域名
+	Action  SortRuleAction
+	Targets []string // 原始书写的 IP/CIDR
+	nets    []*net.IPNet
+}
+
+// String 返回规则的文本形式，与规则文件中的一行一致
+func (r SortRule) String() string {
+	return fmt.Sprintf("%s %s %s", r.Pattern, r.Action, strings.Join(r.Targets, " "))
+}
+
+// matchTarget 返回 IP 命中的第一个目标下标，未命中返回 -1
+func (r SortRule) matchTarget(ip net.IP) int {
+	for i, n := range r.nets {
+		if n.Contains(ip) {
+			return i
+		}
+	}
+	return -1
+}
+
+// SortRuleManager 管理按域名生效的 IP 固定/偏好/降级/排除规则
+// 规则在测速排序之后、构造响应之前应用，不写入排序缓存，因此缓存刷新后依然生效
+type SortRuleManager struct {
+	mu       sync.RWMutex
+	exact    map[string][]SortRule // 精确域名 -> 规则
+	wildcard map[string][]SortRule // *.suffix 中的 suffix -> 规则
+	filePath string
+}
+
+// NewSortRuleManager creates a new manager
+func NewSortRuleManager(filePath string) *SortRuleManager {
+	return &SortRuleManager{
+		exact:    make(map[string][]SortRule),
+		wildcard: make(map[string][]SortRule),
+		filePath: filePath,
+	}
+}
+
+// FilePath 返回规则文件路径
+func (m *SortRuleManager) FilePath() string {
+	return m.filePath
+}
+
+// Load reads rules from the file
+func (m *SortRuleManager) Load() error {
+	content, err := os.ReadFile(m.filePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			m.mu.Lock()
+			m.exact = make(map[string][]SortRule)
+			m.wildcard = make(map[string][]SortRule)
+			m.mu.Unlock()
+			return nil
+		}
+		return fmt.Errorf("failed to read sort rules file: %w", err)
+	}
+
+	rules, err := parseSortRules(string(content))
+	if err != nil {
+		return err
+	}
+
+	exact := make(map[string][]SortRule)
+	wildcard := make(map[string][]SortRule)
+	for _, r := range rules {
+		if suffix, ok := strings.CutPrefix(r.Pattern, "*."); ok {
+			wildcard[suffix] = append(wildcard[suffix], r)
+		} else {
+			exact[r.Pattern] = append(exact[r.Pattern], r)
+		}
+	}
+
+	m.mu.Lock()
+	m.exact = exact
+	m.wildcard = wildcard
+	m.mu.Unlock()
+	return nil
+}
+
+// ValidateRules validates the raw content without applying it
+func (m *SortRuleManager) ValidateRules(content string) error {
+	_, err := parseSortRules(content)
+	return err
+}
+
+// parseSortRules 解析规则文本：每行 "域名 动作 IP/CIDR [IP/CIDR...]"，# 开头为注释
+func parseSortRules(content string) ([]SortRule, error) {
+	var rules []SortRule
+	scanner := bufio.NewScanner(strings.NewReader(content))
+	lineNum := 0
+
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		parts := strings.Fields(line)
+		if len(parts) < 3 {
+			return nil, fmt.Errorf("line %d: invalid format, expected 'domain action ip/cidr...'", lineNum)
+		}
+
+		pattern := strings.ToLower(strings.TrimRight(parts[0], "."))
+		if pattern == "" || pattern == "*." || strings.Contains(strings.TrimPrefix(pattern, "*."), "*") {
+			return nil, fmt.Errorf("line %d: invalid domain pattern '%s'", lineNum, parts[0])
+		}
+
+		action := SortRuleAction(strings.ToLower(parts[1]))
+		switch action {
+		case SortRulePin, SortRulePrefer, SortRuleDemote, SortRuleExclude:
+		default:
+			return nil, fmt.Errorf("line %d: unsupported action '%s' (pin, prefer, demote, exclude)", lineNum, parts[1])
+		}
+
+		rule := SortRule{Pattern: pattern, Action: action}
+		for _, target := range parts[2:] {
+			n, err := parseIPOrCIDR(target)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", lineNum, err)
+			}
+			rule.Targets = append(rule.Targets, target)
+			rule.nets = append(rule.nets, n)
+		}
+		rules = append(rules, rule)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading content: %w", err)
+	}
+	return rules, nil
+}
+
+// parseIPOrCIDR 将单个 IP 视为 /32 或 /128 网段
+func parseIPOrCIDR(s string) (*net.IPNet, error) {
+	if strings.Contains(s, "/") {
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR '%s'", s)
+		}
+		return n, nil
+	}
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid IP '%s'", s)
+	}
+	if v4 := ip.To4(); v4 != nil {
+		return &net.IPNet{IP: v4, Mask: net.CIDRMask(32, 32)}, nil
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}, nil
+}
+
+// Match 返回对域名生效的规则：精确规则在前，随后是由近到远的通配符规则
+func (m *SortRuleManager) Match(domain string) []SortRule {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if len(m.exact) == 0 && len(m.wildcard) == 0 {
+		return nil
+	}
+
+	domain = strings.ToLower(strings.TrimRight(domain, "."))
+	matched := append([]SortRule(nil), m.exact[domain]...)
+	for rest := domain; ; {
+		idx := strings.IndexByte(rest, '.')
+		if idx < 0 {
+			break
+		}
+		rest = rest[idx+1:]
+		matched = append(matched, m.wildcard[rest]...)
+	}
+	return matched
+}
+
+// Apply 按规则调整 IP 顺序
+// 返回调整后的列表、命中的规则，以及顺序或内容是否发生变化
+// 同一 IP 命中多条规则时优先级为 exclude > pin > prefer > demote
+func (m *SortRuleManager) Apply(domain string, ips []string) ([]string, []SortRule, bool) {
+	if m == nil || len(ips) == 0 {
+		return ips, nil, false
+	}
+	rules := m.Match(domain)
+	if len(rules) == 0 {
+		return ips, nil, false
+	}
+
+	type pinKey struct{ rule, target int }
+	var (
+		pinned   = make(map[pinKey][]string)
+		prefer   []string
+		normal   []string
+		demote   []string
+		excluded int
+		applied  = make([]bool, len(rules))
+	)
+
+	for _, ipStr := range ips {
+		ip := net.ParseIP(ipStr)
+		if ip == nil {
+			normal = append(normal, ipStr)
+			continue
+		}
+
+		var (
+			isExcluded, isPreferred, isDemoted bool
+			pin                                *pinKey
+		)
+		for ri, r := range rules {
+			ti := r.matchTarget(ip)
+			if ti < 0 {
+				continue
+			}
+			applied[ri] = true
+			switch r.Action {
+			case SortRuleExclude:
+				isExcluded = true
+			case SortRulePin:
+				if pin == nil {
+					pin = &pinKey{ri, ti}
+				}
+			case SortRulePrefer:
+				isPreferred = true
+			case SortRuleDemote:
+				isDemoted = true
+			}
+		}
+
+		switch {
+		case isExcluded:
+			excluded++
+		case pin != nil:
+			pinned[*pin] = append(pinned[*pin], ipStr)
+		case isPreferred:
+			prefer = append(prefer, ipStr)
+		case isDemoted:
+			demote = append(demote, ipStr)
+		default:
+			normal = append(normal, ipStr)
+		}
+	}
+
+	// 全部被排除时返回原结果，避免产生空应答
+	if excluded == len(ips) {
+		return ips, nil, false
+	}
+
+	// 固定的 IP 按规则出现顺序、目标书写顺序排列
+	result := make([]string, 0, len(ips)-excluded)
+	for ri := range rules {
+		for ti := range rules[ri].nets {
+			result = append(result, pinned[pinKey{ri, ti}]...)
+		}
+	}
+	result = append(result, prefer...)
+	result = append(result, normal...)
+	result = append(result, demote...)
+
+	var hit []SortRule
+	for i, r := range rules {
+		if applied[i] {
+			hit = append(hit, r)
+		}
+	}
+
+	changed := len(result) != len(ips)
+	for i := 0; !changed && i < len(ips); i++ {
+		changed = result[i] != ips[i]
+	}
+	return result, hit, changed
+}
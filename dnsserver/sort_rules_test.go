@@ -0,0 +1,92 @@
+package dnsserver
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func newTestSortRuleManager(t *testing.T, content string) *SortRuleManager {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "sort_rules.txt")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write rules file: %v", err)
+	}
+	mgr := NewSortRuleManager(path)
+	if err := mgr.Load(); err != nil {
+		t.Fatalf("Failed to load rules: %v", err)
+	}
+	return mgr
+}
+
+func TestSortRuleManager_Apply(t *testing.T) {
+	mgr := newTestSortRuleManager(t, `
+# 固定、偏好、降级、排除
+example.com pin 9.9.9.9 8.8.8.8
+example.com demote 1.1.1.1
+*.example.com prefer 10.0.0.0/8
+*.example.com exclude 5.5.5.5
+`)
+
+	ips := []string{"1.1.1.1", "8.8.8.8", "10.1.1.1", "5.5.5.5", "9.9.9.9", "2.2.2.2"}
+
+	got, rules, changed := mgr.Apply("example.com", ips)
+	want := []string{"9.9.9.9", "8.8.8.8", "10.1.1.1", "5.5.5.5", "2.2.2.2", "1.1.1.1"}
+	if !reflect.DeepEqual(got, want) || !changed || len(rules) != 2 {
+		t.Errorf("apex: got %v (rules=%d changed=%v), want %v", got, len(rules), changed, want)
+	}
+
+	got, rules, changed = mgr.Apply("www.Example.com.", ips)
+	want = []string{"10.1.1.1", "1.1.1.1", "8.8.8.8", "9.9.9.9", "2.2.2.2"}
+	if !reflect.DeepEqual(got, want) || !changed || len(rules) != 2 {
+		t.Errorf("subdomain: got %v (rules=%d changed=%v), want %v", got, len(rules), changed, want)
+	}
+
+	got, _, changed = mgr.Apply("other.org", ips)
+	if !reflect.DeepEqual(got, ips) || changed {
+		t.Errorf("unmatched domain should be untouched, got %v", got)
+	}
+}
+
+func TestSortRuleManager_ExcludeAllKeepsOriginal(t *testing.T) {
+	mgr := newTestSortRuleManager(t, "example.com exclude 0.0.0.0/0\n")
+	ips := []string{"1.1.1.1", "2.2.2.2"}
+	got, _, changed := mgr.Apply("example.com", ips)
+	if !reflect.DeepEqual(got, ips) || changed {
+		t.Errorf("excluding every IP should keep the original answer, got %v", got)
+	}
+}
+
+func TestSortRuleManager_AlreadyInOrder(t *testing.T) {
+	mgr := newTestSortRuleManager(t, "example.com pin 1.1.1.1\n")
+	_, rules, changed := mgr.Apply("example.com", []string{"1.1.1.1", "2.2.2.2"})
+	if changed || len(rules) != 1 {
+		t.Errorf("matching rule without reordering should report changed=false, got changed=%v rules=%d", changed, len(rules))
+	}
+}
+
+func TestSortRuleManager_ParseInvalidContent(t *testing.T) {
+	invalidCases := []string{
+		"example.com pin",             // Missing target
+		"example.com boost 1.2.3.4",   // Invalid action
+		"example.com pin 999.1.1.1",   // Invalid IP
+		"example.com prefer 1.2.3/40", // Invalid CIDR
+		"*.*.example.com pin 1.2.3.4", // Invalid pattern
+	}
+	for _, content := range invalidCases {
+		if _, err := parseSortRules(content); err == nil {
+			t.Errorf("Expected error for invalid content: '%s', but got none", content)
+		}
+	}
+}
+
+func TestSortRuleManager_MissingFile(t *testing.T) {
+	mgr := NewSortRuleManager(filepath.Join(t.TempDir(), "missing.txt"))
+	if err := mgr.Load(); err != nil {
+		t.Fatalf("Missing file should not be an error: %v", err)
+	}
+	if rules := mgr.Match("example.com"); len(rules) != 0 {
+		t.Errorf("Expected no rules, got %v", rules)
+	}
+}
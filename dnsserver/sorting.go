@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"net"
 	"smartdnssort/cache"
+	"smartdnssort/config"
 	"smartdnssort/logger"
 	"smartdnssort/ping"
 	"sort"
@@ -23,7 +24,8 @@ func (s *Server) performPingSort(ctx context.Context, domain string, ips []strin
 		// If ping is disabled, return the original IPs without sorting or RTTs.
 		// RTTs will be nil, which calling functions should handle (e.g., using 0 or ignoring).
 		// No error is returned as this is an intended bypass.
-		return ips, nil, nil
+		// 若配置了离线 GeoIP 排序，则用其代替上游原始顺序
+		return s.sortIPsByGeo(ips), nil, nil
 	}
 
 	logger.Debugf("[performPingSort] 对 %d 个 IP 进行 ping 排序", len(ips))
@@ -50,8 +52,13 @@ func (s *Server) performPingSort(ctx context.Context, domain string, ips []strin
 
 	s.mu.RLock()
 	pinger := s.pinger
+	bandwidthProber := s.bandwidthProber
 	s.mu.RUnlock()
 
+	// 配置了吞吐量排序的域名：异步测量缺少有效数据的 IP，结果在后续排序中生效
+	// 规则匹配与探测的 Host/SNI 使用查询域名（CNAME 目标通常不是客户端实际访问的站点），与缓存命中路径一致
+	bandwidthProber.Refresh(pinger.GetIPPool(), domain, ips)
+
 	// 第三阶段改造：优先从 IPPool 获取 RTT 数据（真理化改造）
 	// 这样可以避免每次都进行实时探测，提高响应速度
 	ipPool := pinger.GetIPPool()
@@ -62,7 +69,7 @@ func (s *Server) performPingSort(ctx context.Context, domain string, ips []strin
 		// 如果所有 IP 都有 RTT 数据，直接使用 IPPool 的数据进行排序
 		if len(rttMap) == len(ips) {
 			logger.Debugf("[performPingSort] 使用 IPPool RTT 数据进行排序: %s", domain)
-			return s.sortIPsByRTT(ips, rttMap, sortDomain)
+			return s.sortIPsByRTT(ips, rttMap, domain)
 		}
 
 		// 部分或全部 IP 没有 RTT 数据，需要探测
@@ -88,7 +95,7 @@ func (s *Server) performPingSort(ctx context.Context, domain string, ips []strin
 		// 如果至少有一个 IP 有 RTT 数据，使用现有数据排序
 		if len(rttMap) > 0 {
 			logger.Debugf("[performPingSort] 使用部分 IPPool RTT 数据进行排序: %s", domain)
-			return s.sortIPsByRTT(ips, rttMap, sortDomain)
+			return s.sortIPsByRTT(ips, rttMap, domain)
 		}
 	}
 
@@ -99,22 +106,36 @@ func (s *Server) performPingSort(ctx context.Context, domain string, ips []strin
 	if len(pingResults) == 0 {
 		// 断网且无缓存时，返回原始 IP 列表（尽力而为）
 		// 这样系统能够继续提供有限的解析服务，而不是返回 SERVFAIL
+		// 配置了离线 GeoIP 排序时，以 ASN/国家偏好作为主排序键
 		logger.Debugf("[performPingSort] 断网且无缓存，返回原始 IP 列表: %s", domain)
-		return ips, nil, nil
+		return s.sortIPsByGeo(ips), nil, nil
 	}
 
 	// 提取排序后的 IP 和 RTT
 	var sortedIPs []string
 	var rtts []int
+	probed := make([]ping.Result, 0, len(pingResults))
 	for _, result := range pingResults {
 		sortedIPs = append(sortedIPs, result.IP)
 		rtts = append(rtts, result.RTT)
+		// 被探测预算推迟的 IP 没有真实测速结果，不计入成功数，也不上报给预取器
+		if result.ProbeMethod == ping.ProbeMethodDeferred {
+			continue
+		}
+		probed = append(probed, result)
 		s.stats.IncPingSuccesses()
 	}
 
+	// 全部被探测预算推迟时没有任何真实测速结果，不能把占位 RTT 当作排序结果
+	// 退回 GeoIP 排序且不返回 RTT，排序缓存不保存该结果，后续排序可补齐测速
+	if len(probed) == 0 {
+		logger.Debugf("[performPingSort] 所有 IP 的测速均被推迟，使用 GeoIP 排序: %s", domain)
+		return s.sortIPsByGeo(ips), nil, nil
+	}
+
 	// Report results to prefetcher for blacklist/stat updates
 	// We also report against sortDomain to centralize the knowledge base
-	s.prefetcher.ReportPingResultWithDomain(sortDomain, pingResults)
+	s.prefetcher.ReportPingResultWithDomain(sortDomain, probed)
 
 	return sortedIPs, rtts, nil
 }
@@ -128,6 +149,24 @@ func (s *Server) sortIPsByRTT(ips []string, rttMap map[string]int, domain string
 		rtt int
 	}
 
+	s.mu.RLock()
+	geoRanker := s.geoRanker
+	bandwidthProber := s.bandwidthProber
+	pinger := s.pinger
+	s.mu.RUnlock()
+
+	// GeoIP 偏好等级在排序前一次性算好，避免比较函数里重复查库
+	var geoRanks map[string]int
+	if geoRanker != nil {
+		geoRanks = geoRanker.Ranks(ips)
+	}
+
+	// 配置了吞吐量排序的域名：可达 IP 中下载速率高的优先（无数据视为 0）
+	var bandwidths map[string]float64
+	if bandwidthProber != nil && pinger != nil {
+		bandwidths = bandwidthProber.Ranks(pinger.GetIPPool(), domain, ips)
+	}
+
 	ipRTTs := make([]ipRTT, 0, len(ips))
 	for _, ip := range ips {
 		rtt := ping.LogicDeadRTT // 默认值，表示不可达
@@ -141,6 +180,25 @@ func (s *Server) sortIPsByRTT(ips []string, rttMap map[string]int, domain string
 	// 选择 sort.Slice 因为其底层实现针对中等规模数组（DNS 响应 IP 数量通常 < 100）
 	// 比冒泡排序 O(n²) 性能更优 (O(n log n))
 	sort.Slice(ipRTTs, func(i, j int) bool {
+		if len(bandwidths) > 0 {
+			deadI, deadJ := ipRTTs[i].rtt >= ping.LogicDeadRTT, ipRTTs[j].rtt >= ping.LogicDeadRTT
+			if deadI != deadJ {
+				return deadJ
+			}
+			if bi, bj := bandwidths[ipRTTs[i].ip], bandwidths[ipRTTs[j].ip]; bi != bj {
+				return bi > bj
+			}
+		}
+		// 启用 GeoIP 时：先比较 RTT 分桶，同桶内按 ASN/国家偏好排序
+		if geoRanks != nil {
+			bi, bj := geoRanker.RTTBucket(ipRTTs[i].rtt), geoRanker.RTTBucket(ipRTTs[j].rtt)
+			if bi != bj {
+				return bi < bj
+			}
+			if ri, rj := geoRanks[ipRTTs[i].ip], geoRanks[ipRTTs[j].ip]; ri != rj {
+				return ri < rj
+			}
+		}
 		// 首先按 RTT 从小到大排序
 		if ipRTTs[i].rtt != ipRTTs[j].rtt {
 			return ipRTTs[i].rtt < ipRTTs[j].rtt
@@ -164,21 +222,91 @@ func (s *Server) sortIPsByRTT(ips []string, rttMap map[string]int, domain string
 	return sortedIPs, rtts, nil
 }
 
-// calculateRemainingTTL 计算基于本地策略后的剩余生存时间
-func (s *Server) calculateRemainingTTL(upstreamTTL uint32, acquisitionTime time.Time) int {
-	elapsed := int(time.Since(acquisitionTime).Seconds())
+// sortIPsByGeo 在没有任何 RTT 数据时按离线 GeoIP 偏好排序
+// 未启用 GeoIP 时原样返回上游顺序
+func (s *Server) sortIPsByGeo(ips []string) []string {
+	s.mu.RLock()
+	geoRanker := s.geoRanker
+	s.mu.RUnlock()
 
-	// 1. 首先基于上游 TTL 和本地配置，计算该记录在本地的总生存期 (Effective TTL)
-	effTTL := upstreamTTL
-	minTTL := uint32(s.cfg.Cache.MinTTLSeconds)
-	maxTTL := uint32(s.cfg.Cache.MaxTTLSeconds)
+	if geoRanker == nil || len(ips) < 2 {
+		return ips
+	}
+	return geoRanker.SortIPs(ips)
+}
 
-	if minTTL > 0 && effTTL < minTTL {
-		effTTL = minTTL
+// newGeoIPRanker 根据配置加载离线 GeoIP 排序器，未启用或加载失败时返回 nil
+func newGeoIPRanker(cfg *config.GeoIPConfig) *ping.GeoIPRanker {
+	if cfg == nil || !cfg.Enabled {
+		return nil
 	}
-	if maxTTL > 0 && effTTL > maxTTL {
-		effTTL = maxTTL
+	ranker, err := ping.NewGeoIPRanker(cfg.CountryDB, cfg.ASNDB, cfg.PreferredASNs, cfg.PreferredCountries, cfg.RTTBucketMs)
+	if err != nil {
+		logger.Warnf("[GeoIP] Failed to load GeoIP database, offline ranking disabled: %v", err)
+		return nil
 	}
+	return ranker
+}
+
+// newBandwidthProber 根据配置创建吞吐量探测器，未启用或未配置域名时返回 nil
+func newBandwidthProber(cfg *config.BandwidthConfig) *ping.BandwidthProber {
+	if cfg == nil || !cfg.Enabled || len(cfg.Domains) == 0 {
+		return nil
+	}
+	rules := make([]ping.BandwidthRule, 0, len(cfg.Domains))
+	for _, d := range cfg.Domains {
+		rules = append(rules, ping.BandwidthRule{Pattern: d.Domain, Path: d.Path, Scheme: d.Scheme})
+	}
+	return ping.NewBandwidthProber(ping.BandwidthOptions{
+		Rules:        rules,
+		MaxBytes:     int64(cfg.MaxKB) * 1024,
+		Timeout:      time.Duration(cfg.TimeoutMs) * time.Millisecond,
+		Concurrency:  cfg.Concurrency,
+		BytesPerHour: cfg.BytesPerHour,
+		CacheTTL:     time.Duration(cfg.CacheTTLSeconds) * time.Second,
+	})
+}
+
+// newProber 根据配置创建外部探测器，未启用或配置无效时返回 nil（回退到本地探测）
+func newProber(cfg *config.ProberConfig) ping.Prober {
+	if cfg == nil || !cfg.Enabled {
+		return nil
+	}
+	prober, err := ping.NewProber(ping.ProberOptions{
+		Type:      cfg.Type,
+		URL:       cfg.URL,
+		AuthToken: cfg.AuthToken,
+		Command:   cfg.Command,
+		Args:      cfg.Args,
+		Timeout:   time.Duration(cfg.TimeoutMs) * time.Millisecond,
+	})
+	if err != nil {
+		logger.Warnf("[Pinger] Failed to create external prober, using local probing: %v", err)
+		return nil
+	}
+	return prober
+}
+
+// applySortRules 在构造响应前应用手动排序规则
+// 规则不写入排序缓存，每次响应时重新应用，缓存刷新或 IPPool 重排后依然生效
+func (s *Server) applySortRules(domain string, ips []string) []string {
+	s.mu.RLock()
+	mgr := s.sortRuleManager
+	s.mu.RUnlock()
+
+	result, rules, changed := mgr.Apply(domain, ips)
+	if changed {
+		logger.Debugf("[SortRules] %s: %d rule(s) reordered %v -> %v", domain, len(rules), ips, result)
+	}
+	return result
+}
+
+// calculateRemainingTTL 计算基于本地策略后的剩余生存时间
+func (s *Server) calculateRemainingTTL(domain string, upstreamTTL uint32, acquisitionTime time.Time) int {
+	elapsed := int(time.Since(acquisitionTime).Seconds())
+
+	// 1. 首先基于上游 TTL 和本地配置（含按域名规则），计算该记录在本地的总生存期 (Effective TTL)
+	effTTL := s.cache.TTLPolicyFor(domain).Clamp(upstreamTTL)
 
 	// 2. 然后减去已经过去的时间，得到剩下的生存时间
 	remaining := int(effTTL) - elapsed
@@ -235,7 +363,7 @@ func (s *Server) sortIPsAsync(domain string, qtype uint16, ips []string, upstrea
 				Domain: domain,
 				Qtype:  qtype,
 				IPs:    ips,
-				TTL:    uint32(s.calculateRemainingTTL(upstreamTTL, acquisitionTime)),
+				TTL:    uint32(s.calculateRemainingTTL(domain, upstreamTTL, acquisitionTime)),
 				Callback: func(result *cache.SortedCacheEntry, err error) {
 					s.handleSortComplete(domain, qtype, result, err, state)
 				},
@@ -276,13 +404,22 @@ func (s *Server) handleSortComplete(domain string, qtype uint16, result *cache.S
 	logger.Debugf("[handleSortComplete] 排序完成: %s (type=%s) -> %v (RTT: %v)",
 		domain, dns.TypeToString[qtype], result.IPs, result.RTTs)
 
+	// 没有任何测速数据（断网或测速全部被推迟）的结果只回传给等待者，不写入排序缓存
+	// 否则整个 TTL 内都会按失效 IP 处理，且后续排序无法补齐测速结果
+	if len(result.RTTs) == 0 {
+		logger.Debugf("[handleSortComplete] 无测速数据，不缓存排序结果: %s (type=%s)",
+			domain, dns.TypeToString[qtype])
+		s.cache.FinishSort(domain, qtype, result, nil, state)
+		return
+	}
+
 	// 从原始缓存获取获取时间，计算剩余 TTL
 	raw, exists := s.cache.GetRaw(domain, qtype)
 	if exists && raw != nil {
-		result.TTL = s.calculateRemainingTTL(raw.UpstreamTTL, raw.AcquisitionTime)
+		result.TTL = s.calculateRemainingTTL(domain, raw.UpstreamTTL, raw.AcquisitionTime)
 	} else {
 		// 如果原始缓存不存在（极少发生），使用最小 TTL 作为兜底
-		result.TTL = s.cfg.Cache.MinTTLSeconds
+		result.TTL = int(s.cache.TTLPolicyFor(domain).MinTTL)
 	}
 
 	// 缓存排序结果
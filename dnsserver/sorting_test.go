@@ -5,9 +5,12 @@ import (
 	"reflect"
 	"testing"
 
+	"smartdnssort/cache"
 	"smartdnssort/config"
 	"smartdnssort/ping"
 	"smartdnssort/stats"
+
+	"github.com/miekg/dns"
 )
 
 // newTestServerForSorting 创建一个 Server 实例，用于 performPingSort 测试。
@@ -153,3 +156,72 @@ func TestPerformPingSort(t *testing.T) {
 		}
 	})
 }
+
+func TestSortIPsByRTTWithBandwidth(t *testing.T) {
+	cfg := &config.Config{
+		Ping: config.PingConfig{
+			Enabled: true,
+			Bandwidth: config.BandwidthConfig{
+				Enabled: true,
+				Domains: []config.BandwidthDomainConfig{{Domain: "dl.example.com"}},
+			},
+		},
+	}
+	server := newTestServerForSorting(cfg)
+
+	ips := []string{"192.0.2.1", "192.0.2.2", "192.0.2.3"}
+	pool := server.pinger.GetIPPool()
+	pool.UpdateDomainIPs(nil, ips, "dl.example.com")
+	pool.UpdateIPBandwidth("192.0.2.1", 100)
+	pool.UpdateIPBandwidth("192.0.2.2", 900)
+	pool.UpdateIPBandwidth("192.0.2.3", 5000) // 吞吐量最高但不可达
+	rttMap := map[string]int{"192.0.2.1": 10, "192.0.2.2": 50, "192.0.2.3": ping.LogicDeadRTT}
+
+	sortedIPs, _, _ := server.sortIPsByRTT(ips, rttMap, "dl.example.com")
+	if want := []string{"192.0.2.2", "192.0.2.1", "192.0.2.3"}; !reflect.DeepEqual(sortedIPs, want) {
+		t.Errorf("吞吐量排序预期 %v, 却得到 %v", want, sortedIPs)
+	}
+
+	// 未配置带宽排序的域名仍按 RTT 排序
+	sortedIPs, _, _ = server.sortIPsByRTT(ips, rttMap, "other.example.com")
+	if want := []string{"192.0.2.1", "192.0.2.2", "192.0.2.3"}; !reflect.DeepEqual(sortedIPs, want) {
+		t.Errorf("RTT 排序预期 %v, 却得到 %v", want, sortedIPs)
+	}
+
+	// 查询域名经 CNAME 指向 CDN 时，吞吐量规则仍按查询域名匹配
+	reachable := ips[:2]
+	for ip, rtt := range map[string]int{"192.0.2.1": 10, "192.0.2.2": 50} {
+		pool.UpdateIPRTT(ip, rtt, 0, 1)
+	}
+	server.cache.SetRaw("dl.example.com", dns.TypeA, reachable, []string{"dl.example.cdn.net."}, 300)
+	sortedIPs, _, _ = server.performPingSort(context.Background(), "dl.example.com", reachable)
+	if want := []string{"192.0.2.2", "192.0.2.1"}; !reflect.DeepEqual(sortedIPs, want) {
+		t.Errorf("CNAME 域名的吞吐量排序预期 %v, 却得到 %v", want, sortedIPs)
+	}
+}
+
+func TestPerformPingSortAllDeferred(t *testing.T) {
+	cfg := &config.Config{Ping: config.PingConfig{Enabled: true}}
+	server := newTestServerForSorting(cfg)
+	server.pinger.GetIPPool().Clear()
+	server.pinger.SetProbeBudget(ping.NewProbeBudget(0, 1, 0)) // 每小时 1 字节：所有探测都被推迟
+
+	ips := []string{"192.0.2.3", "192.0.2.1", "192.0.2.2"}
+	sortedIPs, rtts, err := server.performPingSort(context.Background(), "example.com", ips)
+	if err != nil {
+		t.Fatalf("预期没有错误, 却得到 %v", err)
+	}
+	if !reflect.DeepEqual(sortedIPs, ips) || rtts != nil {
+		t.Fatalf("测速全部被推迟时预期返回 GeoIP 排序且无 RTT, 却得到 %v %v", sortedIPs, rtts)
+	}
+
+	// 无测速数据的结果不写入排序缓存，后续排序可以补齐
+	state, _ := server.cache.GetOrStartSort("example.com", dns.TypeA)
+	server.handleSortComplete("example.com", dns.TypeA, &cache.SortedCacheEntry{IPs: sortedIPs, IsValid: true}, nil, state)
+	if _, ok := server.cache.GetSorted("example.com", dns.TypeA); ok {
+		t.Error("无测速数据的排序结果不应被缓存")
+	}
+	if state.Result == nil || state.InProgress {
+		t.Error("排序任务仍应完成并把结果交给等待者")
+	}
+}
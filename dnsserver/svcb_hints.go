@@ -0,0 +1,192 @@
+package dnsserver
+
+import (
+	"net"
+	"slices"
+	"strings"
+
+	"smartdnssort/config"
+	"smartdnssort/logger"
+
+	"github.com/miekg/dns"
+)
+
+// rewriteSVCBAnswer 按配置改写应答中的 HTTPS/SVCB 记录
+//   - 命中按域名规则时移除地址提示或 ECH 配置
+//   - 开启 rewrite_hints 时，用目标名排序缓存中的 IP 顺序替换 ipv4hint/ipv6hint；
+//     目标名尚未完成排序时保留上游提示
+//
+// 被修改的记录先复制再改写，不影响缓存中的对象。
+// 带签名的 RRset 不做提示重排（改写会使签名失效）；按策略移除参数时同时去掉对应的 RRSIG 并清除 AD 位
+func (s *Server) rewriteSVCBAnswer(msg *dns.Msg, domain string, cfg *config.SVCBConfig) {
+	if len(msg.Question) == 0 {
+		return
+	}
+	qtype := msg.Question[0].Qtype
+	if qtype != dns.TypeHTTPS && qtype != dns.TypeSVCB {
+		return
+	}
+	rule := matchSVCBRule(cfg.Rules, domain)
+	if rule == nil && !cfg.RewriteHints {
+		return
+	}
+
+	signed := hasCoveringRRSIG(msg.Answer, qtype)
+	modified := false
+	for i, rr := range msg.Answer {
+		svcb := svcbOf(rr)
+		if svcb == nil || svcb.Priority == 0 { // 别名模式不携带参数
+			continue
+		}
+
+		values, changed := svcb.Value, false
+		if rule != nil {
+			values, changed = stripSVCBParams(values, rule.StripHints, rule.StripECH)
+		}
+		if cfg.RewriteHints && !signed && (rule == nil || !rule.StripHints) {
+			var reordered bool
+			values, reordered = s.sortSVCBHints(values, svcbTarget(svcb))
+			changed = changed || reordered
+		}
+		if !changed {
+			continue
+		}
+
+		cp := dns.Copy(rr)
+		svcbOf(cp).Value = values
+		msg.Answer[i] = cp
+		modified = true
+	}
+
+	if modified && signed {
+		msg.Answer = slices.DeleteFunc(msg.Answer, func(rr dns.RR) bool {
+			sig, ok := rr.(*dns.RRSIG)
+			return ok && sig.TypeCovered == qtype
+		})
+		msg.AuthenticatedData = false
+	}
+	if modified {
+		logger.Debugf("[SVCB] %s: rewrote %s parameters", domain, dns.TypeToString[qtype])
+	}
+}
+
+// matchSVCBRule 返回第一条命中的按域名规则（匹配域名自身及其子域名）
+func matchSVCBRule(rules []config.SVCBRuleConfig, domain string) *config.SVCBRuleConfig {
+	if len(rules) == 0 {
+		return nil
+	}
+	domain = strings.ToLower(strings.TrimRight(domain, "."))
+	for i := range rules {
+		suffix := strings.ToLower(strings.Trim(strings.TrimSpace(rules[i].Domain), "."))
+		if suffix != "" && (domain == suffix || strings.HasSuffix(domain, "."+suffix)) {
+			return &rules[i]
+		}
+	}
+	return nil
+}
+
+// sortSVCBHints 用目标名的排序结果替换已有的地址提示，只改写上游给出的地址族
+func (s *Server) sortSVCBHints(values []dns.SVCBKeyValue, target string) ([]dns.SVCBKeyValue, bool) {
+	var out []dns.SVCBKeyValue
+	for i, kv := range values {
+		var replaced dns.SVCBKeyValue
+		switch hint := kv.(type) {
+		case *dns.SVCBIPv4Hint:
+			if ips := s.sortedHintIPs(target, dns.TypeA); len(ips) > 0 && !slices.EqualFunc(ips, hint.Hint, net.IP.Equal) {
+				replaced = &dns.SVCBIPv4Hint{Hint: ips}
+			}
+		case *dns.SVCBIPv6Hint:
+			if ips := s.sortedHintIPs(target, dns.TypeAAAA); len(ips) > 0 && !slices.EqualFunc(ips, hint.Hint, net.IP.Equal) {
+				replaced = &dns.SVCBIPv6Hint{Hint: ips}
+			}
+		}
+		if replaced == nil {
+			continue
+		}
+		if out == nil {
+			out = slices.Clone(values)
+		}
+		out[i] = replaced
+	}
+	if out == nil {
+		return values, false
+	}
+	return out, true
+}
+
+// sortedHintIPs 返回目标名排序缓存中的 IP（已应用手动排序规则），未排序时返回 nil
+func (s *Server) sortedHintIPs(target string, qtype uint16) []net.IP {
+	sorted, ok := s.cache.GetSorted(target, qtype)
+	if !ok || len(sorted.IPs) == 0 {
+		return nil
+	}
+	var ips []net.IP
+	for _, str := range s.applySortRules(target, sorted.IPs) {
+		ip := net.ParseIP(str)
+		if ip == nil || (ip.To4() != nil) != (qtype == dns.TypeA) {
+			continue
+		}
+		if qtype == dns.TypeA {
+			ip = ip.To4()
+		}
+		ips = append(ips, ip)
+	}
+	return ips
+}
+
+// stripSVCBParams 按策略移除地址提示和 ECH 配置，并同步清理 mandatory 中对应的键
+func stripSVCBParams(values []dns.SVCBKeyValue, hints, ech bool) ([]dns.SVCBKeyValue, bool) {
+	drop := func(key dns.SVCBKey) bool {
+		return (hints && (key == dns.SVCB_IPV4HINT || key == dns.SVCB_IPV6HINT)) || (ech && key == dns.SVCB_ECHCONFIG)
+	}
+
+	out := make([]dns.SVCBKeyValue, 0, len(values))
+	changed := false
+	for _, kv := range values {
+		if drop(kv.Key()) {
+			changed = true
+			continue
+		}
+		if m, ok := kv.(*dns.SVCBMandatory); ok && slices.ContainsFunc(m.Code, drop) {
+			codes := slices.DeleteFunc(slices.Clone(m.Code), drop)
+			changed = true
+			if len(codes) == 0 {
+				continue
+			}
+			kv = &dns.SVCBMandatory{Code: codes}
+		}
+		out = append(out, kv)
+	}
+	if !changed {
+		return values, false
+	}
+	return out, true
+}
+
+// svcbOf 返回 HTTPS/SVCB 记录中的 SVCB 部分，其他类型返回 nil
+func svcbOf(rr dns.RR) *dns.SVCB {
+	switch v := rr.(type) {
+	case *dns.SVCB:
+		return v
+	case *dns.HTTPS:
+		return &v.SVCB
+	}
+	return nil
+}
+
+// svcbTarget 返回服务实际连接的目标名，TargetName 为 "." 时即记录自身的 owner（RFC 9460 2.5.2）
+func svcbTarget(svcb *dns.SVCB) string {
+	target := svcb.Target
+	if target == "." || target == "" {
+		target = svcb.Hdr.Name
+	}
+	return strings.ToLower(strings.TrimRight(target, "."))
+}
+
+// hasCoveringRRSIG 判断记录集中是否有覆盖指定类型的签名
+func hasCoveringRRSIG(rrs []dns.RR, rrtype uint16) bool {
+	return slices.ContainsFunc(rrs, func(rr dns.RR) bool {
+		sig, ok := rr.(*dns.RRSIG)
+		return ok && sig.TypeCovered == rrtype
+	})
+}
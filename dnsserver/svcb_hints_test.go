@@ -0,0 +1,136 @@
+package dnsserver
+
+import (
+	"context"
+	"smartdnssort/cache"
+	"smartdnssort/config"
+	"smartdnssort/stats"
+	"smartdnssort/upstream"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func hintsOf(t *testing.T, msg *dns.Msg) (v4, v6 []string, ech bool) {
+	t.Helper()
+	for _, rr := range msg.Answer {
+		https, ok := rr.(*dns.HTTPS)
+		if !ok {
+			continue
+		}
+		for _, kv := range https.Value {
+			switch h := kv.(type) {
+			case *dns.SVCBIPv4Hint:
+				for _, ip := range h.Hint {
+					v4 = append(v4, ip.String())
+				}
+			case *dns.SVCBIPv6Hint:
+				for _, ip := range h.Hint {
+					v6 = append(v6, ip.String())
+				}
+			case *dns.SVCBECHConfig:
+				ech = true
+			}
+		}
+	}
+	return v4, v6, ech
+}
+
+// Test_SVCBHints_RewrittenFromSortedCache 验证未命中与命中两条路径都按排序缓存改写地址提示
+func Test_SVCBHints_RewrittenFromSortedCache(t *testing.T) {
+	server, cfg := newTestServer(t)
+	cfg.DNS.SVCB.RewriteHints = true
+	s := stats.NewStats(&cfg.Stats)
+
+	// 目标名已完成测速：192.0.2.3 最快
+	server.cache.SetSorted("example.com", dns.TypeA, &cache.SortedCacheEntry{
+		IPs: []string{"192.0.2.3", "192.0.2.1"}, RTTs: []int{5, 40}, Timestamp: time.Now(), TTL: 300, IsValid: true,
+	})
+
+	mockUpstream := &MockUpstream{
+		ExchangeFunc: func(ctx context.Context, msg *dns.Msg) (*dns.Msg, error) {
+			resp := new(dns.Msg)
+			resp.SetReply(msg)
+			resp.Answer = []dns.RR{mustTestRR(t,
+				`example.com. 300 IN HTTPS 1 . alpn="h3,h2" ipv4hint="192.0.2.1,192.0.2.2" ech="AEX+DQBBpQAgACDf" ipv6hint="2001:db8::1"`)}
+			return resp, nil
+		},
+	}
+	mgr := upstream.NewManager(&cfg.Upstream, []upstream.Upstream{mockUpstream}, s, nil)
+
+	req := new(dns.Msg)
+	req.SetQuestion("example.com.", dns.TypeHTTPS)
+	w := &capturingResponseWriter{}
+	server.handleGenericCacheMiss(w, req, "example.com", dns.TypeHTTPS, context.Background(), mgr, cfg, s, nil)
+	if w.LastMsg == nil {
+		t.Fatal("no response written on cache miss")
+	}
+	v4, v6, ech := hintsOf(t, w.LastMsg)
+	if len(v4) != 2 || v4[0] != "192.0.2.3" || v4[1] != "192.0.2.1" {
+		t.Errorf("ipv4hint = %v, want sorted order [192.0.2.3 192.0.2.1]", v4)
+	}
+	if len(v6) != 1 || v6[0] != "2001:db8::1" || !ech {
+		t.Errorf("unsorted family and ech should be kept: v6=%v ech=%v", v6, ech)
+	}
+
+	raw, ok := server.cache.GetRaw("example.com", dns.TypeHTTPS)
+	if !ok || raw.Msg == nil {
+		t.Fatal("HTTPS response was not cached")
+	}
+	if v4, _, _ := hintsOf(t, raw.Msg); len(v4) != 2 || v4[0] != "192.0.2.1" {
+		t.Errorf("cached message must keep upstream hints, got %v", v4)
+	}
+
+	// 按域名策略：移除提示和 ECH，命中路径同样生效
+	cfg.DNS.SVCB.Rules = []config.SVCBRuleConfig{{Domain: "example.com", StripHints: true, StripECH: true}}
+	w = &capturingResponseWriter{}
+	if !server.handleRawCacheHitGeneric(w, req, "example.com", dns.TypeHTTPS, cfg, s) {
+		t.Fatal("expected generic cache hit")
+	}
+	v4, v6, ech = hintsOf(t, w.LastMsg)
+	if len(v4) != 0 || len(v6) != 0 || ech {
+		t.Errorf("hints and ech should be stripped: v4=%v v6=%v ech=%v", v4, v6, ech)
+	}
+	if https := w.LastMsg.Answer[0].(*dns.HTTPS); len(https.Value) != 1 || https.Value[0].Key() != dns.SVCB_ALPN {
+		t.Errorf("other parameters should be preserved: %v", https)
+	}
+}
+
+func Test_RewriteSVCBAnswer_Signed(t *testing.T) {
+	server, _ := newTestServer(t)
+	server.cache.SetSorted("svc.example.net", dns.TypeA, &cache.SortedCacheEntry{
+		IPs: []string{"198.51.100.9"}, RTTs: []int{5}, Timestamp: time.Now(), TTL: 300, IsValid: true,
+	})
+
+	build := func() *dns.Msg {
+		m := new(dns.Msg)
+		m.SetQuestion("example.net.", dns.TypeHTTPS)
+		m.AuthenticatedData = true
+		m.Answer = []dns.RR{
+			mustTestRR(t, `example.net. 300 IN HTTPS 1 svc.example.net. mandatory=ech ech="AEX+DQBBpQAgACDf" ipv4hint="198.51.100.1"`),
+			mustTestRR(t, "example.net. 300 IN RRSIG HTTPS 13 2 300 20300101000000 20200101000000 12345 example.net. AAAA"),
+		}
+		return m
+	}
+
+	// 签名记录不做提示重排
+	msg := build()
+	server.rewriteSVCBAnswer(msg, "example.net", &config.SVCBConfig{RewriteHints: true})
+	if v4, _, _ := hintsOf(t, msg); len(v4) != 1 || v4[0] != "198.51.100.1" || len(msg.Answer) != 2 || !msg.AuthenticatedData {
+		t.Errorf("signed RRset should be left intact: %v", msg.Answer)
+	}
+
+	// 策略优先：移除 ECH（含 mandatory 中的键），同时去掉失效的签名并清除 AD
+	msg = build()
+	server.rewriteSVCBAnswer(msg, "example.net", &config.SVCBConfig{
+		Rules: []config.SVCBRuleConfig{{Domain: "net", StripECH: true}},
+	})
+	if len(msg.Answer) != 1 || msg.AuthenticatedData {
+		t.Fatalf("RRSIG should be dropped and AD cleared: ad=%v %v", msg.AuthenticatedData, msg.Answer)
+	}
+	https := msg.Answer[0].(*dns.HTTPS)
+	if len(https.Value) != 1 || https.Value[0].Key() != dns.SVCB_IPV4HINT {
+		t.Errorf("ech and mandatory=ech should be removed: %v", https)
+	}
+}
@@ -1,6 +1,7 @@
 package dnsserver
 
 import (
+	"smartdnssort/cache"
 	"smartdnssort/logger"
 	"time"
 )
@@ -33,6 +34,18 @@ func (s *Server) startIPMonitorRoutine() {
 	s.ipMonitor.Start()
 }
 
+// saveCache 写入缓存快照；启用了预写日志时通过压缩完成，final 为 true 时同时关闭日志
+// 预写日志未能打开时退回到直接保存快照
+func (s *Server) saveCache(final bool) error {
+	if !s.cache.GetPersistenceStats().Enabled {
+		return s.cache.SaveToDisk(cache.PersistenceFile)
+	}
+	if final {
+		return s.cache.ClosePersistence()
+	}
+	return s.cache.Compact()
+}
+
 // saveCacheRoutine 定期保存缓存到磁盘
 func (s *Server) saveCacheRoutine() {
 	interval := time.Duration(s.cfg.Cache.SaveToDiskIntervalMinutes) * time.Minute
@@ -48,11 +61,14 @@ func (s *Server) saveCacheRoutine() {
 			return
 		case <-ticker.C:
 			logger.Debug("[Cache] Saving cache to disk...")
-			if err := s.cache.SaveToDisk("dns_cache.bin"); err != nil {
+			if err := s.saveCache(false); err != nil {
 				logger.Errorf("[Cache] Failed to save cache: %v", err)
 			} else {
 				logger.Debug("[Cache] Cache saved successfully.")
 			}
+			if err := s.saveWarmupHistory(); err != nil {
+				logger.Errorf("[Warmup] Failed to save history: %v", err)
+			}
 		}
 	}
 }
@@ -151,3 +151,27 @@ func extractIPsFromRecords(records []dns.RR) []string {
 	}
 	return ips
 }
+
+// recordIPs 提取记录中的全部地址：A/AAAA 记录以及 HTTPS/SVCB 的 ipv4hint/ipv6hint
+func recordIPs(records []dns.RR) []string {
+	ips := extractIPsFromRecords(records)
+	for _, rr := range records {
+		svcb := svcbOf(rr)
+		if svcb == nil {
+			continue
+		}
+		for _, kv := range svcb.Value {
+			switch v := kv.(type) {
+			case *dns.SVCBIPv4Hint:
+				for _, ip := range v.Hint {
+					ips = append(ips, ip.String())
+				}
+			case *dns.SVCBIPv6Hint:
+				for _, ip := range v.Hint {
+					ips = append(ips, ip.String())
+				}
+			}
+		}
+	}
+	return ips
+}
@@ -0,0 +1,319 @@
+package dnsserver
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"smartdnssort/config"
+	"smartdnssort/logger"
+
+	"github.com/miekg/dns"
+)
+
+// 预热状态
+const (
+	WarmupStateIdle      = "idle"
+	WarmupStateRunning   = "running"
+	WarmupStateCompleted = "completed"
+	WarmupStateStopped   = "stopped"
+)
+
+// 预热域名来源
+const (
+	warmupSourceHistory = "history"
+	warmupSourceList    = "domain_list"
+)
+
+// WarmupStatus 启动预热进度
+type WarmupStatus struct {
+	Enabled    bool           `json:"enabled"`
+	State      string         `json:"state"`
+	Domains    int            `json:"domains"`
+	Total      int            `json:"total"`     // 解析任务数（域名 × 记录类型）
+	Completed  int            `json:"completed"` // 已处理的任务数
+	Resolved   int            `json:"resolved"`  // 解析成功并提交排序
+	Skipped    int            `json:"skipped"`   // 已有有效排序结果
+	Failed     int            `json:"failed"`
+	Sources    map[string]int `json:"sources"`
+	StartedAt  time.Time      `json:"started_at"`
+	FinishedAt time.Time      `json:"finished_at"`
+}
+
+// warmupRunner 保存预热配置与进度，配置在创建时固定，预热只在启动时执行一次
+type warmupRunner struct {
+	cfg    config.WarmupConfig
+	once   sync.Once
+	mu     sync.Mutex
+	status WarmupStatus
+}
+
+func newWarmupRunner(cfg config.WarmupConfig) *warmupRunner {
+	return &warmupRunner{
+		cfg:    cfg,
+		status: WarmupStatus{Enabled: cfg.Enabled, State: WarmupStateIdle, Sources: map[string]int{}},
+	}
+}
+
+// warmupHistory 热门域名历史文件格式
+type warmupHistory struct {
+	SavedAt time.Time `json:"saved_at"`
+	Domains []string  `json:"domains"`
+}
+
+type warmupOutcome int
+
+const (
+	warmupResolved warmupOutcome = iota
+	warmupSkipped
+	warmupFailed
+)
+
+// GetWarmupStatus 返回启动预热进度
+func (s *Server) GetWarmupStatus() WarmupStatus {
+	w := s.warmup
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	st := w.status
+	st.Sources = make(map[string]int, len(w.status.Sources))
+	for k, v := range w.status.Sources {
+		st.Sources[k] = v
+	}
+	return st
+}
+
+// startWarmup 在 UDP 监听就绪后启动预热，重复调用只执行一次
+func (s *Server) startWarmup() {
+	if !s.warmup.cfg.Enabled {
+		return
+	}
+	s.warmup.once.Do(func() {
+		go func() {
+			domains, sources := s.collectWarmupDomains()
+			s.runWarmup(domains, sources)
+		}()
+	})
+}
+
+// collectWarmupDomains 合并历史文件与域名列表，历史中的热门域名优先
+func (s *Server) collectWarmupDomains() ([]string, map[string]int) {
+	cfg := s.warmup.cfg
+	sources := map[string]int{}
+
+	history, err := loadWarmupHistory(cfg.HistoryFile)
+	if err != nil {
+		logger.Warnf("[Warmup] Failed to load history %s: %v", cfg.HistoryFile, err)
+	}
+	sources[warmupSourceHistory] = len(history)
+
+	var list []string
+	if cfg.DomainListFile != "" {
+		list, err = loadDomainList(cfg.DomainListFile)
+		if err != nil {
+			logger.Warnf("[Warmup] Failed to load domain list %s: %v", cfg.DomainListFile, err)
+		}
+		sources[warmupSourceList] = len(list)
+	}
+
+	return mergeWarmupDomains(cfg.MaxDomains, history, list), sources
+}
+
+// runWarmup 以限定速率解析并排序给定域名，收到停止信号时提前结束
+func (s *Server) runWarmup(domains []string, sources map[string]int) {
+	cfg := s.warmup.cfg
+	qtypes := []uint16{dns.TypeA}
+	if s.cfg.DNS.EnableIPv6 {
+		qtypes = append(qtypes, dns.TypeAAAA)
+	}
+
+	w := s.warmup
+	w.mu.Lock()
+	w.status.State = WarmupStateRunning
+	w.status.Domains = len(domains)
+	w.status.Total = len(domains) * len(qtypes)
+	w.status.Sources = sources
+	w.status.StartedAt = time.Now()
+	w.mu.Unlock()
+	logger.Infof("[Warmup] Warming %d domains (rate=%d/s, concurrency=%d)", len(domains), cfg.RatePerSecond, cfg.Concurrency)
+
+	tasks := make(chan RefreshTask)
+	var wg sync.WaitGroup
+	for i := 0; i < max(cfg.Concurrency, 1); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for task := range tasks {
+				outcome := s.warmupDomain(task.Domain, task.Qtype)
+				w.mu.Lock()
+				w.status.Completed++
+				switch outcome {
+				case warmupResolved:
+					w.status.Resolved++
+				case warmupSkipped:
+					w.status.Skipped++
+				default:
+					w.status.Failed++
+				}
+				w.mu.Unlock()
+			}
+		}()
+	}
+
+	interval := time.Second / time.Duration(max(cfg.RatePerSecond, 1))
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	stopped := false
+feed:
+	for _, domain := range domains {
+		for _, qtype := range qtypes {
+			select {
+			case <-s.stopCh:
+				stopped = true
+				break feed
+			case tasks <- RefreshTask{Domain: domain, Qtype: qtype}:
+			}
+			select {
+			case <-s.stopCh:
+				stopped = true
+				break feed
+			case <-ticker.C:
+			}
+		}
+	}
+	close(tasks)
+	wg.Wait()
+
+	w.mu.Lock()
+	w.status.FinishedAt = time.Now()
+	if stopped {
+		w.status.State = WarmupStateStopped
+	} else {
+		w.status.State = WarmupStateCompleted
+	}
+	st := w.status
+	w.mu.Unlock()
+	logger.Infof("[Warmup] %s: resolved=%d skipped=%d failed=%d in %v",
+		st.State, st.Resolved, st.Skipped, st.Failed, st.FinishedAt.Sub(st.StartedAt).Round(time.Millisecond))
+}
+
+// warmupDomain 预热单个域名：已有有效排序则跳过，原始缓存仍有效则直接排序，否则向上游解析
+func (s *Server) warmupDomain(domain string, qtype uint16) warmupOutcome {
+	if sorted, ok := s.cache.GetSorted(domain, qtype); ok && !sorted.IsExpired() {
+		return warmupSkipped
+	}
+	if raw, ok := s.cache.GetRaw(domain, qtype); ok && !raw.IsExpired() && len(raw.IPs) > 0 {
+		s.sortIPsAsync(domain, qtype, raw.IPs, raw.UpstreamTTL, raw.AcquisitionTime)
+		return warmupResolved
+	}
+
+	started := time.Now()
+	s.refreshCacheAsync(RefreshTask{Domain: domain, Qtype: qtype})
+	if raw, ok := s.cache.GetRaw(domain, qtype); ok && !raw.AcquisitionTime.Before(started) {
+		return warmupResolved
+	}
+	return warmupFailed
+}
+
+// saveWarmupHistory 将预取评分和热门域名统计中的高频域名写入历史文件，供下次启动预热
+func (s *Server) saveWarmupHistory() error {
+	cfg := s.warmup.cfg
+	if !cfg.Enabled || cfg.HistoryFile == "" {
+		return nil
+	}
+	limit := max(cfg.MaxDomains, 1)
+	var hot []string
+	for _, dc := range s.stats.GetTopDomains(limit) {
+		hot = append(hot, dc.Domain)
+	}
+	domains := mergeWarmupDomains(limit, s.prefetcher.TopScoredDomains(limit), hot)
+	if len(domains) == 0 {
+		// 刚启动、尚无查询时不要覆盖上次的历史
+		return nil
+	}
+	return writeWarmupHistory(cfg.HistoryFile, domains)
+}
+
+// writeWarmupHistory 通过临时文件原子写入历史文件
+func writeWarmupHistory(path string, domains []string) error {
+	data, err := json.Marshal(warmupHistory{SavedAt: time.Now(), Domains: domains})
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// loadWarmupHistory 读取历史文件，文件不存在时返回空列表
+func loadWarmupHistory(path string) ([]string, error) {
+	if path == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var h warmupHistory
+	if err := json.Unmarshal(data, &h); err != nil {
+		return nil, err
+	}
+	return h.Domains, nil
+}
+
+// loadDomainList 读取域名列表文件：每行一个域名，空行和 # 开头的行忽略，行内 # 之后视为注释
+func loadDomainList(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var domains []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		if fields := strings.Fields(line); len(fields) > 0 {
+			domains = append(domains, fields[0])
+		}
+	}
+	return domains, scanner.Err()
+}
+
+// mergeWarmupDomains 规范化、去重并按先后顺序合并多个域名列表，最多保留 limit 个
+func mergeWarmupDomains(limit int, lists ...[]string) []string {
+	seen := make(map[string]struct{})
+	var merged []string
+	for _, list := range lists {
+		for _, d := range list {
+			d = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(d)), ".")
+			if d == "" {
+				continue
+			}
+			if _, ok := dns.IsDomainName(d); !ok {
+				continue
+			}
+			if _, dup := seen[d]; dup {
+				continue
+			}
+			if limit > 0 && len(merged) >= limit {
+				return merged
+			}
+			seen[d] = struct{}{}
+			merged = append(merged, d)
+		}
+	}
+	return merged
+}
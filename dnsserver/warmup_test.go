@@ -0,0 +1,89 @@
+package dnsserver
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"smartdnssort/cache"
+	"smartdnssort/config"
+
+	"github.com/miekg/dns"
+)
+
+func TestLoadDomainListAndMerge(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "warmup.txt")
+	content := "# 常用站点\nwww.Example.com.\n\n  api.example.com   # 行内注释\nbad..name\nwww.example.com\n"
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	list, err := loadDomainList(path)
+	if err != nil {
+		t.Fatalf("loadDomainList: %v", err)
+	}
+	if want := []string{"www.Example.com.", "api.example.com", "bad..name", "www.example.com"}; !reflect.DeepEqual(list, want) {
+		t.Fatalf("list = %v, want %v", list, want)
+	}
+
+	history := []string{"hot.example.com", "api.example.com"}
+	merged := mergeWarmupDomains(0, history, list)
+	if want := []string{"hot.example.com", "api.example.com", "www.example.com"}; !reflect.DeepEqual(merged, want) {
+		t.Errorf("merged = %v, want %v", merged, want)
+	}
+	if got := mergeWarmupDomains(2, history, list); len(got) != 2 {
+		t.Errorf("limit not applied: %v", got)
+	}
+}
+
+func TestWarmupHistoryRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "warmup_history.json")
+
+	domains, err := loadWarmupHistory(path)
+	if err != nil || domains != nil {
+		t.Fatalf("missing history should be empty, got %v, %v", domains, err)
+	}
+
+	want := []string{"a.example.com", "b.example.com"}
+	if err := writeWarmupHistory(path, want); err != nil {
+		t.Fatalf("writeWarmupHistory: %v", err)
+	}
+	domains, err = loadWarmupHistory(path)
+	if err != nil {
+		t.Fatalf("loadWarmupHistory: %v", err)
+	}
+	if !reflect.DeepEqual(domains, want) {
+		t.Errorf("domains = %v, want %v", domains, want)
+	}
+}
+
+func TestRunWarmupSkipsFreshEntries(t *testing.T) {
+	s := newTestServerForSorting(&config.Config{
+		Warmup: config.WarmupConfig{Enabled: true, RatePerSecond: 1000, Concurrency: 2},
+	})
+	domains := []string{"a.example.com", "b.example.com"}
+	for _, d := range domains {
+		s.cache.SetSorted(d, dns.TypeA, &cache.SortedCacheEntry{
+			IPs:       []string{"192.0.2.1"},
+			RTTs:      []int{10},
+			Timestamp: time.Now(),
+			TTL:       300,
+			IsValid:   true,
+		})
+	}
+
+	s.runWarmup(domains, map[string]int{warmupSourceList: len(domains)})
+
+	st := s.GetWarmupStatus()
+	if st.State != WarmupStateCompleted {
+		t.Errorf("state = %s, want %s", st.State, WarmupStateCompleted)
+	}
+	if st.Total != 2 || st.Completed != 2 || st.Skipped != 2 || st.Failed != 0 {
+		t.Errorf("unexpected progress: %+v", st)
+	}
+	if st.Sources[warmupSourceList] != 2 {
+		t.Errorf("sources = %v", st.Sources)
+	}
+}
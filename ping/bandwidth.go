@@ -0,0 +1,277 @@
+package ping
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"smartdnssort/logger"
+)
+
+// BandwidthRule 单个域名的带宽探测规则
+type BandwidthRule struct {
+	Pattern string // 域名，*.example.com 匹配所有子域名
+	Path    string // 测速路径，默认 "/"
+	Scheme  string // https（默认）或 http
+}
+
+// BandwidthOptions 带宽探测配置
+type BandwidthOptions struct {
+	Rules        []BandwidthRule
+	MaxBytes     int64         // 单次测速最多下载的字节数
+	Timeout      time.Duration // 单次测速超时
+	Concurrency  int           // 同时进行的测速数
+	BytesPerHour int64         // 每小时下载字节上限，0 表示不限制
+	CacheTTL     time.Duration // 测速结果有效期
+}
+
+// BandwidthStats 带宽探测统计
+type BandwidthStats struct {
+	Enabled      bool             `json:"enabled"`
+	Rules        int              `json:"rules"`
+	Measurements int64            `json:"measurements"`
+	Failures     int64            `json:"failures"`
+	Deferred     int64            `json:"deferred"`
+	BytesFetched int64            `json:"bytes_fetched"`
+	InFlight     int              `json:"in_flight"`
+	Budget       ProbeBudgetStats `json:"budget"`
+}
+
+// BandwidthProber 面向大文件下载域名的吞吐量探测器
+// 对候选 IP 携带正确的 Host/SNI 下载前 N 字节，测得的吞吐量写入 IPPool，
+// 排序时吞吐量高的 IP 优先于单纯 RTT 低的 IP
+type BandwidthProber struct {
+	exact    map[string]BandwidthRule
+	wildcard map[string]BandwidthRule
+
+	maxBytes int64
+	timeout  time.Duration
+	cacheTTL time.Duration
+	sem      chan struct{}
+	budget   *ProbeBudget // 独立的字节预算，与延迟探测预算互不影响
+
+	inflight      sync.Map // ip -> struct{}，避免同一 IP 重复测速
+	inflightCount atomic.Int32
+
+	measurements atomic.Int64
+	failures     atomic.Int64
+	deferred     atomic.Int64
+	bytesFetched atomic.Int64
+
+	// dialAddr 允许测试替换连接目标端口
+	dialAddr func(ip, port string) string
+}
+
+// NewBandwidthProber 创建带宽探测器
+func NewBandwidthProber(opts BandwidthOptions) *BandwidthProber {
+	if opts.MaxBytes <= 0 {
+		opts.MaxBytes = 512 * 1024
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = 10 * time.Second
+	}
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = 2
+	}
+	if opts.CacheTTL <= 0 {
+		opts.CacheTTL = 6 * time.Hour
+	}
+
+	b := &BandwidthProber{
+		exact:    make(map[string]BandwidthRule),
+		wildcard: make(map[string]BandwidthRule),
+		maxBytes: opts.MaxBytes,
+		timeout:  opts.Timeout,
+		cacheTTL: opts.CacheTTL,
+		sem:      make(chan struct{}, opts.Concurrency),
+		budget:   NewProbeBudget(0, opts.BytesPerHour, 0),
+		dialAddr: net.JoinHostPort,
+	}
+	for _, r := range opts.Rules {
+		pattern := strings.ToLower(strings.TrimRight(strings.TrimSpace(r.Pattern), "."))
+		if pattern == "" {
+			continue
+		}
+		if r.Path == "" {
+			r.Path = "/"
+		} else if !strings.HasPrefix(r.Path, "/") {
+			r.Path = "/" + r.Path
+		}
+		r.Scheme = strings.ToLower(r.Scheme)
+		if r.Scheme != "http" {
+			r.Scheme = "https"
+		}
+		r.Pattern = pattern
+		if suffix, ok := strings.CutPrefix(pattern, "*."); ok {
+			b.wildcard[suffix] = r
+		} else {
+			b.exact[pattern] = r
+		}
+	}
+	return b
+}
+
+// Match 返回域名对应的带宽探测规则，精确匹配优先，其次是最近的通配符
+func (b *BandwidthProber) Match(domain string) (BandwidthRule, bool) {
+	if b == nil {
+		return BandwidthRule{}, false
+	}
+	domain = strings.ToLower(strings.TrimRight(domain, "."))
+	if r, ok := b.exact[domain]; ok {
+		return r, true
+	}
+	for rest := domain; ; {
+		idx := strings.IndexByte(rest, '.')
+		if idx < 0 {
+			return BandwidthRule{}, false
+		}
+		rest = rest[idx+1:]
+		if r, ok := b.wildcard[rest]; ok {
+			return r, true
+		}
+	}
+}
+
+// Ranks 返回域名候选 IP 在有效期内的吞吐量（KB/s）
+// 域名未配置带宽排序时返回 nil；没有数据的 IP 不在结果中
+func (b *BandwidthProber) Ranks(pool *IPPool, domain string, ips []string) map[string]float64 {
+	if pool == nil {
+		return nil
+	}
+	if _, ok := b.Match(domain); !ok {
+		return nil
+	}
+	return pool.GetIPBandwidths(ips, b.cacheTTL)
+}
+
+// Refresh 异步测量缺少有效吞吐量数据的 IP，立即返回
+func (b *BandwidthProber) Refresh(pool *IPPool, domain string, ips []string) {
+	if pool == nil {
+		return
+	}
+	rule, ok := b.Match(domain)
+	if !ok {
+		return
+	}
+
+	fresh := pool.GetIPBandwidths(ips, b.cacheTTL)
+	for _, ip := range ips {
+		if _, ok := fresh[ip]; ok {
+			continue
+		}
+		if _, loaded := b.inflight.LoadOrStore(ip, struct{}{}); loaded {
+			continue
+		}
+		b.inflightCount.Add(1)
+		go func(ip string) {
+			defer func() {
+				b.inflight.Delete(ip)
+				b.inflightCount.Add(-1)
+			}()
+			b.sem <- struct{}{}
+			defer func() { <-b.sem }()
+
+			// 独立预算，无需为其他探测预留份额，按用户优先级申请
+			ctx, cancel := context.WithTimeout(context.Background(), b.timeout)
+			defer cancel()
+			if !b.budget.Acquire(ctx, ProbePriorityUser, 0, b.maxBytes) {
+				b.deferred.Add(1)
+				return
+			}
+
+			kbps, err := b.Measure(ctx, ip, domain, rule)
+			if err != nil {
+				b.failures.Add(1)
+				logger.Debugf("[Bandwidth] Probe %s (%s) failed: %v", ip, domain, err)
+				// 记录为 0，有效期内不再重复测速，排序时与无数据的 IP 同等对待
+				pool.UpdateIPBandwidth(ip, 0)
+				return
+			}
+			logger.Debugf("[Bandwidth] %s (%s): %.1f KB/s", ip, domain, kbps)
+			pool.UpdateIPBandwidth(ip, kbps)
+		}(ip)
+	}
+}
+
+// Measure 携带 Host/SNI 向指定 IP 发起 Range 请求，返回下载吞吐量（KB/s）
+// 计时从收到响应头开始，排除握手和首字节延迟，只衡量传输速率
+func (b *BandwidthProber) Measure(ctx context.Context, ip, host string, rule BandwidthRule) (float64, error) {
+	port := "443"
+	if rule.Scheme == "http" {
+		port = "80"
+	}
+	addr := b.dialAddr(ip, port)
+	host = strings.TrimRight(host, ".")
+
+	dialer := &net.Dialer{Timeout: b.timeout}
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			return dialer.DialContext(ctx, "tcp", addr)
+		},
+		TLSClientConfig:   &tls.Config{ServerName: host},
+		DisableKeepAlives: true,
+	}
+	defer transport.CloseIdleConnections()
+	client := &http.Client{
+		Transport: transport,
+		// 跳转可能指向其他主机，测得的不再是该 IP 的速率
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rule.Scheme+"://"+host+rule.Path, nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=0-%d", b.maxBytes-1))
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
+		return 0, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	start := time.Now()
+	n, err := io.Copy(io.Discard, io.LimitReader(resp.Body, b.maxBytes))
+	elapsed := time.Since(start)
+	b.bytesFetched.Add(n)
+	if err != nil && n == 0 {
+		return 0, err
+	}
+	if n == 0 {
+		return 0, fmt.Errorf("empty response body")
+	}
+	b.measurements.Add(1)
+
+	if elapsed < time.Millisecond {
+		elapsed = time.Millisecond
+	}
+	return float64(n) / 1024 / elapsed.Seconds(), nil
+}
+
+// GetStats 获取带宽探测统计
+func (b *BandwidthProber) GetStats() BandwidthStats {
+	if b == nil {
+		return BandwidthStats{}
+	}
+	return BandwidthStats{
+		Enabled:      true,
+		Rules:        len(b.exact) + len(b.wildcard),
+		Measurements: b.measurements.Load(),
+		Failures:     b.failures.Load(),
+		Deferred:     b.deferred.Load(),
+		BytesFetched: b.bytesFetched.Load(),
+		InFlight:     int(b.inflightCount.Load()),
+		Budget:       b.budget.GetStats(),
+	}
+}
@@ -0,0 +1,118 @@
+package ping
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBandwidthProberMatch(t *testing.T) {
+	b := NewBandwidthProber(BandwidthOptions{Rules: []BandwidthRule{
+		{Pattern: "dl.example.com", Path: "big.bin"},
+		{Pattern: "*.cdn.example.com", Scheme: "HTTP"},
+	}})
+
+	r, ok := b.Match("DL.example.com.")
+	if !ok || r.Path != "/big.bin" || r.Scheme != "https" {
+		t.Errorf("exact rule not normalized: %+v ok=%v", r, ok)
+	}
+	r, ok = b.Match("v1.edge.cdn.example.com")
+	if !ok || r.Path != "/" || r.Scheme != "http" {
+		t.Errorf("wildcard rule not matched: %+v ok=%v", r, ok)
+	}
+	if _, ok := b.Match("cdn.example.com"); ok {
+		t.Error("wildcard should not match the apex")
+	}
+
+	var nilProber *BandwidthProber
+	if _, ok := nilProber.Match("dl.example.com"); ok {
+		t.Error("nil prober should match nothing")
+	}
+}
+
+func TestBandwidthProberMeasureAndRefresh(t *testing.T) {
+	payload := strings.Repeat("x", 64*1024)
+	var gotHost, gotRange string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotHost, gotRange = r.Host, r.Header.Get("Range")
+		w.WriteHeader(http.StatusPartialContent)
+		w.Write([]byte(payload))
+	}))
+	defer srv.Close()
+
+	b := NewBandwidthProber(BandwidthOptions{
+		Rules:    []BandwidthRule{{Pattern: "dl.example.com", Path: "/file", Scheme: "http"}},
+		MaxBytes: 16 * 1024,
+		Timeout:  2 * time.Second,
+	})
+	// 所有 IP 都连到测试服务器
+	b.dialAddr = func(ip, port string) string { return srv.Listener.Addr().String() }
+
+	rule, _ := b.Match("dl.example.com")
+	kbps, err := b.Measure(context.Background(), "192.0.2.1", "dl.example.com", rule)
+	if err != nil {
+		t.Fatalf("measure failed: %v", err)
+	}
+	if kbps <= 0 {
+		t.Errorf("expected positive throughput, got %f", kbps)
+	}
+	if gotHost != "dl.example.com" || gotRange != "bytes=0-16383" {
+		t.Errorf("unexpected request: host=%q range=%q", gotHost, gotRange)
+	}
+	if stats := b.GetStats(); stats.BytesFetched != 16*1024 || stats.Measurements != 1 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+
+	pool := NewIPPool()
+	ips := []string{"192.0.2.1", "192.0.2.2"}
+	pool.UpdateDomainIPs(nil, ips, "dl.example.com")
+
+	if ranks := b.Ranks(pool, "other.com", ips); ranks != nil {
+		t.Errorf("unconfigured domain should not be ranked, got %v", ranks)
+	}
+
+	b.Refresh(pool, "dl.example.com", ips)
+	deadline := time.Now().Add(2 * time.Second)
+	for len(b.Ranks(pool, "dl.example.com", ips)) < len(ips) && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	ranks := b.Ranks(pool, "dl.example.com", ips)
+	if len(ranks) != len(ips) || ranks["192.0.2.2"] <= 0 {
+		t.Errorf("refresh should store throughput in the pool, got %v", ranks)
+	}
+}
+
+func TestBandwidthProberByteBudget(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(strings.Repeat("x", 1024)))
+	}))
+	defer srv.Close()
+
+	// 每小时只够一次测速
+	b := NewBandwidthProber(BandwidthOptions{
+		Rules:        []BandwidthRule{{Pattern: "dl.example.com", Scheme: "http"}},
+		MaxBytes:     1024,
+		BytesPerHour: 1500,
+		Concurrency:  1,
+	})
+	b.dialAddr = func(ip, port string) string { return srv.Listener.Addr().String() }
+
+	pool := NewIPPool()
+	ips := []string{"192.0.2.1", "192.0.2.2"}
+	pool.UpdateDomainIPs(nil, ips, "dl.example.com")
+	b.Refresh(pool, "dl.example.com", ips)
+
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if s := b.GetStats(); s.InFlight == 0 && s.Measurements+s.Deferred == 2 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if s := b.GetStats(); s.Measurements != 1 || s.Deferred != 1 {
+		t.Errorf("expected one measurement and one deferral, got %+v", s)
+	}
+}
@@ -0,0 +1,205 @@
+package ping
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+
+	"smartdnssort/logger"
+)
+
+// GeoIPInfo 单个 IP 的离线地理/自治系统信息
+type GeoIPInfo struct {
+	ASN     uint32 `json:"asn,omitempty"`
+	ASOrg   string `json:"as_org,omitempty"`
+	Country string `json:"country,omitempty"` // ISO 3166-1 alpha-2，大写
+}
+
+// GeoIPRanker 基于本地 MMDB 数据库的离线排序器
+// 偏好顺序：先匹配偏好 ASN（按列表顺序），再匹配偏好国家/地区（按列表顺序），其余排最后
+// 用途：
+//  1. 作为 RTT 相同（或落在同一 RTT 桶内）时的次级排序键
+//  2. 完全没有 RTT 数据时（断网、Ping 关闭）作为主排序键，替代上游原始顺序
+type GeoIPRanker struct {
+	countryDB       *mmdbReader
+	asnDB           *mmdbReader
+	preferASNs      map[uint32]int
+	preferCountries map[string]int
+	rttBucketMs     int
+}
+
+// NewGeoIPRanker 创建 GeoIP 排序器
+// countryDB 和 asnDB 可以指向同一个包含两类字段的数据库，也可以只提供其一
+func NewGeoIPRanker(countryDB, asnDB string, preferASNs []uint32, preferCountries []string, rttBucketMs int) (*GeoIPRanker, error) {
+	r := &GeoIPRanker{
+		preferASNs:      make(map[uint32]int, len(preferASNs)),
+		preferCountries: make(map[string]int, len(preferCountries)),
+		rttBucketMs:     rttBucketMs,
+	}
+
+	if countryDB != "" {
+		db, err := openMMDB(countryDB)
+		if err != nil {
+			return nil, fmt.Errorf("load country db %s: %w", countryDB, err)
+		}
+		r.countryDB = db
+	}
+	if asnDB != "" {
+		if asnDB == countryDB && r.countryDB != nil {
+			r.asnDB = r.countryDB
+		} else {
+			db, err := openMMDB(asnDB)
+			if err != nil {
+				return nil, fmt.Errorf("load asn db %s: %w", asnDB, err)
+			}
+			r.asnDB = db
+		}
+	}
+	if r.countryDB == nil && r.asnDB == nil {
+		return nil, fmt.Errorf("no geoip database configured")
+	}
+
+	for i, asn := range preferASNs {
+		if _, exists := r.preferASNs[asn]; !exists {
+			r.preferASNs[asn] = i
+		}
+	}
+	for i, cc := range preferCountries {
+		cc = strings.ToUpper(strings.TrimSpace(cc))
+		if _, exists := r.preferCountries[cc]; cc != "" && !exists {
+			r.preferCountries[cc] = i
+		}
+	}
+
+	logger.Infof("[GeoIP] Ranker loaded (country_db=%q, asn_db=%q, preferred ASNs=%v, preferred countries=%v)",
+		countryDB, asnDB, preferASNs, preferCountries)
+	return r, nil
+}
+
+// Lookup 查询 IP 的 ASN/国家信息，查询失败返回零值
+func (r *GeoIPRanker) Lookup(ipStr string) GeoIPInfo {
+	var info GeoIPInfo
+	if r == nil {
+		return info
+	}
+	ip := net.ParseIP(ipStr)
+	if ip == nil {
+		return info
+	}
+
+	if r.asnDB != nil {
+		if rec, err := r.asnDB.lookup(ip); err == nil {
+			info.ASN, info.ASOrg = extractASN(rec)
+		}
+	}
+	if r.countryDB != nil {
+		if rec, err := r.countryDB.lookup(ip); err == nil {
+			info.Country = extractCountry(rec)
+			// 合并库（同时包含 ASN 字段）时补全 ASN
+			if info.ASN == 0 && r.countryDB != r.asnDB {
+				info.ASN, info.ASOrg = extractASN(rec)
+			}
+		}
+	}
+	return info
+}
+
+// Rank 返回 IP 的偏好等级，数值越小越优先
+// [0, len(ASNs)) 为 ASN 命中，随后是国家命中，未命中返回 len(ASNs)+len(Countries)
+func (r *GeoIPRanker) Rank(ip string) int {
+	if r == nil {
+		return 0
+	}
+	return r.rankInfo(r.Lookup(ip))
+}
+
+func (r *GeoIPRanker) rankInfo(info GeoIPInfo) int {
+	if info.ASN != 0 {
+		if idx, ok := r.preferASNs[info.ASN]; ok {
+			return idx
+		}
+	}
+	if info.Country != "" {
+		if idx, ok := r.preferCountries[info.Country]; ok {
+			return len(r.preferASNs) + idx
+		}
+	}
+	return len(r.preferASNs) + len(r.preferCountries)
+}
+
+// Ranks 批量计算偏好等级，供排序前一次性预计算，避免在比较函数中重复查库
+func (r *GeoIPRanker) Ranks(ips []string) map[string]int {
+	ranks := make(map[string]int, len(ips))
+	if r == nil {
+		return ranks
+	}
+	for _, ip := range ips {
+		ranks[ip] = r.Rank(ip)
+	}
+	return ranks
+}
+
+// RTTBucket 返回 RTT 所在的分桶，同一桶内的 IP 视为延迟相同，交由 GeoIP 偏好决定先后
+func (r *GeoIPRanker) RTTBucket(rtt int) int {
+	if r == nil || r.rttBucketMs <= 1 {
+		return rtt
+	}
+	return rtt / r.rttBucketMs
+}
+
+// SortIPs 在没有任何 RTT 数据时，按 GeoIP 偏好对 IP 排序
+// 同等级内保持上游原始顺序（稳定排序）
+func (r *GeoIPRanker) SortIPs(ips []string) []string {
+	sorted := make([]string, len(ips))
+	copy(sorted, ips)
+	if r == nil || len(sorted) < 2 {
+		return sorted
+	}
+	ranks := r.Ranks(sorted)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return ranks[sorted[i]] < ranks[sorted[j]]
+	})
+	return sorted
+}
+
+// extractASN 兼容 GeoLite2-ASN 格式 (autonomous_system_number) 和 "asn": "AS13335" 格式
+func extractASN(rec interface{}) (uint32, string) {
+	m, ok := rec.(map[string]interface{})
+	if !ok {
+		return 0, ""
+	}
+	org, _ := m["autonomous_system_organization"].(string)
+	if v, exists := m["autonomous_system_number"]; exists {
+		return uint32(mmdbUint(v)), org
+	}
+	if s, ok := m["asn"].(string); ok {
+		if n, err := strconv.ParseUint(strings.TrimPrefix(strings.ToUpper(s), "AS"), 10, 32); err == nil {
+			if org == "" {
+				org, _ = m["as_name"].(string)
+			}
+			return uint32(n), org
+		}
+	}
+	return 0, ""
+}
+
+// extractCountry 兼容 GeoLite2-Country 格式 (country.iso_code) 和扁平的 country_code 格式
+func extractCountry(rec interface{}) string {
+	m, ok := rec.(map[string]interface{})
+	if !ok {
+		return ""
+	}
+	for _, key := range []string{"country", "registered_country"} {
+		if sub, ok := m[key].(map[string]interface{}); ok {
+			if code, ok := sub["iso_code"].(string); ok && code != "" {
+				return strings.ToUpper(code)
+			}
+		}
+	}
+	if code, ok := m["country_code"].(string); ok {
+		return strings.ToUpper(code)
+	}
+	return ""
+}
@@ -0,0 +1,350 @@
+package ping
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"os"
+)
+
+// mmdbMetadataMarker MaxMind DB 元数据段的起始标记
+var mmdbMetadataMarker = []byte("\xAB\xCD\xEFMaxMind.com")
+
+// mmdbDataSectionSeparator 搜索树与数据段之间的 16 字节分隔区
+const mmdbDataSectionSeparator = 16
+
+// mmdbReader 极简的 MaxMind DB (MMDB) 只读解析器
+// 只实现了按 IP 查询所需的最小子集：搜索树遍历 + 数据段解码
+// 整个文件一次性读入内存，查询过程无锁、无 IO，可被并发调用
+type mmdbReader struct {
+	buf         []byte
+	nodeCount   uint
+	recordSize  uint
+	ipVersion   uint
+	dbType      string
+	treeSize    uint
+	dataSection []byte
+	ipv4Start   uint // IPv6 树中 ::/96 对应的节点，用于 IPv4 查询
+}
+
+// openMMDB 从文件加载 MMDB 数据库
+func openMMDB(path string) (*mmdbReader, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return newMMDBReader(data)
+}
+
+// newMMDBReader 从内存数据构建 MMDB 解析器
+func newMMDBReader(buf []byte) (*mmdbReader, error) {
+	idx := bytes.LastIndex(buf, mmdbMetadataMarker)
+	if idx < 0 {
+		return nil, errors.New("mmdb: metadata marker not found")
+	}
+	metaStart := idx + len(mmdbMetadataMarker)
+
+	d := mmdbDecoder{buf: buf[metaStart:]}
+	metaVal, _, err := d.decode(0, 0)
+	if err != nil {
+		return nil, fmt.Errorf("mmdb: decode metadata: %w", err)
+	}
+	meta, ok := metaVal.(map[string]interface{})
+	if !ok {
+		return nil, errors.New("mmdb: metadata is not a map")
+	}
+
+	r := &mmdbReader{buf: buf}
+	r.nodeCount = uint(mmdbUint(meta["node_count"]))
+	r.recordSize = uint(mmdbUint(meta["record_size"]))
+	r.ipVersion = uint(mmdbUint(meta["ip_version"]))
+	r.dbType, _ = meta["database_type"].(string)
+
+	switch r.recordSize {
+	case 24, 28, 32:
+	default:
+		return nil, fmt.Errorf("mmdb: unsupported record size %d", r.recordSize)
+	}
+	if r.ipVersion != 4 && r.ipVersion != 6 {
+		return nil, fmt.Errorf("mmdb: unsupported ip version %d", r.ipVersion)
+	}
+
+	r.treeSize = r.nodeCount * r.recordSize / 4
+	dataStart := r.treeSize + mmdbDataSectionSeparator
+	if dataStart > uint(idx) {
+		return nil, errors.New("mmdb: search tree exceeds file size")
+	}
+	r.dataSection = buf[dataStart:idx]
+
+	// IPv4 地址在 IPv6 树中位于 ::/96 之下，预先走完前 96 位
+	if r.ipVersion == 6 {
+		node := uint(0)
+		for i := 0; i < 96 && node < r.nodeCount; i++ {
+			node = r.readRecord(node, 0)
+		}
+		r.ipv4Start = node
+	}
+
+	return r, nil
+}
+
+// readRecord 读取节点 node 的左(0)/右(1)记录
+func (r *mmdbReader) readRecord(node uint, bit uint) uint {
+	switch r.recordSize {
+	case 24:
+		off := node*6 + bit*3
+		b := r.buf[off : off+3]
+		return uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+	case 28:
+		off := node * 7
+		b := r.buf[off : off+7]
+		if bit == 0 {
+			return uint(b[3]&0xF0)<<20 | uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+		}
+		return uint(b[3]&0x0F)<<24 | uint(b[4])<<16 | uint(b[5])<<8 | uint(b[6])
+	default: // 32
+		off := node*8 + bit*4
+		return uint(binary.BigEndian.Uint32(r.buf[off : off+4]))
+	}
+}
+
+// lookup 查询 IP 对应的数据记录，未命中时返回 nil
+func (r *mmdbReader) lookup(ip net.IP) (interface{}, error) {
+	var bits []byte
+	node := uint(0)
+	if v4 := ip.To4(); v4 != nil {
+		bits = v4
+		if r.ipVersion == 6 {
+			node = r.ipv4Start
+		}
+	} else {
+		if r.ipVersion == 4 {
+			return nil, nil
+		}
+		bits = ip.To16()
+		if bits == nil {
+			return nil, nil
+		}
+	}
+
+	bitCount := uint(len(bits) * 8)
+	for i := uint(0); i < bitCount && node < r.nodeCount; i++ {
+		bit := uint(bits[i>>3]>>(7-(i&7))) & 1
+		node = r.readRecord(node, bit)
+	}
+
+	if node == r.nodeCount {
+		return nil, nil
+	}
+	if node < r.nodeCount {
+		return nil, errors.New("mmdb: invalid search tree")
+	}
+
+	offset := node - r.nodeCount - mmdbDataSectionSeparator
+	if offset >= uint(len(r.dataSection)) {
+		return nil, errors.New("mmdb: data pointer out of range")
+	}
+	d := mmdbDecoder{buf: r.dataSection}
+	val, _, err := d.decode(offset, 0)
+	return val, err
+}
+
+// mmdbDecoder MMDB 数据段解码器
+type mmdbDecoder struct {
+	buf []byte
+}
+
+const (
+	mmdbTypeExtended  = 0
+	mmdbTypePointer   = 1
+	mmdbTypeString    = 2
+	mmdbTypeDouble    = 3
+	mmdbTypeBytes     = 4
+	mmdbTypeUint16    = 5
+	mmdbTypeUint32    = 6
+	mmdbTypeMap       = 7
+	mmdbTypeInt32     = 8
+	mmdbTypeUint64    = 9
+	mmdbTypeUint128   = 10
+	mmdbTypeArray     = 11
+	mmdbTypeContainer = 12
+	mmdbTypeEndMarker = 13
+	mmdbTypeBool      = 14
+	mmdbTypeFloat     = 15
+
+	// mmdbMaxDepth 防止恶意/损坏文件导致的无限递归
+	mmdbMaxDepth = 32
+)
+
+// decode 从 offset 处解码一个值，返回值和下一个值的偏移
+func (d *mmdbDecoder) decode(offset uint, depth int) (interface{}, uint, error) {
+	if depth > mmdbMaxDepth {
+		return nil, 0, errors.New("mmdb: data nested too deeply")
+	}
+	if offset >= uint(len(d.buf)) {
+		return nil, 0, errors.New("mmdb: unexpected end of data")
+	}
+
+	ctrl := d.buf[offset]
+	offset++
+	typeNum := uint(ctrl >> 5)
+
+	if typeNum == mmdbTypePointer {
+		ptr, next, err := d.decodePointer(ctrl, offset)
+		if err != nil {
+			return nil, 0, err
+		}
+		val, _, err := d.decode(ptr, depth+1)
+		return val, next, err
+	}
+
+	if typeNum == mmdbTypeExtended {
+		if offset >= uint(len(d.buf)) {
+			return nil, 0, errors.New("mmdb: unexpected end of data")
+		}
+		typeNum = 7 + uint(d.buf[offset])
+		offset++
+	}
+
+	size := uint(ctrl & 0x1f)
+	if size >= 29 {
+		n := size - 28
+		if offset+n > uint(len(d.buf)) {
+			return nil, 0, errors.New("mmdb: unexpected end of data")
+		}
+		v := uint(0)
+		for _, b := range d.buf[offset : offset+n] {
+			v = v<<8 | uint(b)
+		}
+		offset += n
+		switch size {
+		case 29:
+			size = 29 + v
+		case 30:
+			size = 285 + v
+		default:
+			size = 65821 + v
+		}
+	}
+
+	switch typeNum {
+	case mmdbTypeMap:
+		m := make(map[string]interface{}, size)
+		for i := uint(0); i < size; i++ {
+			k, next, err := d.decode(offset, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			key, ok := k.(string)
+			if !ok {
+				return nil, 0, errors.New("mmdb: map key is not a string")
+			}
+			v, next2, err := d.decode(next, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			m[key] = v
+			offset = next2
+		}
+		return m, offset, nil
+	case mmdbTypeArray:
+		arr := make([]interface{}, 0, size)
+		for i := uint(0); i < size; i++ {
+			v, next, err := d.decode(offset, depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			arr = append(arr, v)
+			offset = next
+		}
+		return arr, offset, nil
+	case mmdbTypeBool:
+		return size != 0, offset, nil
+	case mmdbTypeContainer, mmdbTypeEndMarker:
+		return nil, offset, nil
+	}
+
+	if offset+size > uint(len(d.buf)) {
+		return nil, 0, errors.New("mmdb: unexpected end of data")
+	}
+	raw := d.buf[offset : offset+size]
+	next := offset + size
+
+	switch typeNum {
+	case mmdbTypeString:
+		return string(raw), next, nil
+	case mmdbTypeBytes:
+		return append([]byte(nil), raw...), next, nil
+	case mmdbTypeDouble:
+		if size != 8 {
+			return nil, 0, errors.New("mmdb: invalid double size")
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(raw)), next, nil
+	case mmdbTypeFloat:
+		if size != 4 {
+			return nil, 0, errors.New("mmdb: invalid float size")
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(raw))), next, nil
+	case mmdbTypeUint16, mmdbTypeUint32, mmdbTypeUint64, mmdbTypeUint128:
+		if size > 8 {
+			// uint128 超出 uint64 范围的部分直接截断，查询场景用不到
+			raw = raw[size-8:]
+		}
+		v := uint64(0)
+		for _, b := range raw {
+			v = v<<8 | uint64(b)
+		}
+		return v, next, nil
+	case mmdbTypeInt32:
+		v := uint32(0)
+		for _, b := range raw {
+			v = v<<8 | uint32(b)
+		}
+		return int64(int32(v)), next, nil
+	default:
+		return nil, 0, fmt.Errorf("mmdb: unknown data type %d", typeNum)
+	}
+}
+
+// decodePointer 解码指针类型，返回指向的数据段偏移和指针之后的偏移
+func (d *mmdbDecoder) decodePointer(ctrl byte, offset uint) (uint, uint, error) {
+	ss := uint(ctrl>>3) & 0x3
+	n := ss + 1
+	if offset+n > uint(len(d.buf)) {
+		return 0, 0, errors.New("mmdb: unexpected end of data")
+	}
+	b := d.buf[offset : offset+n]
+	vvv := uint(ctrl & 0x7)
+
+	var ptr uint
+	switch ss {
+	case 0:
+		ptr = vvv<<8 | uint(b[0])
+	case 1:
+		ptr = (vvv<<16 | uint(b[0])<<8 | uint(b[1])) + 2048
+	case 2:
+		ptr = (vvv<<24 | uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])) + 526336
+	default:
+		ptr = uint(binary.BigEndian.Uint32(b))
+	}
+	return ptr, offset + n, nil
+}
+
+// mmdbUint 将解码结果转换为无符号整数
+func mmdbUint(v interface{}) uint64 {
+	switch n := v.(type) {
+	case uint64:
+		return n
+	case int64:
+		if n < 0 {
+			return 0
+		}
+		return uint64(n)
+	case float64:
+		return uint64(n)
+	}
+	return 0
+}
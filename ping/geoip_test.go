@@ -0,0 +1,233 @@
+package ping
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+// ========== 测试用 MMDB 构造器 ==========
+
+// encodeMMDBValue 按 MMDB 数据段格式编码测试数据（仅支持 map/string/uint32/uint16）
+func encodeMMDBValue(buf *bytes.Buffer, v interface{}) {
+	writeCtrl := func(typeNum int, size int) {
+		sizeBits, extra := size, -1
+		if size >= 29 {
+			sizeBits, extra = 29, size-29 // 测试数据不超过 284 字节
+		}
+		if typeNum <= 7 {
+			buf.WriteByte(byte(typeNum<<5 | sizeBits))
+		} else {
+			buf.WriteByte(byte(sizeBits))
+			buf.WriteByte(byte(typeNum - 7))
+		}
+		if extra >= 0 {
+			buf.WriteByte(byte(extra))
+		}
+	}
+	switch val := v.(type) {
+	case string:
+		writeCtrl(2, len(val))
+		buf.WriteString(val)
+	case uint16:
+		b := make([]byte, 2)
+		binary.BigEndian.PutUint16(b, val)
+		writeCtrl(5, 2)
+		buf.Write(b)
+	case uint32:
+		b := make([]byte, 4)
+		binary.BigEndian.PutUint32(b, val)
+		writeCtrl(6, 4)
+		buf.Write(b)
+	case map[string]interface{}:
+		keys := make([]string, 0, len(val))
+		for k := range val {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		writeCtrl(7, len(val))
+		for _, k := range keys {
+			encodeMMDBValue(buf, k)
+			encodeMMDBValue(buf, val[k])
+		}
+	}
+}
+
+// buildTestMMDB 构造一个 record_size=24 的 IPv6 树，IPv4 网段挂在 ::/96 之下
+func buildTestMMDB(t *testing.T, networks map[string]map[string]interface{}) []byte {
+	t.Helper()
+
+	type node struct{ rec [2]int } // -1 空，-2-n 表示数据 n
+	nodes := []node{{rec: [2]int{-1, -1}}}
+	var data bytes.Buffer
+	var offsets []int
+
+	for cidr, rec := range networks {
+		_, ipnet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			t.Fatalf("bad cidr %s: %v", cidr, err)
+		}
+		ones, _ := ipnet.Mask.Size()
+		ip16 := ipnet.IP.To16()
+		if v4 := ipnet.IP.To4(); v4 != nil {
+			// IPv4 位于 ::/96 之下（非 ::ffff:0:0/96 映射地址）
+			ip16 = append(make(net.IP, 12), v4...)
+			ones += 96
+		}
+
+		offsets = append(offsets, data.Len())
+		encodeMMDBValue(&data, rec)
+		dataIdx := len(offsets) - 1
+
+		cur := 0
+		for i := 0; i < ones; i++ {
+			bit := int(ip16[i/8]>>(7-uint(i%8))) & 1
+			if i == ones-1 {
+				nodes[cur].rec[bit] = -2 - dataIdx
+				break
+			}
+			next := nodes[cur].rec[bit]
+			if next < 0 {
+				nodes = append(nodes, node{rec: [2]int{-1, -1}})
+				next = len(nodes) - 1
+				nodes[cur].rec[bit] = next
+			}
+			cur = next
+		}
+	}
+
+	nodeCount := len(nodes)
+	var out bytes.Buffer
+	for _, n := range nodes {
+		for _, r := range n.rec {
+			v := r
+			switch {
+			case r == -1:
+				v = nodeCount
+			case r <= -2:
+				v = nodeCount + 16 + offsets[-2-r]
+			}
+			out.Write([]byte{byte(v >> 16), byte(v >> 8), byte(v)})
+		}
+	}
+	out.Write(make([]byte, 16))
+	out.Write(data.Bytes())
+	out.Write(mmdbMetadataMarker)
+	encodeMMDBValue(&out, map[string]interface{}{
+		"node_count":    uint32(nodeCount),
+		"record_size":   uint16(24),
+		"ip_version":    uint16(6),
+		"database_type": "Test-Geo",
+	})
+	return out.Bytes()
+}
+
+func writeTestGeoDB(t *testing.T) string {
+	t.Helper()
+	db := buildTestMMDB(t, map[string]map[string]interface{}{
+		"1.0.0.0/8": {
+			"autonomous_system_number":       uint32(13335),
+			"autonomous_system_organization": "Cloudflare",
+			"country":                        map[string]interface{}{"iso_code": "US"},
+		},
+		"36.0.0.0/8": {
+			"autonomous_system_number": uint32(4134),
+			"country":                  map[string]interface{}{"iso_code": "CN"},
+		},
+		"101.0.0.0/8": {
+			"autonomous_system_number": uint32(4837),
+			"country":                  map[string]interface{}{"iso_code": "CN"},
+		},
+		"2400:cb00::/32": {
+			"autonomous_system_number": uint32(13335),
+			"country":                  map[string]interface{}{"iso_code": "US"},
+		},
+	})
+	path := filepath.Join(t.TempDir(), "geo.mmdb")
+	if err := os.WriteFile(path, db, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// ========== 测试用例 ==========
+
+func TestGeoIPRankerLookup(t *testing.T) {
+	path := writeTestGeoDB(t)
+	r, err := NewGeoIPRanker(path, path, nil, nil, 0)
+	if err != nil {
+		t.Fatalf("NewGeoIPRanker failed: %v", err)
+	}
+
+	tests := []struct {
+		ip      string
+		asn     uint32
+		country string
+	}{
+		{"1.1.1.1", 13335, "US"},
+		{"36.152.44.95", 4134, "CN"},
+		{"101.6.6.6", 4837, "CN"},
+		{"2400:cb00::1", 13335, "US"},
+		{"8.8.8.8", 0, ""},
+		{"not-an-ip", 0, ""},
+	}
+	for _, tt := range tests {
+		info := r.Lookup(tt.ip)
+		if info.ASN != tt.asn || info.Country != tt.country {
+			t.Errorf("Lookup(%s) = %+v, want ASN=%d country=%s", tt.ip, info, tt.asn, tt.country)
+		}
+	}
+	if org := r.Lookup("1.2.3.4").ASOrg; org != "Cloudflare" {
+		t.Errorf("expected AS org Cloudflare, got %q", org)
+	}
+}
+
+func TestGeoIPRankerSortIPs(t *testing.T) {
+	path := writeTestGeoDB(t)
+	// 偏好：自家 ASN 4837 优先，其次国内 (CN)
+	r, err := NewGeoIPRanker(path, path, []uint32{4837}, []string{"cn"}, 0)
+	if err != nil {
+		t.Fatalf("NewGeoIPRanker failed: %v", err)
+	}
+
+	ips := []string{"8.8.8.8", "1.1.1.1", "36.1.1.1", "101.1.1.1", "36.2.2.2"}
+	got := r.SortIPs(ips)
+	want := []string{"101.1.1.1", "36.1.1.1", "36.2.2.2", "8.8.8.8", "1.1.1.1"}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("SortIPs = %v, want %v", got, want)
+		}
+	}
+	// 原切片不应被修改
+	if ips[0] != "8.8.8.8" {
+		t.Errorf("SortIPs modified input slice: %v", ips)
+	}
+}
+
+func TestGeoIPRankerRTTBucket(t *testing.T) {
+	var nilRanker *GeoIPRanker
+	if nilRanker.RTTBucket(37) != 37 || nilRanker.Rank("1.1.1.1") != 0 {
+		t.Error("nil ranker should be a no-op")
+	}
+
+	r := &GeoIPRanker{rttBucketMs: 10}
+	if r.RTTBucket(31) != r.RTTBucket(39) {
+		t.Error("31ms and 39ms should fall into the same bucket")
+	}
+	if r.RTTBucket(29) == r.RTTBucket(31) {
+		t.Error("29ms and 31ms should fall into different buckets")
+	}
+}
+
+func TestOpenMMDBInvalid(t *testing.T) {
+	if _, err := newMMDBReader([]byte("garbage")); err == nil {
+		t.Error("expected error for data without metadata marker")
+	}
+	if _, err := NewGeoIPRanker("", "", nil, nil, 0); err == nil {
+		t.Error("expected error when no database is configured")
+	}
+}
@@ -73,11 +73,12 @@ func DefaultIPMonitorConfig() IPMonitorConfig {
 
 // IPMonitorStats 监控器统计信息
 type IPMonitorStats struct {
-	TotalRefreshes    int64 // 扫描周期数（原来的）
-	TotalPlannedPings int64 // 计划测速总数（原来的 TotalIPsRefreshed）
-	TotalActualPings  int64 // 真正发出的 ICMP 包数量（新）
-	TotalSkippedPings int64 // 被探测冷却/策略拦截的数量（新）
-	LastRefreshTime   time.Time
+	TotalRefreshes     int64 // 扫描周期数（原来的）
+	TotalPlannedPings  int64 // 计划测速总数（原来的 TotalIPsRefreshed）
+	TotalActualPings   int64 // 真正发出的 ICMP 包数量（新）
+	TotalSkippedPings  int64 // 被探测冷却/策略拦截的数量（新）
+	TotalDeferredPings int64 // 因全局探测预算不足被推迟的数量
+	LastRefreshTime    time.Time
 
 	T0PoolSize int
 	T1PoolSize int
@@ -29,9 +29,18 @@ func (m *IPMonitor) refreshIPs(ips []string, poolName string) {
 		m.mu.Unlock()
 	}
 
-	ctx := context.Background()
+	// 后台巡检使用最低优先级，预算紧张时让位于用户排序
+	ctx := WithProbePriority(context.Background(), ProbePriorityBackground)
+
+	// 配置了外部探测器时改为批量探测
+	if m.pinger.GetProber() != nil {
+		m.refreshIPsExternal(ctx, ips, poolName)
+		return
+	}
+
 	successCount := 0
 	skippedCount := 0
+	deferredCount := 0
 	var mu sync.Mutex
 
 	// 使用 worker pool 模式进行并发测速
@@ -50,37 +59,25 @@ func (m *IPMonitor) refreshIPs(ips []string, poolName string) {
 			defer wg.Done()
 			for ip := range ipCh {
 				// === 探测冷却时间检查（Cooldown / TTL Padding） ===
-				if m.config.EnableCooldown {
-					remainingMs, isFresh := m.pinger.GetCacheTTLRemaining(ip)
-					if isFresh {
-						// 计算当前刷新周期的阈值（毫秒）
-						var intervalMs int64
-						switch poolName {
-						case "T0":
-							intervalMs = int64(m.config.T0RefreshInterval) * 1000
-						case "T1":
-							intervalMs = int64(m.config.T1RefreshInterval) * 1000
-						case "T2":
-							intervalMs = int64(m.config.T2RefreshInterval) * 1000
-						default:
-							intervalMs = 120000 // 默认 2 分钟
-						}
-
-						// 如果剩余 TTL 超过刷新周期的 CooldownRatio，跳过探测
-						thresholdMs := int64(float64(intervalMs) * m.config.CooldownRatio)
-						if remainingMs > thresholdMs {
-							mu.Lock()
-							skippedCount++
-							mu.Unlock()
-							continue
-						}
-					}
+				if m.inCooldown(ip, poolName) {
+					mu.Lock()
+					skippedCount++
+					mu.Unlock()
+					continue
 				}
 
 				// 纯 ICMP 探测：不需要 SNI 域名
 				// 执行测速（使用 smartPingWithMethod 获取探测方法）
 				rtt, method, _ := m.pinger.smartPingWithMethod(ctx, ip, "")
 
+				// 全局探测预算不足：本轮放弃该 IP，不写缓存、不消耗小时配额
+				if method == ProbeMethodDeferred {
+					mu.Lock()
+					deferredCount++
+					mu.Unlock()
+					continue
+				}
+
 				// 将探测结果写入全局 RTT 缓存
 				// 这样 PingAndSort 就可以直接使用 IPMonitor 维护的数据
 				if rtt >= 0 {
@@ -134,15 +131,92 @@ func (m *IPMonitor) refreshIPs(ips []string, poolName string) {
 	m.stats.TotalRefreshes++
 	// 真正的效率逻辑：
 	m.stats.TotalPlannedPings += int64(len(ips))
-	m.stats.TotalActualPings += int64(len(ips) - skippedCount) // 物理真实发包（含失败）
-	m.stats.TotalSkippedPings += int64(skippedCount)           // 策略拦截（省下的负担）
+	m.stats.TotalActualPings += int64(len(ips) - skippedCount - deferredCount) // 物理真实发包（含失败）
+	m.stats.TotalSkippedPings += int64(skippedCount)                           // 策略拦截（省下的负担）
+	m.stats.TotalDeferredPings += int64(deferredCount)                         // 全局预算不足而推迟
+	m.stats.HourlyQuotaUsed = int(m.hourlyPingCount)
+	m.stats.HourlyQuotaLimit = m.config.MaxPingsPerHour
+	m.stats.LastRefreshTime = time.Now()
+	m.mu.Unlock()
+
+	logger.Debugf("[IPMonitor] %s pool: Refreshed %d IPs, %d successful, %d skipped (cooldown), %d deferred (budget)",
+		poolName, len(ips), successCount, skippedCount, deferredCount)
+}
+
+// inCooldown 判断 IP 的缓存是否仍足够新鲜，可以跳过本轮探测
+func (m *IPMonitor) inCooldown(ip string, poolName string) bool {
+	if !m.config.EnableCooldown {
+		return false
+	}
+	remainingMs, isFresh := m.pinger.GetCacheTTLRemaining(ip)
+	if !isFresh {
+		return false
+	}
+
+	// 计算当前刷新周期的阈值（毫秒）
+	var intervalMs int64
+	switch poolName {
+	case "T0":
+		intervalMs = int64(m.config.T0RefreshInterval) * 1000
+	case "T1":
+		intervalMs = int64(m.config.T1RefreshInterval) * 1000
+	case "T2":
+		intervalMs = int64(m.config.T2RefreshInterval) * 1000
+	default:
+		intervalMs = 120000 // 默认 2 分钟
+	}
+
+	// 如果剩余 TTL 超过刷新周期的 CooldownRatio，跳过探测
+	thresholdMs := int64(float64(intervalMs) * m.config.CooldownRatio)
+	return remainingMs > thresholdMs
+}
+
+// refreshIPsExternal 使用外部探测器批量刷新 IP
+// 结果经 probeExternal 写入 RTT 缓存和 IPPool，外部未覆盖的 IP 本轮跳过，留待下个周期
+func (m *IPMonitor) refreshIPsExternal(ctx context.Context, ips []string, poolName string) {
+	toProbe := make([]string, 0, len(ips))
+	skippedCount := 0
+	for _, ip := range ips {
+		if m.inCooldown(ip, poolName) {
+			skippedCount++
+			continue
+		}
+		toProbe = append(toProbe, ip)
+	}
+
+	results, leftovers := m.pinger.probeExternal(ctx, toProbe, "")
+
+	successCount := 0
+	for _, r := range results {
+		if r.Loss < 100 {
+			successCount++
+			if m.config.EnableStabilityBackoff {
+				m.updateStabilityRecord(r.IP, r.RTT, poolName)
+			}
+		} else if m.pinger.IsNetworkOnline() {
+			m.resetStabilityRecord(r.IP)
+		}
+
+		if m.pinger.ipPool != nil {
+			m.pinger.ipPool.UpdateMonitorTime(r.IP)
+		}
+	}
+
+	m.mu.Lock()
+	if m.config.MaxPingsPerHour > 0 {
+		m.hourlyPingCount += int64(len(results))
+	}
+	m.stats.TotalRefreshes++
+	m.stats.TotalPlannedPings += int64(len(ips))
+	m.stats.TotalActualPings += int64(len(results))
+	m.stats.TotalSkippedPings += int64(skippedCount)
 	m.stats.HourlyQuotaUsed = int(m.hourlyPingCount)
 	m.stats.HourlyQuotaLimit = m.config.MaxPingsPerHour
 	m.stats.LastRefreshTime = time.Now()
 	m.mu.Unlock()
 
-	logger.Debugf("[IPMonitor] %s pool: Refreshed %d IPs, %d successful, %d skipped (cooldown)",
-		poolName, len(ips), successCount, skippedCount)
+	logger.Debugf("[IPMonitor] %s pool: Refreshed %d IPs via external prober, %d successful, %d skipped (cooldown), %d not covered",
+		poolName, len(ips), successCount, skippedCount, len(leftovers))
 }
 
 // updateStabilityRecord 更新 IP 稳定性记录
@@ -23,6 +23,10 @@ type IPInfo struct {
 
 	// 第四阶段新增：滑动窗口式巡检
 	LastMonitorTime time.Time // 最后监控时间（用于滑动窗口优先级计算）
+
+	// 带宽探测：仅对配置了吞吐量排序的域名测量
+	Bandwidth        float64   // 下载吞吐量（KB/s），0 表示测速失败
+	BandwidthUpdated time.Time // 吞吐量更新时间
 }
 
 // IPPool 全局 IP 资源管理器
@@ -189,6 +193,9 @@ func (p *IPPool) GetAllIPs() []*IPInfo {
 			RTTEWMA:         info.RTTEWMA,    // 补全：拷贝平滑 RTT
 			loss:            info.loss,       // 补全：拷贝丢包率
 			RTTUpdated:      info.RTTUpdated, // 补全：拷贝更新时间
+
+			Bandwidth:        info.Bandwidth,
+			BandwidthUpdated: info.BandwidthUpdated,
 		})
 	}
 	return result
@@ -406,6 +413,28 @@ func (p *IPPool) UpdateIPRTT(ip string, rtt int, loss float64, alpha float64) {
 	}
 }
 
+// MergeIPRTT 合并来自对等节点的 RTT 数据，按更新时间"后写者胜"
+// 仅更新池中已存在且本地数据更旧的 IP，EWMA 直接采用对端的值，返回是否更新
+func (p *IPPool) MergeIPRTT(ip string, rtt, rttEWMA int, loss float64, updated time.Time) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	info, exists := p.ips[ip]
+	if !exists || !info.RTTUpdated.Before(updated) {
+		return false
+	}
+	info.RTT = rtt
+	info.RTTEWMA = rttEWMA
+	info.loss = loss
+	info.RTTUpdated = updated
+	return true
+}
+
+// Loss 返回丢包率（0-100）
+func (info *IPInfo) Loss() float64 {
+	return info.loss
+}
+
 // GetIPRTT 获取 IP 的 RTT 数据
 // 返回值：
 // - rtt: 最新 RTT 值（毫秒）
@@ -447,6 +476,32 @@ func (p *IPPool) GetAllIPRTTs(ips []string) map[string]int {
 	return result
 }
 
+// UpdateIPBandwidth 更新 IP 的下载吞吐量（KB/s）
+func (p *IPPool) UpdateIPBandwidth(ip string, kbps float64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if info, exists := p.ips[ip]; exists {
+		info.Bandwidth = kbps
+		info.BandwidthUpdated = time.Now()
+	}
+}
+
+// GetIPBandwidths 批量获取 maxAge 内测得的吞吐量
+// 返回 map[ip]KB/s，只返回有效期内有数据的 IP
+func (p *IPPool) GetIPBandwidths(ips []string, maxAge time.Duration) map[string]float64 {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	result := make(map[string]float64)
+	for _, ip := range ips {
+		if info, exists := p.ips[ip]; exists && !info.BandwidthUpdated.IsZero() && time.Since(info.BandwidthUpdated) <= maxAge {
+			result[ip] = info.Bandwidth
+		}
+	}
+	return result
+}
+
 // IsIPDead 判断 IP 是否为"死"状态（RTT >= LogicDeadRTT）
 func (p *IPPool) IsIPDead(ip string) bool {
 	p.mu.RLock()
@@ -108,17 +108,31 @@ func (p *Pinger) PingAndSort(ctx context.Context, ips []string, domain string) [
 
 	// 并发测（兜底方案）
 	// 只有当缓存不可用时才会执行这里
+	// 外部探测器优先（可选）：结果已通过 UpdateIPCache 写入缓存和 IPPool
+	// 外部探测失败或未覆盖的 IP 继续走本地探测
+	var external []Result
+	if len(toPing) > 0 && p.GetProber() != nil {
+		external, toPing = p.probeExternal(ctx, toPing, domain)
+	}
+
 	results := p.concurrentPing(ctx, toPing, domain)
 
 	// 记录失效权重（避免两重记录）
 	// 修复 #8：使用统一的 recordProbeResult 方法
+	// 被预算推迟的探测没有真实结果，不记录权重也不写缓存
 	for _, r := range results {
+		if r.ProbeMethod == ProbeMethodDeferred {
+			continue
+		}
 		p.recordProbeResult(r.IP, r.Loss, r.FastFail)
 	}
 
 	// 更新缓存（缓存所有结果，包括失败）
 	if p.rttCacheTtlSeconds > 0 {
 		for _, r := range results {
+			if r.ProbeMethod == ProbeMethodDeferred {
+				continue
+			}
 			ttl := p.calculateDynamicTTL(r)
 			staleAt := time.Now().Add(ttl)
 
@@ -143,7 +157,7 @@ func (p *Pinger) PingAndSort(ctx context.Context, ips []string, domain string) [
 	}
 
 	// 合并 + 排序
-	all := append(cached, results...)
+	all := append(append(cached, external...), results...)
 	p.sortResults(all)
 	return all
 }
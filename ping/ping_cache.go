@@ -280,12 +280,12 @@ func (p *Pinger) triggerStaleRevalidate(ip, domain string) {
 			p.staleRevalidateMu.Unlock()
 		}()
 
-		// 执行探测
-		ctx, cancel := context.WithTimeout(context.Background(), time.Duration(p.timeoutMs)*time.Millisecond)
+		// 执行探测（软过期刷新优先级低于用户排序）
+		ctx, cancel := context.WithTimeout(WithProbePriority(context.Background(), ProbePriorityRevalidate), time.Duration(p.timeoutMs)*time.Millisecond)
 		defer cancel()
 
 		result := p.pingIP(ctx, ip, domain)
-		if result == nil {
+		if result == nil || result.ProbeMethod == ProbeMethodDeferred {
 			return
 		}
 
@@ -85,3 +85,13 @@ func (p *Pinger) IsNetworkOnline() bool {
 	}
 	return p.healthChecker.IsNetworkHealthy()
 }
+
+// SetProbeBudget 注入全局探测预算（PingAndSort、IPMonitor、TCP 回退共享）
+func (p *Pinger) SetProbeBudget(budget *ProbeBudget) {
+	p.budget = budget
+}
+
+// GetProbeBudget 获取全局探测预算
+func (p *Pinger) GetProbeBudget() *ProbeBudget {
+	return p.budget
+}
@@ -291,7 +291,15 @@ func (p *Pinger) tcpPing(ip string, ports []int) (int, int) {
 //   - rtt: 最终 RTT（毫秒），-1 表示不可达（外层 pingIP 依靠 rtt >= 0 判断成功）
 //   - method: 探测方法 (icmp, tcp:443, tcp:80 等)
 //   - icmpErr: ICMP 错误信息（用于判断是否为权限/协议错误）
-func (p *Pinger) smartPingWithMethod(_ context.Context, ip, _ string) (int, string, *ICMPError) {
+//   - method == ProbeMethodDeferred 表示预算不足、未发包，调用方不得当作失败处理
+func (p *Pinger) smartPingWithMethod(ctx context.Context, ip, _ string) (int, string, *ICMPError) {
+	prio := probePriorityFrom(ctx)
+
+	// 0. 申请全局探测预算（按优先级排队，用户探测可抢占后台巡检）
+	if !p.budget.Acquire(ctx, prio, icmpProbePackets, icmpProbeBytes) {
+		return -1, ProbeMethodDeferred, nil
+	}
+
 	// 1. 执行 1 次 ICMP 探测
 	rtt, icmpErr := p.icmpPingWithError(ip)
 
@@ -303,6 +311,15 @@ func (p *Pinger) smartPingWithMethod(_ context.Context, ip, _ string) (int, stri
 	}
 
 	if needTCPFallback && p.enableTCPFallback && len(p.tcpFallbackPorts) > 0 {
+		// 预算不足时跳过 TCP 补全：ICMP 已成功（只是偏慢）时返回 ICMP 结果，
+		// ICMP 失败时结果尚未确定，按未发包处理，避免 IP 被误记为失败
+		if !p.budget.Acquire(ctx, prio, tcpProbePackets*len(p.tcpFallbackPorts), int64(tcpProbeBytes*len(p.tcpFallbackPorts))) {
+			if rtt < 0 {
+				return -1, ProbeMethodDeferred, nil
+			}
+			return rtt, "icmp", icmpErr
+		}
+
 		// 执行 TCP 探测
 		tcpRTT, tcpPort := p.tcpPing(ip, p.tcpFallbackPorts)
 
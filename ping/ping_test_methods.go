@@ -23,6 +23,14 @@ func (p *Pinger) pingIP(ctx context.Context, ip, domain string) *Result {
 
 	for i := 0; i < p.count; i++ {
 		rtt, method, icmpErr := p.smartPingWithMethod(ctx, ip, domain)
+		if method == ProbeMethodDeferred {
+			// 预算不足：已有成功样本则用已有样本，否则整体标记为推迟（不是失败）
+			if successCount == 0 {
+				return &Result{IP: ip, RTT: LogicDeadRTT, Loss: 0, ProbeMethod: ProbeMethodDeferred}
+			}
+			avgRTT := int(totalRTT / int64(successCount))
+			return &Result{IP: ip, RTT: avgRTT, Loss: float64(i-successCount) / float64(i) * 100, ProbeMethod: probeMethod}
+		}
 		if rtt >= 0 {
 			totalRTT += int64(rtt)
 			successCount++
@@ -49,8 +49,14 @@ type Pinger struct {
 	failureWeightMgr *IPFailureWeightManager           // IP 失效权重管理器，用于排序惩罚
 	probeFlight      *singleflight.Group               // 请求合并，避免重复探测同一 IP
 	ipPool           *IPPool                           // 全局 IP 资源管理器，用于 IP 监控器获取 IP 列表
+	budget           *ProbeBudget                      // 全局探测预算（nil 表示不限制），与 IPMonitor 共享
 	healthChecker    connectivity.NetworkHealthChecker // 网络健康检查器，用于断网时防止缓存污染
 
+	// === 外部探测器（HTTP 批量端点 / exec 子进程） ===
+	proberMu        sync.RWMutex
+	prober          Prober // nil 表示仅使用本地探测
+	proberBatchSize int    // 单批次最大 IP 数，0 表示不拆分
+
 	// === Stale-While-Revalidate 相关 ===
 	staleRevalidateMu sync.Mutex      // 保护 staleRevalidating 的互斥锁
 	staleRevalidating map[string]bool // 记录正在异步更新的 IP，避免重复触发
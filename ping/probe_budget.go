@@ -0,0 +1,411 @@
+package ping
+
+import (
+	"container/heap"
+	"context"
+	"sync"
+	"time"
+)
+
+// ProbePriority 探测请求的优先级，数值越小越优先
+type ProbePriority int
+
+const (
+	// ProbePriorityUser 用户查询触发的排序探测（PingAndSort 默认优先级）
+	ProbePriorityUser ProbePriority = iota
+	// ProbePriorityRevalidate 软过期异步刷新
+	ProbePriorityRevalidate
+	// ProbePriorityBackground IPMonitor 后台巡检
+	ProbePriorityBackground
+
+	probePriorityCount
+)
+
+// String 返回优先级名称（用于 API 输出）
+func (p ProbePriority) String() string {
+	switch p {
+	case ProbePriorityUser:
+		return "user"
+	case ProbePriorityRevalidate:
+		return "revalidate"
+	case ProbePriorityBackground:
+		return "background"
+	default:
+		return "unknown"
+	}
+}
+
+// ProbeMethodDeferred 因预算不足被推迟（未真正发包）的探测结果标记
+// 调用方看到该标记时不得将其视为失败：不写 RTT 缓存、不记录失效权重
+const ProbeMethodDeferred = "deferred"
+
+// 单次探测的估算发包数与线路字节数（含 IP 头）
+const (
+	icmpProbePackets = 1   // Echo Request
+	icmpProbeBytes   = 64  // ICMP Echo 请求 + 应答
+	tcpProbePackets  = 2   // SYN + ACK/RST（每个端口）
+	tcpProbeBytes    = 180 // SYN / SYN-ACK / ACK(RST) 三个包（每个端口）
+)
+
+// backgroundReserveRatio 每小时字节预算中为用户探测保留的比例
+// 后台巡检用量达到 (1-ratio) 后即被推迟，保证用户排序始终有余量
+const backgroundReserveRatio = 0.1
+
+type probePriorityKey struct{}
+
+// WithProbePriority 在 context 中标记探测优先级
+func WithProbePriority(ctx context.Context, prio ProbePriority) context.Context {
+	return context.WithValue(ctx, probePriorityKey{}, prio)
+}
+
+// probePriorityFrom 从 context 读取优先级，未标记时视为用户探测
+func probePriorityFrom(ctx context.Context) ProbePriority {
+	if ctx != nil {
+		if prio, ok := ctx.Value(probePriorityKey{}).(ProbePriority); ok {
+			return prio
+		}
+	}
+	return ProbePriorityUser
+}
+
+// ProbeBudgetStats 探测预算统计（供 API 展示）
+type ProbeBudgetStats struct {
+	Enabled           bool             `json:"enabled"`
+	PacketsPerSecond  int              `json:"packets_per_second"`
+	BytesPerHour      int64            `json:"bytes_per_hour"`
+	BytesUsedHour     int64            `json:"bytes_used_hour"`
+	PacketsUsedHour   int64            `json:"packets_used_hour"`
+	PacketsGranted    int64            `json:"packets_granted"`
+	DeferredProbes    int64            `json:"deferred_probes"`
+	DeferredByPrio    map[string]int64 `json:"deferred_by_priority"`
+	GrantedByPrio     map[string]int64 `json:"granted_by_priority"`
+	QueueLength       int              `json:"queue_length"`
+	HourWindowStarted time.Time        `json:"hour_window_started"`
+}
+
+// budgetWaiter 排队等待令牌的探测请求
+type budgetWaiter struct {
+	prio    ProbePriority
+	seq     uint64
+	packets int
+	bytes   int64
+	ready   chan struct{}
+	granted bool
+	index   int
+}
+
+// waiterHeap 按 (优先级, 入队顺序) 排列的小顶堆
+type waiterHeap []*budgetWaiter
+
+func (h waiterHeap) Len() int { return len(h) }
+func (h waiterHeap) Less(i, j int) bool {
+	if h[i].prio != h[j].prio {
+		return h[i].prio < h[j].prio
+	}
+	return h[i].seq < h[j].seq
+}
+func (h waiterHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+func (h *waiterHeap) Push(x interface{}) {
+	w := x.(*budgetWaiter)
+	w.index = len(*h)
+	*h = append(*h, w)
+}
+func (h *waiterHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	w := old[n-1]
+	old[n-1] = nil
+	w.index = -1
+	*h = old[:n-1]
+	return w
+}
+
+// ProbeBudget 全局探测预算
+// PingAndSort、IPMonitor、TCP 回退共享同一个实例：
+//   - 每秒发包数：令牌桶限速，令牌不足时按优先级排队（用户 > 软过期刷新 > 后台巡检）
+//   - 每小时字节数：固定窗口配额，超出后直接推迟探测（后台巡检提前让出保留份额）
+//
+// 零值限制表示不限制；nil 预算等同于不限制
+type ProbeBudget struct {
+	mu sync.Mutex
+
+	packetsPerSec int
+	bytesPerHour  int64
+	maxWait       time.Duration
+
+	tokens     float64
+	lastRefill time.Time
+
+	hourStart       time.Time
+	bytesUsedHour   int64
+	packetsUsedHour int64
+
+	queue       waiterHeap
+	seq         uint64
+	dispatching bool
+
+	packetsGranted int64
+	grantedByPrio  [probePriorityCount]int64
+	deferredByPrio [probePriorityCount]int64
+}
+
+// NewProbeBudget 创建探测预算
+//   - packetsPerSec: 每秒最大发包数，0 表示不限
+//   - bytesPerHour: 每小时最大探测字节数，0 表示不限
+//   - maxWait: 单次排队最长等待时间，超时即推迟，0 表示只受 context 控制
+func NewProbeBudget(packetsPerSec int, bytesPerHour int64, maxWait time.Duration) *ProbeBudget {
+	now := time.Now()
+	b := &ProbeBudget{
+		lastRefill: now,
+		hourStart:  now,
+	}
+	b.SetLimits(packetsPerSec, bytesPerHour, maxWait)
+	b.tokens = float64(b.burst())
+	return b
+}
+
+// SetLimits 热更新预算上限（配置热加载时调用，保留已用量和排队状态）
+func (b *ProbeBudget) SetLimits(packetsPerSec int, bytesPerHour int64, maxWait time.Duration) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	if packetsPerSec < 0 {
+		packetsPerSec = 0
+	}
+	if bytesPerHour < 0 {
+		bytesPerHour = 0
+	}
+	b.packetsPerSec = packetsPerSec
+	b.bytesPerHour = bytesPerHour
+	b.maxWait = maxWait
+	if burst := float64(b.burst()); b.tokens > burst {
+		b.tokens = burst
+	}
+	b.mu.Unlock()
+	// 限制可能被放宽，唤醒排队者
+	b.kickDispatcher()
+}
+
+// burst 令牌桶容量：1 秒的发包量（调用方需持有锁）
+func (b *ProbeBudget) burst() int {
+	if b.packetsPerSec <= 0 {
+		return 0
+	}
+	return b.packetsPerSec
+}
+
+// refillLocked 补充令牌并滚动小时窗口（调用方需持有锁）
+func (b *ProbeBudget) refillLocked(now time.Time) {
+	if b.packetsPerSec > 0 {
+		elapsed := now.Sub(b.lastRefill).Seconds()
+		if elapsed > 0 {
+			b.tokens += elapsed * float64(b.packetsPerSec)
+			if burst := float64(b.burst()); b.tokens > burst {
+				b.tokens = burst
+			}
+		}
+	}
+	b.lastRefill = now
+
+	if now.Sub(b.hourStart) >= time.Hour {
+		b.hourStart = now
+		b.bytesUsedHour = 0
+		b.packetsUsedHour = 0
+	}
+}
+
+// hourlyAllowedLocked 判断小时字节配额是否允许本次探测（调用方需持有锁）
+func (b *ProbeBudget) hourlyAllowedLocked(prio ProbePriority, bytes int64) bool {
+	if b.bytesPerHour <= 0 {
+		return true
+	}
+	limit := b.bytesPerHour
+	if prio == ProbePriorityBackground {
+		limit = int64(float64(b.bytesPerHour) * (1 - backgroundReserveRatio))
+	}
+	return b.bytesUsedHour+bytes <= limit
+}
+
+// grantLocked 扣减预算（调用方需持有锁）
+func (b *ProbeBudget) grantLocked(prio ProbePriority, packets int, bytes int64) {
+	if b.packetsPerSec > 0 {
+		b.tokens -= float64(packets)
+	}
+	b.bytesUsedHour += bytes
+	b.packetsUsedHour += int64(packets)
+	b.packetsGranted += int64(packets)
+	b.grantedByPrio[prio]++
+}
+
+// Acquire 申请发送 packets 个包、共 bytes 字节的探测预算
+// 返回 false 表示本次探测被推迟（配额耗尽、排队超时或 context 取消），调用方不应发包
+func (b *ProbeBudget) Acquire(ctx context.Context, prio ProbePriority, packets int, bytes int64) bool {
+	if b == nil {
+		return true
+	}
+	if prio < 0 || prio >= probePriorityCount {
+		prio = ProbePriorityBackground
+	}
+
+	b.mu.Lock()
+	b.refillLocked(time.Now())
+
+	// 单次申请不能超过令牌桶容量，否则永远无法放行
+	if b.packetsPerSec > 0 && packets > b.packetsPerSec {
+		packets = b.packetsPerSec
+	}
+
+	if !b.hourlyAllowedLocked(prio, bytes) {
+		b.deferredByPrio[prio]++
+		b.mu.Unlock()
+		return false
+	}
+
+	// 令牌充足且没有更高（或同级更早）的排队者时直接放行
+	if b.packetsPerSec <= 0 || (b.tokens >= float64(packets) && !b.hasWaiterAheadLocked(prio)) {
+		b.grantLocked(prio, packets, bytes)
+		b.mu.Unlock()
+		return true
+	}
+
+	b.seq++
+	w := &budgetWaiter{
+		prio:    prio,
+		seq:     b.seq,
+		packets: packets,
+		bytes:   bytes,
+		ready:   make(chan struct{}),
+	}
+	heap.Push(&b.queue, w)
+	maxWait := b.maxWait
+	b.mu.Unlock()
+
+	b.kickDispatcher()
+
+	var timeout <-chan time.Time
+	if maxWait > 0 {
+		timer := time.NewTimer(maxWait)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	var done <-chan struct{}
+	if ctx != nil {
+		done = ctx.Done()
+	}
+
+	select {
+	case <-w.ready:
+	case <-done:
+	case <-timeout:
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if w.granted {
+		// 已被调度器放行（包括与超时竞争的情况）
+		return true
+	}
+	if w.index >= 0 {
+		// 仍在队列中：主动退出并计入推迟
+		heap.Remove(&b.queue, w.index)
+		b.deferredByPrio[prio]++
+	}
+	// index < 0 且未放行：已被调度器因配额耗尽推迟并计数
+	return false
+}
+
+// hasWaiterAheadLocked 是否存在优先级不低于 prio 的排队者（调用方需持有锁）
+func (b *ProbeBudget) hasWaiterAheadLocked(prio ProbePriority) bool {
+	return len(b.queue) > 0 && b.queue[0].prio <= prio
+}
+
+// kickDispatcher 确保调度协程在运行
+func (b *ProbeBudget) kickDispatcher() {
+	b.mu.Lock()
+	if b.dispatching || len(b.queue) == 0 {
+		b.mu.Unlock()
+		return
+	}
+	b.dispatching = true
+	b.mu.Unlock()
+	go b.dispatch()
+}
+
+// dispatch 按优先级放行排队者，令牌不足时休眠到下一个令牌可用
+func (b *ProbeBudget) dispatch() {
+	for {
+		b.mu.Lock()
+		b.refillLocked(time.Now())
+
+		for len(b.queue) > 0 {
+			w := b.queue[0]
+			if !b.hourlyAllowedLocked(w.prio, w.bytes) {
+				// 小时配额已耗尽：推迟，不再占位
+				heap.Pop(&b.queue)
+				b.deferredByPrio[w.prio]++
+				close(w.ready)
+				continue
+			}
+			if b.packetsPerSec > 0 && b.tokens < float64(w.packets) {
+				break
+			}
+			heap.Pop(&b.queue)
+			w.granted = true
+			b.grantLocked(w.prio, w.packets, w.bytes)
+			close(w.ready)
+		}
+
+		if len(b.queue) == 0 {
+			b.dispatching = false
+			b.mu.Unlock()
+			return
+		}
+
+		need := float64(b.queue[0].packets) - b.tokens
+		rate := b.packetsPerSec
+		b.mu.Unlock()
+
+		wait := 10 * time.Millisecond
+		if rate > 0 && need > 0 {
+			wait = time.Duration(need / float64(rate) * float64(time.Second))
+			if wait < time.Millisecond {
+				wait = time.Millisecond
+			}
+		}
+		time.Sleep(wait)
+	}
+}
+
+// GetStats 返回预算使用情况
+func (b *ProbeBudget) GetStats() ProbeBudgetStats {
+	if b == nil {
+		return ProbeBudgetStats{}
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refillLocked(time.Now())
+
+	stats := ProbeBudgetStats{
+		Enabled:           b.packetsPerSec > 0 || b.bytesPerHour > 0,
+		PacketsPerSecond:  b.packetsPerSec,
+		BytesPerHour:      b.bytesPerHour,
+		BytesUsedHour:     b.bytesUsedHour,
+		PacketsUsedHour:   b.packetsUsedHour,
+		PacketsGranted:    b.packetsGranted,
+		DeferredByPrio:    make(map[string]int64, probePriorityCount),
+		GrantedByPrio:     make(map[string]int64, probePriorityCount),
+		QueueLength:       len(b.queue),
+		HourWindowStarted: b.hourStart,
+	}
+	for prio := ProbePriority(0); prio < probePriorityCount; prio++ {
+		stats.DeferredByPrio[prio.String()] = b.deferredByPrio[prio]
+		stats.GrantedByPrio[prio.String()] = b.grantedByPrio[prio]
+		stats.DeferredProbes += b.deferredByPrio[prio]
+	}
+	return stats
+}
@@ -0,0 +1,158 @@
+package ping
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestProbeBudgetNilAndUnlimited(t *testing.T) {
+	var nilBudget *ProbeBudget
+	if !nilBudget.Acquire(context.Background(), ProbePriorityBackground, 1, 64) {
+		t.Error("nil budget should always grant")
+	}
+
+	b := NewProbeBudget(0, 0, 0)
+	for i := 0; i < 1000; i++ {
+		if !b.Acquire(context.Background(), ProbePriorityUser, 1, 64) {
+			t.Fatal("unlimited budget should always grant")
+		}
+	}
+	stats := b.GetStats()
+	if stats.Enabled {
+		t.Error("unlimited budget should report enabled=false")
+	}
+	if stats.PacketsGranted != 1000 || stats.BytesUsedHour != 64000 {
+		t.Errorf("unexpected accounting: %+v", stats)
+	}
+}
+
+func TestProbeBudgetHourlyBytesWithUserReserve(t *testing.T) {
+	// 1000 字节/小时：后台巡检最多用到 900，剩余留给用户排序
+	b := NewProbeBudget(0, 1000, 0)
+	ctx := context.Background()
+
+	granted := 0
+	for i := 0; i < 20; i++ {
+		if b.Acquire(ctx, ProbePriorityBackground, 1, 100) {
+			granted++
+		}
+	}
+	if granted != 9 {
+		t.Errorf("expected 9 background probes within reserve, got %d", granted)
+	}
+
+	if !b.Acquire(ctx, ProbePriorityUser, 1, 100) {
+		t.Error("user probe should use the reserved share")
+	}
+	if b.Acquire(ctx, ProbePriorityUser, 1, 100) {
+		t.Error("user probe should be deferred once the hourly budget is exhausted")
+	}
+
+	stats := b.GetStats()
+	if stats.DeferredByPrio["background"] != 11 || stats.DeferredByPrio["user"] != 1 {
+		t.Errorf("unexpected deferred counters: %v", stats.DeferredByPrio)
+	}
+	if stats.DeferredProbes != 12 {
+		t.Errorf("expected 12 deferred probes, got %d", stats.DeferredProbes)
+	}
+}
+
+func TestProbeBudgetUserPreemptsBackground(t *testing.T) {
+	// 每秒 20 个包：桶耗尽后每 50ms 放行一个
+	b := NewProbeBudget(20, 0, 5*time.Second)
+	ctx := context.Background()
+	for i := 0; i < 20; i++ {
+		b.Acquire(ctx, ProbePriorityBackground, 1, 0)
+	}
+
+	var mu sync.Mutex
+	var order []ProbePriority
+	var wg sync.WaitGroup
+
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if b.Acquire(ctx, ProbePriorityBackground, 1, 0) {
+				mu.Lock()
+				order = append(order, ProbePriorityBackground)
+				mu.Unlock()
+			}
+		}()
+	}
+	// 等后台请求全部入队后再提交用户请求
+	deadline := time.Now().Add(time.Second)
+	for b.GetStats().QueueLength < 3 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if b.Acquire(ctx, ProbePriorityUser, 1, 0) {
+			mu.Lock()
+			order = append(order, ProbePriorityUser)
+			mu.Unlock()
+		}
+	}()
+	wg.Wait()
+
+	if len(order) != 4 {
+		t.Fatalf("expected 4 grants, got %v", order)
+	}
+	// 用户请求最晚提交，但至多排在一个已被放行的后台请求之后
+	userPos := -1
+	for i, p := range order {
+		if p == ProbePriorityUser {
+			userPos = i
+		}
+	}
+	if userPos > 1 {
+		t.Errorf("user probe should preempt queued background probes, got order %v", order)
+	}
+}
+
+func TestProbeBudgetMaxWaitDefers(t *testing.T) {
+	b := NewProbeBudget(1, 0, 20*time.Millisecond)
+	ctx := context.Background()
+	if !b.Acquire(ctx, ProbePriorityBackground, 1, 0) {
+		t.Fatal("first probe should consume the initial token")
+	}
+	if b.Acquire(ctx, ProbePriorityBackground, 1, 0) {
+		t.Error("second probe should be deferred after max wait")
+	}
+	stats := b.GetStats()
+	if stats.QueueLength != 0 {
+		t.Errorf("deferred waiter should leave the queue, got length %d", stats.QueueLength)
+	}
+	if stats.DeferredByPrio["background"] != 1 {
+		t.Errorf("expected 1 deferred background probe, got %v", stats.DeferredByPrio)
+	}
+}
+
+func TestProbePriorityFromContext(t *testing.T) {
+	if probePriorityFrom(context.Background()) != ProbePriorityUser {
+		t.Error("unmarked context should default to user priority")
+	}
+	ctx := WithProbePriority(context.Background(), ProbePriorityBackground)
+	if probePriorityFrom(ctx) != ProbePriorityBackground {
+		t.Error("priority should round-trip through context")
+	}
+}
+
+func TestSmartPingDeferredWhenFallbackDenied(t *testing.T) {
+	p := NewPinger(1, 200, 1, 0, 60, true, "")
+	defer p.Stop()
+	// 预算只够一次 ICMP，TCP 补全被拒绝
+	p.SetProbeBudget(NewProbeBudget(0, icmpProbeBytes, 0))
+
+	// ICMP 调度器未就绪时 ICMP 探测必然失败
+	p.icmpReady = make(chan struct{})
+	ctx := WithProbePriority(context.Background(), ProbePriorityUser)
+	rtt, method, icmpErr := p.smartPingWithMethod(ctx, "192.0.2.1", "")
+	if rtt != -1 || method != ProbeMethodDeferred || icmpErr != nil {
+		t.Errorf("denied TCP fallback after a failed ICMP probe should be deferred, got %d %q %v", rtt, method, icmpErr)
+	}
+}
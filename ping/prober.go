@@ -0,0 +1,183 @@
+package ping
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"smartdnssort/logger"
+)
+
+// Prober 外部探测器接口
+// 用于把测速工作交给外部系统（例如部署在各出口的探测代理、自定义脚本），
+// 探测结果统一经由 Pinger.UpdateIPCache 写入 RTT 缓存和 IPPool
+type Prober interface {
+	// Probe 批量探测一组 IP，返回的结果数量可以少于输入（缺失的 IP 视为未探测）
+	Probe(ctx context.Context, req ProbeRequest) ([]Result, error)
+
+	// Name 返回探测器名称（用于日志和 ProbeMethod 标记）
+	Name() string
+}
+
+// ProbeRequest 外部探测请求（JSON 协议，HTTP 请求体与 exec 标准输入共用）
+type ProbeRequest struct {
+	IPs       []string `json:"ips"`
+	Domain    string   `json:"domain,omitempty"`
+	Count     int      `json:"count"`
+	TimeoutMs int      `json:"timeout_ms"`
+}
+
+// probeResponse 外部探测响应
+//
+//	{"results": [{"ip": "1.1.1.1", "rtt": 12, "loss": 0, "method": "icmp"}]}
+//
+// rtt < 0 或 loss >= 100 表示不可达
+type probeResponse struct {
+	Results []probeResponseItem `json:"results"`
+	Error   string              `json:"error,omitempty"`
+}
+
+type probeResponseItem struct {
+	IP     string  `json:"ip"`
+	RTT    int     `json:"rtt"`
+	Loss   float64 `json:"loss"`
+	Method string  `json:"method,omitempty"`
+}
+
+// maxProbeResponseBytes 外部探测响应体上限，防止异常输出撑爆内存
+const maxProbeResponseBytes = 4 * 1024 * 1024
+
+// ProberOptions 外部探测器配置
+type ProberOptions struct {
+	Type      string        // http 或 exec
+	URL       string        // http: 批量探测端点
+	AuthToken string        // http: 可选的 Bearer Token
+	Command   string        // exec: 可执行文件路径
+	Args      []string      // exec: 命令参数
+	Timeout   time.Duration // 单批次超时
+}
+
+// NewProber 根据配置创建外部探测器
+func NewProber(opts ProberOptions) (Prober, error) {
+	if opts.Timeout <= 0 {
+		opts.Timeout = 5 * time.Second
+	}
+	switch strings.ToLower(opts.Type) {
+	case "http", "https":
+		return NewHTTPProber(opts.URL, opts.AuthToken, opts.Timeout)
+	case "exec":
+		return NewExecProber(opts.Command, opts.Args, opts.Timeout)
+	default:
+		return nil, fmt.Errorf("unknown prober type: %s", opts.Type)
+	}
+}
+
+// decodeProbeResponse 解析外部探测响应，只保留请求中的 IP 并规范化不可达结果
+func decodeProbeResponse(r io.Reader, req ProbeRequest, method string) ([]Result, error) {
+	var resp probeResponse
+	if err := json.NewDecoder(io.LimitReader(r, maxProbeResponseBytes)).Decode(&resp); err != nil {
+		return nil, fmt.Errorf("decode probe response: %w", err)
+	}
+	if resp.Error != "" {
+		return nil, fmt.Errorf("prober error: %s", resp.Error)
+	}
+
+	wanted := make(map[string]struct{}, len(req.IPs))
+	for _, ip := range req.IPs {
+		wanted[ip] = struct{}{}
+	}
+
+	results := make([]Result, 0, len(resp.Results))
+	for _, item := range resp.Results {
+		if _, ok := wanted[item.IP]; !ok {
+			continue
+		}
+		delete(wanted, item.IP) // 同一 IP 只取第一条
+
+		res := Result{IP: item.IP, RTT: item.RTT, Loss: item.Loss, ProbeMethod: method}
+		if item.Method != "" {
+			res.ProbeMethod = method + ":" + item.Method
+		}
+		if res.Loss < 0 {
+			res.Loss = 0
+		}
+		if res.RTT < 0 || res.Loss >= 100 || res.RTT >= LogicDeadRTT {
+			res.RTT = LogicDeadRTT
+			res.Loss = 100
+		}
+		results = append(results, res)
+	}
+	return results, nil
+}
+
+// SetProber 设置外部探测器（nil 表示仅使用本地 ICMP/TCP 探测）
+func (p *Pinger) SetProber(prober Prober, batchSize int) {
+	p.proberMu.Lock()
+	defer p.proberMu.Unlock()
+	p.prober = prober
+	p.proberBatchSize = batchSize
+}
+
+// GetProber 获取外部探测器
+func (p *Pinger) GetProber() Prober {
+	p.proberMu.RLock()
+	defer p.proberMu.RUnlock()
+	return p.prober
+}
+
+// probeExternal 使用外部探测器批量探测
+// 返回探测结果（已写入 RTT 缓存和 IPPool）以及外部探测器未覆盖、需要本地兜底探测的 IP
+// 外部探测器自行控制发包，不占用本地探测预算
+func (p *Pinger) probeExternal(ctx context.Context, ips []string, domain string) ([]Result, []string) {
+	p.proberMu.RLock()
+	prober, batchSize := p.prober, p.proberBatchSize
+	p.proberMu.RUnlock()
+
+	if prober == nil || len(ips) == 0 {
+		return nil, ips
+	}
+	if batchSize <= 0 || batchSize > len(ips) {
+		batchSize = len(ips)
+	}
+
+	results := make([]Result, 0, len(ips))
+	var leftovers []string
+
+	for start := 0; start < len(ips); start += batchSize {
+		end := start + batchSize
+		if end > len(ips) {
+			end = len(ips)
+		}
+		batch := ips[start:end]
+
+		batchResults, err := prober.Probe(ctx, ProbeRequest{
+			IPs:       batch,
+			Domain:    domain,
+			Count:     p.count,
+			TimeoutMs: p.timeoutMs,
+		})
+		if err != nil {
+			logger.Warnf("[Pinger] External prober %s failed for %d IPs, falling back to local probing: %v", prober.Name(), len(batch), err)
+			leftovers = append(leftovers, batch...)
+			continue
+		}
+
+		covered := make(map[string]struct{}, len(batchResults))
+		for _, r := range batchResults {
+			covered[r.IP] = struct{}{}
+			// UpdateIPCache 会同步写入 IPPool.UpdateIPRTT 并更新失效权重
+			p.UpdateIPCache(r.IP, r.RTT, r.Loss, r.ProbeMethod)
+			results = append(results, r)
+		}
+		for _, ip := range batch {
+			if _, ok := covered[ip]; !ok {
+				leftovers = append(leftovers, ip)
+			}
+		}
+	}
+
+	return results, leftovers
+}
@@ -0,0 +1,104 @@
+package ping
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"time"
+)
+
+// ExecProber 通过外部程序探测
+// 将 ProbeRequest 以 JSON 写入子进程标准输入，从标准输出读取 probeResponse
+type ExecProber struct {
+	command string
+	args    []string
+	timeout time.Duration
+}
+
+// NewExecProber 创建 exec 探测器
+func NewExecProber(command string, args []string, timeout time.Duration) (*ExecProber, error) {
+	if command == "" {
+		return nil, fmt.Errorf("prober command is empty")
+	}
+	path, err := exec.LookPath(command)
+	if err != nil {
+		return nil, fmt.Errorf("prober command not found: %w", err)
+	}
+	return &ExecProber{
+		command: path,
+		args:    append([]string(nil), args...),
+		timeout: timeout,
+	}, nil
+}
+
+// Name 实现 Prober 接口
+func (e *ExecProber) Name() string {
+	return "exec"
+}
+
+// Probe 实现 Prober 接口
+func (e *ExecProber) Probe(ctx context.Context, req ProbeRequest) ([]Result, error) {
+	input, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+
+	var cancel context.CancelFunc
+	if e.timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, e.timeout)
+	} else {
+		ctx, cancel = context.WithCancel(ctx)
+	}
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, e.command, e.args...)
+	cmd.Stdin = bytes.NewReader(input)
+	// 输出超限时立即终止子进程，而不是等它退出后再检查长度
+	stdout := &limitedBuffer{limit: maxProbeResponseBytes, onExceed: cancel}
+	stderr := &limitedBuffer{limit: maxProbeResponseBytes, onExceed: cancel}
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+
+	err = cmd.Run()
+	if stdout.exceeded || stderr.exceeded {
+		return nil, fmt.Errorf("prober output exceeds %d bytes", maxProbeResponseBytes)
+	}
+	if err != nil {
+		msg := stderr.buf.String()
+		if len(msg) > 256 {
+			msg = msg[:256]
+		}
+		return nil, fmt.Errorf("prober command failed: %w (stderr: %s)", err, bytes.TrimSpace([]byte(msg)))
+	}
+
+	return decodeProbeResponse(&stdout.buf, req, e.Name())
+}
+
+// errProbeOutputTooLarge 外部探测程序输出超过上限
+var errProbeOutputTooLarge = errors.New("prober output too large")
+
+// limitedBuffer 有上限的输出缓冲区，超限后写入失败并调用 onExceed
+// 不内嵌 bytes.Buffer：否则 io.Copy 会走其 ReadFrom 绕过上限检查
+type limitedBuffer struct {
+	buf      bytes.Buffer
+	limit    int
+	exceeded bool
+	onExceed func()
+}
+
+// Write 实现 io.Writer 接口
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if b.exceeded || b.buf.Len()+len(p) > b.limit {
+		if !b.exceeded {
+			b.exceeded = true
+			if b.onExceed != nil {
+				b.onExceed()
+			}
+		}
+		return 0, errProbeOutputTooLarge
+	}
+	return b.buf.Write(p)
+}
@@ -0,0 +1,68 @@
+package ping
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// HTTPProber 通过 HTTP 批量端点探测
+// POST JSON 格式的 ProbeRequest，期望返回 probeResponse
+type HTTPProber struct {
+	endpoint  string
+	authToken string
+	client    *http.Client
+}
+
+// NewHTTPProber 创建 HTTP 批量探测器
+func NewHTTPProber(endpoint, authToken string, timeout time.Duration) (*HTTPProber, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid prober endpoint: %q", endpoint)
+	}
+	return &HTTPProber{
+		endpoint:  endpoint,
+		authToken: authToken,
+		client:    &http.Client{Timeout: timeout},
+	}, nil
+}
+
+// Name 实现 Prober 接口
+func (h *HTTPProber) Name() string {
+	return "http"
+}
+
+// Probe 实现 Prober 接口
+func (h *HTTPProber) Probe(ctx context.Context, req ProbeRequest) ([]Result, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, h.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	if h.authToken != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+h.authToken)
+	}
+
+	resp, err := h.client.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		snippet, _ := io.ReadAll(io.LimitReader(resp.Body, 256))
+		return nil, fmt.Errorf("prober returned status %s: %s", resp.Status, bytes.TrimSpace(snippet))
+	}
+
+	return decodeProbeResponse(resp.Body, req, h.Name())
+}
@@ -0,0 +1,179 @@
+package ping
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDecodeProbeResponseFiltersAndNormalizes(t *testing.T) {
+	req := ProbeRequest{IPs: []string{"1.1.1.1", "2.2.2.2", "3.3.3.3"}}
+	body := `{"results": [
+		{"ip": "1.1.1.1", "rtt": 12, "loss": 0, "method": "icmp"},
+		{"ip": "1.1.1.1", "rtt": 99, "loss": 0},
+		{"ip": "2.2.2.2", "rtt": -1, "loss": 0},
+		{"ip": "9.9.9.9", "rtt": 5, "loss": 0}
+	]}`
+
+	results, err := decodeProbeResponse(strings.NewReader(body), req, "http")
+	if err != nil {
+		t.Fatalf("decode failed: %v", err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("expected 2 results (duplicates and unrequested IPs dropped), got %+v", results)
+	}
+	if results[0].IP != "1.1.1.1" || results[0].RTT != 12 || results[0].ProbeMethod != "http:icmp" {
+		t.Errorf("unexpected first result: %+v", results[0])
+	}
+	if results[1].RTT != LogicDeadRTT || results[1].Loss != 100 || results[1].ProbeMethod != "http" {
+		t.Errorf("negative rtt should be normalized to unreachable: %+v", results[1])
+	}
+
+	if _, err := decodeProbeResponse(strings.NewReader(`{"error": "boom"}`), req, "http"); err == nil {
+		t.Error("error field should be surfaced")
+	}
+}
+
+func TestHTTPProber(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		var req ProbeRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		resp := probeResponse{}
+		for i, ip := range req.IPs {
+			resp.Results = append(resp.Results, probeResponseItem{IP: ip, RTT: 10 * (i + 1)})
+		}
+		json.NewEncoder(w).Encode(resp)
+	}))
+	defer srv.Close()
+
+	prober, err := NewHTTPProber(srv.URL, "secret", time.Second)
+	if err != nil {
+		t.Fatalf("NewHTTPProber failed: %v", err)
+	}
+	results, err := prober.Probe(context.Background(), ProbeRequest{IPs: []string{"1.1.1.1", "2.2.2.2"}, Count: 1})
+	if err != nil {
+		t.Fatalf("probe failed: %v", err)
+	}
+	if len(results) != 2 || results[1].RTT != 20 {
+		t.Errorf("unexpected results: %+v", results)
+	}
+
+	bad, _ := NewHTTPProber(srv.URL, "wrong", time.Second)
+	if _, err := bad.Probe(context.Background(), ProbeRequest{IPs: []string{"1.1.1.1"}}); err == nil {
+		t.Error("non-200 status should return an error")
+	}
+
+	if _, err := NewHTTPProber("ftp://example.com", "", time.Second); err == nil {
+		t.Error("non-http endpoint should be rejected")
+	}
+}
+
+func TestExecProber(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh not available")
+	}
+	script := `cat >/dev/null; echo '{"results": [{"ip": "1.1.1.1", "rtt": 7, "loss": 0, "method": "tcp"}]}'`
+	prober, err := NewExecProber("sh", []string{"-c", script}, time.Second)
+	if err != nil {
+		t.Fatalf("NewExecProber failed: %v", err)
+	}
+	results, err := prober.Probe(context.Background(), ProbeRequest{IPs: []string{"1.1.1.1"}})
+	if err != nil {
+		t.Fatalf("probe failed: %v", err)
+	}
+	if len(results) != 1 || results[0].RTT != 7 || results[0].ProbeMethod != "exec:tcp" {
+		t.Errorf("unexpected results: %+v", results)
+	}
+
+	failing, _ := NewExecProber("sh", []string{"-c", "echo oops >&2; exit 3"}, time.Second)
+	if _, err := failing.Probe(context.Background(), ProbeRequest{IPs: []string{"1.1.1.1"}}); err == nil || !strings.Contains(err.Error(), "oops") {
+		t.Errorf("expected error with stderr snippet, got %v", err)
+	}
+
+	// 输出超限时应立即终止子进程，而不是等到超时
+	chatty, _ := NewExecProber("sh", []string{"-c", "cat >/dev/null; yes"}, 30*time.Second)
+	start := time.Now()
+	if _, err := chatty.Probe(context.Background(), ProbeRequest{IPs: []string{"1.1.1.1"}}); err == nil || !strings.Contains(err.Error(), "exceeds") {
+		t.Errorf("expected output limit error, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("oversized output should stop the prober early, took %v", elapsed)
+	}
+}
+
+// fakeProber 按预设结果应答，并记录每批请求
+type fakeProber struct {
+	rtts    map[string]int
+	batches [][]string
+	err     error
+}
+
+func (f *fakeProber) Name() string { return "fake" }
+
+func (f *fakeProber) Probe(ctx context.Context, req ProbeRequest) ([]Result, error) {
+	f.batches = append(f.batches, append([]string(nil), req.IPs...))
+	if f.err != nil {
+		return nil, f.err
+	}
+	var results []Result
+	for _, ip := range req.IPs {
+		if rtt, ok := f.rtts[ip]; ok {
+			results = append(results, Result{IP: ip, RTT: rtt, ProbeMethod: "fake"})
+		}
+	}
+	return results, nil
+}
+
+func TestProbeExternalFeedsCacheAndPool(t *testing.T) {
+	p := NewPinger(1, 100, 4, 0, 60, false, "")
+	defer p.Stop()
+
+	ips := []string{"10.0.0.1", "10.0.0.2", "10.0.0.3", "10.0.0.4"}
+	p.ipPool.UpdateDomainIPs(nil, ips, "example.com")
+
+	fp := &fakeProber{rtts: map[string]int{"10.0.0.1": 30, "10.0.0.2": 10, "10.0.0.3": 20}}
+	p.SetProber(fp, 2)
+
+	results, leftovers := p.probeExternal(context.Background(), ips, "example.com")
+	if len(results) != 3 {
+		t.Fatalf("expected 3 external results, got %+v", results)
+	}
+	if len(leftovers) != 1 || leftovers[0] != "10.0.0.4" {
+		t.Errorf("uncovered IP should be left for local probing, got %v", leftovers)
+	}
+	if len(fp.batches) != 2 || len(fp.batches[0]) != 2 {
+		t.Errorf("expected 2 batches of at most 2 IPs, got %v", fp.batches)
+	}
+
+	if e, ok := p.rttCache.get("10.0.0.2"); !ok || e.rtt != 10 {
+		t.Errorf("external result should be written to rtt cache, got %+v ok=%v", e, ok)
+	}
+	if rtt, _, updated := p.ipPool.GetIPRTT("10.0.0.2"); !updated || rtt != 10 {
+		t.Errorf("external result should be fed into IPPool, got rtt=%d updated=%v", rtt, updated)
+	}
+}
+
+func TestProbeExternalFallsBackOnError(t *testing.T) {
+	p := NewPinger(1, 100, 4, 0, 60, false, "")
+	defer p.Stop()
+
+	p.SetProber(&fakeProber{err: errors.New("unavailable")}, 0)
+	ips := []string{"10.0.0.1", "10.0.0.2"}
+	results, leftovers := p.probeExternal(context.Background(), ips, "")
+	if len(results) != 0 || len(leftovers) != len(ips) {
+		t.Errorf("failed batch should fall back entirely, got results=%v leftovers=%v", results, leftovers)
+	}
+}
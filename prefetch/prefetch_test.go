@@ -437,3 +437,21 @@ func TestEvictedDomainNotRefreshed(t *testing.T) {
 	// Verify domain was not refreshed
 	assert.False(t, mockRefresher.wasRefreshed("test.com"))
 }
+
+// TestTopScoredDomainsAppliesDecay verifies ranking uses decayed scores without mutating the table
+func TestTopScoredDomainsAppliesDecay(t *testing.T) {
+	p := NewPrefetcher(&config.PrefetchConfig{Enabled: true}, &mockStats{}, &mockCache{}, &mockRefresher{})
+	currentCycle := time.Now().Unix() / DecayCycleSeconds
+
+	p.scoreMu.Lock()
+	p.scoreTable["fresh.com"] = &ScoreEntry{RawScore: 50, LastUpdateCycle: currentCycle}
+	// 100 * 0.93^20 ≈ 23.4，衰减后排在 fresh.com 之后
+	p.scoreTable["old.com"] = &ScoreEntry{RawScore: 100, LastUpdateCycle: currentCycle - 20}
+	p.scoreTable["low.com"] = &ScoreEntry{RawScore: 1, LastUpdateCycle: currentCycle}
+	p.scoreMu.Unlock()
+
+	assert.Equal(t, []string{"fresh.com", "old.com"}, p.TopScoredDomains(2))
+	assert.Len(t, p.TopScoredDomains(10), 3)
+	assert.Nil(t, p.TopScoredDomains(0))
+	assert.Equal(t, 100.0, p.scoreTable["old.com"].RawScore, "ranking must not write back decay")
+}
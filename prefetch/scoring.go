@@ -229,3 +229,38 @@ func (p *Prefetcher) evictMsg() {
 		delete(p.scoreTable, items[i].domain)
 	}
 }
+
+// TopScoredDomains 按衰减后的评分返回最多 n 个域名（评分从高到低），不修改评分表
+func (p *Prefetcher) TopScoredDomains(n int) []string {
+	if n <= 0 {
+		return nil
+	}
+	currentCycle := time.Now().Unix() / DecayCycleSeconds
+
+	type scored struct {
+		domain string
+		score  float64
+	}
+	p.scoreMu.RLock()
+	items := make([]scored, 0, len(p.scoreTable))
+	for domain, entry := range p.scoreTable {
+		score := entry.RawScore
+		if delta := currentCycle - entry.LastUpdateCycle; delta > 0 {
+			score *= math.Pow(DecayBase, float64(delta))
+		}
+		items = append(items, scored{domain: domain, score: score})
+	}
+	p.scoreMu.RUnlock()
+
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].score > items[j].score
+	})
+	if len(items) > n {
+		items = items[:n]
+	}
+	domains := make([]string, len(items))
+	for i, item := range items {
+		domains[i] = item.domain
+	}
+	return domains
+}
@@ -0,0 +1,260 @@
+package replication
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// 协议说明
+//
+// 连接由发送方（拨号方）发起，数据单向流向接收方（监听方）：
+//  1. 监听方发送 hello{version, node_id, nonce}
+//  2. 拨号方发送 hello{version, node_id, nonce, mac}，mac = HMAC(key, "dial" | 监听方 nonce | 拨号方 nonce | 拨号方 node_id)
+//  3. 监听方校验后回复 hello{mac}，mac = HMAC(key, "listen" | 拨号方 nonce | 监听方 nonce | 监听方 node_id)
+//  4. 双方以 HMAC(key, "session" | 监听方 nonce | 拨号方 nonce) 作为会话密钥，
+//     此后每个数据帧附带 HMAC(会话密钥, 序号 | 载荷)，防止篡改、重放和乱序
+//
+// 帧格式：4 字节大端长度 + 载荷 [+ 32 字节 MAC]
+const (
+	protocolVersion = 1
+	nonceSize       = 16
+	macSize         = sha256.Size
+	maxFrameSize    = 16 << 20
+)
+
+var errAuthFailed = errors.New("peer authentication failed")
+
+// hello 握手消息
+type hello struct {
+	Version int    `json:"version"`
+	NodeID  string `json:"node_id,omitempty"`
+	Nonce   []byte `json:"nonce,omitempty"`
+	MAC     []byte `json:"mac,omitempty"`
+}
+
+// batch 一个数据帧中携带的更新
+type batch struct {
+	Snapshot string         `json:"snapshot,omitempty"` // begin / end，标记全量快照的边界
+	Raw      []rawUpdate    `json:"raw,omitempty"`
+	Sorted   []sortedUpdate `json:"sorted,omitempty"`
+	RTT      []rttUpdate    `json:"rtt,omitempty"`
+}
+
+func (b *batch) size() int {
+	return len(b.Raw) + len(b.Sorted) + len(b.RTT)
+}
+
+// rawUpdate 原始缓存条目，记录以文本形式传输，完整应答以线路格式传输
+type rawUpdate struct {
+	Domain      string   `json:"d"`
+	Qtype       uint16   `json:"t"`
+	Msg         []byte   `json:"m,omitempty"`
+	Records     []string `json:"r,omitempty"`
+	CNAMEs      []string `json:"c,omitempty"`
+	UpstreamTTL uint32   `json:"ttl"`
+	AD          bool     `json:"ad,omitempty"`
+	Acquired    int64    `json:"at"` // UnixNano
+	Source      string   `json:"src,omitempty"`
+}
+
+// sortedUpdate 排序结果
+type sortedUpdate struct {
+	Domain    string   `json:"d"`
+	Qtype     uint16   `json:"t"`
+	IPs       []string `json:"ips"`
+	RTTs      []int    `json:"rtts"`
+	Timestamp int64    `json:"at"` // UnixNano
+	TTL       int      `json:"ttl"`
+}
+
+// rttUpdate IP 池中的 RTT 数据
+type rttUpdate struct {
+	IP      string  `json:"ip"`
+	RTT     int     `json:"rtt"`
+	RTTEWMA int     `json:"ewma"`
+	Loss    float64 `json:"loss,omitempty"`
+	Updated int64   `json:"at"` // UnixNano
+}
+
+func newNonce() ([]byte, error) {
+	nonce := make([]byte, nonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return nonce, nil
+}
+
+func computeMAC(key []byte, parts ...[]byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	for _, p := range parts {
+		mac.Write(p)
+	}
+	return mac.Sum(nil)
+}
+
+// writeFrame 写入长度前缀帧
+func writeFrame(w io.Writer, payload []byte) error {
+	var header [4]byte
+	binary.BigEndian.PutUint32(header[:], uint32(len(payload)))
+	if _, err := w.Write(header[:]); err != nil {
+		return err
+	}
+	_, err := w.Write(payload)
+	return err
+}
+
+// readFrame 读取长度前缀帧，拒绝超过 maxFrameSize 的帧
+func readFrame(r io.Reader) ([]byte, error) {
+	var header [4]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+	n := binary.BigEndian.Uint32(header[:])
+	if n > maxFrameSize {
+		return nil, fmt.Errorf("frame too large: %d bytes", n)
+	}
+	payload := make([]byte, n)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, err
+	}
+	return payload, nil
+}
+
+func writeHello(w io.Writer, h hello) error {
+	data, err := json.Marshal(h)
+	if err != nil {
+		return err
+	}
+	return writeFrame(w, data)
+}
+
+func readHello(r io.Reader) (hello, error) {
+	var h hello
+	data, err := readFrame(r)
+	if err != nil {
+		return h, err
+	}
+	if err := json.Unmarshal(data, &h); err != nil {
+		return h, fmt.Errorf("invalid hello: %w", err)
+	}
+	if h.Version != protocolVersion {
+		return h, fmt.Errorf("unsupported protocol version %d", h.Version)
+	}
+	return h, nil
+}
+
+// session 握手完成后的已认证连接状态
+type session struct {
+	key    []byte
+	seq    uint64
+	peerID string
+}
+
+// handshakeDial 拨号方握手，返回会话
+func handshakeDial(rw io.ReadWriter, key []byte, nodeID string) (*session, error) {
+	serverHello, err := readHello(rw)
+	if err != nil {
+		return nil, err
+	}
+	if len(serverHello.Nonce) != nonceSize {
+		return nil, errors.New("invalid peer nonce")
+	}
+	nonce, err := newNonce()
+	if err != nil {
+		return nil, err
+	}
+	mac := computeMAC(key, []byte("dial"), serverHello.Nonce, nonce, []byte(nodeID))
+	if err := writeHello(rw, hello{Version: protocolVersion, NodeID: nodeID, Nonce: nonce, MAC: mac}); err != nil {
+		return nil, err
+	}
+
+	ack, err := readHello(rw)
+	if err != nil {
+		return nil, err
+	}
+	expected := computeMAC(key, []byte("listen"), nonce, serverHello.Nonce, []byte(serverHello.NodeID))
+	if !hmac.Equal(ack.MAC, expected) {
+		return nil, errAuthFailed
+	}
+	return &session{
+		key:    computeMAC(key, []byte("session"), serverHello.Nonce, nonce),
+		peerID: serverHello.NodeID,
+	}, nil
+}
+
+// handshakeAccept 监听方握手，返回会话
+func handshakeAccept(rw io.ReadWriter, key []byte, nodeID string) (*session, error) {
+	nonce, err := newNonce()
+	if err != nil {
+		return nil, err
+	}
+	if err := writeHello(rw, hello{Version: protocolVersion, NodeID: nodeID, Nonce: nonce}); err != nil {
+		return nil, err
+	}
+
+	clientHello, err := readHello(rw)
+	if err != nil {
+		return nil, err
+	}
+	if len(clientHello.Nonce) != nonceSize {
+		return nil, errors.New("invalid peer nonce")
+	}
+	expected := computeMAC(key, []byte("dial"), nonce, clientHello.Nonce, []byte(clientHello.NodeID))
+	if !hmac.Equal(clientHello.MAC, expected) {
+		return nil, errAuthFailed
+	}
+
+	mac := computeMAC(key, []byte("listen"), clientHello.Nonce, nonce, []byte(nodeID))
+	if err := writeHello(rw, hello{Version: protocolVersion, MAC: mac}); err != nil {
+		return nil, err
+	}
+	return &session{
+		key:    computeMAC(key, []byte("session"), nonce, clientHello.Nonce),
+		peerID: clientHello.NodeID,
+	}, nil
+}
+
+// writeBatch 编码并发送一个带 MAC 的数据帧
+func (s *session) writeBatch(w io.Writer, b *batch) error {
+	payload, err := json.Marshal(b)
+	if err != nil {
+		return err
+	}
+	var seq [8]byte
+	binary.BigEndian.PutUint64(seq[:], s.seq)
+	s.seq++
+	frame := make([]byte, 0, len(payload)+macSize)
+	frame = append(frame, payload...)
+	frame = append(frame, computeMAC(s.key, seq[:], payload)...)
+	return writeFrame(w, frame)
+}
+
+// readBatch 读取并校验一个数据帧
+func (s *session) readBatch(r io.Reader) (*batch, error) {
+	frame, err := readFrame(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(frame) < macSize {
+		return nil, errors.New("frame too short")
+	}
+	payload, mac := frame[:len(frame)-macSize], frame[len(frame)-macSize:]
+	var seq [8]byte
+	binary.BigEndian.PutUint64(seq[:], s.seq)
+	if !hmac.Equal(mac, computeMAC(s.key, seq[:], payload)) {
+		return nil, errAuthFailed
+	}
+	s.seq++
+
+	var b batch
+	if err := json.Unmarshal(payload, &b); err != nil {
+		return nil, fmt.Errorf("invalid batch: %w", err)
+	}
+	return &b, nil
+}
@@ -0,0 +1,630 @@
+package replication
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"smartdnssort/cache"
+	"smartdnssort/logger"
+	"smartdnssort/ping"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	handshakeTimeout = 10 * time.Second
+	writeTimeout     = 10 * time.Second
+	maxReconnectWait = 30 * time.Second
+)
+
+// Options 节点间复制配置
+type Options struct {
+	NodeID        string        // 本节点标识，默认取主机名
+	ListenAddr    string        // 接收对端数据的监听地址，为空时只发送不接收
+	Peers         []string      // 对端地址列表（host:port）
+	SharedKey     string        // 共享密钥，用于双向认证和数据帧校验
+	QueueSize     int           // 每个对端的待发送队列长度，队列满时丢弃（重连时由全量快照补齐）
+	BatchSize     int           // 每帧最多携带的更新数
+	FlushInterval time.Duration // 增量更新的最长攒批时间
+}
+
+// PeerStats 单个对端的发送状态
+type PeerStats struct {
+	Addr        string    `json:"addr"`
+	PeerID      string    `json:"peer_id,omitempty"`
+	Connected   bool      `json:"connected"`
+	ConnectedAt time.Time `json:"connected_at,omitempty"`
+	LastError   string    `json:"last_error,omitempty"`
+	Sent        int64     `json:"sent"`
+	Dropped     int64     `json:"dropped"`
+	Snapshots   int64     `json:"snapshots"`
+	QueueLen    int       `json:"queue_len"`
+}
+
+// Stats 复制子系统统计
+type Stats struct {
+	Enabled        bool        `json:"enabled"`
+	NodeID         string      `json:"node_id"`
+	ListenAddr     string      `json:"listen_addr,omitempty"`
+	Peers          []PeerStats `json:"peers"`
+	InboundConns   int64       `json:"inbound_conns"`
+	AuthFailures   int64       `json:"auth_failures"`
+	Received       int64       `json:"received"`
+	Applied        int64       `json:"applied"`
+	Ignored        int64       `json:"ignored"` // 本地已有更新的数据（后写者胜）
+	InvalidRecords int64       `json:"invalid_records"`
+}
+
+// update 待发送的增量更新
+type update struct {
+	raw    *rawUpdate
+	sorted *sortedUpdate
+	rtts   []rttUpdate
+}
+
+// Replicator 在多个 SmartDNSSort 节点之间复制原始缓存、排序结果和 IP RTT
+// 每个节点主动连接所有对端并推送本地写入，连接建立（对端加入或重连）时先发送全量快照；
+// 接收方按获取时间"后写者胜"合并，实现最终一致
+type Replicator struct {
+	opts   Options
+	key    []byte
+	cache  *cache.Cache
+	poolFn func() *ping.IPPool
+
+	listener net.Listener
+	links    []*peerLink
+	inbound  sync.Map // net.Conn -> struct{}
+
+	stopCh   chan struct{}
+	stopOnce sync.Once
+	wg       sync.WaitGroup
+
+	inboundConns   atomic.Int64
+	authFailures   atomic.Int64
+	received       atomic.Int64
+	applied        atomic.Int64
+	ignored        atomic.Int64
+	invalidRecords atomic.Int64
+}
+
+// New 创建复制器，poolFn 返回当前的 IP 池（Pinger 热重载后会变化）
+func New(opts Options, c *cache.Cache, poolFn func() *ping.IPPool) (*Replicator, error) {
+	if opts.SharedKey == "" {
+		return nil, errors.New("replication requires a shared key")
+	}
+	if opts.NodeID == "" {
+		opts.NodeID, _ = os.Hostname()
+	}
+	if opts.QueueSize <= 0 {
+		opts.QueueSize = 10000
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 256
+	}
+	if opts.FlushInterval <= 0 {
+		opts.FlushInterval = 200 * time.Millisecond
+	}
+
+	r := &Replicator{
+		opts:   opts,
+		key:    []byte(opts.SharedKey),
+		cache:  c,
+		poolFn: poolFn,
+		stopCh: make(chan struct{}),
+	}
+	for _, addr := range opts.Peers {
+		r.links = append(r.links, &peerLink{
+			addr:  addr,
+			queue: make(chan update, opts.QueueSize),
+			r:     r,
+		})
+	}
+	return r, nil
+}
+
+// Start 启动监听和对端连接
+func (r *Replicator) Start() error {
+	if r.opts.ListenAddr != "" {
+		ln, err := net.Listen("tcp", r.opts.ListenAddr)
+		if err != nil {
+			return fmt.Errorf("failed to listen on %s: %w", r.opts.ListenAddr, err)
+		}
+		r.listener = ln
+		r.wg.Add(1)
+		go r.acceptLoop()
+		logger.Infof("[Replication] Node %s listening on %s", r.opts.NodeID, ln.Addr())
+	}
+	for _, link := range r.links {
+		r.wg.Add(1)
+		go link.run()
+	}
+	return nil
+}
+
+// Stop 关闭所有连接并等待后台任务退出
+func (r *Replicator) Stop() {
+	r.stopOnce.Do(func() {
+		close(r.stopCh)
+		if r.listener != nil {
+			r.listener.Close()
+		}
+		r.inbound.Range(func(key, _ any) bool {
+			key.(net.Conn).Close()
+			return true
+		})
+		for _, link := range r.links {
+			link.closeConn()
+		}
+	})
+	r.wg.Wait()
+}
+
+// Addr 返回实际监听地址（未监听时为 nil）
+func (r *Replicator) Addr() net.Addr {
+	if r.listener == nil {
+		return nil
+	}
+	return r.listener.Addr()
+}
+
+// OnRawSet 实现 cache.ReplicationHook
+func (r *Replicator) OnRawSet(domain string, qtype uint16, entry *cache.RawCacheEntry) {
+	u := newRawUpdate(domain, qtype, entry)
+	r.enqueue(update{raw: &u})
+}
+
+// OnSortedSet 实现 cache.ReplicationHook，同时携带这些 IP 在本地 IP 池中的 RTT
+func (r *Replicator) OnSortedSet(domain string, qtype uint16, entry *cache.SortedCacheEntry) {
+	u := newSortedUpdate(domain, qtype, entry)
+	r.enqueue(update{sorted: &u, rtts: r.collectRTTs(entry.IPs)})
+}
+
+// enqueue 非阻塞地投递到每个对端队列
+func (r *Replicator) enqueue(u update) {
+	for _, link := range r.links {
+		select {
+		case link.queue <- u:
+		default:
+			link.dropped.Add(1)
+		}
+	}
+}
+
+func newRawUpdate(domain string, qtype uint16, entry *cache.RawCacheEntry) rawUpdate {
+	u := rawUpdate{
+		Domain:      domain,
+		Qtype:       qtype,
+		CNAMEs:      entry.CNAMEs,
+		UpstreamTTL: entry.UpstreamTTL,
+		AD:          entry.AuthenticatedData,
+		Acquired:    entry.AcquisitionTime.UnixNano(),
+		Source:      entry.Source,
+	}
+	for _, rr := range entry.Records {
+		u.Records = append(u.Records, rr.String())
+	}
+	if entry.Msg != nil {
+		if packed, err := entry.Msg.Pack(); err == nil {
+			u.Msg = packed
+		}
+	}
+	return u
+}
+
+func newSortedUpdate(domain string, qtype uint16, entry *cache.SortedCacheEntry) sortedUpdate {
+	return sortedUpdate{
+		Domain:    domain,
+		Qtype:     qtype,
+		IPs:       entry.IPs,
+		RTTs:      entry.RTTs,
+		Timestamp: entry.Timestamp.UnixNano(),
+		TTL:       entry.TTL,
+	}
+}
+
+// collectRTTs 读取 IP 在本地 IP 池中的 RTT 数据
+func (r *Replicator) collectRTTs(ips []string) []rttUpdate {
+	pool := r.poolFn()
+	if pool == nil {
+		return nil
+	}
+	var rtts []rttUpdate
+	for _, ip := range ips {
+		if info, ok := pool.GetIPInfo(ip); ok && !info.RTTUpdated.IsZero() {
+			rtts = append(rtts, newRTTUpdate(info))
+		}
+	}
+	return rtts
+}
+
+func newRTTUpdate(info *ping.IPInfo) rttUpdate {
+	return rttUpdate{
+		IP:      info.IP,
+		RTT:     info.RTT,
+		RTTEWMA: info.RTTEWMA,
+		Loss:    info.Loss(),
+		Updated: info.RTTUpdated.UnixNano(),
+	}
+}
+
+// acceptLoop 接收对端连接
+func (r *Replicator) acceptLoop() {
+	defer r.wg.Done()
+	for {
+		conn, err := r.listener.Accept()
+		if err != nil {
+			select {
+			case <-r.stopCh:
+				return
+			default:
+			}
+			logger.Warnf("[Replication] Accept failed: %v", err)
+			time.Sleep(time.Second)
+			continue
+		}
+		r.wg.Add(1)
+		go r.serveConn(conn)
+	}
+}
+
+// serveConn 完成握手后持续接收并应用对端推送的更新
+func (r *Replicator) serveConn(conn net.Conn) {
+	defer r.wg.Done()
+	defer conn.Close()
+	r.inbound.Store(conn, struct{}{})
+	defer r.inbound.Delete(conn)
+	// Stop 遍历 inbound 之后才登记的连接不会被关闭，这里自行退出
+	select {
+	case <-r.stopCh:
+		return
+	default:
+	}
+
+	conn.SetDeadline(time.Now().Add(handshakeTimeout))
+	sess, err := handshakeAccept(conn, r.key, r.opts.NodeID)
+	if err != nil {
+		if errors.Is(err, errAuthFailed) {
+			r.authFailures.Add(1)
+		}
+		logger.Warnf("[Replication] Handshake with %s failed: %v", conn.RemoteAddr(), err)
+		return
+	}
+	conn.SetDeadline(time.Time{})
+	r.inboundConns.Add(1)
+	defer r.inboundConns.Add(-1)
+	logger.Infof("[Replication] Peer %s (%s) connected", sess.peerID, conn.RemoteAddr())
+
+	for {
+		b, err := sess.readBatch(conn)
+		if err != nil {
+			if errors.Is(err, errAuthFailed) {
+				r.authFailures.Add(1)
+			}
+			select {
+			case <-r.stopCh:
+			default:
+				logger.Debugf("[Replication] Connection from %s closed: %v", sess.peerID, err)
+			}
+			return
+		}
+		switch b.Snapshot {
+		case "begin":
+			logger.Debugf("[Replication] Receiving snapshot from %s", sess.peerID)
+		case "end":
+			logger.Infof("[Replication] Snapshot from %s applied", sess.peerID)
+		}
+		r.apply(b)
+	}
+}
+
+// apply 合并一批更新：先原始缓存和排序结果（排序结果会把 IP 注册到 IP 池），再合并 RTT
+func (r *Replicator) apply(b *batch) {
+	r.received.Add(int64(b.size()))
+
+	for _, u := range b.Raw {
+		records := make([]dns.RR, 0, len(u.Records))
+		valid := true
+		for _, s := range u.Records {
+			rr, err := dns.NewRR(s)
+			if err != nil || rr == nil {
+				valid = false
+				break
+			}
+			records = append(records, rr)
+		}
+		var msg *dns.Msg
+		if valid && len(u.Msg) > 0 {
+			msg = new(dns.Msg)
+			valid = msg.Unpack(u.Msg) == nil
+		}
+		if !valid {
+			r.invalidRecords.Add(1)
+			continue
+		}
+		r.count(r.cache.ApplyReplicatedRaw(u.Domain, u.Qtype, msg, records, u.CNAMEs, u.UpstreamTTL, u.AD, time.Unix(0, u.Acquired), u.Source))
+	}
+
+	for _, u := range b.Sorted {
+		if len(u.IPs) == 0 {
+			continue
+		}
+		ts := time.Unix(0, u.Timestamp)
+		r.count(r.cache.ApplyReplicatedSorted(u.Domain, u.Qtype, &cache.SortedCacheEntry{
+			IPs:          u.IPs,
+			RTTs:         u.RTTs,
+			Timestamp:    ts,
+			TTL:          u.TTL,
+			IsValid:      true,
+			QueryVersion: u.Timestamp,
+		}))
+	}
+
+	if pool := r.poolFn(); pool != nil {
+		for _, u := range b.RTT {
+			r.count(pool.MergeIPRTT(u.IP, u.RTT, u.RTTEWMA, u.Loss, time.Unix(0, u.Updated)))
+		}
+	}
+}
+
+func (r *Replicator) count(applied bool) {
+	if applied {
+		r.applied.Add(1)
+	} else {
+		r.ignored.Add(1)
+	}
+}
+
+// GetStats 获取复制统计，nil 安全
+func (r *Replicator) GetStats() Stats {
+	if r == nil {
+		return Stats{Peers: []PeerStats{}}
+	}
+	stats := Stats{
+		Enabled:        true,
+		NodeID:         r.opts.NodeID,
+		ListenAddr:     r.opts.ListenAddr,
+		Peers:          make([]PeerStats, 0, len(r.links)),
+		InboundConns:   r.inboundConns.Load(),
+		AuthFailures:   r.authFailures.Load(),
+		Received:       r.received.Load(),
+		Applied:        r.applied.Load(),
+		Ignored:        r.ignored.Load(),
+		InvalidRecords: r.invalidRecords.Load(),
+	}
+	for _, link := range r.links {
+		stats.Peers = append(stats.Peers, link.stats())
+	}
+	return stats
+}
+
+// peerLink 到单个对端的发送连接
+type peerLink struct {
+	addr  string
+	queue chan update
+	r     *Replicator
+
+	mu          sync.Mutex
+	conn        net.Conn
+	peerID      string
+	connectedAt time.Time
+	lastErr     string
+
+	sent      atomic.Int64
+	dropped   atomic.Int64
+	snapshots atomic.Int64
+}
+
+// run 连接、发送快照、持续推送增量；断开后指数退避重连
+func (l *peerLink) run() {
+	defer l.r.wg.Done()
+	wait := time.Second
+	for {
+		established, err := l.session()
+		select {
+		case <-l.r.stopCh:
+			return
+		default:
+		}
+		l.mu.Lock()
+		if err != nil {
+			l.lastErr = err.Error()
+		}
+		l.conn = nil
+		l.connectedAt = time.Time{}
+		l.mu.Unlock()
+
+		// 成功建立过的连接断开后立即以最短间隔重连
+		if established {
+			wait = time.Second
+		}
+		logger.Debugf("[Replication] Link to %s down: %v (retry in %v)", l.addr, err, wait)
+
+		select {
+		case <-l.r.stopCh:
+			return
+		case <-time.After(wait):
+		}
+		if !established {
+			wait = min(wait*2, maxReconnectWait)
+		}
+	}
+}
+
+// session 处理一次完整的连接生命周期，返回是否完成过握手
+func (l *peerLink) session() (bool, error) {
+	dialer := &net.Dialer{Timeout: handshakeTimeout, KeepAlive: 15 * time.Second}
+	ctx, cancel := context.WithTimeout(context.Background(), handshakeTimeout)
+	conn, err := dialer.DialContext(ctx, "tcp", l.addr)
+	cancel()
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+
+	l.mu.Lock()
+	l.conn = conn
+	l.mu.Unlock()
+	// Stop 可能在设置 conn 之前执行，再检查一次
+	select {
+	case <-l.r.stopCh:
+		return false, nil
+	default:
+	}
+
+	conn.SetDeadline(time.Now().Add(handshakeTimeout))
+	sess, err := handshakeDial(conn, l.r.key, l.r.opts.NodeID)
+	if err != nil {
+		return false, err
+	}
+	conn.SetDeadline(time.Time{})
+	if sess.peerID == l.r.opts.NodeID {
+		return false, fmt.Errorf("peer %s has the same node id as this node", l.addr)
+	}
+
+	l.mu.Lock()
+	l.peerID = sess.peerID
+	l.connectedAt = time.Now()
+	l.lastErr = ""
+	l.mu.Unlock()
+	logger.Infof("[Replication] Connected to peer %s (%s), sending snapshot", sess.peerID, l.addr)
+
+	// 快照覆盖了断线期间积压的增量，先清空队列
+	for drained := false; !drained; {
+		select {
+		case <-l.queue:
+		default:
+			drained = true
+		}
+	}
+	if err := l.sendSnapshot(conn, sess); err != nil {
+		return true, fmt.Errorf("snapshot: %w", err)
+	}
+	l.snapshots.Add(1)
+
+	return true, l.stream(conn, sess)
+}
+
+func (l *peerLink) write(conn net.Conn, sess *session, b *batch) error {
+	conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	if err := sess.writeBatch(conn, b); err != nil {
+		return err
+	}
+	l.sent.Add(int64(b.size()))
+	return nil
+}
+
+// sendSnapshot 发送全量快照：原始缓存、排序结果、IP 池 RTT
+func (l *peerLink) sendSnapshot(conn net.Conn, sess *session) error {
+	batchSize := l.r.opts.BatchSize
+	if err := l.write(conn, sess, &batch{Snapshot: "begin"}); err != nil {
+		return err
+	}
+
+	var err error
+	b := &batch{}
+	flush := func() bool {
+		if b.size() == 0 {
+			return true
+		}
+		err = l.write(conn, sess, b)
+		b = &batch{}
+		return err == nil
+	}
+
+	l.r.cache.RangeRaw(func(domain string, qtype uint16, entry *cache.RawCacheEntry) bool {
+		b.Raw = append(b.Raw, newRawUpdate(domain, qtype, entry))
+		return b.size() < batchSize || flush()
+	})
+	if err != nil {
+		return err
+	}
+	l.r.cache.RangeSorted(func(domain string, qtype uint16, entry *cache.SortedCacheEntry) bool {
+		b.Sorted = append(b.Sorted, newSortedUpdate(domain, qtype, entry))
+		return b.size() < batchSize || flush()
+	})
+	if err != nil {
+		return err
+	}
+	// 排序结果需先于 RTT 到达，对端才能把 IP 注册进 IP 池
+	if !flush() {
+		return err
+	}
+	if pool := l.r.poolFn(); pool != nil {
+		for _, info := range pool.GetAllIPs() {
+			if info.RTTUpdated.IsZero() {
+				continue
+			}
+			b.RTT = append(b.RTT, newRTTUpdate(info))
+			if b.size() >= batchSize && !flush() {
+				return err
+			}
+		}
+	}
+	if !flush() {
+		return err
+	}
+	return l.write(conn, sess, &batch{Snapshot: "end"})
+}
+
+// stream 攒批推送增量更新
+func (l *peerLink) stream(conn net.Conn, sess *session) error {
+	ticker := time.NewTicker(l.r.opts.FlushInterval)
+	defer ticker.Stop()
+
+	b := &batch{}
+	for {
+		select {
+		case <-l.r.stopCh:
+			return nil
+		case u := <-l.queue:
+			if u.raw != nil {
+				b.Raw = append(b.Raw, *u.raw)
+			}
+			if u.sorted != nil {
+				b.Sorted = append(b.Sorted, *u.sorted)
+			}
+			b.RTT = append(b.RTT, u.rtts...)
+			if b.size() < l.r.opts.BatchSize {
+				continue
+			}
+		case <-ticker.C:
+			if b.size() == 0 {
+				continue
+			}
+		}
+		if err := l.write(conn, sess, b); err != nil {
+			return err
+		}
+		b = &batch{}
+	}
+}
+
+func (l *peerLink) closeConn() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.conn != nil {
+		l.conn.Close()
+	}
+}
+
+func (l *peerLink) stats() PeerStats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return PeerStats{
+		Addr:        l.addr,
+		PeerID:      l.peerID,
+		Connected:   !l.connectedAt.IsZero(),
+		ConnectedAt: l.connectedAt,
+		LastError:   l.lastErr,
+		Sent:        l.sent.Load(),
+		Dropped:     l.dropped.Load(),
+		Snapshots:   l.snapshots.Load(),
+		QueueLen:    len(l.queue),
+	}
+}
@@ -0,0 +1,252 @@
+package replication
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"smartdnssort/cache"
+	"smartdnssort/config"
+	"smartdnssort/ping"
+
+	"github.com/miekg/dns"
+)
+
+// testNode 一个带有独立缓存和 IP 池的复制节点
+type testNode struct {
+	cache *cache.Cache
+	pool  *ping.IPPool
+	repl  *Replicator
+}
+
+func newTestCache() *cache.Cache {
+	return cache.NewCache(&config.CacheConfig{
+		FastResponseTTL:           15,
+		UserReturnTTL:             600,
+		NegativeTTLSeconds:        300,
+		ErrorCacheTTL:             30,
+		MaxMemoryMB:               16,
+		MsgCacheSizeMB:            1,
+		DNSSECMsgCacheTTLSeconds:  300,
+		EvictionThreshold:         0.9,
+		EvictionBatchPercent:      0.1,
+		SaveToDiskIntervalMinutes: 60,
+	})
+}
+
+// newTestNode 创建节点并启动监听（127.0.0.1 随机端口），peers 为推送目标
+func newTestNode(t *testing.T, id, key string, peers ...string) *testNode {
+	t.Helper()
+	n := &testNode{cache: newTestCache(), pool: ping.NewIPPool()}
+	n.cache.SetIPPoolUpdater(n.pool)
+	r, err := New(Options{
+		NodeID:        id,
+		ListenAddr:    "127.0.0.1:0",
+		Peers:         peers,
+		SharedKey:     key,
+		FlushInterval: 10 * time.Millisecond,
+	}, n.cache, func() *ping.IPPool { return n.pool })
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if err := r.Start(); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	n.cache.SetReplicationHook(r)
+	n.repl = r
+	t.Cleanup(r.Stop)
+	return n
+}
+
+func aRecords(t *testing.T, domain string, ips ...string) []dns.RR {
+	t.Helper()
+	var records []dns.RR
+	for _, ip := range ips {
+		rr, err := dns.NewRR(fmt.Sprintf("%s 300 IN A %s", dns.Fqdn(domain), ip))
+		if err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, rr)
+	}
+	return records
+}
+
+func mustRR(t *testing.T, s string) dns.RR {
+	t.Helper()
+	rr, err := dns.NewRR(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rr
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if cond() {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for %s", what)
+}
+
+func TestReplicationSnapshotAndStream(t *testing.T) {
+	receiver := newTestNode(t, "b", "secret")
+
+	sender := &testNode{cache: newTestCache(), pool: ping.NewIPPool()}
+	sender.cache.SetIPPoolUpdater(sender.pool)
+
+	// 连接建立前写入的数据通过全量快照同步
+	sender.cache.SetRawRecordsWithSource("before.example.com", dns.TypeA, aRecords(t, "before.example.com", "1.1.1.1", "2.2.2.2"), nil, 300, false, "8.8.8.8:53")
+	sender.cache.SetSorted("before.example.com", dns.TypeA, &cache.SortedCacheEntry{
+		IPs: []string{"2.2.2.2", "1.1.1.1"}, RTTs: []int{5, 50}, Timestamp: time.Now(), TTL: 300, IsValid: true,
+	})
+	sender.pool.UpdateIPRTT("2.2.2.2", 5, 0, 0.3)
+
+	r, err := New(Options{NodeID: "a", Peers: []string{receiver.repl.Addr().String()}, SharedKey: "secret", FlushInterval: 10 * time.Millisecond},
+		sender.cache, func() *ping.IPPool { return sender.pool })
+	if err != nil {
+		t.Fatal(err)
+	}
+	sender.cache.SetReplicationHook(r)
+	if err := r.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(r.Stop)
+
+	waitFor(t, "snapshot", func() bool {
+		_, ok := receiver.cache.GetSorted("before.example.com", dns.TypeA)
+		return ok
+	})
+	raw, ok := receiver.cache.GetRaw("before.example.com", dns.TypeA)
+	if !ok || len(raw.IPs) != 2 || raw.Source != "8.8.8.8:53" {
+		t.Fatalf("raw entry not replicated correctly: %+v", raw)
+	}
+	sorted, _ := receiver.cache.GetSorted("before.example.com", dns.TypeA)
+	if sorted.IPs[0] != "2.2.2.2" {
+		t.Errorf("sorted IPs = %v, want 2.2.2.2 first", sorted.IPs)
+	}
+	waitFor(t, "RTT merge", func() bool {
+		rtt, _, updated := receiver.pool.GetIPRTT("2.2.2.2")
+		return updated && rtt == 5
+	})
+
+	// 连接建立后的写入实时推送
+	sender.cache.SetRawRecordsWithSource("live.example.com", dns.TypeA, aRecords(t, "live.example.com", "3.3.3.3"), nil, 300, false, "")
+	waitFor(t, "live update", func() bool {
+		_, ok := receiver.cache.GetRaw("live.example.com", dns.TypeA)
+		return ok
+	})
+
+	// 通用类型的完整应答（Authority/Additional 段）随条目一起复制
+	msg := new(dns.Msg)
+	msg.SetQuestion("mx.example.com.", dns.TypeMX)
+	msg.Answer = []dns.RR{mustRR(t, "mx.example.com. 300 IN MX 10 mail.example.com.")}
+	msg.Ns = []dns.RR{mustRR(t, "example.com. 300 IN NS ns1.example.com.")}
+	msg.Extra = []dns.RR{mustRR(t, "mail.example.com. 300 IN A 192.0.2.25")}
+	sender.cache.SetRawMessage("mx.example.com", dns.TypeMX, msg, msg.Answer, nil, 300, false, "")
+	waitFor(t, "message update", func() bool {
+		_, ok := receiver.cache.GetRaw("mx.example.com", dns.TypeMX)
+		return ok
+	})
+	if raw, _ := receiver.cache.GetRaw("mx.example.com", dns.TypeMX); raw.Msg == nil || len(raw.Msg.Ns) != 1 || len(raw.Msg.Extra) != 1 {
+		t.Errorf("full message not replicated: %+v", raw.Msg)
+	}
+
+	stats := r.GetStats()
+	if len(stats.Peers) != 1 || !stats.Peers[0].Connected || stats.Peers[0].PeerID != "b" || stats.Peers[0].Snapshots != 1 {
+		t.Errorf("unexpected sender stats: %+v", stats.Peers)
+	}
+	if got := receiver.repl.GetStats(); got.InboundConns != 1 || got.Applied == 0 {
+		t.Errorf("unexpected receiver stats: %+v", got)
+	}
+}
+
+func TestReplicationLastWriterWins(t *testing.T) {
+	c := newTestCache()
+	records := aRecords(t, "lww.example.com", "1.1.1.1")
+	now := time.Now()
+
+	if !c.ApplyReplicatedRaw("lww.example.com", dns.TypeA, nil, records, nil, 300, false, now, "") {
+		t.Fatal("first write should be applied")
+	}
+	if c.ApplyReplicatedRaw("lww.example.com", dns.TypeA, nil, aRecords(t, "lww.example.com", "9.9.9.9"), nil, 300, false, now.Add(-time.Minute), "") {
+		t.Error("older write should be ignored")
+	}
+	raw, _ := c.GetRaw("lww.example.com", dns.TypeA)
+	if raw.IPs[0] != "1.1.1.1" {
+		t.Errorf("IPs = %v, older write must not overwrite", raw.IPs)
+	}
+	if !c.ApplyReplicatedRaw("lww.example.com", dns.TypeA, nil, aRecords(t, "lww.example.com", "4.4.4.4"), nil, 300, false, now.Add(time.Second), "") {
+		t.Error("newer write should be applied")
+	}
+
+	// 早已过期的条目不写入
+	if c.ApplyReplicatedRaw("old.example.com", dns.TypeA, nil, records, nil, 60, false, now.Add(-48*time.Hour), "") {
+		t.Error("ancient entry should be ignored")
+	}
+
+	sorted := &cache.SortedCacheEntry{IPs: []string{"1.1.1.1"}, RTTs: []int{10}, Timestamp: now, TTL: 300, IsValid: true}
+	if !c.ApplyReplicatedSorted("lww.example.com", dns.TypeA, sorted) {
+		t.Fatal("sorted write should be applied")
+	}
+	older := &cache.SortedCacheEntry{IPs: []string{"9.9.9.9"}, RTTs: []int{1}, Timestamp: now.Add(-time.Second), TTL: 300, IsValid: true}
+	if c.ApplyReplicatedSorted("lww.example.com", dns.TypeA, older) {
+		t.Error("older sorted result should be ignored")
+	}
+}
+
+func TestReplicationRejectsWrongKey(t *testing.T) {
+	receiver := newTestNode(t, "b", "secret")
+	sender := newTestNode(t, "a", "wrong", receiver.repl.Addr().String())
+
+	sender.cache.SetRawRecordsWithSource("x.example.com", dns.TypeA, aRecords(t, "x.example.com", "1.1.1.1"), nil, 300, false, "")
+	waitFor(t, "auth failure", func() bool {
+		return receiver.repl.GetStats().AuthFailures > 0
+	})
+	if _, ok := receiver.cache.GetRaw("x.example.com", dns.TypeA); ok {
+		t.Error("data from an unauthenticated peer must not be applied")
+	}
+	if peer := sender.repl.GetStats().Peers[0]; peer.Connected || peer.LastError == "" {
+		t.Errorf("sender should report the failed handshake: %+v", peer)
+	}
+}
+
+func TestSessionFrameIntegrity(t *testing.T) {
+	key := []byte("session-key")
+	writer := &session{key: key}
+	var buf bytes.Buffer
+	if err := writer.writeBatch(&buf, &batch{RTT: []rttUpdate{{IP: "1.1.1.1", RTT: 10}}}); err != nil {
+		t.Fatal(err)
+	}
+	frame := append([]byte(nil), buf.Bytes()...)
+
+	// 正常读取
+	reader := &session{key: key}
+	b, err := reader.readBatch(bytes.NewReader(frame))
+	if err != nil || len(b.RTT) != 1 || b.RTT[0].IP != "1.1.1.1" {
+		t.Fatalf("readBatch() = %+v, %v", b, err)
+	}
+
+	// 重放：序号已前进，同一帧不能再次通过校验
+	if _, err := reader.readBatch(bytes.NewReader(frame)); !errors.Is(err, errAuthFailed) {
+		t.Errorf("replayed frame error = %v, want errAuthFailed", err)
+	}
+
+	// 篡改载荷
+	tampered := append([]byte(nil), frame...)
+	tampered[10] ^= 0xff
+	if _, err := (&session{key: key}).readBatch(bytes.NewReader(tampered)); !errors.Is(err, errAuthFailed) {
+		t.Errorf("tampered frame error = %v, want errAuthFailed", err)
+	}
+}
+
+func TestNewRequiresSharedKey(t *testing.T) {
+	if _, err := New(Options{}, newTestCache(), nil); err == nil {
+		t.Error("New() without shared key should fail")
+	}
+}
@@ -22,6 +22,9 @@ type Stats struct {
 	cacheHits         int64
 	cacheMisses       int64
 	cacheStaleRefresh int64 // 缓存更新：缓存已过期但返回给用户，同时向上游查询
+	synthesizedNX     int64 // 由缓存的 NSEC/NSEC3 证明合成的 NXDOMAIN
+	synthesizedNoData int64 // 由缓存的 NSEC/NSEC3 证明合成的 NODATA
+	safeSearch        int64 // 改写为安全搜索端点的查询
 	upstreamFailures  int64 // 总失败计数
 	pingSuccesses     int64
 	pingFailures      int64
@@ -172,6 +175,20 @@ func (s *Stats) IncCacheStaleRefresh() {
 	s.generalStatsTracker.RecordCacheStaleRefresh()
 }
 
+// IncSynthesizedNegative 增加本地合成的否定应答计数（积极否定缓存）
+func (s *Stats) IncSynthesizedNegative(nxdomain bool) {
+	if nxdomain {
+		atomic.AddInt64(&s.synthesizedNX, 1)
+	} else {
+		atomic.AddInt64(&s.synthesizedNoData, 1)
+	}
+}
+
+// IncSafeSearch 增加安全搜索改写计数
+func (s *Stats) IncSafeSearch() {
+	atomic.AddInt64(&s.safeSearch, 1)
+}
+
 // IncUpstreamFailures 增加上游失败计数 (总计)
 // 熔断：断网时不记录，避免统计污染
 func (s *Stats) IncUpstreamFailures() {
@@ -284,6 +301,9 @@ func (s *Stats) GetStats() map[string]interface{} {
 	cacheHits := atomic.LoadInt64(&s.cacheHits)
 	cacheMisses := atomic.LoadInt64(&s.cacheMisses)
 	cacheStaleRefresh := atomic.LoadInt64(&s.cacheStaleRefresh)
+	synthesizedNX := atomic.LoadInt64(&s.synthesizedNX)
+	synthesizedNoData := atomic.LoadInt64(&s.synthesizedNoData)
+	safeSearch := atomic.LoadInt64(&s.safeSearch)
 	upstreamFailures := atomic.LoadInt64(&s.upstreamFailures)
 	pingSuccesses := atomic.LoadInt64(&s.pingSuccesses)
 	pingFailures := atomic.LoadInt64(&s.pingFailures)
@@ -328,22 +348,25 @@ func (s *Stats) GetStats() map[string]interface{} {
 
 	// 6. 构建返回结果
 	return map[string]interface{}{
-		"total_queries":       queries,
-		"effective_queries":   effectiveQueries,
-		"cache_hits":          cacheHits,
-		"cache_misses":        cacheMisses,
-		"cache_stale_refresh": cacheStaleRefresh,
-		"cache_hit_rate":      hitRate,
-		"upstream_failures":   upstreamFailures,
-		"ping_successes":      pingSuccesses,
-		"ping_failures":       pingFailures,
-		"average_rtt_ms":      avgRTT,
-		"failed_nodes":        failedNodesSnapshot,
-		"system_stats":        sysStats,
-		"top_domains":         topDomains,
-		"top_blocked_domains": topBlockedDomains,
-		"uptime_seconds":      time.Since(s.startTime).Seconds(),
-		"evictions_per_min":   0.0,
+		"total_queries":        queries,
+		"effective_queries":    effectiveQueries,
+		"cache_hits":           cacheHits,
+		"cache_misses":         cacheMisses,
+		"cache_stale_refresh":  cacheStaleRefresh,
+		"synthesized_nxdomain": synthesizedNX,
+		"synthesized_nodata":   synthesizedNoData,
+		"safe_search_queries":  safeSearch,
+		"cache_hit_rate":       hitRate,
+		"upstream_failures":    upstreamFailures,
+		"ping_successes":       pingSuccesses,
+		"ping_failures":        pingFailures,
+		"average_rtt_ms":       avgRTT,
+		"failed_nodes":         failedNodesSnapshot,
+		"system_stats":         sysStats,
+		"top_domains":          topDomains,
+		"top_blocked_domains":  topBlockedDomains,
+		"uptime_seconds":       time.Since(s.startTime).Seconds(),
+		"evictions_per_min":    0.0,
 	}
 }
 
@@ -455,6 +478,9 @@ func (s *Stats) Reset() {
 	atomic.StoreInt64(&s.cacheHits, 0)
 	atomic.StoreInt64(&s.cacheMisses, 0)
 	atomic.StoreInt64(&s.cacheStaleRefresh, 0)
+	atomic.StoreInt64(&s.synthesizedNX, 0)
+	atomic.StoreInt64(&s.synthesizedNoData, 0)
+	atomic.StoreInt64(&s.safeSearch, 0)
 	atomic.StoreInt64(&s.upstreamFailures, 0)
 	atomic.StoreInt64(&s.pingSuccesses, 0)
 	atomic.StoreInt64(&s.pingFailures, 0)
@@ -36,6 +36,7 @@ type QueryResultWithTTL struct {
 	TTL               uint32   // 上游 DNS 返回的 TTL
 	AuthenticatedData bool     // DNSSEC 验证标记 (AD flag)
 	DnsMsg            *dns.Msg // 原始 DNS 消息（包含完整的 RRSIG 等 DNSSEC 数据）
+	Server            string   // 提供该应答的上游服务器
 }
 
 // Manager 上游 DNS 查询管理器
@@ -229,6 +229,7 @@ func (u *Manager) queryParallel(ctx context.Context, domain string, qtype uint16
 		TTL:               fastResponse.TTL,
 		AuthenticatedData: fastResponse.AuthenticatedData,
 		DnsMsg:            fastResponse.DnsMsg,
+		Server:            fastResponse.Server,
 	}, nil
 }
 
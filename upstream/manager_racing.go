@@ -112,6 +112,7 @@ func (u *Manager) queryRacing(ctx context.Context, domain string, qtype uint16,
 				TTL:               ttl,
 				AuthenticatedData: reply.AuthenticatedData,
 				DnsMsg:            reply.Copy(),
+				Server:            srv.Address(),
 			}
 
 			once.Do(func() {
@@ -95,7 +95,7 @@ func (u *Manager) queryRandom(ctx context.Context, domain string, qtype uint16,
 			server.RecordSuccess()
 			queryLatency := time.Since(queryStartTime)
 			u.RecordQueryLatency(queryLatency)
-			return &QueryResultWithTTL{Records: nil, IPs: nil, CNAMEs: nil, TTL: ttl, DnsMsg: reply.Copy()}, nil
+			return &QueryResultWithTTL{Records: nil, IPs: nil, CNAMEs: nil, TTL: ttl, DnsMsg: reply.Copy(), Server: server.Address()}, nil
 		}
 
 		// 处理其他 DNS 错误响应码
@@ -130,7 +130,7 @@ func (u *Manager) queryRandom(ctx context.Context, domain string, qtype uint16,
 			logger.Warnf("[queryRandom] ⚠️  第 %d 次尝试: %s 返回空结果",
 				attemptNum+1, server.Address())
 			// 保存这个空结果,但继续尝试其他服务器
-			lastResult = &QueryResultWithTTL{Records: records, IPs: ips, CNAMEs: cnames, TTL: ttl, DnsMsg: reply.Copy()}
+			lastResult = &QueryResultWithTTL{Records: records, IPs: ips, CNAMEs: cnames, TTL: ttl, DnsMsg: reply.Copy(), Server: server.Address()}
 			continue
 		}
 
@@ -143,7 +143,7 @@ func (u *Manager) queryRandom(ctx context.Context, domain string, qtype uint16,
 		queryLatency := time.Since(queryStartTime)
 		u.RecordQueryLatency(queryLatency)
 
-		return &QueryResultWithTTL{Records: records, IPs: ips, CNAMEs: cnames, TTL: ttl, AuthenticatedData: reply.AuthenticatedData, DnsMsg: reply.Copy()}, nil
+		return &QueryResultWithTTL{Records: records, IPs: ips, CNAMEs: cnames, TTL: ttl, AuthenticatedData: reply.AuthenticatedData, DnsMsg: reply.Copy(), Server: server.Address()}, nil
 	}
 
 	// 所有服务器都失败了
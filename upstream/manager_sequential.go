@@ -98,7 +98,7 @@ func (u *Manager) querySequential(ctx context.Context, domain string, qtype uint
 			ttl := extractNegativeTTL(reply)
 			logger.Debugf("[querySequential] 服务器 %s 返回 NXDOMAIN，立即返回", server.Address())
 			server.RecordSuccess()
-			return &QueryResultWithTTL{Records: nil, IPs: nil, CNAMEs: nil, TTL: ttl, DnsMsg: reply.Copy()}, nil
+			return &QueryResultWithTTL{Records: nil, IPs: nil, CNAMEs: nil, TTL: ttl, DnsMsg: reply.Copy(), Server: server.Address()}, nil
 		}
 
 		// 处理其他 DNS 错误响应码
@@ -142,7 +142,7 @@ func (u *Manager) querySequential(ctx context.Context, domain string, qtype uint
 		u.RecordQueryLatency(queryLatency)
 		logger.Debugf("[querySequential] 记录查询延迟: %v (用于动态参数优化)", queryLatency)
 
-		return &QueryResultWithTTL{Records: records, IPs: ips, CNAMEs: cnames, TTL: ttl, AuthenticatedData: reply.AuthenticatedData, DnsMsg: reply.Copy()}, nil
+		return &QueryResultWithTTL{Records: records, IPs: ips, CNAMEs: cnames, TTL: ttl, AuthenticatedData: reply.AuthenticatedData, DnsMsg: reply.Copy(), Server: server.Address()}, nil
 	}
 
 	// 所有服务器都尝试失败
@@ -31,6 +31,10 @@ type QueryResult struct {
 	Type   string     `json:"type"`
 	IPs    []IPResult `json:"ips"`
 	Status string     `json:"status"`
+
+	// 手动排序规则：IPs 已是应用规则后的实际响应顺序
+	SortRules       []string `json:"sort_rules,omitempty"` // 命中的规则
+	ReorderedByRule bool     `json:"reordered_by_rule"`    // 规则是否改变了顺序或排除了 IP
 }
 
 // IPResult 单个 IP 的结果，包含 RTT
@@ -60,6 +64,7 @@ type Server struct {
 	isAdblockBusy       bool         // AdBlock 更新进行中标志
 	customRulesMutex    sync.RWMutex // 保护 custom_rules.txt 读写
 	customResponseMutex sync.RWMutex // 保护 custom_response_rules.txt 读写
+	sortRulesMutex      sync.RWMutex // 保护 sort_rules.txt 读写
 	unboundConfigMutex  sync.RWMutex // 保护 Unbound 配置文件读写
 }
 
@@ -95,6 +100,8 @@ func (s *Server) Start() error {
 	mux.HandleFunc("/api/stats/clear", s.handleClearStats)
 	mux.HandleFunc("/api/cache/clear", s.handleClearCache)
 	mux.HandleFunc("/api/cache/memory", s.handleCacheMemoryStats)
+	mux.HandleFunc("/api/cache/entries", s.handleCacheEntries)
+	mux.HandleFunc("/api/cache/purge", s.handleCachePurge)
 	mux.HandleFunc("/api/config", s.handleConfig)  // GET 和 POST 都支持
 	mux.HandleFunc("/api/config/reset", s.handleResetConfig)
 	mux.HandleFunc("/api/config/export", s.handleExportConfig)
@@ -111,15 +118,21 @@ func (s *Server) Start() error {
 	// AdBlock API 路由
 	mux.HandleFunc("/api/adblock/status", s.handleAdBlockStatus)
 	mux.HandleFunc("/api/adblock/sources", s.handleAdBlockSources)
+	mux.HandleFunc("/api/adblock/sources/", s.handleAdBlockSourceStats)
+	mux.HandleFunc("/api/adblock/unblock-requests", s.handleAdBlockUnblockRequests)
+	mux.HandleFunc("/api/adblock/dga", s.handleAdBlockDGA)
 	mux.HandleFunc("/api/adblock/update", s.handleAdBlockUpdate)
 	mux.HandleFunc("/api/adblock/toggle", s.handleAdBlockToggle)
+	mux.HandleFunc("/api/adblock/pause", s.handleAdBlockPause)
 	mux.HandleFunc("/api/adblock/test", s.handleAdBlockTest)
 	mux.HandleFunc("/api/adblock/blockmode", s.handleAdBlockBlockMode)
 	mux.HandleFunc("/api/adblock/settings", s.handleAdBlockSettings)
+	mux.HandleFunc("/api/adblock/services", s.handleAdBlockServices)
 
 	// 自定义规则 API 路由
 	mux.HandleFunc("/api/custom/blocked", s.handleCustomBlocked)
 	mux.HandleFunc("/api/custom/response", s.handleCustomResponse)
+	mux.HandleFunc("/api/sort-rules", s.handleSortRules)
 
 	// Recursor API 路由
 	mux.HandleFunc("/api/recursor/status", s.handleRecursorStatus)
@@ -136,6 +149,12 @@ func (s *Server) Start() error {
 	mux.HandleFunc("/api/ip-pool/top", s.handleIPPoolTop)
 	mux.HandleFunc("/api/ip-pool/toggle", s.handleIPPoolToggle)
 
+	// 多实例缓存复制
+	mux.HandleFunc("/api/replication/status", s.handleReplicationStatus)
+
+	// 启动缓存预热
+	mux.HandleFunc("/api/warmup/status", s.handleWarmupStatus)
+
 	// Web 文件服务
 	webSubFS, err := fs.Sub(webFilesFS, "web")
 	if err == nil {
@@ -175,83 +194,6 @@ func (s *Server) Stop() error {
 	return s.listener.Shutdown(ctx)
 }
 
-// calculateAvgBytesPerEntry 计算缓存条目的平均字节数
-// 通过采样缓存中的条目来获得更准确的平均值
-func (s *Server) calculateAvgBytesPerEntry() int64 {
-	const (
-		defaultAvgBytes = 820 // 默认估算值
-		sampleSize      = 100 // 采样100个条目
-	)
-
-	// 获取所有缓存条目
-	allEntries := s.dnsCache.GetRawCacheSnapshot()
-	if len(allEntries) == 0 {
-		return defaultAvgBytes
-	}
-
-	// 如果条目数少于采样大小，直接计算所有条目的平均值
-	if len(allEntries) <= sampleSize {
-		totalSize := int64(0)
-		for _, entry := range allEntries {
-			totalSize += estimateEntrySize(entry)
-		}
-		return totalSize / int64(len(allEntries))
-	}
-
-	// 采样计算：每隔 len/sampleSize 个条目采样一个
-	step := len(allEntries) / sampleSize
-	if step < 1 {
-		step = 1
-	}
-
-	totalSize := int64(0)
-	sampleCount := 0
-	for i := 0; i < len(allEntries); i += step {
-		totalSize += estimateEntrySize(allEntries[i])
-		sampleCount++
-	}
-
-	if sampleCount == 0 {
-		return defaultAvgBytes
-	}
-
-	return totalSize / int64(sampleCount)
-}
-
-// estimateEntrySize 估算单个缓存条目的大小（字节）
-func estimateEntrySize(entry *cache.RawCacheEntry) int64 {
-	if entry == nil {
-		return 0
-	}
-
-	size := int64(0)
-
-	// RawCacheEntry 结构体本身的大小
-	size += 8 + 8 + 8 + 8 + 8 + 8 + 8 + 1 + 8 // 各字段的大小
-
-	// IPs 切片
-	for _, ip := range entry.IPs {
-		size += int64(len(ip))
-	}
-	size += int64(len(entry.IPs)) * 24 // 切片头的开销
-
-	// CNAMEs 切片
-	for _, cname := range entry.CNAMEs {
-		size += int64(len(cname))
-	}
-	size += int64(len(entry.CNAMEs)) * 24 // 切片头的开销
-
-	// Records 切片（如果有）
-	if entry.Records != nil {
-		size += int64(len(entry.Records)) * 100 // 粗略估算每个 DNS 记录约 100 字节
-	}
-
-	// 其他开销（map 节点、指针等）
-	size += 100
-
-	return size
-}
-
 // calculateEvictionsPerMinute 计算每分钟的驱逐率
 func (s *Server) calculateEvictionsPerMinute() float64 {
 	currentEvictions := s.dnsCache.GetEvictions()
@@ -3,8 +3,10 @@ package webapi
 import (
 	"encoding/json"
 	"net/http"
+	"net/netip"
 	"smartdnssort/config"
 	"smartdnssort/logger"
+	"time"
 
 	"gopkg.in/yaml.v3"
 )
@@ -193,15 +195,9 @@ func (s *Server) handleAdBlockToggle(w http.ResponseWriter, r *http.Request) {
 	s.cfgMutex.Lock()
 	defer s.cfgMutex.Unlock()
 
-	// Update in-memory config
+	// Update in-memory config and the AdBlockManager's effective state
 	s.dnsServer.SetAdBlockEnabled(payload.Enabled)
 
-	// Also update AdBlockManager's internal config
-	adblockMgr := s.dnsServer.GetAdBlockManager()
-	if adblockMgr != nil {
-		adblockMgr.SetEnabled(payload.Enabled)
-	}
-
 	// Load current config from file
 	cfg, err := config.LoadConfig(s.configPath)
 	if err != nil {
@@ -231,6 +227,59 @@ func (s *Server) handleAdBlockToggle(w http.ResponseWriter, r *http.Request) {
 	s.writeJSONSuccess(w, "AdBlock status updated successfully", nil)
 }
 
+// maxAdBlockPause 单次暂停的最长时间
+const maxAdBlockPause = 24 * time.Hour
+
+// handleAdBlockPause 处理临时暂停请求：GET 查询状态，POST 按 duration 暂停，DELETE 立即恢复
+// 暂停不修改配置文件，到期后自动恢复暂停前的状态
+func (s *Server) handleAdBlockPause(w http.ResponseWriter, r *http.Request) {
+	adblockMgr := s.dnsServer.GetAdBlockManager()
+	if adblockMgr == nil {
+		s.writeJSONError(w, "AdBlock is disabled", http.StatusServiceUnavailable)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		until := adblockMgr.PausedUntil()
+		data := map[string]interface{}{"paused": !until.IsZero()}
+		if !until.IsZero() {
+			data["paused_until"] = until.Format(time.RFC3339)
+			data["remaining_seconds"] = int(time.Until(until).Seconds())
+		}
+		s.writeJSONSuccess(w, "AdBlock pause status retrieved successfully", data)
+
+	case http.MethodPost:
+		var payload struct {
+			Duration string `json:"duration"` // 如 "15m"、"1h"
+		}
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			s.writeJSONError(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		d, err := time.ParseDuration(payload.Duration)
+		if err != nil || d <= 0 || d > maxAdBlockPause {
+			s.writeJSONError(w, "Invalid duration. Must be a positive duration up to 24h, e.g. \"15m\"", http.StatusBadRequest)
+			return
+		}
+		until, _ := s.dnsServer.PauseAdBlock(d)
+		s.writeJSONSuccess(w, "AdBlock paused", map[string]interface{}{
+			"paused":       true,
+			"paused_until": until.Format(time.RFC3339),
+		})
+
+	case http.MethodDelete:
+		if !s.dnsServer.ResumeAdBlock() {
+			s.writeJSONSuccess(w, "AdBlock is not paused", map[string]interface{}{"paused": false})
+			return
+		}
+		s.writeJSONSuccess(w, "AdBlock resumed", map[string]interface{}{"paused": false})
+
+	default:
+		s.writeJSONError(w, "Invalid request method", http.StatusMethodNotAllowed)
+	}
+}
+
 // handleAdBlockTest 处理广告拦截测试请求
 func (s *Server) handleAdBlockTest(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
@@ -406,3 +455,68 @@ func (s *Server) handlePostAdBlockSettings(w http.ResponseWriter, r *http.Reques
 	logger.Debug("[AdBlock] Settings updated via API")
 	s.writeJSONSuccess(w, "AdBlock settings updated successfully", nil)
 }
+
+// handleAdBlockUnblockRequests 处理拦截页提交的解除拦截申请
+// GET 返回申请列表（最新的在前）；DELETE 清空列表
+func (s *Server) handleAdBlockUnblockRequests(w http.ResponseWriter, r *http.Request) {
+	bp := s.dnsServer.GetBlockPage()
+	if bp == nil {
+		s.writeJSONError(w, "Block page is disabled", http.StatusServiceUnavailable)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		s.writeJSONSuccess(w, "Unblock requests retrieved successfully", map[string]interface{}{
+			"requests": bp.UnblockRequests(),
+		})
+
+	case http.MethodDelete:
+		bp.ClearUnblockRequests()
+		s.writeJSONSuccess(w, "Unblock requests cleared", nil)
+
+	default:
+		s.writeJSONError(w, "Invalid request method", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleAdBlockDGA 处理 DGA 检测的可疑域名
+// GET 返回检测模式与可疑域名列表，带 domain 参数时额外返回该域名的评分明细；DELETE 清空列表
+func (s *Server) handleAdBlockDGA(w http.ResponseWriter, r *http.Request) {
+	adblockMgr := s.dnsServer.GetAdBlockManager()
+	if adblockMgr == nil {
+		s.writeJSONError(w, "AdBlock is disabled", http.StatusServiceUnavailable)
+		return
+	}
+	dga := adblockMgr.DGA()
+	if dga == nil {
+		s.writeJSONError(w, "DGA detection is disabled", http.StatusServiceUnavailable)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		data := map[string]interface{}{
+			"mode":          dga.Mode(),
+			"threshold":     dga.Threshold(),
+			"learned_names": dga.LearnedNames(),
+			"flagged":       dga.Flagged(),
+		}
+		if domain := r.URL.Query().Get("domain"); domain != "" {
+			score, ok := dga.Score(domain, netip.Addr{})
+			if ok {
+				data["score"] = score
+			} else {
+				data["score"] = nil
+			}
+		}
+		s.writeJSONSuccess(w, "DGA detection status retrieved successfully", data)
+
+	case http.MethodDelete:
+		dga.ClearFlagged()
+		s.writeJSONSuccess(w, "Flagged domains cleared", nil)
+
+	default:
+		s.writeJSONError(w, "Invalid request method", http.StatusMethodNotAllowed)
+	}
+}
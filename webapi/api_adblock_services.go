@@ -0,0 +1,140 @@
+package webapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"smartdnssort/config"
+	"smartdnssort/logger"
+
+	"gopkg.in/yaml.v3"
+)
+
+// handleAdBlockServices 处理服务目录请求
+// GET 返回目录及启用状态；POST 按服务 ID 或分类开关；PUT 替换整个启用列表
+// 当前没有客户端分组，开关对所有客户端生效
+func (s *Server) handleAdBlockServices(w http.ResponseWriter, r *http.Request) {
+	adblockMgr := s.dnsServer.GetAdBlockManager()
+	if adblockMgr == nil {
+		s.writeJSONError(w, "AdBlock is disabled", http.StatusServiceUnavailable)
+		return
+	}
+	catalog := adblockMgr.Catalog()
+
+	switch r.Method {
+	case http.MethodGet:
+		services := adblockMgr.GetServices()
+		var groups []string
+		for _, svc := range services {
+			if svc.Group != "" && !slices.Contains(groups, svc.Group) {
+				groups = append(groups, svc.Group)
+			}
+		}
+		s.writeJSONSuccess(w, "AdBlock services retrieved successfully", map[string]interface{}{
+			"version":  catalog.Version(),
+			"groups":   groups,
+			"services": services,
+		})
+
+	case http.MethodPost:
+		var payload struct {
+			ID      string `json:"id"`
+			Group   string `json:"group"`
+			Blocked bool   `json:"blocked"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			s.writeJSONError(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		var ids []string
+		switch {
+		case payload.ID != "" && payload.Group != "":
+			s.writeJSONError(w, "Specify either id or group, not both", http.StatusBadRequest)
+			return
+		case payload.ID != "":
+			if !catalog.Has(payload.ID) {
+				s.writeJSONError(w, "Unknown service: "+payload.ID, http.StatusBadRequest)
+				return
+			}
+			ids = []string{payload.ID}
+		case payload.Group != "":
+			ids = catalog.GroupIDs(payload.Group)
+			if len(ids) == 0 {
+				s.writeJSONError(w, "Unknown service group: "+payload.Group, http.StatusBadRequest)
+				return
+			}
+		default:
+			s.writeJSONError(w, "id or group is required", http.StatusBadRequest)
+			return
+		}
+
+		s.updateBlockedServices(w, func(current []string) []string {
+			current = slices.DeleteFunc(current, func(id string) bool { return slices.Contains(ids, id) })
+			if payload.Blocked {
+				current = append(current, ids...)
+			}
+			return current
+		})
+
+	case http.MethodPut:
+		var payload struct {
+			Services []string `json:"services"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			s.writeJSONError(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		for _, id := range payload.Services {
+			if !catalog.Has(id) {
+				s.writeJSONError(w, "Unknown service: "+id, http.StatusBadRequest)
+				return
+			}
+		}
+		s.updateBlockedServices(w, func([]string) []string {
+			return slices.Compact(slices.Sorted(slices.Values(payload.Services)))
+		})
+
+	default:
+		s.writeJSONError(w, "Invalid request method", http.StatusMethodNotAllowed)
+	}
+}
+
+// updateBlockedServices 修改配置文件中的启用服务列表并应用到运行中的服务
+func (s *Server) updateBlockedServices(w http.ResponseWriter, update func([]string) []string) {
+	// 加锁保护配置文件操作
+	s.cfgMutex.Lock()
+	defer s.cfgMutex.Unlock()
+
+	cfg, err := config.LoadConfig(s.configPath)
+	if err != nil {
+		logger.Errorf("[AdBlock] Failed to load config for services update: %v", err)
+		s.writeJSONError(w, "Failed to load config: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	cfg.AdBlock.BlockedServices = update(slices.Clone(cfg.AdBlock.BlockedServices))
+
+	yamlData, err := yaml.Marshal(cfg)
+	if err != nil {
+		logger.Errorf("[AdBlock] Failed to marshal config for services update: %v", err)
+		s.writeJSONError(w, "Failed to marshal config: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if err := s.writeConfigFile(yamlData); err != nil {
+		logger.Errorf("[AdBlock] Failed to write config file for services update: %v", err)
+		s.writeJSONError(w, "Failed to write config file: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if err := s.dnsServer.ApplyConfig(cfg); err != nil {
+		logger.Errorf("[AdBlock] Failed to apply config for services update: %v", err)
+		s.writeJSONError(w, "Failed to apply new configuration: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	logger.Debugf("[AdBlock] Blocked services updated: %v", cfg.AdBlock.BlockedServices)
+	s.writeJSONSuccess(w, "Blocked services updated successfully", map[string]interface{}{
+		"blocked_services": cfg.AdBlock.BlockedServices,
+	})
+}
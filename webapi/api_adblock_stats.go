@@ -0,0 +1,58 @@
+package webapi
+
+import (
+	"net/http"
+	"net/url"
+	"smartdnssort/adblock"
+	"strconv"
+	"strings"
+)
+
+const (
+	sourceStatsPrefix      = "/api/adblock/sources/"
+	defaultSourceStatsDays = 7
+	defaultSourceStatsTop  = 20
+)
+
+// handleAdBlockSourceStats 处理 /api/adblock/sources/{url}/stats 请求
+// {url} 为经过 URL 编码的规则源地址（与 /api/adblock/sources 返回的 url 一致）
+func (s *Server) handleAdBlockSourceStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		s.writeJSONError(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	adblockMgr := s.dnsServer.GetAdBlockManager()
+	if adblockMgr == nil {
+		s.writeJSONError(w, "AdBlock is disabled", http.StatusServiceUnavailable)
+		return
+	}
+
+	// 使用转义后的路径，规则源地址中编码的 "/" 不会被当作路径分隔符
+	escaped, ok := strings.CutSuffix(strings.TrimPrefix(r.URL.EscapedPath(), sourceStatsPrefix), "/stats")
+	if !ok || escaped == "" {
+		s.writeJSONError(w, "Not found", http.StatusNotFound)
+		return
+	}
+	sourceURL, err := url.PathUnescape(escaped)
+	if err != nil {
+		s.writeJSONError(w, "Invalid source URL", http.StatusBadRequest)
+		return
+	}
+
+	days := defaultSourceStatsDays
+	if d, err := strconv.Atoi(r.URL.Query().Get("days")); err == nil && d > 0 && d <= adblock.RuleStatsDays {
+		days = d
+	}
+	top := defaultSourceStatsTop
+	if n, err := strconv.Atoi(r.URL.Query().Get("top")); err == nil && n > 0 && n <= 1000 {
+		top = n
+	}
+
+	stats, found := adblockMgr.GetSourceStats(sourceURL, days, top)
+	if !found {
+		s.writeJSONError(w, "Source not found or not indexed yet", http.StatusNotFound)
+		return
+	}
+	s.writeJSONSuccess(w, "Source stats retrieved successfully", stats)
+}
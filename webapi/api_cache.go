@@ -0,0 +1,138 @@
+package webapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"smartdnssort/cache"
+	"smartdnssort/logger"
+	"strconv"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	defaultCacheEntriesLimit = 100
+	maxCacheEntriesLimit     = 5000
+)
+
+// CacheEntriesResult /api/cache/entries 的响应数据
+type CacheEntriesResult struct {
+	Total   int                    `json:"total"` // 满足条件的条目总数（截断前）
+	Entries []cache.CacheEntryInfo `json:"entries"`
+}
+
+// CachePurgeRequest /api/cache/purge 的请求体
+type CachePurgeRequest struct {
+	Domain string `json:"domain"` // 精确域名或 *.example.com
+	Type   string `json:"type"`   // 查询类型（A、AAAA、HTTPS 或数字），为空表示所有类型
+}
+
+// parseQtypeParam 解析查询类型名称或数字，空字符串返回 0（所有类型）
+func parseQtypeParam(s string) (uint16, error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	if s == "" || s == "ANY" || s == "ALL" {
+		return 0, nil
+	}
+	if qtype, ok := dns.StringToType[s]; ok {
+		return qtype, nil
+	}
+	if n, err := strconv.ParseUint(strings.TrimPrefix(s, "TYPE"), 10, 16); err == nil {
+		return uint16(n), nil
+	}
+	return 0, fmt.Errorf("invalid query type '%s'", s)
+}
+
+// validateCacheNamePattern 校验精确域名或 *.suffix 通配符
+func validateCacheNamePattern(name string) error {
+	rest := strings.TrimPrefix(name, "*.")
+	if rest == "" || strings.Contains(rest, "*") {
+		return fmt.Errorf("invalid domain pattern '%s' (use example.com or *.example.com)", name)
+	}
+	return nil
+}
+
+// handleCacheEntries 列出/检索缓存条目
+// GET /api/cache/entries?domain=*.example.com&q=cdn&type=A&limit=100
+func (s *Server) handleCacheEntries(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		s.writeJSONError(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	filter := cache.EntryFilter{
+		Name:   strings.TrimSpace(query.Get("domain")),
+		Search: strings.TrimSpace(query.Get("q")),
+	}
+	if filter.Name != "" {
+		if err := validateCacheNamePattern(filter.Name); err != nil {
+			s.writeJSONError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	qtype, err := parseQtypeParam(query.Get("type"))
+	if err != nil {
+		s.writeJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	filter.Qtype = qtype
+
+	limit := defaultCacheEntriesLimit
+	if v := query.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			s.writeJSONError(w, "Invalid limit parameter", http.StatusBadRequest)
+			return
+		}
+		limit = min(n, maxCacheEntriesLimit)
+	}
+
+	entries, total := s.dnsCache.ListEntries(filter, limit)
+	s.writeJSONSuccess(w, "Cache entries retrieved", CacheEntriesResult{
+		Total:   total,
+		Entries: entries,
+	})
+}
+
+// handleCachePurge 按域名、*.suffix 通配符或查询类型定向清除缓存
+// 至少需要指定域名或类型之一，清空全部缓存请使用 /api/cache/clear
+func (s *Server) handleCachePurge(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		s.writeJSONError(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req CachePurgeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		s.writeJSONError(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	filter := cache.EntryFilter{Name: strings.TrimSpace(req.Domain)}
+	if filter.Name != "" {
+		if err := validateCacheNamePattern(filter.Name); err != nil {
+			s.writeJSONError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	qtype, err := parseQtypeParam(req.Type)
+	if err != nil {
+		s.writeJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	filter.Qtype = qtype
+
+	if filter.Name == "" && filter.Qtype == 0 {
+		s.writeJSONError(w, "Domain or type is required (use /api/cache/clear to flush everything)", http.StatusBadRequest)
+		return
+	}
+
+	result := s.dnsCache.Purge(filter)
+	logger.Infof("[CachePurge] domain=%q type=%q removed %d entries (%+v)", filter.Name, req.Type, result.Total(), result)
+	s.writeJSONSuccess(w, fmt.Sprintf("Purged %d cache entries", result.Total()), map[string]any{
+		"total":  result.Total(),
+		"layers": result,
+	})
+}
@@ -6,9 +6,12 @@ import (
 	"io"
 	"net"
 	"net/http"
+	"net/netip"
 	"os"
 	"path/filepath"
 	"regexp"
+	"smartdnssort/adblock"
+	"smartdnssort/cache"
 	"smartdnssort/config"
 	"smartdnssort/logger"
 	"sort"
@@ -54,6 +57,7 @@ func (s *Server) handleConfig(w http.ResponseWriter, r *http.Request) {
 // handleExportConfig 导出当前配置
 func (s *Server) handleExportConfig(w http.ResponseWriter, _ *http.Request) {
 	currentConfig := s.dnsServer.GetConfig()
+	maskConfigSecrets(currentConfig)
 	w.Header().Set("Content-Type", "application/json")
 	w.Header().Set("Content-Disposition", "attachment; filename=smartdnssort-config.json")
 	if err := json.NewEncoder(w).Encode(currentConfig); err != nil {
@@ -66,9 +70,40 @@ func (s *Server) handleExportConfig(w http.ResponseWriter, _ *http.Request) {
 // handleGetConfig 获取当前配置
 func (s *Server) handleGetConfig(w http.ResponseWriter, _ *http.Request) {
 	currentConfig := s.dnsServer.GetConfig()
+	maskConfigSecrets(currentConfig)
 	s.writeJSONSuccess(w, "Configuration retrieved successfully", currentConfig)
 }
 
+// maskedSecret 返回给前端的敏感字段占位值，POST 回传该值时保留已保存的配置
+const maskedSecret = "********"
+
+// configSecrets 返回配置中不能明文返回的字段
+func configSecrets(cfg *config.Config) []*string {
+	return []*string{
+		&cfg.Replication.SharedKey,
+		&cfg.Cache.Backend.Password,
+	}
+}
+
+// maskConfigSecrets 将非空的敏感字段替换为占位值（cfg 必须是副本）
+func maskConfigSecrets(cfg *config.Config) {
+	for _, secret := range configSecrets(cfg) {
+		if *secret != "" {
+			*secret = maskedSecret
+		}
+	}
+}
+
+// restoreConfigSecrets 将原样回传的占位值恢复为 saved 中已保存的值
+func restoreConfigSecrets(cfg, saved *config.Config) {
+	savedSecrets := configSecrets(saved)
+	for i, secret := range configSecrets(cfg) {
+		if *secret == maskedSecret {
+			*secret = *savedSecrets[i]
+		}
+	}
+}
+
 // handlePostConfig 处理配置更新请求
 func (s *Server) handlePostConfig(w http.ResponseWriter, r *http.Request) {
 	// 获取写锁，保护配置文件更新
@@ -100,11 +135,14 @@ func (s *Server) handlePostConfig(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// 2. 将新配置（JSON 格式）解析并覆盖到基准配置上
+	// GET 返回的是脱敏后的密钥，原样回传时保留已保存的值
+	savedCfg := *newCfg
 	if err := json.Unmarshal(bodyBytes, newCfg); err != nil {
 		logger.Errorf("JSON Unmarshal failed: %v", err)
 		s.writeJSONError(w, "Failed to parse config JSON: "+err.Error(), http.StatusBadRequest)
 		return
 	}
+	restoreConfigSecrets(newCfg, &savedCfg)
 
 	// 3. 验证合并后的配置
 	if err := s.validateConfig(newCfg); err != nil {
@@ -220,6 +258,28 @@ func (s *Server) validateConfig(cfg *config.Config) error {
 		logger.Errorf("Validation failed: invalid DNS listen port %d", cfg.DNS.ListenPort)
 		return fmt.Errorf("invalid DNS listen port: %d", cfg.DNS.ListenPort)
 	}
+	for i, rule := range cfg.DNS.SVCB.Rules {
+		if strings.Trim(strings.TrimSpace(rule.Domain), ".") == "" {
+			logger.Errorf("Validation failed: svcb rule %d has no domain", i)
+			return fmt.Errorf("svcb rule %d: domain is required", i)
+		}
+	}
+	switch cfg.DNS.SafeSearch.YouTubeMode {
+	case "", "strict", "moderate":
+	default:
+		logger.Errorf("Validation failed: invalid safe_search youtube_mode %q", cfg.DNS.SafeSearch.YouTubeMode)
+		return fmt.Errorf("invalid safe_search youtube_mode: %q (must be strict or moderate)", cfg.DNS.SafeSearch.YouTubeMode)
+	}
+	for _, client := range cfg.DNS.SafeSearch.Clients {
+		client = strings.TrimSpace(client)
+		if _, err := netip.ParsePrefix(client); err == nil {
+			continue
+		}
+		if _, err := netip.ParseAddr(client); err != nil {
+			logger.Errorf("Validation failed: invalid safe_search client %q", client)
+			return fmt.Errorf("invalid safe_search client: %q (must be an IP or CIDR)", client)
+		}
+	}
 
 	// Sanitize Upstream Servers (remove quotes and spaces)
 	for i, server := range cfg.Upstream.Servers {
@@ -323,6 +383,18 @@ func (s *Server) validateConfig(cfg *config.Config) error {
 		logger.Error("Validation failed: cache error TTL cannot be negative")
 		return fmt.Errorf("cache error TTL cannot be negative")
 	}
+	if err := cache.ValidateTTLRules(cfg.Cache.TTLRules); err != nil {
+		logger.Errorf("Validation failed: invalid cache ttl_rules: %v", err)
+		return fmt.Errorf("invalid cache ttl_rules: %v", err)
+	}
+	if cfg.Cache.WALSyncIntervalMs < 0 || cfg.Cache.WALCompactMB < 0 || cfg.Cache.LoadTimeoutSeconds < 0 {
+		logger.Error("Validation failed: cache WAL settings cannot be negative")
+		return fmt.Errorf("cache WAL sync interval, compact size and load timeout cannot be negative")
+	}
+	if cfg.Cache.NSECCacheMaxEntries < 0 {
+		logger.Error("Validation failed: cache NSEC max entries cannot be negative")
+		return fmt.Errorf("cache nsec_cache_max_entries cannot be negative")
+	}
 	if cfg.Ping.Count <= 0 {
 		logger.Errorf("Validation failed: ping count must be positive, got %d", cfg.Ping.Count)
 		return fmt.Errorf("ping count must be positive")
@@ -400,6 +472,22 @@ func (s *Server) validateConfig(cfg *config.Config) error {
 			return fmt.Errorf("invalid adblock block mode: %s (must be one of: nxdomain, zero_ip, refused, custom_ip)", cfg.AdBlock.BlockMode)
 		}
 	}
+	if err := adblock.ValidateRPZZones(cfg.AdBlock.RPZ); err != nil {
+		logger.Errorf("Validation failed: %v", err)
+		return fmt.Errorf("invalid adblock rpz config: %w", err)
+	}
+	if err := adblock.ValidateSchedules(&cfg.AdBlock); err != nil {
+		logger.Errorf("Validation failed: %v", err)
+		return fmt.Errorf("invalid adblock schedules: %w", err)
+	}
+	if err := adblock.ValidateDGA(&cfg.AdBlock.DGA); err != nil {
+		logger.Errorf("Validation failed: %v", err)
+		return fmt.Errorf("invalid adblock dga config: %w", err)
+	}
+	if err := validateBlockPage(&cfg.AdBlock.BlockPage, cfg.WebUI.ListenPort); err != nil {
+		logger.Errorf("Validation failed: %v", err)
+		return fmt.Errorf("invalid adblock block page config: %w", err)
+	}
 
 	// 验证端口冲突：DNS 和 WebUI 不能使用相同端口
 	if cfg.DNS.ListenPort == cfg.WebUI.ListenPort {
@@ -419,6 +507,43 @@ func (s *Server) validateConfig(cfg *config.Config) error {
 		return fmt.Errorf("ping timeout should not exceed 30 seconds (30000ms)")
 	}
 
+	// 验证外部缓存后端配置
+	switch cfg.Cache.Backend.Type {
+	case "", "memory":
+	case "redis":
+		if _, _, err := net.SplitHostPort(cfg.Cache.Backend.Addr); err != nil {
+			logger.Errorf("Validation failed: invalid cache backend address: %v", err)
+			return fmt.Errorf("invalid cache backend address: %v", err)
+		}
+	default:
+		logger.Errorf("Validation failed: invalid cache backend type %s", cfg.Cache.Backend.Type)
+		return fmt.Errorf("invalid cache backend type: %s (must be 'memory' or 'redis')", cfg.Cache.Backend.Type)
+	}
+
+	// 验证缓存复制配置
+	if cfg.Replication.Enabled {
+		if cfg.Replication.SharedKey == "" {
+			logger.Error("Validation failed: replication shared_key is required when replication is enabled")
+			return fmt.Errorf("replication shared_key is required when replication is enabled")
+		}
+		for i, peer := range cfg.Replication.Peers {
+			if _, _, err := net.SplitHostPort(peer); err != nil {
+				logger.Errorf("Validation failed: invalid replication peer at index %d: %v", i, err)
+				return fmt.Errorf("invalid replication peer at index %d: %v", i, err)
+			}
+		}
+	}
+	if cfg.Replication.QueueSize < 0 || cfg.Replication.BatchSize < 0 || cfg.Replication.FlushIntervalMs < 0 {
+		logger.Error("Validation failed: replication queue_size, batch_size and flush_interval_ms cannot be negative")
+		return fmt.Errorf("replication queue_size, batch_size and flush_interval_ms cannot be negative")
+	}
+
+	// 验证缓存预热配置
+	if cfg.Warmup.MaxDomains < 0 || cfg.Warmup.RatePerSecond < 0 || cfg.Warmup.Concurrency < 0 {
+		logger.Error("Validation failed: warmup max_domains, rate_per_second and concurrency cannot be negative")
+		return fmt.Errorf("warmup max_domains, rate_per_second and concurrency cannot be negative")
+	}
+
 	return nil
 }
 
@@ -581,3 +706,39 @@ func derefOrDefaultVal(ptr *int, defaultValue int) int {
 	}
 	return defaultValue
 }
+
+// validateBlockPage 校验拦截页配置：至少配置一个应答地址，CA 证书与私钥成对出现，监听端口不与 WebUI 冲突
+func validateBlockPage(bp *config.BlockPageConfig, webPort int) error {
+	if !bp.Enable {
+		return nil
+	}
+	if bp.IPv4 == "" && bp.IPv6 == "" {
+		return fmt.Errorf("at least one of ipv4 and ipv6 is required")
+	}
+	if bp.IPv4 != "" {
+		if addr, err := netip.ParseAddr(bp.IPv4); err != nil || !addr.Is4() {
+			return fmt.Errorf("invalid ipv4 address: %s", bp.IPv4)
+		}
+	}
+	if bp.IPv6 != "" {
+		if addr, err := netip.ParseAddr(bp.IPv6); err != nil || !addr.Is6() || addr.Is4In6() {
+			return fmt.Errorf("invalid ipv6 address: %s", bp.IPv6)
+		}
+	}
+	if (bp.CACert == "") != (bp.CAKey == "") {
+		return fmt.Errorf("ca_cert and ca_key must be configured together")
+	}
+	for _, listen := range []string{bp.HTTPListen, bp.HTTPSListen} {
+		if listen == "" {
+			continue
+		}
+		_, port, err := net.SplitHostPort(listen)
+		if err != nil {
+			return fmt.Errorf("invalid listen address %s: %v", listen, err)
+		}
+		if port == strconv.Itoa(webPort) {
+			return fmt.Errorf("listen address %s conflicts with the WebUI port", listen)
+		}
+	}
+	return nil
+}
@@ -156,3 +156,73 @@ func (s *Server) handleCustomResponse(w http.ResponseWriter, r *http.Request) {
 		s.writeJSONError(w, "Invalid request method", http.StatusMethodNotAllowed)
 	}
 }
+
+// handleSortRules 处理手动 IP 排序规则请求
+func (s *Server) handleSortRules(w http.ResponseWriter, r *http.Request) {
+	mgr := s.dnsServer.GetSortRuleManager()
+	if mgr == nil {
+		s.writeJSONError(w, "Sort rule manager not initialized", http.StatusInternalServerError)
+		return
+	}
+	sortRulesFile := mgr.FilePath()
+
+	switch r.Method {
+	case http.MethodGet:
+		s.sortRulesMutex.RLock()
+		defer s.sortRulesMutex.RUnlock()
+
+		content, err := os.ReadFile(sortRulesFile)
+		if err != nil {
+			if os.IsNotExist(err) {
+				s.writeJSONSuccess(w, "Sort rules", map[string]string{"content": ""})
+				return
+			}
+			logger.Errorf("[SortRules] Failed to read sort rules file %s: %v", sortRulesFile, err)
+			s.writeJSONError(w, "Failed to read sort rules file: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		s.writeJSONSuccess(w, "Sort rules retrieved", map[string]string{"content": string(content)})
+
+	case http.MethodPost:
+		s.sortRulesMutex.Lock()
+		defer s.sortRulesMutex.Unlock()
+
+		var payload struct {
+			Content string `json:"content"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			s.writeJSONError(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		if err := mgr.ValidateRules(payload.Content); err != nil {
+			s.writeJSONError(w, "Validation failed: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		dir := filepath.Dir(sortRulesFile)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			logger.Errorf("[SortRules] Failed to create directory %s: %v", dir, err)
+			s.writeJSONError(w, "Failed to create directory: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if err := os.WriteFile(sortRulesFile, []byte(payload.Content), 0644); err != nil {
+			logger.Errorf("[SortRules] Failed to write sort rules file %s: %v", sortRulesFile, err)
+			s.writeJSONError(w, "Failed to write sort rules file: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		// 规则在响应时应用，重新加载后立即对缓存中的记录生效
+		if err := mgr.Load(); err != nil {
+			logger.Errorf("[SortRules] Failed to reload sort rules: %v", err)
+			s.writeJSONError(w, "Saved but failed to reload rules: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		s.writeJSONSuccess(w, "Sort rules saved and reloaded", nil)
+
+	default:
+		s.writeJSONError(w, "Invalid request method", http.StatusMethodNotAllowed)
+	}
+}
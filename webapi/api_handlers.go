@@ -2,6 +2,7 @@ package webapi
 
 import (
 	"encoding/json"
+	"math"
 	"net/http"
 	"smartdnssort/config"
 	"smartdnssort/connectivity"
@@ -71,6 +72,27 @@ func (s *Server) handleQuery(w http.ResponseWriter, r *http.Request) {
 		Status: status,
 	}
 
+	// 应用手动排序规则，展示实际返回给客户端的顺序
+	if mgr := s.dnsServer.GetSortRuleManager(); mgr != nil {
+		ips := make([]string, len(ipsResult))
+		byIP := make(map[string]IPResult, len(ipsResult))
+		for i, r := range ipsResult {
+			ips[i] = r.IP
+			byIP[r.IP] = r
+		}
+		ordered, rules, changed := mgr.Apply(domain, ips)
+		for _, rule := range rules {
+			result.SortRules = append(result.SortRules, rule.String())
+		}
+		if changed {
+			result.ReorderedByRule = true
+			result.IPs = make([]IPResult, 0, len(ordered))
+			for _, ip := range ordered {
+				result.IPs = append(result.IPs, byIP[ip])
+			}
+		}
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	response := APIResponse{
 		Success: true,
@@ -106,38 +128,7 @@ func (s *Server) handleStats(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// 3. 计算缓存内存统计（实时数据，不受时间范围影响）
-	cacheCfg := s.dnsServer.GetConfig().Cache
-	currentEntries := s.dnsCache.GetCurrentEntries()
-	expiredEntries := s.dnsCache.GetExpiredEntries()
-	maxEntries := cacheCfg.CalculateMaxEntries()
-
-	// 计算采样的平均字节数
-	avgBytesPerEntry := s.calculateAvgBytesPerEntry()
-
-	// 计算驱逐率
-	evictionsPerMin := s.calculateEvictionsPerMinute()
-
-	var memoryPercent float64
-	if maxEntries > 0 {
-		memoryPercent = (float64(currentEntries) / float64(maxEntries)) * 100
-	}
-
-	var expiredPercent float64
-	if currentEntries > 0 {
-		expiredPercent = (float64(expiredEntries) / float64(currentEntries)) * 100
-	}
-
-	stats["cache_memory_stats"] = map[string]interface{}{
-		"max_memory_mb":     cacheCfg.MaxMemoryMB,
-		"max_entries":       maxEntries,
-		"current_entries":   currentEntries,
-		"current_memory_mb": int(float64(currentEntries) * float64(avgBytesPerEntry) / (1024 * 1024)),
-		"memory_percent":    memoryPercent,
-		"expired_entries":   expiredEntries,
-		"expired_percent":   expiredPercent,
-		"protected_entries": s.dnsCache.GetProtectedEntries(),
-		"evictions_per_min": evictionsPerMin,
-	}
+	stats["cache_memory_stats"] = s.cacheMemoryStats()
 
 	// 添加网络在线状态
 	stats["network_online"] = connectivity.GetGlobalNetworkChecker().IsNetworkHealthy()
@@ -153,41 +144,38 @@ func (s *Server) handleCacheMemoryStats(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	s.writeJSONSuccess(w, "Cache memory stats retrieved successfully", s.cacheMemoryStats())
+}
+
+// cacheMemoryStats 汇总缓存内存统计，字节数来自各缓存层的增量记账
+func (s *Server) cacheMemoryStats() map[string]interface{} {
 	cacheCfg := s.dnsServer.GetConfig().Cache
 	currentEntries := s.dnsCache.GetCurrentEntries()
-	maxEntries := cacheCfg.CalculateMaxEntries()
 	expiredEntries := s.dnsCache.GetExpiredEntries()
-	protectedEntries := s.dnsCache.GetProtectedEntries()
-
-	// 计算采样的平均字节数
-	avgBytesPerEntry := s.calculateAvgBytesPerEntry()
-
-	// 计算驱逐率
-	evictionsPerMin := s.calculateEvictionsPerMinute()
-
-	var memoryPercent float64
-	if maxEntries > 0 {
-		memoryPercent = (float64(currentEntries) / float64(maxEntries)) * 100
-	}
+	mem := s.dnsCache.GetMemoryStats()
 
 	var expiredPercent float64
 	if currentEntries > 0 {
 		expiredPercent = (float64(expiredEntries) / float64(currentEntries)) * 100
 	}
 
-	stats := map[string]interface{}{
-		"max_memory_mb":     cacheCfg.MaxMemoryMB,
-		"max_entries":       maxEntries,
-		"current_entries":   currentEntries,
-		"current_memory_mb": int(float64(currentEntries) * float64(avgBytesPerEntry) / (1024 * 1024)),
-		"memory_percent":    memoryPercent,
-		"expired_entries":   expiredEntries,
-		"expired_percent":   expiredPercent,
-		"protected_entries": protectedEntries,
-		"evictions_per_min": evictionsPerMin,
+	return map[string]interface{}{
+		"max_memory_mb":      cacheCfg.MaxMemoryMB,
+		"max_entries":        cacheCfg.CalculateMaxEntries(),
+		"current_entries":    currentEntries,
+		"current_memory_mb":  math.Round(float64(mem.UsedBytes)/(1024*1024)*100) / 100,
+		"current_bytes":      mem.UsedBytes,
+		"total_memory_bytes": mem.TotalBytes,
+		"memory_percent":     s.dnsCache.GetMemoryUsagePercent() * 100,
+		"layers":             mem.Layers,
+		"expired_entries":    expiredEntries,
+		"expired_percent":    expiredPercent,
+		"protected_entries":  s.dnsCache.GetProtectedEntries(),
+		"evictions_per_min":  s.calculateEvictionsPerMinute(),
+		"backend":            s.dnsCache.GetBackendStats(),
+		"persistence":        s.dnsCache.GetPersistenceStats(),
+		"nsec":               s.dnsCache.GetNSECStats(),
 	}
-
-	s.writeJSONSuccess(w, "Cache memory stats retrieved successfully", stats)
 }
 
 // handleHealth 处理健康检查请求
@@ -206,7 +194,14 @@ func (s *Server) handleClearCache(w http.ResponseWriter, r *http.Request) {
 	s.dnsCache.Clear()
 	logger.Debug("DNS cache (memory) cleared via API request.")
 
-	// 删除磁盘缓存文件
+	// 清空记录已写入预写日志，立即压缩为空快照，避免重启后旧日志被回放
+	if err := s.dnsCache.Compact(); err != nil {
+		logger.Errorf("Failed to compact cache persistence during API clear request: %v", err)
+		s.writeJSONError(w, "Failed to clear disk cache: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// 删除旧版本遗留的缓存文件
 	cacheFile := "dns_cache.json"
 	if err := s.deleteCacheFile(cacheFile); err != nil {
 		logger.Errorf("Failed to delete cache file during API clear request: %v", err)
@@ -370,6 +365,11 @@ type IPPoolResult struct {
 	AccessHeat int64  `json:"access_heat"`
 	RTT        int    `json:"rtt"`
 	LastAccess string `json:"last_access"`
+	ASN        uint32 `json:"asn,omitempty"`     // 离线 GeoIP 数据，未启用时省略
+	ASOrg      string `json:"as_org,omitempty"`  // 自治系统组织名
+	Country    string `json:"country,omitempty"` // ISO 国家代码
+
+	BandwidthKBps float64 `json:"bandwidth_kbps,omitempty"` // 带宽探测测得的吞吐量，未测量时省略
 }
 
 // IPPoolStatusResponse IP 池状态响应
@@ -380,6 +380,7 @@ type IPPoolStatusResponse struct {
 	LastUpdated   string                 `json:"last_updated"`
 	MonitorStats  map[string]interface{} `json:"monitor_stats"`
 	TopIPs        []IPPoolResult         `json:"top_ips"`
+	ProbeBudget   ping.ProbeBudgetStats  `json:"probe_budget"` // 全局探测预算用量与推迟数
 }
 
 // handleIPPoolStatus 处理 IP 池状态请求
@@ -390,7 +391,8 @@ func (s *Server) handleIPPoolStatus(w http.ResponseWriter, r *http.Request) {
 	}
 
 	response := IPPoolStatusResponse{
-		TopIPs: []IPPoolResult{},
+		TopIPs:      []IPPoolResult{},
+		ProbeBudget: s.dnsServer.GetProbeBudget().GetStats(),
 		// 初始化默认值，确保即使 ipMonitor 为 nil 也能返回有效数据
 		MonitorStats: map[string]interface{}{
 			"total_refreshes":     int64(0),
@@ -413,17 +415,18 @@ func (s *Server) handleIPPoolStatus(w http.ResponseWriter, r *http.Request) {
 		// 获取 IPMonitor 统计信息
 		stats := ipMonitor.GetStats()
 		response.MonitorStats = map[string]interface{}{
-			"total_refreshes":     stats.TotalRefreshes,
-			"total_planned_pings": stats.TotalPlannedPings,
-			"total_actual_pings":  stats.TotalActualPings,
-			"total_skipped_pings": stats.TotalSkippedPings,
-			"last_refresh_time":   stats.LastRefreshTime,
-			"t0_pool_size":        stats.T0PoolSize,
-			"t1_pool_size":        stats.T1PoolSize,
-			"t2_pool_size":        stats.T2PoolSize,
-			"downgraded_ips":      stats.DowngradedIPs,
-			"hourly_quota_used":   stats.HourlyQuotaUsed,
-			"hourly_quota_limit":  stats.HourlyQuotaLimit,
+			"total_refreshes":      stats.TotalRefreshes,
+			"total_planned_pings":  stats.TotalPlannedPings,
+			"total_actual_pings":   stats.TotalActualPings,
+			"total_skipped_pings":  stats.TotalSkippedPings,
+			"total_deferred_pings": stats.TotalDeferredPings,
+			"last_refresh_time":    stats.LastRefreshTime,
+			"t0_pool_size":         stats.T0PoolSize,
+			"t1_pool_size":         stats.T1PoolSize,
+			"t2_pool_size":         stats.T2PoolSize,
+			"downgraded_ips":       stats.DowngradedIPs,
+			"hourly_quota_used":    stats.HourlyQuotaUsed,
+			"hourly_quota_limit":   stats.HourlyQuotaLimit,
 		}
 	}
 
@@ -449,6 +452,8 @@ func (s *Server) handleIPPoolTop(w http.ResponseWriter, r *http.Request) {
 		"top_ips":         []IPPoolResult{},
 		"monitor_stats":   make(map[string]interface{}),
 		"monitor_enabled": false,
+		"probe_budget":    s.dnsServer.GetProbeBudget().GetStats(),
+		"bandwidth":       s.dnsServer.GetBandwidthProber().GetStats(),
 	}
 
 	// 获取 IP 池信息
@@ -456,17 +461,18 @@ func (s *Server) handleIPPoolTop(w http.ResponseWriter, r *http.Request) {
 	if ipMonitor != nil {
 		stats := ipMonitor.GetStats()
 		response["monitor_stats"] = map[string]interface{}{
-			"total_refreshes":     stats.TotalRefreshes,
-			"total_planned_pings": stats.TotalPlannedPings,
-			"total_actual_pings":  stats.TotalActualPings,
-			"total_skipped_pings": stats.TotalSkippedPings,
-			"last_refresh_time":   stats.LastRefreshTime.Format(time.RFC3339),
-			"t0_pool_size":        stats.T0PoolSize,
-			"t1_pool_size":        stats.T1PoolSize,
-			"t2_pool_size":        stats.T2PoolSize,
-			"downgraded_ips":      stats.DowngradedIPs,
-			"hourly_quota_used":   stats.HourlyQuotaUsed,
-			"hourly_quota_limit":  stats.HourlyQuotaLimit,
+			"total_refreshes":      stats.TotalRefreshes,
+			"total_planned_pings":  stats.TotalPlannedPings,
+			"total_actual_pings":   stats.TotalActualPings,
+			"total_skipped_pings":  stats.TotalSkippedPings,
+			"total_deferred_pings": stats.TotalDeferredPings,
+			"last_refresh_time":    stats.LastRefreshTime.Format(time.RFC3339),
+			"t0_pool_size":         stats.T0PoolSize,
+			"t1_pool_size":         stats.T1PoolSize,
+			"t2_pool_size":         stats.T2PoolSize,
+			"downgraded_ips":       stats.DowngradedIPs,
+			"hourly_quota_used":    stats.HourlyQuotaUsed,
+			"hourly_quota_limit":   stats.HourlyQuotaLimit,
 		}
 		// 获取配置中的 Enabled 状态 (需要加锁或者通过方法获取)
 		// 这里暂且从 dnsServer 配置中读，更准确
@@ -483,6 +489,7 @@ func (s *Server) handleIPPoolTop(w http.ResponseWriter, r *http.Request) {
 			// 获取所有 IP
 			allIPs := pool.GetAllIPs()
 			pinger := ipMonitor.GetPinger()
+			geoRanker := s.dnsServer.GetGeoIPRanker()
 			topIPs := []IPPoolResult{}
 
 			for _, info := range allIPs {
@@ -507,6 +514,12 @@ func (s *Server) handleIPPoolTop(w http.ResponseWriter, r *http.Request) {
 						AccessHeat: info.AccessHeat,
 						RTT:        rtt,
 						LastAccess: info.LastAccess.Format(time.RFC3339),
+
+						BandwidthKBps: info.Bandwidth,
+					}
+					if geoRanker != nil {
+						geo := geoRanker.Lookup(info.IP)
+						result.ASN, result.ASOrg, result.Country = geo.ASN, geo.ASOrg, geo.Country
 					}
 					topIPs = append(topIPs, result)
 				}
@@ -0,0 +1,19 @@
+package webapi
+
+import (
+	"net/http"
+)
+
+// handleReplicationStatus 返回多实例缓存复制的连接与同步统计
+// 未启用复制时返回 enabled=false
+func (s *Server) handleReplicationStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		s.writeJSONError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.dnsServer == nil {
+		s.writeJSONError(w, "DNS server not available", http.StatusServiceUnavailable)
+		return
+	}
+	s.writeJSONSuccess(w, "Replication status retrieved", s.dnsServer.GetReplicator().GetStats())
+}
@@ -0,0 +1,18 @@
+package webapi
+
+import (
+	"net/http"
+)
+
+// handleWarmupStatus 返回启动缓存预热的进度
+func (s *Server) handleWarmupStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		s.writeJSONError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.dnsServer == nil {
+		s.writeJSONError(w, "DNS server not available", http.StatusServiceUnavailable)
+		return
+	}
+	s.writeJSONSuccess(w, "Warmup status retrieved", s.dnsServer.GetWarmupStatus())
+}